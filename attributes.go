@@ -0,0 +1,73 @@
+package dstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// objectAttributesBatchConcurrency is the number of attributes requests in
+// flight in `ObjectAttributesBatch`.
+const objectAttributesBatchConcurrency = 32
+
+// ObjectAttributesBatch fetches the attributes of the objects `names`
+// concurrently, returning them keyed by name. Missing objects are left out of
+// the result, other errors stopping the whole batch.
+//
+// None of the SDKs of the backends expose a batch of object metadata
+// requests, so each object costs an `ObjectAttributes` request, up to 32 of
+// them being in flight.
+func ObjectAttributesBatch(ctx context.Context, store Store, names []string) (map[string]*ObjectAttrs, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var errOnce sync.Once
+	var firstErr error
+	out := make(map[string]*ObjectAttrs, len(names))
+
+	work := make(chan string)
+	for i := 0; i < objectAttributesBatchConcurrency && i < len(names); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				attrs, err := store.ObjectAttributes(ctx, name)
+				if err == ErrNotFound {
+					continue
+				}
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("attributes %q: %w", name, err)
+						cancel()
+					})
+					continue
+				}
+
+				lock.Lock()
+				out[name] = attrs
+				lock.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, name := range names {
+		select {
+		case work <- name:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,38 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectAttributesBatch(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	var names []string
+	for _, name := range []string{"a", "bb", "ccc"} {
+		require.NoError(t, store.WriteObject(ctx, name, strings.NewReader(name)))
+		names = append(names, name)
+	}
+
+	attrs, err := ObjectAttributesBatch(ctx, store, append(names, "missing"))
+	require.NoError(t, err)
+	require.Len(t, attrs, 3)
+	for _, name := range names {
+		assert.Equal(t, name, attrs[name].Name)
+		assert.Equal(t, int64(len(name)), attrs[name].Size)
+	}
+
+	attrs, err = ObjectAttributesBatch(ctx, store, nil)
+	require.NoError(t, err)
+	assert.Empty(t, attrs)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = ObjectAttributesBatch(canceled, store, names)
+	assert.True(t, errors.Is(err, context.Canceled), "expected context.Canceled, got %v", err)
+}
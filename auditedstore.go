@@ -0,0 +1,189 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+//
+// Audited Store
+//
+
+// AuditRecord records a mutation of an `AuditedStore`. It marshals to JSON.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Actor is who performed the mutation, set on the context of the
+	// operation with `WithAuditActor`.
+	Actor string `json:"actor,omitempty"`
+	// Operation is the name of the `Store` method called.
+	Operation string `json:"operation"`
+	// Store is the URL of the mutated store.
+	Store string `json:"store"`
+	// Name is the name of the object written or deleted, or the source of
+	// copies and renames.
+	Name string `json:"name"`
+	// Destination is the destination of copies and renames.
+	Destination string `json:"destination,omitempty"`
+	// Size and SHA256 are the size and hex encoded checksum of the content
+	// written, before compression.
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Error is the error of the mutation when it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// AuditSink receives the records of an `AuditedStore`, an error failing the
+// audited operation.
+type AuditSink func(ctx context.Context, record *AuditRecord) error
+
+type auditActorKey struct{}
+
+// WithAuditActor returns a context recording `actor` as the author of the
+// mutations of `AuditedStore` performed with it.
+func WithAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// AuditedStore is a `Store` recording each mutation of the inner store, its
+// author, time, object, size and checksum, to an append-only `AuditSink`, for
+// compliance on sensitive buckets. Failed mutations are recorded too, with
+// their error.
+//
+// The record is sent once the mutation returned, and an error of the sink
+// fails the operation, so that callers never miss an unaudited mutation.
+// Prefix deletions are recorded object per object.
+type AuditedStore struct {
+	// Store is the inner store, whose mutations are audited.
+	Store
+
+	sink AuditSink
+}
+
+func NewAuditedStore(inner Store, sink AuditSink) *AuditedStore {
+	return &AuditedStore{
+		Store: inner,
+		sink:  sink,
+	}
+}
+
+// AuditLog returns an `AuditSink` writing each record as a JSON object of its
+// own under `prefix` in `store`, named by its time so that the log lists in
+// order, and never overwriting one.
+func AuditLog(store Store, prefix string) AuditSink {
+	return func(ctx context.Context, record *AuditRecord) error {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("marshal audit record: %w", err)
+		}
+
+		name := fmt.Sprintf("%s%s-%08x.json", prefix, record.Time.UTC().Format("20060102T150405.000000000Z"), rand.Uint32())
+		return store.WriteObject(ctx, name, bytes.NewReader(data))
+	}
+}
+
+func (s *AuditedStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := *s
+	sub.Store = inner
+	return &sub, nil
+}
+
+// audit sends the record of `operation` on the object `name` to the sink,
+// returning the error of the operation or else of the sink.
+func (s *AuditedStore) audit(ctx context.Context, operation, name, destination string, digest *Digest, err error) error {
+	record := &AuditRecord{
+		Time:        time.Now(),
+		Operation:   operation,
+		Store:       s.Store.BaseURL().String(),
+		Name:        name,
+		Destination: destination,
+	}
+	if actor, ok := ctx.Value(auditActorKey{}).(string); ok {
+		record.Actor = actor
+	}
+	if digest != nil {
+		record.Size, record.SHA256 = digest.Size, digest.SHA256
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	if sinkErr := s.sink(ctx, record); sinkErr != nil && err == nil {
+		return fmt.Errorf("audit %s of %q: %w", operation, name, sinkErr)
+	}
+	return err
+}
+
+func (s *AuditedStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	digest, err := WriteObjectDigest(ctx, s.Store, base, f, opts...)
+	return s.audit(ctx, "WriteObject", base, "", digest, err)
+}
+
+func (s *AuditedStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	digest, err := fileDigest(localFile)
+	if err != nil {
+		return err
+	}
+
+	err = s.Store.PushLocalFile(ctx, localFile, toBaseName)
+	return s.audit(ctx, "PushLocalFile", toBaseName, "", digest, err)
+}
+
+// fileDigest returns the size and SHA-256 of the local file `path`.
+func fileDigest(path string) (*Digest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open local file: %w", err)
+	}
+	defer file.Close()
+
+	sha := sha256.New()
+	size, err := io.Copy(sha, file)
+	if err != nil {
+		return nil, fmt.Errorf("read local file: %w", err)
+	}
+	return &Digest{Size: size, SHA256: hex.EncodeToString(sha.Sum(nil))}, nil
+}
+
+func (s *AuditedStore) CopyObject(ctx context.Context, src, dst string) error {
+	err := s.Store.CopyObject(ctx, src, dst)
+	return s.audit(ctx, "CopyObject", src, dst, nil, err)
+}
+
+func (s *AuditedStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	err := s.Store.RenameObject(ctx, oldName, newName)
+	return s.audit(ctx, "RenameObject", oldName, newName, nil, err)
+}
+
+func (s *AuditedStore) DeleteObject(ctx context.Context, base string) error {
+	err := s.Store.DeleteObject(ctx, base)
+	return s.audit(ctx, "DeleteObject", base, "", nil, err)
+}
+
+func (s *AuditedStore) DeleteObjects(ctx context.Context, names []string) error {
+	err := s.Store.DeleteObjects(ctx, names)
+	for _, name := range names {
+		if auditErr := s.audit(ctx, "DeleteObjects", name, "", nil, err); auditErr != err {
+			return auditErr
+		}
+	}
+	return err
+}
+
+// DeletePrefix walks the prefix and deletes its objects in batches through
+// `DeleteObjects`, so that each deleted object is recorded.
+func (s *AuditedStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	return deletePrefix(ctx, s, prefix, deletePrefixConcurrency)
+}
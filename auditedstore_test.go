@@ -0,0 +1,53 @@
+package dstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditedStore(t *testing.T) {
+	ctx := WithAuditActor(context.Background(), "gc-job")
+	auditLog := NewMemoryStore()
+	store := NewAuditedStore(NewMemoryStore(), AuditLog(auditLog, "audit/"))
+
+	require.NoError(t, store.WriteObject(ctx, "dir/a", strings.NewReader("content")))
+	require.NoError(t, store.WriteObject(ctx, "dir/b", strings.NewReader("more")))
+	require.NoError(t, store.CopyObject(ctx, "dir/a", "c"))
+	deleted, err := store.DeletePrefix(ctx, "dir/")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	var records []*AuditRecord
+	require.NoError(t, auditLog.WalkObjects(ctx, "audit/", func(attrs *ObjectAttrs) error {
+		data, err := ReadObject(ctx, auditLog, attrs.Name)
+		if err != nil {
+			return err
+		}
+		record := &AuditRecord{}
+		records = append(records, record)
+		return json.Unmarshal(data, record)
+	}))
+
+	require.Len(t, records, 5)
+	assert.Equal(t, "WriteObject", records[0].Operation)
+	assert.Equal(t, "gc-job", records[0].Actor)
+	assert.Equal(t, "dir/a", records[0].Name)
+	assert.Equal(t, int64(7), records[0].Size)
+	assert.Equal(t, "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73", records[0].SHA256)
+	assert.Equal(t, "memory:///", records[0].Store)
+	assert.Equal(t, "CopyObject", records[2].Operation)
+	assert.Equal(t, "c", records[2].Destination)
+	assert.Equal(t, "DeleteObjects", records[3].Operation)
+	assert.Equal(t, "DeleteObjects", records[4].Operation)
+	assert.ElementsMatch(t, []string{"dir/a", "dir/b"}, []string{records[3].Name, records[4].Name})
+
+	errSink := errors.New("sink down")
+	failing := NewAuditedStore(NewMemoryStore(), func(ctx context.Context, record *AuditRecord) error { return errSink })
+	assert.True(t, errors.Is(failing.WriteObject(ctx, "a", strings.NewReader("content")), errSink))
+}
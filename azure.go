@@ -1,9 +1,13 @@
 package dstore
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -11,58 +15,184 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-blob-go/azblob"
+	"go.uber.org/zap"
 )
 
 type AzureStore struct {
 	*commonStore
 
-	baseURL      *url.URL
-	containerURL azblob.ContainerURL
+	baseURL       *url.URL
+	containerName string
+	containerURL  azblob.ContainerURL
+	// path is the blob name prefix of the store within the container
+	path string
+	// credential is only set with shared key authentication, which is the
+	// only one able to presign URLs
+	credential *azblob.SharedKeyCredential
 }
 
 func NewAzureStore(baseURL *url.URL, extension, compressionType string, overwrite bool) (*AzureStore, error) {
-	accountName, containerName, err := decodeAzureScheme(baseURL)
+	return NewAzureStoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+// NewAzureStoreWithOptions creates a store for an `az://account.container/path`
+// or `az://account/container/path` URL. It authenticates with the shared key
+// of the `AZURE_STORAGE_KEY` environment variable, or the SAS token of the
+// `AZURE_STORAGE_SAS_TOKEN` one, or with the managed identity of the host
+// when the URL has the `auth=managed_identity` query parameter, the
+// `AZURE_CLIENT_ID` environment variable selecting a user assigned identity.
+func NewAzureStoreWithOptions(baseURL *url.URL, opts ...Option) (*AzureStore, error) {
+	config := newCloudConfig(opts)
+
+	accountName, containerName, blobPath, err := decodeAzureScheme(baseURL)
 	if err != nil {
-		return nil, fmt.Errorf("specify azure account name and container like: az://account.container/path")
+		return nil, fmt.Errorf("specify azure account name and container like: az://account.container/path or az://account/container/path")
 	}
 
-	accessKey := os.Getenv("AZURE_STORAGE_KEY")
-	if accessKey == "" {
-		return nil, fmt.Errorf("specify azure access storate key with env var: AZURE_STORAGE_KEY")
+	containerURL, err := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", accountName, containerName))
+	if err != nil {
+		return nil, fmt.Errorf("invalid container url: %w", err)
 	}
 
-	credential, err := azblob.NewSharedKeyCredential(accountName, accessKey)
-	if err != nil {
-		return nil, fmt.Errorf("azure authentication failed: %w", err)
+	var credential azblob.Credential
+	var sharedKeyCredential *azblob.SharedKeyCredential
+	if accessKey := os.Getenv("AZURE_STORAGE_KEY"); accessKey != "" {
+		sharedKeyCredential, err = azblob.NewSharedKeyCredential(accountName, accessKey)
+		if err != nil {
+			return nil, fmt.Errorf("azure authentication failed: %w", err)
+		}
+		credential = sharedKeyCredential
+	} else if sasToken := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); sasToken != "" {
+		// The SAS token rides along every request made from the container URL
+		containerURL.RawQuery = strings.TrimPrefix(sasToken, "?")
+		credential = azblob.NewAnonymousCredential()
+	} else if baseURL.Query().Get("auth") == "managed_identity" {
+		credential, err = newAzureManagedIdentityCredential(loggerOrDefault(config.logger), os.Getenv("AZURE_CLIENT_ID"))
+		if err != nil {
+			return nil, fmt.Errorf("azure managed identity authentication failed: %w", err)
+		}
+	} else {
+		return nil, fmt.Errorf("specify azure credentials with env var AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN, or use the host's managed identity with the auth=managed_identity query parameter")
 	}
 
-	p := azblob.NewPipeline(credential, azblob.PipelineOptions{
+	pipelineOptions := azblob.PipelineOptions{
 		RequestLog: azblob.RequestLogOptions{
 			LogWarningIfTryOverThreshold: time.Millisecond * 200,
 		},
-	})
-	u, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", accountName, containerName))
-	containerURL := azblob.NewContainerURL(*u, p)
+	}
+	common := newCommonStore(baseURL, config)
+	if common.httpClient != nil {
+		pipelineOptions.HTTPSender = azureHTTPSender(common.httpClient)
+	}
+	p := azblob.NewPipeline(credential, pipelineOptions)
 
 	return &AzureStore{
-		baseURL:      baseURL,
-		containerURL: containerURL,
-		commonStore: &commonStore{
-			compressionType: compressionType,
-			extension:       extension,
-			overwrite:       overwrite,
-		},
+		baseURL:       baseURL,
+		containerName: containerName,
+		containerURL:  azblob.NewContainerURL(*containerURL, p),
+		path:          blobPath,
+		credential:    sharedKeyCredential,
+		commonStore:   common,
 	}, nil
 }
 
+// azureHTTPSender sends the requests of the pipeline with `client` instead of
+// the default client of the Azure SDK.
+func azureHTTPSender(client *http.Client) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			resp, err := client.Do(request.WithContext(ctx))
+			if err != nil {
+				err = pipeline.NewError(err, "HTTP request failed")
+			}
+			return pipeline.NewHTTPResponse(resp), err
+		}
+	})
+}
+
+// azureIMDSTokenEndpoint is the Azure Instance Metadata Service endpoint
+// delivering tokens for the managed identities of the host.
+var azureIMDSTokenEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// newAzureManagedIdentityCredential fetches a storage token from the instance
+// metadata service and keeps refreshing it ahead of its expiration.
+func newAzureManagedIdentityCredential(logger *zap.Logger, clientID string) (azblob.TokenCredential, error) {
+	token, expiresIn, err := fetchAzureManagedIdentityToken(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshIn := func(expiresIn time.Duration) time.Duration {
+		if expiresIn > 10*time.Minute {
+			return expiresIn - 5*time.Minute
+		}
+		return expiresIn / 2
+	}
+
+	initial := true
+	return azblob.NewTokenCredential(token, func(credential azblob.TokenCredential) time.Duration {
+		if initial {
+			// Invoked right away by `NewTokenCredential`, the token is fresh
+			initial = false
+			return refreshIn(expiresIn)
+		}
+
+		token, expiresIn, err := fetchAzureManagedIdentityToken(clientID)
+		if err != nil {
+			logger.Warn("unable to refresh azure managed identity token, retrying soon", zap.Error(err))
+			return 30 * time.Second
+		}
+		credential.SetToken(token)
+		return refreshIn(expiresIn)
+	}), nil
+}
+
+func fetchAzureManagedIdentityToken(clientID string) (token string, expiresIn time.Duration, err error) {
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", "https://storage.azure.com/")
+	if clientID != "" {
+		query.Set("client_id", clientID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, azureIMDSTokenEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("requesting token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", 0, fmt.Errorf("requesting token: unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in,string"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", 0, fmt.Errorf("decoding token: %w", err)
+	}
+
+	return out.AccessToken, time.Duration(out.ExpiresIn) * time.Second, nil
+}
+
 func (s *AzureStore) SubStore(subFolder string) (Store, error) {
 	url, err := url.Parse(s.baseURL.String())
 	if err != nil {
 		return nil, fmt.Errorf("azure store parsing base url: %w", err)
 	}
 	url.Path = path.Join(url.Path, subFolder)
-	return NewAzureStore(url, s.extension, s.compressionType, s.overwrite)
+	return NewAzureStoreWithOptions(url, s.options()...)
 }
 
 func (s *AzureStore) BaseURL() *url.URL {
@@ -70,18 +200,54 @@ func (s *AzureStore) BaseURL() *url.URL {
 }
 
 func (a *AzureStore) ObjectPath(name string) string {
-	return path.Join(strings.TrimLeft(a.baseURL.Path, "/"), a.pathWithExt(name))
+	return path.Join(a.path, a.pathWithExt(name))
 }
 
 func (a *AzureStore) ObjectURL(name string) string {
 	return fmt.Sprintf("%s/%s", strings.TrimRight(a.baseURL.String(), "/"), strings.TrimLeft(a.pathWithExt(name), "/"))
 }
 
-func (a *AzureStore) FileExists(ctx context.Context, base string) (bool, error) {
+func (a *AzureStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return a.presign(base, ttl, azblob.BlobSASPermissions{Read: true})
+}
+
+func (a *AzureStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return a.presign(base, ttl, azblob.BlobSASPermissions{Create: true, Write: true})
+}
+
+// presign generates a blob service SAS URL signed with the account's shared key.
+func (a *AzureStore) presign(base string, ttl time.Duration, permissions azblob.BlobSASPermissions) (string, error) {
+	if a.credential == nil {
+		return "", fmt.Errorf("presigning without shared key credentials: %w", ErrNotSupported)
+	}
+
+	path := a.ObjectPath(base)
+
+	sas, err := azblob.BlobSASSignatureValues{
+		Protocol:      azblob.SASProtocolHTTPS,
+		ExpiryTime:    time.Now().UTC().Add(ttl),
+		ContainerName: a.containerName,
+		BlobName:      path,
+		Permissions:   permissions.String(),
+	}.NewSASQueryParameters(a.credential)
+	if err != nil {
+		return "", fmt.Errorf("signing SAS: %w", err)
+	}
+
+	blobURL := a.containerURL.NewBlockBlobURL(path).URL()
+	blobURL.RawQuery = sas.Encode()
+
+	return blobURL.String(), nil
+}
+
+func (a *AzureStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer classifyError(&err)
 	path := a.ObjectPath(base)
 
 	blobURL := a.containerURL.NewBlockBlobURL(path)
-	_, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	_, err = blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
 	if err != nil {
 
 		// azure returns a 404 error when blob NOT FOUND
@@ -97,8 +263,36 @@ func (a *AzureStore) FileExists(ctx context.Context, base string) (bool, error)
 	return true, nil
 }
 
-func (a *AzureStore) WriteObject(ctx context.Context, base string, f io.Reader) (err error) {
+func (a *AzureStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	defer classifyError(&err)
+	path := a.ObjectPath(base)
+
+	blobURL := a.containerURL.NewBlockBlobURL(path)
+	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		if isAzureNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &ObjectAttrs{
+		Name:         base,
+		Size:         props.ContentLength(),
+		LastModified: props.LastModified(),
+		ETag:         strings.Trim(string(props.ETag()), `"`),
+		Metadata:     normalizeMetadata(props.NewMetadata()),
+	}, nil
+}
+
+func (a *AzureStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	defer classifyError(&err)
 	path := a.ObjectPath(base)
+	config := newWriteConfig(opts)
+	f = config.trackProgress(f)
+	if config.acl != "" {
+		return fmt.Errorf("object ACL: %w", ErrNotSupported)
+	}
 
 	exists, err := a.FileExists(ctx, base)
 	if err != nil {
@@ -117,7 +311,7 @@ func (a *AzureStore) WriteObject(ctx context.Context, base string, f io.Reader)
 	go func() {
 		defer pipeWrite.Close()
 
-		err := a.compressedCopy(f, pipeWrite)
+		err := a.compressedCopy(ctx, f, pipeWrite)
 		if err != nil {
 			cancel()
 		}
@@ -127,15 +321,16 @@ func (a *AzureStore) WriteObject(ctx context.Context, base string, f io.Reader)
 	bufferSize := 1 * 1024 * 1024 // Size of the rotating buffers that are used when uploading
 	maxBuffers := 3               // Number of rotating buffers that are used when uploading
 	blobURL := a.containerURL.NewBlockBlobURL(path)
+	contentType, cacheControl := a.contentHeaders(config, defaultContentType, defaultCacheControl)
 	blobHeader := azblob.BlobHTTPHeaders{
-		ContentType:  "application/octet-stream",
-		CacheControl: "public, max-age=86400",
+		ContentType:  contentType,
+		CacheControl: cacheControl,
 	}
 
 	_, err = azblob.UploadStreamToBlockBlob(ctx, pipeRead, blobURL, azblob.UploadStreamToBlockBlobOptions{BlobHTTPHeaders: blobHeader,
 		BufferSize:       bufferSize,
 		MaxBuffers:       maxBuffers,
-		Metadata:         azblob.Metadata{},
+		Metadata:         azblob.Metadata(config.metadata),
 		AccessConditions: azblob.BlobAccessConditions{},
 	})
 	if err != nil {
@@ -145,7 +340,57 @@ func (a *AzureStore) WriteObject(ctx context.Context, base string, f io.Reader)
 	return nil
 }
 
+func (a *AzureStore) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, a, dst); skip || err != nil {
+		return err
+	}
+
+	return a.copyFromURL(ctx, a.containerURL.NewBlockBlobURL(a.ObjectPath(src)).URL(), a.ObjectPath(dst))
+}
+
+// copyFromURL starts a server-side copy of the source blob and waits for it to
+// complete, Azure copies being asynchronous.
+func (a *AzureStore) copyFromURL(ctx context.Context, source url.URL, dstPath string) error {
+	blobURL := a.containerURL.NewBlockBlobURL(dstPath)
+
+	resp, err := blobURL.StartCopyFromURL(ctx, source, azblob.Metadata{}, azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{}, azblob.DefaultAccessTier, nil)
+	if err != nil {
+		if isAzureNotFound(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	status := resp.CopyStatus()
+	for status == azblob.CopyStatusPending {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+
+		props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+		if err != nil {
+			return fmt.Errorf("get copy status: %w", err)
+		}
+		status = props.CopyStatus()
+	}
+
+	if status != azblob.CopyStatusSuccess {
+		return fmt.Errorf("copy to %q ended with status %q", dstPath, status)
+	}
+
+	return nil
+}
+
+func (a *AzureStore) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer classifyError(&err)
+	return renameObject(ctx, a, oldName, newName)
+}
+
 func (a *AzureStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
 	path := a.ObjectPath(name)
 
 	blobURL := a.containerURL.NewBlockBlobURL(path)
@@ -159,12 +404,50 @@ func (a *AzureStore) OpenObject(ctx context.Context, name string) (out io.ReadCl
 		return nil, err
 	}
 
-	reader := get.Body(azblob.RetryReaderOptions{})
+	// The Azure SDK reopens interrupted reads natively
+	reader := get.Body(azblob.RetryReaderOptions{MaxRetryRequests: a.readResumes})
+
+	return a.uncompressedReader(ctx, reader)
+}
+
+func (a *AzureStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	if a.decompressesReads() {
+		return openDecompressedRange(ctx, a, name, offset, length)
+	}
+	reader, err := a.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return a.throttleReads(ctx, reader), nil
+}
+
+func (a *AzureStore) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	if length < 0 {
+		length = azblob.CountToEnd
+	}
+
+	path := a.ObjectPath(name)
+	blobURL := a.containerURL.NewBlockBlobURL(path)
+
+	get, err := blobURL.Download(ctx, offset, length, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		if isAzureNotFound(err) {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
 
-	return a.uncompressedReader(reader)
+	return get.Body(azblob.RetryReaderOptions{}), nil
 }
 
-func (a *AzureStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) error {
+func (a *AzureStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer classifyError(&err)
 	remove, err := pushLocalFile(ctx, a, localFile, toBaseName)
 	if err != nil {
 		return err
@@ -172,21 +455,27 @@ func (a *AzureStore) PushLocalFile(ctx context.Context, localFile, toBaseName st
 	return remove()
 }
 
-func (s *AzureStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
+func (s *AzureStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
 	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
 }
 
-func (a *AzureStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error {
+func (a *AzureStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkBetween(a, ctx, prefix, startingPoint, endPoint, f)
+}
 
-	p := strings.TrimLeft(a.baseURL.Path, "/") + "/"
-	if prefix != "" {
-		p = filepath.Join(p, prefix)
-		// join cleans the string and will remove the trailing / in the prefix is present.
-		// adding it back to prevent false positive matches
-		if prefix[len(prefix)-1:] == "/" {
-			p = p + "/"
-		}
-	}
+func (a *AzureStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return a.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (a *AzureStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer classifyError(&err)
+	f = skipPrefixes(f)
+	p := a.walkPrefix(prefix)
 
 	for marker := (azblob.Marker{}); marker.NotDone(); { // The parens around Marker{} are required to avoid compiler error.
 		// Get a result segment starting with the blob indicated by the current Marker.
@@ -203,38 +492,145 @@ func (a *AzureStore) Walk(ctx context.Context, prefix string, f func(filename st
 
 		// Process the blobs returned in this result segment (if the segment is empty, the loop body won't execute)
 		for _, blobInfo := range listBlob.Segment.BlobItems {
-			if err := f(a.toBaseName(blobInfo.Name)); err != nil {
+			if err := f(a.newObjectAttrs(blobInfo)); err != nil {
 				if err == StopIteration {
 					return nil
 				}
+				return err
 			}
 		}
 	}
 	return nil
 }
 
-func (a *AzureStore) ListFiles(ctx context.Context, prefix string, max int) ([]string, error) {
+func (a *AzureStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer classifyError(&err)
+	var marker azblob.Marker
+	if pageToken != "" {
+		marker.Val = &pageToken
+	}
+
+	listBlob, err := a.containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{
+		Prefix:     a.walkPrefix(prefix),
+		MaxResults: int32(pageSize),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, blobInfo := range listBlob.Segment.BlobItems {
+		files = append(files, a.toBaseName(blobInfo.Name))
+	}
+	if listBlob.NextMarker.Val != nil {
+		nextToken = *listBlob.NextMarker.Val
+	}
+	return files, nextToken, nil
+}
+
+// walkPrefix returns the full blob name prefix to list for the given store
+// relative prefix.
+func (a *AzureStore) walkPrefix(prefix string) string {
+	p := ""
+	if a.path != "" {
+		p = a.path + "/"
+	}
+	if prefix != "" {
+		p = filepath.Join(p, prefix)
+		// join cleans the string and will remove the trailing / in the prefix is present.
+		// adding it back to prevent false positive matches
+		if prefix[len(prefix)-1:] == "/" {
+			p = p + "/"
+		}
+	}
+	return p
+}
+
+func (a *AzureStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer classifyError(&err)
+	p := directoryPrefix(a.path, prefix)
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		listBlob, err := a.containerURL.ListBlobsHierarchySegment(ctx, marker, "/", azblob.ListBlobsSegmentOptions{
+			Prefix: p,
+		})
+		if err != nil {
+			return nil, err
+		}
+		marker = listBlob.NextMarker
+
+		for _, blobPrefix := range listBlob.Segment.BlobPrefixes {
+			out = append(out, relativeDirectory(a.path, blobPrefix.Name))
+		}
+	}
+	return out, nil
+}
+
+func (a *AzureStore) newObjectAttrs(blobInfo azblob.BlobItemInternal) *ObjectAttrs {
+	attrs := &ObjectAttrs{
+		Name:         a.toBaseName(blobInfo.Name),
+		LastModified: blobInfo.Properties.LastModified,
+		ETag:         strings.Trim(string(blobInfo.Properties.Etag), `"`),
+	}
+	if blobInfo.Properties.ContentLength != nil {
+		attrs.Size = *blobInfo.Properties.ContentLength
+	}
+	return attrs
+}
+
+func (a *AzureStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer classifyError(&err)
 	return listFiles(ctx, a, prefix, max)
 }
 
-func (a AzureStore) DeleteObject(ctx context.Context, base string) error {
+func (a AzureStore) DeleteObject(ctx context.Context, base string) (err error) {
+	defer classifyError(&err)
 	path := a.ObjectPath(base)
 
 	blobURL := a.containerURL.NewBlockBlobURL(path)
 
-	_, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+	_, err = blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
 
 	return err
 }
 
-func decodeAzureScheme(baseURL *url.URL) (accountName string, container string, err error) {
-	chunks := strings.Split(baseURL.Host, ".")
-	if len(chunks) != 2 {
-		err = fmt.Errorf("invalid schema expected cannot decode account name and container")
-		return
+func (a *AzureStore) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer classifyError(&err)
+	return deleteObjectsConcurrently(ctx, names, deleteObjectsConcurrency, func(ctx context.Context, name string) error {
+		err := a.DeleteObject(ctx, name)
+		if err != nil && isAzureNotFound(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (a *AzureStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer classifyError(&err)
+	return deletePrefix(ctx, a, prefix, deletePrefixConcurrency)
+}
+
+// decodeAzureScheme supports both the `az://account.container/path` and the
+// `az://account/container/path` forms.
+func decodeAzureScheme(baseURL *url.URL) (accountName string, container string, blobPath string, err error) {
+	blobPath = strings.Trim(baseURL.Path, "/")
+
+	if strings.Contains(baseURL.Host, ".") {
+		chunks := strings.Split(baseURL.Host, ".")
+		if len(chunks) != 2 {
+			err = fmt.Errorf("invalid schema expected cannot decode account name and container")
+			return
+		}
+		accountName = chunks[0]
+		container = chunks[1]
+	} else {
+		accountName = baseURL.Host
+		chunks := strings.SplitN(blobPath, "/", 2)
+		container = chunks[0]
+		blobPath = ""
+		if len(chunks) == 2 {
+			blobPath = chunks[1]
+		}
 	}
-	accountName = chunks[0]
-	container = chunks[1]
 
 	if accountName == "" {
 		err = fmt.Errorf("invalid schema missing account name")
@@ -248,6 +644,25 @@ func decodeAzureScheme(baseURL *url.URL) (accountName string, container string,
 	return
 }
 
+// isAzureNotFound returns true when the error is a blob not found error. On
+// `HEAD` requests, Azure has no body to carry the service code so we also check
+// the response's status code.
+func isAzureNotFound(err error) bool {
+	if serr, ok := err.(azblob.StorageError); ok {
+		if serr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			return true
+		}
+		if resp := serr.Response(); resp != nil && resp.StatusCode == http.StatusNotFound {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *AzureStore) toBaseName(filename string) string {
-	return strings.TrimPrefix(strings.TrimSuffix(filename, s.pathWithExt("")), strings.TrimLeft(s.baseURL.Path, "/")+"/")
+	name := strings.TrimSuffix(filename, s.pathWithExt(""))
+	if s.path == "" {
+		return name
+	}
+	return strings.TrimPrefix(name, s.path+"/")
 }
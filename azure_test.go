@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -18,6 +22,7 @@ func Test_decodeAzureScheme(t *testing.T) {
 		schema              string
 		expectAccountName   string
 		expectContainerName string
+		expectPath          string
 		expectError         bool
 	}{
 		{
@@ -25,6 +30,7 @@ func Test_decodeAzureScheme(t *testing.T) {
 			schema:              "azblob://accountname.container/path",
 			expectAccountName:   "accountname",
 			expectContainerName: "container",
+			expectPath:          "path",
 			expectError:         false,
 		},
 		{
@@ -33,9 +39,17 @@ func Test_decodeAzureScheme(t *testing.T) {
 			expectError: true,
 		},
 		{
-			name:        "only account name",
-			schema:      "azblob://accountname/path",
-			expectError: true,
+			name:                "container in path",
+			schema:              "azblob://accountname/container/path/sub",
+			expectAccountName:   "accountname",
+			expectContainerName: "container",
+			expectPath:          "path/sub",
+		},
+		{
+			name:                "container in path without path",
+			schema:              "azblob://accountname/container",
+			expectAccountName:   "accountname",
+			expectContainerName: "container",
 		},
 		{
 			name:        "missing account name",
@@ -44,7 +58,7 @@ func Test_decodeAzureScheme(t *testing.T) {
 		},
 		{
 			name:        "only account name",
-			schema:      "azblob://container/path",
+			schema:      "azblob://accountname",
 			expectError: true,
 		},
 	}
@@ -56,13 +70,14 @@ func Test_decodeAzureScheme(t *testing.T) {
 				panic("invalid test")
 			}
 
-			a, c, err := decodeAzureScheme(dsn)
+			a, c, p, err := decodeAzureScheme(dsn)
 			if test.expectError {
 				require.Error(t, err)
 			} else {
 				require.NoError(t, err)
 				assert.Equal(t, test.expectAccountName, a)
 				assert.Equal(t, test.expectContainerName, c)
+				assert.Equal(t, test.expectPath, p)
 			}
 
 		})
@@ -70,6 +85,67 @@ func Test_decodeAzureScheme(t *testing.T) {
 
 }
 
+func TestNewAzureStore_Credentials(t *testing.T) {
+	os.Setenv("AZURE_STORAGE_KEY", "")
+	os.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	defer os.Unsetenv("AZURE_STORAGE_KEY")
+	defer os.Unsetenv("AZURE_STORAGE_SAS_TOKEN")
+
+	base, _ := url.Parse("az://account/container/path")
+	_, err := NewAzureStore(base, "", "", false)
+	require.Error(t, err, "no credentials")
+
+	os.Setenv("AZURE_STORAGE_KEY", "c2VjcmV0")
+	store, err := NewAzureStore(base, "dbin", "", false)
+	require.NoError(t, err)
+	assert.Equal(t, "path/0001.dbin", store.ObjectPath("0001"))
+
+	signed, err := store.PresignGet(context.Background(), "0001", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(signed, "https://account.blob.core.windows.net/container/path/0001.dbin?"), signed)
+
+	os.Setenv("AZURE_STORAGE_KEY", "")
+	os.Setenv("AZURE_STORAGE_SAS_TOKEN", "?sv=2020-08-04&sig=signature")
+	store, err = NewAzureStore(base, "", "", false)
+	require.NoError(t, err)
+	assert.Equal(t, "sv=2020-08-04&sig=signature", store.containerURL.NewBlockBlobURL("0001").URL().RawQuery)
+
+	_, err = store.PresignGet(context.Background(), "0001", time.Minute)
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestNewAzureStore_ManagedIdentity(t *testing.T) {
+	os.Setenv("AZURE_STORAGE_KEY", "")
+	os.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	os.Setenv("AZURE_CLIENT_ID", "client-id")
+	defer os.Unsetenv("AZURE_CLIENT_ID")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("client_id") != "client-id" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"access_token": "token", "expires_in": "3599", "token_type": "Bearer"}`)
+	}))
+	defer server.Close()
+
+	defer func(endpoint string) { azureIMDSTokenEndpoint = endpoint }(azureIMDSTokenEndpoint)
+	azureIMDSTokenEndpoint = server.URL
+
+	base, _ := url.Parse("az://account/container/path?auth=managed_identity")
+	store, err := NewAzureStore(base, "", "", false)
+	require.NoError(t, err)
+	assert.Nil(t, store.credential)
+
+	token, expiresIn, err := fetchAzureManagedIdentityToken("client-id")
+	require.NoError(t, err)
+	assert.Equal(t, "token", token)
+	assert.Equal(t, 3599*time.Second, expiresIn)
+
+	_, _, err = fetchAzureManagedIdentityToken("other")
+	require.Error(t, err)
+}
+
 func TestAzureSToreWriteObject(t *testing.T) {
 	t.Skip("needs azure access to test this")
 	os.Setenv("AZURE_STORAGE_KEY", "")
@@ -118,3 +194,31 @@ func TestAzureSToreWriteObject(t *testing.T) {
 	require.NoError(t, err)
 	assert.ElementsMatch(t, []string{}, readFiles)
 }
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestAzureStore_HTTPClient(t *testing.T) {
+	os.Setenv("AZURE_STORAGE_KEY", "c2VjcmV0")
+	defer os.Unsetenv("AZURE_STORAGE_KEY")
+
+	var requested []string
+	client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		requested = append(requested, r.Method+" "+r.URL.Host+r.URL.Path)
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody, Request: r}, nil
+	})}
+
+	base, _ := url.Parse("az://account/container/path")
+	store, err := NewAzureStoreWithOptions(base, HTTPClient(client))
+	require.NoError(t, err)
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+
+	exists, err := sub.FileExists(context.Background(), "file")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, []string{"HEAD account.blob.core.windows.net/container/path/sub/file"}, requested)
+}
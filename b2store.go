@@ -0,0 +1,1191 @@
+//go:build !dstore_no_b2
+
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// b2APIURL is the endpoint used to authorize the account, a variable so tests
+// can point it to a fake server.
+var b2APIURL = "https://api.backblazeb2.com"
+
+// b2MaxAttempts is the number of times a B2 request is attempted before
+// giving up on transient errors, waiting `b2RetryDelay` before the first
+// retry and doubling it for each one after.
+var b2MaxAttempts = 6
+var b2RetryDelay = time.Second
+
+// b2MaxCopySize is the largest object `b2_copy_file` copies in one request,
+// larger ones are copied part by part.
+const b2MaxCopySize = 5 * 1024 * 1024 * 1024
+
+// b2MaxListCount is the largest page `b2_list_file_names` returns.
+const b2MaxListCount = 10000
+
+//
+// Backblaze B2 Store
+//
+
+func init() {
+	registerBackend(&backend{
+		schemes: []string{"b2"},
+		newStore: func(base *url.URL, opts ...Option) (Store, error) {
+			store, err := NewB2StoreWithOptions(base, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		},
+		errorClass: b2ErrorClass,
+	})
+}
+
+type B2Store struct {
+	baseURL *url.URL
+
+	bucket string
+	path   string
+	client *b2Client
+
+	multipartThreshold int64
+
+	*commonStore
+}
+
+func NewB2Store(baseURL *url.URL, extension, compressionType string, overwrite bool) (*B2Store, error) {
+	return NewB2StoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+// NewB2StoreWithOptions creates a store on the `b2://bucket/path` URL using B2's
+// native API, authenticated with the application key found in the
+// `B2_APPLICATION_KEY_ID` and `B2_APPLICATION_KEY` environment variables.
+func NewB2StoreWithOptions(baseURL *url.URL, opts ...Option) (*B2Store, error) {
+	keyID, key := os.Getenv("B2_APPLICATION_KEY_ID"), os.Getenv("B2_APPLICATION_KEY")
+	if keyID == "" || key == "" {
+		return nil, fmt.Errorf("specify b2 credentials through the B2_APPLICATION_KEY_ID and B2_APPLICATION_KEY environment variables")
+	}
+	if baseURL.Host == "" {
+		return nil, fmt.Errorf("specify b2 bucket like: b2://bucket/path")
+	}
+
+	config := newCloudConfig(opts)
+	return newB2Store(baseURL, newB2Client(http.DefaultClient, loggerOrDefault(config.logger), keyID, key, baseURL.Host), config)
+}
+
+func newB2Store(baseURL *url.URL, client *b2Client, config *config) (*B2Store, error) {
+	if config.multipartThreshold != 0 && config.multipartThreshold < b2MinPartSize {
+		return nil, fmt.Errorf("multipart threshold must be at least %d bytes, got %d", b2MinPartSize, config.multipartThreshold)
+	}
+
+	return &B2Store{
+		baseURL:            baseURL,
+		bucket:             baseURL.Host,
+		path:               strings.Trim(baseURL.Path, "/"),
+		client:             client,
+		multipartThreshold: config.multipartThreshold,
+		commonStore:        newCommonStore(baseURL, config),
+	}, nil
+}
+
+// SubStore shares the B2 client, and so its authorization and upload URLs, of
+// the parent store.
+func (s *B2Store) SubStore(subFolder string) (Store, error) {
+	url, err := url.Parse(s.baseURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("b2 store parsing base url: %w", err)
+	}
+	url.Path = path.Join(url.Path, subFolder)
+	return newB2Store(url, s.client, newConfig(append(s.options(), MultipartThreshold(s.multipartThreshold))))
+}
+
+func (s *B2Store) BaseURL() *url.URL {
+	return s.baseURL
+}
+
+func (s *B2Store) ObjectPath(name string) string {
+	return path.Join(s.path, s.pathWithExt(name))
+}
+
+func (s *B2Store) ObjectURL(name string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(s.baseURL.String(), "/"), strings.TrimLeft(s.pathWithExt(name), "/"))
+}
+
+func (s *B2Store) PresignGet(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+
+	var out struct {
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+	err = s.client.api(ctx, "b2_get_download_authorization", func(auth *b2Authorization) interface{} {
+		return map[string]interface{}{
+			"bucketId":               auth.bucketID,
+			"fileNamePrefix":         path,
+			"validDurationInSeconds": int64(ttl / time.Second),
+		}
+	}, &out)
+	if err != nil {
+		return "", err
+	}
+
+	auth, err := s.client.authorization(ctx)
+	if err != nil {
+		return "", err
+	}
+	return s.downloadURL(auth, path) + "?Authorization=" + url.QueryEscape(out.AuthorizationToken), nil
+}
+
+func (s *B2Store) PresignPut(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return "", fmt.Errorf("b2 store presign put: %w", ErrNotSupported)
+}
+
+func (s *B2Store) downloadURL(auth *b2Authorization, filePath string) string {
+	return auth.DownloadURL + "/file/" + b2EscapeName(s.bucket) + "/" + b2EscapeName(filePath)
+}
+
+func (s *B2Store) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+	config := newWriteConfig(opts)
+	f = config.trackProgress(f)
+	if config.acl != "" {
+		return fmt.Errorf("object ACL: %w", ErrNotSupported)
+	}
+
+	if !s.overwrite {
+		exists, err := s.FileExists(ctx, base)
+		if err != nil {
+			return err
+		}
+		if exists {
+			// We silently ignore when we ask not to overwrite
+			return nil
+		}
+	}
+
+	contentType, cacheControl := s.contentHeaders(config, "b2/x-auto", "")
+	fileInfo := map[string]string{}
+	for key, value := range config.metadata {
+		fileInfo[key] = value
+	}
+	if cacheControl != "" {
+		fileInfo["b2-cache-control"] = cacheControl
+	}
+
+	pipeRead, pipeWrite := io.Pipe()
+	writeDone := make(chan error, 1)
+	go func() {
+		err := s.compressedCopy(ctx, f, pipeWrite)
+		pipeWrite.CloseWithError(err)
+		writeDone <- err
+	}()
+
+	err = s.upload(ctx, path, contentType, fileInfo, pipeRead)
+	pipeRead.Close()
+	if copyErr := <-writeDone; copyErr != nil && copyErr != io.ErrClosedPipe {
+		return copyErr
+	}
+	return err
+}
+
+// upload sends the content in a single request when it fits in one part and
+// through B2's large file API otherwise, B2 requiring the length and SHA1 of
+// every request body upfront.
+func (s *B2Store) upload(ctx context.Context, filePath, contentType string, fileInfo map[string]string, content io.Reader) error {
+	auth, err := s.client.authorization(ctx)
+	if err != nil {
+		return err
+	}
+
+	partSize := s.multipartThreshold
+	if partSize == 0 {
+		partSize = auth.RecommendedPartSize
+	}
+
+	part, err := readB2Part(content, partSize)
+	if err != nil {
+		return err
+	}
+
+	var next []byte
+	if int64(len(part)) == partSize {
+		if next, err = readB2Part(content, partSize); err != nil {
+			return err
+		}
+	}
+
+	// Large files are made of at least two parts
+	if len(next) == 0 {
+		headers := map[string]string{
+			"X-Bz-File-Name": b2EscapeName(filePath),
+			"Content-Type":   contentType,
+		}
+		for key, value := range fileInfo {
+			headers["X-Bz-Info-"+key] = b2EscapeName(value)
+		}
+		return s.client.upload(ctx, s.client.uploadURLs, headers, part)
+	}
+
+	var largeFile struct {
+		FileID string `json:"fileId"`
+	}
+	err = s.client.api(ctx, "b2_start_large_file", func(auth *b2Authorization) interface{} {
+		return map[string]interface{}{
+			"bucketId":    auth.bucketID,
+			"fileName":    filePath,
+			"contentType": contentType,
+			"fileInfo":    fileInfo,
+		}
+	}, &largeFile)
+	if err != nil {
+		return fmt.Errorf("starting large file: %w", err)
+	}
+
+	partURLs := newB2UploadURLPool(func(ctx context.Context) (*b2UploadURL, error) {
+		out := &b2UploadURL{}
+		err := s.client.api(ctx, "b2_get_upload_part_url", func(auth *b2Authorization) interface{} {
+			return map[string]interface{}{"fileId": largeFile.FileID}
+		}, out)
+		return out, err
+	})
+
+	var partSHA1s []string
+	for partNumber := 1; len(part) > 0; partNumber++ {
+		headers := map[string]string{"X-Bz-Part-Number": strconv.Itoa(partNumber)}
+		if err := s.client.upload(ctx, partURLs, headers, part); err != nil {
+			s.cancelLargeFile(largeFile.FileID)
+			return fmt.Errorf("uploading part %d: %w", partNumber, err)
+		}
+		partSHA1s = append(partSHA1s, b2SHA1(part))
+
+		part, next = next, nil
+		if int64(len(part)) == partSize {
+			if next, err = readB2Part(content, partSize); err != nil {
+				s.cancelLargeFile(largeFile.FileID)
+				return err
+			}
+		}
+	}
+
+	return s.finishLargeFile(ctx, largeFile.FileID, partSHA1s)
+}
+
+// readB2Part reads up to `partSize` bytes, growing the buffer as content comes
+// in so small objects don't allocate a whole part.
+func readB2Part(content io.Reader, partSize int64) ([]byte, error) {
+	part := &bytes.Buffer{}
+	if _, err := io.CopyN(part, content, partSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return part.Bytes(), nil
+}
+
+func (s *B2Store) finishLargeFile(ctx context.Context, fileID string, partSHA1s []string) error {
+	err := s.client.api(ctx, "b2_finish_large_file", func(auth *b2Authorization) interface{} {
+		return map[string]interface{}{"fileId": fileID, "partSha1Array": partSHA1s}
+	}, nil)
+	if err != nil {
+		s.cancelLargeFile(fileID)
+		return fmt.Errorf("finishing large file: %w", err)
+	}
+	return nil
+}
+
+// cancelLargeFile drops the parts of a failed large file, which are billed
+// until canceled.
+func (s *B2Store) cancelLargeFile(fileID string) {
+	err := s.client.api(context.Background(), "b2_cancel_large_file", func(auth *b2Authorization) interface{} {
+		return map[string]interface{}{"fileId": fileID}
+	}, nil)
+	if err != nil {
+		s.logger.Warn("unable to cancel b2 large file, its parts are left behind", zap.String("file_id", fileID), zap.Error(err))
+	}
+}
+
+// b2File is a file version as returned by B2's listings.
+type b2File struct {
+	FileID          string            `json:"fileId"`
+	FileName        string            `json:"fileName"`
+	Action          string            `json:"action"`
+	ContentLength   int64             `json:"contentLength"`
+	ContentSHA1     string            `json:"contentSha1"`
+	ContentType     string            `json:"contentType"`
+	FileInfo        map[string]string `json:"fileInfo"`
+	UploadTimestamp int64             `json:"uploadTimestamp"`
+}
+
+type b2FileList struct {
+	Files        []*b2File `json:"files"`
+	NextFileName *string   `json:"nextFileName"`
+}
+
+func (s *B2Store) listFileNames(ctx context.Context, prefix, startFileName, delimiter string, maxFileCount int) (*b2FileList, error) {
+	out := &b2FileList{}
+	err := s.client.api(ctx, "b2_list_file_names", func(auth *b2Authorization) interface{} {
+		request := map[string]interface{}{
+			"bucketId":     auth.bucketID,
+			"prefix":       prefix,
+			"maxFileCount": maxFileCount,
+		}
+		if startFileName != "" {
+			request["startFileName"] = startFileName
+		}
+		if delimiter != "" {
+			request["delimiter"] = delimiter
+		}
+		return request
+	}, out)
+	if err != nil {
+		return nil, fmt.Errorf("listing files: %w", err)
+	}
+	return out, nil
+}
+
+// file returns the latest version of the file at `filePath`.
+func (s *B2Store) file(ctx context.Context, filePath string) (*b2File, error) {
+	list, err := s.listFileNames(ctx, filePath, filePath, "", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Files) == 0 || list.Files[0].FileName != filePath {
+		return nil, ErrNotFound
+	}
+	return list.Files[0], nil
+}
+
+func (s *B2Store) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, s, dst); skip || err != nil {
+		return err
+	}
+
+	source, err := s.file(ctx, s.ObjectPath(src))
+	if err != nil {
+		return err
+	}
+	dstPath := s.ObjectPath(dst)
+
+	if source.ContentLength <= b2MaxCopySize {
+		return s.client.api(ctx, "b2_copy_file", func(auth *b2Authorization) interface{} {
+			return map[string]interface{}{
+				"sourceFileId":      source.FileID,
+				"fileName":          dstPath,
+				"metadataDirective": "COPY",
+			}
+		}, nil)
+	}
+
+	return s.copyLargeFile(ctx, source, dstPath)
+}
+
+// copyLargeFile copies the file part by part, the content type and file info
+// must be passed explicitly as they are not carried over by part copies.
+func (s *B2Store) copyLargeFile(ctx context.Context, source *b2File, dstPath string) error {
+	auth, err := s.client.authorization(ctx)
+	if err != nil {
+		return err
+	}
+
+	var largeFile struct {
+		FileID string `json:"fileId"`
+	}
+	err = s.client.api(ctx, "b2_start_large_file", func(auth *b2Authorization) interface{} {
+		return map[string]interface{}{
+			"bucketId":    auth.bucketID,
+			"fileName":    dstPath,
+			"contentType": source.ContentType,
+			"fileInfo":    source.FileInfo,
+		}
+	}, &largeFile)
+	if err != nil {
+		return fmt.Errorf("starting large file: %w", err)
+	}
+
+	partSize := auth.RecommendedPartSize
+	var partSHA1s []string
+	for partNumber, offset := 1, int64(0); offset < source.ContentLength; partNumber, offset = partNumber+1, offset+partSize {
+		end := offset + partSize - 1
+		if end >= source.ContentLength {
+			end = source.ContentLength - 1
+		}
+
+		var part struct {
+			ContentSHA1 string `json:"contentSha1"`
+		}
+		err := s.client.api(ctx, "b2_copy_part", func(auth *b2Authorization) interface{} {
+			return map[string]interface{}{
+				"sourceFileId": source.FileID,
+				"largeFileId":  largeFile.FileID,
+				"partNumber":   partNumber,
+				"range":        fmt.Sprintf("bytes=%d-%d", offset, end),
+			}
+		}, &part)
+		if err != nil {
+			s.cancelLargeFile(largeFile.FileID)
+			return fmt.Errorf("copying part %d: %w", partNumber, err)
+		}
+		partSHA1s = append(partSHA1s, part.ContentSHA1)
+	}
+
+	return s.finishLargeFile(ctx, largeFile.FileID, partSHA1s)
+}
+
+func (s *B2Store) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer classifyError(&err)
+	if _, err := s.head(ctx, s.ObjectPath(base)); err != nil {
+		if err == ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *B2Store) head(ctx context.Context, filePath string) (http.Header, error) {
+	resp, err := s.client.do(ctx, func(auth *b2Authorization) (*http.Request, error) {
+		return http.NewRequest(http.MethodHead, s.downloadURL(auth, filePath), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp.Header, nil
+}
+
+func (s *B2Store) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	defer classifyError(&err)
+	header, err := s.head(ctx, s.ObjectPath(base))
+	if err != nil {
+		return nil, err
+	}
+
+	size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	uploadTimestamp, _ := strconv.ParseInt(header.Get("X-Bz-Upload-Timestamp"), 10, 64)
+
+	fileInfo := map[string]string{}
+	for key := range header {
+		if strings.HasPrefix(key, "X-Bz-Info-") {
+			value, err := url.PathUnescape(header.Get(key))
+			if err != nil {
+				return nil, fmt.Errorf("invalid file info %q: %w", key, err)
+			}
+			fileInfo[strings.TrimPrefix(key, "X-Bz-Info-")] = value
+		}
+	}
+
+	return newB2ObjectAttrs(base, &b2File{
+		FileID:          header.Get("X-Bz-File-Id"),
+		ContentLength:   size,
+		ContentSHA1:     header.Get("X-Bz-Content-Sha1"),
+		FileInfo:        fileInfo,
+		UploadTimestamp: uploadTimestamp,
+	}), nil
+}
+
+// newB2ObjectAttrs uses the content SHA1 as ETag, falling back to the file ID
+// for large files which have none.
+func newB2ObjectAttrs(name string, file *b2File) *ObjectAttrs {
+	etag := strings.TrimPrefix(file.ContentSHA1, "unverified:")
+	if etag == "" || etag == "none" {
+		etag = file.FileID
+	}
+
+	metadata := map[string]string{}
+	for key, value := range file.FileInfo {
+		if !strings.HasPrefix(strings.ToLower(key), "b2-") {
+			metadata[key] = value
+		}
+	}
+
+	return &ObjectAttrs{
+		Name:         name,
+		Size:         file.ContentLength,
+		LastModified: time.Unix(0, file.UploadTimestamp*int64(time.Millisecond)),
+		ETag:         etag,
+		Metadata:     normalizeMetadata(metadata),
+	}
+}
+
+func (s *B2Store) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer classifyError(&err)
+	return renameObject(ctx, s, oldName, newName)
+}
+
+func (s *B2Store) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(name)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file", zap.String("path", path))
+	}
+
+	resp, err := s.client.do(ctx, func(auth *b2Authorization) (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, s.downloadURL(auth, path), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.uncompressedReader(ctx, s.resumeReads(ctx, name, resp.Body, s.openStoredRange))
+}
+
+func (s *B2Store) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	if s.decompressesReads() {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+	reader, err := s.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.throttleReads(ctx, reader), nil
+}
+
+func (s *B2Store) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	path := s.ObjectPath(name)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file range", zap.String("path", path), zap.Int64("offset", offset), zap.Int64("length", length))
+	}
+
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+
+	resp, err := s.client.do(ctx, func(auth *b2Authorization) (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, s.downloadURL(auth, path), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", byteRange)
+		return req, nil
+	})
+	if err != nil {
+		var b2Err *b2Error
+		if errors.As(err, &b2Err) && b2Err.Status == http.StatusRequestedRangeNotSatisfiable {
+			// The offset is past the end of the object
+			return ioutil.NopCloser(bytes.NewReader(nil)), nil
+		}
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *B2Store) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkBetween(ctx, prefix, startingPoint, "", f)
+}
+
+// WalkBetween seeks to `startingPoint` and stops at `endPoint` natively, file
+// names being listed in order from `startFileName`.
+func (s *B2Store) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkObjects(ctx, prefix, startingPoint, endPoint, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *B2Store) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *B2Store) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkObjects(ctx, prefix, "", "", f)
+}
+
+func (s *B2Store) walkObjects(ctx context.Context, prefix, startingPoint, endPoint string, f func(attrs *ObjectAttrs) (err error)) error {
+	targetPrefix := s.walkPrefix(prefix)
+
+	var startFileName string
+	if startingPoint != "" {
+		startFileName = s.walkPrefix("") + startingPoint
+	}
+
+	for {
+		if debugEnabled(s.logger) {
+			s.logger.Debug("walking files", zap.String("bucket", s.bucket), zap.String("prefix", targetPrefix), zap.String("start_file_name", startFileName))
+		}
+
+		list, err := s.listFileNames(ctx, targetPrefix, startFileName, "", b2MaxListCount)
+		if err != nil {
+			return err
+		}
+
+		var seekTo string
+		for _, file := range list.Files {
+			filename := s.toBaseName(file.FileName)
+			if filename == "" {
+				s.logger.Warn("got an empty filename from b2 store, ignoring it", zap.String("file_name", file.FileName))
+				continue
+			}
+			if endPoint != "" && filename >= endPoint {
+				return nil
+			}
+
+			if err := f(newB2ObjectAttrs(filename, file)); err != nil {
+				if err == StopIteration {
+					return nil
+				}
+				if skipped, ok := skippedPrefix(err); ok {
+					bound := prefixUpperBound(skipped)
+					if bound == "" {
+						return nil
+					}
+					if bound > filename {
+						seekTo = bound
+						break
+					}
+					continue
+				}
+				return fmt.Errorf("processing file list: %w", err)
+			}
+		}
+
+		switch {
+		case seekTo != "":
+			startFileName = s.walkPrefix("") + seekTo
+		case list.NextFileName != nil:
+			startFileName = *list.NextFileName
+		default:
+			return nil
+		}
+	}
+}
+
+func (s *B2Store) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer classifyError(&err)
+	return listFiles(ctx, s, prefix, max)
+}
+
+// ListFilesPage uses the `nextFileName` of the listing as page token.
+func (s *B2Store) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer classifyError(&err)
+	if pageSize > b2MaxListCount {
+		pageSize = b2MaxListCount
+	}
+
+	list, err := s.listFileNames(ctx, s.walkPrefix(prefix), pageToken, "", pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, file := range list.Files {
+		if filename := s.toBaseName(file.FileName); filename != "" {
+			files = append(files, filename)
+		}
+	}
+	if list.NextFileName != nil {
+		nextToken = *list.NextFileName
+	}
+	return files, nextToken, nil
+}
+
+func (s *B2Store) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer classifyError(&err)
+	targetPrefix := directoryPrefix(s.path, prefix)
+
+	var startFileName string
+	for {
+		list, err := s.listFileNames(ctx, targetPrefix, startFileName, "/", b2MaxListCount)
+		if err != nil {
+			return nil, fmt.Errorf("listing directories: %w", err)
+		}
+
+		for _, file := range list.Files {
+			if file.Action == "folder" {
+				out = append(out, relativeDirectory(s.path, file.FileName))
+			}
+		}
+
+		if list.NextFileName == nil {
+			return out, nil
+		}
+		startFileName = *list.NextFileName
+	}
+}
+
+// walkPrefix returns the full file name prefix to list for the given store
+// relative prefix.
+func (s *B2Store) walkPrefix(prefix string) string {
+	targetPrefix := s.path
+	if targetPrefix != "" {
+		targetPrefix += "/"
+	}
+	if prefix != "" {
+		targetPrefix = filepath.Join(targetPrefix, prefix)
+		if prefix[len(prefix)-1:] == "/" {
+			targetPrefix += "/"
+		}
+	}
+	return targetPrefix
+}
+
+func (s *B2Store) toBaseName(filename string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(filename, s.pathWithExt("")), s.path+"/")
+}
+
+// DeleteObject deletes every version of the object, B2 keeping previous
+// versions around when a file is overwritten.
+func (s *B2Store) DeleteObject(ctx context.Context, base string) (err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+
+	var versions struct {
+		Files []*b2File `json:"files"`
+	}
+	err = s.client.api(ctx, "b2_list_file_versions", func(auth *b2Authorization) interface{} {
+		return map[string]interface{}{
+			"bucketId":      auth.bucketID,
+			"startFileName": path,
+			"prefix":        path,
+			"maxFileCount":  b2MaxListCount,
+		}
+	}, &versions)
+	if err != nil {
+		return fmt.Errorf("listing file versions: %w", err)
+	}
+
+	deleted := 0
+	for _, version := range versions.Files {
+		if version.FileName != path {
+			continue
+		}
+
+		err := s.client.api(ctx, "b2_delete_file_version", func(auth *b2Authorization) interface{} {
+			return map[string]interface{}{"fileName": path, "fileId": version.FileID}
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("deleting file version %q: %w", version.FileID, err)
+		}
+		deleted++
+	}
+
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *B2Store) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer classifyError(&err)
+	return deleteObjectsConcurrently(ctx, names, deleteObjectsConcurrency, func(ctx context.Context, name string) error {
+		if err := s.DeleteObject(ctx, name); err != nil && err != ErrNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *B2Store) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer classifyError(&err)
+	return deletePrefix(ctx, s, prefix, deletePrefixConcurrency)
+}
+
+func (s *B2Store) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer classifyError(&err)
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+//
+// B2 API client
+//
+
+// b2MinPartSize is the smallest part accepted by B2 for all but the last part
+// of a large file.
+const b2MinPartSize = 5 * 1024 * 1024
+
+type b2Authorization struct {
+	AccountID           string `json:"accountId"`
+	AuthorizationToken  string `json:"authorizationToken"`
+	APIURL              string `json:"apiUrl"`
+	DownloadURL         string `json:"downloadUrl"`
+	RecommendedPartSize int64  `json:"recommendedPartSize"`
+	Allowed             struct {
+		BucketID   string `json:"bucketId"`
+		BucketName string `json:"bucketName"`
+	} `json:"allowed"`
+
+	bucketID string
+}
+
+type b2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *b2Error) Error() string {
+	return fmt.Sprintf("b2 error %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// b2ErrorClass returns the class of the errors answered by B2.
+func b2ErrorClass(err error) error {
+	var b2Err *b2Error
+	if errors.As(err, &b2Err) {
+		return statusClass(b2Err.Status)
+	}
+	return nil
+}
+
+// retryable tells whether B2 asks clients to retry the request, like on its
+// `503 Service Unavailable` answers when a storage pod is at capacity.
+func (e *b2Error) retryable() bool {
+	switch e.Status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+// newB2Error reads the error out of a failed response and closes it.
+func newB2Error(resp *http.Response) *b2Error {
+	defer resp.Body.Close()
+
+	out := &b2Error{}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil || out.Status == 0 {
+		out.Status = resp.StatusCode
+		out.Code = http.StatusText(resp.StatusCode)
+	}
+	return out
+}
+
+type b2UploadURL struct {
+	UploadURL          string `json:"uploadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+// b2UploadURLPool keeps the upload URLs that are not in use. B2 expects each
+// concurrent upload to use its own upload URL, and a new one to be fetched
+// after any failure, so URLs are only returned to the pool after a success.
+type b2UploadURLPool struct {
+	lock  sync.Mutex
+	idle  []*b2UploadURL
+	fetch func(ctx context.Context) (*b2UploadURL, error)
+}
+
+func newB2UploadURLPool(fetch func(ctx context.Context) (*b2UploadURL, error)) *b2UploadURLPool {
+	return &b2UploadURLPool{fetch: fetch}
+}
+
+func (p *b2UploadURLPool) get(ctx context.Context) (*b2UploadURL, error) {
+	p.lock.Lock()
+	if len(p.idle) > 0 {
+		uploadURL := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.lock.Unlock()
+		return uploadURL, nil
+	}
+	p.lock.Unlock()
+
+	uploadURL, err := p.fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting upload url: %w", err)
+	}
+	return uploadURL, nil
+}
+
+func (p *b2UploadURLPool) put(uploadURL *b2UploadURL) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.idle = append(p.idle, uploadURL)
+}
+
+type b2Client struct {
+	httpClient *http.Client
+	logger     *zap.Logger
+	keyID      string
+	key        string
+	bucketName string
+
+	lock sync.Mutex
+	auth *b2Authorization
+
+	uploadURLs *b2UploadURLPool
+}
+
+func newB2Client(httpClient *http.Client, logger *zap.Logger, keyID, key, bucketName string) *b2Client {
+	c := &b2Client{
+		httpClient: httpClient,
+		logger:     logger,
+		keyID:      keyID,
+		key:        key,
+		bucketName: bucketName,
+	}
+	c.uploadURLs = newB2UploadURLPool(func(ctx context.Context) (*b2UploadURL, error) {
+		out := &b2UploadURL{}
+		err := c.api(ctx, "b2_get_upload_url", func(auth *b2Authorization) interface{} {
+			return map[string]interface{}{"bucketId": auth.bucketID}
+		}, out)
+		return out, err
+	})
+	return c
+}
+
+// authorization returns the current account authorization, authorizing the
+// account and resolving the bucket ID the first time or after the previous
+// one expired.
+func (c *b2Client) authorization(ctx context.Context) (*b2Authorization, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.auth != nil {
+		return c.auth, nil
+	}
+
+	resp, err := c.retry(ctx, (*b2Error).retryable, func() (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, b2APIURL+"/b2api/v2/b2_authorize_account", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(c.keyID, c.key)
+		return c.httpClient.Do(req.WithContext(ctx))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("authorizing b2 account: %w", err)
+	}
+	defer resp.Body.Close()
+
+	auth := &b2Authorization{}
+	if err := json.NewDecoder(resp.Body).Decode(auth); err != nil {
+		return nil, fmt.Errorf("decoding b2 account authorization: %w", err)
+	}
+
+	auth.bucketID = auth.Allowed.BucketID
+	if auth.bucketID == "" || auth.Allowed.BucketName != c.bucketName {
+		var buckets struct {
+			Buckets []struct {
+				BucketID string `json:"bucketId"`
+			} `json:"buckets"`
+		}
+		request := map[string]interface{}{"accountId": auth.AccountID, "bucketName": c.bucketName}
+		if err := c.call(ctx, auth, "b2_list_buckets", request, &buckets); err != nil {
+			return nil, fmt.Errorf("resolving bucket %q: %w", c.bucketName, err)
+		}
+		if len(buckets.Buckets) == 0 {
+			return nil, fmt.Errorf("bucket %q not found", c.bucketName)
+		}
+		auth.bucketID = buckets.Buckets[0].BucketID
+	}
+
+	c.auth = auth
+	return auth, nil
+}
+
+func (c *b2Client) expire(auth *b2Authorization) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.auth == auth {
+		c.auth = nil
+	}
+}
+
+// retry runs `send` until it succeeds, retrying network failures and the
+// errors accepted by `retryable` with an exponential backoff, honoring the
+// `Retry-After` header. The caller must close the returned response.
+func (c *b2Client) retry(ctx context.Context, retryable func(*b2Error) bool, send func() (*http.Response, error)) (*http.Response, error) {
+	delay := b2RetryDelay
+	for attempt := 1; ; attempt++ {
+		resp, err := send()
+		if err == nil {
+			if resp.StatusCode < 300 {
+				return resp, nil
+			}
+
+			b2Err := newB2Error(resp)
+			if !retryable(b2Err) {
+				return nil, b2Err
+			}
+			err = b2Err
+
+			if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+				delay = time.Duration(seconds) * time.Second
+			}
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if attempt >= b2MaxAttempts {
+			return nil, err
+		}
+
+		c.logger.Debug("retrying b2 request", zap.Int("attempt", attempt), zap.Duration("delay", delay), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// do runs the request built by `newRequest` with the account authorization,
+// retrying transient errors and authorizing again once on a 401, the token
+// having expired, which body-less HEAD answers can't tell. A 404 is reported
+// as `ErrNotFound`.
+func (c *b2Client) do(ctx context.Context, newRequest func(auth *b2Authorization) (*http.Request, error)) (*http.Response, error) {
+	reauthorized := false
+	for {
+		auth, err := c.authorization(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.retry(ctx, (*b2Error).retryable, func() (*http.Response, error) {
+			req, err := newRequest(auth)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", auth.AuthorizationToken)
+			return c.httpClient.Do(req.WithContext(ctx))
+		})
+
+		var b2Err *b2Error
+		if errors.As(err, &b2Err) {
+			if b2Err.Status == http.StatusUnauthorized && !reauthorized {
+				c.expire(auth)
+				reauthorized = true
+				continue
+			}
+			if b2Err.Status == http.StatusNotFound {
+				return nil, ErrNotFound
+			}
+		}
+		return resp, err
+	}
+}
+
+// api calls the `operation` API with the request built by `newRequest`,
+// decoding the JSON response into `out` when not nil.
+func (c *b2Client) api(ctx context.Context, operation string, newRequest func(auth *b2Authorization) interface{}, out interface{}) error {
+	resp, err := c.do(ctx, func(auth *b2Authorization) (*http.Request, error) {
+		body, err := json.Marshal(newRequest(auth))
+		if err != nil {
+			return nil, err
+		}
+		return http.NewRequest(http.MethodPost, auth.APIURL+"/b2api/v2/"+operation, bytes.NewReader(body))
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// call is `api` with a given authorization, used while authorizing.
+func (c *b2Client) call(ctx context.Context, auth *b2Authorization, operation string, request, out interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.retry(ctx, (*b2Error).retryable, func() (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodPost, auth.APIURL+"/b2api/v2/"+operation, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", auth.AuthorizationToken)
+		return c.httpClient.Do(req.WithContext(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// upload sends `data` to an upload URL taken from `pool`. Any failed attempt
+// drops its upload URL, as B2 requires, and is retried with a fresh one, an
+// expired upload URL included.
+func (c *b2Client) upload(ctx context.Context, pool *b2UploadURLPool, headers map[string]string, data []byte) error {
+	retryable := func(err *b2Error) bool {
+		return err.retryable() || err.Status == http.StatusUnauthorized
+	}
+
+	var uploadURL *b2UploadURL
+	resp, err := c.retry(ctx, retryable, func() (*http.Response, error) {
+		var err error
+		if uploadURL, err = pool.get(ctx); err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, uploadURL.UploadURL, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = int64(len(data))
+		req.Header.Set("Authorization", uploadURL.AuthorizationToken)
+		req.Header.Set("X-Bz-Content-Sha1", b2SHA1(data))
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		return c.httpClient.Do(req.WithContext(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	pool.put(uploadURL)
+	return nil
+}
+
+func b2SHA1(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// b2EscapeName percent-encodes file names and file info values the way B2
+// expects them in headers and download URLs.
+func b2EscapeName(name string) string {
+	var out strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte("._-/~!$'()*;=:@", c) >= 0 {
+			out.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&out, "%%%02X", c)
+	}
+	return out.String()
+}
@@ -0,0 +1,444 @@
+//go:build !dstore_no_b2
+
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeB2 is an in-memory B2 server implementing the subset of the native API
+// used by the store.
+type fakeB2 struct {
+	*httptest.Server
+
+	lock          sync.Mutex
+	files         map[string]*fakeB2File
+	largeFiles    map[string]*fakeB2File
+	nextID        int
+	authorizes    int
+	uploadURLs    int
+	failUploads   int
+	expireTokens  int
+	currentToken  string
+	uploadedParts int
+}
+
+type fakeB2File struct {
+	b2File
+	content []byte
+	parts   map[int][]byte
+}
+
+func newFakeB2(t *testing.T) *fakeB2 {
+	f := &fakeB2{files: map[string]*fakeB2File{}, largeFiles: map[string]*fakeB2File{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+
+	previousURL, previousDelay := b2APIURL, b2RetryDelay
+	b2APIURL, b2RetryDelay = f.URL, time.Millisecond
+	t.Cleanup(func() { b2APIURL, b2RetryDelay = previousURL, previousDelay })
+
+	os.Setenv("B2_APPLICATION_KEY_ID", "key-id")
+	os.Setenv("B2_APPLICATION_KEY", "key")
+	t.Cleanup(func() {
+		os.Unsetenv("B2_APPLICATION_KEY_ID")
+		os.Unsetenv("B2_APPLICATION_KEY")
+	})
+
+	return f
+}
+
+func (f *fakeB2) fail(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "code": code, "message": code})
+}
+
+func (f *fakeB2) reply(w http.ResponseWriter, out interface{}) {
+	json.NewEncoder(w).Encode(out)
+}
+
+func (f *fakeB2) newID() string {
+	f.nextID++
+	return fmt.Sprintf("id-%d", f.nextID)
+}
+
+func (f *fakeB2) serve(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.URL.Path == "/b2api/v2/b2_authorize_account" {
+		if user, pass, _ := r.BasicAuth(); user != "key-id" || pass != "key" {
+			f.fail(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		f.authorizes++
+		f.currentToken = fmt.Sprintf("token-%d", f.authorizes)
+		f.reply(w, map[string]interface{}{
+			"accountId":           "account",
+			"authorizationToken":  f.currentToken,
+			"apiUrl":              f.URL,
+			"downloadUrl":         f.URL,
+			"recommendedPartSize": 100 * 1024 * 1024,
+			"allowed":             map[string]interface{}{"bucketId": nil, "bucketName": nil},
+		})
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/upload/") {
+		f.serveUpload(w, r)
+		return
+	}
+
+	if r.Header.Get("Authorization") != f.currentToken {
+		f.fail(w, http.StatusUnauthorized, "bad_auth_token")
+		return
+	}
+	if f.expireTokens > 0 {
+		f.expireTokens--
+		f.fail(w, http.StatusUnauthorized, "expired_auth_token")
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/file/bucket/") {
+		f.serveDownload(w, r, strings.TrimPrefix(r.URL.Path, "/file/bucket/"))
+		return
+	}
+
+	var request struct {
+		BucketName        string            `json:"bucketName"`
+		FileName          string            `json:"fileName"`
+		FileID            string            `json:"fileId"`
+		SourceFileID      string            `json:"sourceFileId"`
+		ContentType       string            `json:"contentType"`
+		FileInfo          map[string]string `json:"fileInfo"`
+		Prefix            string            `json:"prefix"`
+		StartFileName     string            `json:"startFileName"`
+		Delimiter         string            `json:"delimiter"`
+		MaxFileCount      int               `json:"maxFileCount"`
+		PartSHA1Array     []string          `json:"partSha1Array"`
+		MetadataDirective string            `json:"metadataDirective"`
+	}
+	json.NewDecoder(r.Body).Decode(&request)
+
+	switch strings.TrimPrefix(r.URL.Path, "/b2api/v2/") {
+	case "b2_list_buckets":
+		if request.BucketName != "bucket" {
+			f.reply(w, map[string]interface{}{"buckets": []interface{}{}})
+			return
+		}
+		f.reply(w, map[string]interface{}{"buckets": []interface{}{map[string]interface{}{"bucketId": "bucket-id"}}})
+
+	case "b2_get_upload_url":
+		f.uploadURLs++
+		f.reply(w, map[string]interface{}{"uploadUrl": f.URL + "/upload/file", "authorizationToken": "upload-" + f.newID()})
+
+	case "b2_get_upload_part_url":
+		f.uploadURLs++
+		f.reply(w, map[string]interface{}{"uploadUrl": f.URL + "/upload/part/" + request.FileID, "authorizationToken": "upload-" + f.newID()})
+
+	case "b2_start_large_file":
+		file := &fakeB2File{b2File: b2File{FileID: f.newID(), FileName: request.FileName, ContentType: request.ContentType, FileInfo: request.FileInfo}, parts: map[int][]byte{}}
+		f.largeFiles[file.FileID] = file
+		f.reply(w, file.b2File)
+
+	case "b2_finish_large_file":
+		file := f.largeFiles[request.FileID]
+		delete(f.largeFiles, request.FileID)
+		for i := 1; i <= len(request.PartSHA1Array); i++ {
+			file.content = append(file.content, file.parts[i]...)
+		}
+		f.store(file)
+		f.reply(w, file.b2File)
+
+	case "b2_cancel_large_file":
+		delete(f.largeFiles, request.FileID)
+		f.reply(w, map[string]interface{}{})
+
+	case "b2_copy_file":
+		for _, file := range f.files {
+			if file.FileID == request.SourceFileID {
+				copied := &fakeB2File{b2File: file.b2File, content: file.content}
+				copied.FileName = request.FileName
+				f.store(copied)
+				f.reply(w, copied.b2File)
+				return
+			}
+		}
+		f.fail(w, http.StatusBadRequest, "bad_request")
+
+	case "b2_list_file_names", "b2_list_file_versions":
+		f.serveList(w, request.Prefix, request.StartFileName, request.Delimiter, request.MaxFileCount)
+
+	case "b2_delete_file_version":
+		file := f.files[request.FileName]
+		if file == nil || file.FileID != request.FileID {
+			f.fail(w, http.StatusBadRequest, "file_not_present")
+			return
+		}
+		delete(f.files, request.FileName)
+		f.reply(w, map[string]interface{}{"fileId": request.FileID, "fileName": request.FileName})
+
+	case "b2_get_download_authorization":
+		f.reply(w, map[string]interface{}{"authorizationToken": "download-token"})
+
+	default:
+		f.fail(w, http.StatusBadRequest, "bad_request")
+	}
+}
+
+func (f *fakeB2) store(file *fakeB2File) {
+	file.FileID = f.newID()
+	file.Action = "upload"
+	file.ContentLength = int64(len(file.content))
+	file.UploadTimestamp = 1600000000000
+	f.files[file.FileName] = file
+}
+
+func (f *fakeB2) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if f.failUploads > 0 {
+		f.failUploads--
+		f.fail(w, http.StatusServiceUnavailable, "service_unavailable")
+		return
+	}
+
+	content, _ := ioutil.ReadAll(r.Body)
+	if r.Header.Get("X-Bz-Content-Sha1") != b2SHA1(content) {
+		f.fail(w, http.StatusBadRequest, "bad_request")
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/upload/part/") {
+		partNumber, _ := strconv.Atoi(r.Header.Get("X-Bz-Part-Number"))
+		f.largeFiles[strings.TrimPrefix(r.URL.Path, "/upload/part/")].parts[partNumber] = content
+		f.uploadedParts++
+		f.reply(w, map[string]interface{}{})
+		return
+	}
+
+	name, _ := url.PathUnescape(r.Header.Get("X-Bz-File-Name"))
+	file := &fakeB2File{b2File: b2File{FileName: name, ContentSHA1: b2SHA1(content), FileInfo: map[string]string{}}, content: content}
+	for key := range r.Header {
+		if strings.HasPrefix(key, "X-Bz-Info-") {
+			value, _ := url.PathUnescape(r.Header.Get(key))
+			file.FileInfo[strings.ToLower(strings.TrimPrefix(key, "X-Bz-Info-"))] = value
+		}
+	}
+	f.store(file)
+	f.reply(w, file.b2File)
+}
+
+func (f *fakeB2) serveDownload(w http.ResponseWriter, r *http.Request, name string) {
+	file := f.files[name]
+	if file == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("X-Bz-File-Id", file.FileID)
+	w.Header().Set("X-Bz-Content-Sha1", file.ContentSHA1)
+	w.Header().Set("X-Bz-Upload-Timestamp", strconv.FormatInt(file.UploadTimestamp, 10))
+	for key, value := range file.FileInfo {
+		w.Header().Set("X-Bz-Info-"+key, url.PathEscape(value))
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(file.content))
+}
+
+func (f *fakeB2) serveList(w http.ResponseWriter, prefix, startFileName, delimiter string, maxFileCount int) {
+	var names []string
+	for name := range f.files {
+		if strings.HasPrefix(name, prefix) && name >= startFileName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	out := &b2FileList{}
+	for _, name := range names {
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				folder := name[:len(prefix)+i+1]
+				if n := len(out.Files); n == 0 || out.Files[n-1].FileName != folder {
+					out.Files = append(out.Files, &b2File{FileName: folder, Action: "folder"})
+				}
+				continue
+			}
+		}
+		if len(out.Files) == maxFileCount {
+			next := name
+			out.NextFileName = &next
+			break
+		}
+		out.Files = append(out.Files, &f.files[name].b2File)
+	}
+	f.reply(w, out)
+}
+
+func newTestB2Store(t *testing.T, opts ...Option) *B2Store {
+	base, err := url.Parse("b2://bucket/path")
+	require.NoError(t, err)
+
+	store, err := NewB2StoreWithOptions(base, opts...)
+	require.NoError(t, err)
+	return store
+}
+
+func TestB2Store_WriteObject(t *testing.T) {
+	fake := newFakeB2(t)
+	store := newTestB2Store(t, AllowOverwrite())
+	ctx := context.Background()
+
+	require.NoError(t, store.WriteObject(ctx, "0001", bytes.NewReader([]byte("content")), WithMetadata(map[string]string{"Block_Range": "1-2 3"})))
+
+	reader, err := store.OpenObject(ctx, "0001")
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, "content", string(content))
+
+	reader, err = store.OpenObjectRange(ctx, "0001", 2, 3)
+	require.NoError(t, err)
+	content, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, "nte", string(content))
+
+	attrs, err := store.ObjectAttributes(ctx, "0001")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), attrs.Size)
+	assert.Equal(t, b2SHA1([]byte("content")), attrs.ETag)
+	assert.Equal(t, map[string]string{"block_range": "1-2 3"}, attrs.Metadata)
+
+	_, err = store.OpenObject(ctx, "0002")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, 1, fake.authorizes)
+}
+
+func TestB2Store_WriteObject_RetriesWithNewUploadURL(t *testing.T) {
+	fake := newFakeB2(t)
+	store := newTestB2Store(t)
+	ctx := context.Background()
+
+	fake.failUploads = 2
+	require.NoError(t, store.WriteObject(ctx, "0001", bytes.NewReader([]byte("content"))))
+	assert.Equal(t, 3, fake.uploadURLs, "each failed upload should drop its upload url")
+
+	require.NoError(t, store.WriteObject(ctx, "0002", bytes.NewReader([]byte("content"))))
+	assert.Equal(t, 3, fake.uploadURLs, "successful upload url should be reused")
+}
+
+func TestB2Store_ExpiredAuthorization(t *testing.T) {
+	fake := newFakeB2(t)
+	store := newTestB2Store(t)
+	ctx := context.Background()
+
+	require.NoError(t, store.WriteObject(ctx, "0001", bytes.NewReader([]byte("content"))))
+	fake.expireTokens = 1
+
+	exists, err := store.FileExists(ctx, "0001")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, 2, fake.authorizes)
+}
+
+func TestB2Store_WriteObject_LargeFile(t *testing.T) {
+	fake := newFakeB2(t)
+	store := newTestB2Store(t, MultipartThreshold(b2MinPartSize))
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("0123456789"), 2*b2MinPartSize/10+1)
+	require.NoError(t, store.WriteObject(ctx, "large", bytes.NewReader(content)))
+	assert.Equal(t, 3, fake.uploadedParts)
+
+	reader, err := store.OpenObject(ctx, "large")
+	require.NoError(t, err)
+	read, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, content, read)
+
+	// Exactly one part is uploaded as a regular file
+	require.NoError(t, store.WriteObject(ctx, "single", bytes.NewReader(content[:b2MinPartSize])))
+	assert.Equal(t, 3, fake.uploadedParts)
+}
+
+func TestB2Store_Walk(t *testing.T) {
+	newFakeB2(t)
+	store := newTestB2Store(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"a/0001", "a/0002", "b/0001", "b/0002", "c"} {
+		require.NoError(t, store.WriteObject(ctx, name, bytes.NewReader([]byte(name))))
+	}
+
+	var walked []string
+	require.NoError(t, store.WalkBetween(ctx, "", "a/0002", "b/0002", func(filename string) error {
+		walked = append(walked, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{"a/0002", "b/0001"}, walked)
+
+	walked = nil
+	require.NoError(t, store.Walk(ctx, "", func(filename string) error {
+		walked = append(walked, filename)
+		if filename == "a/0001" {
+			return SkipPrefix("a/")
+		}
+		return nil
+	}))
+	assert.Equal(t, []string{"a/0001", "b/0001", "b/0002", "c"}, walked)
+
+	directories, err := store.ListDirectories(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, directories)
+
+	files, next, err := store.ListFilesPage(ctx, "", 3, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/0001", "a/0002", "b/0001"}, files)
+	files, next, err = store.ListFilesPage(ctx, "", 3, next)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/0002", "c"}, files)
+	assert.Equal(t, "", next)
+
+	require.NoError(t, store.RenameObject(ctx, "c", "d"))
+	exists, err := store.FileExists(ctx, "c")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.Equal(t, ErrNotFound, store.DeleteObject(ctx, "c"))
+	deleted, err := store.DeletePrefix(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, 5, deleted)
+}
+
+func TestB2Store_ErrorClasses(t *testing.T) {
+	assertErrorClass(t, &b2Error{Status: http.StatusTooManyRequests}, ErrRateLimited)
+	assertErrorClass(t, &b2Error{Status: http.StatusUnauthorized}, ErrPermissionDenied)
+	assert.True(t, IsRetryableError(&b2Error{Status: http.StatusServiceUnavailable}))
+}
+
+func TestB2Store_PresignGet(t *testing.T) {
+	fake := newFakeB2(t)
+	store := newTestB2Store(t)
+
+	signed, err := store.PresignGet(context.Background(), "dir/0001 b", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, fake.URL+"/file/bucket/path/dir/0001%20b?Authorization=download-token", signed)
+}
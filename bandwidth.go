@@ -0,0 +1,110 @@
+package dstore
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strconv"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// bandwidthLimits are the token buckets throttling the bytes read and written
+// by a store and its sub stores, nil when unlimited.
+type bandwidthLimits struct {
+	read, write *rate.Limiter
+}
+
+// newBandwidthLimits returns the limits configured by the `read_bandwidth` and
+// `write_bandwidth` query parameters of `baseURL` or the options, the limits
+// of the parent store for sub stores, nil when unlimited.
+func newBandwidthLimits(baseURL *url.URL, config *config) *bandwidthLimits {
+	if config.bandwidth != nil {
+		return config.bandwidth
+	}
+
+	logger := loggerOrDefault(config.logger)
+	read := bandwidthParam(logger, baseURL, "read_bandwidth", config.readBandwidth)
+	write := bandwidthParam(logger, baseURL, "write_bandwidth", config.writeBandwidth)
+	if read <= 0 && write <= 0 {
+		return nil
+	}
+
+	return &bandwidthLimits{
+		read:  newRateLimiter(float64(read), rateLimitMinBytesBurst),
+		write: newRateLimiter(float64(write), rateLimitMinBytesBurst),
+	}
+}
+
+func bandwidthParam(logger *zap.Logger, baseURL *url.URL, name string, bytesPerSecond int64) int64 {
+	param := baseURL.Query().Get(name)
+	if param == "" {
+		return bytesPerSecond
+	}
+
+	parsed, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		logger.Warn("ignoring invalid bandwidth query parameter", zap.Stringer("base_url", baseURL), zap.String(name, param))
+		return bytesPerSecond
+	}
+	return parsed
+}
+
+// sharedBandwidth makes sub stores share the limits of their parent.
+func sharedBandwidth(limits *bandwidthLimits) Option {
+	return optionFunc(func(config *config) {
+		config.bandwidth = limits
+	})
+}
+
+// throttleReads consumes the read tokens of the store for every byte read
+// from `reader`.
+func (c *commonStore) throttleReads(ctx context.Context, reader io.ReadCloser) io.ReadCloser {
+	if c.bandwidth == nil || c.bandwidth.read == nil {
+		return reader
+	}
+	return &readCloser{Reader: newRateLimitedReader(ctx, reader, c.bandwidth.read), Closer: reader}
+}
+
+// limitsWrites returns whether the bytes written by the store are throttled.
+func (c *commonStore) limitsWrites() bool {
+	return c.bandwidth != nil && c.bandwidth.write != nil
+}
+
+// throttleWrites consumes the write tokens of the store for every byte written
+// to `w`.
+func (c *commonStore) throttleWrites(ctx context.Context, w io.Writer) io.Writer {
+	if !c.limitsWrites() {
+		return w
+	}
+	return &rateLimitedWriter{ctx: ctx, writer: w, limiter: c.bandwidth.write}
+}
+
+// rateLimitedWriter consumes tokens of `limiter` for every byte written, in
+// writes of at most the burst of the limiter.
+type rateLimitedWriter struct {
+	ctx     context.Context
+	writer  io.Writer
+	limiter *rate.Limiter
+}
+
+func (w *rateLimitedWriter) Write(p []byte) (written int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > w.limiter.Burst() {
+			chunk = chunk[:w.limiter.Burst()]
+		}
+		if err := w.limiter.WaitN(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+
+		n, err := w.writer.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
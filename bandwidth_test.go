@@ -0,0 +1,59 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidth(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(ReadBandwidth(100*1024), WriteBandwidth(100*1024))
+
+	// The first burst of 100KiB is free, the next 50KiB take half a second
+	content := make([]byte, 150*1024)
+	start := time.Now()
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader(content)))
+	assert.InDelta(t, 500*time.Millisecond, time.Since(start), float64(200*time.Millisecond))
+
+	start = time.Now()
+	read, err := ReadObject(ctx, store, "file")
+	require.NoError(t, err)
+	assert.Len(t, read, len(content))
+	assert.InDelta(t, 500*time.Millisecond, time.Since(start), float64(200*time.Millisecond))
+
+	// Sub stores share the limits of their parent, the read tokens being
+	// exhausted by the previous read
+	sub, err := store.SubStore("")
+	require.NoError(t, err)
+	start = time.Now()
+	reader, err := sub.OpenObjectRange(ctx, "file", 0, 50*1024)
+	require.NoError(t, err)
+	read, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Len(t, read, 50*1024)
+	assert.InDelta(t, 500*time.Millisecond, time.Since(start), float64(200*time.Millisecond))
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Error(t, sub.WriteObject(canceled, "other", bytes.NewReader(content)))
+}
+
+func TestNewBandwidthLimits(t *testing.T) {
+	baseURL, err := url.Parse("memory:///?read_bandwidth=1000&write_bandwidth=invalid")
+	require.NoError(t, err)
+
+	limits := newBandwidthLimits(baseURL, newConfig([]Option{WriteBandwidth(2000)}))
+	require.NotNil(t, limits)
+	assert.Equal(t, 1000.0, float64(limits.read.Limit()))
+	assert.Equal(t, 2000.0, float64(limits.write.Limit()))
+
+	assert.Nil(t, newBandwidthLimits(&url.URL{Scheme: "memory"}, newConfig(nil)))
+}
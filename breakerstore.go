@@ -0,0 +1,313 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//
+// Circuit Breaker Store
+//
+
+// ErrCircuitOpen is returned by `CircuitBreakerStore` without reaching the
+// backend while it is considered down.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitBreakerPolicy configures a `CircuitBreakerStore`, zero values picking
+// the defaults.
+type CircuitBreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failures opening the
+	// circuit, 5 by default.
+	FailureThreshold int
+	// CoolDown is how long the circuit stays open before a single operation
+	// is let through to probe the backend, 30s by default.
+	CoolDown time.Duration
+	// IsFailure tells whether an error is a failure of the backend,
+	// `IsRetryableError` by default so that missing objects don't count.
+	IsFailure func(err error) bool
+}
+
+func (p CircuitBreakerPolicy) withDefaults() CircuitBreakerPolicy {
+	if p.FailureThreshold <= 0 {
+		p.FailureThreshold = 5
+	}
+	if p.CoolDown <= 0 {
+		p.CoolDown = 30 * time.Second
+	}
+	if p.IsFailure == nil {
+		p.IsFailure = IsRetryableError
+	}
+	return p
+}
+
+// CircuitBreakerStore is a `Store` failing fast with `ErrCircuitOpen` once the
+// inner store failed `FailureThreshold` times in a row, for the `CoolDown` of
+// the policy, so that an outage of the backend doesn't pile up slow failing
+// requests. The operation let through after the cool-down closes the circuit
+// when it succeeds and opens it again when it fails. Sub stores share the
+// circuit of their parent.
+type CircuitBreakerStore struct {
+	// Store is the inner store, receiving the operations while the circuit is
+	// closed.
+	Store
+
+	breaker *circuitBreaker
+}
+
+func NewCircuitBreakerStore(inner Store, policy CircuitBreakerPolicy) *CircuitBreakerStore {
+	return &CircuitBreakerStore{
+		Store:   inner,
+		breaker: &circuitBreaker{policy: policy.withDefaults(), logger: storeLogger(inner)},
+	}
+}
+
+type circuitBreaker struct {
+	policy CircuitBreakerPolicy
+	logger *zap.Logger
+
+	lock     sync.Mutex
+	failures int
+	// openUntil is the end of the cool-down, zero while the circuit is closed.
+	openUntil time.Time
+	// probing is set while the operation let through after the cool-down
+	// runs.
+	probing bool
+}
+
+// allow returns `ErrCircuitOpen` when the operation must not reach the
+// backend.
+func (b *circuitBreaker) allow() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if remaining := time.Until(b.openUntil); remaining > 0 || b.probing {
+		if remaining < 0 {
+			remaining = 0
+		}
+		return fmt.Errorf("backend failing, retry in %s: %w", remaining.Round(time.Millisecond), ErrCircuitOpen)
+	}
+
+	b.probing = true
+	return nil
+}
+
+// record updates the circuit with the outcome of an allowed operation.
+func (b *circuitBreaker) record(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// Canceled operations tell nothing about the backend
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		b.probing = false
+		return
+	}
+
+	if err == nil || !b.policy.IsFailure(err) {
+		if !b.openUntil.IsZero() {
+			b.logger.Info("backend recovered, closing circuit")
+		}
+		b.failures, b.openUntil, b.probing = 0, time.Time{}, false
+		return
+	}
+
+	b.failures++
+	if b.probing || b.failures >= b.policy.FailureThreshold {
+		b.logger.Warn("backend failing, opening circuit", zap.Int("failures", b.failures), zap.Duration("cool_down", b.policy.CoolDown), zap.Error(err))
+		b.openUntil, b.probing = time.Now().Add(b.policy.CoolDown), false
+	}
+}
+
+// call runs `f` when the circuit allows it, recording its outcome.
+func (s *CircuitBreakerStore) call(f func() error) error {
+	if err := s.breaker.allow(); err != nil {
+		return err
+	}
+
+	err := f()
+	s.breaker.record(err)
+	return err
+}
+
+func (s *CircuitBreakerStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := *s
+	sub.Store = inner
+	return &sub, nil
+}
+
+func (s *CircuitBreakerStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	err = s.call(func() (err error) {
+		out, err = s.Store.OpenObject(ctx, name)
+		return err
+	})
+	return out, err
+}
+
+func (s *CircuitBreakerStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	err = s.call(func() (err error) {
+		out, err = s.Store.OpenObjectRange(ctx, name, offset, length)
+		return err
+	})
+	return out, err
+}
+
+func (s *CircuitBreakerStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	err = s.call(func() (err error) {
+		exists, err = s.Store.FileExists(ctx, base)
+		return err
+	})
+	return exists, err
+}
+
+func (s *CircuitBreakerStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	err = s.call(func() (err error) {
+		attrs, err = s.Store.ObjectAttributes(ctx, base)
+		return err
+	})
+	return attrs, err
+}
+
+func (s *CircuitBreakerStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	return s.call(func() error {
+		return s.Store.WriteObject(ctx, base, f, opts...)
+	})
+}
+
+func (s *CircuitBreakerStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+func (s *CircuitBreakerStore) CopyObject(ctx context.Context, src, dst string) error {
+	return s.call(func() error {
+		return s.Store.CopyObject(ctx, src, dst)
+	})
+}
+
+func (s *CircuitBreakerStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	return s.call(func() error {
+		return s.Store.RenameObject(ctx, oldName, newName)
+	})
+}
+
+func (s *CircuitBreakerStore) DeleteObject(ctx context.Context, base string) error {
+	return s.call(func() error {
+		return s.Store.DeleteObject(ctx, base)
+	})
+}
+
+func (s *CircuitBreakerStore) DeleteObjects(ctx context.Context, names []string) error {
+	return s.call(func() error {
+		return s.Store.DeleteObjects(ctx, names)
+	})
+}
+
+func (s *CircuitBreakerStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	err = s.call(func() (err error) {
+		deleted, err = s.Store.DeletePrefix(ctx, prefix)
+		return err
+	})
+	return deleted, err
+}
+
+func (s *CircuitBreakerStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
+	return s.walk(f, func(f func(filename string) error) error {
+		return s.Store.WalkFrom(ctx, prefix, startingPoint, f)
+	})
+}
+
+func (s *CircuitBreakerStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error {
+	return s.walk(f, func(f func(filename string) error) error {
+		return s.Store.WalkBetween(ctx, prefix, startingPoint, endPoint, f)
+	})
+}
+
+func (s *CircuitBreakerStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error {
+	return s.walk(f, func(f func(filename string) error) error {
+		return s.Store.Walk(ctx, prefix, f)
+	})
+}
+
+// walk runs the walk of the inner store, the errors returned by `f` not being
+// recorded as failures of the backend.
+func (s *CircuitBreakerStore) walk(f func(filename string) error, walk func(f func(filename string) error) error) error {
+	if err := s.breaker.allow(); err != nil {
+		return err
+	}
+
+	var callbackErr error
+	err := walk(func(filename string) error {
+		if err := f(filename); err != nil {
+			callbackErr = err
+			return err
+		}
+		return nil
+	})
+	if callbackErr != nil {
+		s.breaker.record(nil)
+	} else {
+		s.breaker.record(err)
+	}
+	return err
+}
+
+func (s *CircuitBreakerStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) error {
+	if err := s.breaker.allow(); err != nil {
+		return err
+	}
+
+	var callbackErr error
+	err := s.Store.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		if err := f(attrs); err != nil {
+			callbackErr = err
+			return err
+		}
+		return nil
+	})
+	if callbackErr != nil {
+		s.breaker.record(nil)
+	} else {
+		s.breaker.record(err)
+	}
+	return err
+}
+
+func (s *CircuitBreakerStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	err = s.call(func() (err error) {
+		files, err = s.Store.ListFiles(ctx, prefix, max)
+		return err
+	})
+	return files, err
+}
+
+func (s *CircuitBreakerStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	err = s.call(func() (err error) {
+		files, nextToken, err = s.Store.ListFilesPage(ctx, prefix, pageSize, pageToken)
+		return err
+	})
+	return files, nextToken, err
+}
+
+func (s *CircuitBreakerStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	err = s.call(func() (err error) {
+		out, err = s.Store.ListDirectories(ctx, prefix)
+		return err
+	})
+	return out, err
+}
@@ -0,0 +1,66 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreakerStore(t *testing.T) {
+	ctx := context.Background()
+	inner := &flakyStore{MemoryStore: NewMemoryStore()}
+	store := NewCircuitBreakerStore(inner, CircuitBreakerPolicy{FailureThreshold: 2, CoolDown: 50 * time.Millisecond})
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+
+	inner.failures = 10
+	assert.Equal(t, errFlaky, store.WriteObject(ctx, "file", strings.NewReader("content")))
+	assert.Equal(t, errFlaky, store.WriteObject(ctx, "file", strings.NewReader("content")))
+
+	// Sub stores share the circuit of their parent
+	for _, s := range []Store{store, sub} {
+		err := s.WriteObject(ctx, "file", strings.NewReader("content"))
+		assert.True(t, errors.Is(err, ErrCircuitOpen))
+	}
+	assert.Equal(t, 2, inner.calls)
+
+	// A failing probe opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, errFlaky, store.WriteObject(ctx, "file", strings.NewReader("content")))
+	_, err = store.FileExists(ctx, "file")
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+
+	time.Sleep(60 * time.Millisecond)
+	inner.failures = 0
+	require.NoError(t, store.WriteObject(ctx, "file", strings.NewReader("content")))
+	exists, err := store.FileExists(ctx, "file")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestCircuitBreakerStore_IgnoredErrors(t *testing.T) {
+	ctx := context.Background()
+	inner := &flakyStore{MemoryStore: NewMemoryStore()}
+	require.NoError(t, WriteObjectBytes(ctx, inner, "file", []byte("content")))
+	store := NewCircuitBreakerStore(inner, CircuitBreakerPolicy{FailureThreshold: 2, CoolDown: time.Minute})
+
+	// Missing objects and walk callback errors are not failures of the backend
+	for i := 0; i < 3; i++ {
+		_, err := store.ObjectAttributes(ctx, "missing")
+		assert.True(t, errors.Is(err, ErrNotFound))
+
+		err = store.Walk(ctx, "", func(filename string) error {
+			return errFlaky
+		})
+		assert.Equal(t, errFlaky, err)
+	}
+
+	exists, err := store.FileExists(ctx, "file")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
@@ -0,0 +1,251 @@
+package dstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+//
+// Tar Bundles
+//
+
+// BundleIndexSuffix is appended to the name of a bundle to name its index.
+const BundleIndexSuffix = ".index"
+
+// BundleEntry locates an entry of a bundle, `Offset` being the position of its
+// content in the decompressed tar stream.
+type BundleEntry struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// BundleIndex lists the entries of a bundle in the order they were added.
+type BundleIndex struct {
+	Entries []BundleEntry `json:"entries"`
+
+	byName map[string]int
+}
+
+// Lookup returns the entry named `name`, the last one added when several
+// entries have that name.
+func (i *BundleIndex) Lookup(name string) (BundleEntry, bool) {
+	if i.byName == nil {
+		i.byName = make(map[string]int, len(i.Entries))
+		for position, entry := range i.Entries {
+			i.byName[entry.Name] = position
+		}
+	}
+
+	position, found := i.byName[name]
+	if !found {
+		return BundleEntry{}, false
+	}
+	return i.Entries[position], true
+}
+
+// BundleWriter streams many small files into a single tar object, saving the
+// request costs of writing them as separate objects. The object is compressed
+// according to the store's compression, a zstd store with `SeekableZstd`
+// making entries readable without decompressing the ones before them.
+//
+// Closing the writer completes the object and writes its index next to it,
+// under the bundle's name followed by `BundleIndexSuffix`.
+type BundleWriter struct {
+	ctx   context.Context
+	store Store
+	name  string
+
+	tar     *tar.Writer
+	counter *countingWriter
+	pipe    *io.PipeWriter
+	done    chan error
+	modTime time.Time
+
+	index BundleIndex
+	err   error
+}
+
+// NewBundleWriter starts writing the bundle `name` to `store`, `opts` applying
+// to the bundle object.
+func NewBundleWriter(ctx context.Context, store Store, name string, opts ...WriteOption) *BundleWriter {
+	pipeRead, pipeWrite := io.Pipe()
+	counter := &countingWriter{writer: pipeWrite}
+
+	w := &BundleWriter{
+		ctx:     ctx,
+		store:   store,
+		name:    name,
+		tar:     tar.NewWriter(counter),
+		counter: counter,
+		pipe:    pipeWrite,
+		done:    make(chan error, 1),
+		modTime: time.Now(),
+	}
+
+	go func() {
+		err := store.WriteObject(ctx, name, pipeRead, opts...)
+		if err == nil {
+			// The write may have been skipped without reading, when the
+			// bundle exists and overwrites are disabled
+			_, err = io.Copy(ioutil.Discard, pipeRead)
+		}
+		pipeRead.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return w
+}
+
+// Add adds an entry holding `content` to the bundle.
+func (w *BundleWriter) Add(name string, content []byte) error {
+	return w.AddReader(name, int64(len(content)), bytes.NewReader(content))
+}
+
+// AddReader adds an entry holding the `size` bytes read from `reader` to the
+// bundle.
+func (w *BundleWriter) AddReader(name string, size int64, reader io.Reader) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  w.modTime,
+	}
+	if err := w.tar.WriteHeader(header); err != nil {
+		return w.fail(fmt.Errorf("write header of %q: %w", name, err))
+	}
+
+	offset := w.counter.written
+	if _, err := pooledCopy(w.tar, io.LimitReader(reader, size)); err != nil {
+		return w.fail(fmt.Errorf("write content of %q: %w", name, err))
+	}
+	if w.counter.written-offset != size {
+		return w.fail(fmt.Errorf("content of %q is %d bytes, expected %d", name, w.counter.written-offset, size))
+	}
+
+	w.index.Entries = append(w.index.Entries, BundleEntry{Name: name, Offset: offset, Size: size})
+	return nil
+}
+
+// fail aborts the bundle write, `Close` then only returns `err`.
+func (w *BundleWriter) fail(err error) error {
+	w.err = err
+	w.pipe.CloseWithError(err)
+	<-w.done
+	return err
+}
+
+// Close completes the bundle and writes its index, returning the entries of
+// the bundle.
+func (w *BundleWriter) Close() (*BundleIndex, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	if err := w.tar.Close(); err != nil {
+		return nil, w.fail(fmt.Errorf("complete bundle: %w", err))
+	}
+	w.pipe.Close()
+	if err := <-w.done; err != nil {
+		w.err = fmt.Errorf("write bundle %q: %w", w.name, err)
+		return nil, w.err
+	}
+	w.err = fmt.Errorf("bundle %q closed", w.name)
+
+	index, err := json.Marshal(&w.index)
+	if err != nil {
+		return nil, fmt.Errorf("marshal index: %w", err)
+	}
+	if err := WriteObjectBytes(w.ctx, w.store, w.name+BundleIndexSuffix, index); err != nil {
+		return nil, fmt.Errorf("write bundle %q index: %w", w.name, err)
+	}
+	return &w.index, nil
+}
+
+type countingWriter struct {
+	writer  io.Writer
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// ReadBundleIndex reads the index written along the bundle `name`.
+func ReadBundleIndex(ctx context.Context, store Store, name string) (*BundleIndex, error) {
+	content, err := ReadObject(ctx, store, name+BundleIndexSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &BundleIndex{}
+	if err := json.Unmarshal(content, index); err != nil {
+		return nil, fmt.Errorf("unmarshal bundle %q index: %w", name, err)
+	}
+	return index, nil
+}
+
+// OpenBundleEntry opens the content of an entry of the bundle `name` through
+// a range read, which only fetches the entry on uncompressed stores and zstd
+// stores with `SeekableZstd`.
+func OpenBundleEntry(ctx context.Context, store Store, name string, entry BundleEntry) (io.ReadCloser, error) {
+	return store.OpenObjectRange(ctx, name, entry.Offset, entry.Size)
+}
+
+// WalkBundle reads the bundle `name` sequentially, calling `f` with each
+// entry and a reader of its content only valid during the call. Returning
+// `StopIteration` from `f` stops the walk without error.
+func WalkBundle(ctx context.Context, store Store, name string, f func(entry BundleEntry, content io.Reader) error) error {
+	reader, err := store.OpenObject(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	counter := &countingReader{reader: reader}
+	tarReader := tar.NewReader(counter)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read bundle %q: %w", name, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		entry := BundleEntry{Name: header.Name, Offset: counter.read, Size: header.Size}
+		if err := f(entry, tarReader); err != nil {
+			if err == StopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+type countingReader struct {
+	reader io.Reader
+	read   int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
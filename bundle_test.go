@@ -0,0 +1,95 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundle(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStoreWithOptions(&url.URL{Scheme: "file", Path: t.TempDir()}, Compression("zstd"), SeekableZstd(4096))
+	require.NoError(t, err)
+
+	contents := map[string]string{}
+	writer := NewBundleWriter(ctx, store, "bundle.tar")
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("blocks/%04d", i)
+		contents[name] = strings.Repeat(fmt.Sprintf("block %d ", i), i)
+		require.NoError(t, writer.Add(name, []byte(contents[name])))
+	}
+	longName := strings.Repeat("long/", 40) + "name"
+	contents[longName] = "long"
+	require.NoError(t, writer.AddReader(longName, 4, strings.NewReader("long and more")))
+
+	index, err := writer.Close()
+	require.NoError(t, err)
+	require.Len(t, index.Entries, 101)
+	assert.Error(t, writer.Add("closed", nil))
+
+	readIndex, err := ReadBundleIndex(ctx, store, "bundle.tar")
+	require.NoError(t, err)
+	assert.Equal(t, index.Entries, readIndex.Entries)
+
+	for _, name := range []string{"blocks/0000", "blocks/0042", "blocks/0099", longName} {
+		entry, found := readIndex.Lookup(name)
+		require.True(t, found, name)
+
+		reader, err := OpenBundleEntry(ctx, store, "bundle.tar", entry)
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, contents[name], string(content), name)
+	}
+	_, found := readIndex.Lookup("missing")
+	assert.False(t, found)
+
+	var walked []BundleEntry
+	require.NoError(t, WalkBundle(ctx, store, "bundle.tar", func(entry BundleEntry, content io.Reader) error {
+		read, err := ioutil.ReadAll(content)
+		require.NoError(t, err)
+		assert.Equal(t, contents[entry.Name], string(read), entry.Name)
+
+		walked = append(walked, entry)
+		return nil
+	}))
+	assert.Equal(t, index.Entries, walked)
+
+	var count int
+	require.NoError(t, WalkBundle(ctx, store, "bundle.tar", func(entry BundleEntry, content io.Reader) error {
+		count++
+		if count == 3 {
+			return StopIteration
+		}
+		return nil
+	}))
+	assert.Equal(t, 3, count)
+}
+
+func TestBundleWriter_errors(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	writer := NewBundleWriter(ctx, store, "bundle.tar")
+	require.NoError(t, writer.Add("first", []byte("content")))
+	assert.Error(t, writer.AddReader("short", 10, strings.NewReader("content")))
+	_, err := writer.Close()
+	assert.Error(t, err)
+
+	_, err = ReadBundleIndex(ctx, store, "bundle.tar")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	failing := NewMemoryStore()
+	writer = NewBundleWriter(ctx, NewReadOnlyStore(failing), "bundle.tar")
+	_, err = writer.Close()
+	assert.True(t, errors.Is(err, ErrReadOnly))
+}
@@ -0,0 +1,161 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+//
+// Checksums
+//
+
+// crc32cTable is the Castagnoli table used by Google Storage checksums.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksummedUpload is the compressed content of an object spooled to a
+// temporary file, along with the checksums of the bytes as they came out of
+// the compressor, so that the backend can verify what it receives.
+type checksummedUpload struct {
+	file   *os.File
+	size   int64
+	crc32c uint32
+	md5    []byte
+}
+
+// spoolChecksummed compresses `f` to a temporary file, computing the
+// checksums of the compressed bytes along the way. The upload must be removed
+// once done.
+func (c *commonStore) spoolChecksummed(ctx context.Context, f io.Reader) (*checksummedUpload, error) {
+	file, err := ioutil.TempFile("", "dstore-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temporary file: %w", err)
+	}
+
+	crc := crc32.New(crc32cTable)
+	md5Hash := md5.New()
+	counter := &countingWriter{writer: io.MultiWriter(file, crc, md5Hash)}
+	if err := c.compressedCopy(ctx, f, counter); err != nil {
+		removeSpooledContent(file)
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		removeSpooledContent(file)
+		return nil, err
+	}
+
+	return &checksummedUpload{
+		file:   file,
+		size:   counter.written,
+		crc32c: crc.Sum32(),
+		md5:    md5Hash.Sum(nil),
+	}, nil
+}
+
+func (u *checksummedUpload) base64MD5() string {
+	return base64.StdEncoding.EncodeToString(u.md5)
+}
+
+func (u *checksummedUpload) remove() {
+	removeSpooledContent(u.file)
+}
+
+// ErrChecksumMismatch is matched by the `ChecksumMismatchError` of objects
+// whose content doesn't match their stored checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumMismatchError is returned when closing an object read by a store
+// with `VerifyChecksums` whose bytes don't match the checksum stored by the
+// backend.
+type ChecksumMismatchError struct {
+	Name string
+	// Algorithm is either `crc32c` or `md5`.
+	Algorithm string
+	// Expected and Actual are the hex encoded checksums.
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s of %q is %s, expected %s", e.Algorithm, e.Name, e.Actual, e.Expected)
+}
+
+func (e *ChecksumMismatchError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+// verifyingReader computes the checksum of the bytes read, comparing it to the
+// expected one on close when the object was read up to its end.
+type verifyingReader struct {
+	io.ReadCloser
+
+	name      string
+	algorithm string
+	hash      hash.Hash
+	expected  []byte
+	complete  bool
+}
+
+func newCRC32CVerifyingReader(name string, reader io.ReadCloser, expected uint32) io.ReadCloser {
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, expected)
+	return &verifyingReader{ReadCloser: reader, name: name, algorithm: "crc32c", hash: crc32.New(crc32cTable), expected: checksum}
+}
+
+func newMD5VerifyingReader(name string, reader io.ReadCloser, expected []byte) io.ReadCloser {
+	return &verifyingReader{ReadCloser: reader, name: name, algorithm: "md5", hash: md5.New(), expected: expected}
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		r.complete = true
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	if err := r.ReadCloser.Close(); err != nil {
+		return err
+	}
+	if !r.complete {
+		return nil
+	}
+
+	if actual := r.hash.Sum(nil); !bytes.Equal(actual, r.expected) {
+		return &ChecksumMismatchError{
+			Name:      r.name,
+			Algorithm: r.algorithm,
+			Expected:  hex.EncodeToString(r.expected),
+			Actual:    hex.EncodeToString(actual),
+		}
+	}
+	return nil
+}
+
+// s3ETagMD5 returns the MD5 of the content of an S3 object out of its ETag,
+// false for the objects whose ETag isn't one, like multipart uploads and
+// objects encrypted with KMS.
+func s3ETagMD5(etag, serverSideEncryption string) ([]byte, bool) {
+	if serverSideEncryption == "aws:kms" {
+		return nil, false
+	}
+
+	checksum, err := hex.DecodeString(strings.Trim(etag, `"`))
+	if err != nil || len(checksum) != md5.Size {
+		return nil, false
+	}
+	return checksum, true
+}
@@ -0,0 +1,98 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommonStore_spoolChecksummed(t *testing.T) {
+	store := newCommonStore(&url.URL{}, newConfig([]Option{Compression("gzip")}))
+
+	upload, err := store.spoolChecksummed(context.Background(), bytes.NewReader(bytes.Repeat([]byte("content"), 1000)))
+	require.NoError(t, err)
+	defer upload.remove()
+
+	spooled, err := ioutil.ReadAll(upload.file)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x1f, 0x8b}, spooled[:2])
+	assert.Equal(t, int64(len(spooled)), upload.size)
+
+	sum := md5.Sum(spooled)
+	assert.Equal(t, sum[:], upload.md5)
+	assert.Equal(t, crc32.Checksum(spooled, crc32.MakeTable(crc32.Castagnoli)), upload.crc32c)
+}
+
+func TestUploadChecksums(t *testing.T) {
+	fromURL := newCommonStore(&url.URL{RawQuery: "checksums=true"}, newConfig(nil))
+	assert.True(t, fromURL.uploadChecksums)
+
+	fromOption := newCommonStore(&url.URL{}, newConfig([]Option{UploadChecksums()}))
+	assert.True(t, fromOption.uploadChecksums)
+	assert.True(t, newCommonStore(&url.URL{}, newConfig(fromOption.options())).uploadChecksums)
+
+	assert.False(t, newCommonStore(&url.URL{}, newConfig(nil)).uploadChecksums)
+}
+
+func TestVerifyingReader(t *testing.T) {
+	content := []byte("some content")
+	sum := md5.Sum(content)
+
+	read := func(reader io.ReadCloser, all bool) error {
+		if all {
+			_, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+		} else {
+			_, err := reader.Read(make([]byte, 4))
+			require.NoError(t, err)
+		}
+		return reader.Close()
+	}
+
+	assert.NoError(t, read(newMD5VerifyingReader("file", ioutil.NopCloser(bytes.NewReader(content)), sum[:]), true))
+	assert.NoError(t, read(newCRC32CVerifyingReader("file", ioutil.NopCloser(bytes.NewReader(content)), crc32.Checksum(content, crc32cTable)), true))
+
+	// Objects not read up to their end cannot be verified
+	assert.NoError(t, read(newMD5VerifyingReader("file", ioutil.NopCloser(bytes.NewReader(content)), make([]byte, md5.Size)), false))
+
+	corrupted := append([]byte(nil), content...)
+	corrupted[3] ^= 0x01
+
+	err := read(newCRC32CVerifyingReader("file", ioutil.NopCloser(bytes.NewReader(corrupted)), crc32.Checksum(content, crc32cTable)), true)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+	var mismatch *ChecksumMismatchError
+	require.True(t, errors.As(err, &mismatch))
+	assert.Equal(t, "file", mismatch.Name)
+	assert.Equal(t, "crc32c", mismatch.Algorithm)
+	assert.Equal(t, fmt.Sprintf("%08x", crc32.Checksum(content, crc32cTable)), mismatch.Expected)
+	assert.Equal(t, fmt.Sprintf("%08x", crc32.Checksum(corrupted, crc32cTable)), mismatch.Actual)
+
+	err = read(newMD5VerifyingReader("file", ioutil.NopCloser(bytes.NewReader(corrupted)), sum[:]), true)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+}
+
+func TestS3ETagMD5(t *testing.T) {
+	checksum, ok := s3ETagMD5(`"9893532233caff98cd083a116b013c0b"`, "")
+	assert.True(t, ok)
+	assert.Equal(t, "9893532233caff98cd083a116b013c0b", hex.EncodeToString(checksum))
+
+	_, ok = s3ETagMD5(`"9893532233caff98cd083a116b013c0b"`, "AES256")
+	assert.True(t, ok)
+	_, ok = s3ETagMD5(`"9893532233caff98cd083a116b013c0b"`, "aws:kms")
+	assert.False(t, ok)
+	_, ok = s3ETagMD5(`"d41d8cd98f00b204e9800998ecf8427e-2"`, "")
+	assert.False(t, ok)
+	_, ok = s3ETagMD5("", "")
+	assert.False(t, ok)
+}
@@ -0,0 +1,552 @@
+package dstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//
+// Chunked Store
+//
+
+// ChunksDirectory is the directory holding the chunks of the objects of a
+// `ChunkedStore`, hidden from its walks and listings.
+const ChunksDirectory = ".chunks"
+
+// chunkManifestMagic starts the manifests of chunked objects.
+var chunkManifestMagic = []byte("dstore-chunked-object\n")
+
+// chunkRetryDelay is the wait before retrying the write of a failed chunk.
+var chunkRetryDelay = 500 * time.Millisecond
+
+// ChunkPolicy configures a `ChunkedStore`.
+type ChunkPolicy struct {
+	// ChunkSize is the size of the chunks, objects larger than it being
+	// chunked.
+	ChunkSize int64
+	// Retries is the number of times the write of a chunk is retried before
+	// failing the whole write.
+	Retries int
+}
+
+// chunkManifest is stored in place of chunked objects, after
+// `chunkManifestMagic`.
+type chunkManifest struct {
+	Size      int64 `json:"size"`
+	ChunkSize int64 `json:"chunk_size"`
+	Chunks    int   `json:"chunks"`
+}
+
+// ChunkedStore is a `Store` splitting the objects larger than
+// `ChunkPolicy.ChunkSize` into numbered chunks, so objects can exceed the
+// maximum object size of the inner store, and a failed chunk write is retried
+// without writing the whole object again. The chunks of `dir/name` are stored
+// under `dir/ChunksDirectory/name/` and a small manifest is stored in place of
+// the object, reads reassembling the chunks it lists.
+//
+// Writes are spooled to a temporary file one chunk at a time, so chunks can be
+// retried. Range reads and attributes of regular objects cost an extra range
+// read to recognize manifests, and `WalkObjects` yields the attributes of the
+// manifests of chunked objects, `ObjectAttributes` returning their real size.
+// Replacing a chunked object by a small one leaves its chunks behind.
+type ChunkedStore struct {
+	// Store is the inner store, holding the objects, the chunks and manifests.
+	Store
+
+	policy ChunkPolicy
+}
+
+func NewChunkedStore(inner Store, policy ChunkPolicy) (*ChunkedStore, error) {
+	if policy.ChunkSize <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive, got %d", policy.ChunkSize)
+	}
+	return &ChunkedStore{Store: inner, policy: policy}, nil
+}
+
+func (s *ChunkedStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+	return &ChunkedStore{Store: inner, policy: s.policy}, nil
+}
+
+// chunkName names the chunks in a chunks directory next to the object, so
+// that sub stores and their parent agree on where they are.
+func chunkName(name string, index int) string {
+	return fmt.Sprintf("%s%08d", chunksPrefix(name), index)
+}
+
+func chunksPrefix(name string) string {
+	return chunksOf(name) + "/"
+}
+
+// chunksOf maps a name, or a prefix of names, to the one of their chunks.
+func chunksOf(name string) string {
+	index := strings.LastIndex(name, "/") + 1
+	return name[:index] + ChunksDirectory + "/" + name[index:]
+}
+
+// chunksDirectoryOf returns the path up to the chunks directory holding
+// `name`, including it, false when `name` is not in a chunks directory.
+func chunksDirectoryOf(name string) (string, bool) {
+	if strings.HasPrefix(name, ChunksDirectory+"/") {
+		return ChunksDirectory + "/", true
+	}
+	if index := strings.Index(name, "/"+ChunksDirectory+"/"); index >= 0 {
+		return name[:index+len(ChunksDirectory)+2], true
+	}
+	return "", false
+}
+
+func (s *ChunkedStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	if !s.Overwrite() {
+		exists, err := s.Store.FileExists(ctx, base)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+
+		// The inner store keeps existing objects, so chunks left by an
+		// interrupted write would be kept in place of the new ones
+		if _, err := s.Store.DeletePrefix(ctx, chunksPrefix(base)); err != nil {
+			return fmt.Errorf("delete leftover chunks: %w", err)
+		}
+	}
+
+	spooled, err := ioutil.TempFile("", "dstore-chunk-*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer removeSpooledContent(spooled)
+
+	source := bufio.NewReader(f)
+	manifest := chunkManifest{ChunkSize: s.policy.ChunkSize}
+	for {
+		size, err := spoolChunk(source, spooled, s.policy.ChunkSize)
+		if err != nil {
+			return fmt.Errorf("spool chunk %d: %w", manifest.Chunks, err)
+		}
+
+		_, err = source.Peek(1)
+		last := err == io.EOF
+		if err != nil && !last {
+			return fmt.Errorf("read content: %w", err)
+		}
+
+		if last && manifest.Chunks == 0 {
+			return s.writeWithRetries(ctx, base, spooled, opts...)
+		}
+
+		if err := s.writeWithRetries(ctx, chunkName(base, manifest.Chunks), spooled); err != nil {
+			return fmt.Errorf("write chunk %d: %w", manifest.Chunks, err)
+		}
+		manifest.Size += size
+		manifest.Chunks++
+
+		if last {
+			break
+		}
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+	if err := s.Store.WriteObject(ctx, base, io.MultiReader(bytes.NewReader(chunkManifestMagic), bytes.NewReader(content)), opts...); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	return s.deleteStaleChunks(ctx, base, manifest.Chunks)
+}
+
+// spoolChunk replaces the content of `spooled` by the next `size` bytes of
+// `f`, rewinding it to be read.
+func spoolChunk(f io.Reader, spooled *os.File, size int64) (int64, error) {
+	if err := spooled.Truncate(0); err != nil {
+		return 0, err
+	}
+	if _, err := spooled.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	written, err := io.CopyN(spooled, f, size)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	_, err = spooled.Seek(0, io.SeekStart)
+	return written, err
+}
+
+// writeWithRetries writes the spooled content, retrying up to
+// `ChunkPolicy.Retries` times.
+func (s *ChunkedStore) writeWithRetries(ctx context.Context, name string, spooled *os.File, opts ...WriteOption) (err error) {
+	for attempt := 0; ; attempt++ {
+		if _, err := spooled.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		err = s.Store.WriteObject(ctx, name, spooled, opts...)
+		if err == nil || attempt >= s.policy.Retries {
+			return err
+		}
+
+		storeLogger(s.Store).Warn("retrying failed chunk write", zap.String("name", name), zap.Int("attempt", attempt+1), zap.Error(err))
+		select {
+		case <-time.After(chunkRetryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// deleteStaleChunks deletes the chunks left by a previous version of the
+// object that had more chunks.
+func (s *ChunkedStore) deleteStaleChunks(ctx context.Context, name string, chunks int) error {
+	if !s.Overwrite() {
+		return nil
+	}
+
+	var stale []string
+	err := s.Store.WalkFrom(ctx, chunksPrefix(name), chunkName(name, chunks), func(filename string) error {
+		stale = append(stale, filename)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("listing stale chunks: %w", err)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	return s.Store.DeleteObjects(ctx, stale)
+}
+
+func (s *ChunkedStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+// manifest returns the manifest of `name`, nil when it's a regular object.
+func (s *ChunkedStore) manifest(ctx context.Context, name string) (*chunkManifest, error) {
+	reader, err := s.Store.OpenObjectRange(ctx, name, 0, int64(len(chunkManifestMagic)))
+	if err != nil {
+		return nil, err
+	}
+	header, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header, chunkManifestMagic) {
+		return nil, nil
+	}
+
+	reader, err = s.Store.OpenObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readChunkManifest(name, bufio.NewReader(reader))
+}
+
+func readChunkManifest(name string, reader *bufio.Reader) (*chunkManifest, error) {
+	if _, err := reader.Discard(len(chunkManifestMagic)); err != nil {
+		return nil, fmt.Errorf("read manifest of %q: %w", name, err)
+	}
+
+	manifest := &chunkManifest{}
+	if err := json.NewDecoder(reader).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest of %q: %w", name, err)
+	}
+	return manifest, nil
+}
+
+// OpenObject recognizes manifests as it reads the object, without extra
+// request for regular objects.
+func (s *ChunkedStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	reader, err := s.Store.OpenObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(reader)
+	header, err := buffered.Peek(len(chunkManifestMagic))
+	if err != nil && err != io.EOF {
+		reader.Close()
+		return nil, err
+	}
+	if !bytes.Equal(header, chunkManifestMagic) {
+		return &readCloser{Reader: buffered, Closer: reader}, nil
+	}
+
+	manifest, err := readChunkManifest(name, buffered)
+	reader.Close()
+	if err != nil {
+		return nil, err
+	}
+	return s.openChunks(ctx, name, manifest, 0, -1), nil
+}
+
+func (s *ChunkedStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	manifest, err := s.manifest(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return s.Store.OpenObjectRange(ctx, name, offset, length)
+	}
+	return s.openChunks(ctx, name, manifest, offset, length), nil
+}
+
+// openChunks reads the chunks of the object holding the range, opening each
+// of them once the previous one is read.
+func (s *ChunkedStore) openChunks(ctx context.Context, name string, manifest *chunkManifest, offset, length int64) io.ReadCloser {
+	if length < 0 || offset+length > manifest.Size {
+		length = manifest.Size - offset
+	}
+	if length < 0 {
+		length = 0
+	}
+
+	return &chunksReader{
+		ctx:       ctx,
+		store:     s.Store,
+		name:      name,
+		chunkSize: manifest.ChunkSize,
+		offset:    offset,
+		remaining: length,
+	}
+}
+
+type chunksReader struct {
+	ctx       context.Context
+	store     Store
+	name      string
+	chunkSize int64
+
+	offset, remaining int64
+	current           io.ReadCloser
+}
+
+func (r *chunksReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if r.current == nil {
+		index := r.offset / r.chunkSize
+		within := r.offset % r.chunkSize
+		length := r.chunkSize - within
+		if length > r.remaining {
+			length = r.remaining
+		}
+
+		reader, err := r.store.OpenObjectRange(r.ctx, chunkName(r.name, int(index)), within, length)
+		if err != nil {
+			return 0, fmt.Errorf("open chunk %d of %q: %w", index, r.name, err)
+		}
+		r.current = reader
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.current.Read(p)
+	r.offset += int64(n)
+	r.remaining -= int64(n)
+
+	if err == io.EOF {
+		r.current.Close()
+		r.current = nil
+		if r.remaining > 0 && r.offset%r.chunkSize != 0 {
+			return n, fmt.Errorf("chunk %d of %q is truncated", r.offset/r.chunkSize, r.name)
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func (r *chunksReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
+
+func (s *ChunkedStore) ObjectAttributes(ctx context.Context, base string) (*ObjectAttrs, error) {
+	attrs, err := s.Store.ObjectAttributes(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := s.manifest(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		attrs.Size = manifest.Size
+	}
+	return attrs, nil
+}
+
+// PresignGet fails for chunked objects, whose content cannot be downloaded
+// through a single URL.
+func (s *ChunkedStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	manifest, err := s.manifest(ctx, base)
+	if err != nil {
+		return "", err
+	}
+	if manifest != nil {
+		return "", fmt.Errorf("presigning chunked object %q: %w", base, ErrNotSupported)
+	}
+	return s.Store.PresignGet(ctx, base, ttl)
+}
+
+func (s *ChunkedStore) CopyObject(ctx context.Context, src, dst string) error {
+	manifest, err := s.manifest(ctx, src)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		return s.Store.CopyObject(ctx, src, dst)
+	}
+
+	if !s.Overwrite() {
+		exists, err := s.Store.FileExists(ctx, dst)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+	}
+
+	for i := 0; i < manifest.Chunks; i++ {
+		if err := s.Store.CopyObject(ctx, chunkName(src, i), chunkName(dst, i)); err != nil {
+			return fmt.Errorf("copy chunk %d: %w", i, err)
+		}
+	}
+	if err := s.Store.CopyObject(ctx, src, dst); err != nil {
+		return fmt.Errorf("copy manifest: %w", err)
+	}
+	return s.deleteStaleChunks(ctx, dst, manifest.Chunks)
+}
+
+func (s *ChunkedStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	return renameObject(ctx, s, oldName, newName)
+}
+
+func (s *ChunkedStore) DeleteObject(ctx context.Context, base string) error {
+	manifest, err := s.manifest(ctx, base)
+	if err != nil {
+		return err
+	}
+
+	// The manifest goes first so that a failure never leaves an object with
+	// missing chunks
+	if err := s.Store.DeleteObject(ctx, base); err != nil {
+		return err
+	}
+	if manifest == nil {
+		return nil
+	}
+
+	chunks := make([]string, manifest.Chunks)
+	for i := range chunks {
+		chunks[i] = chunkName(base, i)
+	}
+	return s.Store.DeleteObjects(ctx, chunks)
+}
+
+// DeleteObjects deletes the objects concurrently, each deletion checking
+// whether the object is chunked.
+func (s *ChunkedStore) DeleteObjects(ctx context.Context, names []string) error {
+	return deleteObjectsConcurrently(ctx, names, deleteObjectsConcurrency, func(ctx context.Context, name string) error {
+		err := s.DeleteObject(ctx, name)
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// DeletePrefix deletes the chunks of the objects under `prefix` along with
+// them. The returned count excludes the chunks stored next to `prefix`, but
+// not the ones of objects in its sub directories.
+func (s *ChunkedStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	if _, err := s.Store.DeletePrefix(ctx, chunksOf(prefix)); err != nil {
+		return 0, fmt.Errorf("delete chunks: %w", err)
+	}
+	return s.Store.DeletePrefix(ctx, prefix)
+}
+
+// hideChunks skips the chunks directories from walks.
+func hideChunks(f func(filename string) error) func(filename string) error {
+	return func(filename string) error {
+		if directory, ok := chunksDirectoryOf(filename); ok {
+			return SkipPrefix(directory)
+		}
+		return f(filename)
+	}
+}
+
+func (s *ChunkedStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
+	return s.Store.WalkFrom(ctx, prefix, startingPoint, hideChunks(f))
+}
+
+func (s *ChunkedStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error {
+	return s.Store.WalkBetween(ctx, prefix, startingPoint, endPoint, hideChunks(f))
+}
+
+func (s *ChunkedStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error {
+	return s.Store.Walk(ctx, prefix, hideChunks(f))
+}
+
+func (s *ChunkedStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) error {
+	return s.Store.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		if directory, ok := chunksDirectoryOf(attrs.Name); ok {
+			return SkipPrefix(directory)
+		}
+		return f(attrs)
+	})
+}
+
+func (s *ChunkedStore) ListFiles(ctx context.Context, prefix string, max int) ([]string, error) {
+	return listFiles(ctx, s, prefix, max)
+}
+
+func (s *ChunkedStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	return listFilesPage(ctx, s, prefix, pageSize, pageToken)
+}
+
+func (s *ChunkedStore) ListDirectories(ctx context.Context, prefix string) ([]string, error) {
+	directories, err := s.Store.ListDirectories(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	out := directories[:0]
+	for _, directory := range directories {
+		if path.Base(directory) != ChunksDirectory {
+			out = append(out, directory)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,196 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedStore(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore(Compression("zstd"), AllowOverwrite())
+	store, err := NewChunkedStore(inner, ChunkPolicy{ChunkSize: 10})
+	require.NoError(t, err)
+
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	require.NoError(t, WriteObjectBytes(ctx, store, "big", []byte(content)))
+	require.NoError(t, WriteObjectBytes(ctx, store, "big/nested", []byte(content)))
+	require.NoError(t, WriteObjectBytes(ctx, store, "small", []byte("0123456789")))
+
+	chunks, err := inner.ListFiles(ctx, "", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		".chunks/big/00000000", ".chunks/big/00000001", ".chunks/big/00000002", ".chunks/big/00000003",
+		"big",
+		"big/.chunks/nested/00000000", "big/.chunks/nested/00000001", "big/.chunks/nested/00000002", "big/.chunks/nested/00000003",
+		"big/nested",
+		"small",
+	}, chunks)
+
+	read, err := ReadObject(ctx, store, "big")
+	require.NoError(t, err)
+	assert.Equal(t, content, string(read))
+	read, err = ReadObject(ctx, store, "small")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(read))
+
+	for _, r := range []struct{ offset, length int64 }{{0, 5}, {8, 15}, {10, 10}, {25, -1}, {30, 100}, {50, -1}} {
+		reader, err := store.OpenObjectRange(ctx, "big", r.offset, r.length)
+		require.NoError(t, err)
+		read, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+
+		end := int64(len(content))
+		if r.length >= 0 && r.offset+r.length < end {
+			end = r.offset + r.length
+		}
+		expected := ""
+		if r.offset < end {
+			expected = content[r.offset:end]
+		}
+		assert.Equal(t, expected, string(read), "offset %d length %d", r.offset, r.length)
+	}
+
+	attrs, err := store.ObjectAttributes(ctx, "big")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), attrs.Size)
+
+	files, err := store.ListFiles(ctx, "", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"big", "big/nested", "small"}, files)
+	dirs, err := store.ListDirectories(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"big"}, dirs)
+
+	require.NoError(t, WriteObjectBytes(ctx, store, "big", []byte(content[:15])))
+	chunks, err = inner.ListFiles(ctx, ChunksDirectory+"/", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []string{".chunks/big/00000000", ".chunks/big/00000001"}, chunks)
+
+	require.NoError(t, store.RenameObject(ctx, "big", "renamed"))
+	read, err = ReadObject(ctx, store, "renamed")
+	require.NoError(t, err)
+	assert.Equal(t, content[:15], string(read))
+	_, err = store.OpenObject(ctx, "big")
+	assert.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, store.DeleteObjects(ctx, []string{"renamed", "missing"}))
+	chunks, err = inner.ListFiles(ctx, ChunksDirectory+"/", 100)
+	require.NoError(t, err)
+	assert.Empty(t, chunks)
+
+	_, err = store.DeletePrefix(ctx, "big")
+	require.NoError(t, err)
+	remaining, err := inner.ListFiles(ctx, "", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"small"}, remaining)
+
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+	require.NoError(t, WriteObjectBytes(ctx, sub, "file", []byte(content)))
+	files, err = store.ListFiles(ctx, "", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"small", "sub/file"}, files)
+	read, err = ReadObject(ctx, store, "sub/file")
+	require.NoError(t, err)
+	assert.Equal(t, content, string(read))
+	exists, err := inner.FileExists(ctx, "sub/.chunks/file/00000000")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestChunkedStore_retries(t *testing.T) {
+	defer func(delay time.Duration) { chunkRetryDelay = delay }(chunkRetryDelay)
+	chunkRetryDelay = 0
+
+	ctx := context.Background()
+	inner := &failingChunkStore{MemoryStore: NewMemoryStore(), failures: map[string]int{".chunks/file/00000001": 2}}
+	store, err := NewChunkedStore(inner, ChunkPolicy{ChunkSize: 4, Retries: 2})
+	require.NoError(t, err)
+
+	require.NoError(t, WriteObjectBytes(ctx, store, "file", []byte("0123456789")))
+	assert.Equal(t, map[string]int{".chunks/file/00000000": 1, ".chunks/file/00000001": 3, ".chunks/file/00000002": 1, "file": 1}, inner.writes)
+	read, err := ReadObject(ctx, store, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(read))
+
+	inner.failures = map[string]int{".chunks/other/00000000": 3}
+	err = WriteObjectBytes(ctx, store, "other", []byte("0123456789"))
+	assert.True(t, errors.Is(err, errChunkWriteFailed))
+	exists, err := store.FileExists(ctx, "other")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestChunkedStore_leftoverChunks(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+	store, err := NewChunkedStore(inner, ChunkPolicy{ChunkSize: 4})
+	require.NoError(t, err)
+
+	// Chunks of an interrupted write, without manifest
+	require.NoError(t, WriteObjectBytes(ctx, inner, ".chunks/file/00000000", []byte("XXXX")))
+	require.NoError(t, WriteObjectBytes(ctx, inner, ".chunks/file/00000003", []byte("YYYY")))
+
+	require.NoError(t, WriteObjectBytes(ctx, store, "file", []byte("abcdefgh")))
+	read, err := ReadObject(ctx, store, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "abcdefgh", string(read))
+
+	chunks, err := inner.ListFiles(ctx, ".chunks/", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []string{".chunks/file/00000000", ".chunks/file/00000001"}, chunks)
+}
+
+var errChunkWriteFailed = errors.New("chunk write failed")
+
+// failingChunkStore fails the first writes of the objects in `failures`.
+type failingChunkStore struct {
+	*MemoryStore
+
+	failures map[string]int
+	writes   map[string]int
+}
+
+func (s *failingChunkStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) error {
+	if s.writes == nil {
+		s.writes = map[string]int{}
+	}
+	s.writes[base]++
+
+	if s.failures[base] > 0 {
+		s.failures[base]--
+		io.Copy(ioutil.Discard, io.LimitReader(f, 2))
+		return errChunkWriteFailed
+	}
+	return s.MemoryStore.WriteObject(ctx, base, f, opts...)
+}
+
+func TestChunksDirectoryOf(t *testing.T) {
+	for name, expected := range map[string]string{
+		".chunks/file/00000000":          ".chunks/",
+		"sub/.chunks/file/00000000":      "sub/.chunks/",
+		"a/b/.chunks/c/.chunks/00000000": "a/b/.chunks/",
+		"file.chunks/00000000":           "",
+		"sub/file":                       "",
+	} {
+		directory, ok := chunksDirectoryOf(name)
+		assert.Equal(t, expected != "", ok, name)
+		assert.Equal(t, expected, directory, name)
+	}
+}
+
+func TestChunksOf(t *testing.T) {
+	assert.Equal(t, ".chunks/file", chunksOf("file"))
+	assert.Equal(t, "a/b/.chunks/file", chunksOf("a/b/file"))
+	assert.Equal(t, "a/b/.chunks/", chunksOf("a/b/"))
+	assert.Equal(t, ".chunks/", chunksOf(""))
+	assert.Equal(t, "a/.chunks/file/00000002", chunkName("a/file", 2))
+}
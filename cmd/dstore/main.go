@@ -0,0 +1,81 @@
+// Command dstore runs the maintenance operations of dstore on stores given by
+// their URL.
+//
+//	dstore convert [-prefix prefix] [-workers n] [flags] <src-url> <dst-url>
+//
+// converts the compression of the objects of `src-url` into the one of
+// `dst-url`, as `dstore.ConvertCompression` does, for example to migrate a
+// bucket of gzip files to zstd:
+//
+//	dstore convert -src-compression gzip -src-extension jsonl.gz -dst-compression zstd -dst-extension jsonl.zst gs://bucket/gzip gs://bucket/zstd
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/streamingfast/dstore"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "convert":
+		err = convert(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dstore %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dstore convert [flags] <src-url> <dst-url>")
+	os.Exit(2)
+}
+
+func convert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	prefix := flags.String("prefix", "", "prefix of the converted objects")
+	workers := flags.Int("workers", 8, "number of objects converted concurrently")
+	srcCompression := flags.String("src-compression", "", "compression of the source store, one of gzip, zstd, lz4, xz or bzip2")
+	srcExtension := flags.String("src-extension", "", "extension of the objects of the source store")
+	dstCompression := flags.String("dst-compression", "", "compression of the destination store")
+	dstExtension := flags.String("dst-extension", "", "extension of the objects of the destination store")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: dstore convert [flags] <src-url> <dst-url>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	src, err := dstore.NewStoreWithOptions(flags.Arg(0), dstore.Compression(*srcCompression), dstore.Extension(*srcExtension))
+	if err != nil {
+		return fmt.Errorf("source store: %w", err)
+	}
+
+	dst, err := dstore.NewStoreWithOptions(flags.Arg(1), dstore.Compression(*dstCompression), dstore.Extension(*dstExtension))
+	if err != nil {
+		return fmt.Errorf("destination store: %w", err)
+	}
+
+	// Interrupted conversions are resumed by running them again
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	return dstore.ConvertCompression(ctx, src, dst, *prefix, *workers)
+}
@@ -1,23 +1,242 @@
 package dstore
 
 import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+	"go.uber.org/zap"
 )
 
 //
 // Common Archive Store
 //
 
+// Default `Content-Type` and `Cache-Control` of objects written to the stores
+// supporting them, when not configured otherwise.
+const defaultContentType = "application/octet-stream"
+const defaultCacheControl = "public, max-age=86400"
+
 type commonStore struct {
 	extension       string
 	compressionType string
-	overwrite       bool
+	// compressionLevel is the level passed to the compressor, zero meaning
+	// its default level.
+	compressionLevel int
+	// parallelGzip compresses gzip objects with pgzip, in blocks of
+	// gzipBlockSize bytes with up to gzipBlocks of them compressed at once.
+	parallelGzip  bool
+	gzipBlockSize int
+	gzipBlocks    int
+	// detectCompression decompresses read objects according to the magic
+	// bytes at their start, whatever compressionType.
+	detectCompression bool
+	// rawReads returns read objects as stored, without decompressing them.
+	rawReads  bool
+	overwrite bool
+	// gzipContentEncoding sets `Content-Encoding: gzip` on the objects written
+	// by gzip compressed stores supporting it.
+	gzipContentEncoding bool
+	// seekableFrameSize is the decompressed size of the frames of objects
+	// written in the zstd seekable format, zero when not seekable.
+	seekableFrameSize int
+	// uploadChecksums sends the checksums of written objects along with them
+	// to the stores supporting it.
+	uploadChecksums bool
+	// verifyChecksums compares the bytes of read objects with their checksum
+	// on the stores supporting it.
+	verifyChecksums bool
+
+	// Store-level `Content-Type` and `Cache-Control` of written objects,
+	// configured through the `content_type` and `cache_control` query
+	// parameters of the store's URL or the equivalent options. Empty means the
+	// backend's default.
+	contentType  string
+	cacheControl string
+
+	// bandwidth throttles the bytes read and written, nil when unlimited.
+	bandwidth *bandwidthLimits
+	// readAheadSize and readAheadChunks configure the read-ahead of opened
+	// objects, disabled when the size is zero.
+	readAheadSize   int
+	readAheadChunks int
+	// readResumes is the number of times in a row a read failing mid-stream
+	// reopens the object at the offset reached, zero disabling it.
+	readResumes int
+	// httpClient sends the requests of the stores talking to a cloud SDK, nil
+	// for the default client of the SDK.
+	httpClient *http.Client
+	// copyBufferSize is the size of the buffer of the copies of writes, zero
+	// for the default size.
+	copyBufferSize int
+	// passthroughCompressed stores the written content as-is when it is
+	// already in the compression of the store.
+	passthroughCompressed bool
+	// logger receives the logs of the store, the package logger unless
+	// configured otherwise.
+	logger *zap.Logger
+}
+
+func newCommonStore(baseURL *url.URL, config *config) *commonStore {
+	logger := loggerOrDefault(config.logger)
+	return &commonStore{
+		compressionType:     firstNonEmpty(config.compression, extensionCompression(config.extension)),
+		compressionLevel:    compressionLevelParam(logger, baseURL, config.compressionLevel),
+		parallelGzip:        config.parallelGzip,
+		gzipBlockSize:       config.gzipBlockSize,
+		gzipBlocks:          config.gzipBlocks,
+		detectCompression:   config.detectCompression,
+		rawReads:            config.rawReads,
+		seekableFrameSize:   seekableFrameSize(config),
+		uploadChecksums:     config.uploadChecksums || baseURL.Query().Get("checksums") == "true",
+		verifyChecksums:     config.verifyChecksums || baseURL.Query().Get("verify_checksums") == "true",
+		gzipContentEncoding: config.contentEncoding || baseURL.Query().Get("content_encoding") == "gzip",
+		extension:           config.extension,
+		overwrite:           config.overwrite,
+		contentType:         firstNonEmpty(baseURL.Query().Get("content_type"), config.contentType),
+		cacheControl:        firstNonEmpty(baseURL.Query().Get("cache_control"), config.cacheControl),
+		bandwidth:           newBandwidthLimits(baseURL, config),
+		readAheadSize:       readAheadSizeParam(logger, baseURL, config.readAheadSize),
+		readAheadChunks:     config.readAheadChunks,
+		readResumes:         readResumesParam(logger, baseURL, config.readResumes),
+		httpClient:          newHTTPClient(config),
+		copyBufferSize:      config.copyBufferSize,
+
+		passthroughCompressed: config.passthroughCompressed,
+		logger:                logger,
+	}
+}
+
+// options returns the options re-creating this common configuration, used to
+// create sub stores.
+func (c *commonStore) options() []Option {
+	opts := append(legacyOptions(c.extension, c.compressionType, c.overwrite),
+		CompressionLevel(c.compressionLevel),
+		DefaultContentType(c.contentType),
+		DefaultCacheControl(c.cacheControl),
+	)
+	if c.parallelGzip {
+		opts = append(opts, ParallelGzip(c.gzipBlockSize, c.gzipBlocks))
+	}
+	if c.detectCompression {
+		opts = append(opts, DetectCompression())
+	}
+	if c.rawReads {
+		opts = append(opts, RawReads())
+	}
+	if c.gzipContentEncoding {
+		opts = append(opts, GzipContentEncoding())
+	}
+	if c.seekableFrameSize > 0 {
+		opts = append(opts, SeekableZstd(c.seekableFrameSize))
+	}
+	if c.uploadChecksums {
+		opts = append(opts, UploadChecksums())
+	}
+	if c.verifyChecksums {
+		opts = append(opts, VerifyChecksums())
+	}
+	if c.bandwidth != nil {
+		opts = append(opts, sharedBandwidth(c.bandwidth))
+	}
+	if c.readAheadSize > 0 {
+		opts = append(opts, ReadAhead(c.readAheadSize, c.readAheadChunks))
+	}
+	if c.readResumes > 0 {
+		opts = append(opts, ResumeReads(c.readResumes))
+	}
+	if c.httpClient != nil {
+		opts = append(opts, HTTPClient(c.httpClient))
+	}
+	if c.copyBufferSize != 0 {
+		opts = append(opts, CopyBufferSize(c.copyBufferSize))
+	}
+	if c.passthroughCompressed {
+		opts = append(opts, PassthroughCompressed())
+	}
+	if c.logger != zlog {
+		opts = append(opts, Logger(c.logger))
+	}
+	return opts
+}
+
+// decompressesReads returns whether read objects may be decompressed, in
+// which case the stored bytes cannot be addressed directly by range reads and
+// their size isn't the size of the content.
+func (c *commonStore) decompressesReads() bool {
+	return !c.rawReads && (c.compressionType != "" || c.detectCompression)
+}
+
+// appendable returns whether content can be appended to objects as a stream
+// compressed on its own, lz4 readers stopping at the end of the first frame
+// and seek tables of seekable zstd objects not covering appended frames.
+func (c *commonStore) appendable() bool {
+	return c.compressionType != "lz4" && c.seekableFrameSize == 0
+}
+
+// concatenatesStored returns whether objects concatenated as stored read back
+// as the concatenation of their content, which isn't the case when they may
+// each use a different compression.
+func (c *commonStore) concatenatesStored() bool {
+	return c.appendable() && !c.detectCompression
+}
+
+// contentEncoding returns the `Content-Encoding` of written objects, empty
+// when they are not stored with one.
+func (c *commonStore) contentEncoding() string {
+	if c.gzipContentEncoding && c.compressionType == "gzip" {
+		return "gzip"
+	}
+	return ""
+}
+
+// sameEncoding returns whether the objects of `c` are stored, read and written
+// like the ones of `other`, so that they can be copied as-is between them.
+func (c *commonStore) sameEncoding(other *commonStore) bool {
+	return c.compressionType == other.compressionType &&
+		c.seekableFrameSize == other.seekableFrameSize &&
+		c.detectCompression == other.detectCompression &&
+		c.rawReads == other.rawReads &&
+		c.contentEncoding() == other.contentEncoding()
+}
+
+// readsStoredEncoding returns whether objects stored with `Content-Encoding:
+// gzip` must be fetched as stored, instead of being decompressed on the way by
+// the backend or the HTTP client, because the store decompresses them itself
+// or returns them raw.
+func (c *commonStore) readsStoredEncoding() bool {
+	return c.compressionType == "gzip" || c.detectCompression
+}
+
+// compression returns the compression of written objects, empty when they are
+// written as-is.
+func (c *commonStore) compression() string {
+	return c.compressionType
+}
+
+func (c *commonStore) log() *zap.Logger {
+	return c.logger
 }
 
 func (c *commonStore) Overwrite() bool      { return c.overwrite }
@@ -30,9 +249,156 @@ func (c *commonStore) pathWithExt(base string) string {
 	return base
 }
 
+// contentHeaders resolves the `Content-Type` and `Cache-Control` to set on a
+// written object, write options take precedence over the store-level
+// configuration which takes precedence over the given backend defaults.
+func (c *commonStore) contentHeaders(config *writeConfig, defaultContentType, defaultCacheControl string) (contentType, cacheControl string) {
+	contentType = firstNonEmpty(config.contentType, c.contentType, defaultContentType)
+	cacheControl = firstNonEmpty(config.cacheControl, c.cacheControl, defaultCacheControl)
+	return
+}
+
+// compressionLevelParam returns the level of the `compression_level` query
+// parameter of the store URL, `level` when it's absent or invalid.
+func compressionLevelParam(logger *zap.Logger, baseURL *url.URL, level int) int {
+	param := baseURL.Query().Get("compression_level")
+	if param == "" {
+		return level
+	}
+
+	parsed, err := strconv.Atoi(param)
+	if err != nil {
+		logger.Warn("ignoring invalid compression_level query parameter", zap.Stringer("base_url", baseURL), zap.String("compression_level", param))
+		return level
+	}
+	return parsed
+}
+
+// newHTTPClient returns the client of the `HTTPClient` option, with a copy of
+// its transport tuned by the `HTTPVersion` and `IdleConnTimeout` options. The
+// tuned client is passed to sub stores, sharing its connection pool.
+func newHTTPClient(config *config) *http.Client {
+	if config.httpVersion == 0 && config.idleConnTimeout == 0 {
+		return config.httpClient
+	}
+
+	client := &http.Client{}
+	if config.httpClient != nil {
+		*client = *config.httpClient
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport, ok := base.(*http.Transport)
+	if !ok {
+		loggerOrDefault(config.logger).Warn("ignoring http version and idle connection timeout of a custom non http.Transport transport")
+		return config.httpClient
+	}
+
+	transport = transport.Clone()
+	switch config.httpVersion {
+	case 1:
+		// A non-nil empty map disables HTTP/2
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case 2:
+		transport.ForceAttemptHTTP2 = true
+	}
+	if config.idleConnTimeout != 0 {
+		transport.IdleConnTimeout = config.idleConnTimeout
+	}
+
+	client.Transport = transport
+	return client
+}
+
+// readAheadSizeParam returns the chunk size of the `read_ahead` query
+// parameter of `baseURL`, `size` when it is missing or invalid.
+func readAheadSizeParam(logger *zap.Logger, baseURL *url.URL, size int) int {
+	param := baseURL.Query().Get("read_ahead")
+	if param == "" {
+		return size
+	}
+
+	parsed, err := strconv.Atoi(param)
+	if err != nil {
+		logger.Warn("ignoring invalid read_ahead query parameter", zap.Stringer("base_url", baseURL), zap.String("read_ahead", param))
+		return size
+	}
+	return parsed
+}
+
+// readResumesParam returns the attempts of the `read_resumes` query parameter
+// of `baseURL`, `attempts` when it is missing or invalid.
+func readResumesParam(logger *zap.Logger, baseURL *url.URL, attempts int) int {
+	param := baseURL.Query().Get("read_resumes")
+	if param == "" {
+		return attempts
+	}
+
+	parsed, err := strconv.Atoi(param)
+	if err != nil {
+		logger.Warn("ignoring invalid read_resumes query parameter", zap.Stringer("base_url", baseURL), zap.String("read_resumes", param))
+		return attempts
+	}
+	return parsed
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// skipPrefixes handles `SkipPrefix` on behalf of backends that cannot seek,
+// by ignoring the files of the skipped prefix as they are listed. Listings
+// being ordered, the files of a prefix are all listed one after the other.
+func skipPrefixes(f func(attrs *ObjectAttrs) error) func(attrs *ObjectAttrs) error {
+	var skipping bool
+	var skipped string
+	return func(attrs *ObjectAttrs) error {
+		if skipping && strings.HasPrefix(attrs.Name, skipped) {
+			return nil
+		}
+		skipping = false
+
+		err := f(attrs)
+		if prefix, ok := skippedPrefix(err); ok {
+			skipping, skipped = true, prefix
+			return nil
+		}
+		return err
+	}
+}
+
+// prefixUpperBound returns the smallest name sorting after every name starting
+// with `prefix`, empty when there is none.
+func prefixUpperBound(prefix string) string {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1})
+		}
+	}
+	return ""
+}
+
 func commonWalkFrom(store Store, ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
+	return commonWalkBetween(store, ctx, prefix, startingPoint, "", f)
+}
+
+// commonWalkBetween relies on listings being lexicographically ordered to stop
+// walking as soon as `endPoint` is reached, an empty `endPoint` walks up to the
+// end.
+func commonWalkBetween(store Store, ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error {
 	var gatePassed bool
 	return store.Walk(ctx, prefix, func(filename string) error {
+		if endPoint != "" && filename >= endPoint {
+			return StopIteration
+		}
 		if gatePassed {
 			return f(filename)
 		}
@@ -44,7 +410,154 @@ func commonWalkFrom(store Store, ctx context.Context, prefix, startingPoint stri
 	})
 }
 
-func pushLocalFile(ctx context.Context, store Store, localFile, toBaseName string) (removeFunc func() error, err error) {
+// dirEntry is an entry of a directory listed by a `dirLister`.
+type dirEntry struct {
+	// path is the full path of the entry, without trailing slash.
+	path string
+	dir  bool
+	// attrs returns the attributes of the object `name` stored in the file,
+	// only called for the files walked.
+	attrs func(name string) (*ObjectAttrs, error)
+}
+
+// dirLister lists the immediate entries of the directory `dir`, in any
+// order, returning none when the directory is missing.
+type dirLister func(dir string) ([]*dirEntry, error)
+
+// walkDirectories walks the objects under `prefix` of the backends storing
+// them as files under `basePath`, depth-first in the lexicographic order of
+// their names like the local store, only listing the directories that can
+// hold them. Half-written `.tmp` files and metadata sidecar files are not
+// objects.
+func (c *commonStore) walkDirectories(ctx context.Context, basePath, prefix string, list dirLister, f func(attrs *ObjectAttrs) error) error {
+	f = skipPrefixes(f)
+
+	basePath = strings.TrimSuffix(basePath, "/")
+	fullPath := basePath + "/" + prefix
+
+	walkPath := fullPath
+	if !strings.HasSuffix(fullPath, "/") {
+		// /my/path/0000 -> will walk /my/path, in case `0000` is the prefix of some files within
+		walkPath = path.Dir(fullPath)
+	}
+
+	if debugEnabled(c.logger) {
+		c.logger.Debug("walking files", zap.String("walk_path", walkPath))
+	}
+
+	err := c.walkDirectory(ctx, strings.TrimSuffix(walkPath, "/"), basePath, fullPath, list, f)
+	if err == StopIteration {
+		return nil
+	}
+	return err
+}
+
+func (c *commonStore) walkDirectory(ctx context.Context, dir, basePath, fullPath string, list dirLister, f func(attrs *ObjectAttrs) error) error {
+	entries, err := list(dir)
+	if err != nil {
+		return err
+	}
+
+	// Directories sort as `dir/`, after `dir` and `dir-a` objects
+	extension := c.pathWithExt("")
+	key := func(entry *dirEntry) string {
+		if entry.dir {
+			return entry.path + "/"
+		}
+		return strings.TrimSuffix(entry.path, extension)
+	}
+	sort.Slice(entries, func(i, j int) bool { return key(entries[i]) < key(entries[j]) })
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if entry.dir {
+			if !strings.HasPrefix(fullPath, entry.path+"/") && !strings.HasPrefix(entry.path, fullPath) {
+				continue
+			}
+			if err := c.walkDirectory(ctx, entry.path, basePath, fullPath, list, f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if strings.HasSuffix(entry.path, ".tmp") || strings.HasSuffix(entry.path, localMetadataSuffix) {
+			continue
+		}
+		if !strings.HasPrefix(entry.path, fullPath) {
+			continue
+		}
+
+		attrs, err := entry.attrs(strings.TrimPrefix(key(entry), basePath+"/"))
+		if err != nil {
+			return err
+		}
+		// StopIteration bubbles up to abort the walk altogether
+		if err := f(attrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sidecarFiles are the file primitives of the backends keeping the metadata
+// of objects in sidecar files next to them, like the local store.
+type sidecarFiles struct {
+	open   func(path string) (io.ReadCloser, error)
+	write  func(path string, data []byte) error
+	remove func(path string) error
+	// notFound tells whether an error of `open` or `remove` is about a missing
+	// file.
+	notFound func(err error) bool
+}
+
+// writeMetadata writes the sidecar metadata file of the object at
+// `objectPath`, removing any previous one when there is no metadata.
+func (files sidecarFiles) writeMetadata(objectPath string, metadata map[string]string) error {
+	metadataPath := objectPath + localMetadataSuffix
+	if len(metadata) == 0 {
+		if err := files.remove(metadataPath); err != nil && !files.notFound(err) {
+			return fmt.Errorf("removing stale metadata %q: %w", metadataPath, err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("marshal metadata: %w", err)
+	}
+
+	if err := files.write(metadataPath, data); err != nil {
+		return fmt.Errorf("writing metadata %q: %w", metadataPath, err)
+	}
+	return nil
+}
+
+// readMetadata reads the sidecar metadata file of the object at
+// `objectPath`, nil when there is none.
+func (files sidecarFiles) readMetadata(objectPath string) (map[string]string, error) {
+	reader, err := files.open(objectPath + localMetadataSuffix)
+	if err != nil {
+		if files.notFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var metadata map[string]string
+	err = json.NewDecoder(reader).Decode(&metadata)
+	if closeErr := reader.Close(); closeErr != nil && err == nil {
+		return nil, closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+func pushLocalFile(ctx context.Context, store Store, localFile, toBaseName string, opts ...WriteOption) (removeFunc func() error, err error) {
 	f, err := os.Open(localFile)
 	if err != nil {
 		return nil, fmt.Errorf("open file: %w", err)
@@ -53,7 +566,7 @@ func pushLocalFile(ctx context.Context, store Store, localFile, toBaseName strin
 
 	objPath := store.ObjectPath(toBaseName)
 
-	err = store.WriteObject(ctx, toBaseName, f)
+	err = store.WriteObject(ctx, toBaseName, f, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("writing %q to storage %q: %w", localFile, objPath, err)
 	}
@@ -63,6 +576,148 @@ func pushLocalFile(ctx context.Context, store Store, localFile, toBaseName strin
 	}, nil
 }
 
+func renameObject(ctx context.Context, store Store, oldName, newName string) error {
+	if err := store.CopyObject(ctx, oldName, newName); err != nil {
+		if err == ErrNotFound {
+			return err
+		}
+		return fmt.Errorf("copy %q to %q: %w", oldName, newName, err)
+	}
+
+	if err := store.DeleteObject(ctx, oldName); err != nil {
+		return fmt.Errorf("delete %q: %w", oldName, err)
+	}
+
+	return nil
+}
+
+// deleteObjectsConcurrency is the number of concurrent deletions performed by
+// stores that have no batch delete API.
+const deleteObjectsConcurrency = 16
+
+// deleteObjectsConcurrently calls `deleteFunc` for each name with at most
+// `concurrency` calls in flight, stopping at the first error.
+func deleteObjectsConcurrently(ctx context.Context, names []string, concurrency int, deleteFunc func(ctx context.Context, name string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+
+	work := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				if err := deleteFunc(ctx, name); err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("delete %q: %w", name, err)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, name := range names {
+		select {
+		case work <- name:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// deletePrefixBatchSize is the number of names accumulated while walking
+// before being handed to `DeleteObjects`.
+const deletePrefixBatchSize = 1000
+
+// deletePrefixConcurrency is the number of `DeleteObjects` batches that can be
+// in flight at the same time.
+const deletePrefixConcurrency = 4
+
+func deletePrefix(ctx context.Context, store Store, prefix string, concurrency int) (deleted int, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	var deletedCount int64
+
+	tracker := deleteProgressTracker(ctx)
+	if tracker != nil {
+		defer tracker.add(0, true)
+	}
+
+	inFlight := make(chan bool, concurrency)
+	flush := func(batch []string) {
+		inFlight <- true
+		wg.Add(1)
+
+		go func() {
+			defer func() {
+				<-inFlight
+				wg.Done()
+			}()
+
+			if err := store.DeleteObjects(ctx, batch); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			if tracker != nil {
+				tracker.add(int64(len(batch)), false)
+			}
+
+			storeLogger(store).Info("deleting objects under prefix",
+				zap.String("prefix", prefix),
+				zap.Int64("deleted", atomic.AddInt64(&deletedCount, int64(len(batch)))),
+			)
+		}()
+	}
+
+	var batch []string
+	walkErr := store.Walk(ctx, prefix, func(filename string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch = append(batch, filename)
+		if len(batch) >= deletePrefixBatchSize {
+			flush(batch)
+			batch = nil
+		}
+		return nil
+	})
+	if walkErr == nil && len(batch) > 0 {
+		flush(batch)
+	}
+	wg.Wait()
+
+	deleted = int(atomic.LoadInt64(&deletedCount))
+	if firstErr != nil {
+		return deleted, fmt.Errorf("deleting objects: %w", firstErr)
+	}
+	if walkErr != nil {
+		return deleted, fmt.Errorf("walking prefix %q: %w", prefix, walkErr)
+	}
+
+	return deleted, nil
+}
+
 func listFiles(ctx context.Context, store Store, prefix string, max int) (out []string, err error) {
 	var count int
 	err = store.Walk(ctx, prefix, func(filename string) error {
@@ -81,35 +736,265 @@ func listFiles(ctx context.Context, store Store, prefix string, max int) (out []
 	return
 }
 
-func (c *commonStore) compressedCopy(f io.Reader, w io.Writer) error {
+// listFilesPage pages through `Walk` for backends without native continuation
+// tokens, the token being the last file name of the previous page.
+func listFilesPage(ctx context.Context, store Store, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	err = store.Walk(ctx, prefix, func(filename string) error {
+		if pageToken != "" && filename <= pageToken {
+			return nil
+		}
+		if len(files) == pageSize {
+			nextToken = files[len(files)-1]
+			return StopIteration
+		}
+
+		files = append(files, filename)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return files, nextToken, nil
+}
+
+// directoryPrefix returns the full key prefix of the `prefix` directory of a
+// store rooted at `basePath`, always ending with a `/` unless it's the bucket
+// root.
+func directoryPrefix(basePath, prefix string) string {
+	p := strings.Trim(basePath, "/")
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		p = path.Join(p, prefix)
+	}
+	if p != "" {
+		p += "/"
+	}
+	return p
+}
+
+// relativeDirectory turns a full key prefix as returned by a delimited listing
+// back into a directory name relative to the store rooted at `basePath`.
+func relativeDirectory(basePath, fullPrefix string) string {
+	if base := strings.Trim(basePath, "/"); base != "" {
+		fullPrefix = strings.TrimPrefix(fullPrefix, base+"/")
+	}
+	return strings.TrimSuffix(fullPrefix, "/")
+}
+
+// compressedCopy relies on `io.CopyBuffer` which uses `io.WriterTo` or
+// `io.ReaderFrom` when available, so in-memory payloads like `bytes.Reader`
+// are written in a single call without an intermediate copy buffer. Copy
+// buffers, gzip writers and zstd encoders are pooled across calls, compressors
+// being only returned to their pool once successfully closed. The copy stops
+// with the error of `ctx` as soon as it is canceled.
+//
+// With `PassthroughCompressed`, content already in the compression of the
+// store is copied as-is.
+func (c *commonStore) compressedCopy(ctx context.Context, f io.Reader, w io.Writer) error {
+	f = newContextReader(ctx, f)
+	w = c.throttleWrites(ctx, w)
+
+	if c.passthroughCompressed && c.compressionType != "" && c.seekableFrameSize == 0 {
+		buffered := bufio.NewReader(f)
+		header, err := buffered.Peek(compressionMagicSize)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("unable to read compression magic bytes: %w", err)
+		}
+
+		f = buffered
+		if detectCompression(header) == c.compressionType {
+			_, err := c.pooledCopy(w, f)
+			return err
+		}
+	}
+
 	switch c.compressionType {
 	case "gzip":
-		gw := gzip.NewWriter(w)
-		if _, err := io.Copy(gw, f); err != nil {
+		level := gzip.DefaultCompression
+		if c.compressionLevel != 0 {
+			level = c.compressionLevel
+		}
+		gw, err := c.newGzipWriter(w, level)
+		if err != nil {
+			return err
+		}
+		if _, err := c.pooledCopy(gw, f); err != nil {
 			return err
 		}
 		if err := gw.Close(); err != nil {
 			return err
 		}
+		releaseGzipWriter(gw, level)
 	case "zstd":
-		zstdEncoder, err := zstd.NewWriter(w)
+		level := zstd.SpeedDefault
+		if c.compressionLevel != 0 {
+			level = zstd.EncoderLevelFromZstd(c.compressionLevel)
+		}
+		if c.seekableFrameSize > 0 {
+			return writeSeekableZstd(f, w, c.seekableFrameSize, level)
+		}
+		zstdEncoder, err := getZstdEncoder(w, level)
 		if err != nil {
 			return err
 		}
-		if _, err := io.Copy(zstdEncoder, f); err != nil {
+		if _, err := c.pooledCopy(zstdEncoder, f); err != nil {
 			return err
 		}
 		if err := zstdEncoder.Close(); err != nil {
 			return err
 		}
+		releaseZstdEncoder(zstdEncoder, level)
+	case "lz4":
+		lz4Writer := lz4.NewWriter(w)
+		if _, err := c.pooledCopy(lz4Writer, f); err != nil {
+			return err
+		}
+		if err := lz4Writer.Close(); err != nil {
+			return err
+		}
+	case "xz":
+		xzWriter, err := xz.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		if _, err := c.pooledCopy(xzWriter, f); err != nil {
+			return err
+		}
+		if err := xzWriter.Close(); err != nil {
+			return err
+		}
+	case "bzip2":
+		return fmt.Errorf("bzip2 compression: %w", ErrNotSupported)
 	default:
-		if _, err := io.Copy(w, f); err != nil {
+		if _, err := c.pooledCopy(w, f); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// pooledCopy is `io.Copy` with a pooled buffer of the store's copy buffer
+// size.
+func (c *commonStore) pooledCopy(dst io.Writer, src io.Reader) (int64, error) {
+	return pooledCopySize(dst, src, c.copyBufferSize)
+}
+
+func (c *commonStore) newGzipWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if !c.parallelGzip {
+		return getGzipWriter(w, level)
+	}
+
+	gw, err := pgzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize, blocks := c.gzipBlockSize, c.gzipBlocks
+	if blockSize == 0 {
+		blockSize = defaultGzipBlockSize
+	}
+	if blocks == 0 {
+		blocks = runtime.GOMAXPROCS(0)
+	}
+	if err := gw.SetConcurrency(blockSize, blocks); err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
+// compressionMagicSize is the number of bytes needed to recognize every
+// compression by its magic bytes.
+const compressionMagicSize = 10
+
+// compressionMagics are the magic bytes starting the objects of each
+// compression, bzip2 being recognized by its block magic too as its stream
+// magic alone could start plain text.
+var compressionMagics = []struct {
+	compressionType string
+	magic           []byte
+	offset          int
+}{
+	{"gzip", []byte{0x1f, 0x8b}, 0},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, 0},
+	{"lz4", []byte{0x04, 0x22, 0x4d, 0x18}, 0},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, 0},
+	{"bzip2", []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}, 4},
+	{"bzip2", []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}, 4},
+}
+
+// detectCompression returns the compression of the object starting with
+// `header`, empty when it isn't compressed.
+func detectCompression(header []byte) string {
+	for _, candidate := range compressionMagics {
+		end := candidate.offset + len(candidate.magic)
+		if len(header) < end || !bytes.Equal(header[candidate.offset:end], candidate.magic) {
+			continue
+		}
+		if candidate.compressionType == "bzip2" && (!bytes.HasPrefix(header, []byte("BZh")) || header[3] < '1' || header[3] > '9') {
+			continue
+		}
+		return candidate.compressionType
+	}
+	return ""
+}
+
+// extensionCompression returns the compression implied by the extension for
+// the formats that are only recognized that way, so stores mirroring `.bz2`
+// and `.xz` archives read them without an explicit compression.
+func extensionCompression(extension string) string {
+	switch path.Ext("." + extension) {
+	case ".bz2":
+		return "bzip2"
+	case ".xz":
+		return "xz"
+	}
+	return ""
+}
+
+// openDecompressedRange is used by compressed stores to serve range reads: the
+// compressed bytes cannot be addressed directly, so we decompress from the start
+// of the object and skip up to `offset`, unless the object is in the zstd
+// seekable format.
+func openDecompressedRange(ctx context.Context, store Store, name string, offset, length int64) (io.ReadCloser, error) {
+	if seekable, ok := store.(seekableStore); ok && seekable.seekable() {
+		reader, err := openSeekableRange(ctx, seekable, name, offset, length)
+		if err != errNotSeekable {
+			return reader, err
+		}
+	}
+
+	reader, err := store.OpenObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		if _, err := io.CopyN(ioutil.Discard, reader, offset); err != nil && err != io.EOF {
+			reader.Close()
+			return nil, fmt.Errorf("skipping to offset %d: %w", offset, err)
+		}
+	}
+
+	return limitReadCloser(reader, length), nil
+}
+
+// limitReadCloser limits the reader to `length` bytes, a negative length
+// meaning no limit at all.
+func limitReadCloser(reader io.ReadCloser, length int64) io.ReadCloser {
+	if length < 0 {
+		return reader
+	}
+
+	return &readCloser{
+		Reader: io.LimitReader(reader, length),
+		Closer: reader,
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
 func wrapReadCloser(orig io.ReadCloser, f func()) io.ReadCloser {
 	return &wrappedReadCloser{
 		orig:      orig,
@@ -130,8 +1015,39 @@ func (wrc *wrappedReadCloser) Read(p []byte) (n int, err error) {
 	return wrc.orig.Read(p)
 }
 
-func (c *commonStore) uncompressedReader(reader io.ReadCloser) (out io.ReadCloser, err error) {
-	switch c.compressionType {
+func (c *commonStore) uncompressedReader(ctx context.Context, reader io.ReadCloser) (out io.ReadCloser, err error) {
+	out, err = c.decompressedReader(ctx, reader)
+	if err != nil || c.readAheadSize <= 0 {
+		return out, err
+	}
+
+	chunks := c.readAheadChunks
+	if chunks <= 0 {
+		chunks = 2
+	}
+	return newReadAheadReader(out, c.readAheadSize, chunks), nil
+}
+
+func (c *commonStore) decompressedReader(ctx context.Context, reader io.ReadCloser) (io.ReadCloser, error) {
+	reader = c.throttleReads(ctx, reader)
+	if c.rawReads {
+		return reader, nil
+	}
+
+	compressionType := c.compressionType
+	if c.detectCompression {
+		buffered := bufio.NewReader(reader)
+		header, err := buffered.Peek(compressionMagicSize)
+		if err != nil && err != io.EOF {
+			reader.Close()
+			return nil, fmt.Errorf("unable to read compression magic bytes: %w", err)
+		}
+
+		compressionType = detectCompression(header)
+		reader = &readCloser{Reader: buffered, Closer: reader}
+	}
+
+	switch compressionType {
 	case "gzip":
 		gzipReader, err := NewGZipReadCloser(reader)
 		if err != nil {
@@ -142,11 +1058,75 @@ func (c *commonStore) uncompressedReader(reader io.ReadCloser) (out io.ReadClose
 	case "zstd":
 		zstdReader, err := zstd.NewReader(reader)
 		if err != nil {
+			reader.Close()
 			return nil, fmt.Errorf("unable to create zstd reader: %w", err)
 		}
 
-		return zstdReader.IOReadCloser(), nil
+		return &zstdReadCloser{src: reader, Decoder: zstdReader}, nil
+	case "lz4":
+		return &readCloser{Reader: lz4.NewReader(reader), Closer: reader}, nil
+	case "bzip2":
+		return &readCloser{Reader: bzip2.NewReader(reader), Closer: reader}, nil
+	case "xz":
+		xzReader, err := xz.NewReader(reader)
+		if err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("unable to create xz reader: %w", err)
+		}
+
+		return &readCloser{Reader: xzReader, Closer: reader}, nil
 	default:
 		return reader, nil
 	}
 }
+
+// contextReader fails reads with the error of `ctx` once it is done, so that
+// copies stop promptly when canceled instead of reading their source to the
+// end.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+// newContextReader returns `reader` as-is when `ctx` can't be canceled. When
+// `reader` is an `io.WriterTo`, the returned reader is one too, checking `ctx`
+// before each write instead, so that in-memory payloads are still written
+// without an intermediate copy buffer.
+func newContextReader(ctx context.Context, reader io.Reader) io.Reader {
+	if ctx.Done() == nil {
+		return reader
+	}
+	if writerTo, ok := reader.(io.WriterTo); ok {
+		return &contextWriterToReader{contextReader: contextReader{ctx: ctx, reader: reader}, writerTo: writerTo}
+	}
+	return &contextReader{ctx: ctx, reader: reader}
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+type contextWriterToReader struct {
+	contextReader
+	writerTo io.WriterTo
+}
+
+func (r *contextWriterToReader) WriteTo(w io.Writer) (int64, error) {
+	return r.writerTo.WriteTo(&contextWriter{ctx: r.ctx, writer: w})
+}
+
+// contextWriter fails writes with the error of `ctx` once it is done.
+type contextWriter struct {
+	ctx    context.Context
+	writer io.Writer
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.writer.Write(p)
+}
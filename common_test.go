@@ -0,0 +1,496 @@
+package dstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommonStore_contentHeaders(t *testing.T) {
+	tests := []struct {
+		name                 string
+		store                *commonStore
+		opts                 []WriteOption
+		expectedContentType  string
+		expectedCacheControl string
+	}{
+		{"defaults", &commonStore{}, nil, defaultContentType, defaultCacheControl},
+		{"store level", &commonStore{contentType: "application/json", cacheControl: "no-cache"}, nil, "application/json", "no-cache"},
+		{"write level", &commonStore{contentType: "application/json"}, []WriteOption{WithContentType("text/html"), WithCacheControl("no-store")}, "text/html", "no-store"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			contentType, cacheControl := test.store.contentHeaders(newWriteConfig(test.opts), defaultContentType, defaultCacheControl)
+			assert.Equal(t, test.expectedContentType, contentType)
+			assert.Equal(t, test.expectedCacheControl, cacheControl)
+		})
+	}
+}
+
+func TestCommonStore_contentEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		opts     []Option
+		expected string
+	}{
+		{"disabled", "gs://bucket/path", []Option{Compression("gzip")}, ""},
+		{"option", "gs://bucket/path", []Option{Compression("gzip"), GzipContentEncoding()}, "gzip"},
+		{"query parameter", "gs://bucket/path?content_encoding=gzip", []Option{Compression("gzip")}, "gzip"},
+		{"not gzip", "gs://bucket/path", []Option{Compression("zstd"), GzipContentEncoding()}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			baseURL, err := url.Parse(test.url)
+			require.NoError(t, err)
+
+			store := newCommonStore(baseURL, newConfig(test.opts))
+			assert.Equal(t, test.expected, store.contentEncoding())
+			assert.Equal(t, test.expected, newCommonStore(baseURL, newConfig(store.options())).contentEncoding())
+		})
+	}
+}
+
+func TestPrefixUpperBound(t *testing.T) {
+	assert.Equal(t, "2022-01-02", prefixUpperBound("2022-01-01"))
+	assert.Equal(t, "2022-01-010", prefixUpperBound("2022-01-01/"))
+	assert.Equal(t, "b", prefixUpperBound("a\xff"))
+	assert.Equal(t, "", prefixUpperBound("\xff\xff"))
+	assert.Equal(t, "", prefixUpperBound(""))
+}
+
+func TestCommonStore_compressionLevel(t *testing.T) {
+	words := strings.Fields("block archive content header transaction receipt log trace")
+	random := rand.New(rand.NewSource(1))
+	var content []byte
+	for len(content) < 256*1024 {
+		content = append(content, words[random.Intn(len(words))]...)
+		content = append(content, ' ')
+	}
+
+	for _, compression := range []string{"gzip", "zstd"} {
+		t.Run(compression, func(t *testing.T) {
+			compress := func(level int) []byte {
+				store := &commonStore{compressionType: compression, compressionLevel: level}
+				var out bytes.Buffer
+				require.NoError(t, store.compressedCopy(context.Background(), bytes.NewReader(content), &out))
+				return out.Bytes()
+			}
+
+			fastest, best := compress(1), compress(9)
+			if compression == "zstd" {
+				best = compress(22)
+			}
+			assert.Less(t, len(best), len(fastest))
+
+			for _, compressed := range [][]byte{fastest, best, compress(0)} {
+				source := &closeRecorder{Reader: bytes.NewReader(compressed)}
+				reader, err := (&commonStore{compressionType: compression}).uncompressedReader(context.Background(), source)
+				require.NoError(t, err)
+
+				decompressed, err := ioutil.ReadAll(reader)
+				require.NoError(t, err)
+				assert.Equal(t, content, decompressed)
+
+				require.NoError(t, reader.Close())
+				assert.True(t, source.closed)
+			}
+		})
+	}
+}
+
+func TestCommonStore_parallelGzip(t *testing.T) {
+	ctx := context.Background()
+	content := make([]byte, 512*1024)
+	rand.New(rand.NewSource(1)).Read(content[:len(content)/2])
+
+	root := NewMemoryStore(Compression("gzip"), ParallelGzip(64*1024, 4))
+	store, err := root.SubStore("sub")
+	require.NoError(t, err)
+	require.NoError(t, WriteObjectBytes(ctx, store, "file", content))
+
+	// Readable by any gzip reader
+	read, err := ReadObject(ctx, store, "file")
+	require.NoError(t, err)
+	assert.Equal(t, content, read)
+
+	// Sub stores keep the setting, blocks must be larger than 16KiB
+	invalid, err := NewMemoryStore(Compression("gzip"), ParallelGzip(1024, 4)).SubStore("sub")
+	require.NoError(t, err)
+	assert.Error(t, WriteObjectBytes(ctx, invalid, "file", content))
+}
+
+func TestCommonStore_detectCompression(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	baseURL := &url.URL{Scheme: "file", Path: dir}
+
+	// A bucket holding objects of mixed compressions
+	for _, compression := range []string{"", "gzip", "zstd", "lz4", "xz"} {
+		store, err := NewLocalStoreWithOptions(baseURL, Compression(compression))
+		require.NoError(t, err)
+		require.NoError(t, WriteObjectBytes(ctx, store, "object-"+compression, []byte("content of "+compression)))
+	}
+	bzip2Archive, err := base64.StdEncoding.DecodeString("QlpoOTFBWSZTWQNjSHIAAAcRgEAAKmJfACAAIgmjA9UIBoAjvKQiZGNMPi7kinChIAbGkOQ=")
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "object-bzip2"), bzip2Archive, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "object-text"), []byte("BZh, plain text"), 0644))
+
+	expected := map[string]string{
+		"object-":      "content of ",
+		"object-gzip":  "content of gzip",
+		"object-zstd":  "content of zstd",
+		"object-lz4":   "content of lz4",
+		"object-xz":    "content of xz",
+		"object-bzip2": "upstream archive",
+		"object-text":  "BZh, plain text",
+	}
+
+	// Whatever the store's compression
+	for _, compression := range []string{"", "gzip"} {
+		store, err := NewLocalStoreWithOptions(baseURL, Compression(compression), DetectCompression())
+		require.NoError(t, err)
+		sub, err := store.SubStore("")
+		require.NoError(t, err)
+
+		for name, content := range expected {
+			read, err := ReadObject(ctx, sub, name)
+			require.NoError(t, err, name)
+			assert.Equal(t, content, string(read), name)
+
+			reader, err := sub.OpenObjectRange(ctx, name, 3, 5)
+			require.NoError(t, err, name)
+			read, err = ioutil.ReadAll(reader)
+			require.NoError(t, err, name)
+			reader.Close()
+			assert.Equal(t, content[3:8], string(read), name)
+
+			localFile := filepath.Join(t.TempDir(), name)
+			require.NoError(t, PullToLocalFile(ctx, sub, name, localFile), name)
+		}
+	}
+}
+
+func TestCommonStore_rawReads(t *testing.T) {
+	ctx := context.Background()
+	baseURL := &url.URL{Scheme: "file", Path: t.TempDir()}
+
+	store, err := NewLocalStoreWithOptions(baseURL, Compression("gzip"))
+	require.NoError(t, err)
+	require.NoError(t, WriteObjectBytes(ctx, store, "sub/file", []byte("content")))
+	attrs, err := store.ObjectAttributes(ctx, "sub/file")
+	require.NoError(t, err)
+
+	raw, err := NewLocalStoreWithOptions(baseURL, Compression("gzip"), RawReads())
+	require.NoError(t, err)
+	sub, err := raw.SubStore("sub")
+	require.NoError(t, err)
+
+	compressed, err := ReadObject(ctx, sub, "file")
+	require.NoError(t, err)
+	assert.Len(t, compressed, int(attrs.Size))
+	assert.Equal(t, []byte{0x1f, 0x8b}, compressed[:2])
+
+	reader, err := NewGZipReadCloser(ioutil.NopCloser(bytes.NewReader(compressed)))
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	reader2, err := sub.OpenObjectRange(ctx, "file", 0, 2)
+	require.NoError(t, err)
+	header, err := ioutil.ReadAll(reader2)
+	require.NoError(t, err)
+	assert.Equal(t, compressed[:2], header)
+
+	// Writes are still compressed
+	require.NoError(t, WriteObjectBytes(ctx, sub, "written", []byte("content")))
+	content, err = ReadObject(ctx, store, "sub/written")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
+
+func TestCommonStore_compressedCopyCanceled(t *testing.T) {
+	for _, compression := range []string{"", "gzip", "zstd", "lz4"} {
+		t.Run(compression, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// An endless source, canceling the copy after some reads
+			reads := 0
+			source := readerFunc(func(p []byte) (int, error) {
+				reads++
+				if reads == 10 {
+					cancel()
+				}
+				return len(p), nil
+			})
+
+			store := &commonStore{compressionType: compression}
+			err := store.compressedCopy(ctx, source, ioutil.Discard)
+			assert.Equal(t, context.Canceled, err)
+			assert.Equal(t, 10, reads)
+		})
+	}
+}
+
+func TestCommonStore_compressedCopyWriterTo(t *testing.T) {
+	content := make([]byte, 4*1024*1024)
+	store := &commonStore{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// `bytes.Reader` writes itself in a single call under a cancelable context
+	recorder := &maxWriteRecorder{}
+	require.NoError(t, store.compressedCopy(ctx, bytes.NewReader(content), recorder))
+	assert.Equal(t, len(content), recorder.max)
+
+	cancel()
+	err := store.compressedCopy(ctx, bytes.NewReader(content), ioutil.Discard)
+	assert.Equal(t, context.Canceled, err)
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestCommonStore_compressedCopyPooled(t *testing.T) {
+	stores := []*commonStore{
+		{compressionType: "gzip"},
+		{compressionType: "gzip", compressionLevel: gzip.BestSpeed},
+		{compressionType: "zstd"},
+		{compressionType: "zstd", compressionLevel: 19},
+		{compressionType: "zstd", seekableFrameSize: 100},
+		{},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				store := stores[(i+j)%len(stores)]
+				content := []byte(strings.Repeat(fmt.Sprintf("content %d-%d ", i, j), j))
+
+				var compressed bytes.Buffer
+				require.NoError(t, store.compressedCopy(context.Background(), bytes.NewReader(content), &compressed))
+
+				reader, err := store.uncompressedReader(context.Background(), ioutil.NopCloser(&compressed))
+				require.NoError(t, err)
+				read, err := ioutil.ReadAll(reader)
+				require.NoError(t, err)
+				require.NoError(t, reader.Close())
+				assert.Equal(t, content, append([]byte{}, read...), "%s level %d", store.compressionType, store.compressionLevel)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestCompressionLevelParam(t *testing.T) {
+	tests := []struct {
+		url      string
+		option   int
+		expected int
+	}{
+		{"gs://bucket/path", 0, 0},
+		{"gs://bucket/path", 9, 9},
+		{"gs://bucket/path?compression_level=1", 9, 1},
+		{"gs://bucket/path?compression_level=fast", 9, 9},
+	}
+
+	for _, test := range tests {
+		baseURL, err := url.Parse(test.url)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, compressionLevelParam(zlog, baseURL, test.option), test.url)
+	}
+}
+
+type closeRecorder struct {
+	*bytes.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestCommonStore_archiveCompressions(t *testing.T) {
+	ctx := context.Background()
+
+	// Produced by the `bzip2` and `xz` command line tools
+	archives := map[string]string{
+		"txt.bz2": "QlpoOTFBWSZTWQNjSHIAAAcRgEAAKmJfACAAIgmjA9UIBoAjvKQiZGNMPi7kinChIAbGkOQ=",
+		"txt.xz":  "/Td6WFoAAATm1rRGBMAUECEBFgAAAAAAAAAAAEEgRR8BAA91cHN0cmVhbSBhcmNoaXZlAIqZImvTOIoXAAEwELyTd+IftvN9AQAAAAAEWVo=",
+	}
+
+	for extension, encoded := range archives {
+		t.Run(extension, func(t *testing.T) {
+			dir := t.TempDir()
+			archive, err := base64.StdEncoding.DecodeString(encoded)
+			require.NoError(t, err)
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "archive."+extension), archive, 0644))
+
+			// The compression is inferred from the extension
+			store, err := NewLocalStoreWithOptions(&url.URL{Scheme: "file", Path: dir}, Extension(extension))
+			require.NoError(t, err)
+
+			content, err := ReadObject(ctx, store, "archive")
+			require.NoError(t, err)
+			assert.Equal(t, "upstream archive", string(content))
+		})
+	}
+
+	store, err := NewLocalStoreWithOptions(&url.URL{Scheme: "file", Path: t.TempDir()}, Compression("xz"))
+	require.NoError(t, err)
+	require.NoError(t, WriteObjectBytes(ctx, store, "file", []byte("content")))
+	content, err := ReadObject(ctx, store, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	dir := t.TempDir()
+	store, err = NewLocalStoreWithOptions(&url.URL{Scheme: "file", Path: dir}, Compression("bzip2"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, WriteObjectBytes(ctx, store, "file", []byte("content")), ErrNotSupported)
+	_, err = os.Stat(filepath.Join(dir, "file"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	assert.Nil(t, newHTTPClient(newConfig(nil)))
+
+	custom := &http.Client{Timeout: time.Minute}
+	assert.Equal(t, custom, newHTTPClient(newConfig([]Option{HTTPClient(custom)})))
+
+	client := newHTTPClient(newConfig([]Option{HTTPVersion(1), IdleConnTimeout(5 * time.Second)}))
+	require.NotNil(t, client)
+	transport := client.Transport.(*http.Transport)
+	assert.NotNil(t, transport.TLSNextProto)
+	assert.Empty(t, transport.TLSNextProto)
+	assert.False(t, transport.ForceAttemptHTTP2)
+	assert.Equal(t, 5*time.Second, transport.IdleConnTimeout)
+	assert.True(t, http.DefaultTransport.(*http.Transport).ForceAttemptHTTP2, "default transport is left untouched")
+
+	base := &http.Transport{}
+	client = newHTTPClient(newConfig([]Option{HTTPClient(&http.Client{Timeout: time.Minute, Transport: base}), HTTPVersion(2)}))
+	assert.Equal(t, time.Minute, client.Timeout)
+	assert.True(t, client.Transport.(*http.Transport).ForceAttemptHTTP2)
+	assert.False(t, base.ForceAttemptHTTP2)
+
+	// Sub stores share the tuned client
+	store := NewMemoryStore(HTTPVersion(1))
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+	assert.Same(t, store.httpClient, sub.(*MemoryStore).httpClient)
+}
+
+// maxWriteRecorder records the size of the largest write.
+type maxWriteRecorder struct {
+	max int
+}
+
+func (r *maxWriteRecorder) Write(p []byte) (int, error) {
+	if len(p) > r.max {
+		r.max = len(p)
+	}
+	return len(p), nil
+}
+
+func TestCommonStore_compressedCopyBufferSize(t *testing.T) {
+	content := make([]byte, 4*1024*1024)
+
+	for _, test := range []struct {
+		size     int
+		expected int
+	}{
+		{0, copyBufferSize},
+		{1024 * 1024, 1024 * 1024},
+		{4096, 4096},
+	} {
+		store := newCommonStore(&url.URL{Scheme: "memory"}, newConfig([]Option{CopyBufferSize(test.size)}))
+
+		// Hides `bytes.Reader` which writes itself in a single call
+		recorder := &maxWriteRecorder{}
+		require.NoError(t, store.compressedCopy(context.Background(), struct{ io.Reader }{bytes.NewReader(content)}, recorder))
+		assert.Equal(t, test.expected, recorder.max)
+	}
+
+	s3Store, err := NewS3StoreWithOptions(&url.URL{Scheme: "s3", Host: "bucket", RawQuery: "region=test"})
+	require.NoError(t, err)
+	assert.Equal(t, cloudCopyBufferSize, s3Store.copyBufferSize)
+	sub, err := s3Store.SubStore("sub")
+	require.NoError(t, err)
+	assert.Equal(t, cloudCopyBufferSize, sub.(*S3Store).copyBufferSize)
+
+	assert.Equal(t, 0, NewMemoryStore().copyBufferSize)
+}
+
+func TestCommonStore_PassthroughCompressed(t *testing.T) {
+	ctx := context.Background()
+	content := []byte(strings.Repeat("already compressed ", 100))
+
+	var compressed bytes.Buffer
+	zstdStore := newCommonStore(&url.URL{Scheme: "memory"}, newConfig([]Option{Compression("zstd")}))
+	require.NoError(t, zstdStore.compressedCopy(ctx, bytes.NewReader(content), &compressed))
+
+	store := NewMemoryStore(Compression("zstd"), PassthroughCompressed())
+	require.NoError(t, store.WriteObject(ctx, "compressed", bytes.NewReader(compressed.Bytes())))
+	object, err := store.get("compressed")
+	require.NoError(t, err)
+	assert.Equal(t, compressed.Bytes(), object.content)
+	read, err := ReadObject(ctx, store, "compressed")
+	require.NoError(t, err)
+	assert.Equal(t, content, read)
+
+	// Other content is compressed as usual
+	require.NoError(t, store.WriteObject(ctx, "plain", bytes.NewReader(content)))
+	read, err = ReadObject(ctx, store, "plain")
+	require.NoError(t, err)
+	assert.Equal(t, content, read)
+
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+	assert.True(t, sub.(*MemoryStore).passthroughCompressed)
+
+	other := NewMemoryStore(Compression("zstd"))
+	require.NoError(t, other.WriteObject(ctx, "compressed", bytes.NewReader(compressed.Bytes())))
+	read, err = ReadObject(ctx, other, "compressed")
+	require.NoError(t, err)
+	assert.Equal(t, compressed.Bytes(), read)
+
+	// Seekable stores compress again, and content shorter than the magic
+	// bytes is still compressed
+	seekable := NewMemoryStore(Compression("zstd"), SeekableZstd(100), PassthroughCompressed())
+	require.NoError(t, seekable.WriteObject(ctx, "compressed", bytes.NewReader(compressed.Bytes())))
+	read, err = ReadObject(ctx, seekable, "compressed")
+	require.NoError(t, err)
+	assert.Equal(t, compressed.Bytes(), read)
+
+	require.NoError(t, store.WriteObject(ctx, "short", strings.NewReader("a")))
+	read, err = ReadObject(ctx, store, "short")
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(read))
+}
@@ -0,0 +1,109 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errNoSources = errors.New("no sources to compose")
+
+// ComposeObjects writes the content of the `sources` objects, one after the
+// other, to `dst`. It is `ConcatObjects` without write options.
+func ComposeObjects(ctx context.Context, store Store, dst string, sources []string) error {
+	return ConcatObjects(ctx, store, dst, sources)
+}
+
+// ConcatObjects writes the content of the `sources` objects, one after the
+// other, to `dst`, `opts` applying to `dst`. A missing source fails with
+// `ErrNotFound`, and the store's overwrite setting applies to `dst`.
+//
+// Objects are concatenated server-side, as stored, through compose on Google
+// Storage and multipart copies on S3 when every source but the last is at
+// least 5MiB. Compressed objects concatenated as stored are gzip members or
+// zstd frames following each other, read back as a single stream. Other
+// stores, and objects that cannot be concatenated as stored like lz4 and
+// seekable zstd ones, are streamed through this process, decompressed and
+// compressed again.
+func ConcatObjects(ctx context.Context, store Store, dst string, sources []string, opts ...WriteOption) error {
+	if len(sources) == 0 {
+		return errNoSources
+	}
+
+	switch s := store.(type) {
+	case *GSStore:
+		return s.composeObjects(ctx, dst, sources, opts...)
+	case *S3Store:
+		concatenated, err := s.concatObjects(ctx, dst, sources, opts...)
+		if concatenated || err != nil {
+			return err
+		}
+	}
+
+	return composeStreamed(ctx, store, dst, sources, opts...)
+}
+
+// composeStreamed composes the objects by writing `dst` out of a reader
+// opening each source in turn.
+func composeStreamed(ctx context.Context, store Store, dst string, sources []string, opts ...WriteOption) error {
+	reader := &objectsReader{ctx: ctx, store: store, names: sources}
+	defer reader.Close()
+
+	err := store.WriteObject(ctx, dst, reader, opts...)
+	if reader.err != nil {
+		// The open error, which the store may have wrapped
+		return reader.err
+	}
+	return err
+}
+
+// objectsReader reads the content of objects one after the other, opening each
+// of them once the previous one is read.
+type objectsReader struct {
+	ctx   context.Context
+	store Store
+	names []string
+
+	current io.ReadCloser
+	err     error
+}
+
+func (r *objectsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.names) == 0 {
+				return 0, io.EOF
+			}
+
+			reader, err := r.store.OpenObject(r.ctx, r.names[0])
+			if err != nil {
+				if err != ErrNotFound {
+					err = fmt.Errorf("open %q: %w", r.names[0], err)
+				}
+				r.err = err
+				return 0, err
+			}
+			r.current = reader
+			r.names = r.names[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *objectsReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
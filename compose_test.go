@@ -0,0 +1,65 @@
+package dstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComposeObjects(t *testing.T) {
+	ctx := context.Background()
+	for _, compression := range []string{"", "gzip", "zstd"} {
+		store := NewMemoryStore(Compression(compression))
+		var sources []string
+		for i := 0; i < 100; i++ {
+			sources = append(sources, fmt.Sprintf("blocks/%04d", i))
+			require.NoError(t, WriteObjectBytes(ctx, store, sources[i], []byte(fmt.Sprintf("%d,", i))))
+		}
+		require.NoError(t, WriteObjectBytes(ctx, store, "blocks/empty", nil))
+		sources = append(sources, "blocks/empty", "blocks/0000")
+
+		require.NoError(t, ComposeObjects(ctx, store, "bundle", sources))
+
+		expected := ""
+		for i := 0; i < 100; i++ {
+			expected += fmt.Sprintf("%d,", i)
+		}
+		content, err := ReadObject(ctx, store, "bundle")
+		require.NoError(t, err)
+		assert.Equal(t, expected+"0,", string(content))
+
+		// Overwrite is disabled, the existing object is left untouched
+		require.NoError(t, ComposeObjects(ctx, store, "bundle", []string{"blocks/0001"}))
+		content, err = ReadObject(ctx, store, "bundle")
+		require.NoError(t, err)
+		assert.Equal(t, expected+"0,", string(content))
+
+		assert.Equal(t, ErrNotFound, ComposeObjects(ctx, store, "other", []string{"blocks/0000", "missing"}))
+		exists, err := store.FileExists(ctx, "other")
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		assert.Equal(t, errNoSources, ComposeObjects(ctx, store, "other", nil))
+	}
+}
+
+func TestConcatObjects(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(Compression("lz4"))
+
+	require.NoError(t, WriteObjectBytes(ctx, store, "first", []byte("first,")))
+	require.NoError(t, WriteObjectBytes(ctx, store, "second", []byte("second")))
+
+	require.NoError(t, ConcatObjects(ctx, store, "both", []string{"first", "second"}, WithMetadata(map[string]string{"Source": "concat"})))
+
+	content, err := ReadObject(ctx, store, "both")
+	require.NoError(t, err)
+	assert.Equal(t, "first,second", string(content))
+
+	attrs, err := store.ObjectAttributes(ctx, "both")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"source": "concat"}, attrs.Metadata)
+}
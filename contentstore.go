@@ -0,0 +1,162 @@
+package dstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+//
+// Content Addressed Store
+//
+
+// ContentAddressedStore is a `Store` naming objects after the hex SHA-256 of
+// their content, so identical contents are stored once whoever writes them.
+// The content of an object being implied by its name, existing objects are
+// never written again, whatever the overwrite setting.
+//
+// Combine it with `NewKeyMappedStore` and `HashFanOutKeyMapping` to spread the
+// objects in directories.
+type ContentAddressedStore struct {
+	// Store is the inner store, holding the objects under their hash.
+	Store
+}
+
+func NewContentAddressedStore(inner Store) *ContentAddressedStore {
+	return &ContentAddressedStore{Store: inner}
+}
+
+func (s *ContentAddressedStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+	return NewContentAddressedStore(inner), nil
+}
+
+// ContentHash returns the name of the content of `r` in a
+// `ContentAddressedStore`.
+func ContentHash(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func validContentHash(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	return err == nil && len(decoded) == sha256.Size && hex.EncodeToString(decoded) == hash
+}
+
+// HasContent returns whether an object with the content hashing to `hash`
+// exists.
+func (s *ContentAddressedStore) HasContent(ctx context.Context, hash string) (bool, error) {
+	if !validContentHash(hash) {
+		return false, fmt.Errorf("invalid content hash %q", hash)
+	}
+	return s.Store.FileExists(ctx, hash)
+}
+
+// WriteContent writes the content of `f` and returns its hash, the name of
+// the object. The content is spooled to a temporary file while hashed, and not
+// uploaded when an object with the same content exists.
+func (s *ContentAddressedStore) WriteContent(ctx context.Context, f io.Reader, opts ...WriteOption) (hash string, err error) {
+	spooled, hash, err := spoolContent(f)
+	if err != nil {
+		return "", err
+	}
+	defer removeSpooledContent(spooled)
+
+	exists, err := s.Store.FileExists(ctx, hash)
+	if err != nil {
+		return "", fmt.Errorf("checking existence of %q: %w", hash, err)
+	}
+	if exists {
+		return hash, nil
+	}
+	return hash, s.writeSpooled(ctx, hash, spooled, opts)
+}
+
+// WriteObject writes the content of `f` under `base`, which must be the hash
+// of the content. The content is verified before being uploaded.
+func (s *ContentAddressedStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	if !validContentHash(base) {
+		return fmt.Errorf("invalid content hash %q", base)
+	}
+
+	exists, err := s.Store.FileExists(ctx, base)
+	if err != nil {
+		return fmt.Errorf("checking existence of %q: %w", base, err)
+	}
+	if exists {
+		return nil
+	}
+
+	spooled, hash, err := spoolContent(f)
+	if err != nil {
+		return err
+	}
+	defer removeSpooledContent(spooled)
+
+	if hash != base {
+		return fmt.Errorf("content hashes to %q, expected %q", hash, base)
+	}
+	return s.writeSpooled(ctx, hash, spooled, opts)
+}
+
+func (s *ContentAddressedStore) writeSpooled(ctx context.Context, hash string, spooled *os.File, opts []WriteOption) error {
+	if _, err := spooled.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind spooled content: %w", err)
+	}
+	return s.Store.WriteObject(ctx, hash, spooled, opts...)
+}
+
+// spoolContent copies `f` to a temporary file, returning it along with the
+// hash of the content.
+func spoolContent(f io.Reader) (*os.File, string, error) {
+	spooled, err := ioutil.TempFile("", "dstore-content-*")
+	if err != nil {
+		return nil, "", fmt.Errorf("create temporary file: %w", err)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(spooled, hash), f); err != nil {
+		removeSpooledContent(spooled)
+		return nil, "", fmt.Errorf("spool content: %w", err)
+	}
+	return spooled, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func removeSpooledContent(spooled *os.File) {
+	spooled.Close()
+	os.Remove(spooled.Name())
+}
+
+func (s *ContentAddressedStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+// CopyObject fails, an object's name being implied by its content.
+func (s *ContentAddressedStore) CopyObject(ctx context.Context, src, dst string) error {
+	return fmt.Errorf("copying content addressed objects: %w", ErrNotSupported)
+}
+
+// RenameObject fails, an object's name being implied by its content.
+func (s *ContentAddressedStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	return fmt.Errorf("renaming content addressed objects: %w", ErrNotSupported)
+}
+
+// PresignPut fails, the uploaded content could not be verified.
+func (s *ContentAddressedStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return "", fmt.Errorf("presigning content addressed objects: %w", ErrNotSupported)
+}
@@ -0,0 +1,57 @@
+package dstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentAddressedStore(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+	store := NewContentAddressedStore(inner)
+
+	hash, err := store.WriteContent(ctx, strings.NewReader("merged"))
+	require.NoError(t, err)
+	assert.Equal(t, "3f8f09c8e09f712b362183db69f4f061bd948d7a61e7663b585d723602c559b1", hash)
+
+	expected, err := ContentHash(strings.NewReader("merged"))
+	require.NoError(t, err)
+	assert.Equal(t, expected, hash)
+	content, err := ReadObject(ctx, store, hash)
+	require.NoError(t, err)
+	assert.Equal(t, "merged", string(content))
+
+	exists, err := store.HasContent(ctx, hash)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// Identical content written by another node is deduplicated
+	again, err := store.WriteContent(ctx, strings.NewReader("merged"))
+	require.NoError(t, err)
+	assert.Equal(t, hash, again)
+	files, err := inner.ListFiles(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{hash}, files)
+
+	// Supplied hashes are verified
+	other, err := ContentHash(strings.NewReader("other"))
+	require.NoError(t, err)
+	assert.Error(t, store.WriteObject(ctx, other, strings.NewReader("tampered")))
+	assert.Error(t, store.WriteObject(ctx, "not-a-hash", strings.NewReader("other")))
+	exists, err = store.HasContent(ctx, other)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, store.WriteObject(ctx, other, strings.NewReader("other")))
+	content, err = ReadObject(ctx, store, other)
+	require.NoError(t, err)
+	assert.Equal(t, "other", string(content))
+
+	assert.ErrorIs(t, store.RenameObject(ctx, hash, other), ErrNotSupported)
+	_, err = store.HasContent(ctx, "ABC")
+	assert.Error(t, err)
+}
@@ -0,0 +1,203 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// ConvertSourceSHA256MetadataKey is the metadata key holding the hex SHA-256
+// of the content of the source of objects written by `ConvertCompression`.
+const ConvertSourceSHA256MetadataKey = "dstore_source_sha256"
+
+// ConvertCompression copies every object under `prefix` from `src` to `dst`,
+// with `workers` objects converted concurrently. Objects are decompressed
+// according to the compression of `src` and compressed according to the one of
+// `dst`, which is how the compression of a bucket is migrated, for example by
+// converting a gzip store into a zstd store on another path or bucket.
+//
+// Each converted object is read back from `dst` and its content checked
+// against the SHA-256 of the content read from `src`, the object being deleted
+// from `dst` when they differ. The SHA-256 is recorded in the metadata of the
+// object under `ConvertSourceSHA256MetadataKey`. Objects already in `dst` are
+// skipped when their content matches the one in `src`, and converted again
+// otherwise, so an interrupted conversion is resumed by running it again,
+// objects left incomplete included. Only `src` is read to check objects holding
+// the SHA-256 of their source, `dst` being read too for the others.
+func ConvertCompression(ctx context.Context, src, dst Store, prefix string, workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	var converted, skipped int64
+
+	work := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				done, err := convertObject(ctx, src, dst, name)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("converting %q: %w", name, err)
+						cancel()
+					})
+					continue
+				}
+
+				if done {
+					atomic.AddInt64(&converted, 1)
+				} else {
+					atomic.AddInt64(&skipped, 1)
+				}
+			}
+		}()
+	}
+
+	walkErr := src.Walk(ctx, prefix, func(filename string) error {
+		select {
+		case work <- filename:
+			return nil
+		case <-ctx.Done():
+			return StopIteration
+		}
+	})
+	close(work)
+	wg.Wait()
+
+	storeLogger(dst).Info("converted objects compression",
+		zap.Stringer("src", src.BaseURL()),
+		zap.Stringer("dst", dst.BaseURL()),
+		zap.String("prefix", prefix),
+		zap.Int64("converted", converted),
+		zap.Int64("skipped", skipped),
+	)
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if walkErr != nil {
+		return fmt.Errorf("walking %q: %w", prefix, walkErr)
+	}
+	return ctx.Err()
+}
+
+// convertObject converts `name`, returning false when it was already in `dst`.
+func convertObject(ctx context.Context, src, dst Store, name string) (converted bool, err error) {
+	exists, err := dst.FileExists(ctx, name)
+	if err != nil {
+		return false, fmt.Errorf("checking destination: %w", err)
+	}
+	if exists {
+		same, err := sameContent(ctx, src, dst, name)
+		if err != nil {
+			return false, err
+		}
+		if same {
+			return false, nil
+		}
+
+		// Left incomplete by an interrupted conversion, or changed since
+		storeLogger(dst).Info("converting again mismatching object", zap.String("name", name))
+		if err := dst.DeleteObject(ctx, name); err != nil {
+			return false, fmt.Errorf("delete mismatching object: %w", err)
+		}
+	}
+
+	attrs, err := src.ObjectAttributes(ctx, name)
+	if err != nil {
+		return false, fmt.Errorf("attributes: %w", err)
+	}
+
+	reader, err := src.OpenObject(ctx, name)
+	if err != nil {
+		return false, fmt.Errorf("open: %w", err)
+	}
+	defer reader.Close()
+
+	// The content is spooled so that its SHA-256 is known before writing it,
+	// to record it in the metadata of the converted object
+	spooled, srcSum, err := spoolContent(reader)
+	if err != nil {
+		return false, err
+	}
+	defer removeSpooledContent(spooled)
+
+	if _, err := spooled.Seek(0, io.SeekStart); err != nil {
+		return false, fmt.Errorf("rewind spooled content: %w", err)
+	}
+	err = dst.WriteObject(ctx, name, spooled,
+		WithMetadata(attrs.Metadata),
+		WithMetadata(map[string]string{ConvertSourceSHA256MetadataKey: srcSum}),
+	)
+	if err != nil {
+		return false, fmt.Errorf("write: %w", err)
+	}
+
+	dstSum, err := objectSHA256(ctx, dst, name)
+	if err != nil {
+		return false, fmt.Errorf("written object: %w", err)
+	}
+
+	if hex.EncodeToString(dstSum) != srcSum {
+		if err := dst.DeleteObject(ctx, name); err != nil {
+			storeLogger(dst).Warn("unable to delete mismatching converted object", zap.String("name", name), zap.Error(err))
+		}
+		return false, fmt.Errorf("checksum of written object doesn't match the source")
+	}
+	return true, nil
+}
+
+// sameContent returns whether the object `name` has the same content in `src`
+// and `dst`. The SHA-256 of the source recorded in the metadata of the object
+// in `dst` is trusted when present, the object being read otherwise.
+func sameContent(ctx context.Context, src, dst Store, name string) (bool, error) {
+	attrs, err := dst.ObjectAttributes(ctx, name)
+	if err != nil {
+		return false, fmt.Errorf("existing object attributes: %w", err)
+	}
+
+	srcSum, err := objectSHA256(ctx, src, name)
+	if err != nil {
+		return false, err
+	}
+
+	if recorded, found := attrs.Metadata[ConvertSourceSHA256MetadataKey]; found {
+		return recorded == hex.EncodeToString(srcSum), nil
+	}
+
+	dstSum, err := objectSHA256(ctx, dst, name)
+	if err != nil {
+		return false, fmt.Errorf("existing object: %w", err)
+	}
+	return bytes.Equal(srcSum, dstSum), nil
+}
+
+// objectSHA256 returns the SHA-256 of the content of the object `name`.
+func objectSHA256(ctx context.Context, store Store, name string) ([]byte, error) {
+	reader, err := store.OpenObject(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,115 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertCompression(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryStore(Compression("gzip"), Extension("jsonl.gz"))
+	dst := NewMemoryStore(Compression("zstd"), Extension("jsonl.zst"))
+
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("blocks/%04d", i)
+		require.NoError(t, WriteObjectBytes(ctx, src, name, bytes.Repeat([]byte(name), 100), WithMetadata(map[string]string{"index": fmt.Sprint(i)})))
+	}
+	require.NoError(t, WriteObjectBytes(ctx, src, "other/file", []byte("other")))
+
+	// Converted by a previous run, and left incomplete by an interrupted one
+	require.NoError(t, WriteObjectBytes(ctx, dst, "blocks/0003", bytes.Repeat([]byte("blocks/0003"), 100)))
+	require.NoError(t, WriteObjectBytes(ctx, dst, "blocks/0004", []byte("blocks/00")))
+
+	require.NoError(t, ConvertCompression(ctx, src, dst, "blocks/", 4))
+
+	files, err := dst.ListFiles(ctx, "", 100)
+	require.NoError(t, err)
+	assert.Len(t, files, 20)
+
+	content, err := ReadObject(ctx, dst, "blocks/0012")
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("blocks/0012"), 100), content)
+	content, err = ReadObject(ctx, dst, "blocks/0004")
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("blocks/0004"), 100), content)
+
+	attrs, err := dst.ObjectAttributes(ctx, "blocks/0012")
+	require.NoError(t, err)
+	assert.Equal(t, "12", attrs.Metadata["index"])
+	assert.Equal(t, sha256Hex(bytes.Repeat([]byte("blocks/0012"), 100)), attrs.Metadata[ConvertSourceSHA256MetadataKey])
+	attrs, err = dst.ObjectAttributes(ctx, "blocks/0004")
+	require.NoError(t, err)
+	assert.Equal(t, "4", attrs.Metadata["index"])
+	attrs, err = dst.ObjectAttributes(ctx, "blocks/0003")
+	require.NoError(t, err)
+	assert.Empty(t, attrs.Metadata, "matching objects are skipped")
+}
+
+func TestConvertCompression_RecordedChecksum(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryStore()
+	require.NoError(t, WriteObjectBytes(ctx, src, "recorded", []byte("content")))
+	require.NoError(t, WriteObjectBytes(ctx, src, "changed", []byte("new content")))
+
+	// The recorded checksum is trusted without reading the converted objects
+	dst := NewMemoryStore()
+	require.NoError(t, WriteObjectBytes(ctx, dst, "recorded", []byte("stale"), WithMetadata(map[string]string{
+		ConvertSourceSHA256MetadataKey: sha256Hex([]byte("content")),
+	})))
+	require.NoError(t, WriteObjectBytes(ctx, dst, "changed", []byte("content"), WithMetadata(map[string]string{
+		ConvertSourceSHA256MetadataKey: sha256Hex([]byte("content")),
+	})))
+
+	require.NoError(t, ConvertCompression(ctx, src, dst, "", 1))
+
+	content, err := ReadObject(ctx, dst, "recorded")
+	require.NoError(t, err)
+	assert.Equal(t, "stale", string(content))
+	content, err = ReadObject(ctx, dst, "changed")
+	require.NoError(t, err)
+	assert.Equal(t, "new content", string(content))
+}
+
+func TestConvertCompression_VerificationFailure(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryStore()
+	require.NoError(t, WriteObjectBytes(ctx, src, "file", []byte("content")))
+
+	dst := &corruptingStore{MemoryStore: NewMemoryStore()}
+	err := ConvertCompression(ctx, src, dst, "", 2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum")
+
+	// Deleted, so that it's converted again on the next run
+	exists, err := dst.MemoryStore.FileExists(ctx, "file")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+// corruptingStore flips the first byte of the objects written to it.
+type corruptingStore struct {
+	*MemoryStore
+}
+
+func (s *corruptingStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) error {
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	content[0] ^= 1
+	return s.MemoryStore.WriteObject(ctx, base, bytes.NewReader(content), opts...)
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,130 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Copy copies the object `srcName` from `srcStore` to `dstName` in `dstStore`.
+//
+// When both stores are of the same backend family (and same bucket family, e.g.
+// the same S3 endpoint or the same Azure account) and encode objects the same
+// way (compression, seekable frames, compression detection, raw reads and
+// `Content-Encoding`), the copy is performed server-side without the content
+// going through this process. Otherwise, or when the credentials of the
+// destination are not allowed to read the source, the object is streamed from
+// the source store, getting decompressed and re-compressed according to each
+// store's own configuration.
+//
+// The object's metadata is carried over and the `dstStore` overwrite setting is
+// honored in both cases.
+func Copy(ctx context.Context, srcStore Store, srcName string, dstStore Store, dstName string) error {
+	copied, err := serverSideCopy(ctx, srcStore, srcName, dstStore, dstName)
+	if err != nil {
+		err = withErrorClass(err)
+		if !errors.Is(err, ErrPermissionDenied) {
+			return fmt.Errorf("server-side copy %q to %q: %w", srcStore.ObjectURL(srcName), dstStore.ObjectURL(dstName), err)
+		}
+
+		// Stores of different accounts on the same backend
+		storeLogger(dstStore).Info("server-side copy denied, streaming it instead", zap.String("src", srcStore.ObjectURL(srcName)), zap.String("dst", dstStore.ObjectURL(dstName)), zap.Error(err))
+		copied = false
+	}
+
+	if copied {
+		return nil
+	}
+
+	if logger := storeLogger(dstStore); debugEnabled(logger) {
+		logger.Debug("streaming copy between stores", zap.String("src", srcStore.ObjectURL(srcName)), zap.String("dst", dstStore.ObjectURL(dstName)))
+	}
+
+	attrs, err := srcStore.ObjectAttributes(ctx, srcName)
+	if err != nil {
+		return fmt.Errorf("attributes %q: %w", srcStore.ObjectURL(srcName), err)
+	}
+
+	reader, err := srcStore.OpenObject(ctx, srcName)
+	if err != nil {
+		return fmt.Errorf("open %q: %w", srcStore.ObjectURL(srcName), err)
+	}
+	defer reader.Close()
+
+	if err := dstStore.WriteObject(ctx, dstName, reader, WithMetadata(attrs.Metadata)); err != nil {
+		return fmt.Errorf("write %q: %w", dstStore.ObjectURL(dstName), err)
+	}
+
+	return nil
+}
+
+// serverSideCopy performs the copy if both stores support copying from one
+// another without streaming the content, it returns `false` when it's not the
+// case and the copy must be streamed.
+func serverSideCopy(ctx context.Context, srcStore Store, srcName string, dstStore Store, dstName string) (copied bool, err error) {
+	switch src := srcStore.(type) {
+	case *GSStore:
+		dst, ok := dstStore.(*GSStore)
+		if !ok || !src.sameEncoding(dst.commonStore) {
+			return false, nil
+		}
+
+		return true, dst.copyPath(ctx, src.baseURL.Host, src.ObjectPath(srcName), dst.ObjectPath(dstName))
+
+	case *S3Store:
+		dst, ok := dstStore.(*S3Store)
+		if !ok || !src.sameEncoding(dst.commonStore) || src.service.Endpoint != dst.service.Endpoint {
+			return false, nil
+		}
+
+		if skip, err := skipExistingCopy(ctx, dst, dstName); skip || err != nil {
+			return true, err
+		}
+		return true, dst.copyPath(ctx, src.bucket, src.ObjectPath(srcName), dst.ObjectPath(dstName))
+
+	case *AzureStore:
+		dst, ok := dstStore.(*AzureStore)
+		if !ok || !src.sameEncoding(dst.commonStore) {
+			return false, nil
+		}
+
+		srcURL := src.containerURL.NewBlockBlobURL(src.ObjectPath(srcName)).URL()
+		dstURL := dst.containerURL.URL()
+		if !strings.EqualFold(srcURL.Host, dstURL.Host) {
+			// Different accounts, our credentials would not be accepted on the source
+			return false, nil
+		}
+
+		if skip, err := skipExistingCopy(ctx, dst, dstName); skip || err != nil {
+			return true, err
+		}
+		return true, dst.copyFromURL(ctx, srcURL, dst.ObjectPath(dstName))
+
+	case *LocalStore:
+		dst, ok := dstStore.(*LocalStore)
+		if !ok || !src.sameEncoding(dst.commonStore) {
+			return false, nil
+		}
+
+		if skip, err := skipExistingCopy(ctx, dst, dstName); skip || err != nil {
+			return true, err
+		}
+		return true, copyLocalFile(src.ObjectPath(srcName), dst.ObjectPath(dstName))
+	}
+
+	return false, nil
+}
+
+// skipExistingCopy returns true when the destination exists and the store is
+// not configured to overwrite, in which case we silently skip the copy like
+// `WriteObject` does.
+func skipExistingCopy(ctx context.Context, dst Store, dstName string) (bool, error) {
+	if dst.Overwrite() {
+		return false, nil
+	}
+
+	return dst.FileExists(ctx, dstName)
+}
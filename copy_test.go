@@ -0,0 +1,69 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopy(t *testing.T) {
+	tests := []struct {
+		name             string
+		srcOpts          []Option
+		dstOpts          []Option
+		expectServerSide bool
+	}{
+		{"same compression", []Option{Compression("zstd")}, []Option{Compression("zstd")}, true},
+		{"different compression", []Option{Compression("zstd")}, []Option{Compression("gzip")}, false},
+		{"to uncompressed", []Option{Compression("gzip")}, nil, false},
+		{"to seekable", []Option{Compression("zstd")}, []Option{Compression("zstd"), SeekableZstd(1024)}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src, err := NewLocalStoreWithOptions(&url.URL{Scheme: "file", Path: t.TempDir()}, test.srcOpts...)
+			require.NoError(t, err)
+			dst, err := NewLocalStoreWithOptions(&url.URL{Scheme: "file", Path: t.TempDir()}, test.dstOpts...)
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			require.NoError(t, src.WriteObject(ctx, "0000/file", bytes.NewReader([]byte("content"))))
+
+			require.NoError(t, Copy(ctx, src, "0000/file", dst, "0001/file"))
+
+			reader, err := dst.OpenObject(ctx, "0001/file")
+			require.NoError(t, err)
+			defer reader.Close()
+
+			content, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, "content", string(content))
+
+			srcRaw, err := ioutil.ReadFile(src.ObjectPath("0000/file"))
+			require.NoError(t, err)
+			dstRaw, err := ioutil.ReadFile(dst.ObjectPath("0001/file"))
+			require.NoError(t, err)
+			assert.Equal(t, test.expectServerSide, bytes.Equal(srcRaw, dstRaw))
+		})
+	}
+}
+
+func TestCopy_MockToLocal(t *testing.T) {
+	src := NewMockStore(nil)
+	src.SetFile("file", []byte("content"))
+
+	dst, err := NewLocalStore(&url.URL{Scheme: "file", Path: t.TempDir()}, "", "", false)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, Copy(ctx, src, "file", dst, "file"))
+
+	content, err := ioutil.ReadFile(dst.ObjectPath("file"))
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
@@ -0,0 +1,159 @@
+package dstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultDownloadPartSize is the size of the ranges fetched by
+// `DownloadObject` when its `partSize` is zero.
+const DefaultDownloadPartSize = 8 * 1024 * 1024
+
+// DefaultDownloadConcurrency is the number of ranges fetched concurrently by
+// `DownloadObject` when its `concurrency` is zero.
+const DefaultDownloadConcurrency = 5
+
+// DownloadObject fetches the object in ranges of `partSize` bytes, with up to
+// `concurrency` ranges in flight, writing each of them at its offset in `w`.
+// It returns the number of bytes written, the object content being complete
+// in `w` when the error is nil.
+//
+// A single stream is usually bound well under the bandwidth available to the
+// process, the concurrent ranges saturating it for big objects. The ranges are
+// planned from the size reported by `ObjectAttributes`, so the download is
+// meant for uncompressed stores or stores with `SeekableZstd`: each range of
+// other compressed stores decompresses the content before it.
+func DownloadObject(ctx context.Context, store Store, name string, w io.WriterAt, partSize int64, concurrency int) (n int64, err error) {
+	return DownloadObjectWithProgress(ctx, store, name, w, partSize, concurrency, nil)
+}
+
+// DownloadObjectWithProgress is `DownloadObject` reporting the progress of the
+// download to `progress`, the bytes of all the ranges added up, with the size
+// of the object as total.
+func DownloadObjectWithProgress(ctx context.Context, store Store, name string, w io.WriterAt, partSize int64, concurrency int, progress ProgressFunc) (n int64, err error) {
+	if partSize <= 0 {
+		partSize = DefaultDownloadPartSize
+	}
+	if concurrency < 1 {
+		concurrency = DefaultDownloadConcurrency
+	}
+
+	attrs, err := store.ObjectAttributes(ctx, name)
+	if err != nil {
+		return 0, fmt.Errorf("attributes %q: %w", name, err)
+	}
+
+	// The last part is read to the end of the object whatever its size, which
+	// differs from the stored size on compressed stores
+	parts := int((attrs.Size + partSize - 1) / partSize)
+	if parts < 1 {
+		parts = 1
+	}
+	written := make([]int64, parts)
+
+	var tracker *progressTracker
+	if progress != nil {
+		tracker = newProgressTracker(progress, attrs.Size)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+
+	work := make(chan int)
+	for i := 0; i < concurrency && i < parts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range work {
+				length := partSize
+				if part == parts-1 {
+					length = -1
+				}
+
+				offset := int64(part) * partSize
+				partWritten, err := downloadRange(ctx, store, name, &offsetWriter{w: w, offset: offset, tracker: tracker}, offset, length)
+				written[part] = partWritten
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("download %q range at offset %d: %w", name, offset, err)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for part := 0; part < parts; part++ {
+		select {
+		case work <- part:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// A short part is the end of the object, content after it meaning the
+	// object changed while it was downloaded
+	ended := false
+	for part, partWritten := range written {
+		if ended && partWritten > 0 {
+			return 0, fmt.Errorf("object %q changed during its download", name)
+		}
+		if part < parts-1 && partWritten < partSize {
+			ended = true
+		}
+		n += partWritten
+	}
+	if tracker != nil {
+		tracker.add(0, true)
+	}
+	return n, nil
+}
+
+// downloadRange copies the range of the object at `offset` to the same offset
+// of `w`, returning the number of bytes copied.
+func downloadRange(ctx context.Context, store Store, name string, w *offsetWriter, offset, length int64) (int64, error) {
+	reader, err := store.OpenObjectRange(ctx, name, offset, length)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(w, reader)
+	closeErr := reader.Close()
+	if err != nil {
+		return n, err
+	}
+	return n, closeErr
+}
+
+// offsetWriter writes sequentially to `w` from `offset`, counting the bytes
+// written to `tracker` when not nil.
+type offsetWriter struct {
+	w       io.WriterAt
+	offset  int64
+	tracker *progressTracker
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.offset)
+	o.offset += int64(n)
+	if o.tracker != nil {
+		o.tracker.add(int64(n), false)
+	}
+	return n, err
+}
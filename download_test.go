@@ -0,0 +1,94 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writerAtBuffer is an in-memory `io.WriterAt`.
+type writerAtBuffer struct {
+	lock    sync.Mutex
+	content []byte
+}
+
+func (b *writerAtBuffer) WriteAt(p []byte, offset int64) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if end := int(offset) + len(p); end > len(b.content) {
+		b.content = append(b.content, make([]byte, end-len(b.content))...)
+	}
+	return copy(b.content[offset:], p), nil
+}
+
+type failingRangeStore struct {
+	Store
+	offset int64
+}
+
+func (s *failingRangeStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	if offset == s.offset {
+		return nil, errors.New("broken range")
+	}
+	return s.Store.OpenObjectRange(ctx, name, offset, length)
+}
+
+func TestDownloadObject(t *testing.T) {
+	ctx := context.Background()
+	content := make([]byte, 10*1024+7)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	tests := []struct {
+		name        string
+		opts        []Option
+		content     []byte
+		partSize    int64
+		concurrency int
+	}{
+		{"uncompressed", nil, content, 1024, 4},
+		{"single part", nil, content, 1024 * 1024, 4},
+		{"sequential", nil, content, 1000, 1},
+		{"defaults", nil, content, 0, 0},
+		{"empty", nil, []byte{}, 1024, 4},
+		{"compressed", []Option{Compression("zstd")}, content, 1024, 4},
+		{"seekable", []Option{Compression("zstd"), SeekableZstd(1024)}, content, 1024, 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewMemoryStore(test.opts...)
+			require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader(test.content)))
+
+			buffer := &writerAtBuffer{}
+			n, err := DownloadObject(ctx, store, "file", buffer, test.partSize, test.concurrency)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(test.content)), n)
+			assert.Equal(t, test.content, append([]byte{}, buffer.content...))
+		})
+	}
+}
+
+func TestDownloadObject_Errors(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader(make([]byte, 4096))))
+
+	_, err := DownloadObject(ctx, store, "missing", &writerAtBuffer{}, 1024, 2)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	_, err = DownloadObject(ctx, &failingRangeStore{Store: store, offset: 2048}, "file", &writerAtBuffer{}, 1024, 2)
+	assert.EqualError(t, err, `download "file" range at offset 2048: broken range`)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = DownloadObject(canceled, store, "file", &writerAtBuffer{}, 1024, 2)
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+package dstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//
+// Dry Run Store
+//
+
+// DryRunStore is a `Store` delegating reads to the inner store and logging
+// the writes, copies, renames and deletions it would perform, with the size of
+// the objects, instead of performing them, to rehearse garbage collections
+// and migrations against production buckets. The skipped operations succeed,
+// reads not seeing their effect.
+type DryRunStore struct {
+	// Store is the inner store, only receiving reads.
+	Store
+}
+
+func NewDryRunStore(inner Store) *DryRunStore {
+	return &DryRunStore{Store: inner}
+}
+
+func (s *DryRunStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+	return NewDryRunStore(inner), nil
+}
+
+// SetOverwrite is ignored, the setting of the inner store possibly being
+// shared with writers.
+func (s *DryRunStore) SetOverwrite(enabled bool) {}
+
+// PresignPut fails with `ErrNotSupported`, the URL allowing writes the store
+// would not see.
+func (s *DryRunStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return "", fmt.Errorf("presign put %q on dry run store: %w", base, ErrNotSupported)
+}
+
+func (s *DryRunStore) logSkipped(operation, name string, fields ...zap.Field) {
+	storeLogger(s.Store).Info("dry run, skipping "+operation, append([]zap.Field{zap.String("name", name)}, fields...)...)
+}
+
+// objectSize returns the size of the object `name`, -1 when it can't be
+// fetched.
+func (s *DryRunStore) objectSize(ctx context.Context, name string) int64 {
+	attrs, err := s.Store.ObjectAttributes(ctx, name)
+	if err != nil {
+		return -1
+	}
+	return attrs.Size
+}
+
+// WriteObject reads `f` to report its size.
+func (s *DryRunStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	size, err := io.Copy(ioutil.Discard, f)
+	if err != nil {
+		return fmt.Errorf("read content: %w", err)
+	}
+	s.logSkipped("write", base, zap.Int64("size", size))
+	return nil
+}
+
+// PushLocalFile leaves `localFile` untouched.
+func (s *DryRunStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	info, err := os.Stat(localFile)
+	if err != nil {
+		return fmt.Errorf("stat local file: %w", err)
+	}
+	s.logSkipped("push", toBaseName, zap.String("local_file", localFile), zap.Int64("size", info.Size()))
+	return nil
+}
+
+func (s *DryRunStore) CopyObject(ctx context.Context, src, dst string) error {
+	s.logSkipped("copy", src, zap.String("destination", dst), zap.Int64("size", s.objectSize(ctx, src)))
+	return nil
+}
+
+func (s *DryRunStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	s.logSkipped("rename", oldName, zap.String("destination", newName), zap.Int64("size", s.objectSize(ctx, oldName)))
+	return nil
+}
+
+func (s *DryRunStore) DeleteObject(ctx context.Context, base string) error {
+	s.logSkipped("delete", base, zap.Int64("size", s.objectSize(ctx, base)))
+	return nil
+}
+
+func (s *DryRunStore) DeleteObjects(ctx context.Context, names []string) error {
+	for _, name := range names {
+		if err := s.DeleteObject(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeletePrefix walks the prefix, logging each object it would delete, and
+// returns their count.
+func (s *DryRunStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	var size int64
+	err = s.Store.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		s.logSkipped("delete", attrs.Name, zap.Int64("size", attrs.Size))
+		deleted++
+		size += attrs.Size
+		return nil
+	})
+	if err != nil {
+		return deleted, err
+	}
+
+	s.logSkipped("prefix deletion", prefix, zap.Int("objects", deleted), zap.Int64("size", size))
+	return deleted, nil
+}
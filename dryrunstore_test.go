@@ -0,0 +1,58 @@
+package dstore
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDryRunStore(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zapcore.InfoLevel)
+	inner := NewMemoryStore(Logger(zap.New(core)))
+	require.NoError(t, inner.WriteObject(ctx, "dir/a", strings.NewReader("content")))
+	require.NoError(t, inner.WriteObject(ctx, "dir/b", strings.NewReader("more")))
+	store := NewDryRunStore(inner)
+
+	data, err := ReadObject(ctx, store, "dir/a")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data), "reads go through")
+
+	require.NoError(t, store.WriteObject(ctx, "new", strings.NewReader("written")))
+	require.NoError(t, store.DeleteObject(ctx, "dir/a"))
+	deleted, err := store.DeletePrefix(ctx, "dir/")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	localFile := filepath.Join(t.TempDir(), "local")
+	require.NoError(t, ioutil.WriteFile(localFile, []byte("local"), 0644))
+	require.NoError(t, store.PushLocalFile(ctx, localFile, "pushed"))
+	assert.FileExists(t, localFile)
+
+	files, err := inner.ListFiles(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dir/a", "dir/b"}, files, "nothing is modified")
+
+	var skipped []string
+	for _, entry := range logs.AllUntimed() {
+		skipped = append(skipped, entry.Message+" "+entry.ContextMap()["name"].(string))
+	}
+	assert.Equal(t, []string{
+		"dry run, skipping write new",
+		"dry run, skipping delete dir/a",
+		"dry run, skipping delete dir/a",
+		"dry run, skipping delete dir/b",
+		"dry run, skipping prefix deletion dir/",
+		"dry run, skipping push pushed",
+	}, skipped)
+	assert.Equal(t, int64(7), logs.AllUntimed()[0].ContextMap()["size"])
+	assert.Equal(t, int64(11), logs.AllUntimed()[4].ContextMap()["size"])
+}
@@ -0,0 +1,407 @@
+package dstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+//
+// Encrypted Store
+//
+
+// KeyProvider provides the keys of an `EncryptedStore`, AES keys of 16, 24 or
+// 32 bytes identified by an ID stored along the objects they encrypt, so that
+// keys can be rotated while objects encrypted with previous keys stay
+// readable.
+type KeyProvider interface {
+	// CurrentKey returns the key encrypting the objects being written.
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+	// Key returns the key with the given ID, to decrypt objects.
+	Key(ctx context.Context, id string) (key []byte, err error)
+}
+
+type staticKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewStaticKeyProvider returns a `KeyProvider` encrypting with the key
+// `current` of `keys`, and decrypting with any of them.
+func NewStaticKeyProvider(current string, keys map[string][]byte) KeyProvider {
+	return &staticKeyProvider{current: current, keys: keys}
+}
+
+func (p *staticKeyProvider) CurrentKey(ctx context.Context) (string, []byte, error) {
+	key, err := p.Key(ctx, p.current)
+	return p.current, key, err
+}
+
+func (p *staticKeyProvider) Key(ctx context.Context, id string) ([]byte, error) {
+	key, found := p.keys[id]
+	if !found {
+		return nil, fmt.Errorf("unknown encryption key %q", id)
+	}
+	return key, nil
+}
+
+const (
+	// encryptedChunkSize is the size of the plaintext chunks sealed
+	// separately, so that objects are encrypted and decrypted as they stream
+	encryptedChunkSize = 64 * 1024
+	encryptedTagSize   = 16
+
+	// encryptedSaltSize is the size of the random salt deriving the key of
+	// each object from the key of the provider, so that chunk nonces only
+	// need to be unique within an object
+	encryptedSaltSize = 32
+
+	// EncryptionKeyIDMetadataKey is the metadata key holding the ID of the key
+	// encrypting objects written by an `EncryptedStore`.
+	EncryptionKeyIDMetadataKey = "dstore_encryption_key_id"
+)
+
+var encryptedMagic = []byte("dse1")
+
+// encryptedKeyInfo binds the derived keys to their use.
+var encryptedKeyInfo = []byte("dstore encrypted object")
+
+// EncryptedStore is a `Store` encrypting objects on the client side before
+// writing them to the inner store, and decrypting them when read, with
+// AES-GCM. Objects are encrypted in chunks, so they don't need to fit in
+// memory and ranges can be read without decrypting the whole object.
+//
+// Each object is encrypted with its own key, derived with HKDF-SHA256 from the
+// key of the provider and a random salt. The ID of the provider's key and the
+// salt are stored in the object's header, the key ID also in its
+// `dstore_encryption_key_id` metadata on the inner store. Sizes
+// returned by `ObjectAttributes` and walks are the sizes of the encrypted
+// objects. Compressing the inner store is useless, since encrypted content
+// doesn't compress, and pre-signed URLs are not supported.
+type EncryptedStore struct {
+	// Store is the inner store, holding the encrypted objects.
+	Store
+
+	keys KeyProvider
+}
+
+func NewEncryptedStore(inner Store, keys KeyProvider) *EncryptedStore {
+	return &EncryptedStore{Store: inner, keys: keys}
+}
+
+func (s *EncryptedStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptedStore(inner, s.keys), nil
+}
+
+func (s *EncryptedStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return "", fmt.Errorf("presigning encrypted objects: %w", ErrNotSupported)
+}
+
+func (s *EncryptedStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return "", fmt.Errorf("presigning encrypted objects: %w", ErrNotSupported)
+}
+
+// newEncryptionAEAD returns the cipher of the object whose header holds
+// `salt`, with a key of the size of `key` derived from it.
+func newEncryptionAEAD(key, salt []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, aes.KeySizeError(len(key))
+	}
+
+	derived := make([]byte, len(key))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, encryptedKeyInfo), derived); err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptedNonce is the chunk index on 4 bytes followed by a byte flagging
+// the last chunk, so that reordered or truncated chunks don't decrypt.
+func encryptedNonce(index uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint32(nonce[7:], index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+func (s *EncryptedStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	keyID, key, err := s.keys.CurrentKey(ctx)
+	if err != nil {
+		return fmt.Errorf("encryption key: %w", err)
+	}
+	if len(keyID) > 255 {
+		return fmt.Errorf("encryption key id %q is longer than 255 bytes", keyID)
+	}
+
+	salt := make([]byte, encryptedSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("generating salt: %w", err)
+	}
+	aead, err := newEncryptionAEAD(key, salt)
+	if err != nil {
+		return fmt.Errorf("encryption key %q: %w", keyID, err)
+	}
+
+	pipeRead, pipeWrite := io.Pipe()
+	encryptDone := make(chan error, 1)
+	go func() {
+		err := encrypt(aead, keyID, salt, f, pipeWrite)
+		pipeWrite.CloseWithError(err)
+		encryptDone <- err
+	}()
+
+	// Copied so that the caller's options are never overwritten
+	innerOpts := append(append([]WriteOption{}, opts...), WithMetadata(map[string]string{EncryptionKeyIDMetadataKey: keyID}))
+	err = s.Store.WriteObject(ctx, base, pipeRead, innerOpts...)
+	// Unblocks the encryption when the inner store returned without reading
+	// everything
+	pipeRead.Close()
+	if encryptErr := <-encryptDone; encryptErr != nil && encryptErr != io.ErrClosedPipe {
+		return encryptErr
+	}
+	return err
+}
+
+// encrypt writes the header, made of the magic, the key ID prefixed by its
+// length and the salt, followed by the sealed chunks.
+func encrypt(aead cipher.AEAD, keyID string, salt []byte, r io.Reader, w io.Writer) error {
+	header := append(append(append([]byte{}, encryptedMagic...), byte(len(keyID))), keyID...)
+	if _, err := w.Write(append(header, salt...)); err != nil {
+		return err
+	}
+
+	// Reading a byte past the chunk tells whether it's the last one
+	reader := bufio.NewReaderSize(r, encryptedChunkSize+1)
+	chunk := make([]byte, encryptedChunkSize)
+	sealed := make([]byte, 0, encryptedChunkSize+encryptedTagSize)
+	for index := uint32(0); ; index++ {
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		last := n < encryptedChunkSize
+		if !last {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				last = true
+			}
+		}
+
+		sealed = aead.Seal(sealed[:0], encryptedNonce(index, last), chunk[:n], nil)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// encryptedHeader is the header of an encrypted object.
+type encryptedHeader struct {
+	aead cipher.AEAD
+	size int64
+}
+
+func (s *EncryptedStore) readHeader(ctx context.Context, r io.Reader) (*encryptedHeader, error) {
+	prefix := make([]byte, len(encryptedMagic)+1)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("reading encryption header: %w", err)
+	}
+	if !bytes.Equal(prefix[:len(encryptedMagic)], encryptedMagic) {
+		return nil, fmt.Errorf("object is not encrypted")
+	}
+
+	rest := make([]byte, int(prefix[len(encryptedMagic)])+encryptedSaltSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, fmt.Errorf("reading encryption header: %w", err)
+	}
+
+	keyID := string(rest[:len(rest)-encryptedSaltSize])
+	key, err := s.keys.Key(ctx, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %w", err)
+	}
+	aead, err := newEncryptionAEAD(key, rest[len(rest)-encryptedSaltSize:])
+	if err != nil {
+		return nil, fmt.Errorf("encryption key %q: %w", keyID, err)
+	}
+
+	return &encryptedHeader{
+		aead: aead,
+		size: int64(len(prefix) + len(rest)),
+	}, nil
+}
+
+func (s *EncryptedStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	reader, err := s.Store.OpenObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := s.readHeader(ctx, reader)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	return newDecryptingReader(header, reader, 0), nil
+}
+
+// OpenObjectRange only reads the chunks holding the range, up to the end of
+// the object so that the last chunk is recognized as such. Ranges starting
+// past the end of the object are empty.
+func (s *EncryptedStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	// Large enough for the longest header
+	headerReader, err := s.Store.OpenObjectRange(ctx, name, 0, int64(len(encryptedMagic)+1+255+encryptedSaltSize))
+	if err != nil {
+		return nil, err
+	}
+	header, err := s.readHeader(ctx, headerReader)
+	headerReader.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	firstChunk := offset / encryptedChunkSize
+	reader, err := s.Store.OpenObjectRange(ctx, name, header.size+firstChunk*(encryptedChunkSize+encryptedTagSize), -1)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := newDecryptingReader(header, reader, uint32(firstChunk))
+	if _, err := io.CopyN(ioutil.Discard, decrypted, offset-firstChunk*encryptedChunkSize); err != nil && err != io.EOF {
+		decrypted.Close()
+		return nil, err
+	}
+	return limitReadCloser(decrypted, length), nil
+}
+
+// decryptingReader opens the chunks of an encrypted object as they're read.
+type decryptingReader struct {
+	header *encryptedHeader
+	source io.ReadCloser
+	reader *bufio.Reader
+	index  uint32
+
+	sealed  []byte
+	chunk   []byte
+	started bool
+	done    bool
+}
+
+func newDecryptingReader(header *encryptedHeader, source io.ReadCloser, index uint32) *decryptingReader {
+	return &decryptingReader{
+		header: header,
+		source: source,
+		reader: bufio.NewReaderSize(source, encryptedChunkSize+encryptedTagSize+1),
+		index:  index,
+		sealed: make([]byte, encryptedChunkSize+encryptedTagSize),
+	}
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (r *decryptingReader) next() error {
+	n, err := io.ReadFull(r.reader, r.sealed)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if n == 0 && !r.started && r.index > 0 {
+		// Ranges starting on a chunk past the last one
+		r.done = true
+		return nil
+	}
+	if n < encryptedTagSize {
+		return fmt.Errorf("encrypted object is truncated")
+	}
+
+	last := n < len(r.sealed)
+	if !last {
+		if _, peekErr := r.reader.Peek(1); peekErr == io.EOF {
+			last = true
+		}
+	}
+
+	chunk, err := r.header.aead.Open(r.sealed[:0], encryptedNonce(r.index, last), r.sealed[:n], nil)
+	if err != nil {
+		return fmt.Errorf("decrypting chunk %d: %w", r.index, err)
+	}
+
+	r.chunk = chunk
+	r.started = true
+	r.index++
+	r.done = last
+	return nil
+}
+
+func (r *decryptingReader) Close() error {
+	return r.source.Close()
+}
+
+// ObjectAttributes hides the key ID from the metadata, it can be read through
+// the inner store.
+func (s *EncryptedStore) ObjectAttributes(ctx context.Context, base string) (*ObjectAttrs, error) {
+	attrs, err := s.Store.ObjectAttributes(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	return withoutEncryptionMetadata(attrs), nil
+}
+
+func (s *EncryptedStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) error {
+	return s.Store.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(withoutEncryptionMetadata(attrs))
+	})
+}
+
+func withoutEncryptionMetadata(attrs *ObjectAttrs) *ObjectAttrs {
+	if _, found := attrs.Metadata[EncryptionKeyIDMetadataKey]; found {
+		delete(attrs.Metadata, EncryptionKeyIDMetadataKey)
+		if len(attrs.Metadata) == 0 {
+			attrs.Metadata = nil
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,159 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEncryptionKeys() map[string][]byte {
+	return map[string][]byte{
+		"key-1": bytes.Repeat([]byte{1}, 32),
+		"key-2": bytes.Repeat([]byte{2}, 16),
+	}
+}
+
+func TestEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+	store := NewEncryptedStore(inner, NewStaticKeyProvider("key-1", testEncryptionKeys()))
+
+	for _, size := range []int{0, 1, encryptedChunkSize - 1, encryptedChunkSize, encryptedChunkSize + 1, 3*encryptedChunkSize + 5} {
+		t.Run(fmt.Sprintf("%d", size), func(t *testing.T) {
+			content := make([]byte, size)
+			rand.Read(content)
+			require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader(content)))
+
+			reader, err := store.OpenObject(ctx, "file")
+			require.NoError(t, err)
+			decrypted, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			reader.Close()
+			assert.Equal(t, content, decrypted)
+
+			attrs, err := inner.ObjectAttributes(ctx, "file")
+			require.NoError(t, err)
+			assert.Equal(t, "key-1", attrs.Metadata[EncryptionKeyIDMetadataKey])
+			// Shorter contents could appear in the ciphertext by chance
+			if size >= 16 {
+				encrypted, err := inner.OpenObject(ctx, "file")
+				require.NoError(t, err)
+				raw, err := ioutil.ReadAll(encrypted)
+				require.NoError(t, err)
+				assert.NotContains(t, string(raw), string(content))
+			}
+
+			require.NoError(t, store.DeleteObject(ctx, "file"))
+		})
+	}
+}
+
+func TestEncryptedStore_OpenObjectRange(t *testing.T) {
+	ctx := context.Background()
+	store := NewEncryptedStore(NewMemoryStore(), NewStaticKeyProvider("key-1", testEncryptionKeys()))
+
+	content := make([]byte, 3*encryptedChunkSize+100)
+	rand.Read(content)
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader(content)))
+
+	tests := []struct {
+		offset, length int64
+	}{
+		{0, 10},
+		{encryptedChunkSize - 5, 10},
+		{2*encryptedChunkSize + 3, -1},
+		{3*encryptedChunkSize + 90, 50},
+		{int64(len(content)) + 10, 5},
+		{4 * encryptedChunkSize, 10},
+		{10 * encryptedChunkSize, -1},
+	}
+
+	for _, test := range tests {
+		reader, err := store.OpenObjectRange(ctx, "file", test.offset, test.length)
+		require.NoError(t, err)
+		read, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		reader.Close()
+
+		end := int64(len(content))
+		if test.length >= 0 && test.offset+test.length < end {
+			end = test.offset + test.length
+		}
+		start := test.offset
+		if start > end {
+			start = end
+		}
+		assert.Equal(t, content[start:end], read, "range %d+%d", test.offset, test.length)
+	}
+}
+
+func TestEncryptedStore_KeyRotation(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+
+	require.NoError(t, NewEncryptedStore(inner, NewStaticKeyProvider("key-1", testEncryptionKeys())).WriteObject(ctx, "old", bytes.NewReader([]byte("old"))))
+
+	store := NewEncryptedStore(inner, NewStaticKeyProvider("key-2", testEncryptionKeys()))
+	require.NoError(t, store.WriteObject(ctx, "new", bytes.NewReader([]byte("new"))))
+
+	for _, name := range []string{"old", "new"} {
+		reader, err := store.OpenObject(ctx, name)
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, name, string(content))
+	}
+
+	_, err := NewEncryptedStore(inner, NewStaticKeyProvider("key-2", map[string][]byte{"key-2": testEncryptionKeys()["key-2"]})).OpenObject(ctx, "old")
+	assert.Error(t, err)
+}
+
+func TestEncryptedStore_Tampering(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore(AllowOverwrite())
+	store := NewEncryptedStore(inner, NewStaticKeyProvider("key-1", testEncryptionKeys()))
+
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader(make([]byte, 2*encryptedChunkSize+10))))
+	reader, err := inner.OpenObject(ctx, "file")
+	require.NoError(t, err)
+	encrypted, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+
+	headerSize := len(encryptedMagic) + 1 + len("key-1") + encryptedSaltSize
+	flipped := append([]byte{}, encrypted...)
+	flipped[len(flipped)-1] ^= 1
+	saltFlipped := append([]byte{}, encrypted...)
+	saltFlipped[headerSize-1] ^= 1
+	truncated := encrypted[:headerSize+2*(encryptedChunkSize+encryptedTagSize)]
+
+	for _, tampered := range [][]byte{flipped, saltFlipped, truncated} {
+		require.NoError(t, inner.WriteObject(ctx, "file", bytes.NewReader(tampered)))
+
+		reader, err := store.OpenObject(ctx, "file")
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(reader)
+		assert.Error(t, err)
+	}
+}
+
+func TestEncryptedStore_WriteOptions(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+	store := NewEncryptedStore(inner, NewStaticKeyProvider("key-1", testEncryptionKeys()))
+
+	// The spare capacity of the caller's options is left untouched
+	opts := make([]WriteOption, 1, 2)
+	opts[0] = WithMetadata(map[string]string{"owner": "test"})
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader([]byte("content")), opts...))
+	assert.Nil(t, opts[:2][1])
+
+	attrs, err := inner.ObjectAttributes(ctx, "file")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "test", EncryptionKeyIDMetadataKey: "key-1"}, attrs.Metadata)
+}
@@ -0,0 +1,232 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"google.golang.org/api/googleapi"
+)
+
+// The classes of the errors returned by the backends, matched with
+// `errors.Is` while the original error of the backend remains reachable with
+// `errors.As`.
+var (
+	// ErrTransient is matched by errors likely to go away when the operation
+	// is retried: network failures, timeouts and server errors.
+	ErrTransient = errors.New("transient error")
+	// ErrRateLimited is matched by errors of operations throttled by the
+	// backend, which also match `ErrTransient`.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrPermissionDenied is matched by errors of operations the credentials
+	// of the store are not allowed to perform.
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// classifiedError tags an error of a backend with its class.
+type classifiedError struct {
+	err   error
+	class error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class || (e.class == ErrRateLimited && target == ErrTransient)
+}
+
+// withErrorClass tags `err` with its class, if any.
+func withErrorClass(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return err
+	}
+	if class := errorClass(err); class != nil {
+		return &classifiedError{err: err, class: class}
+	}
+	return err
+}
+
+// classifyError tags the error pointed by `err` with its class, deferred by
+// the methods of the backends so that all their errors are classified.
+func classifyError(err *error) {
+	*err = withErrorClass(*err)
+}
+
+// newStatusError tags `err` with the class of the HTTP `status` it was built
+// from, for the backends talking HTTP without an SDK.
+func newStatusError(status int, err error) error {
+	if class := statusClass(status); class != nil {
+		return &classifiedError{err: err, class: class}
+	}
+	return err
+}
+
+// errorClass returns the class of an error of a backend, nil when it has none
+// or when it is a canceled context.
+func errorClass(err error) error {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return nil
+	case errors.Is(err, os.ErrPermission):
+		return ErrPermissionDenied
+	case errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE):
+		return ErrTransient
+	}
+
+	var gsErr *googleapi.Error
+	if errors.As(err, &gsErr) {
+		// Google Storage also throttles with `403 Forbidden` answers
+		for _, item := range gsErr.Errors {
+			if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+				return ErrRateLimited
+			}
+		}
+		return statusClass(gsErr.Code)
+	}
+	var s3Err awserr.Error
+	if errors.As(err, &s3Err) {
+		switch s3Err.Code() {
+		case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded":
+			return ErrRateLimited
+		case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken":
+			return ErrPermissionDenied
+		case "RequestError", "RequestTimeout", request.ErrCodeResponseTimeout, request.ErrCodeRead:
+			return ErrTransient
+		}
+		if failure, ok := s3Err.(awserr.RequestFailure); ok {
+			return statusClass(failure.StatusCode())
+		}
+		if s3Err.OrigErr() != nil {
+			return errorClass(s3Err.OrigErr())
+		}
+		return nil
+	}
+	// Checked before `net.Error`, which Azure errors implement whatever their
+	// status
+	var azureErr azblob.StorageError
+	if errors.As(err, &azureErr) {
+		if azureErr.ServiceCode() == azblob.ServiceCodeServerBusy {
+			return ErrRateLimited
+		}
+		if resp := azureErr.Response(); resp != nil {
+			return statusClass(resp.StatusCode)
+		}
+		return nil
+	}
+	for _, backend := range backends {
+		if backend.errorClass == nil {
+			continue
+		}
+		if class := backend.errorClass(err); class != nil {
+			return class
+		}
+	}
+	// System errors implement `net.Error` too, the relevant ones being
+	// checked above
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if _, isErrno := netErr.(syscall.Errno); !isErrno {
+			return ErrTransient
+		}
+	}
+	return nil
+}
+
+func statusClass(status int) error {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrPermissionDenied
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ErrTransient
+	}
+	return nil
+}
+
+// ErrorClass returns the class of `err` as a short label suited to metrics:
+// "not_found", "rate_limited", "transient", "permission_denied",
+// "not_supported", "canceled" or "other", and "" when `err` is nil.
+func ErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	err = withErrorClass(err)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrTransient):
+		return "transient"
+	case errors.Is(err, ErrPermissionDenied):
+		return "permission_denied"
+	case errors.Is(err, ErrNotSupported):
+		return "not_supported"
+	}
+	return "other"
+}
+
+// retryAfterError is implemented by the errors of backends answering with a
+// `Retry-After` header that their SDK does not expose.
+type retryAfterError interface {
+	retryAfter() time.Duration
+}
+
+// retryAfter returns the wait requested by the `Retry-After` header of the
+// response `err` comes from, zero when there is none.
+func retryAfter(err error) time.Duration {
+	var gsErr *googleapi.Error
+	if errors.As(err, &gsErr) {
+		return parseRetryAfter(gsErr.Header.Get("Retry-After"), time.Now())
+	}
+	var hinted retryAfterError
+	if errors.As(err, &hinted) {
+		return hinted.retryAfter()
+	}
+	return 0
+}
+
+// parseRetryAfter parses a `Retry-After` header holding either a number of
+// seconds or an HTTP date, returning zero when it is invalid or in the past.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
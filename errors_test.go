@@ -0,0 +1,95 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+)
+
+func TestErrorClasses(t *testing.T) {
+	for _, test := range []struct {
+		err   error
+		class error
+	}{
+		{err: errors.New("some error"), class: nil},
+		{err: fmt.Errorf("open: %w", ErrNotFound), class: nil},
+		{err: fmt.Errorf("read: %w", context.Canceled), class: nil},
+		{err: fmt.Errorf("read: %w", syscall.ECONNRESET), class: ErrTransient},
+		{err: &os.PathError{Op: "open", Path: "file", Err: syscall.EACCES}, class: ErrPermissionDenied},
+		{err: &os.PathError{Op: "remove", Path: "file", Err: syscall.ENOENT}, class: nil},
+		{err: &googleapi.Error{Code: http.StatusServiceUnavailable}, class: ErrTransient},
+		{err: &googleapi.Error{Code: http.StatusForbidden}, class: ErrPermissionDenied},
+		{err: &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, class: ErrRateLimited},
+		{err: &googleapi.Error{Code: http.StatusNotFound}, class: nil},
+		{err: awserr.NewRequestFailure(awserr.New("SlowDown", "reduce your request rate", nil), http.StatusServiceUnavailable, "id"), class: ErrRateLimited},
+		{err: awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), http.StatusForbidden, "id"), class: ErrPermissionDenied},
+		{err: awserr.NewRequestFailure(awserr.New("InternalError", "internal error", nil), http.StatusInternalServerError, "id"), class: ErrTransient},
+		{err: awserr.New("RequestError", "send request failed", syscall.ECONNREFUSED), class: ErrTransient},
+	} {
+		assertErrorClass(t, test.err, test.class)
+	}
+}
+
+// assertErrorClass checks that `err`, once classified, matches `class` only,
+// and remains reachable.
+func assertErrorClass(t *testing.T, err error, class error) {
+	t.Helper()
+
+	classified := withErrorClass(fmt.Errorf("operation: %w", err))
+	for _, candidate := range []error{ErrTransient, ErrRateLimited, ErrPermissionDenied} {
+		expected := candidate == class || (candidate == ErrTransient && class == ErrRateLimited)
+		assert.Equal(t, expected, errors.Is(classified, candidate), "%v is %v", err, candidate)
+	}
+	assert.True(t, errors.Is(classified, err), "%v remains reachable", err)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		value    string
+		expected time.Duration
+	}{
+		{value: "", expected: 0},
+		{value: "120", expected: 2 * time.Minute},
+		{value: "-1", expected: 0},
+		{value: "soon", expected: 0},
+		{value: "Tue, 01 Jun 2021 12:00:30 GMT", expected: 30 * time.Second},
+		{value: "Tue, 01 Jun 2021 11:59:30 GMT", expected: 0},
+	} {
+		assert.Equal(t, test.expected, parseRetryAfter(test.value, now), "%q", test.value)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	header := http.Header{"Retry-After": []string{"5"}}
+
+	gsErr := &googleapi.Error{Code: http.StatusTooManyRequests, Header: header}
+	assert.Equal(t, 5*time.Second, retryAfter(withErrorClass(fmt.Errorf("walk: %w", gsErr))))
+	assert.Equal(t, time.Duration(0), retryAfter(&googleapi.Error{Code: http.StatusTooManyRequests}))
+	assert.Equal(t, time.Duration(0), retryAfter(errors.New("some error")))
+
+	r := &request.Request{
+		Error:        awserr.NewRequestFailure(awserr.New("SlowDown", "reduce your request rate", nil), http.StatusServiceUnavailable, "id"),
+		HTTPResponse: &http.Response{StatusCode: http.StatusServiceUnavailable, Header: header},
+	}
+	s3RetryAfter(r)
+	err := withErrorClass(fmt.Errorf("walk: %w", r.Error))
+	assert.Equal(t, 5*time.Second, retryAfter(err))
+	assert.True(t, errors.Is(err, ErrRateLimited))
+
+	var failure awserr.RequestFailure
+	require.True(t, errors.As(err, &failure))
+	assert.Equal(t, "SlowDown", failure.Code())
+	assert.Equal(t, http.StatusServiceUnavailable, failure.StatusCode())
+}
@@ -0,0 +1,95 @@
+package dstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+//
+// Fallback Store
+//
+
+// FallbackStore is a `Store` reading objects from its primary store, and from
+// its secondary stores in order when the primary doesn't have them, for
+// example while migrating objects to a new bucket. Only `OpenObject`,
+// `OpenObjectRange`, `FileExists` and `ObjectAttributes` fall back, every other
+// operation, including listings and writes, is performed on the primary store.
+type FallbackStore struct {
+	// Store is the primary store.
+	Store
+
+	secondaries []Store
+}
+
+func NewFallbackStore(primary Store, secondaries ...Store) *FallbackStore {
+	return &FallbackStore{Store: primary, secondaries: secondaries}
+}
+
+// SubStore returns a fallback store on the sub stores of the primary and
+// secondary stores.
+func (s *FallbackStore) SubStore(subFolder string) (Store, error) {
+	primary, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	secondaries := make([]Store, len(s.secondaries))
+	for i, secondary := range s.secondaries {
+		if secondaries[i], err = secondary.SubStore(subFolder); err != nil {
+			return nil, fmt.Errorf("sub store of %s: %w", secondary.BaseURL(), err)
+		}
+	}
+
+	return NewFallbackStore(primary, secondaries...), nil
+}
+
+// fallback calls `f` on each store in order until one doesn't return
+// `ErrNotFound`.
+func (s *FallbackStore) fallback(f func(store Store) error) error {
+	err := f(s.Store)
+	for _, secondary := range s.secondaries {
+		if err != ErrNotFound {
+			return err
+		}
+		err = f(secondary)
+	}
+	return err
+}
+
+func (s *FallbackStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	err = s.fallback(func(store Store) (err error) {
+		out, err = store.OpenObject(ctx, name)
+		return err
+	})
+	return out, err
+}
+
+func (s *FallbackStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	err = s.fallback(func(store Store) (err error) {
+		out, err = store.OpenObjectRange(ctx, name, offset, length)
+		return err
+	})
+	return out, err
+}
+
+func (s *FallbackStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	err = s.fallback(func(store Store) (err error) {
+		if exists, err = store.FileExists(ctx, base); err == nil && !exists {
+			return ErrNotFound
+		}
+		return err
+	})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return exists, err
+}
+
+func (s *FallbackStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	err = s.fallback(func(store Store) (err error) {
+		attrs, err = store.ObjectAttributes(ctx, base)
+		return err
+	})
+	return attrs, err
+}
@@ -0,0 +1,58 @@
+package dstore
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFallbackStore(t *testing.T) {
+	ctx := context.Background()
+	primary, old, older := NewMemoryStore(), NewMemoryStore(), NewMemoryStore(Compression("zstd"))
+	require.NoError(t, primary.WriteObject(ctx, "sub/file", strings.NewReader("primary")))
+	require.NoError(t, old.WriteObject(ctx, "sub/file", strings.NewReader("old")))
+	require.NoError(t, old.WriteObject(ctx, "sub/migrating", strings.NewReader("old")))
+	require.NoError(t, older.WriteObject(ctx, "sub/archived", strings.NewReader("older")))
+
+	root := NewFallbackStore(primary, old, older)
+	store, err := root.SubStore("sub")
+	require.NoError(t, err)
+
+	for name, expected := range map[string]string{"file": "primary", "migrating": "old", "archived": "older"} {
+		reader, err := store.OpenObject(ctx, name)
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		reader.Close()
+		assert.Equal(t, expected, string(content), name)
+
+		exists, err := store.FileExists(ctx, name)
+		require.NoError(t, err)
+		assert.True(t, exists, name)
+	}
+
+	reader, err := store.OpenObjectRange(ctx, "archived", 1, 3)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "lde", string(content))
+
+	attrs, err := store.ObjectAttributes(ctx, "migrating")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), attrs.Size)
+
+	_, err = store.OpenObject(ctx, "missing")
+	assert.Equal(t, ErrNotFound, err)
+	exists, err := store.FileExists(ctx, "missing")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Listings and writes only involve the primary
+	files, err := store.ListFiles(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"file"}, files)
+}
@@ -0,0 +1,883 @@
+//go:build !dstore_no_ftp
+
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"go.uber.org/zap"
+)
+
+// ftpMaxIdleConnections bounds the connections kept open between operations,
+// and the number of concurrent deletions, FTP servers commonly limiting the
+// connections per client.
+const ftpMaxIdleConnections = 4
+
+// ftpIdleCheckAfter is how long a pooled connection can stay idle before it
+// is checked with a NOOP, servers closing idle connections on their own.
+var ftpIdleCheckAfter = 15 * time.Second
+
+// ftpMaxResumeAttempts is how many times in a row a download is resumed after
+// a disconnection without receiving any data.
+var ftpMaxResumeAttempts = 5
+
+//
+// FTP Store
+//
+
+func init() {
+	registerBackend(&backend{
+		schemes: []string{"ftp", "ftps"},
+		newStore: func(base *url.URL, opts ...Option) (Store, error) {
+			store, err := NewFTPStoreWithOptions(base, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		},
+		errorClass: ftpErrorClass,
+	})
+}
+
+// ftpErrorClass returns the class of the replies of FTP servers, transient
+// for the 4xx ones.
+func ftpErrorClass(err error) error {
+	var ftpReply *textproto.Error
+	if errors.As(err, &ftpReply) {
+		switch {
+		case ftpReply.Code == ftp.StatusNotLoggedIn:
+			return ErrPermissionDenied
+		case ftpReply.Code >= 400 && ftpReply.Code < 500:
+			return ErrTransient
+		}
+	}
+	return nil
+}
+
+type FTPStore struct {
+	baseURL  *url.URL
+	basePath string
+	pool     *ftpPool
+	*commonStore
+}
+
+func NewFTPStore(baseURL *url.URL, extension, compressionType string, overwrite bool) (*FTPStore, error) {
+	return NewFTPStoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+// NewFTPStoreWithOptions connects to the `ftp://user@host:port/path` URL, or
+// to `ftps://...` for FTP over explicit TLS, with `implicit_tls=true` for
+// servers only speaking implicit TLS (port 990 by default). It logs in with
+// the URL's credentials or the `FTP_USERNAME` and `FTP_PASSWORD` environment
+// variables, anonymously when none are set. Transfers use passive mode, with
+// `disable_epsv=true` to only use PASV for servers mishandling EPSV.
+//
+// Downloads interrupted by a disconnection are resumed where they stopped.
+func NewFTPStoreWithOptions(baseURL *url.URL, opts ...Option) (*FTPStore, error) {
+	dial, err := newFTPDialFunc(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &ftpPool{dial: dial}
+
+	// Connects upfront so bad addresses or credentials fail here
+	conn, err := pool.get()
+	if err != nil {
+		return nil, err
+	}
+	pool.put(conn, nil)
+
+	return newFTPStore(baseURL, pool, newConfig(opts))
+}
+
+func newFTPStore(baseURL *url.URL, pool *ftpPool, config *config) (*FTPStore, error) {
+	store := &FTPStore{
+		baseURL:     baseURL,
+		basePath:    path.Clean("/" + baseURL.Path),
+		pool:        pool,
+		commonStore: newCommonStore(baseURL, config),
+	}
+
+	if err := store.withConn(func(conn *ftp.ServerConn) error { return ftpMkdirAll(conn, store.basePath) }); err != nil {
+		return nil, fmt.Errorf("unable to create base path %q: %w", store.basePath, err)
+	}
+	return store, nil
+}
+
+// newFTPDialFunc returns the function opening new logged in connections to
+// the server of `baseURL`.
+func newFTPDialFunc(baseURL *url.URL) (func() (*ftp.ServerConn, error), error) {
+	query := baseURL.Query()
+	port := "21"
+
+	dialOptions := []ftp.DialOption{
+		ftp.DialWithTimeout(30 * time.Second),
+		ftp.DialWithDisabledEPSV(query.Get("disable_epsv") == "true"),
+	}
+
+	switch baseURL.Scheme {
+	case "ftp":
+	case "ftps":
+		tlsConfig := &tls.Config{
+			ServerName:         baseURL.Hostname(),
+			InsecureSkipVerify: query.Get("insecure_skip_verify") == "true",
+			// Many servers require data connections to resume the control
+			// connection's TLS session
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		}
+
+		if query.Get("implicit_tls") == "true" {
+			port = "990"
+			dialOptions = append(dialOptions, ftp.DialWithTLS(tlsConfig))
+		} else {
+			dialOptions = append(dialOptions, ftp.DialWithExplicitTLS(tlsConfig))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported ftp scheme %q, use ftp:// or ftps://", baseURL.Scheme)
+	}
+
+	if baseURL.Hostname() == "" {
+		return nil, fmt.Errorf("specify ftp host like: ftp://user@host/path")
+	}
+
+	addr := baseURL.Host
+	if baseURL.Port() == "" {
+		addr = net.JoinHostPort(baseURL.Hostname(), port)
+	}
+
+	username, password := os.Getenv("FTP_USERNAME"), os.Getenv("FTP_PASSWORD")
+	if baseURL.User != nil {
+		username = baseURL.User.Username()
+		if urlPassword, ok := baseURL.User.Password(); ok {
+			password = urlPassword
+		}
+	}
+	if username == "" {
+		username, password = "anonymous", "anonymous"
+	}
+
+	return func() (*ftp.ServerConn, error) {
+		conn, err := ftp.Dial(addr, dialOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("ftp dial %q: %w", addr, err)
+		}
+
+		if err := conn.Login(username, password); err != nil {
+			conn.Quit()
+			return nil, fmt.Errorf("ftp login as %q: %w", username, err)
+		}
+		return conn, nil
+	}, nil
+}
+
+// ftpPool holds the idle connections of a store and its sub stores, an FTP
+// connection running a single command at a time.
+type ftpPool struct {
+	dial func() (*ftp.ServerConn, error)
+
+	lock sync.Mutex
+	idle []*ftpIdleConn
+}
+
+type ftpIdleConn struct {
+	conn  *ftp.ServerConn
+	since time.Time
+}
+
+func (p *ftpPool) get() (*ftp.ServerConn, error) {
+	for {
+		p.lock.Lock()
+		if len(p.idle) == 0 {
+			p.lock.Unlock()
+			return p.dial()
+		}
+		idle := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.lock.Unlock()
+
+		if time.Since(idle.since) < ftpIdleCheckAfter || idle.conn.NoOp() == nil {
+			return idle.conn, nil
+		}
+		idle.conn.Quit()
+	}
+}
+
+// put returns the connection to the pool once done with it, closing it
+// instead when `err` is neither a reply of the server nor `ErrNotFound` and so
+// might have left the connection in an unknown state.
+func (p *ftpPool) put(conn *ftp.ServerConn, err error) {
+	if err != nil && !isFTPReply(err) && err != ErrNotFound {
+		conn.Quit()
+		return
+	}
+
+	p.lock.Lock()
+	if len(p.idle) >= ftpMaxIdleConnections {
+		p.lock.Unlock()
+		conn.Quit()
+		return
+	}
+	p.idle = append(p.idle, &ftpIdleConn{conn: conn, since: time.Now()})
+	p.lock.Unlock()
+}
+
+func isFTPReply(err error) bool {
+	var reply *textproto.Error
+	return errors.As(err, &reply)
+}
+
+// isFTPNotFound reports whether the server answered that the file is
+// unavailable, the reply FTP servers give for missing files.
+func isFTPNotFound(err error) bool {
+	var reply *textproto.Error
+	return errors.As(err, &reply) && reply.Code == ftp.StatusFileUnavailable
+}
+
+// withConn runs `f` with a connection of the pool.
+func (s *FTPStore) withConn(f func(conn *ftp.ServerConn) error) error {
+	conn, err := s.pool.get()
+	if err != nil {
+		return err
+	}
+
+	err = f(conn)
+	s.pool.put(conn, err)
+	return err
+}
+
+// ftpMkdirAll creates the directory `dir` along with its missing parents.
+func ftpMkdirAll(conn *ftp.ServerConn, dir string) error {
+	if dir == "/" || dir == "." {
+		return nil
+	}
+	if err := conn.MakeDir(dir); err == nil {
+		return nil
+	}
+
+	// The directory either exists already or misses its parent, the server
+	// answering the same in both cases
+	if err := ftpMkdirAll(conn, path.Dir(dir)); err != nil {
+		return err
+	}
+	if err := conn.MakeDir(dir); err != nil && !isFTPReply(err) {
+		return err
+	}
+	return nil
+}
+
+// SubStore shares the connection pool of the parent store.
+func (s *FTPStore) SubStore(subFolder string) (Store, error) {
+	url, err := url.Parse(s.baseURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("ftp store parsing base url: %w", err)
+	}
+	url.Path = path.Join(url.Path, subFolder)
+	return newFTPStore(url, s.pool, newConfig(s.options()))
+}
+
+func (s *FTPStore) BaseURL() *url.URL {
+	return s.baseURL
+}
+
+func (s *FTPStore) ObjectPath(name string) string {
+	return path.Join(s.basePath, s.pathWithExt(name))
+}
+
+func (s *FTPStore) ObjectURL(name string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(s.baseURL.String(), "/"), strings.TrimLeft(s.pathWithExt(name), "/"))
+}
+
+func (s *FTPStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return "", fmt.Errorf("ftp store presign: %w", ErrNotSupported)
+}
+
+func (s *FTPStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return "", fmt.Errorf("ftp store presign: %w", ErrNotSupported)
+}
+
+func (s *FTPStore) toBaseName(filePath string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(filePath, s.pathWithExt("")), strings.TrimSuffix(s.basePath, "/")+"/")
+}
+
+func (s *FTPStore) WriteObject(ctx context.Context, base string, reader io.Reader, opts ...WriteOption) (err error) {
+	defer classifyError(&err)
+	destPath := s.ObjectPath(base)
+	config := newWriteConfig(opts)
+	reader = config.trackProgress(reader)
+	if config.acl != "" {
+		return fmt.Errorf("object ACL: %w", ErrNotSupported)
+	}
+
+	if !s.overwrite {
+		exists, err := s.FileExists(ctx, base)
+		if err != nil {
+			return err
+		}
+		if exists {
+			// We silently ignore when we ask not to overwrite
+			return nil
+		}
+	}
+
+	return s.writeFile(destPath, config.metadata, func(w io.Writer) error {
+		return s.compressedCopy(ctx, reader, w)
+	})
+}
+
+// writeFile uploads the file to a temporary file renamed once complete, so
+// readers never see a partially written file.
+func (s *FTPStore) writeFile(destPath string, metadata map[string]string, write func(w io.Writer) error) error {
+	tempPath := fmt.Sprintf("%s.%08x.tmp", destPath, rand.Uint32())
+
+	return s.withConn(func(conn *ftp.ServerConn) error {
+		targetDir := path.Dir(tempPath)
+		if err := ftpMkdirAll(conn, targetDir); err != nil {
+			return fmt.Errorf("ensuring directory exists (mkdir -p) %q: %w", targetDir, err)
+		}
+
+		if err := ftpStore(conn, tempPath, write); err != nil {
+			conn.Delete(tempPath)
+			return err
+		}
+
+		if err := s.sidecarFiles(conn).writeMetadata(destPath, metadata); err != nil {
+			conn.Delete(tempPath)
+			return err
+		}
+
+		if err := ftpRename(conn, tempPath, destPath); err != nil {
+			conn.Delete(tempPath)
+			return fmt.Errorf("rename: %w", err)
+		}
+		return nil
+	})
+}
+
+// ftpStore uploads the content written by `write` to `filePath`.
+func ftpStore(conn *ftp.ServerConn, filePath string, write func(w io.Writer) error) error {
+	pipeRead, pipeWrite := io.Pipe()
+	writeDone := make(chan error, 1)
+	go func() {
+		err := write(pipeWrite)
+		pipeWrite.CloseWithError(err)
+		writeDone <- err
+	}()
+
+	err := conn.Stor(filePath, pipeRead)
+	pipeRead.Close()
+	if copyErr := <-writeDone; copyErr != nil && copyErr != io.ErrClosedPipe {
+		return copyErr
+	}
+	return err
+}
+
+// ftpRename replaces `newPath` when it exists, which some servers refuse to
+// do, by deleting it before renaming again.
+func ftpRename(conn *ftp.ServerConn, oldPath, newPath string) error {
+	err := conn.Rename(oldPath, newPath)
+	if err == nil || !isFTPReply(err) {
+		return err
+	}
+
+	if err := conn.Delete(newPath); err != nil {
+		if isFTPNotFound(err) {
+			// The rename failure was not about the existing file
+			return conn.Rename(oldPath, newPath)
+		}
+		return err
+	}
+	return conn.Rename(oldPath, newPath)
+}
+
+// sidecarFiles returns the primitives of the metadata sidecar files, going
+// through `conn`.
+func (s *FTPStore) sidecarFiles(conn *ftp.ServerConn) sidecarFiles {
+	return sidecarFiles{
+		open: func(path string) (io.ReadCloser, error) {
+			resp, err := conn.Retr(path)
+			if err != nil {
+				return nil, err
+			}
+			return resp, nil
+		},
+		write: func(path string, data []byte) error {
+			return conn.Stor(path, bytes.NewReader(data))
+		},
+		remove:   conn.Delete,
+		notFound: isFTPNotFound,
+	}
+}
+
+// CopyObject streams the object through the client, FTP having no server
+// side copy.
+func (s *FTPStore) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, s, dst); skip || err != nil {
+		return err
+	}
+
+	srcPath := s.ObjectPath(src)
+	srcFile, err := s.openFile(srcPath, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	var metadata map[string]string
+	if err := s.withConn(func(conn *ftp.ServerConn) (err error) {
+		metadata, err = s.sidecarFiles(conn).readMetadata(srcPath)
+		return err
+	}); err != nil {
+		return fmt.Errorf("reading metadata: %w", err)
+	}
+
+	return s.writeFile(s.ObjectPath(dst), metadata, func(w io.Writer) error {
+		_, err := io.Copy(w, srcFile)
+		return err
+	})
+}
+
+func (s *FTPStore) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer classifyError(&err)
+	oldPath := s.ObjectPath(oldName)
+
+	if skip, err := skipExistingCopy(ctx, s, newName); skip || err != nil {
+		if err != nil {
+			return err
+		}
+		return s.DeleteObject(ctx, oldName)
+	}
+
+	newPath := s.ObjectPath(newName)
+
+	return s.withConn(func(conn *ftp.ServerConn) error {
+		targetDir := path.Dir(newPath)
+		if err := ftpMkdirAll(conn, targetDir); err != nil {
+			return fmt.Errorf("ensuring directory exists (mkdir -p) %q: %w", targetDir, err)
+		}
+
+		metadata, err := s.sidecarFiles(conn).readMetadata(oldPath)
+		if err != nil {
+			return fmt.Errorf("reading metadata: %w", err)
+		}
+
+		if _, err := conn.FileSize(oldPath); err != nil {
+			if isFTPNotFound(err) {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		if err := ftpRename(conn, oldPath, newPath); err != nil {
+			return fmt.Errorf("rename: %w", err)
+		}
+
+		if err := s.sidecarFiles(conn).writeMetadata(newPath, metadata); err != nil {
+			return err
+		}
+		if err := conn.Delete(oldPath + localMetadataSuffix); err != nil && !isFTPNotFound(err) {
+			return fmt.Errorf("removing metadata: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *FTPStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(name)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file", zap.String("path", path))
+	}
+
+	reader, err := s.openFile(path, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.uncompressedReader(ctx, s.resumeReads(ctx, name, reader, s.openStoredRange))
+}
+
+func (s *FTPStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	if s.decompressesReads() {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+	reader, err := s.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.throttleReads(ctx, reader), nil
+}
+
+func (s *FTPStore) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	return s.openFile(s.ObjectPath(name), offset, length)
+}
+
+// openFile opens a reader of `length` bytes of the file from `offset`, up to
+// the end of the file when `length` is negative. The size of the file is
+// fetched first so interrupted downloads are told apart from complete ones.
+func (s *FTPStore) openFile(filePath string, offset, length int64) (io.ReadCloser, error) {
+	var size int64
+	err := s.withConn(func(conn *ftp.ServerConn) (err error) {
+		size, err = conn.FileSize(filePath)
+		return err
+	})
+	if err != nil {
+		if isFTPNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+
+	return &ftpReader{pool: s.pool, logger: s.logger, path: filePath, offset: offset, end: end}, nil
+}
+
+// ftpReader downloads the `[offset, end)` range of the file, resuming the
+// download from where it stopped when the connection drops.
+type ftpReader struct {
+	pool   *ftpPool
+	logger *zap.Logger
+	path   string
+
+	offset   int64
+	end      int64
+	attempts int
+
+	conn *ftp.ServerConn
+	resp *ftp.Response
+}
+
+func (r *ftpReader) Read(p []byte) (int, error) {
+	for {
+		if r.offset >= r.end {
+			return 0, io.EOF
+		}
+
+		if r.resp == nil {
+			if err := r.open(); err != nil {
+				if isFTPReply(err) || !r.retry(err) {
+					return 0, err
+				}
+				continue
+			}
+		}
+
+		if remaining := r.end - r.offset; int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+
+		n, err := r.resp.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			r.attempts = 0
+		}
+
+		if err != nil && r.offset < r.end {
+			// The transfer stopped before the end, even with io.EOF
+			if !r.retry(err) {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return n, err
+			}
+			if n == 0 {
+				continue
+			}
+		}
+		return n, nil
+	}
+}
+
+func (r *ftpReader) open() error {
+	conn, err := r.pool.get()
+	if err != nil {
+		return err
+	}
+
+	resp, err := conn.RetrFrom(r.path, uint64(r.offset))
+	if err != nil {
+		r.pool.put(conn, err)
+		if isFTPNotFound(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	r.conn, r.resp = conn, resp
+	return nil
+}
+
+// retry drops the current connection and reports whether the download should
+// be resumed after `err`.
+func (r *ftpReader) retry(err error) bool {
+	if r.resp != nil {
+		r.resp.Close()
+		r.conn.Quit()
+		r.conn, r.resp = nil, nil
+	}
+
+	r.attempts++
+	if r.attempts > ftpMaxResumeAttempts {
+		return false
+	}
+
+	r.logger.Info("resuming interrupted ftp download", zap.String("path", r.path), zap.Int64("offset", r.offset), zap.Error(err))
+	return true
+}
+
+// Close keeps the connection for later operations when the server confirms
+// the end of the transfer, which servers do not when it is closed early.
+func (r *ftpReader) Close() error {
+	if r.resp == nil {
+		return nil
+	}
+
+	err := r.resp.Close()
+	if err != nil {
+		r.conn.Quit()
+	} else {
+		r.pool.put(r.conn, nil)
+	}
+	r.conn, r.resp = nil, nil
+	return nil
+}
+
+func (s *FTPStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer classifyError(&err)
+	err = s.withConn(func(conn *ftp.ServerConn) error {
+		_, err := conn.FileSize(s.ObjectPath(base))
+		return err
+	})
+	if err != nil {
+		if isFTPNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ObjectAttributes finds the object in the listing of its directory, servers
+// not supporting the listing of single files consistently.
+func (s *FTPStore) ObjectAttributes(ctx context.Context, base string) (out *ObjectAttrs, err error) {
+	defer classifyError(&err)
+	objectPath := s.ObjectPath(base)
+
+	err = s.withConn(func(conn *ftp.ServerConn) error {
+		entries, err := s.list(conn, path.Dir(objectPath))
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if entry.Name == path.Base(objectPath) && entry.Type == ftp.EntryTypeFile {
+				out, err = s.newObjectAttrs(conn, base, objectPath, entry)
+				return err
+			}
+		}
+		return ErrNotFound
+	})
+	if isFTPNotFound(err) {
+		return nil, ErrNotFound
+	}
+	return out, err
+}
+
+func (s *FTPStore) newObjectAttrs(conn *ftp.ServerConn, name, objectPath string, entry *ftp.Entry) (*ObjectAttrs, error) {
+	metadata, err := s.sidecarFiles(conn).readMetadata(objectPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+
+	return &ObjectAttrs{
+		Name:         name,
+		Size:         int64(entry.Size),
+		LastModified: entry.Time,
+		ETag:         fmt.Sprintf("%x-%x", entry.Time.UnixNano(), entry.Size),
+		Metadata:     metadata,
+	}, nil
+}
+
+// list lists the entries of `dir` in name order, without the `.` and `..`
+// entries some servers include.
+func (s *FTPStore) list(conn *ftp.ServerConn, dir string) ([]*ftp.Entry, error) {
+	entries, err := conn.List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	out := entries[:0]
+	for _, entry := range entries {
+		if entry.Name != "." && entry.Name != ".." {
+			out = append(out, entry)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (s *FTPStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer classifyError(&err)
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+func (s *FTPStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer classifyError(&err)
+	return listFiles(ctx, s, prefix, max)
+}
+
+func (s *FTPStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer classifyError(&err)
+	return listFilesPage(ctx, s, prefix, pageSize, pageToken)
+}
+
+func (s *FTPStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer classifyError(&err)
+	prefix = strings.Trim(prefix, "/")
+
+	err = s.withConn(func(conn *ftp.ServerConn) error {
+		entries, err := s.list(conn, path.Join(s.basePath, prefix))
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if entry.Type == ftp.EntryTypeFolder {
+				out = append(out, path.Join(prefix, entry.Name))
+			}
+		}
+		return nil
+	})
+	if isFTPNotFound(err) {
+		return nil, nil
+	}
+	return out, err
+}
+
+func (s *FTPStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
+}
+
+func (s *FTPStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkBetween(s, ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *FTPStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+// WalkObjects holds a connection for the whole walk, the operations run by
+// `f` using other connections of the pool.
+func (s *FTPStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer classifyError(&err)
+	conn, err := s.pool.get()
+	if err != nil {
+		return err
+	}
+
+	var callbackErr error
+	err = s.walkDirectories(ctx, s.basePath, prefix, s.dirLister(conn), func(attrs *ObjectAttrs) error {
+		callbackErr = f(attrs)
+		return callbackErr
+	})
+	if err == nil || err == callbackErr || err == ctx.Err() {
+		// Those errors say nothing about the connection
+		s.pool.put(conn, nil)
+	} else {
+		s.pool.put(conn, err)
+	}
+	return err
+}
+
+// dirLister lists the directories through `conn`, links not being objects.
+func (s *FTPStore) dirLister(conn *ftp.ServerConn) dirLister {
+	return func(dir string) ([]*dirEntry, error) {
+		entries, err := s.list(conn, dir)
+		if err != nil {
+			if isFTPNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		out := make([]*dirEntry, 0, len(entries))
+		for _, entry := range entries {
+			entry, entryPath := entry, dir+"/"+entry.Name
+			switch entry.Type {
+			case ftp.EntryTypeFolder:
+				out = append(out, &dirEntry{path: entryPath, dir: true})
+			case ftp.EntryTypeFile:
+				out = append(out, &dirEntry{path: entryPath, attrs: func(name string) (*ObjectAttrs, error) {
+					return s.newObjectAttrs(conn, name, entryPath, entry)
+				}})
+			}
+		}
+		return out, nil
+	}
+}
+
+func (s *FTPStore) DeleteObject(ctx context.Context, base string) (err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+
+	err = s.withConn(func(conn *ftp.ServerConn) error {
+		if err := conn.Delete(path + localMetadataSuffix); err != nil && !isFTPNotFound(err) {
+			return fmt.Errorf("removing metadata: %w", err)
+		}
+		return conn.Delete(path)
+	})
+	if isFTPNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *FTPStore) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer classifyError(&err)
+	return deleteObjectsConcurrently(ctx, names, ftpMaxIdleConnections, func(ctx context.Context, name string) error {
+		if err := s.DeleteObject(ctx, name); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *FTPStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer classifyError(&err)
+	return deletePrefix(ctx, s, prefix, deletePrefixConcurrency)
+}
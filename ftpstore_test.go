@@ -0,0 +1,428 @@
+//go:build !dstore_no_ftp
+
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeFTP is an FTP server serving a temporary directory, implementing the
+// subset of the protocol used by the store in passive mode.
+type fakeFTP struct {
+	listener net.Listener
+	root     string
+
+	lock sync.Mutex
+	// dropTransfers is how many upcoming downloads are cut, along with their
+	// control connection, after `dropAfter` bytes.
+	dropTransfers int
+	dropAfter     int64
+	logins        int
+	retrieves     int
+}
+
+func newFakeFTP(t *testing.T) *fakeFTP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	f := &fakeFTP{listener: listener, root: t.TempDir()}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serveConn(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeFTP) localPath(ftpPath string) string {
+	return filepath.Join(f.root, filepath.FromSlash(ftpPath))
+}
+
+func (f *fakeFTP) serveConn(netConn net.Conn) {
+	conn := textproto.NewConn(netConn)
+	defer conn.Close()
+
+	var passive net.Listener
+	var restOffset int64
+	var renameFrom string
+	defer func() {
+		if passive != nil {
+			passive.Close()
+		}
+	}()
+
+	reply := func(code int, format string, args ...interface{}) {
+		conn.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+	}
+
+	// transfer accepts the data connection opened after EPSV
+	transfer := func(run func(data net.Conn) error) {
+		if passive == nil {
+			reply(425, "Use EPSV first")
+			return
+		}
+		data, err := passive.Accept()
+		passive.Close()
+		passive = nil
+		if err != nil {
+			reply(425, "Can't open data connection")
+			return
+		}
+
+		reply(150, "Opening data connection")
+		err = run(data)
+		data.Close()
+		if err != nil {
+			reply(426, "Transfer aborted: %s", err)
+			return
+		}
+		reply(226, "Transfer complete")
+	}
+
+	reply(220, "fake ftp ready")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			command, arg = line[:i], line[i+1:]
+		}
+		local := f.localPath(arg)
+
+		switch strings.ToUpper(command) {
+		case "USER":
+			reply(331, "Password required")
+		case "PASS":
+			if arg != "secret" {
+				reply(530, "Login incorrect")
+				continue
+			}
+			f.lock.Lock()
+			f.logins++
+			f.lock.Unlock()
+			reply(230, "Logged in")
+		case "FEAT":
+			conn.PrintfLine("211-Features:")
+			conn.PrintfLine(" MLST type*;size*;modify*;")
+			conn.PrintfLine(" EPSV")
+			conn.PrintfLine("211 End")
+		case "TYPE", "NOOP":
+			reply(200, "OK")
+		case "EPSV":
+			if passive, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				reply(425, "Can't listen")
+				continue
+			}
+			reply(229, "Entering Extended Passive Mode (|||%d|)", passive.Addr().(*net.TCPAddr).Port)
+		case "REST":
+			restOffset, _ = strconv.ParseInt(arg, 10, 64)
+			reply(350, "Restarting at %d", restOffset)
+		case "SIZE":
+			info, err := os.Stat(local)
+			if err != nil || info.IsDir() {
+				reply(550, "No such file")
+				continue
+			}
+			reply(213, "%d", info.Size())
+		case "RETR":
+			file, err := os.Open(local)
+			if err != nil {
+				reply(550, "No such file")
+				continue
+			}
+			offset := restOffset
+			restOffset = 0
+
+			f.lock.Lock()
+			f.retrieves++
+			drop := f.dropTransfers > 0
+			if drop {
+				f.dropTransfers--
+			}
+			f.lock.Unlock()
+
+			if drop {
+				data, err := passive.Accept()
+				if err == nil {
+					reply(150, "Opening data connection")
+					file.Seek(offset, io.SeekStart)
+					io.CopyN(data, file, f.dropAfter)
+					data.Close()
+				}
+				file.Close()
+				return
+			}
+
+			transfer(func(data net.Conn) error {
+				defer file.Close()
+				if _, err := file.Seek(offset, io.SeekStart); err != nil {
+					return err
+				}
+				_, err := io.Copy(data, file)
+				return err
+			})
+		case "STOR":
+			file, err := os.Create(local)
+			if err != nil {
+				reply(553, "Cannot create file")
+				continue
+			}
+			transfer(func(data net.Conn) error {
+				defer file.Close()
+				_, err := io.Copy(file, data)
+				return err
+			})
+		case "MLSD":
+			entries, err := ioutil.ReadDir(local)
+			if err != nil {
+				reply(550, "No such directory")
+				continue
+			}
+			sort.Slice(entries, func(i, j int) bool { return entries[i].Name() > entries[j].Name() })
+			transfer(func(data net.Conn) error {
+				for _, entry := range entries {
+					kind := "file"
+					if entry.IsDir() {
+						kind = "dir"
+					}
+					fmt.Fprintf(data, "type=%s;size=%d;modify=%s; %s\r\n", kind, entry.Size(), entry.ModTime().UTC().Format("20060102150405"), entry.Name())
+				}
+				return nil
+			})
+		case "DELE":
+			if info, err := os.Stat(local); err != nil || info.IsDir() {
+				reply(550, "No such file")
+				continue
+			}
+			os.Remove(local)
+			reply(250, "Deleted")
+		case "MKD":
+			if err := os.Mkdir(local, 0755); err != nil {
+				reply(550, "Cannot create directory")
+				continue
+			}
+			reply(257, "%q created", arg)
+		case "RNFR":
+			if _, err := os.Stat(local); err != nil {
+				reply(550, "No such file")
+				continue
+			}
+			renameFrom = local
+			reply(350, "Ready for RNTO")
+		case "RNTO":
+			if _, err := os.Stat(local); err == nil {
+				// Like the many servers refusing to replace files
+				reply(553, "File exists")
+				continue
+			}
+			if err := os.Rename(renameFrom, local); err != nil {
+				reply(553, "Cannot rename")
+				continue
+			}
+			reply(250, "Renamed")
+		case "QUIT":
+			reply(221, "Bye")
+			return
+		default:
+			reply(502, "Command not implemented")
+		}
+	}
+}
+
+func newTestFTPStore(t *testing.T, fake *fakeFTP, opts ...Option) *FTPStore {
+	base, err := url.Parse(fmt.Sprintf("ftp://user:secret@%s/archives", fake.listener.Addr()))
+	require.NoError(t, err)
+
+	store, err := NewFTPStoreWithOptions(base, opts...)
+	require.NoError(t, err)
+	return store
+}
+
+func TestNewFTPStore(t *testing.T) {
+	fake := newFakeFTP(t)
+
+	base, _ := url.Parse(fmt.Sprintf("ftp://user:wrong@%s/archives", fake.listener.Addr()))
+	_, err := NewFTPStoreWithOptions(base)
+	require.Error(t, err, "bad credentials")
+
+	base, _ = url.Parse("sftp://host/path")
+	_, err = newFTPDialFunc(base)
+	require.Error(t, err, "bad scheme")
+
+	base, _ = url.Parse("ftps:///path")
+	_, err = newFTPDialFunc(base)
+	require.Error(t, err, "no host")
+
+	os.Setenv("FTP_USERNAME", "user")
+	os.Setenv("FTP_PASSWORD", "secret")
+	defer os.Unsetenv("FTP_USERNAME")
+	defer os.Unsetenv("FTP_PASSWORD")
+
+	base, _ = url.Parse(fmt.Sprintf("ftp://%s/archives", fake.listener.Addr()))
+	_, err = NewFTPStoreWithOptions(base)
+	require.NoError(t, err)
+	assert.DirExists(t, filepath.Join(fake.root, "archives"))
+}
+
+func TestFTPStore_ErrorClasses(t *testing.T) {
+	assertErrorClass(t, &textproto.Error{Code: 421}, ErrTransient)
+	assertErrorClass(t, &textproto.Error{Code: 530}, ErrPermissionDenied)
+	assertErrorClass(t, &textproto.Error{Code: 550}, nil)
+}
+
+func TestFTPStore_WriteObject(t *testing.T) {
+	fake := newFakeFTP(t)
+	store := newTestFTPStore(t, fake)
+	ctx := context.Background()
+
+	require.NoError(t, store.WriteObject(ctx, "dir/0001", bytes.NewReader([]byte("content")), WithMetadata(map[string]string{"Block_Range": "1-2"})))
+	content, err := ReadObject(ctx, store, "dir/0001")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	reader, err := store.OpenObjectRange(ctx, "dir/0001", 2, 3)
+	require.NoError(t, err)
+	content, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, "nte", string(content))
+
+	reader, err = store.OpenObjectRange(ctx, "dir/0001", 10, -1)
+	require.NoError(t, err)
+	content, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, "", string(content))
+
+	attrs, err := store.ObjectAttributes(ctx, "dir/0001")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), attrs.Size)
+	assert.False(t, attrs.LastModified.IsZero())
+	assert.Equal(t, map[string]string{"block_range": "1-2"}, attrs.Metadata)
+
+	// Without overwrite, the existing object is kept
+	require.NoError(t, store.WriteObject(ctx, "dir/0001", bytes.NewReader([]byte("other"))))
+	content, err = ReadObject(ctx, store, "dir/0001")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	_, err = store.OpenObject(ctx, "dir/0002")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = store.ObjectAttributes(ctx, "dir")
+	assert.Equal(t, ErrNotFound, err, "directories are not objects")
+
+	assert.Equal(t, 1, fake.logins, "connections should be reused")
+}
+
+func TestFTPStore_WriteObject_Overwrite(t *testing.T) {
+	fake := newFakeFTP(t)
+	store := newTestFTPStore(t, fake, AllowOverwrite())
+	ctx := context.Background()
+
+	require.NoError(t, store.WriteObject(ctx, "0001", bytes.NewReader([]byte("content")), WithMetadata(map[string]string{"key": "value"})))
+	require.NoError(t, store.WriteObject(ctx, "0001", bytes.NewReader([]byte("overwritten"))))
+	content, err := ReadObject(ctx, store, "0001")
+	require.NoError(t, err)
+	assert.Equal(t, "overwritten", string(content))
+
+	attrs, err := store.ObjectAttributes(ctx, "0001")
+	require.NoError(t, err)
+	assert.Nil(t, attrs.Metadata)
+}
+
+func TestFTPStore_OpenObject_ResumesDownload(t *testing.T) {
+	fake := newFakeFTP(t)
+	store := newTestFTPStore(t, fake)
+	ctx := context.Background()
+
+	require.NoError(t, store.WriteObject(ctx, "0001", bytes.NewReader([]byte("0123456789"))))
+
+	fake.dropTransfers, fake.dropAfter = 2, 3
+	content, err := ReadObject(ctx, store, "0001")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(content))
+	assert.Equal(t, 3, fake.retrieves)
+
+	fake.dropTransfers, fake.dropAfter = 1, 2
+	reader, err := store.OpenObjectRange(ctx, "0001", 2, 4)
+	require.NoError(t, err)
+	content, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, "2345", string(content))
+
+	fake.dropTransfers, fake.dropAfter = ftpMaxResumeAttempts+1, 0
+	reader, err = store.OpenObject(ctx, "0001")
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(reader)
+	assert.Error(t, err, "downloads not progressing should stop resuming")
+	reader.Close()
+}
+
+func TestFTPStore_Walk(t *testing.T) {
+	fake := newFakeFTP(t)
+	store := newTestFTPStore(t, fake)
+	ctx := context.Background()
+
+	for _, name := range []string{"0000/0002", "0000/0001", "0001/0001", "0001.txt", "01"} {
+		require.NoError(t, store.WriteObject(ctx, name, bytes.NewReader([]byte(name)), WithMetadata(map[string]string{"name": name})))
+	}
+
+	var files []string
+	require.NoError(t, store.Walk(ctx, "", func(filename string) error {
+		files = append(files, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{"0000/0001", "0000/0002", "0001.txt", "0001/0001", "01"}, files)
+
+	files = nil
+	require.NoError(t, store.WalkFrom(ctx, "0", "0000/0002", func(filename string) error {
+		files = append(files, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{"0000/0002", "0001.txt", "0001/0001", "01"}, files)
+
+	dirs, err := store.ListDirectories(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0000", "0001"}, dirs)
+
+	require.NoError(t, store.RenameObject(ctx, "0001.txt", "0002/0001"))
+	require.NoError(t, store.CopyObject(ctx, "01", "0002/0000"))
+	attrs, err := store.ObjectAttributes(ctx, "0002/0001")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "0001.txt"}, attrs.Metadata)
+	content, err := ReadObject(ctx, store, "0002/0000")
+	require.NoError(t, err)
+	assert.Equal(t, "01", string(content))
+
+	deleted, err := store.DeletePrefix(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, 6, deleted)
+	assert.Equal(t, ErrNotFound, store.DeleteObject(ctx, "01"))
+}
@@ -1,54 +1,244 @@
 package dstore
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"go.uber.org/zap"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
 //
 // Google Storage Store
 
 type GSStore struct {
-	baseURL *url.URL
-	client  *storage.Client
+	baseURL         *url.URL
+	client          *storage.Client
+	shared          *gsSharedClient
+	closeOnce       sync.Once
+	credentialsFile string
+
+	// compositePartSize enables parallel composite uploads in parts of that
+	// size, with up to compositeConcurrency parts uploaded concurrently.
+	compositePartSize    int64
+	compositeConcurrency int
 	*commonStore
 }
 
 func NewGSStore(baseURL *url.URL, extension, compressionType string, overwrite bool) (*GSStore, error) {
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return nil, err
+	return NewGSStoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+func NewGSStoreWithOptions(baseURL *url.URL, opts ...Option) (*GSStore, error) {
+	config := newCloudConfig(opts)
+	common := newCommonStore(baseURL, config)
+
+	var shared *gsSharedClient
+	if config.gsClient != nil {
+		shared = newGSSharedClient(config, common.httpClient, config.gsClient)
+	} else {
+		var err error
+		shared, err = sharedGSClient(config, common.httpClient)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &GSStore{
-		baseURL: baseURL,
-		client:  client,
-		commonStore: &commonStore{
-			compressionType: compressionType,
-			extension:       extension,
-			overwrite:       overwrite,
-		},
+		baseURL:         baseURL,
+		client:          shared.client,
+		shared:          shared,
+		credentialsFile: config.credentialsFile,
+
+		compositePartSize:    config.compositePartSize,
+		compositeConcurrency: config.compositeConcurrency,
+		commonStore:          common,
 	}, nil
 }
+
+// gsClientKey identifies the clients shared by the stores created with the
+// same credentials and HTTP options.
+type gsClientKey struct {
+	credentialsFile string
+	httpClient      *http.Client
+	httpVersion     int
+	idleConnTimeout time.Duration
+}
+
+var gsClientsLock sync.Mutex
+var gsClients = map[gsClientKey]*gsSharedClient{}
+
+// gsSharedClient is the storage client of a store, shared with its sub stores
+// and the stores created with the same options, along with the authenticated
+// HTTP client of its resumable writes, which the storage client does not
+// expose.
+type gsSharedClient struct {
+	client *storage.Client
+
+	// pooled clients are in `gsClients` under `key`, until the last of the
+	// `refs` stores using them is closed. Guarded by `gsClientsLock`.
+	pooled bool
+	key    gsClientKey
+	refs   int
+
+	base          *http.Client
+	clientOptions []option.ClientOption
+
+	lock          sync.Mutex
+	authenticated *http.Client
+}
+
+// newGSSharedClient returns the shared client of `client`, authenticating its
+// HTTP client with the credentials of `config` on top of `httpClient`, the
+// default client when nil.
+func newGSSharedClient(config *config, httpClient *http.Client, client *storage.Client) *gsSharedClient {
+	shared := &gsSharedClient{client: client, base: httpClient}
+	if shared.base == nil {
+		shared.base = http.DefaultClient
+	}
+	if config.credentialsFile != "" {
+		shared.clientOptions = append(shared.clientOptions, option.WithCredentialsFile(config.credentialsFile))
+	}
+	return shared
+}
+
+// httpClient returns the authenticated HTTP client, created on first use.
+func (c *gsSharedClient) httpClient() (*http.Client, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.authenticated == nil {
+		authenticated, err := newGSHTTPClient(context.Background(), c.base, c.clientOptions)
+		if err != nil {
+			return nil, err
+		}
+		c.authenticated = authenticated
+	}
+	return c.authenticated, nil
+}
+
+// sharedGSClient returns the client of the stores created with the same
+// credentials and HTTP options, creating it with `httpClient`, the client
+// tuned from those options, on first use. The stores share its connection pool
+// and authentication tokens, each holding a reference released by `Close`.
+func sharedGSClient(config *config, httpClient *http.Client) (*gsSharedClient, error) {
+	gsClientsLock.Lock()
+	defer gsClientsLock.Unlock()
+
+	key := gsClientKey{
+		credentialsFile: config.credentialsFile,
+		httpClient:      config.httpClient,
+		httpVersion:     config.httpVersion,
+		idleConnTimeout: config.idleConnTimeout,
+	}
+	if shared, found := gsClients[key]; found {
+		shared.refs++
+		return shared, nil
+	}
+
+	shared := newGSSharedClient(config, httpClient, nil)
+	clientOptions := shared.clientOptions
+	if httpClient != nil {
+		authenticated, err := shared.httpClient()
+		if err != nil {
+			return nil, err
+		}
+		clientOptions = append(clientOptions, option.WithHTTPClient(authenticated))
+	}
+
+	client, err := storage.NewClient(context.Background(), clientOptions...)
+	if err != nil {
+		return nil, err
+	}
+	shared.client = client
+	shared.pooled, shared.key, shared.refs = true, key, 1
+	gsClients[key] = shared
+	return shared, nil
+}
+
+// acquire counts one more store using the client.
+func (c *gsSharedClient) acquire() *gsSharedClient {
+	gsClientsLock.Lock()
+	defer gsClientsLock.Unlock()
+	c.refs++
+	return c
+}
+
+// release counts one less store using the client, closing a pooled client
+// once no store uses it anymore.
+func (c *gsSharedClient) release() error {
+	gsClientsLock.Lock()
+	defer gsClientsLock.Unlock()
+
+	c.refs--
+	if !c.pooled || c.refs > 0 {
+		return nil
+	}
+	delete(gsClients, c.key)
+	return c.client.Close()
+}
+
+// newGSHTTPClient returns a copy of `client` whose transport authenticates the
+// requests, as the storage client uses the clients it is given as-is.
+func newGSHTTPClient(ctx context.Context, client *http.Client, clientOptions []option.ClientOption) (*http.Client, error) {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	transport, err := htransport.NewTransport(ctx, base, append(clientOptions, option.WithScopes(storage.ScopeFullControl, "https://www.googleapis.com/auth/cloud-platform"))...)
+	if err != nil {
+		return nil, fmt.Errorf("gs store authenticating http client: %w", err)
+	}
+
+	authenticated := *client
+	authenticated.Transport = transport
+	return &authenticated, nil
+}
+
 func (s *GSStore) SubStore(subFolder string) (Store, error) {
 	url, err := url.Parse(s.baseURL.String())
 	if err != nil {
 		return nil, fmt.Errorf("gs store parsing base url: %w", err)
 	}
 	url.Path = path.Join(url.Path, subFolder)
-	return NewGSStore(url, s.extension, s.compressionType, s.overwrite)
+	opts := append(s.options(), CredentialsFile(s.credentialsFile), GSClient(s.client))
+	if s.compositePartSize > 0 {
+		opts = append(opts, ParallelCompositeUpload(s.compositePartSize, s.compositeConcurrency))
+	}
+	sub, err := NewGSStoreWithOptions(url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	sub.shared = s.shared.acquire()
+	return sub, nil
+}
+
+// Close releases the client of the store, shared with its sub stores and the
+// stores created with the same options, and closed once all of them are
+// closed. A client injected with `GSClient` is left open. The store must not
+// be used afterwards.
+func (s *GSStore) Close() (err error) {
+	s.closeOnce.Do(func() {
+		err = s.shared.release()
+	})
+	return err
 }
 
 func (s *GSStore) BaseURL() *url.URL {
@@ -63,26 +253,75 @@ func (s *GSStore) ObjectURL(name string) string {
 	return fmt.Sprintf("%s/%s", strings.TrimRight(s.baseURL.String(), "/"), strings.TrimLeft(s.pathWithExt(name), "/"))
 }
 
+func (s *GSStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return s.presign(http.MethodGet, base, ttl)
+}
+
+func (s *GSStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return s.presign(http.MethodPut, base, ttl)
+}
+
+// presign signs the URL using the client's service account credentials,
+// falling back to the IAM `signBlob` API when no private key is available.
+func (s *GSStore) presign(method string, base string, ttl time.Duration) (string, error) {
+	return s.client.Bucket(s.baseURL.Host).SignedURL(s.ObjectPath(base), &storage.SignedURLOptions{
+		Method:  method,
+		Expires: time.Now().Add(ttl),
+		Scheme:  storage.SigningSchemeV4,
+	})
+}
+
 func (s *GSStore) toBaseName(filename string) string {
 	return strings.TrimPrefix(strings.TrimSuffix(filename, s.pathWithExt("")), strings.TrimLeft(s.baseURL.Path, "/")+"/")
 }
 
-func (s *GSStore) WriteObject(ctx context.Context, base string, f io.Reader) (err error) {
-	path := s.ObjectPath(base)
+func (s *GSStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	defer classifyError(&err)
+	config := newWriteConfig(opts)
+	f = config.trackProgress(f)
+	if s.compositePartSize > 0 && !s.uploadChecksums {
+		return s.compositeUpload(ctx, base, f, config)
+	}
 
-	object := s.client.Bucket(s.baseURL.Host).Object(path)
+	w := s.objectWriter(ctx, base, config)
+	if s.uploadChecksums {
+		upload, err := s.spoolChecksummed(ctx, f)
+		if err != nil {
+			return err
+		}
+		defer upload.remove()
+
+		w.SendCRC32C = true
+		w.CRC32C = upload.crc32c
+		w.MD5 = upload.md5
+		if _, err := s.pooledCopy(w, upload.file); err != nil {
+			return err
+		}
+	} else if err := s.compressedCopy(ctx, f, w); err != nil {
+		return err
+	}
+	return s.closeObjectWriter(base, w, config)
+}
 
+// objectWriter returns a writer of the object `base` with the headers of the
+// store and of the write `config`.
+func (s *GSStore) objectWriter(ctx context.Context, base string, config *writeConfig) *storage.Writer {
+	object := s.client.Bucket(s.baseURL.Host).Object(s.ObjectPath(base))
 	if !s.overwrite {
 		object = object.If(storage.Conditions{DoesNotExist: true})
 	}
-	w := object.NewWriter(ctx)
-	w.ContentType = "application/octet-stream"
-	w.CacheControl = "public, max-age=86400"
 
-	if err := s.compressedCopy(f, w); err != nil {
-		return err
-	}
+	w := object.NewWriter(ctx)
+	w.ContentType, w.CacheControl = s.contentHeaders(config, defaultContentType, defaultCacheControl)
+	w.ContentEncoding = s.contentEncoding()
+	w.Metadata = config.metadata
+	w.PredefinedACL = gsPredefinedACL(config.acl)
+	return w
+}
 
+func (s *GSStore) closeObjectWriter(base string, w *storage.Writer, config *writeConfig) error {
 	if err := w.Close(); err != nil {
 		if s.overwrite {
 			return err
@@ -90,9 +329,180 @@ func (s *GSStore) WriteObject(ctx context.Context, base string, f io.Reader) (er
 		return silencePreconditionError(err)
 	}
 
+	config.capture(newGSObjectAttrs(base, w.Attrs()))
+	return nil
+}
+
+// gsDefaultCompositeConcurrency is the number of parts uploaded concurrently
+// by parallel composite uploads when no concurrency is configured.
+const gsDefaultCompositeConcurrency = 4
+
+// compositeUpload splits the compressed content in parts uploaded
+// concurrently as temporary objects, composed into the object then deleted.
+// Content fitting in a single part is written directly.
+func (s *GSStore) compositeUpload(ctx context.Context, base string, f io.Reader, config *writeConfig) error {
+	pipeRead, pipeWrite := io.Pipe()
+	writeDone := make(chan error, 1)
+	go func() {
+		err := s.compressedCopy(ctx, f, pipeWrite)
+		pipeWrite.CloseWithError(err)
+		writeDone <- err
+	}()
+
+	err := s.uploadComposite(ctx, base, pipeRead, config)
+	pipeRead.Close()
+	if copyErr := <-writeDone; copyErr != nil && copyErr != io.ErrClosedPipe {
+		return copyErr
+	}
+	return err
+}
+
+func (s *GSStore) uploadComposite(ctx context.Context, base string, content io.Reader, config *writeConfig) error {
+	concurrency := s.compositeConcurrency
+	if concurrency <= 0 {
+		concurrency = gsDefaultCompositeConcurrency
+	}
+
+	buffers := make(chan []byte, concurrency)
+	for i := 0; i < concurrency; i++ {
+		buffers <- make([]byte, s.compositePartSize)
+	}
+
+	first := <-buffers
+	n, err := io.ReadFull(content, first)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if int64(n) < s.compositePartSize {
+		w := s.objectWriter(ctx, base, config)
+		if _, err := w.Write(first[:n]); err != nil {
+			w.CloseWithError(err)
+			return err
+		}
+		return s.closeObjectWriter(base, w, config)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	bucket := s.client.Bucket(s.baseURL.Host)
+	path := s.ObjectPath(base)
+	prefix := fmt.Sprintf("%s.part-%d", path, time.Now().UnixNano())
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	var parts []string
+	defer func() {
+		deleteErr := deleteObjectsConcurrently(context.Background(), parts, deleteObjectsConcurrency, func(ctx context.Context, name string) error {
+			if err := bucket.Object(name).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+				return err
+			}
+			return nil
+		})
+		if deleteErr != nil {
+			s.logger.Warn("unable to delete composite upload parts", zap.String("path", path), zap.Error(deleteErr))
+		}
+	}()
+
+	part := first[:n]
+	for {
+		partNum := len(parts)
+		partPath := fmt.Sprintf("%s-%d", prefix, partNum)
+		parts = append(parts, partPath)
+
+		wg.Add(1)
+		go func(part []byte) {
+			defer func() {
+				buffers <- part[:cap(part)]
+				wg.Done()
+			}()
+
+			w := bucket.Object(partPath).NewWriter(ctx)
+			_, err := w.Write(part)
+			if err == nil {
+				err = w.Close()
+			} else {
+				w.CloseWithError(err)
+			}
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("uploading part %d: %w", partNum, err)
+					cancel()
+				})
+			}
+		}(part)
+
+		if int64(len(part)) < s.compositePartSize {
+			break
+		}
+
+		var buffer []byte
+		select {
+		case buffer = <-buffers:
+		case <-ctx.Done():
+		}
+		if buffer == nil {
+			break
+		}
+
+		n, err := io.ReadFull(content, buffer)
+		if err == io.EOF {
+			buffers <- buffer
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			buffers <- buffer
+			errOnce.Do(func() {
+				firstErr = err
+				cancel()
+			})
+			break
+		}
+		part = buffer[:n]
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	handles := make([]*storage.ObjectHandle, len(parts))
+	for i, partPath := range parts {
+		handles[i] = bucket.Object(partPath)
+	}
+	attrs, err := s.composeHandles(ctx, path, handles, config)
+	if err != nil {
+		return err
+	}
+	if attrs != nil {
+		config.capture(newGSObjectAttrs(base, attrs))
+	}
 	return nil
 }
 
+var gsPredefinedACLs = map[string]string{
+	ACLPrivate:                "private",
+	ACLPublicRead:             "publicRead",
+	ACLPublicReadWrite:        "publicReadWrite",
+	ACLAuthenticatedRead:      "authenticatedRead",
+	ACLBucketOwnerRead:        "bucketOwnerRead",
+	ACLBucketOwnerFullControl: "bucketOwnerFullControl",
+}
+
+// gsPredefinedACL translates our canned ACL names to the Google Storage ones,
+// names without equivalent are passed through as-is so that Google Storage
+// specific ones like `projectPrivate` can be used.
+func gsPredefinedACL(acl string) string {
+	if predefined, found := gsPredefinedACLs[acl]; found {
+		return predefined
+	}
+	return acl
+}
+
 func silencePreconditionError(err error) error {
 	if e, ok := err.(*googleapi.Error); ok {
 		if e.Code == http.StatusPreconditionFailed {
@@ -102,13 +512,390 @@ func silencePreconditionError(err error) error {
 	return err
 }
 
+// gsResumableChunkSize is the size of the chunks sent by resumable writes, a
+// multiple of the 256KiB granularity of resumable uploads.
+const gsResumableChunkSize = 16 * 1024 * 1024
+
+// gsResumableEndpoint is the endpoint of the resumable uploads, sent without
+// the storage client which does not expose the session URI of its uploads.
+const gsResumableEndpoint = "https://storage.googleapis.com"
+
+// resumableClient returns the authenticated HTTP client sending the requests
+// of resumable writes, shared with the other stores of the storage client,
+// along with their endpoint. Like the storage client, it talks without
+// authentication to the `STORAGE_EMULATOR_HOST` emulator.
+func (s *GSStore) resumableClient() (*http.Client, string, error) {
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		return s.shared.base, strings.TrimRight(host, "/"), nil
+	}
+
+	client, err := s.shared.httpClient()
+	if err != nil {
+		return nil, "", err
+	}
+	return client, gsResumableEndpoint, nil
+}
+
+func (s *GSStore) startResumableWrite(ctx context.Context, name string, size int64, config *writeConfig) (checkpoint *UploadCheckpoint, err error) {
+	defer classifyError(&err)
+
+	client, endpoint, err := s.resumableClient()
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"uploadType": {"resumable"}, "name": {s.ObjectPath(name)}}
+	if !s.overwrite {
+		query.Set("ifGenerationMatch", "0")
+	}
+	if config.acl != "" {
+		query.Set("predefinedAcl", gsPredefinedACL(config.acl))
+	}
+
+	var object struct {
+		Name            string            `json:"name"`
+		ContentType     string            `json:"contentType,omitempty"`
+		CacheControl    string            `json:"cacheControl,omitempty"`
+		ContentEncoding string            `json:"contentEncoding,omitempty"`
+		Metadata        map[string]string `json:"metadata,omitempty"`
+	}
+	object.Name = s.ObjectPath(name)
+	object.ContentType, object.CacheControl = s.contentHeaders(config, defaultContentType, defaultCacheControl)
+	object.ContentEncoding = s.contentEncoding()
+	object.Metadata = config.metadata
+	body, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadURL := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", endpoint, url.PathEscape(s.baseURL.Host), query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return nil, fmt.Errorf("resumable upload created without session URI")
+	}
+	return &UploadCheckpoint{Name: name, Session: session, PartSize: gsResumableChunkSize}, nil
+}
+
+func (s *GSStore) resumeWrite(ctx context.Context, checkpoint *UploadCheckpoint, content io.ReadSeeker, size int64, save func(checkpoint *UploadCheckpoint) error) (err error) {
+	defer classifyError(&err)
+	client, _, err := s.resumableClient()
+	if err != nil {
+		return err
+	}
+
+	// An empty chunk asks for the bytes already stored, completing empty
+	// uploads
+	offset, done, err := sendResumableChunk(ctx, client, checkpoint.Session, nil, 0, size)
+	if err != nil {
+		return fmt.Errorf("resumable upload status: %w", err)
+	}
+
+	bufferSize := checkpoint.PartSize
+	if size < bufferSize {
+		bufferSize = size
+	}
+	buffer := make([]byte, bufferSize)
+	for !done {
+		length := checkpoint.PartSize
+		if size-offset < length {
+			length = size - offset
+		}
+		chunk, err := readChunk(content, buffer, offset, length)
+		if err != nil {
+			return err
+		}
+
+		// The session may keep only part of the chunk, the rest being sent
+		// again with the next one
+		stored, complete, err := sendResumableChunk(ctx, client, checkpoint.Session, chunk, offset, size)
+		if err != nil {
+			return fmt.Errorf("uploading chunk at offset %d: %w", offset, err)
+		}
+		offset, done = stored, complete
+
+		checkpoint.Offset = offset
+		if !done {
+			if err := save(checkpoint); err != nil {
+				return fmt.Errorf("saving checkpoint: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// sendResumableChunk sends `chunk` at `offset` to the resumable upload
+// `session` of `size` bytes, returning the number of bytes stored so far and
+// whether the upload is complete. An empty chunk only queries the upload.
+func sendResumableChunk(ctx context.Context, client *http.Client, session string, chunk []byte, offset, size int64) (stored int64, done bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, session, bytes.NewReader(chunk))
+	if err != nil {
+		return 0, false, err
+	}
+	if len(chunk) == 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	} else {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(len(chunk))-1, size))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPermanentRedirect {
+		// `Range: bytes=0-N` holds the bytes stored, absent when none are
+		var last int64 = -1
+		if stored := resp.Header.Get("Range"); stored != "" {
+			if _, err := fmt.Sscanf(stored, "bytes=0-%d", &last); err != nil {
+				return 0, false, fmt.Errorf("invalid range %q: %w", stored, err)
+			}
+		}
+		return last + 1, false, nil
+	}
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		// Only sent to create the object, which already exists
+		if silencePreconditionError(err) == nil {
+			return size, true, nil
+		}
+		return 0, false, err
+	}
+	return size, true, nil
+}
+
+func (s *GSStore) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer classifyError(&err)
+	return s.copyPath(ctx, s.baseURL.Host, s.ObjectPath(src), s.ObjectPath(dst))
+}
+
+// copyPath rewrites the source object into this store's bucket at `dstPath`.
+// The copier takes care of issuing as many rewrite calls as needed for very
+// large objects.
+func (s *GSStore) copyPath(ctx context.Context, srcBucket, srcPath, dstPath string) error {
+	dstObject := s.client.Bucket(s.baseURL.Host).Object(dstPath)
+	if !s.overwrite {
+		dstObject = dstObject.If(storage.Conditions{DoesNotExist: true})
+	}
+
+	_, err := dstObject.CopierFrom(s.client.Bucket(srcBucket).Object(srcPath)).Run(ctx)
+	if err != nil {
+		if err == storage.ErrObjectNotExist || isGSNotFound(err) {
+			return ErrNotFound
+		}
+		if s.overwrite {
+			return err
+		}
+		return silencePreconditionError(err)
+	}
+
+	return nil
+}
+
+func isGSNotFound(err error) bool {
+	if e, ok := err.(*googleapi.Error); ok {
+		return e.Code == http.StatusNotFound
+	}
+	return false
+}
+
+func (s *GSStore) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer classifyError(&err)
+	return renameObject(ctx, s, oldName, newName)
+}
+
+// AppendObject appends the content read from `f` to the object, creating it
+// when missing. The content is written to a temporary part object that is
+// composed with the object then deleted, the compose being conditioned on the
+// object's generation so that concurrent appends fail instead of being lost.
+func (s *GSStore) AppendObject(ctx context.Context, base string, f io.Reader) (err error) {
+	defer classifyError(&err)
+	if !s.appendable() {
+		return fmt.Errorf("append to %s object: %w", s.compressionType, ErrNotSupported)
+	}
+
+	path := s.ObjectPath(base)
+	bucket := s.client.Bucket(s.baseURL.Host)
+	object := bucket.Object(path)
+
+	attrs, err := object.Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return s.writeAppended(ctx, object.If(storage.Conditions{DoesNotExist: true}), f)
+	}
+	if err != nil {
+		return err
+	}
+
+	part := bucket.Object(fmt.Sprintf("%s.append-%d", path, time.Now().UnixNano()))
+	if err := s.writeAppended(ctx, part, f); err != nil {
+		return fmt.Errorf("write appended part: %w", err)
+	}
+	defer func() {
+		if err := part.Delete(context.Background()); err != nil {
+			s.logger.Warn("unable to delete appended part", zap.String("path", part.ObjectName()), zap.Error(err))
+		}
+	}()
+
+	composer := object.If(storage.Conditions{GenerationMatch: attrs.Generation}).ComposerFrom(object.Generation(attrs.Generation), part)
+	composer.ContentType = attrs.ContentType
+	composer.ContentEncoding = attrs.ContentEncoding
+	composer.CacheControl = attrs.CacheControl
+	composer.Metadata = attrs.Metadata
+	if _, err := composer.Run(ctx); err != nil {
+		return fmt.Errorf("compose appended part: %w", err)
+	}
+	return nil
+}
+
+// gsMaxComposeSources is the maximum number of objects composed by a single
+// Google Storage compose request.
+const gsMaxComposeSources = 32
+
+// ComposeObjects composes the `sources` objects into `dst` server-side, more
+// than 32 sources being composed into temporary objects first. Objects that
+// cannot be concatenated as stored, like lz4 or seekable zstd ones, are
+// streamed through this process instead.
+func (s *GSStore) ComposeObjects(ctx context.Context, dst string, sources []string) (err error) {
+	defer classifyError(&err)
+	return s.composeObjects(ctx, dst, sources)
+}
+
+func (s *GSStore) composeObjects(ctx context.Context, dst string, sources []string, opts ...WriteOption) error {
+	if len(sources) == 0 {
+		return errNoSources
+	}
+	if !s.concatenatesStored() {
+		return composeStreamed(ctx, s, dst, sources, opts...)
+	}
+
+	bucket := s.client.Bucket(s.baseURL.Host)
+	handles := make([]*storage.ObjectHandle, len(sources))
+	for i, source := range sources {
+		handles[i] = bucket.Object(s.ObjectPath(source))
+	}
+
+	_, err := s.composeHandles(ctx, s.ObjectPath(dst), handles, newWriteConfig(opts))
+	return err
+}
+
+// composeHandles composes the `handles` objects into the object at `dstPath`,
+// returning its attributes, nil when it already existed and overwrite is
+// disabled.
+func (s *GSStore) composeHandles(ctx context.Context, dstPath string, handles []*storage.ObjectHandle, config *writeConfig) (*storage.ObjectAttrs, error) {
+	bucket := s.client.Bucket(s.baseURL.Host)
+
+	var temporary []*storage.ObjectHandle
+	defer func() {
+		for _, object := range temporary {
+			if err := object.Delete(context.Background()); err != nil {
+				s.logger.Warn("unable to delete temporary composed object", zap.String("path", object.ObjectName()), zap.Error(err))
+			}
+		}
+	}()
+
+	for len(handles) > gsMaxComposeSources {
+		var next []*storage.ObjectHandle
+		for start := 0; start < len(handles); start += gsMaxComposeSources {
+			end := start + gsMaxComposeSources
+			if end > len(handles) {
+				end = len(handles)
+			}
+			if end-start == 1 {
+				next = append(next, handles[start])
+				continue
+			}
+
+			object := bucket.Object(fmt.Sprintf("%s.compose-%d-%d", dstPath, time.Now().UnixNano(), len(temporary)))
+			if _, err := object.ComposerFrom(handles[start:end]...).Run(ctx); err != nil {
+				return nil, gsComposeError(err)
+			}
+			temporary = append(temporary, object)
+			next = append(next, object)
+		}
+		handles = next
+	}
+
+	object := bucket.Object(dstPath)
+	if !s.overwrite {
+		object = object.If(storage.Conditions{DoesNotExist: true})
+	}
+	composer := object.ComposerFrom(handles...)
+	composer.ContentType, composer.CacheControl = s.contentHeaders(config, defaultContentType, defaultCacheControl)
+	composer.ContentEncoding = s.contentEncoding()
+	composer.Metadata = config.metadata
+	composer.PredefinedACL = gsPredefinedACL(config.acl)
+	attrs, err := composer.Run(ctx)
+	if err != nil {
+		if s.overwrite {
+			return nil, gsComposeError(err)
+		}
+		return nil, silencePreconditionError(gsComposeError(err))
+	}
+	return attrs, nil
+}
+
+func gsComposeError(err error) error {
+	if err == storage.ErrObjectNotExist || isGSNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// writeAppended writes the content appended to an object, compressed as a
+// stream of its own.
+func (s *GSStore) writeAppended(ctx context.Context, object *storage.ObjectHandle, f io.Reader) error {
+	w := object.NewWriter(ctx)
+	w.ContentType, w.CacheControl = s.contentHeaders(newWriteConfig(nil), defaultContentType, defaultCacheControl)
+	w.ContentEncoding = s.contentEncoding()
+
+	if err := s.compressedCopy(ctx, f, w); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
 func (s *GSStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
 	path := s.ObjectPath(name)
 
-	if tracer.Enabled() {
-		zlog.Debug("opening dstore file", zap.String("path", s.pathWithExt(name)))
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file", zap.String("path", s.pathWithExt(name)))
+	}
+	object := s.object(path)
+	var attrs *storage.ObjectAttrs
+	if s.verifyChecksums {
+		// The generation is pinned so that the checksum is the one of the
+		// object read
+		attrs, err = object.Attrs(ctx)
+		if err != nil {
+			if err == storage.ErrObjectNotExist {
+				return nil, ErrNotFound
+			}
+			return nil, err
+		}
+		object = object.Generation(attrs.Generation)
 	}
-	reader, err := s.client.Bucket(s.baseURL.Host).Object(path).NewReader(ctx)
+
+	reader, err := object.NewReader(ctx)
 	if err != nil {
 		if err == storage.ErrObjectNotExist {
 			return nil, ErrNotFound
@@ -117,24 +904,96 @@ func (s *GSStore) OpenObject(ctx context.Context, name string) (out io.ReadClose
 		return nil, err
 	}
 
-	out, err = s.uncompressedReader(reader)
-	if tracer.Enabled() {
+	// Resumed reads require the generation read so far, failing instead of
+	// splicing the content of a replacing object
+	pinned := object.If(storage.Conditions{GenerationMatch: reader.Attrs.Generation})
+	raw := s.resumeReads(ctx, name, reader, func(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+		return s.openObjectRange(ctx, pinned, name, offset, length)
+	})
+	if attrs != nil && (attrs.ContentEncoding != "gzip" || s.readsStoredEncoding()) {
+		raw = newCRC32CVerifyingReader(name, raw, attrs.CRC32C)
+	}
+	out, err = s.uncompressedReader(ctx, raw)
+	if debugEnabled(s.logger) {
 		out = wrapReadCloser(out, func() {
-			zlog.Debug("closing dstore file", zap.String("path", s.pathWithExt(name)))
+			s.logger.Debug("closing dstore file", zap.String("path", s.pathWithExt(name)))
 		})
 	}
 	return
 }
 
-func (s *GSStore) DeleteObject(ctx context.Context, base string) error {
+func (s *GSStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	if s.decompressesReads() {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+	reader, err := s.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.throttleReads(ctx, reader), nil
+}
+
+func (s *GSStore) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	return s.openObjectRange(ctx, s.object(s.ObjectPath(name)), name, offset, length)
+}
+
+func (s *GSStore) openObjectRange(ctx context.Context, object *storage.ObjectHandle, name string, offset, length int64) (out io.ReadCloser, err error) {
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file range", zap.String("path", s.pathWithExt(name)), zap.Int64("offset", offset), zap.Int64("length", length))
+	}
+
+	if length < 0 {
+		length = -1
+	}
+
+	reader, err := object.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// object returns the handle reading the object at `path`, as stored when the
+// store handles its encoding, Google Storage serving objects stored with
+// `Content-Encoding: gzip` decompressed otherwise.
+func (s *GSStore) object(path string) *storage.ObjectHandle {
+	return s.client.Bucket(s.baseURL.Host).Object(path).ReadCompressed(s.readsStoredEncoding())
+}
+
+func (s *GSStore) DeleteObject(ctx context.Context, base string) (err error) {
+	defer classifyError(&err)
 	path := s.ObjectPath(base)
 	return s.client.Bucket(s.baseURL.Host).Object(path).Delete(ctx)
 }
 
-func (s *GSStore) FileExists(ctx context.Context, base string) (bool, error) {
+func (s *GSStore) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer classifyError(&err)
+	bucket := s.client.Bucket(s.baseURL.Host)
+	return deleteObjectsConcurrently(ctx, names, deleteObjectsConcurrency, func(ctx context.Context, name string) error {
+		err := bucket.Object(s.ObjectPath(name)).Delete(ctx)
+		if err == storage.ErrObjectNotExist {
+			return nil
+		}
+		return err
+	})
+}
+
+func (s *GSStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer classifyError(&err)
+	return deletePrefix(ctx, s, prefix, deletePrefixConcurrency)
+}
+
+func (s *GSStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer classifyError(&err)
 	path := s.ObjectPath(base)
 
-	_, err := s.client.Bucket(s.baseURL.Host).Object(path).Attrs(ctx)
+	_, err = s.client.Bucket(s.baseURL.Host).Object(path).Attrs(ctx)
 	if err != nil {
 		if err == storage.ErrObjectNotExist {
 			return false, nil
@@ -145,7 +1004,24 @@ func (s *GSStore) FileExists(ctx context.Context, base string) (bool, error) {
 	return true, nil
 }
 
-func (s *GSStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) error {
+func (s *GSStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+
+	objectAttrs, err := s.client.Bucket(s.baseURL.Host).Object(path).Attrs(ctx)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return newGSObjectAttrs(base, objectAttrs), nil
+}
+
+func (s *GSStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer classifyError(&err)
 	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
 	if err != nil {
 		return err
@@ -153,27 +1029,44 @@ func (s *GSStore) PushLocalFile(ctx context.Context, localFile, toBaseName strin
 	return remove()
 }
 
-func (s *GSStore) ListFiles(ctx context.Context, prefix string, max int) ([]string, error) {
+func (s *GSStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer classifyError(&err)
 	return listFiles(ctx, s, prefix, max)
 }
 
-func (s *GSStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error {
+func (s *GSStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
 	return s.WalkFrom(ctx, prefix, "", f)
 }
 
-func (s *GSStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
+func (s *GSStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkBetween(ctx, prefix, startingPoint, "", f)
+}
+
+func (s *GSStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkObjects(ctx, prefix, startingPoint, endPoint, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *GSStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkObjects(ctx, prefix, "", "", f)
+}
+
+func (s *GSStore) walkObjects(ctx context.Context, prefix, startingPoint, endPoint string, f func(attrs *ObjectAttrs) (err error)) error {
+	basePrefix := s.walkPrefix("")
+
 	q := &storage.Query{}
-	q.Prefix = strings.TrimLeft(s.baseURL.Path, "/") + "/"
-	if prefix != "" {
-		q.Prefix = filepath.Join(q.Prefix, prefix)
-		// join cleans the string and will remove the trailing / in the prefix if present.
-		// adding it back to prevent false positive matches
-		if prefix[len(prefix)-1:] == "/" {
-			q.Prefix = q.Prefix + "/"
-		}
-	}
+	q.Prefix = s.walkPrefix(prefix)
+	// Both offsets are store relative names, like the ones passed to `f`
 	if startingPoint != "" {
-		q.StartOffset = filepath.Join(q.Prefix, startingPoint)
+		q.StartOffset = basePrefix + startingPoint
+	}
+	if endPoint != "" {
+		q.EndOffset = basePrefix + endPoint
 	}
 	it := s.client.Bucket(s.baseURL.Host).Objects(ctx, q)
 
@@ -185,12 +1078,95 @@ func (s *GSStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f
 		if err != nil {
 			return err
 		}
-		if err := f(s.toBaseName(attrs.Name)); err != nil {
+
+		name := s.toBaseName(attrs.Name)
+		if err := f(newGSObjectAttrs(name, attrs)); err != nil {
 			if err == StopIteration {
 				return nil
 			}
-			return err
+
+			skipped, ok := skippedPrefix(err)
+			if !ok {
+				return err
+			}
+
+			// Seek past the skipped prefix by restarting the listing after it
+			bound := prefixUpperBound(skipped)
+			if bound == "" || (endPoint != "" && bound >= endPoint) {
+				return nil
+			}
+			if bound > name {
+				q.StartOffset = basePrefix + bound
+				it = s.client.Bucket(s.baseURL.Host).Objects(ctx, q)
+			}
 		}
 	}
 	return nil
 }
+
+func (s *GSStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer classifyError(&err)
+	it := s.client.Bucket(s.baseURL.Host).Objects(ctx, &storage.Query{Prefix: s.walkPrefix(prefix)})
+
+	var objects []*storage.ObjectAttrs
+	nextToken, err = iterator.NewPager(it, pageSize, pageToken).NextPage(&objects)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, attrs := range objects {
+		files = append(files, s.toBaseName(attrs.Name))
+	}
+	return files, nextToken, nil
+}
+
+// walkPrefix returns the full object name prefix to list for the given store
+// relative prefix.
+func (s *GSStore) walkPrefix(prefix string) string {
+	targetPrefix := strings.TrimLeft(s.baseURL.Path, "/") + "/"
+	if prefix != "" {
+		targetPrefix = filepath.Join(targetPrefix, prefix)
+		// join cleans the string and will remove the trailing / in the prefix if present.
+		// adding it back to prevent false positive matches
+		if prefix[len(prefix)-1:] == "/" {
+			targetPrefix = targetPrefix + "/"
+		}
+	}
+	return targetPrefix
+}
+
+func (s *GSStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer classifyError(&err)
+	q := &storage.Query{
+		Prefix:    directoryPrefix(s.baseURL.Path, prefix),
+		Delimiter: "/",
+	}
+	it := s.client.Bucket(s.baseURL.Host).Objects(ctx, q)
+
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Objects directly under the prefix have an empty `Prefix`
+		if attrs.Prefix != "" {
+			out = append(out, relativeDirectory(s.baseURL.Path, attrs.Prefix))
+		}
+	}
+	return out, nil
+}
+
+func newGSObjectAttrs(name string, attrs *storage.ObjectAttrs) *ObjectAttrs {
+	return &ObjectAttrs{
+		Name:           name,
+		Size:           attrs.Size,
+		LastModified:   attrs.Updated,
+		ETag:           attrs.Etag,
+		Generation:     attrs.Generation,
+		Metageneration: attrs.Metageneration,
+		Metadata:       normalizeMetadata(attrs.Metadata),
+	}
+}
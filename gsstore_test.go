@@ -0,0 +1,336 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+)
+
+// fakeGSServer implements the uploads, resumable uploads, composes and
+// deletes of the Google Storage JSON API, keeping the objects of a single
+// bucket in memory.
+type fakeGSServer struct {
+	lock     sync.Mutex
+	objects  map[string][]byte
+	composed [][]string
+	uploads  int
+	// sessions are the resumable uploads in progress, by upload ID
+	sessions map[string]*fakeGSSession
+}
+
+type fakeGSSession struct {
+	name    string
+	content []byte
+}
+
+func (f *fakeGSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Query().Get("uploadType") == "resumable":
+		if f.sessions == nil {
+			f.sessions = map[string]*fakeGSSession{}
+		}
+		id := strconv.Itoa(len(f.sessions))
+		f.sessions[id] = &fakeGSSession{name: r.URL.Query().Get("name")}
+		w.Header().Set("Location", "http://"+r.Host+"/upload/storage/v1/b/bucket/o?uploadType=resumable&upload_id="+id)
+
+	case r.Method == http.MethodPut && r.URL.Query().Get("upload_id") != "":
+		session, found := f.sessions[r.URL.Query().Get("upload_id")]
+		if !found {
+			http.Error(w, "no such upload", http.StatusNotFound)
+			return
+		}
+
+		var start, end, size int
+		contentRange := r.Header.Get("Content-Range")
+		if _, err := fmt.Sscanf(contentRange, "bytes */%d", &size); err != nil {
+			if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &size); err != nil || start != len(session.content) {
+				http.Error(w, "invalid range "+contentRange, http.StatusBadRequest)
+				return
+			}
+			chunk, _ := ioutil.ReadAll(r.Body)
+			session.content = append(session.content, chunk...)
+			f.uploads++
+		}
+
+		if len(session.content) == size {
+			f.objects[session.name] = session.content
+			f.writeObject(w, session.name)
+			return
+		}
+		if len(session.content) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(session.content)-1))
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/bucket/o"):
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		parts := multipart.NewReader(r.Body, params["boundary"])
+		var object struct {
+			Name string `json:"name"`
+		}
+		metadata, err := parts.NextPart()
+		if err == nil {
+			err = json.NewDecoder(metadata).Decode(&object)
+		}
+		var media *multipart.Part
+		if err == nil {
+			media, err = parts.NextPart()
+		}
+		var content []byte
+		if err == nil {
+			content, err = ioutil.ReadAll(media)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		f.uploads++
+		f.objects[object.Name] = content
+		f.writeObject(w, object.Name)
+
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/compose"):
+		dst := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/"), "/compose")
+		var request struct {
+			SourceObjects []struct {
+				Name string `json:"name"`
+			} `json:"sourceObjects"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var content []byte
+		var sources []string
+		for _, source := range request.SourceObjects {
+			content = append(content, f.objects[source.Name]...)
+			sources = append(sources, source.Name)
+		}
+		f.composed = append(f.composed, sources)
+		f.objects[dst] = content
+		f.writeObject(w, dst)
+
+	case r.Method == http.MethodDelete:
+		name := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/")
+		if _, found := f.objects[name]; !found {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeGSServer) writeObject(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"bucket": "bucket",
+		"name":   name,
+		"size":   strconv.Itoa(len(f.objects[name])),
+	})
+}
+
+func newFakeGSStore(t *testing.T, fake *fakeGSServer, opts ...Option) *GSStore {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	require.NoError(t, err)
+
+	store, err := NewGSStoreWithOptions(&url.URL{Scheme: "gs", Host: "bucket", Path: "/path"}, append(opts, GSClient(client))...)
+	require.NoError(t, err)
+	return store
+}
+
+func TestGSStore_ParallelCompositeUpload(t *testing.T) {
+	ctx := context.Background()
+	content := make([]byte, 10*1024+7)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	fake := &fakeGSServer{objects: map[string][]byte{}}
+	store := newFakeGSStore(t, fake, ParallelCompositeUpload(1024, 3), AllowOverwrite())
+
+	var attrs ObjectAttrs
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader(content), CaptureAttrs(&attrs)))
+	assert.Equal(t, content, fake.objects["path/file"])
+	assert.Equal(t, int64(len(content)), attrs.Size)
+	assert.Equal(t, 11, fake.uploads)
+	require.Len(t, fake.composed, 1)
+	assert.Len(t, fake.composed[0], 11)
+	assert.Len(t, fake.objects, 1, "parts are deleted")
+
+	// Content fitting in a single part is written directly
+	fake.uploads, fake.composed = 0, nil
+	require.NoError(t, store.WriteObject(ctx, "small", bytes.NewReader(content[:100])))
+	assert.Equal(t, content[:100], fake.objects["path/small"])
+	assert.Equal(t, 1, fake.uploads)
+	assert.Empty(t, fake.composed)
+
+	// More than 32 parts are composed in several rounds
+	fake.uploads, fake.composed = 0, nil
+	store = newFakeGSStore(t, fake, ParallelCompositeUpload(256, 0), AllowOverwrite())
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader(content)))
+	assert.Equal(t, content, fake.objects["path/file"])
+	assert.Equal(t, 41, fake.uploads)
+	assert.Len(t, fake.composed, 3)
+	assert.Len(t, fake.objects, 2)
+}
+
+func TestGSStore_SharedClient(t *testing.T) {
+	os.Setenv("STORAGE_EMULATOR_HOST", "localhost:1")
+	defer os.Unsetenv("STORAGE_EMULATOR_HOST")
+
+	first, err := NewGSStoreWithOptions(&url.URL{Scheme: "gs", Host: "bucket", Path: "/first"})
+	require.NoError(t, err)
+	second, err := NewGSStoreWithOptions(&url.URL{Scheme: "gs", Host: "bucket", Path: "/second"}, Compression("zstd"))
+	require.NoError(t, err)
+	assert.Same(t, first.client, second.client)
+
+	sub, err := first.SubStore("sub")
+	require.NoError(t, err)
+	assert.Same(t, first.client, sub.(*GSStore).client)
+	assert.Same(t, first.shared, sub.(*GSStore).shared)
+
+	client, err := storage.NewClient(context.Background(), option.WithoutAuthentication())
+	require.NoError(t, err)
+	injected, err := NewGSStoreWithOptions(&url.URL{Scheme: "gs", Host: "bucket", Path: "/injected"}, GSClient(client))
+	require.NoError(t, err)
+	assert.Same(t, client, injected.client)
+
+	sub, err = injected.SubStore("sub")
+	require.NoError(t, err)
+	assert.Same(t, client, sub.(*GSStore).client)
+	assert.Same(t, injected.shared, sub.(*GSStore).shared)
+
+	// The authenticated HTTP client of resumable writes is created once
+	shared := &gsSharedClient{base: http.DefaultClient, clientOptions: []option.ClientOption{option.WithoutAuthentication()}}
+	authenticated, err := shared.httpClient()
+	require.NoError(t, err)
+	again, err := shared.httpClient()
+	require.NoError(t, err)
+	assert.Same(t, authenticated, again)
+}
+
+func TestGSStore_CloseSharedClient(t *testing.T) {
+	// Credentials of their own keep the client apart from the other tests
+	credentials := filepath.Join(t.TempDir(), "credentials.json")
+	require.NoError(t, ioutil.WriteFile(credentials, []byte(`{"type": "service_account", "client_email": "test@example.com", "private_key": "key"}`), 0600))
+
+	first, err := NewGSStoreWithOptions(&url.URL{Scheme: "gs", Host: "bucket", Path: "/first"}, CredentialsFile(credentials))
+	require.NoError(t, err)
+	second, err := NewGSStoreWithOptions(&url.URL{Scheme: "gs", Host: "bucket", Path: "/second"}, CredentialsFile(credentials))
+	require.NoError(t, err)
+	sub, err := first.SubStore("sub")
+	require.NoError(t, err)
+	key := first.shared.key
+
+	require.NoError(t, first.Close())
+	require.NoError(t, first.Close())
+	require.NoError(t, second.Close())
+	assert.Contains(t, gsClients, key, "the sub store still uses the client")
+	require.NoError(t, sub.(*GSStore).Close())
+	assert.NotContains(t, gsClients, key)
+
+	third, err := NewGSStoreWithOptions(&url.URL{Scheme: "gs", Host: "bucket", Path: "/third"}, CredentialsFile(credentials))
+	require.NoError(t, err)
+	assert.NotSame(t, first.client, third.client, "a closed client is not reused")
+	require.NoError(t, third.Close())
+}
+
+func TestGSStore_WriteResumable(t *testing.T) {
+	ctx := context.Background()
+	content := make([]byte, gsResumableChunkSize+300*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	fake := &fakeGSServer{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	os.Setenv("STORAGE_EMULATOR_HOST", server.URL)
+	defer os.Unsetenv("STORAGE_EMULATOR_HOST")
+
+	client, err := storage.NewClient(ctx, option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	require.NoError(t, err)
+	store, err := NewGSStoreWithOptions(&url.URL{Scheme: "gs", Host: "bucket", Path: "/path"}, GSClient(client), AllowOverwrite())
+	require.NoError(t, err)
+
+	// The process "crashes" once the first chunk is stored
+	errCrash := errors.New("crash")
+	var saved UploadCheckpoint
+	err = WriteResumable(ctx, store, "file", bytes.NewReader(content), func(checkpoint *UploadCheckpoint) error {
+		saved = *checkpoint
+		if checkpoint.Offset > 0 {
+			return errCrash
+		}
+		return nil
+	})
+	require.True(t, errors.Is(err, errCrash), "expected crash, got %v", err)
+	assert.Equal(t, int64(gsResumableChunkSize), saved.Offset)
+	assert.NotContains(t, fake.objects, "path/file")
+
+	require.NoError(t, ResumeWrite(ctx, store, &saved, bytes.NewReader(content), func(*UploadCheckpoint) error { return nil }))
+	assert.Equal(t, content, fake.objects["path/file"])
+	assert.Equal(t, 2, fake.uploads, "stored chunks are not sent again")
+
+	require.NoError(t, WriteResumable(ctx, store, "empty", bytes.NewReader(nil), func(*UploadCheckpoint) error { return nil }))
+	assert.Contains(t, fake.objects, "path/empty")
+	assert.Empty(t, fake.objects["path/empty"])
+
+	// Compressed stores resume on the compressed bytes
+	compressed, err := NewGSStoreWithOptions(&url.URL{Scheme: "gs", Host: "bucket", Path: "/path"}, GSClient(client), Compression("zstd"), AllowOverwrite())
+	require.NoError(t, err)
+	err = WriteResumable(ctx, compressed, "compressed", bytes.NewReader(content), func(checkpoint *UploadCheckpoint) error {
+		saved = *checkpoint
+		if checkpoint.Offset > 0 {
+			return errCrash
+		}
+		return nil
+	})
+	require.True(t, errors.Is(err, errCrash), "expected crash, got %v", err)
+	assert.NotZero(t, saved.CompressedCRC32C)
+
+	changed := append([]byte{}, content...)
+	changed[0]++
+	err = ResumeWrite(ctx, compressed, &saved, bytes.NewReader(changed), func(*UploadCheckpoint) error { return nil })
+	assert.Error(t, err, "content compressing to other bytes can't be resumed")
+
+	require.NoError(t, ResumeWrite(ctx, compressed, &saved, bytes.NewReader(content), func(*UploadCheckpoint) error { return nil }))
+	decoder, err := zstd.NewReader(bytes.NewReader(fake.objects["path/compressed"]))
+	require.NoError(t, err)
+	defer decoder.Close()
+	decompressed, err := ioutil.ReadAll(decoder)
+	require.NoError(t, err)
+	assert.Equal(t, content, decompressed)
+}
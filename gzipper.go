@@ -3,6 +3,9 @@ package dstore
 import (
 	"compress/gzip"
 	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 type GZipReadCloser struct {
@@ -34,3 +37,96 @@ func (g *GZipReadCloser) Close() error {
 	}
 	return err1
 }
+
+// zstdReadCloser closes the source along with the decoder, which only
+// releases its own resources.
+type zstdReadCloser struct {
+	src io.ReadCloser
+	*zstd.Decoder
+}
+
+func (z *zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return z.src.Close()
+}
+
+// copyBufferSize is the size of the pooled buffers of `pooledCopy`, the one
+// `io.Copy` allocates.
+const copyBufferSize = 32 * 1024
+
+// copyBuffers holds a `sync.Pool` of buffers per size.
+var copyBuffers sync.Map
+
+// pooledCopy is `io.Copy` with a pooled buffer.
+func pooledCopy(dst io.Writer, src io.Reader) (int64, error) {
+	return pooledCopySize(dst, src, copyBufferSize)
+}
+
+// pooledCopySize is `pooledCopy` with a buffer of `size` bytes, the default
+// size when zero.
+func pooledCopySize(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if size <= 0 {
+		size = copyBufferSize
+	}
+
+	pool, found := copyBuffers.Load(size)
+	if !found {
+		pool, _ = copyBuffers.LoadOrStore(size, &sync.Pool{
+			New: func() interface{} {
+				buffer := make([]byte, size)
+				return &buffer
+			},
+		})
+	}
+
+	buffers := pool.(*sync.Pool)
+	buffer := buffers.Get().(*[]byte)
+	defer buffers.Put(buffer)
+
+	return io.CopyBuffer(dst, src, *buffer)
+}
+
+// gzipWriters pools the gzip writers of each compression level, indexed from
+// `gzip.HuffmanOnly`.
+var gzipWriters [gzip.BestCompression - gzip.HuffmanOnly + 1]sync.Pool
+
+// getGzipWriter returns a gzip writer of `level` writing to `w`, reused from
+// the pool when one is available.
+func getGzipWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		// Let gzip report the invalid level
+		return gzip.NewWriterLevel(w, level)
+	}
+
+	if gw, ok := gzipWriters[level-gzip.HuffmanOnly].Get().(*gzip.Writer); ok {
+		gw.Reset(w)
+		return gw, nil
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// releaseGzipWriter returns a closed writer of `getGzipWriter` to its pool,
+// parallel gzip writers are not pooled.
+func releaseGzipWriter(gw io.WriteCloser, level int) {
+	if writer, ok := gw.(*gzip.Writer); ok {
+		gzipWriters[level-gzip.HuffmanOnly].Put(writer)
+	}
+}
+
+// zstdEncoders pools the zstd encoders of each encoder level.
+var zstdEncoders [zstd.SpeedBestCompression + 1]sync.Pool
+
+// getZstdEncoder returns a zstd encoder of `level` writing to `w`, reused from
+// the pool when one is available.
+func getZstdEncoder(w io.Writer, level zstd.EncoderLevel) (*zstd.Encoder, error) {
+	if encoder, ok := zstdEncoders[level].Get().(*zstd.Encoder); ok {
+		encoder.Reset(w)
+		return encoder, nil
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+}
+
+// releaseZstdEncoder returns a closed encoder of `getZstdEncoder` to its pool.
+func releaseZstdEncoder(encoder *zstd.Encoder, level zstd.EncoderLevel) {
+	zstdEncoders[level].Put(encoder)
+}
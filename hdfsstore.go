@@ -0,0 +1,495 @@
+//go:build !dstore_no_hdfs
+
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2"
+	"github.com/colinmarc/hdfs/v2/hadoopconf"
+	"go.uber.org/zap"
+)
+
+// hdfsMetadataPrefix prefixes the extended attributes holding the object's
+// metadata.
+const hdfsMetadataPrefix = "user.dstore."
+
+//
+// HDFS Store
+//
+
+func init() {
+	registerBackend(&backend{
+		schemes: []string{"hdfs"},
+		newStore: func(base *url.URL, opts ...Option) (Store, error) {
+			store, err := NewHDFSStoreWithOptions(base, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		},
+	})
+}
+
+type HDFSStore struct {
+	baseURL  *url.URL
+	basePath string
+	client   *hdfs.Client
+	*commonStore
+}
+
+func NewHDFSStore(baseURL *url.URL, extension, compressionType string, overwrite bool) (*HDFSStore, error) {
+	return NewHDFSStoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+// NewHDFSStoreWithOptions connects to the namenode of the
+// `hdfs://namenode:port/path` URL, or to the namenodes of the Hadoop
+// configuration found through `HADOOP_CONF_DIR` when the URL has no host, as
+// in `hdfs:///path`. The HDFS user is the URL's user, `HADOOP_USER_NAME` or
+// the current system user, in that order. Kerberos authentication is not
+// supported.
+func NewHDFSStoreWithOptions(baseURL *url.URL, opts ...Option) (*HDFSStore, error) {
+	options, err := newHDFSClientOptions(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := hdfs.NewClient(options)
+	if err != nil {
+		return nil, fmt.Errorf("hdfs client: %w", err)
+	}
+
+	return NewHDFSStoreFromClient(baseURL, client, opts...)
+}
+
+// NewHDFSStoreFromClient creates a store on top of an already connected HDFS
+// client, for setups like Kerberos that cannot be expressed through the store
+// URL.
+func NewHDFSStoreFromClient(baseURL *url.URL, client *hdfs.Client, opts ...Option) (*HDFSStore, error) {
+	config := newConfig(opts)
+
+	basePath := path.Clean("/" + baseURL.Path)
+	if err := client.MkdirAll(basePath, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create base path %q: %w", basePath, err)
+	}
+
+	return &HDFSStore{
+		baseURL:     baseURL,
+		basePath:    basePath,
+		client:      client,
+		commonStore: newCommonStore(baseURL, config),
+	}, nil
+}
+
+func newHDFSClientOptions(baseURL *url.URL) (options hdfs.ClientOptions, err error) {
+	if baseURL.Host != "" {
+		options.Addresses = []string{baseURL.Host}
+	} else {
+		conf, err := hadoopconf.LoadFromEnvironment()
+		if err != nil {
+			return options, fmt.Errorf("loading hadoop configuration: %w", err)
+		}
+
+		options = hdfs.ClientOptionsFromConf(conf)
+		if options.KerberosClient != nil {
+			return options, fmt.Errorf("kerberos authentication is not supported, create the store with NewHDFSStoreFromClient instead")
+		}
+		if len(options.Addresses) == 0 {
+			return options, fmt.Errorf("specify hdfs namenode like: hdfs://namenode:8020/path, or through the HADOOP_CONF_DIR configuration")
+		}
+	}
+
+	options.User = os.Getenv("HADOOP_USER_NAME")
+	if baseURL.User != nil && baseURL.User.Username() != "" {
+		options.User = baseURL.User.Username()
+	}
+	if options.User == "" {
+		current, err := user.Current()
+		if err != nil {
+			return options, fmt.Errorf("determining hdfs user: %w", err)
+		}
+		options.User = current.Username
+	}
+
+	return options, nil
+}
+
+// SubStore shares the HDFS connection of the parent store.
+func (s *HDFSStore) SubStore(subFolder string) (Store, error) {
+	url, err := url.Parse(s.baseURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("hdfs store parsing base url: %w", err)
+	}
+	url.Path = path.Join(url.Path, subFolder)
+	return NewHDFSStoreFromClient(url, s.client, s.options()...)
+}
+
+func (s *HDFSStore) BaseURL() *url.URL {
+	return s.baseURL
+}
+
+func (s *HDFSStore) ObjectPath(name string) string {
+	return path.Join(s.basePath, s.pathWithExt(name))
+}
+
+func (s *HDFSStore) ObjectURL(name string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(s.baseURL.String(), "/"), strings.TrimLeft(s.pathWithExt(name), "/"))
+}
+
+func (s *HDFSStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return "", fmt.Errorf("hdfs store presign: %w", ErrNotSupported)
+}
+
+func (s *HDFSStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return "", fmt.Errorf("hdfs store presign: %w", ErrNotSupported)
+}
+
+func (s *HDFSStore) toBaseName(filePath string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(filePath, s.pathWithExt("")), strings.TrimSuffix(s.basePath, "/")+"/")
+}
+
+func (s *HDFSStore) WriteObject(ctx context.Context, base string, reader io.Reader, opts ...WriteOption) (err error) {
+	defer classifyError(&err)
+	destPath := s.ObjectPath(base)
+	config := newWriteConfig(opts)
+	reader = config.trackProgress(reader)
+	if config.acl != "" {
+		return fmt.Errorf("object ACL: %w", ErrNotSupported)
+	}
+
+	if !s.overwrite {
+		exists, err := s.FileExists(ctx, base)
+		if err != nil {
+			return err
+		}
+		if exists {
+			// We silently ignore when we ask not to overwrite
+			return nil
+		}
+	}
+
+	return s.writeFile(destPath, config.metadata, func(w io.Writer) error {
+		return s.compressedCopy(ctx, reader, w)
+	})
+}
+
+// writeFile writes the file through a temporary file renamed once complete,
+// HDFS files being visible while written. Each write uses its own temporary
+// file, HDFS refusing to create a file another client is still writing.
+func (s *HDFSStore) writeFile(destPath string, metadata map[string]string, write func(w io.Writer) error) error {
+	tempPath := fmt.Sprintf("%s.%08x.tmp", destPath, rand.Uint32())
+
+	targetDir := path.Dir(tempPath)
+	if err := s.client.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("ensuring directory exists (mkdir -p) %q: %w", targetDir, err)
+	}
+
+	file, err := s.client.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("unable to create file %q: %w", tempPath, err)
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+		s.client.Remove(tempPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		s.client.Remove(tempPath)
+		return err
+	}
+
+	for key, value := range metadata {
+		if err := s.client.SetXAttr(tempPath, hdfsMetadataPrefix+strings.ToLower(key), value); err != nil {
+			s.client.Remove(tempPath)
+			return fmt.Errorf("writing metadata %q: %w", key, err)
+		}
+	}
+
+	if err := s.client.Rename(tempPath, destPath); err != nil {
+		s.client.Remove(tempPath)
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+func (s *HDFSStore) readMetadata(objectPath string) (map[string]string, error) {
+	attrs, err := s.client.ListXAttrs(objectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata map[string]string
+	for key, value := range attrs {
+		if strings.HasPrefix(key, hdfsMetadataPrefix) {
+			if metadata == nil {
+				metadata = map[string]string{}
+			}
+			metadata[strings.TrimPrefix(key, hdfsMetadataPrefix)] = value
+		}
+	}
+	return metadata, nil
+}
+
+func (s *HDFSStore) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, s, dst); skip || err != nil {
+		return err
+	}
+
+	srcPath := s.ObjectPath(src)
+	srcFile, err := s.client.Open(srcPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	defer srcFile.Close()
+
+	metadata, err := s.readMetadata(srcPath)
+	if err != nil {
+		return fmt.Errorf("reading metadata: %w", err)
+	}
+
+	return s.writeFile(s.ObjectPath(dst), metadata, func(w io.Writer) error {
+		_, err := io.Copy(w, srcFile)
+		return err
+	})
+}
+
+func (s *HDFSStore) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, s, newName); skip || err != nil {
+		if err != nil {
+			return err
+		}
+		return s.DeleteObject(ctx, oldName)
+	}
+
+	newPath := s.ObjectPath(newName)
+
+	targetDir := path.Dir(newPath)
+	if err := s.client.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("ensuring directory exists (mkdir -p) %q: %w", targetDir, err)
+	}
+
+	// Extended attributes, and so the metadata, follow the renamed file
+	if err := s.client.Rename(s.ObjectPath(oldName), newPath); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+func (s *HDFSStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(name)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file", zap.String("path", path))
+	}
+
+	file, err := s.client.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return s.uncompressedReader(ctx, s.resumeReads(ctx, name, file, s.openStoredRange))
+}
+
+func (s *HDFSStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	if s.decompressesReads() {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+	reader, err := s.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.throttleReads(ctx, reader), nil
+}
+
+func (s *HDFSStore) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	file, err := s.client.Open(s.ObjectPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if offset >= file.Stat().Size() {
+		// Seeking past the end of the file is an error on HDFS
+		file.Close()
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("seeking to offset %d: %w", offset, err)
+	}
+
+	return limitReadCloser(file, length), nil
+}
+
+func (s *HDFSStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer classifyError(&err)
+	if _, err := s.client.Stat(s.ObjectPath(base)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *HDFSStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+
+	info, err := s.client.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return s.newObjectAttrs(base, path, info)
+}
+
+func (s *HDFSStore) newObjectAttrs(name, path string, info os.FileInfo) (*ObjectAttrs, error) {
+	metadata, err := s.readMetadata(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+
+	return &ObjectAttrs{
+		Name:         name,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		ETag:         fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+		Metadata:     metadata,
+	}, nil
+}
+
+func (s *HDFSStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer classifyError(&err)
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+func (s *HDFSStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer classifyError(&err)
+	return listFiles(ctx, s, prefix, max)
+}
+
+func (s *HDFSStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer classifyError(&err)
+	return listFilesPage(ctx, s, prefix, pageSize, pageToken)
+}
+
+func (s *HDFSStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer classifyError(&err)
+	prefix = strings.Trim(prefix, "/")
+
+	entries, err := s.client.ReadDir(path.Join(s.basePath, prefix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			out = append(out, path.Join(prefix, entry.Name()))
+		}
+	}
+	return out, nil
+}
+
+func (s *HDFSStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
+}
+
+func (s *HDFSStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkBetween(s, ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *HDFSStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *HDFSStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkDirectories(ctx, s.basePath, prefix, s.listDir, f)
+}
+
+func (s *HDFSStore) listDir(dir string) ([]*dirEntry, error) {
+	infos, err := s.client.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	entries := make([]*dirEntry, len(infos))
+	for i, info := range infos {
+		info, entryPath := info, dir+"/"+info.Name()
+		entries[i] = &dirEntry{path: entryPath, dir: info.IsDir(), attrs: func(name string) (*ObjectAttrs, error) {
+			return s.newObjectAttrs(name, entryPath, info)
+		}}
+	}
+	return entries, nil
+}
+
+func (s *HDFSStore) DeleteObject(ctx context.Context, base string) (err error) {
+	defer classifyError(&err)
+	return s.client.Remove(s.ObjectPath(base))
+}
+
+func (s *HDFSStore) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer classifyError(&err)
+	return deleteObjectsConcurrently(ctx, names, deleteObjectsConcurrency, func(ctx context.Context, name string) error {
+		if err := s.DeleteObject(ctx, name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *HDFSStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer classifyError(&err)
+	return deletePrefix(ctx, s, prefix, deletePrefixConcurrency)
+}
@@ -0,0 +1,51 @@
+//go:build !dstore_no_hdfs
+
+package dstore
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newHDFSClientOptions(t *testing.T) {
+	confDir := t.TempDir()
+	os.Setenv("HADOOP_CONF_DIR", confDir)
+	os.Setenv("HADOOP_USER_NAME", "hadoop")
+	defer os.Unsetenv("HADOOP_CONF_DIR")
+	defer os.Unsetenv("HADOOP_USER_NAME")
+
+	base, _ := url.Parse("hdfs://namenode:8020/path")
+	options, err := newHDFSClientOptions(base)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"namenode:8020"}, options.Addresses)
+	assert.Equal(t, "hadoop", options.User)
+
+	base, _ = url.Parse("hdfs://alice@namenode:8020/path")
+	options, err = newHDFSClientOptions(base)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", options.User)
+
+	base, _ = url.Parse("hdfs:///path")
+	_, err = newHDFSClientOptions(base)
+	require.Error(t, err, "no namenode")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(confDir, "core-site.xml"), []byte(`<configuration>
+  <property><name>fs.defaultFS</name><value>hdfs://confnode:9000</value></property>
+</configuration>`), 0644))
+	options, err = newHDFSClientOptions(base)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"confnode:9000"}, options.Addresses)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(confDir, "core-site.xml"), []byte(`<configuration>
+  <property><name>fs.defaultFS</name><value>hdfs://confnode:9000</value></property>
+  <property><name>hadoop.security.authentication</name><value>kerberos</value></property>
+</configuration>`), 0644))
+	_, err = newHDFSClientOptions(base)
+	require.Error(t, err, "kerberos")
+}
@@ -0,0 +1,141 @@
+package dstore
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//
+// Hedged Store
+//
+
+// HedgedStore is a `Store` hedging the reads of the inner store: when opening
+// an object or a range of it has not answered after a delay, a second
+// identical request is sent and the first one to answer is used, the other
+// being canceled. This cuts the tail latency of latency sensitive readers when
+// a backend occasionally takes seconds to answer, at the cost of the
+// duplicated requests.
+//
+// An open failing before the delay is returned as-is, without hedging.
+type HedgedStore struct {
+	// Store is the inner store, receiving the hedged reads.
+	Store
+
+	delay time.Duration
+}
+
+func NewHedgedStore(inner Store, delay time.Duration) *HedgedStore {
+	return &HedgedStore{
+		Store: inner,
+		delay: delay,
+	}
+}
+
+func (s *HedgedStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := *s
+	sub.Store = inner
+	return &sub, nil
+}
+
+func (s *HedgedStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	return s.hedge(ctx, name, func(ctx context.Context) (io.ReadCloser, error) {
+		return s.Store.OpenObject(ctx, name)
+	})
+}
+
+func (s *HedgedStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	return s.hedge(ctx, name, func(ctx context.Context) (io.ReadCloser, error) {
+		return s.Store.OpenObjectRange(ctx, name, offset, length)
+	})
+}
+
+type hedgedOpen struct {
+	attempt int
+	reader  io.ReadCloser
+	err     error
+}
+
+// hedge runs `open`, running it a second time if it has not returned after
+// the delay, and returns the first reader opened. Each attempt has its own
+// context, canceled when the attempt loses or when the returned reader is
+// closed.
+func (s *HedgedStore) hedge(ctx context.Context, name string, open func(ctx context.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	results := make(chan hedgedOpen, 2)
+	var cancels []context.CancelFunc
+	launch := func() {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		attempt := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			reader, err := open(attemptCtx)
+			results <- hedgedOpen{attempt: attempt, reader: reader, err: err}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(s.delay)
+	defer timer.Stop()
+
+	pending := 1
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if logger := storeLogger(s.Store); debugEnabled(logger) {
+				logger.Debug("hedging slow open", zap.String("name", name), zap.Duration("delay", s.delay))
+			}
+			launch()
+			pending++
+
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				for attempt, cancel := range cancels {
+					if attempt != result.attempt {
+						cancel()
+					}
+				}
+				go closeHedgedLosers(results, pending)
+				return &hedgedReader{ReadCloser: result.reader, cancel: cancels[result.attempt]}, nil
+			}
+
+			cancels[result.attempt]()
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// closeHedgedLosers closes the readers of the `pending` attempts that lost the
+// race, if they managed to open one.
+func closeHedgedLosers(results <-chan hedgedOpen, pending int) {
+	for ; pending > 0; pending-- {
+		if result := <-results; result.err == nil {
+			result.reader.Close()
+		}
+	}
+}
+
+// hedgedReader cancels the context of the winning attempt once closed.
+type hedgedReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *hedgedReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
+}
@@ -0,0 +1,93 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedgedStore(t *testing.T) {
+	ctx := context.Background()
+	inner := &stallingStore{MemoryStore: NewMemoryStore(), stalled: 1}
+	require.NoError(t, inner.WriteObject(ctx, "file", strings.NewReader("content")))
+	store := NewHedgedStore(inner, 10*time.Millisecond)
+
+	reader, err := store.OpenObject(ctx, "file")
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, "content", string(content))
+	assert.Equal(t, 2, inner.opens())
+
+	// The stalled attempt is canceled once the hedged one won
+	select {
+	case <-inner.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("stalled open not canceled")
+	}
+
+	reader, err = store.OpenObjectRange(ctx, "file", 2, 3)
+	require.NoError(t, err)
+	content, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, "nte", string(content))
+	assert.Equal(t, 3, inner.opens(), "fast opens are not hedged")
+
+	_, err = store.OpenObject(ctx, "missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, 4, inner.opens(), "failures before the delay are not hedged")
+
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+	assert.Equal(t, store.delay, sub.(*HedgedStore).delay)
+}
+
+// stallingStore blocks the next `stalled` opens until their context is
+// canceled.
+type stallingStore struct {
+	*MemoryStore
+
+	lock     sync.Mutex
+	stalled  int
+	calls    int
+	canceled chan struct{}
+}
+
+func (s *stallingStore) opens() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.calls
+}
+
+func (s *stallingStore) OpenObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.OpenObjectRange(ctx, name, 0, -1)
+}
+
+func (s *stallingStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	s.lock.Lock()
+	s.calls++
+	stall := s.stalled > 0
+	if stall {
+		s.stalled--
+		s.canceled = make(chan struct{})
+	}
+	canceled := s.canceled
+	s.lock.Unlock()
+
+	if stall {
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	}
+	return s.MemoryStore.OpenObjectRange(ctx, name, offset, length)
+}
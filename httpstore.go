@@ -0,0 +1,550 @@
+//go:build !dstore_no_http
+
+package dstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/html"
+)
+
+//
+// HTTP Store
+//
+
+func init() {
+	registerBackend(&backend{
+		schemes: []string{"http", "https"},
+		newStore: func(base *url.URL, opts ...Option) (Store, error) {
+			store, err := NewHTTPStoreWithOptions(base, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		},
+	})
+}
+
+// HTTPStore is a read-only store on a plain web server or CDN. Objects are
+// fetched with `GET` and `HEAD` requests, and walked either through the
+// server's HTML directory listing pages or through an index file listing the
+// objects.
+type HTTPStore struct {
+	baseURL  *url.URL
+	endpoint *url.URL
+	basePath string
+	client   *http.Client
+
+	// indexPath is the server path of the index file listing the objects,
+	// empty to walk directory listing pages instead.
+	indexPath string
+	*commonStore
+}
+
+var errHTTPStoreReadOnly = fmt.Errorf("http store is read-only: %w", ErrNotSupported)
+
+func NewHTTPStore(baseURL *url.URL, extension, compressionType string, overwrite bool) (*HTTPStore, error) {
+	return NewHTTPStoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+// NewHTTPStoreWithOptions creates a read-only store on the web server of the
+// `http://host/path` or `https://host/path` URL. Objects are walked through
+// the server's directory listing pages, or through the index file given by the
+// `index` query parameter, resolved against the base path, which lists one
+// object path per line, optionally followed by the object's size. Credentials
+// in the URL's user info are sent through basic authentication and the other
+// query parameters, like CDN tokens, are sent along with every request.
+func NewHTTPStoreWithOptions(baseURL *url.URL, opts ...Option) (*HTTPStore, error) {
+	return newHTTPStore(baseURL, http.DefaultClient, newConfig(opts))
+}
+
+func newHTTPStore(baseURL *url.URL, client *http.Client, config *config) (*HTTPStore, error) {
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported http scheme %q, use http:// or https://", baseURL.Scheme)
+	}
+
+	basePath := path.Clean("/" + baseURL.Path)
+
+	query := baseURL.Query()
+	var indexPath string
+	if index := query.Get("index"); index != "" {
+		indexPath = index
+		if !strings.HasPrefix(indexPath, "/") {
+			indexPath = path.Join(basePath, index)
+		}
+	}
+	for _, param := range []string{"index", "content_type", "cache_control", "compression_level", "content_encoding", "checksums", "verify_checksums", "object_lock", "read_bandwidth", "write_bandwidth", "read_ahead", "read_resumes"} {
+		query.Del(param)
+	}
+
+	endpoint := &url.URL{Scheme: baseURL.Scheme, User: baseURL.User, Host: baseURL.Host, RawQuery: query.Encode()}
+
+	return &HTTPStore{
+		baseURL:     baseURL,
+		endpoint:    endpoint,
+		basePath:    basePath,
+		client:      client,
+		indexPath:   indexPath,
+		commonStore: newCommonStore(baseURL, config),
+	}, nil
+}
+
+// SubStore shares the HTTP client and the index file of the parent store.
+func (s *HTTPStore) SubStore(subFolder string) (Store, error) {
+	url, err := url.Parse(s.baseURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("http store parsing base url: %w", err)
+	}
+	url.Path = path.Join(url.Path, subFolder)
+	if s.indexPath != "" {
+		query := url.Query()
+		query.Set("index", s.indexPath)
+		url.RawQuery = query.Encode()
+	}
+	return newHTTPStore(url, s.client, newConfig(s.options()))
+}
+
+func (s *HTTPStore) BaseURL() *url.URL {
+	return s.baseURL
+}
+
+func (s *HTTPStore) ObjectPath(name string) string {
+	return path.Join(s.basePath, s.pathWithExt(name))
+}
+
+func (s *HTTPStore) ObjectURL(name string) string {
+	return s.resourceURL(s.ObjectPath(name))
+}
+
+// PresignGet returns the plain URL of the object, which grants access by
+// itself unless the store authenticates with credentials.
+func (s *HTTPStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	if s.endpoint.User != nil {
+		return "", fmt.Errorf("http store presign with credentials: %w", ErrNotSupported)
+	}
+	return s.ObjectURL(base), nil
+}
+
+func (s *HTTPStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return "", errHTTPStoreReadOnly
+}
+
+func (s *HTTPStore) toBaseName(filePath string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(filePath, s.pathWithExt("")), strings.TrimSuffix(s.basePath, "/")+"/")
+}
+
+func (s *HTTPStore) resourceURL(resourcePath string) string {
+	u := *s.endpoint
+	u.User = nil
+	u.Path = resourcePath
+	return u.String()
+}
+
+// doExpect runs the request and turns any response whose status is not one of
+// `expected` into an error, `ErrNotFound` for 404s.
+func (s *HTTPStore) doExpect(ctx context.Context, method, resourcePath string, headers map[string]string, expected ...int) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.resourceURL(resourcePath), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if user := s.endpoint.User; user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	return nil, newStatusError(resp.StatusCode, fmt.Errorf("http %s %q: %s", method, resourcePath, resp.Status))
+}
+
+func (s *HTTPStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(name)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file", zap.String("path", path))
+	}
+
+	resp, err := s.doExpect(ctx, http.MethodGet, path, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.uncompressedReader(ctx, s.resumeReads(ctx, name, resp.Body, s.openStoredRange))
+}
+
+func (s *HTTPStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	if s.decompressesReads() {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+	reader, err := s.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.throttleReads(ctx, reader), nil
+}
+
+func (s *HTTPStore) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+
+	resp, err := s.doExpect(ctx, http.MethodGet, s.ObjectPath(name), map[string]string{"Range": byteRange}, http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The offset is past the end of the object
+		resp.Body.Close()
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	case http.StatusOK:
+		// The server ignored the range and sends the whole object
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil && err != io.EOF {
+			resp.Body.Close()
+			return nil, fmt.Errorf("skipping to offset %d: %w", offset, err)
+		}
+		return limitReadCloser(resp.Body, length), nil
+	}
+	return resp.Body, nil
+}
+
+func (s *HTTPStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer classifyError(&err)
+	if _, err := s.ObjectAttributes(ctx, base); err != nil {
+		if err == ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *HTTPStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	defer classifyError(&err)
+	resp, err := s.doExpect(ctx, http.MethodHead, s.ObjectPath(base), nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	attrs = &ObjectAttrs{
+		Name: base,
+		Size: resp.ContentLength,
+		ETag: resp.Header.Get("ETag"),
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		if attrs.LastModified, err = http.ParseTime(lastModified); err != nil {
+			return nil, fmt.Errorf("invalid last modified %q: %w", lastModified, err)
+		}
+	}
+	return attrs, nil
+}
+
+func (s *HTTPStore) WriteObject(ctx context.Context, base string, reader io.Reader, opts ...WriteOption) (err error) {
+	defer classifyError(&err)
+	return errHTTPStoreReadOnly
+}
+
+func (s *HTTPStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer classifyError(&err)
+	return errHTTPStoreReadOnly
+}
+
+func (s *HTTPStore) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer classifyError(&err)
+	return errHTTPStoreReadOnly
+}
+
+func (s *HTTPStore) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer classifyError(&err)
+	return errHTTPStoreReadOnly
+}
+
+func (s *HTTPStore) DeleteObject(ctx context.Context, base string) (err error) {
+	defer classifyError(&err)
+	return errHTTPStoreReadOnly
+}
+
+func (s *HTTPStore) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer classifyError(&err)
+	return errHTTPStoreReadOnly
+}
+
+func (s *HTTPStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer classifyError(&err)
+	return 0, errHTTPStoreReadOnly
+}
+
+func (s *HTTPStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer classifyError(&err)
+	return listFiles(ctx, s, prefix, max)
+}
+
+func (s *HTTPStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer classifyError(&err)
+	return listFilesPage(ctx, s, prefix, pageSize, pageToken)
+}
+
+func (s *HTTPStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer classifyError(&err)
+	prefix = strings.Trim(prefix, "/")
+
+	list, err := s.lister(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := list(strings.TrimSuffix(path.Join(s.basePath, prefix), "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.dir {
+			out = append(out, path.Join(prefix, path.Base(entry.path)))
+		}
+	}
+	return out, nil
+}
+
+func (s *HTTPStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
+}
+
+func (s *HTTPStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkBetween(s, ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *HTTPStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+// WalkObjects yields the object sizes found in the index file, listing pages
+// carrying no reliable attributes, only names are set when walking them.
+func (s *HTTPStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer classifyError(&err)
+	list, err := s.lister(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.walkDirectories(ctx, s.basePath, prefix, func(dir string) ([]*dirEntry, error) {
+		entries, err := list(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		out := make([]*dirEntry, len(entries))
+		for i, entry := range entries {
+			size := entry.size
+			out[i] = &dirEntry{path: entry.path, dir: entry.dir, attrs: func(name string) (*ObjectAttrs, error) {
+				return &ObjectAttrs{Name: name, Size: size}, nil
+			}}
+		}
+		return out, nil
+	}, f)
+}
+
+type httpEntry struct {
+	path string
+	dir  bool
+	size int64
+}
+
+// httpLister returns the immediate entries of a directory, sorted by path.
+// Paths are returned without trailing slash, and a missing directory has no
+// entries.
+type httpLister func(dir string) ([]*httpEntry, error)
+
+// lister returns the lister of the store, reading the index file once upfront
+// when configured, or the directory listing pages otherwise.
+func (s *HTTPStore) lister(ctx context.Context) (httpLister, error) {
+	if s.indexPath == "" {
+		return func(dir string) ([]*httpEntry, error) {
+			entries, err := s.readListing(ctx, dir)
+			if err != nil {
+				if err == ErrNotFound {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return sortedHTTPEntries(entries), nil
+		}, nil
+	}
+
+	files, err := s.readIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(dir string) ([]*httpEntry, error) {
+		// Files deeper than `dir` show up as their top-most directory below it
+		entries := map[string]*httpEntry{}
+		for _, file := range files {
+			if !strings.HasPrefix(file.path, dir+"/") {
+				continue
+			}
+
+			rest := strings.TrimPrefix(file.path, dir+"/")
+			if index := strings.Index(rest, "/"); index >= 0 {
+				dirPath := dir + "/" + rest[:index]
+				entries[dirPath] = &httpEntry{path: dirPath, dir: true}
+				continue
+			}
+			entries[file.path] = file
+		}
+		return sortedHTTPEntries(entries), nil
+	}, nil
+}
+
+func sortedHTTPEntries(entries map[string]*httpEntry) []*httpEntry {
+	out := make([]*httpEntry, 0, len(entries))
+	for _, entry := range entries {
+		out = append(out, entry)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].path < out[j].path })
+	return out
+}
+
+// readIndex reads the files listed in the index file, each line holding a
+// file path, resolved against the index file's URL like a link would be,
+// optionally followed by the file's size. Blank lines and lines starting with
+// `#` are ignored.
+func (s *HTTPStore) readIndex(ctx context.Context) ([]*httpEntry, error) {
+	resp, err := s.doExpect(ctx, http.MethodGet, s.indexPath, nil, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("reading index %q: %w", s.indexPath, err)
+	}
+	defer resp.Body.Close()
+
+	indexURL := &url.URL{Path: s.indexPath}
+	var files []*httpEntry
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		ref, err := url.Parse(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid index entry %q: %w", fields[0], err)
+		}
+		if ref.Host != "" && ref.Host != s.endpoint.Host {
+			continue
+		}
+
+		file := &httpEntry{path: path.Clean(indexURL.ResolveReference(ref).Path)}
+		if len(fields) > 1 {
+			if file.size, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid index entry size %q: %w", fields[1], err)
+			}
+		}
+		files = append(files, file)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading index %q: %w", s.indexPath, err)
+	}
+	return files, nil
+}
+
+// readListing reads the entries of `dir` from the links of its HTML listing
+// page, like the ones of Apache's `mod_autoindex` or nginx's `autoindex`. Only
+// the links to the immediate children of `dir` are kept, the ones ending with
+// `/` being directories.
+func (s *HTTPStore) readListing(ctx context.Context, dir string) (map[string]*httpEntry, error) {
+	resp, err := s.doExpect(ctx, http.MethodGet, dir+"/", nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	dirURL := &url.URL{Path: dir + "/"}
+	entries := map[string]*httpEntry{}
+
+	tokenizer := html.NewTokenizer(resp.Body)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return nil, fmt.Errorf("parsing listing of %q: %w", dir, err)
+			}
+			return entries, nil
+		case html.StartTagToken:
+			name, hasAttr := tokenizer.TagName()
+			if string(name) != "a" {
+				continue
+			}
+
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = tokenizer.TagAttr()
+				if string(key) != "href" {
+					continue
+				}
+
+				ref, err := url.Parse(string(value))
+				if err != nil || (ref.Host != "" && ref.Host != s.endpoint.Host) || ref.RawQuery != "" {
+					// Not a link to an entry, like the sorting links of Apache
+					continue
+				}
+
+				linkPath := dirURL.ResolveReference(ref).Path
+				entryPath := strings.TrimSuffix(linkPath, "/")
+				if path.Dir(entryPath) != path.Clean("/"+dir) || entryPath == dir {
+					continue
+				}
+				entries[entryPath] = &httpEntry{path: entryPath, dir: strings.HasSuffix(linkPath, "/")}
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package dstore
+
+import (
+	"context"
+	"io"
+)
+
+//
+// Intercepted Store
+//
+
+// Operation is an operation on a store going through the interceptors of an
+// `InterceptedStore`. Interceptors may change its arguments before calling
+// the next handler, and inspect its results once it returned.
+type Operation struct {
+	// Store is the inner store running the operation.
+	Store Store
+	// Method is the name of the `Store` method called, like "OpenObject" or
+	// "WriteObject".
+	Method string
+	// Name is the name of the object of the operation, the source of copies
+	// and renames, the prefix of walks, listings and deletions of prefixes,
+	// and the local file of pushes.
+	Name string
+	// Destination is the destination of copies and renames, and the name of
+	// the object of pushes.
+	Destination string
+	// Names are the names of the objects deleted by "DeleteObjects".
+	Names []string
+	// Offset and Length are the range of "OpenObjectRange".
+	Offset int64
+	Length int64
+	// Content is the content written by "WriteObject", which interceptors may
+	// wrap, and WriteOptions its options.
+	Content      io.Reader
+	WriteOptions []WriteOption
+
+	// Reader is the reader of the object opened by "OpenObject" and
+	// "OpenObjectRange", set once the operation succeeded, which interceptors
+	// may wrap.
+	Reader io.ReadCloser
+}
+
+// Handler runs an operation, or the rest of the interceptors before it.
+type Handler func(ctx context.Context) error
+
+// Interceptor wraps the handler of each operation, to run code before and
+// after it or to fail it without running it. The handler returned must call
+// `next` for the operation to go on.
+type Interceptor func(op *Operation, next Handler) Handler
+
+// InterceptedStore is a `Store` running its operations through interceptors,
+// an extension point for the concerns common to all the operations, like
+// refreshing credentials, auditing or enforcing rules on the names written,
+// without writing a wrapper store implementing each method.
+//
+// `ObjectPath`, `ObjectURL`, the presigned URLs and the store settings are
+// not operations on the backend and are not intercepted.
+type InterceptedStore struct {
+	// Store is the inner store, running the intercepted operations.
+	Store
+
+	interceptors []Interceptor
+}
+
+// NewInterceptedStore returns an `InterceptedStore` running the operations on
+// `inner` through `interceptors`, the first one being the outermost.
+func NewInterceptedStore(inner Store, interceptors ...Interceptor) *InterceptedStore {
+	return &InterceptedStore{
+		Store:        inner,
+		interceptors: interceptors,
+	}
+}
+
+func (s *InterceptedStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := *s
+	sub.Store = inner
+	return &sub, nil
+}
+
+// run runs `handler` through the interceptors for `op`.
+func (s *InterceptedStore) run(ctx context.Context, op *Operation, handler Handler) error {
+	op.Store = s.Store
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		handler = s.interceptors[i](op, handler)
+	}
+	return handler(ctx)
+}
+
+func (s *InterceptedStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	op := &Operation{Method: "OpenObject", Name: name}
+	err = s.run(ctx, op, func(ctx context.Context) (err error) {
+		op.Reader, err = s.Store.OpenObject(ctx, op.Name)
+		return err
+	})
+	return openedReader(op, err)
+}
+
+func (s *InterceptedStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	op := &Operation{Method: "OpenObjectRange", Name: name, Offset: offset, Length: length}
+	err = s.run(ctx, op, func(ctx context.Context) (err error) {
+		op.Reader, err = s.Store.OpenObjectRange(ctx, op.Name, op.Offset, op.Length)
+		return err
+	})
+	return openedReader(op, err)
+}
+
+// openedReader returns the reader of the open operation `op`, closing it when
+// an interceptor failed the operation after it was opened.
+func openedReader(op *Operation, err error) (io.ReadCloser, error) {
+	if err != nil {
+		if op.Reader != nil {
+			op.Reader.Close()
+		}
+		return nil, err
+	}
+	return op.Reader, nil
+}
+
+func (s *InterceptedStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	op := &Operation{Method: "FileExists", Name: base}
+	err = s.run(ctx, op, func(ctx context.Context) (err error) {
+		exists, err = s.Store.FileExists(ctx, op.Name)
+		return err
+	})
+	return exists, err
+}
+
+func (s *InterceptedStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	op := &Operation{Method: "ObjectAttributes", Name: base}
+	err = s.run(ctx, op, func(ctx context.Context) (err error) {
+		attrs, err = s.Store.ObjectAttributes(ctx, op.Name)
+		return err
+	})
+	return attrs, err
+}
+
+func (s *InterceptedStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	op := &Operation{Method: "WriteObject", Name: base, Content: f, WriteOptions: opts}
+	return s.run(ctx, op, func(ctx context.Context) error {
+		return s.Store.WriteObject(ctx, op.Name, op.Content, op.WriteOptions...)
+	})
+}
+
+func (s *InterceptedStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	op := &Operation{Method: "PushLocalFile", Name: localFile, Destination: toBaseName}
+	return s.run(ctx, op, func(ctx context.Context) error {
+		return s.Store.PushLocalFile(ctx, op.Name, op.Destination)
+	})
+}
+
+func (s *InterceptedStore) CopyObject(ctx context.Context, src, dst string) error {
+	op := &Operation{Method: "CopyObject", Name: src, Destination: dst}
+	return s.run(ctx, op, func(ctx context.Context) error {
+		return s.Store.CopyObject(ctx, op.Name, op.Destination)
+	})
+}
+
+func (s *InterceptedStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	op := &Operation{Method: "RenameObject", Name: oldName, Destination: newName}
+	return s.run(ctx, op, func(ctx context.Context) error {
+		return s.Store.RenameObject(ctx, op.Name, op.Destination)
+	})
+}
+
+func (s *InterceptedStore) DeleteObject(ctx context.Context, base string) error {
+	op := &Operation{Method: "DeleteObject", Name: base}
+	return s.run(ctx, op, func(ctx context.Context) error {
+		return s.Store.DeleteObject(ctx, op.Name)
+	})
+}
+
+func (s *InterceptedStore) DeleteObjects(ctx context.Context, names []string) error {
+	op := &Operation{Method: "DeleteObjects", Names: names}
+	return s.run(ctx, op, func(ctx context.Context) error {
+		return s.Store.DeleteObjects(ctx, op.Names)
+	})
+}
+
+func (s *InterceptedStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	op := &Operation{Method: "DeletePrefix", Name: prefix}
+	err = s.run(ctx, op, func(ctx context.Context) (err error) {
+		deleted, err = s.Store.DeletePrefix(ctx, op.Name)
+		return err
+	})
+	return deleted, err
+}
+
+func (s *InterceptedStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
+	op := &Operation{Method: "WalkFrom", Name: prefix}
+	return s.run(ctx, op, func(ctx context.Context) error {
+		return s.Store.WalkFrom(ctx, op.Name, startingPoint, f)
+	})
+}
+
+func (s *InterceptedStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error {
+	op := &Operation{Method: "WalkBetween", Name: prefix}
+	return s.run(ctx, op, func(ctx context.Context) error {
+		return s.Store.WalkBetween(ctx, op.Name, startingPoint, endPoint, f)
+	})
+}
+
+func (s *InterceptedStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error {
+	op := &Operation{Method: "Walk", Name: prefix}
+	return s.run(ctx, op, func(ctx context.Context) error {
+		return s.Store.Walk(ctx, op.Name, f)
+	})
+}
+
+func (s *InterceptedStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) error {
+	op := &Operation{Method: "WalkObjects", Name: prefix}
+	return s.run(ctx, op, func(ctx context.Context) error {
+		return s.Store.WalkObjects(ctx, op.Name, f)
+	})
+}
+
+func (s *InterceptedStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	op := &Operation{Method: "ListFiles", Name: prefix}
+	err = s.run(ctx, op, func(ctx context.Context) (err error) {
+		files, err = s.Store.ListFiles(ctx, op.Name, max)
+		return err
+	})
+	return files, err
+}
+
+func (s *InterceptedStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	op := &Operation{Method: "ListFilesPage", Name: prefix}
+	err = s.run(ctx, op, func(ctx context.Context) (err error) {
+		files, nextToken, err = s.Store.ListFilesPage(ctx, op.Name, pageSize, pageToken)
+		return err
+	})
+	return files, nextToken, err
+}
+
+func (s *InterceptedStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	op := &Operation{Method: "ListDirectories", Name: prefix}
+	err = s.run(ctx, op, func(ctx context.Context) (err error) {
+		out, err = s.Store.ListDirectories(ctx, op.Name)
+		return err
+	})
+	return out, err
+}
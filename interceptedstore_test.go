@@ -0,0 +1,68 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterceptedStore(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+	errDenied := errors.New("deletes denied")
+
+	var calls []string
+	audit := func(op *Operation, next Handler) Handler {
+		return func(ctx context.Context) error {
+			err := next(ctx)
+			calls = append(calls, op.Method+" "+op.Name)
+			return err
+		}
+	}
+	namespaced := func(op *Operation, next Handler) Handler {
+		op.Name = "tenant/" + op.Name
+		return next
+	}
+	denyDeletes := func(op *Operation, next Handler) Handler {
+		if strings.HasPrefix(op.Method, "Delete") {
+			return func(ctx context.Context) error { return errDenied }
+		}
+		return next
+	}
+	store := NewInterceptedStore(inner, audit, namespaced, denyDeletes)
+
+	require.NoError(t, store.WriteObject(ctx, "file", strings.NewReader("content")))
+	exists, err := inner.FileExists(ctx, "tenant/file")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	reader, err := store.OpenObject(ctx, "file")
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, "content", string(content))
+
+	assert.Equal(t, errDenied, store.DeleteObject(ctx, "file"))
+	_, err = store.OpenObject(ctx, "missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+	files, err := sub.ListFiles(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	assert.Equal(t, []string{
+		"WriteObject tenant/file",
+		"OpenObject tenant/file",
+		"DeleteObject tenant/file",
+		"OpenObject tenant/missing",
+		"ListFiles tenant/",
+	}, calls)
+}
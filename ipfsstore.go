@@ -0,0 +1,741 @@
+//go:build !dstore_no_ipfs
+
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//
+// IPFS Store
+//
+
+func init() {
+	registerBackend(&backend{
+		schemes: []string{"ipfs"},
+		newStore: func(base *url.URL, opts ...Option) (Store, error) {
+			store, err := NewIPFSStoreWithOptions(base, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		},
+	})
+}
+
+// defaultIPFSAPIURL is the RPC API address of a local IPFS (kubo) node.
+const defaultIPFSAPIURL = "http://127.0.0.1:5001"
+
+type IPFSStore struct {
+	baseURL *url.URL
+	api     string
+	gateway string
+	client  *http.Client
+
+	// basePath is an `/ipfs/<cid>/path` path for stores rooted at a CID,
+	// which are read-only, and a path of the node's Mutable File System
+	// (MFS) otherwise.
+	basePath  string
+	immutable bool
+	pin       bool
+	*commonStore
+}
+
+func NewIPFSStore(baseURL *url.URL, extension, compressionType string, overwrite bool) (*IPFSStore, error) {
+	return NewIPFSStoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+// NewIPFSStoreWithOptions creates a store talking to the RPC API of the IPFS
+// node at `IPFS_API_URL` (defaults to `http://127.0.0.1:5001`).
+//
+// With a CID as host, as in `ipfs://bafybei.../path`, the store reads the
+// immutable directory of that CID and refuses writes. Without host, as in
+// `ipfs:///path`, the store lives in the node's Mutable File System: objects
+// are added and pinned, unless `?pin=false` is given, then linked at their
+// path so they can be walked like in any other store.
+//
+// When `IPFS_GATEWAY_URL` is set, `PresignGet` returns the gateway URL of the
+// object's CID.
+func NewIPFSStoreWithOptions(baseURL *url.URL, opts ...Option) (*IPFSStore, error) {
+	api := os.Getenv("IPFS_API_URL")
+	if api == "" {
+		api = defaultIPFSAPIURL
+	}
+
+	return newIPFSStore(baseURL, api, http.DefaultClient, newConfig(opts))
+}
+
+func newIPFSStore(baseURL *url.URL, api string, client *http.Client, config *config) (*IPFSStore, error) {
+	pin := true
+	if value := baseURL.Query().Get("pin"); value != "" {
+		var err error
+		if pin, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid pin value %q: %w", value, err)
+		}
+	}
+
+	basePath := path.Clean("/" + baseURL.Path)
+	if baseURL.Host != "" {
+		basePath = path.Join("/ipfs", baseURL.Host, basePath)
+	}
+
+	return &IPFSStore{
+		baseURL:     baseURL,
+		api:         strings.TrimRight(api, "/") + "/api/v0/",
+		gateway:     strings.TrimRight(os.Getenv("IPFS_GATEWAY_URL"), "/"),
+		client:      client,
+		basePath:    basePath,
+		immutable:   baseURL.Host != "",
+		pin:         pin,
+		commonStore: newCommonStore(baseURL, config),
+	}, nil
+}
+
+// SubStore shares the HTTP client of the parent store.
+func (s *IPFSStore) SubStore(subFolder string) (Store, error) {
+	url, err := url.Parse(s.baseURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("ipfs store parsing base url: %w", err)
+	}
+	url.Path = path.Join(url.Path, subFolder)
+	return newIPFSStore(url, strings.TrimSuffix(s.api, "/api/v0/"), s.client, newConfig(s.options()))
+}
+
+func (s *IPFSStore) BaseURL() *url.URL {
+	return s.baseURL
+}
+
+func (s *IPFSStore) ObjectPath(name string) string {
+	return path.Join(s.basePath, s.pathWithExt(name))
+}
+
+func (s *IPFSStore) ObjectURL(name string) string {
+	u := *s.baseURL
+	u.RawQuery = ""
+	return fmt.Sprintf("%s/%s", strings.TrimRight(u.String(), "/"), strings.TrimLeft(s.pathWithExt(name), "/"))
+}
+
+// PresignGet returns the gateway URL of the object's content, which never
+// expires since the content behind a CID never changes.
+func (s *IPFSStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	if s.gateway == "" {
+		return "", fmt.Errorf("ipfs store presign requires IPFS_GATEWAY_URL: %w", ErrNotSupported)
+	}
+
+	stat, err := s.stat(ctx, s.ObjectPath(base))
+	if err != nil {
+		return "", err
+	}
+	return s.gateway + "/ipfs/" + stat.Hash, nil
+}
+
+func (s *IPFSStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return "", fmt.Errorf("ipfs store presign: %w", ErrNotSupported)
+}
+
+func (s *IPFSStore) toBaseName(filePath string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(filePath, s.pathWithExt("")), strings.TrimSuffix(s.basePath, "/")+"/")
+}
+
+func (s *IPFSStore) checkWritable() error {
+	if s.immutable {
+		return fmt.Errorf("ipfs store %q is immutable, use ipfs:///path to write in the node's MFS: %w", s.basePath, ErrNotSupported)
+	}
+	return nil
+}
+
+type ipfsError struct {
+	Message string
+	Code    int
+}
+
+func (e *ipfsError) Error() string {
+	return "ipfs: " + e.Message
+}
+
+// alreadyExists reports whether the node refused to link an entry because
+// another one already exists at that path.
+func (e *ipfsError) alreadyExists() bool {
+	return strings.Contains(e.Message, "already has entry")
+}
+
+// call runs the RPC API `command` with the `args` and `params` query
+// parameters. Errors replied by the node are returned as `*ipfsError`, or as
+// `ErrNotFound` when they are about a missing path.
+func (s *IPFSStore) call(ctx context.Context, command string, args []string, params url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+	query["arg"] = args
+
+	req, err := http.NewRequest(http.MethodPost, s.api+command+"?"+query.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(resp.Body)
+	ipfsErr := &ipfsError{}
+	if err := json.Unmarshal(data, ipfsErr); err != nil || ipfsErr.Message == "" {
+		return nil, newStatusError(resp.StatusCode, fmt.Errorf("ipfs %s: %s: %s", command, resp.Status, strings.TrimSpace(string(data))))
+	}
+
+	switch {
+	case strings.Contains(ipfsErr.Message, "does not exist"),
+		strings.Contains(ipfsErr.Message, "no link named"),
+		strings.Contains(ipfsErr.Message, "not found"):
+		return nil, ErrNotFound
+	}
+	return nil, ipfsErr
+}
+
+func (s *IPFSStore) callJSON(ctx context.Context, command string, args []string, params url.Values, out interface{}) error {
+	resp, err := s.call(ctx, command, args, params, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding ipfs %s response: %w", command, err)
+	}
+	return nil
+}
+
+// upload streams the content written by `write` as the single file of a
+// multipart request, the form the node expects for `add` and `files/write`,
+// and hands the reply to `read`. The node replies while still reading the
+// content, so the request is only closed once the reply is read.
+func (s *IPFSStore) upload(ctx context.Context, command string, args []string, params url.Values, name string, write func(w io.Writer) error, read func(r io.Reader) error) error {
+	pipeRead, pipeWrite := io.Pipe()
+	form := multipart.NewWriter(pipeWrite)
+
+	writeDone := make(chan error, 1)
+	go func() {
+		err := func() error {
+			part, err := form.CreateFormFile("file", name)
+			if err != nil {
+				return err
+			}
+			if err := write(part); err != nil {
+				return err
+			}
+			return form.Close()
+		}()
+		pipeWrite.CloseWithError(err)
+		writeDone <- err
+	}()
+
+	resp, err := s.call(ctx, command, args, params, pipeRead, form.FormDataContentType())
+	if err == nil {
+		err = read(resp.Body)
+		resp.Body.Close()
+	}
+	pipeRead.Close()
+	if copyErr := <-writeDone; copyErr != nil && copyErr != io.ErrClosedPipe {
+		return copyErr
+	}
+	return err
+}
+
+// add adds the content written by `write` to the node, pinning it when
+// configured to, and returns its CID.
+func (s *IPFSStore) add(ctx context.Context, name string, write func(w io.Writer) error) (cid string, err error) {
+	params := url.Values{
+		"pin":         {strconv.FormatBool(s.pin)},
+		"cid-version": {"1"},
+		"quieter":     {"true"},
+	}
+
+	err = s.upload(ctx, "add", nil, params, name, write, func(r io.Reader) error {
+		// The node streams one JSON object per added entry
+		decoder := json.NewDecoder(r)
+		for {
+			var added struct{ Hash string }
+			if err := decoder.Decode(&added); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return fmt.Errorf("decoding ipfs add response: %w", err)
+			}
+			cid = added.Hash
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if cid == "" {
+		return "", fmt.Errorf("ipfs add returned no CID")
+	}
+	return cid, nil
+}
+
+func (s *IPFSStore) mkdir(ctx context.Context, dir string) error {
+	if dir == "/" || dir == "." {
+		return nil
+	}
+	return s.callJSON(ctx, "files/mkdir", []string{dir}, url.Values{"parents": {"true"}}, nil)
+}
+
+// link puts the entry at `srcPath`, either an MFS path or an `/ipfs/<cid>`
+// path, at `destPath` in the MFS. It returns `false` when an entry already
+// exists at `destPath` and overwrites are disabled.
+func (s *IPFSStore) link(ctx context.Context, srcPath, destPath string) (bool, error) {
+	if err := s.mkdir(ctx, path.Dir(destPath)); err != nil {
+		return false, fmt.Errorf("creating parent directory: %w", err)
+	}
+
+	if s.overwrite {
+		if err := s.callJSON(ctx, "files/rm", []string{destPath}, nil, nil); err != nil && err != ErrNotFound {
+			return false, fmt.Errorf("removing previous entry: %w", err)
+		}
+	}
+
+	if err := s.callJSON(ctx, "files/cp", []string{srcPath, destPath}, nil, nil); err != nil {
+		var ipfsErr *ipfsError
+		if !s.overwrite && errors.As(err, &ipfsErr) && ipfsErr.alreadyExists() {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *IPFSStore) WriteObject(ctx context.Context, base string, reader io.Reader, opts ...WriteOption) (err error) {
+	defer classifyError(&err)
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+
+	destPath := s.ObjectPath(base)
+	config := newWriteConfig(opts)
+	reader = config.trackProgress(reader)
+	if config.acl != "" {
+		return fmt.Errorf("object ACL: %w", ErrNotSupported)
+	}
+
+	if !s.overwrite {
+		exists, err := s.FileExists(ctx, base)
+		if err != nil {
+			return err
+		}
+		if exists {
+			// We silently ignore when we ask not to overwrite
+			return nil
+		}
+	}
+
+	cid, err := s.add(ctx, path.Base(destPath), func(w io.Writer) error {
+		return s.compressedCopy(ctx, reader, w)
+	})
+	if err != nil {
+		return fmt.Errorf("adding content: %w", err)
+	}
+
+	linked, err := s.link(ctx, "/ipfs/"+cid, destPath)
+	if err != nil || !linked {
+		// Not linked when another writer created the object since our check
+		return err
+	}
+
+	return s.sidecarFiles(ctx).writeMetadata(destPath, config.metadata)
+}
+
+// sidecarFiles returns the primitives of the metadata sidecar files, in the
+// MFS.
+func (s *IPFSStore) sidecarFiles(ctx context.Context) sidecarFiles {
+	return sidecarFiles{
+		open: func(path string) (io.ReadCloser, error) {
+			return s.read(ctx, path, 0, -1)
+		},
+		write: func(metadataPath string, data []byte) error {
+			params := url.Values{"create": {"true"}, "truncate": {"true"}, "parents": {"true"}}
+			write := func(w io.Writer) error {
+				_, err := w.Write(data)
+				return err
+			}
+			discard := func(r io.Reader) error {
+				_, err := io.Copy(ioutil.Discard, r)
+				return err
+			}
+			return s.upload(ctx, "files/write", []string{metadataPath}, params, path.Base(metadataPath), write, discard)
+		},
+		remove: func(path string) error {
+			return s.callJSON(ctx, "files/rm", []string{path}, nil, nil)
+		},
+		notFound: func(err error) bool {
+			return err == ErrNotFound
+		},
+	}
+}
+
+// transfer copies or moves the object along with its metadata, copies only
+// linking the existing content at the new path.
+func (s *IPFSStore) transfer(ctx context.Context, move bool, src, dst string) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+
+	srcPath, dstPath := s.ObjectPath(src), s.ObjectPath(dst)
+	if _, err := s.stat(ctx, srcPath); err != nil {
+		return err
+	}
+
+	metadata, err := s.sidecarFiles(ctx).readMetadata(srcPath)
+	if err != nil {
+		return fmt.Errorf("reading metadata: %w", err)
+	}
+
+	if _, err := s.link(ctx, srcPath, dstPath); err != nil {
+		return err
+	}
+	if err := s.sidecarFiles(ctx).writeMetadata(dstPath, metadata); err != nil {
+		return err
+	}
+
+	if move {
+		return s.DeleteObject(ctx, src)
+	}
+	return nil
+}
+
+func (s *IPFSStore) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, s, dst); skip || err != nil {
+		return err
+	}
+	return s.transfer(ctx, false, src, dst)
+}
+
+func (s *IPFSStore) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, s, newName); skip || err != nil {
+		if err != nil {
+			return err
+		}
+		return s.DeleteObject(ctx, oldName)
+	}
+	return s.transfer(ctx, true, oldName, newName)
+}
+
+// read reads `length` bytes of the file at `objectPath` from `offset`, or up
+// to the end of the file when `length` is negative.
+func (s *IPFSStore) read(ctx context.Context, objectPath string, offset, length int64) (io.ReadCloser, error) {
+	command, lengthParam := "files/read", "count"
+	if s.immutable {
+		command, lengthParam = "cat", "length"
+	}
+
+	params := url.Values{"offset": {strconv.FormatInt(offset, 10)}}
+	if length >= 0 {
+		params.Set(lengthParam, strconv.FormatInt(length, 10))
+	}
+
+	resp, err := s.call(ctx, command, []string{objectPath}, params, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *IPFSStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(name)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file", zap.String("path", path))
+	}
+
+	reader, err := s.read(ctx, path, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.uncompressedReader(ctx, s.resumeReads(ctx, name, reader, s.openStoredRange))
+}
+
+func (s *IPFSStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	if s.decompressesReads() {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+	reader, err := s.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.throttleReads(ctx, reader), nil
+}
+
+func (s *IPFSStore) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	objectPath := s.ObjectPath(name)
+	stat, err := s.stat(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= stat.Size {
+		// The node refuses offsets past the end of the file
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	return s.read(ctx, objectPath, offset, length)
+}
+
+type ipfsStat struct {
+	Hash string
+	Size int64
+	Type string
+}
+
+// stat returns the stat of the file at `objectPath`, `ErrNotFound` when
+// there is no file there.
+func (s *IPFSStore) stat(ctx context.Context, objectPath string) (*ipfsStat, error) {
+	stat := &ipfsStat{}
+	if err := s.callJSON(ctx, "files/stat", []string{objectPath}, nil, stat); err != nil {
+		return nil, err
+	}
+	if stat.Type != "file" {
+		return nil, ErrNotFound
+	}
+	return stat, nil
+}
+
+func (s *IPFSStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer classifyError(&err)
+	if _, err := s.stat(ctx, s.ObjectPath(base)); err != nil {
+		if err == ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *IPFSStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+
+	stat, err := s.stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.newObjectAttrs(ctx, base, &ipfsEntry{path: path, hash: stat.Hash, size: stat.Size})
+}
+
+// newObjectAttrs turns the entry into attributes. The CID of the content
+// serves as ETag and, IPFS not tracking modification times, `LastModified`
+// is left zero.
+func (s *IPFSStore) newObjectAttrs(ctx context.Context, name string, entry *ipfsEntry) (*ObjectAttrs, error) {
+	metadata, err := s.sidecarFiles(ctx).readMetadata(entry.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+
+	return &ObjectAttrs{
+		Name:     name,
+		Size:     entry.size,
+		ETag:     entry.hash,
+		Metadata: metadata,
+	}, nil
+}
+
+type ipfsEntry struct {
+	path      string
+	directory bool
+	hash      string
+	size      int64
+}
+
+// list lists the entries of the directory `dir` in name order, through
+// `files/ls` in the MFS and `ls` under a CID.
+func (s *IPFSStore) list(ctx context.Context, dir string) (out []*ipfsEntry, err error) {
+	type link struct {
+		Name string
+		Hash string
+		Size int64
+		Type int
+	}
+
+	var links []link
+	if s.immutable {
+		var reply struct{ Objects []struct{ Links []link } }
+		if err := s.callJSON(ctx, "ls", []string{dir}, url.Values{"resolve-type": {"true"}, "size": {"true"}}, &reply); err != nil {
+			return nil, err
+		}
+		for _, object := range reply.Objects {
+			links = append(links, object.Links...)
+		}
+	} else {
+		var reply struct{ Entries []link }
+		if err := s.callJSON(ctx, "files/ls", []string{dir}, url.Values{"long": {"true"}}, &reply); err != nil {
+			return nil, err
+		}
+		links = reply.Entries
+	}
+
+	for _, link := range links {
+		entry := &ipfsEntry{path: path.Join(dir, link.Name), hash: link.Hash, size: link.Size}
+		if s.immutable {
+			// UnixFS types, 1 being a directory and 5 a sharded directory
+			entry.directory = link.Type == 1 || link.Type == 5
+		} else {
+			entry.directory = link.Type == 1
+		}
+		out = append(out, entry)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].path < out[j].path })
+	return out, nil
+}
+
+func (s *IPFSStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer classifyError(&err)
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+func (s *IPFSStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer classifyError(&err)
+	return listFiles(ctx, s, prefix, max)
+}
+
+func (s *IPFSStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer classifyError(&err)
+	return listFilesPage(ctx, s, prefix, pageSize, pageToken)
+}
+
+func (s *IPFSStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer classifyError(&err)
+	prefix = strings.Trim(prefix, "/")
+
+	entries, err := s.list(ctx, path.Join(s.basePath, prefix))
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.directory {
+			out = append(out, path.Join(prefix, path.Base(entry.path)))
+		}
+	}
+	return out, nil
+}
+
+func (s *IPFSStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
+}
+
+func (s *IPFSStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkBetween(s, ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *IPFSStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *IPFSStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkDirectories(ctx, s.basePath, prefix, func(dir string) ([]*dirEntry, error) {
+		entries, err := s.list(ctx, dir)
+		if err != nil {
+			if err == ErrNotFound {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		out := make([]*dirEntry, len(entries))
+		for i, entry := range entries {
+			entry := entry
+			out[i] = &dirEntry{path: entry.path, dir: entry.directory, attrs: func(name string) (*ObjectAttrs, error) {
+				return s.newObjectAttrs(ctx, name, entry)
+			}}
+		}
+		return out, nil
+	}, f)
+}
+
+// DeleteObject unlinks the object from the MFS, its content staying pinned
+// on the node when it was added with pinning.
+func (s *IPFSStore) DeleteObject(ctx context.Context, base string) (err error) {
+	defer classifyError(&err)
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+
+	path := s.ObjectPath(base)
+	if err := s.callJSON(ctx, "files/rm", []string{path + localMetadataSuffix}, nil, nil); err != nil && err != ErrNotFound {
+		return fmt.Errorf("removing metadata: %w", err)
+	}
+	return s.callJSON(ctx, "files/rm", []string{path}, nil, nil)
+}
+
+func (s *IPFSStore) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer classifyError(&err)
+	return deleteObjectsConcurrently(ctx, names, deleteObjectsConcurrency, func(ctx context.Context, name string) error {
+		if err := s.DeleteObject(ctx, name); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *IPFSStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer classifyError(&err)
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+	return deletePrefix(ctx, s, prefix, deletePrefixConcurrency)
+}
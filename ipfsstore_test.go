@@ -0,0 +1,424 @@
+//go:build !dstore_no_ipfs
+
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIPFS is an in-memory IPFS node implementing the subset of the RPC API
+// used by the store. Directories added under `/ipfs/` are flat maps of their
+// files' relative paths to CIDs.
+type fakeIPFS struct {
+	*httptest.Server
+
+	lock    sync.Mutex
+	blocks  map[string][]byte
+	roots   map[string]map[string]string
+	mfs     map[string]string
+	mfsDirs map[string]bool
+	pinned  map[string]bool
+}
+
+func newFakeIPFS(t *testing.T) *fakeIPFS {
+	f := &fakeIPFS{
+		blocks:  map[string][]byte{},
+		roots:   map[string]map[string]string{},
+		mfs:     map[string]string{},
+		mfsDirs: map[string]bool{"/": true},
+		pinned:  map[string]bool{},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeIPFS) put(content []byte) string {
+	cid := fmt.Sprintf("bafk%x", sha256.Sum256(content))[:20]
+	f.blocks[cid] = content
+	return cid
+}
+
+func (f *fakeIPFS) fail(w http.ResponseWriter, message string) {
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]interface{}{"Message": message, "Code": 0, "Type": "error"})
+}
+
+// resolve returns the CID of the file at `p`, or whether it is a directory.
+func (f *fakeIPFS) resolve(p string) (cid string, dir bool, found bool) {
+	if strings.HasPrefix(p, "/ipfs/") {
+		parts := strings.SplitN(strings.TrimPrefix(p, "/ipfs/"), "/", 2)
+		if len(parts) == 1 {
+			if _, ok := f.roots[parts[0]]; ok {
+				return "", true, true
+			}
+			_, ok := f.blocks[parts[0]]
+			return parts[0], false, ok
+		}
+		for name, cid := range f.roots[parts[0]] {
+			if name == parts[1] {
+				return cid, false, true
+			}
+			if strings.HasPrefix(name, parts[1]+"/") {
+				dir = true
+			}
+		}
+		return "", dir, dir
+	}
+
+	if cid, ok := f.mfs[p]; ok {
+		return cid, false, true
+	}
+	return "", f.mfsDirs[p], f.mfsDirs[p]
+}
+
+type fakeIPFSLink struct {
+	Name string
+	Hash string
+	Size int64
+	Type int
+}
+
+func (f *fakeIPFS) children(dir string) (out []fakeIPFSLink) {
+	seen := map[string]bool{}
+	add := func(full, cid string) {
+		if !strings.HasPrefix(full, strings.TrimSuffix(dir, "/")+"/") {
+			return
+		}
+		rest := strings.TrimPrefix(full, strings.TrimSuffix(dir, "/")+"/")
+		name := strings.SplitN(rest, "/", 2)[0]
+		if seen[name] || name == "" {
+			return
+		}
+		seen[name] = true
+
+		if name != rest || cid == "" {
+			out = append(out, fakeIPFSLink{Name: name, Type: 1})
+			return
+		}
+		out = append(out, fakeIPFSLink{Name: name, Hash: cid, Size: int64(len(f.blocks[cid])), Type: 2})
+	}
+
+	if strings.HasPrefix(dir, "/ipfs/") {
+		root := strings.SplitN(strings.TrimPrefix(dir, "/ipfs/"), "/", 2)[0]
+		for name, cid := range f.roots[root] {
+			add("/ipfs/"+root+"/"+name, cid)
+		}
+	} else {
+		for p, cid := range f.mfs {
+			add(p, cid)
+		}
+		for p := range f.mfsDirs {
+			add(p, "")
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name > out[j].Name })
+	return out
+}
+
+func (f *fakeIPFS) readForm(r *http.Request) ([]byte, error) {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+func (f *fakeIPFS) serve(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	args := query["arg"]
+	reply := json.NewEncoder(w)
+
+	switch strings.TrimPrefix(r.URL.Path, "/api/v0/") {
+	case "add":
+		content, err := f.readForm(r)
+		if err != nil {
+			f.fail(w, err.Error())
+			return
+		}
+		cid := f.put(content)
+		if query.Get("pin") == "true" {
+			f.pinned[cid] = true
+		}
+		reply.Encode(map[string]interface{}{"Name": cid, "Hash": cid, "Size": strconv.Itoa(len(content))})
+
+	case "files/write":
+		content, err := f.readForm(r)
+		if err != nil {
+			f.fail(w, err.Error())
+			return
+		}
+		f.mfs[args[0]] = f.put(content)
+
+	case "files/mkdir":
+		for dir := args[0]; dir != "/"; dir = path.Dir(dir) {
+			f.mfsDirs[dir] = true
+		}
+
+	case "files/cp":
+		cid, dir, found := f.resolve(args[0])
+		if !found || dir {
+			f.fail(w, "cp: cannot get node from path "+args[0]+": file does not exist")
+			return
+		}
+		if _, _, exists := f.resolve(args[1]); exists {
+			f.fail(w, "cp: cannot put node in path "+args[1]+": directory already has entry by that name")
+			return
+		}
+		f.mfs[args[1]] = cid
+
+	case "files/rm":
+		if _, ok := f.mfs[args[0]]; !ok {
+			f.fail(w, args[0]+": file does not exist")
+			return
+		}
+		delete(f.mfs, args[0])
+
+	case "files/stat":
+		cid, dir, found := f.resolve(args[0])
+		switch {
+		case !found:
+			f.fail(w, args[0]+": file does not exist")
+		case dir:
+			reply.Encode(map[string]interface{}{"Hash": "bafydir", "Type": "directory"})
+		default:
+			reply.Encode(map[string]interface{}{"Hash": cid, "Size": len(f.blocks[cid]), "Type": "file"})
+		}
+
+	case "files/read", "cat":
+		if strings.HasPrefix(args[0], "/ipfs/") != (r.URL.Path == "/api/v0/cat") {
+			f.fail(w, "unexpected path "+args[0])
+			return
+		}
+		cid, dir, found := f.resolve(args[0])
+		if !found || dir {
+			f.fail(w, args[0]+": file does not exist")
+			return
+		}
+
+		content := f.blocks[cid]
+		offset, _ := strconv.Atoi(query.Get("offset"))
+		if offset > len(content) {
+			f.fail(w, "offset was past end of file")
+			return
+		}
+		content = content[offset:]
+		lengthParam := "count"
+		if r.URL.Path == "/api/v0/cat" {
+			lengthParam = "length"
+		}
+		if length, err := strconv.Atoi(query.Get(lengthParam)); err == nil && length < len(content) {
+			content = content[:length]
+		}
+		w.Write(content)
+
+	case "files/ls":
+		if _, dir, _ := f.resolve(args[0]); !dir || strings.HasPrefix(args[0], "/ipfs/") {
+			f.fail(w, args[0]+": file does not exist")
+			return
+		}
+		entries := f.children(args[0])
+		for i := range entries {
+			if entries[i].Type == 2 {
+				entries[i].Type = 0
+			}
+		}
+		reply.Encode(map[string]interface{}{"Entries": entries})
+
+	case "ls":
+		if _, dir, _ := f.resolve(args[0]); !dir {
+			f.fail(w, "no link named "+path.Base(args[0]))
+			return
+		}
+		reply.Encode(map[string]interface{}{"Objects": []interface{}{map[string]interface{}{"Hash": "bafydir", "Links": f.children(args[0])}}})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "404 page not found")
+	}
+}
+
+func newTestIPFSStore(t *testing.T, fake *fakeIPFS, rawURL string, opts ...Option) *IPFSStore {
+	base, err := url.Parse(rawURL)
+	require.NoError(t, err)
+
+	store, err := newIPFSStore(base, fake.URL, fake.Client(), newConfig(opts))
+	require.NoError(t, err)
+	return store
+}
+
+func TestIPFSStore_WriteObject(t *testing.T) {
+	fake := newFakeIPFS(t)
+	store := newTestIPFSStore(t, fake, "ipfs:///archives")
+	ctx := context.Background()
+
+	require.NoError(t, store.WriteObject(ctx, "dir/0001", bytes.NewReader([]byte("content")), WithMetadata(map[string]string{"Block_Range": "1-2"})))
+	content, err := ReadObject(ctx, store, "dir/0001")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	cid := fake.mfs["/archives/dir/0001"]
+	assert.True(t, fake.pinned[cid], "added content should be pinned")
+
+	reader, err := store.OpenObjectRange(ctx, "dir/0001", 2, 3)
+	require.NoError(t, err)
+	content, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "nte", string(content))
+
+	reader, err = store.OpenObjectRange(ctx, "dir/0001", 10, -1)
+	require.NoError(t, err)
+	content, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, content)
+
+	attrs, err := store.ObjectAttributes(ctx, "dir/0001")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), attrs.Size)
+	assert.Equal(t, cid, attrs.ETag)
+	assert.Equal(t, map[string]string{"block_range": "1-2"}, attrs.Metadata)
+
+	// Without overwrite, the existing object is kept
+	require.NoError(t, store.WriteObject(ctx, "dir/0001", bytes.NewReader([]byte("other"))))
+	content, err = ReadObject(ctx, store, "dir/0001")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	_, err = store.OpenObject(ctx, "dir/0002")
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = store.ObjectAttributes(ctx, "dir")
+	assert.Equal(t, ErrNotFound, err, "directories are not objects")
+}
+
+func TestIPFSStore_WriteObject_Unpinned(t *testing.T) {
+	fake := newFakeIPFS(t)
+	store := newTestIPFSStore(t, fake, "ipfs:///archives?pin=false", AllowOverwrite())
+	ctx := context.Background()
+
+	require.NoError(t, store.WriteObject(ctx, "0001", bytes.NewReader([]byte("content"))))
+	require.NoError(t, store.WriteObject(ctx, "0001", bytes.NewReader([]byte("overwritten"))))
+	content, err := ReadObject(ctx, store, "0001")
+	require.NoError(t, err)
+	assert.Equal(t, "overwritten", string(content))
+	assert.Empty(t, fake.pinned)
+	assert.Equal(t, "ipfs:///archives/0001", store.ObjectURL("0001"))
+}
+
+func TestIPFSStore_Walk(t *testing.T) {
+	fake := newFakeIPFS(t)
+	store := newTestIPFSStore(t, fake, "ipfs:///archives")
+	ctx := context.Background()
+
+	for _, name := range []string{"0000/0002", "0000/0001", "0001/0001", "0001.txt", "01"} {
+		require.NoError(t, store.WriteObject(ctx, name, bytes.NewReader([]byte(name)), WithMetadata(map[string]string{"name": name})))
+	}
+
+	var files []string
+	require.NoError(t, store.Walk(ctx, "", func(filename string) error {
+		files = append(files, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{"0000/0001", "0000/0002", "0001.txt", "0001/0001", "01"}, files)
+
+	files = nil
+	require.NoError(t, store.WalkFrom(ctx, "0", "0000/0002", func(filename string) error {
+		files = append(files, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{"0000/0002", "0001.txt", "0001/0001", "01"}, files)
+
+	dirs, err := store.ListDirectories(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0000", "0001"}, dirs)
+
+	require.NoError(t, store.RenameObject(ctx, "0001.txt", "0002/0001"))
+	require.NoError(t, store.CopyObject(ctx, "01", "0002/0000"))
+	attrs, err := store.ObjectAttributes(ctx, "0002/0001")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "0001.txt"}, attrs.Metadata)
+
+	deleted, err := store.DeletePrefix(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, 6, deleted)
+	assert.Empty(t, fake.mfs)
+}
+
+func TestIPFSStore_Immutable(t *testing.T) {
+	fake := newFakeIPFS(t)
+	fake.roots["bafyroot"] = map[string]string{
+		"path/0001":     fake.put([]byte("first")),
+		"path/sub/0002": fake.put([]byte("second")),
+		"other/0003":    fake.put([]byte("third")),
+	}
+
+	store := newTestIPFSStore(t, fake, "ipfs://bafyroot/path")
+	ctx := context.Background()
+
+	content, err := ReadObject(ctx, store, "sub/0002")
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(content))
+
+	var files []string
+	require.NoError(t, store.Walk(ctx, "", func(filename string) error {
+		files = append(files, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{"0001", "sub/0002"}, files)
+
+	exists, err := store.FileExists(ctx, "0003")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	err = store.WriteObject(ctx, "0004", bytes.NewReader([]byte("fourth")))
+	assert.ErrorIs(t, err, ErrNotSupported)
+	assert.ErrorIs(t, store.DeleteObject(ctx, "0001"), ErrNotSupported)
+	assert.Equal(t, "ipfs://bafyroot/path/0001", store.ObjectURL("0001"))
+}
+
+func TestIPFSStore_PresignGet(t *testing.T) {
+	fake := newFakeIPFS(t)
+	ctx := context.Background()
+
+	store := newTestIPFSStore(t, fake, "ipfs:///archives")
+	require.NoError(t, store.WriteObject(ctx, "0001", bytes.NewReader([]byte("content"))))
+	_, err := store.PresignGet(ctx, "0001", time.Hour)
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	os.Setenv("IPFS_GATEWAY_URL", "https://gateway.example.com/")
+	defer os.Unsetenv("IPFS_GATEWAY_URL")
+
+	store = newTestIPFSStore(t, fake, "ipfs:///archives")
+	signed, err := store.PresignGet(ctx, "0001", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "https://gateway.example.com/ipfs/"+fake.mfs["/archives/0001"], signed)
+}
@@ -0,0 +1,304 @@
+package dstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+//
+// Key Mapped Store
+//
+
+// KeyMapping maps the object names of a `KeyMappedStore` to the names of the
+// objects in its inner store, and back.
+type KeyMapping interface {
+	// ToInner returns the inner name of the object `name`.
+	ToInner(name string) string
+	// FromInner returns the name of the object with the inner name
+	// `innerName`, `ok` is false for inner objects that are not mapped, which
+	// are ignored when walking.
+	FromInner(innerName string) (name string, ok bool)
+	// InnerPrefix returns the inner prefix under which the objects whose name
+	// starts with `prefix` are stored, `ordered` reporting whether their inner
+	// names are in the same order as their names. Walks of unordered mappings
+	// list every object under the inner prefix and sort them in memory.
+	InnerPrefix(prefix string) (innerPrefix string, ordered bool)
+}
+
+type prefixKeyMapping struct {
+	prefix string
+}
+
+// PrefixKeyMapping stores objects under `prefix` in the inner store.
+func PrefixKeyMapping(prefix string) KeyMapping {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &prefixKeyMapping{prefix: prefix}
+}
+
+func (m *prefixKeyMapping) ToInner(name string) string {
+	return m.prefix + name
+}
+
+func (m *prefixKeyMapping) FromInner(innerName string) (string, bool) {
+	if !strings.HasPrefix(innerName, m.prefix) {
+		return "", false
+	}
+	return innerName[len(m.prefix):], true
+}
+
+func (m *prefixKeyMapping) InnerPrefix(prefix string) (string, bool) {
+	return m.prefix + prefix, true
+}
+
+type hashFanOutKeyMapping struct {
+	levels int
+	width  int
+}
+
+// HashFanOutKeyMapping stores objects under `levels` directories named after
+// the first characters of the hex SHA-256 of their name, `width` characters
+// per level, so `HashFanOutKeyMapping(2, 2)` stores `0000000100` as
+// `ab/cd/0000000100` where the hash starts with `abcd`. It spreads objects
+// with sequential names across the key space of the backend, avoiding
+// hotspots, at the cost of walks listing every object.
+func HashFanOutKeyMapping(levels, width int) KeyMapping {
+	return &hashFanOutKeyMapping{levels: levels, width: width}
+}
+
+func (m *hashFanOutKeyMapping) ToInner(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])
+
+	var b strings.Builder
+	for level := 0; level < m.levels; level++ {
+		b.WriteString(hash[level*m.width : (level+1)*m.width])
+		b.WriteByte('/')
+	}
+	b.WriteString(name)
+	return b.String()
+}
+
+func (m *hashFanOutKeyMapping) FromInner(innerName string) (string, bool) {
+	parts := strings.SplitN(innerName, "/", m.levels+1)
+	if len(parts) != m.levels+1 {
+		return "", false
+	}
+
+	name := parts[m.levels]
+	return name, m.ToInner(name) == innerName
+}
+
+func (m *hashFanOutKeyMapping) InnerPrefix(prefix string) (string, bool) {
+	return "", false
+}
+
+type dateKeyMapping struct {
+	layout string
+	dateOf func(name string) time.Time
+}
+
+// DateKeyMapping stores objects under the date returned by `dateOf` for their
+// name, formatted in UTC with `layout`, like `2006/01/02` for daily
+// partitions, so that lifecycle rules can target old partitions.
+func DateKeyMapping(layout string, dateOf func(name string) time.Time) KeyMapping {
+	return &dateKeyMapping{layout: strings.Trim(layout, "/"), dateOf: dateOf}
+}
+
+func (m *dateKeyMapping) ToInner(name string) string {
+	return m.dateOf(name).UTC().Format(m.layout) + "/" + name
+}
+
+func (m *dateKeyMapping) FromInner(innerName string) (string, bool) {
+	segments := strings.Count(m.layout, "/") + 1
+	parts := strings.SplitN(innerName, "/", segments+1)
+	if len(parts) != segments+1 {
+		return "", false
+	}
+
+	name := parts[segments]
+	return name, m.ToInner(name) == innerName
+}
+
+func (m *dateKeyMapping) InnerPrefix(prefix string) (string, bool) {
+	return "", false
+}
+
+// KeyMappedStore is a `Store` storing its objects in the inner store under
+// names transformed by a `KeyMapping`, walks and listings reversing the
+// mapping so that it's transparent to the callers.
+type KeyMappedStore struct {
+	// Store is the inner store, holding the objects under their mapped names.
+	Store
+
+	mapping KeyMapping
+}
+
+func NewKeyMappedStore(inner Store, mapping KeyMapping) *KeyMappedStore {
+	return &KeyMappedStore{Store: inner, mapping: mapping}
+}
+
+// SubStore returns a store prefixing names with `subFolder` before mapping
+// them, so objects of the sub store are mapped like the ones written through
+// this store.
+func (s *KeyMappedStore) SubStore(subFolder string) (Store, error) {
+	return NewKeyMappedStore(s, PrefixKeyMapping(subFolder)), nil
+}
+
+func (s *KeyMappedStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	return s.Store.OpenObject(ctx, s.mapping.ToInner(name))
+}
+
+func (s *KeyMappedStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	return s.Store.OpenObjectRange(ctx, s.mapping.ToInner(name), offset, length)
+}
+
+func (s *KeyMappedStore) FileExists(ctx context.Context, base string) (bool, error) {
+	return s.Store.FileExists(ctx, s.mapping.ToInner(base))
+}
+
+func (s *KeyMappedStore) ObjectAttributes(ctx context.Context, base string) (*ObjectAttrs, error) {
+	attrs, err := s.Store.ObjectAttributes(ctx, s.mapping.ToInner(base))
+	if err != nil {
+		return nil, err
+	}
+	attrs.Name = base
+	return attrs, nil
+}
+
+func (s *KeyMappedStore) ObjectPath(base string) string {
+	return s.Store.ObjectPath(s.mapping.ToInner(base))
+}
+
+func (s *KeyMappedStore) ObjectURL(base string) string {
+	return s.Store.ObjectURL(s.mapping.ToInner(base))
+}
+
+func (s *KeyMappedStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return s.Store.PresignGet(ctx, s.mapping.ToInner(base), ttl)
+}
+
+func (s *KeyMappedStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return s.Store.PresignPut(ctx, s.mapping.ToInner(base), ttl)
+}
+
+func (s *KeyMappedStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	return s.Store.WriteObject(ctx, s.mapping.ToInner(base), f, opts...)
+}
+
+func (s *KeyMappedStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+func (s *KeyMappedStore) CopyObject(ctx context.Context, src, dst string) error {
+	return s.Store.CopyObject(ctx, s.mapping.ToInner(src), s.mapping.ToInner(dst))
+}
+
+func (s *KeyMappedStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	return s.Store.RenameObject(ctx, s.mapping.ToInner(oldName), s.mapping.ToInner(newName))
+}
+
+func (s *KeyMappedStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
+	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
+}
+
+func (s *KeyMappedStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error {
+	return commonWalkBetween(s, ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *KeyMappedStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error {
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *KeyMappedStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) error {
+	f = skipPrefixes(f)
+	innerPrefix, ordered := s.mapping.InnerPrefix(prefix)
+
+	if ordered {
+		return s.Store.WalkObjects(ctx, innerPrefix, func(attrs *ObjectAttrs) error {
+			name, ok := s.mapping.FromInner(attrs.Name)
+			if !ok || !strings.HasPrefix(name, prefix) {
+				return nil
+			}
+
+			attrs.Name = name
+			return f(attrs)
+		})
+	}
+
+	var objects []*ObjectAttrs
+	err := s.Store.WalkObjects(ctx, innerPrefix, func(attrs *ObjectAttrs) error {
+		if name, ok := s.mapping.FromInner(attrs.Name); ok && strings.HasPrefix(name, prefix) {
+			attrs.Name = name
+			objects = append(objects, attrs)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	for _, attrs := range objects {
+		if err := f(attrs); err != nil {
+			if err == StopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *KeyMappedStore) ListFiles(ctx context.Context, prefix string, max int) ([]string, error) {
+	return listFiles(ctx, s, prefix, max)
+}
+
+func (s *KeyMappedStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	return listFilesPage(ctx, s, prefix, pageSize, pageToken)
+}
+
+// ListDirectories walks the objects under `prefix`, since the directories of
+// the inner store don't match the ones of the mapped names.
+func (s *KeyMappedStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	dirPrefix := directoryPrefix("", prefix)
+
+	err = s.Walk(ctx, dirPrefix, func(filename string) error {
+		rest := filename[len(dirPrefix):]
+		if i := strings.Index(rest, "/"); i >= 0 {
+			out = append(out, dirPrefix+rest[:i])
+			return SkipPrefix(dirPrefix + rest[:i+1])
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *KeyMappedStore) DeleteObject(ctx context.Context, base string) error {
+	return s.Store.DeleteObject(ctx, s.mapping.ToInner(base))
+}
+
+func (s *KeyMappedStore) DeleteObjects(ctx context.Context, names []string) error {
+	innerNames := make([]string, len(names))
+	for i, name := range names {
+		innerNames[i] = s.mapping.ToInner(name)
+	}
+	return s.Store.DeleteObjects(ctx, innerNames)
+}
+
+func (s *KeyMappedStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	return deletePrefix(ctx, s, prefix, deletePrefixConcurrency)
+}
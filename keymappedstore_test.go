@@ -0,0 +1,96 @@
+package dstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyMappedStore_HashFanOut(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+	store := NewKeyMappedStore(inner, HashFanOutKeyMapping(2, 2))
+
+	for _, name := range []string{"blocks/0002", "blocks/0001", "blocks/sub/0003", "index"} {
+		require.NoError(t, store.WriteObject(ctx, name, strings.NewReader(name)))
+	}
+	// Not produced by the mapping, ignored
+	require.NoError(t, inner.WriteObject(ctx, "ab/cd/unmapped", strings.NewReader("unmapped")))
+
+	innerFiles, err := inner.ListFiles(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Contains(t, innerFiles, "49/b5/blocks/0001")
+
+	files, err := store.ListFiles(ctx, "blocks/", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"blocks/0001", "blocks/0002", "blocks/sub/0003"}, files)
+
+	dirs, err := store.ListDirectories(ctx, "blocks")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"blocks/sub"}, dirs)
+
+	attrs, err := store.ObjectAttributes(ctx, "blocks/0001")
+	require.NoError(t, err)
+	assert.Equal(t, "blocks/0001", attrs.Name)
+	content, err := ReadObject(ctx, store, "blocks/0001")
+	require.NoError(t, err)
+	assert.Equal(t, "blocks/0001", string(content))
+
+	sub, err := store.SubStore("blocks")
+	require.NoError(t, err)
+	content, err = ReadObject(ctx, sub, "0002")
+	require.NoError(t, err)
+	assert.Equal(t, "blocks/0002", string(content))
+	subFiles, err := sub.ListFiles(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0001", "0002", "sub/0003"}, subFiles)
+
+	deleted, err := store.DeletePrefix(ctx, "blocks/")
+	require.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+	innerFiles, err = inner.ListFiles(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Len(t, innerFiles, 2)
+}
+
+func TestKeyMappedStore_Date(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+	store := NewKeyMappedStore(inner, DateKeyMapping("2006/01/02", func(name string) time.Time {
+		date, _ := time.Parse("20060102", strings.SplitN(name, "-", 2)[0])
+		return date
+	}))
+
+	require.NoError(t, store.WriteObject(ctx, "20210102-b", strings.NewReader("b")))
+	require.NoError(t, store.WriteObject(ctx, "20201231-a", strings.NewReader("a")))
+
+	innerFiles, err := inner.ListFiles(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2020/12/31/20201231-a", "2021/01/02/20210102-b"}, innerFiles)
+
+	files, err := store.ListFiles(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"20201231-a", "20210102-b"}, files)
+	content, err := ReadObject(ctx, store, "20210102-b")
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(content))
+}
+
+func TestPrefixKeyMapping(t *testing.T) {
+	mapping := PrefixKeyMapping("/tenant/")
+	assert.Equal(t, "tenant/file", mapping.ToInner("file"))
+
+	name, ok := mapping.FromInner("tenant/file")
+	assert.True(t, ok)
+	assert.Equal(t, "file", name)
+	_, ok = mapping.FromInner("other/file")
+	assert.False(t, ok)
+
+	innerPrefix, ordered := mapping.InnerPrefix("fi")
+	assert.Equal(t, "tenant/fi", innerPrefix)
+	assert.True(t, ordered)
+}
@@ -0,0 +1,82 @@
+package dstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// UploadLocalFile is like `Store.PushLocalFile` but keeps `localFile` around,
+// so that it can be uploaded to other stores, and returns the attributes of
+// the written object.
+func UploadLocalFile(ctx context.Context, store Store, localFile, toBaseName string, opts ...WriteOption) (*ObjectAttrs, error) {
+	if _, err := pushLocalFile(ctx, store, localFile, toBaseName, opts...); err != nil {
+		return nil, err
+	}
+
+	attrs, err := store.ObjectAttributes(ctx, toBaseName)
+	if err != nil {
+		return nil, fmt.Errorf("object attributes of %q: %w", toBaseName, err)
+	}
+	return attrs, nil
+}
+
+// PullToLocalFile downloads the object to `localFile`, decompressed according
+// to the store's compression. The content is first written to a temporary file
+// in the same directory and renamed to `localFile` once complete, so that
+// `localFile` is never observed half-written.
+//
+// Before the rename, the downloaded size is checked against the object's size
+// on uncompressed stores, compressed content being already verified by the
+// checksums of the compression format.
+func PullToLocalFile(ctx context.Context, store Store, name, localFile string) (err error) {
+	attrs, err := store.ObjectAttributes(ctx, name)
+	if err != nil {
+		return fmt.Errorf("object attributes of %q: %w", name, err)
+	}
+
+	reader, err := store.OpenObject(ctx, name)
+	if err != nil {
+		return fmt.Errorf("open object %q: %w", name, err)
+	}
+	defer reader.Close()
+
+	if err := os.MkdirAll(filepath.Dir(localFile), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(localFile), filepath.Base(localFile)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+		}
+	}()
+
+	written, err := io.Copy(tempFile, reader)
+	if err != nil {
+		return fmt.Errorf("download %q: %w", name, err)
+	}
+
+	if s, ok := store.(interface{ decompressesReads() bool }); ok && !s.decompressesReads() && written != attrs.Size {
+		return fmt.Errorf("downloaded %d bytes from %q, expected %d", written, name, attrs.Size)
+	}
+
+	if err := tempFile.Sync(); err != nil {
+		return fmt.Errorf("sync temporary file: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("close temporary file: %w", err)
+	}
+
+	if err := os.Rename(tempFile.Name(), localFile); err != nil {
+		return fmt.Errorf("rename temporary file: %w", err)
+	}
+	return nil
+}
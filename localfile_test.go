@@ -0,0 +1,71 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadLocalFile(t *testing.T) {
+	localFile := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, ioutil.WriteFile(localFile, []byte("content"), 0644))
+
+	ctx := context.Background()
+	for _, compression := range []string{"", "gzip"} {
+		store, err := NewLocalStore(&url.URL{Scheme: "file", Path: t.TempDir()}, "", compression, false)
+		require.NoError(t, err)
+
+		attrs, err := UploadLocalFile(ctx, store, localFile, "0000/file", WithMetadata(map[string]string{"Origin": "test"}))
+		require.NoError(t, err)
+		assert.Equal(t, "0000/file", attrs.Name)
+		assert.Equal(t, map[string]string{"origin": "test"}, attrs.Metadata)
+
+		reader, err := store.OpenObject(ctx, "0000/file")
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "content", string(content))
+	}
+
+	assert.FileExists(t, localFile)
+}
+
+func TestPullToLocalFile(t *testing.T) {
+	ctx := context.Background()
+	for _, compression := range []string{"", "gzip", "zstd"} {
+		t.Run(compression, func(t *testing.T) {
+			store, err := NewLocalStore(&url.URL{Scheme: "file", Path: t.TempDir()}, "", compression, false)
+			require.NoError(t, err)
+			require.NoError(t, store.WriteObject(ctx, "0000/file", bytes.NewReader([]byte("content"))))
+
+			localDir := t.TempDir()
+			localFile := filepath.Join(localDir, "sub", "file")
+			require.NoError(t, PullToLocalFile(ctx, store, "0000/file", localFile))
+
+			content, err := ioutil.ReadFile(localFile)
+			require.NoError(t, err)
+			assert.Equal(t, "content", string(content))
+
+			entries, err := ioutil.ReadDir(filepath.Join(localDir, "sub"))
+			require.NoError(t, err)
+			assert.Len(t, entries, 1, "temporary file left behind")
+		})
+	}
+}
+
+func TestPullToLocalFile_ErrNotFound(t *testing.T) {
+	store, err := NewLocalStore(&url.URL{Scheme: "file", Path: t.TempDir()}, "", "", false)
+	require.NoError(t, err)
+
+	localFile := filepath.Join(t.TempDir(), "file")
+	err = PullToLocalFile(context.Background(), store, "missing", localFile)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoFileExists(t, localFile)
+}
@@ -1,14 +1,20 @@
 package dstore
 
 import (
+	"bufio"
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -20,12 +26,19 @@ import (
 type LocalStore struct {
 	baseURL  *url.URL
 	basePath string
+	// syncWrites flushes the written files and their directory to disk.
+	syncWrites bool
 	*commonStore
 }
 
 func NewLocalStore(baseURL *url.URL, extension, compressionType string, overwrite bool) (*LocalStore, error) {
+	return NewLocalStoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+func NewLocalStoreWithOptions(baseURL *url.URL, opts ...Option) (*LocalStore, error) {
+	config := newConfig(opts)
 	basePath := filepath.Clean(baseURL.Path)
-	zlog.Info("sanitized base path", zap.String("original_base_path", baseURL.Path), zap.String("sanitized_base_path", basePath))
+	loggerOrDefault(config.logger).Info("sanitized base path", zap.String("original_base_path", baseURL.Path), zap.String("sanitized_base_path", basePath))
 
 	myBaseURL := *baseURL
 	myBaseURL.Scheme = "file"
@@ -40,13 +53,10 @@ func NewLocalStore(baseURL *url.URL, extension, compressionType string, overwrit
 	}
 
 	return &LocalStore{
-		basePath: basePath,
-		baseURL:  &myBaseURL,
-		commonStore: &commonStore{
-			compressionType: compressionType,
-			extension:       extension,
-			overwrite:       overwrite,
-		},
+		basePath:    basePath,
+		baseURL:     &myBaseURL,
+		syncWrites:  config.syncWrites || baseURL.Query().Get("sync") == "true",
+		commonStore: newCommonStore(baseURL, config),
 	}, nil
 }
 
@@ -57,75 +67,402 @@ func (s *LocalStore) SubStore(subFolder string) (Store, error) {
 	if err != nil {
 		return nil, fmt.Errorf("local store parsing base url: %w", err)
 	}
-	return NewLocalStore(url, s.extension, s.compressionType, s.overwrite)
+	opts := s.options()
+	if s.syncWrites {
+		opts = append(opts, SyncWrites())
+	}
+	return NewLocalStoreWithOptions(url, opts...)
 }
 
 func (s *LocalStore) BaseURL() *url.URL {
 	return s.baseURL
 }
 
-func (s *LocalStore) ListFiles(ctx context.Context, prefix string, max int) ([]string, error) {
+func (s *LocalStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer classifyError(&err)
 	return listFiles(ctx, s, prefix, max)
 }
 
-func (s *LocalStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
+func (s *LocalStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
 	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
 }
 
-func (s *LocalStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error {
-	fullPath := s.basePath + "/"
-	if prefix != "" {
-		fullPath += prefix
+func (s *LocalStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkBetween(s, ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *LocalStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walk(ctx, prefix, false, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *LocalStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walk(ctx, prefix, true, f)
+}
+
+// walk walks the objects under `prefix`, reading the metadata sidecar of each
+// object only when `withMetadata` is set, plain name walks not needing it.
+func (s *LocalStore) walk(ctx context.Context, prefix string, withMetadata bool, f func(attrs *ObjectAttrs) error) (err error) {
+	f = skipPrefixes(f)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("walking files", zap.String("base_path", s.basePath), zap.String("prefix", prefix))
 	}
 
-	walkPath := fullPath
-	if !strings.HasSuffix(fullPath, "/") {
-		// /my/path/0000 -> will walk /my/path, in case `0000` is the prefix of some files within
-		walkPath = filepath.Dir(fullPath)
+	err = s.walkDir(ctx, s.basePath, "", prefix, withMetadata, f)
+	if err == StopIteration {
+		return nil
 	}
+	return err
+}
+
+// localWalkBatchSize is the number of directory entries read at once while
+// walking, so that huge directories are not loaded all at once.
+const localWalkBatchSize = 1024
+
+// localWalkRunSize is the number of entries of a directory sorted in memory at
+// once while walking. Larger directories are sorted in runs spilled to a
+// temporary file, and merged as they are walked.
+var localWalkRunSize = 64 * 1024
+
+// localWalkEntry is the part of a directory entry kept while walking.
+type localWalkEntry struct {
+	name string
+	// key sorts the entry in the lexicographic order of object names, being
+	// the name without the extension of the store for files and the name
+	// followed by a slash for directories.
+	key     string
+	dir     bool
+	size    int64
+	modTime time.Time
+}
 
-	if tracer.Enabled() {
-		zlog.Debug("walking files", zap.String("walk_path", walkPath))
+// walkDir walks the files of `dir`, whose path relative to the base path of
+// the store is `rel`, in the lexicographic order of their names. The entries
+// are read in batches, only the ones matching `prefix` being kept, and sorted
+// in runs of at most `localWalkRunSize` entries, so walking a huge directory
+// holds a bounded number of entries in memory. The metadata sidecars of the
+// files are read only when `withMetadata` is set.
+func (s *LocalStore) walkDir(ctx context.Context, dir, rel, prefix string, withMetadata bool, f func(attrs *ObjectAttrs) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	err := filepath.Walk(walkPath, func(infoPath string, info os.FileInfo, err error) error {
-		if strings.HasSuffix(infoPath, ".tmp") {
-			// Early exits to avoid races with half-written `.tmp`
-			// files, that would error out with the `err != nil` check
-			// below.  Only for local ones, as other stores are atomic.
+	run, spill, err := s.readWalkEntries(dir, rel, prefix)
+	if err != nil {
+		if os.IsNotExist(err) {
 			return nil
 		}
-		if err != nil {
-			if os.IsNotExist(err) {
-				return nil
+		return err
+	}
+
+	walkEntry := func(entry *localWalkEntry) error {
+		entryPath := filepath.Join(dir, entry.name)
+		if entry.dir {
+			return s.walkDir(ctx, entryPath, rel+entry.name+"/", prefix, withMetadata, f)
+		}
+
+		var metadata map[string]string
+		if withMetadata {
+			var err error
+			if metadata, err = readLocalMetadata(entryPath); err != nil {
+				return fmt.Errorf("reading metadata: %w", err)
 			}
-			return err
 		}
 
-		if info.IsDir() {
-			if len(infoPath) >= len(fullPath) && !strings.HasPrefix(infoPath, fullPath) {
-				return filepath.SkipDir
+		// StopIteration bubbles up to abort the walk altogether
+		return f(newLocalObjectAttrsFrom(rel+entry.key, entry.size, entry.modTime, metadata))
+	}
+
+	if spill == nil {
+		for i := range run {
+			if err := walkEntry(&run[i]); err != nil {
+				return err
 			}
-			return nil
 		}
-		if !strings.HasPrefix(infoPath, fullPath) {
-			return nil
+		return nil
+	}
+
+	defer spill.close()
+	return spill.merge(walkEntry)
+}
+
+// readWalkEntries returns the sorted entries of `dir` matching `prefix`, or
+// the spill holding them in sorted runs when there are more than
+// `localWalkRunSize` of them.
+func (s *LocalStore) readWalkEntries(dir, rel, prefix string) (run []localWalkEntry, spill *localWalkSpill, err error) {
+	file, err := os.Open(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	defer func() {
+		if err != nil && spill != nil {
+			spill.close()
+			spill = nil
 		}
+	}()
+
+	extension := s.pathWithExt("")
+	for {
+		infos, readErr := file.Readdir(localWalkBatchSize)
+		for _, info := range infos {
+			name := info.Name()
+			if info.IsDir() {
+				key := name + "/"
+				if strings.HasPrefix(rel+key, prefix) || strings.HasPrefix(prefix, rel+key) {
+					run = append(run, localWalkEntry{name: name, key: key, dir: true})
+				}
+			} else {
+				// Half-written `.tmp` files and metadata sidecar files are
+				// not objects on their own
+				if strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, localMetadataSuffix) || !strings.HasPrefix(rel+name, prefix) {
+					continue
+				}
+				run = append(run, localWalkEntry{
+					name:    name,
+					key:     strings.TrimSuffix(name, extension),
+					size:    info.Size(),
+					modTime: info.ModTime(),
+				})
+			}
 
-		if err := f(s.toBaseName(infoPath)); err != nil {
-			if err == StopIteration {
-				return nil
+			if len(run) < localWalkRunSize {
+				continue
 			}
-			return err
+			if spill == nil {
+				if spill, err = newLocalWalkSpill(); err != nil {
+					return nil, nil, err
+				}
+			}
+			if err := spill.writeRun(run); err != nil {
+				return nil, nil, err
+			}
+			run = run[:0]
 		}
 
-		return nil
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, nil, readErr
+		}
+	}
+
+	if spill == nil {
+		sortLocalWalkEntries(run)
+		return run, nil, nil
+	}
+	if len(run) > 0 {
+		if err := spill.writeRun(run); err != nil {
+			return nil, nil, err
+		}
+	}
+	return nil, spill, nil
+}
+
+func sortLocalWalkEntries(entries []localWalkEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
 	})
+}
+
+// localWalkSpill holds the sorted runs of entries of a huge directory in a
+// temporary file, one after the other.
+type localWalkSpill struct {
+	file    *os.File
+	writer  *bufio.Writer
+	size    int64
+	scratch [binary.MaxVarintLen64]byte
+	// ends are the offsets of the end of each run in the file
+	ends []int64
+}
+
+func newLocalWalkSpill() (*localWalkSpill, error) {
+	file, err := ioutil.TempFile("", "dstore-walk-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temporary file: %w", err)
+	}
+	return &localWalkSpill{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (s *localWalkSpill) close() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// writeRun sorts `entries` and appends them to the file as a new run.
+func (s *localWalkSpill) writeRun(entries []localWalkEntry) error {
+	sortLocalWalkEntries(entries)
+	for _, entry := range entries {
+		var dir int64
+		if entry.dir {
+			dir = 1
+		}
+
+		err := s.writeString(entry.name)
+		if err == nil {
+			err = s.writeString(entry.key)
+		}
+		if err == nil {
+			err = s.writeVarint(dir)
+		}
+		if err == nil {
+			err = s.writeVarint(entry.size)
+		}
+		if err == nil {
+			err = s.writeVarint(entry.modTime.UnixNano())
+		}
+		if err != nil {
+			return fmt.Errorf("spill walk entries: %w", err)
+		}
+	}
+	s.ends = append(s.ends, s.size)
+	return nil
+}
+
+func (s *localWalkSpill) writeVarint(value int64) error {
+	n, err := s.writer.Write(s.scratch[:binary.PutVarint(s.scratch[:], value)])
+	s.size += int64(n)
+	return err
+}
+
+func (s *localWalkSpill) writeString(value string) error {
+	if err := s.writeVarint(int64(len(value))); err != nil {
+		return err
+	}
+	n, err := s.writer.WriteString(value)
+	s.size += int64(n)
 	return err
 }
 
-func (s *LocalStore) WriteObject(ctx context.Context, base string, reader io.Reader) (err error) {
+// merge passes the entries of the runs to `f` in order, `f` not keeping them.
+func (s *localWalkSpill) merge(f func(entry *localWalkEntry) error) error {
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("spill walk entries: %w", err)
+	}
+
+	runs := make([]*localWalkRun, 0, len(s.ends))
+	var start int64
+	for _, end := range s.ends {
+		run := &localWalkRun{reader: bufio.NewReader(io.NewSectionReader(s.file, start, end-start))}
+		if err := run.next(); err != nil {
+			return err
+		}
+		runs = append(runs, run)
+		start = end
+	}
+
+	for len(runs) > 0 {
+		next := 0
+		for i, run := range runs {
+			if run.head.key < runs[next].head.key {
+				next = i
+			}
+		}
+
+		run := runs[next]
+		if err := f(&run.head); err != nil {
+			return err
+		}
+		if err := run.next(); err != nil {
+			return err
+		}
+		if run.done {
+			runs = append(runs[:next], runs[next+1:]...)
+		}
+	}
+	return nil
+}
+
+// localWalkRun reads a run of a spill one entry at a time.
+type localWalkRun struct {
+	reader *bufio.Reader
+	head   localWalkEntry
+	done   bool
+}
+
+// next moves head to the next entry of the run, setting done at its end.
+func (r *localWalkRun) next() error {
+	name, err := r.readString()
+	if err == io.EOF {
+		r.done = true
+		return nil
+	}
+
+	var key string
+	var dir, size, modTime int64
+	if err == nil {
+		key, err = r.readString()
+	}
+	if err == nil {
+		dir, err = binary.ReadVarint(r.reader)
+	}
+	if err == nil {
+		size, err = binary.ReadVarint(r.reader)
+	}
+	if err == nil {
+		modTime, err = binary.ReadVarint(r.reader)
+	}
+	if err != nil {
+		return fmt.Errorf("read spilled walk entries: %w", err)
+	}
+
+	r.head = localWalkEntry{name: name, key: key, dir: dir == 1, size: size, modTime: time.Unix(0, modTime)}
+	return nil
+}
+
+func (r *localWalkRun) readString() (string, error) {
+	length, err := binary.ReadVarint(r.reader)
+	if err != nil {
+		return "", err
+	}
+
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r.reader, value); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func (s *LocalStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer classifyError(&err)
+	return listFilesPage(ctx, s, prefix, pageSize, pageToken)
+}
+
+func (s *LocalStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer classifyError(&err)
+	prefix = strings.Trim(prefix, "/")
+
+	entries, err := ioutil.ReadDir(filepath.Join(s.basePath, prefix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			out = append(out, path.Join(prefix, entry.Name()))
+		}
+	}
+	return out, nil
+}
+
+func (s *LocalStore) WriteObject(ctx context.Context, base string, reader io.Reader, opts ...WriteOption) (err error) {
+	defer classifyError(&err)
 	destPath := s.ObjectPath(base)
+	config := newWriteConfig(opts)
+	reader = config.trackProgress(reader)
+	if config.acl != "" {
+		return fmt.Errorf("object ACL: %w", ErrNotSupported)
+	}
 
 	tempPath := destPath + ".tmp"
 
@@ -139,13 +476,266 @@ func (s *LocalStore) WriteObject(ctx context.Context, base string, reader io.Rea
 		return fmt.Errorf("unable to create file %q: %w", tempPath, err)
 	}
 
-	if err := s.compressedCopy(reader, file); err != nil {
+	if err := s.compressedCopy(ctx, reader, file); err != nil {
+		file.Close()
 		return err
 	}
+	if s.syncWrites {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return fmt.Errorf("sync %q: %w", tempPath, err)
+		}
+	}
 	if err := file.Close(); err != nil {
 		return err
 	}
 
+	if err := writeLocalMetadata(destPath, config.metadata, s.syncWrites); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tempPath, destPath); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	if s.syncWrites {
+		// The rename is only durable once the directory is
+		if err := syncLocalDir(targetDir); err != nil {
+			return err
+		}
+	}
+
+	if config.attrs != nil {
+		info, err := os.Stat(destPath)
+		if err != nil {
+			return fmt.Errorf("stat written object: %w", err)
+		}
+		attrs, err := newLocalObjectAttrs(base, destPath, info)
+		if err != nil {
+			return err
+		}
+		config.capture(attrs)
+	}
+	return nil
+}
+
+// AppendObject appends the content read from `f` to the object, creating it
+// when missing. A failed append truncates the object back to its previous
+// size, leaving no partially compressed stream behind.
+func (s *LocalStore) AppendObject(ctx context.Context, base string, f io.Reader) (err error) {
+	defer classifyError(&err)
+	if !s.appendable() {
+		return fmt.Errorf("append to %s object: %w", s.compressionType, ErrNotSupported)
+	}
+
+	destPath := s.ObjectPath(base)
+	targetDir := filepath.Dir(destPath)
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("ensuring directory exists (mkdir -p) %q: %w", targetDir, err)
+	}
+
+	file, err := os.OpenFile(destPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open file %q: %w", destPath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := s.compressedCopy(ctx, f, file); err != nil {
+		if truncateErr := file.Truncate(info.Size()); truncateErr != nil {
+			s.logger.Warn("unable to truncate failed append", zap.String("path", destPath), zap.Error(truncateErr))
+		}
+		return err
+	}
+	if s.syncWrites {
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("sync %q: %w", destPath, err)
+		}
+		if info.Size() == 0 {
+			// The file may have been created by the append
+			if err := syncLocalDir(targetDir); err != nil {
+				return err
+			}
+		}
+	}
+	return file.Close()
+}
+
+// syncLocalDir flushes the entries of the directory to disk, making the files
+// created or renamed in it durable.
+func syncLocalDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open directory %q: %w", dir, err)
+	}
+	defer file.Close()
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("sync directory %q: %w", dir, err)
+	}
+	return nil
+}
+
+// localMetadataSuffix is appended to an object's path to form the path of the
+// sidecar file holding the object's metadata.
+const localMetadataSuffix = ".dstoremeta"
+
+// writeLocalMetadata writes the sidecar metadata file of the object at
+// `objectPath`, removing any previous one when there is no metadata. With
+// `sync`, the file is flushed to disk before being renamed in place.
+func writeLocalMetadata(objectPath string, metadata map[string]string, sync bool) error {
+	metadataPath := objectPath + localMetadataSuffix
+	if len(metadata) == 0 {
+		if err := os.Remove(metadataPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing stale metadata %q: %w", metadataPath, err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("marshal metadata: %w", err)
+	}
+
+	tempPath := metadataPath + ".tmp"
+	if err := writeLocalFile(tempPath, data, sync); err != nil {
+		return fmt.Errorf("writing metadata %q: %w", tempPath, err)
+	}
+
+	if err := os.Rename(tempPath, metadataPath); err != nil {
+		return fmt.Errorf("rename metadata: %w", err)
+	}
+	return nil
+}
+
+func writeLocalFile(path string, data []byte, sync bool) error {
+	if !sync {
+		return ioutil.WriteFile(path, data, 0644)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func readLocalMetadata(objectPath string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(objectPath + localMetadataSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var metadata map[string]string
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("unmarshal metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+func (s *LocalStore) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, s, dst); skip || err != nil {
+		return err
+	}
+
+	return copyLocalFile(s.ObjectPath(src), s.ObjectPath(dst))
+}
+
+func (s *LocalStore) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer classifyError(&err)
+	oldPath := s.ObjectPath(oldName)
+
+	if skip, err := skipExistingCopy(ctx, s, newName); skip || err != nil {
+		if err != nil {
+			return err
+		}
+		return s.DeleteObject(ctx, oldName)
+	}
+
+	newPath := s.ObjectPath(newName)
+
+	targetDir := filepath.Dir(newPath)
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("ensuring directory exists (mkdir -p) %q: %w", targetDir, err)
+	}
+
+	metadata, err := readLocalMetadata(oldPath)
+	if err != nil {
+		return fmt.Errorf("reading metadata: %w", err)
+	}
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("rename: %w", err)
+	}
+
+	if err := writeLocalMetadata(newPath, metadata, false); err != nil {
+		return err
+	}
+	if err := os.Remove(oldPath + localMetadataSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing metadata: %w", err)
+	}
+
+	return nil
+}
+
+// copyLocalFile copies the file through a temporary file renamed once
+// complete, so readers never see a partially copied file.
+func copyLocalFile(srcPath, destPath string) error {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	defer srcFile.Close()
+
+	tempPath := destPath + ".tmp"
+
+	targetDir := filepath.Dir(tempPath)
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("ensuring directory exists (mkdir -p) %q: %w", targetDir, err)
+	}
+
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("unable to create file %q: %w", tempPath, err)
+	}
+
+	if _, err := io.Copy(file, srcFile); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	metadata, err := readLocalMetadata(srcPath)
+	if err != nil {
+		return fmt.Errorf("reading metadata: %w", err)
+	}
+	if err := writeLocalMetadata(destPath, metadata, false); err != nil {
+		return err
+	}
+
 	if err := os.Rename(tempPath, destPath); err != nil {
 		return fmt.Errorf("rename: %w", err)
 	}
@@ -154,10 +744,11 @@ func (s *LocalStore) WriteObject(ctx context.Context, base string, reader io.Rea
 }
 
 func (s *LocalStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
 	path := s.ObjectPath(name)
 
-	if tracer.Enabled() {
-		zlog.Debug("opening dstore file", zap.String("path", s.pathWithExt(name)))
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file", zap.String("path", s.pathWithExt(name)))
 	}
 
 	file, err := os.Open(path)
@@ -169,15 +760,50 @@ func (s *LocalStore) OpenObject(ctx context.Context, name string) (out io.ReadCl
 	}
 
 	reader := NewBufferedFileReadCloser(file)
-	out, err = s.uncompressedReader(reader)
-	if tracer.Enabled() {
+	out, err = s.uncompressedReader(ctx, reader)
+	if debugEnabled(s.logger) {
 		out = wrapReadCloser(out, func() {
-			zlog.Debug("closing dstore file", zap.String("path", s.pathWithExt(name)))
+			s.logger.Debug("closing dstore file", zap.String("path", s.pathWithExt(name)))
 		})
 	}
 	return
 }
 
+func (s *LocalStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	if s.decompressesReads() {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+	reader, err := s.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.throttleReads(ctx, reader), nil
+}
+
+func (s *LocalStore) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	path := s.ObjectPath(name)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file range", zap.String("path", s.pathWithExt(name)), zap.Int64("offset", offset), zap.Int64("length", length))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("seeking to offset %d: %w", offset, err)
+	}
+
+	return limitReadCloser(NewBufferedFileReadCloser(file), length), nil
+}
+
 func (s *LocalStore) toBaseName(filename string) string {
 	baseName := strings.TrimPrefix(strings.TrimSuffix(filename, s.pathWithExt("")), s.basePath)
 	baseName = strings.TrimPrefix(baseName, "/")
@@ -193,15 +819,49 @@ func (s *LocalStore) ObjectURL(name string) string {
 	return fmt.Sprintf("%s/%s", strings.TrimRight(s.baseURL.String(), "/"), strings.TrimLeft(s.pathWithExt(name), "/"))
 }
 
-func (s *LocalStore) DeleteObject(ctx context.Context, base string) error {
+func (s *LocalStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return "", ErrNotSupported
+}
+
+func (s *LocalStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return "", ErrNotSupported
+}
+
+func (s *LocalStore) DeleteObject(ctx context.Context, base string) (err error) {
+	defer classifyError(&err)
 	path := s.ObjectPath(base)
-	return os.Remove(path)
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+
+	if err := os.Remove(path + localMetadataSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing metadata: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStore) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer classifyError(&err)
+	for _, name := range names {
+		if err := s.DeleteObject(ctx, name); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("delete %q: %w", name, err)
+		}
+	}
+	return nil
 }
 
-func (s *LocalStore) FileExists(ctx context.Context, base string) (bool, error) {
+func (s *LocalStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer classifyError(&err)
+	return deletePrefix(ctx, s, prefix, deletePrefixConcurrency)
+}
+
+func (s *LocalStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer classifyError(&err)
 	path := s.ObjectPath(base)
 
-	_, err := os.Stat(path)
+	_, err = os.Stat(path)
 	if err == nil {
 		return true, nil
 	}
@@ -213,7 +873,42 @@ func (s *LocalStore) FileExists(ctx context.Context, base string) (bool, error)
 	return false, err
 }
 
-func (s *LocalStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) error {
+func (s *LocalStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return newLocalObjectAttrs(base, path, info)
+}
+
+func newLocalObjectAttrs(name, path string, info os.FileInfo) (*ObjectAttrs, error) {
+	metadata, err := readLocalMetadata(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+
+	return newLocalObjectAttrsFrom(name, info.Size(), info.ModTime(), metadata), nil
+}
+
+func newLocalObjectAttrsFrom(name string, size int64, modTime time.Time, metadata map[string]string) *ObjectAttrs {
+	return &ObjectAttrs{
+		Name:         name,
+		Size:         size,
+		LastModified: modTime,
+		ETag:         fmt.Sprintf("%x-%x", modTime.UnixNano(), size),
+		Metadata:     metadata,
+	}
+}
+
+func (s *LocalStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer classifyError(&err)
 	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
 	if err != nil {
 		return err
@@ -2,8 +2,13 @@ package dstore
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"math"
 	"net/url"
+	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 
@@ -47,3 +52,159 @@ func TestNewLocalStore_SubStore(t *testing.T) {
 	require.True(t, strings.HasSuffix(sub.BaseURL().Path, "sub-folder"))
 
 }
+
+func TestNewLocalStore_WriteObject_ACLNotSupported(t *testing.T) {
+	store, err := NewLocalStore(&url.URL{Scheme: "file", Path: t.TempDir()}, "", "", false)
+	require.NoError(t, err)
+
+	err = store.WriteObject(context.Background(), "file", strings.NewReader("content"), WithACL(ACLPublicRead))
+	assert.True(t, errors.Is(err, ErrNotSupported), "expected ErrNotSupported, got %v", err)
+
+	exists, err := store.FileExists(context.Background(), "file")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestNewLocalStoreWithOptions(t *testing.T) {
+	store, err := NewStoreWithOptions(t.TempDir(), Extension("dbin.zst"), Compression("zstd"), AllowOverwrite())
+	require.NoError(t, err)
+
+	sub, err := store.SubStore("sub-folder")
+	require.NoError(t, err)
+
+	for _, s := range []Store{store, sub} {
+		local := s.(*LocalStore)
+		assert.Equal(t, "dbin.zst", local.extension)
+		assert.Equal(t, "zstd", local.compressionType)
+		assert.True(t, local.Overwrite())
+		assert.True(t, strings.HasSuffix(local.ObjectPath("0000000100"), "/0000000100.dbin.zst"))
+	}
+}
+
+func TestLocalStore_SyncWrites(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStoreWithOptions(&url.URL{Scheme: "file", Path: t.TempDir()}, Compression("zstd"), SyncWrites())
+	require.NoError(t, err)
+	assert.True(t, store.syncWrites)
+
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+	assert.True(t, sub.(*LocalStore).syncWrites)
+
+	for _, s := range []Store{store, sub} {
+		require.NoError(t, s.WriteObject(ctx, "file", strings.NewReader("content"), WithMetadata(map[string]string{"key": "value"})))
+		require.NoError(t, AppendObject(ctx, s, "appended", strings.NewReader("first")))
+		require.NoError(t, AppendObject(ctx, s, "appended", strings.NewReader(" second")))
+
+		content, err := ReadObject(ctx, s, "file")
+		require.NoError(t, err)
+		assert.Equal(t, "content", string(content))
+		attrs, err := s.ObjectAttributes(ctx, "file")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"key": "value"}, attrs.Metadata)
+
+		content, err = ReadObject(ctx, s, "appended")
+		require.NoError(t, err)
+		assert.Equal(t, "first second", string(content))
+	}
+
+	store, err = NewLocalStoreWithOptions(&url.URL{Scheme: "file", Path: t.TempDir(), RawQuery: "sync=true"})
+	require.NoError(t, err)
+	assert.True(t, store.syncWrites)
+}
+
+func TestLocalStore_WalkObjectsOrder(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStoreWithOptions(&url.URL{Scheme: "file", Path: t.TempDir()}, Extension("ext"))
+	require.NoError(t, err)
+
+	for _, name := range []string{"dir/b", "dir/a", "dir", "a-b", "a", "dir-a", "other/a"} {
+		require.NoError(t, store.WriteObject(ctx, name, strings.NewReader("content")))
+	}
+
+	walk := func(prefix string) (names []string) {
+		require.NoError(t, store.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+			assert.Equal(t, int64(7), attrs.Size)
+			names = append(names, attrs.Name)
+			return nil
+		}))
+		return
+	}
+
+	assert.Equal(t, []string{"a", "a-b", "dir", "dir-a", "dir/a", "dir/b", "other/a"}, walk(""))
+	assert.Equal(t, []string{"dir", "dir-a", "dir/a", "dir/b"}, walk("dir"))
+	assert.Equal(t, []string{"dir/a", "dir/b"}, walk("dir/"))
+	assert.Equal(t, []string{"dir/b"}, walk("dir/b"))
+	assert.Empty(t, walk("missing/"))
+
+	var between []string
+	require.NoError(t, store.WalkBetween(ctx, "", "a-b", "dir/b", func(filename string) error {
+		between = append(between, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{"a-b", "dir", "dir-a", "dir/a"}, between)
+}
+
+func TestLocalStore_WalkMetadata(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewLocalStore(&url.URL{Scheme: "file", Path: dir}, "", "", false)
+	require.NoError(t, err)
+
+	require.NoError(t, store.WriteObject(ctx, "a", strings.NewReader("content"), WithMetadata(map[string]string{"key": "value"})))
+	require.NoError(t, store.WriteObject(ctx, "b", strings.NewReader("content")))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b"+localMetadataSuffix), []byte("corrupted"), 0644))
+
+	var names []string
+	require.NoError(t, store.Walk(ctx, "", func(filename string) error {
+		names = append(names, filename)
+		return nil
+	}), "plain walks do not read the metadata sidecars")
+	assert.Equal(t, []string{"a", "b"}, names)
+
+	var metadata []map[string]string
+	err = store.WalkObjects(ctx, "", func(attrs *ObjectAttrs) error {
+		metadata = append(metadata, attrs.Metadata)
+		return nil
+	})
+	assert.ErrorContains(t, err, "reading metadata")
+	assert.Equal(t, []map[string]string{{"key": "value"}}, metadata)
+}
+
+func TestLocalStore_WalkObjectsSpilled(t *testing.T) {
+	defer func(size int) { localWalkRunSize = size }(localWalkRunSize)
+	localWalkRunSize = 3
+
+	ctx := context.Background()
+	store, err := NewLocalStoreWithOptions(&url.URL{Scheme: "file", Path: t.TempDir()}, Extension("ext"))
+	require.NoError(t, err)
+
+	var expected []string
+	for i := 19; i >= 0; i-- {
+		for _, name := range []string{fmt.Sprintf("%02d", i), fmt.Sprintf("%02d/nested", i), fmt.Sprintf("%02d-", i)} {
+			require.NoError(t, store.WriteObject(ctx, name, strings.NewReader(name), WithMetadata(map[string]string{"name": name})))
+			expected = append(expected, name)
+		}
+	}
+	sort.Strings(expected)
+
+	var names []string
+	require.NoError(t, store.WalkObjects(ctx, "", func(attrs *ObjectAttrs) error {
+		assert.Equal(t, int64(len(attrs.Name)), attrs.Size)
+		assert.Equal(t, map[string]string{"name": attrs.Name}, attrs.Metadata)
+		assert.False(t, attrs.LastModified.IsZero())
+		names = append(names, attrs.Name)
+		return nil
+	}))
+	assert.Equal(t, expected, names)
+
+	names = nil
+	require.NoError(t, store.Walk(ctx, "1", func(filename string) error {
+		names = append(names, filename)
+		if len(names) == 4 {
+			return StopIteration
+		}
+		return nil
+	}))
+	assert.Equal(t, []string{"10", "10-", "10/nested", "11"}, names)
+}
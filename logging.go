@@ -2,6 +2,55 @@ package dstore
 
 import (
 	"github.com/streamingfast/logging"
+	"go.uber.org/zap"
 )
 
 var zlog, tracer = logging.PackageLogger("dstore", "github.com/streamingfast/dstore")
+
+// loggerOrDefault returns `logger`, the package logger when nil.
+func loggerOrDefault(logger *zap.Logger) *zap.Logger {
+	if logger == nil {
+		return zlog
+	}
+	return logger
+}
+
+// debugEnabled returns whether `logger` logs the debug messages, decided by
+// the package tracer for the package logger and by its level otherwise.
+func debugEnabled(logger *zap.Logger) bool {
+	if logger == zlog {
+		return tracer.Enabled()
+	}
+	return logger.Core().Enabled(zap.DebugLevel)
+}
+
+// storeLogger returns the logger of `store`, set with the `Logger` option,
+// wrapper stores logging to the logger of their inner store.
+func storeLogger(store Store) *zap.Logger {
+	if logging, ok := store.(interface{ log() *zap.Logger }); ok {
+		return logging.log()
+	}
+	return zlog
+}
+
+// The wrapper stores log to the logger of their inner store.
+
+func (s *AuditedStore) log() *zap.Logger          { return storeLogger(s.Store) }
+func (s *CircuitBreakerStore) log() *zap.Logger   { return storeLogger(s.Store) }
+func (s *ChunkedStore) log() *zap.Logger          { return storeLogger(s.Store) }
+func (s *ContentAddressedStore) log() *zap.Logger { return storeLogger(s.Store) }
+func (s *DryRunStore) log() *zap.Logger           { return storeLogger(s.Store) }
+func (s *EncryptedStore) log() *zap.Logger        { return storeLogger(s.Store) }
+func (s *FallbackStore) log() *zap.Logger         { return storeLogger(s.Store) }
+func (s *InterceptedStore) log() *zap.Logger      { return storeLogger(s.Store) }
+func (s *HedgedStore) log() *zap.Logger           { return storeLogger(s.Store) }
+func (s *KeyMappedStore) log() *zap.Logger        { return storeLogger(s.Store) }
+func (s *MetricsStore) log() *zap.Logger          { return storeLogger(s.Store) }
+func (s *MirrorStore) log() *zap.Logger           { return storeLogger(s.Store) }
+func (s *RateLimitedStore) log() *zap.Logger      { return storeLogger(s.Store) }
+func (s *ReadOnlyStore) log() *zap.Logger         { return storeLogger(s.Store) }
+func (s *RetryingStore) log() *zap.Logger         { return storeLogger(s.Store) }
+func (s *ShardedStore) log() *zap.Logger          { return storeLogger(s.Store) }
+func (s *StatsStore) log() *zap.Logger            { return storeLogger(s.Store) }
+func (s *TieredStore) log() *zap.Logger           { return storeLogger(s.Store) }
+func (s *TracingStore) log() *zap.Logger          { return storeLogger(s.Store) }
@@ -0,0 +1,32 @@
+package dstore
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	base, _ := url.Parse("file://" + t.TempDir() + "?read_resumes=many")
+	store, err := NewLocalStoreWithOptions(base, Logger(logger))
+	require.NoError(t, err)
+	assert.Equal(t, 1, logs.FilterMessage("ignoring invalid read_resumes query parameter").Len())
+	assert.False(t, debugEnabled(storeLogger(store)))
+
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+	assert.Equal(t, logger, storeLogger(sub))
+
+	wrapped := NewRetryingStore(NewHedgedStore(sub, 0), RetryPolicy{})
+	assert.Equal(t, logger, storeLogger(wrapped))
+
+	assert.Equal(t, zlog, storeLogger(NewMemoryStore()))
+}
@@ -0,0 +1,389 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Memory Store
+//
+
+// MemoryStore is a `Store` keeping its objects in memory, safe for concurrent
+// use. It honors compression, extension and overwrite options just like the
+// other stores, so unit tests and benchmarks can run against it without temp
+// directories or emulators.
+type MemoryStore struct {
+	baseURL *url.URL
+
+	// prefix is the key prefix of the sub store, empty or ending with `/`
+	prefix  string
+	objects *memoryObjects
+	*commonStore
+}
+
+// memoryObjects holds the objects of a memory store, shared with its sub
+// stores.
+type memoryObjects struct {
+	lock    sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+// memoryObject is never mutated once stored, writes replace it altogether so
+// readers can keep using its content outside the lock.
+type memoryObject struct {
+	content      []byte
+	metadata     map[string]string
+	lastModified time.Time
+}
+
+func NewMemoryStore(opts ...Option) *MemoryStore {
+	objects := &memoryObjects{objects: map[string]*memoryObject{}}
+	return newMemoryStore(&url.URL{Scheme: "memory", Path: "/"}, "", objects, newConfig(opts))
+}
+
+func newMemoryStore(baseURL *url.URL, prefix string, objects *memoryObjects, config *config) *MemoryStore {
+	return &MemoryStore{
+		baseURL:     baseURL,
+		prefix:      prefix,
+		objects:     objects,
+		commonStore: newCommonStore(baseURL, config),
+	}
+}
+
+// SubStore returns a store sharing the objects of this one, so objects
+// written through either are visible to the other.
+func (s *MemoryStore) SubStore(subFolder string) (Store, error) {
+	prefix := strings.Trim(path.Join(s.prefix, subFolder), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	baseURL := *s.baseURL
+	baseURL.Path = "/" + prefix
+	return newMemoryStore(&baseURL, prefix, s.objects, newConfig(s.options())), nil
+}
+
+func (s *MemoryStore) BaseURL() *url.URL {
+	return s.baseURL
+}
+
+func (s *MemoryStore) ObjectPath(name string) string {
+	return s.prefix + s.pathWithExt(name)
+}
+
+func (s *MemoryStore) ObjectURL(name string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(s.baseURL.String(), "/"), strings.TrimLeft(s.pathWithExt(name), "/"))
+}
+
+func (s *MemoryStore) toBaseName(key string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(key, s.prefix), s.pathWithExt(""))
+}
+
+func (s *MemoryStore) WriteObject(ctx context.Context, base string, reader io.Reader, opts ...WriteOption) error {
+	config := newWriteConfig(opts)
+	reader = config.trackProgress(reader)
+	if config.acl != "" {
+		return fmt.Errorf("object ACL: %w", ErrNotSupported)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	if err := s.compressedCopy(ctx, reader, buffer); err != nil {
+		return err
+	}
+
+	key := s.ObjectPath(base)
+
+	s.objects.lock.Lock()
+	defer s.objects.lock.Unlock()
+
+	// Checked under the lock so that concurrent writes of the same object
+	// without overwrite end up with a single winner.
+	if _, exists := s.objects.objects[key]; exists && !s.overwrite {
+		return nil
+	}
+
+	object := &memoryObject{
+		content:      buffer.Bytes(),
+		metadata:     copyMetadata(config.metadata),
+		lastModified: time.Now(),
+	}
+	s.objects.objects[key] = object
+	config.capture(newMemoryObjectAttrs(base, object))
+	return nil
+}
+
+func (s *MemoryStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) error {
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+func (s *MemoryStore) CopyObject(ctx context.Context, src, dst string) error {
+	return s.transfer(s.ObjectPath(src), s.ObjectPath(dst), false)
+}
+
+func (s *MemoryStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	return s.transfer(s.ObjectPath(oldName), s.ObjectPath(newName), true)
+}
+
+// transfer copies, or moves when `move` is set, the object at `srcKey` to
+// `dstKey` atomically. Like the other stores, a move onto an existing object of
+// a store not allowing overwrites only removes the source.
+func (s *MemoryStore) transfer(srcKey, dstKey string, move bool) error {
+	s.objects.lock.Lock()
+	defer s.objects.lock.Unlock()
+
+	object, found := s.objects.objects[srcKey]
+	if !found {
+		return ErrNotFound
+	}
+	if srcKey == dstKey {
+		return nil
+	}
+
+	if _, exists := s.objects.objects[dstKey]; !exists || s.overwrite {
+		s.objects.objects[dstKey] = &memoryObject{
+			content:      object.content,
+			metadata:     object.metadata,
+			lastModified: time.Now(),
+		}
+	}
+
+	if move {
+		delete(s.objects.objects, srcKey)
+	}
+	return nil
+}
+
+func (s *MemoryStore) get(name string) (*memoryObject, error) {
+	s.objects.lock.RLock()
+	defer s.objects.lock.RUnlock()
+
+	object, found := s.objects.objects[s.ObjectPath(name)]
+	if !found {
+		return nil, ErrNotFound
+	}
+	return object, nil
+}
+
+func (s *MemoryStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	object, err := s.get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.uncompressedReader(ctx, ioutil.NopCloser(bytes.NewReader(object.content)))
+}
+
+func (s *MemoryStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	if s.decompressesReads() {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+	reader, err := s.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.throttleReads(ctx, reader), nil
+}
+
+func (s *MemoryStore) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	object, err := s.get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bytes.NewReader(object.content)
+	if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seeking to offset %d: %w", offset, err)
+	}
+
+	return limitReadCloser(ioutil.NopCloser(reader), length), nil
+}
+
+func (s *MemoryStore) FileExists(ctx context.Context, base string) (bool, error) {
+	_, err := s.get(base)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *MemoryStore) ObjectAttributes(ctx context.Context, base string) (*ObjectAttrs, error) {
+	object, err := s.get(base)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMemoryObjectAttrs(base, object), nil
+}
+
+func newMemoryObjectAttrs(name string, object *memoryObject) *ObjectAttrs {
+	return &ObjectAttrs{
+		Name:         name,
+		Size:         int64(len(object.content)),
+		LastModified: object.lastModified,
+		ETag:         fmt.Sprintf("%x", md5.Sum(object.content)),
+		Metadata:     copyMetadata(object.metadata),
+	}
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	out := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		out[key] = value
+	}
+	return out
+}
+
+func (s *MemoryStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (s *MemoryStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (s *MemoryStore) ListFiles(ctx context.Context, prefix string, max int) ([]string, error) {
+	return listFiles(ctx, s, prefix, max)
+}
+
+func (s *MemoryStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	return listFilesPage(ctx, s, prefix, pageSize, pageToken)
+}
+
+func (s *MemoryStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	prefix = strings.Trim(prefix, "/")
+
+	keyPrefix := s.prefix
+	if prefix != "" {
+		keyPrefix += prefix + "/"
+	}
+
+	s.objects.lock.RLock()
+	seen := map[string]bool{}
+	for key := range s.objects.objects {
+		if !strings.HasPrefix(key, keyPrefix) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(key, keyPrefix)
+		if index := strings.Index(rest, "/"); index > 0 {
+			seen[path.Join(prefix, rest[:index])] = true
+		}
+	}
+	s.objects.lock.RUnlock()
+
+	for dir := range seen {
+		out = append(out, dir)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (s *MemoryStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
+	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
+}
+
+func (s *MemoryStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error {
+	return commonWalkBetween(s, ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *MemoryStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error {
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+// WalkObjects walks a snapshot of the objects taken when the walk starts, in
+// lexicographical order, so `f` is free to write or delete objects of the
+// store.
+func (s *MemoryStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) error {
+	f = skipPrefixes(f)
+	keyPrefix := s.prefix + prefix
+
+	s.objects.lock.RLock()
+	var keys []string
+	snapshot := map[string]*memoryObject{}
+	for key, object := range s.objects.objects {
+		if strings.HasPrefix(key, keyPrefix) {
+			keys = append(keys, key)
+			snapshot[key] = object
+		}
+	}
+	s.objects.lock.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := f(newMemoryObjectAttrs(s.toBaseName(key), snapshot[key])); err != nil {
+			if err == StopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteObject(ctx context.Context, base string) error {
+	key := s.ObjectPath(base)
+
+	s.objects.lock.Lock()
+	defer s.objects.lock.Unlock()
+
+	if _, found := s.objects.objects[key]; !found {
+		return ErrNotFound
+	}
+	delete(s.objects.objects, key)
+	return nil
+}
+
+func (s *MemoryStore) DeleteObjects(ctx context.Context, names []string) error {
+	s.objects.lock.Lock()
+	defer s.objects.lock.Unlock()
+
+	for _, name := range names {
+		delete(s.objects.objects, s.ObjectPath(name))
+	}
+	return nil
+}
+
+// DeletePrefix removes all the objects under `prefix` at once, no listing
+// being involved.
+func (s *MemoryStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	keyPrefix := s.prefix + prefix
+
+	s.objects.lock.Lock()
+	for key := range s.objects.objects {
+		if strings.HasPrefix(key, keyPrefix) {
+			delete(s.objects.objects, key)
+			deleted++
+		}
+	}
+	s.objects.lock.Unlock()
+
+	if tracker := deleteProgressTracker(ctx); tracker != nil {
+		tracker.add(int64(deleted), true)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,59 @@
+package dstore
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_SubStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(Extension("dbin"))
+
+	sub, err := store.SubStore("sub/folder")
+	require.NoError(t, err)
+	assert.Equal(t, "memory:///sub/folder/file.dbin", sub.ObjectURL("file"))
+
+	require.NoError(t, sub.WriteObject(ctx, "file", strings.NewReader("content")))
+
+	exists, err := store.FileExists(ctx, "sub/folder/file")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	dirs, err := store.ListDirectories(ctx, "sub")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sub/folder"}, dirs)
+
+	files, err := sub.ListFiles(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"file"}, files)
+}
+
+func TestMemoryStore_ConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, store.WriteObject(ctx, "same", strings.NewReader(fmt.Sprintf("writer-%02d", i))))
+		}(i)
+	}
+	wg.Wait()
+
+	reader, err := store.OpenObject(ctx, "same")
+	require.NoError(t, err)
+	defer reader.Close()
+
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Regexp(t, "^writer-[0-9]{2}$", string(content))
+}
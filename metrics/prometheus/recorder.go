@@ -0,0 +1,76 @@
+// Package prometheus exports the measures of a `dstore.MetricsStore` as
+// Prometheus metrics, kept apart from the root package so that only the
+// applications using it depend on the Prometheus client.
+package prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/streamingfast/dstore"
+)
+
+// Recorder is a `dstore.MetricsRecorder` exporting the measures of the
+// stores as Prometheus metrics, labeled with the name of the store and its
+// backend:
+//
+//   - `dstore_operations_total`, the operations by `operation`,
+//   - `dstore_operation_errors_total`, the failed operations by `operation`
+//     and `class`, the `dstore.ErrorClass` of their error,
+//   - `dstore_operation_duration_seconds`, the latency histogram of the
+//     operations by `operation`,
+//   - `dstore_bytes_total`, the bytes transferred by `operation`, "read" or
+//     "write".
+type Recorder struct {
+	operations *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	durations  *prometheus.HistogramVec
+	bytes      *prometheus.CounterVec
+}
+
+// NewRecorder returns a `Recorder` whose metrics are registered on
+// `registerer`, `prometheus.DefaultRegisterer` when nil.
+func NewRecorder(registerer prometheus.Registerer) (*Recorder, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	r := &Recorder{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dstore_operations_total",
+			Help: "Number of store operations.",
+		}, []string{"store", "backend", "operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dstore_operation_errors_total",
+			Help: "Number of failed store operations, by error class.",
+		}, []string{"store", "backend", "operation", "class"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "dstore_operation_duration_seconds",
+			Help:    "Latency of store operations.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"store", "backend", "operation"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dstore_bytes_total",
+			Help: "Number of bytes read and written, before compression.",
+		}, []string{"store", "backend", "operation"}),
+	}
+
+	for _, collector := range []prometheus.Collector{r.operations, r.errors, r.durations, r.bytes} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *Recorder) ObserveOperation(store, backend, operation string, duration time.Duration, err error) {
+	r.operations.WithLabelValues(store, backend, operation).Inc()
+	r.durations.WithLabelValues(store, backend, operation).Observe(duration.Seconds())
+	if err != nil {
+		r.errors.WithLabelValues(store, backend, operation, dstore.ErrorClass(err)).Inc()
+	}
+}
+
+func (r *Recorder) ObserveBytes(store, backend, operation string, n int64) {
+	r.bytes.WithLabelValues(store, backend, operation).Add(float64(n))
+}
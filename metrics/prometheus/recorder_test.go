@@ -0,0 +1,39 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	ctx := context.Background()
+	registry := prometheus.NewRegistry()
+	recorder, err := NewRecorder(registry)
+	require.NoError(t, err)
+
+	store := dstore.NewMetricsStore(dstore.NewMemoryStore(), "blocks", recorder)
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader([]byte("content"))))
+	content, err := dstore.ReadObject(ctx, store, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+	_, err = store.OpenObject(ctx, "missing")
+	assert.Equal(t, dstore.ErrNotFound, err)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(recorder.operations.WithLabelValues("blocks", "memory", "write")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(recorder.operations.WithLabelValues("blocks", "memory", "open")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(recorder.errors.WithLabelValues("blocks", "memory", "open", dstore.ErrorClass(dstore.ErrNotFound))))
+	assert.Equal(t, float64(7), testutil.ToFloat64(recorder.bytes.WithLabelValues("blocks", "memory", "write")))
+	assert.Equal(t, float64(7), testutil.ToFloat64(recorder.bytes.WithLabelValues("blocks", "memory", "read")))
+	assert.Equal(t, 2, testutil.CollectAndCount(recorder.durations))
+
+	// Registering the metrics twice fails
+	_, err = NewRecorder(registry)
+	assert.Error(t, err)
+}
@@ -0,0 +1,222 @@
+package dstore
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+//
+// Metrics Store
+//
+
+// MetricsRecorder receives the measures of a `MetricsStore`, to be exported to
+// the metrics system of the application. Each measure is labeled with the
+// name given to the store and its backend, the scheme of its URL.
+//
+// It is called concurrently, from the goroutines using the store.
+type MetricsRecorder interface {
+	// ObserveOperation is called once an operation returned, with the time it
+	// took and its error, nil on success. `ErrorClass` turns the error into a
+	// label. The operations are "open", "exists", "attributes", "write",
+	// "push", "copy", "rename", "walk", "list", "delete", "delete_objects" and
+	// "delete_prefix".
+	ObserveOperation(store, backend, operation string, duration time.Duration, err error)
+	// ObserveBytes is called as objects are read and written, with the number
+	// of bytes just transferred, `operation` being "read" or "write".
+	ObserveBytes(store, backend, operation string, n int64)
+}
+
+// MetricsStore is a `Store` measuring the operations on the inner store:
+// their count, errors and latency, and the bytes read and written, as seen by
+// the caller. The latency of opening an object does not include reading it,
+// while the latency of walks includes the time spent in the callback.
+type MetricsStore struct {
+	// Store is the inner store, measured.
+	Store
+
+	name     string
+	backend  string
+	recorder MetricsRecorder
+}
+
+// NewMetricsStore returns a `MetricsStore` reporting the operations on
+// `inner` to `recorder`, labeled with `name`.
+func NewMetricsStore(inner Store, name string, recorder MetricsRecorder) *MetricsStore {
+	backend := "file"
+	if baseURL := inner.BaseURL(); baseURL != nil && baseURL.Scheme != "" {
+		backend = baseURL.Scheme
+	}
+
+	return &MetricsStore{
+		Store:    inner,
+		name:     name,
+		backend:  backend,
+		recorder: recorder,
+	}
+}
+
+// observe reports the `operation` started at `start`, deferred with the
+// address of the error returned.
+func (s *MetricsStore) observe(operation string, start time.Time, err *error) {
+	s.recorder.ObserveOperation(s.name, s.backend, operation, time.Since(start), *err)
+}
+
+func (s *MetricsStore) observeBytes(operation string) func(n int) {
+	return func(n int) {
+		if n > 0 {
+			s.recorder.ObserveBytes(s.name, s.backend, operation, int64(n))
+		}
+	}
+}
+
+func (s *MetricsStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := *s
+	sub.Store = inner
+	return &sub, nil
+}
+
+func (s *MetricsStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer s.observe("open", time.Now(), &err)
+
+	reader, err := s.Store.OpenObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsReadCloser{ReadCloser: reader, observe: s.observeBytes("read")}, nil
+}
+
+func (s *MetricsStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer s.observe("open", time.Now(), &err)
+
+	reader, err := s.Store.OpenObjectRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsReadCloser{ReadCloser: reader, observe: s.observeBytes("read")}, nil
+}
+
+func (s *MetricsStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer s.observe("exists", time.Now(), &err)
+	return s.Store.FileExists(ctx, base)
+}
+
+func (s *MetricsStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	defer s.observe("attributes", time.Now(), &err)
+	return s.Store.ObjectAttributes(ctx, base)
+}
+
+// WriteObject counts the bytes of `f` consumed by the inner store, before
+// compression. Seekable content stays seekable, so that the stores relying on
+// it keep doing so.
+func (s *MetricsStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	defer s.observe("write", time.Now(), &err)
+
+	counted := &metricsReader{Reader: f, observe: s.observeBytes("write")}
+	if seeker, ok := f.(io.ReadSeeker); ok {
+		return s.Store.WriteObject(ctx, base, &metricsReadSeeker{metricsReader: counted, seeker: seeker}, opts...)
+	}
+	return s.Store.WriteObject(ctx, base, counted, opts...)
+}
+
+func (s *MetricsStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer s.observe("push", time.Now(), &err)
+	return s.Store.PushLocalFile(ctx, localFile, toBaseName)
+}
+
+func (s *MetricsStore) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer s.observe("copy", time.Now(), &err)
+	return s.Store.CopyObject(ctx, src, dst)
+}
+
+func (s *MetricsStore) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer s.observe("rename", time.Now(), &err)
+	return s.Store.RenameObject(ctx, oldName, newName)
+}
+
+func (s *MetricsStore) DeleteObject(ctx context.Context, base string) (err error) {
+	defer s.observe("delete", time.Now(), &err)
+	return s.Store.DeleteObject(ctx, base)
+}
+
+func (s *MetricsStore) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer s.observe("delete_objects", time.Now(), &err)
+	return s.Store.DeleteObjects(ctx, names)
+}
+
+func (s *MetricsStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer s.observe("delete_prefix", time.Now(), &err)
+	return s.Store.DeletePrefix(ctx, prefix)
+}
+
+func (s *MetricsStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer s.observe("walk", time.Now(), &err)
+	return s.Store.WalkFrom(ctx, prefix, startingPoint, f)
+}
+
+func (s *MetricsStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer s.observe("walk", time.Now(), &err)
+	return s.Store.WalkBetween(ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *MetricsStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer s.observe("walk", time.Now(), &err)
+	return s.Store.Walk(ctx, prefix, f)
+}
+
+func (s *MetricsStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer s.observe("walk", time.Now(), &err)
+	return s.Store.WalkObjects(ctx, prefix, f)
+}
+
+func (s *MetricsStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer s.observe("list", time.Now(), &err)
+	return s.Store.ListFiles(ctx, prefix, max)
+}
+
+func (s *MetricsStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer s.observe("list", time.Now(), &err)
+	return s.Store.ListFilesPage(ctx, prefix, pageSize, pageToken)
+}
+
+func (s *MetricsStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer s.observe("list", time.Now(), &err)
+	return s.Store.ListDirectories(ctx, prefix)
+}
+
+// metricsReader reports the bytes read through it.
+type metricsReader struct {
+	io.Reader
+	observe func(n int)
+}
+
+func (r *metricsReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.observe(n)
+	return n, err
+}
+
+type metricsReadSeeker struct {
+	*metricsReader
+	seeker io.Seeker
+}
+
+func (r *metricsReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
+
+type metricsReadCloser struct {
+	io.ReadCloser
+	observe func(n int)
+}
+
+func (r *metricsReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.observe(n)
+	return n, err
+}
@@ -0,0 +1,86 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsStore(t *testing.T) {
+	ctx := context.Background()
+	recorder := &recordingRecorder{bytes: map[string]int64{}}
+	store := NewMetricsStore(NewMemoryStore(), "blocks", recorder)
+
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader([]byte("content"))))
+	require.NoError(t, store.WriteObject(ctx, "other", strings.NewReader("more")))
+
+	reader, err := store.OpenObject(ctx, "file")
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	_, err = store.OpenObject(ctx, "missing")
+	require.Error(t, err)
+
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+	_, err = sub.FileExists(ctx, "file")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"write:", "write:", "open:", "open:not_found", "exists:"}, recorder.operations)
+	assert.Equal(t, map[string]int64{"write": 11, "read": 7}, recorder.bytes)
+	assert.Equal(t, []string{"blocks/memory"}, recorder.labels())
+}
+
+func TestErrorClass(t *testing.T) {
+	assert.Equal(t, "", ErrorClass(nil))
+	assert.Equal(t, "not_found", ErrorClass(ErrNotFound))
+	assert.Equal(t, "canceled", ErrorClass(context.Canceled))
+	assert.Equal(t, "rate_limited", ErrorClass(newStatusError(429, assert.AnError)))
+	assert.Equal(t, "transient", ErrorClass(newStatusError(503, assert.AnError)))
+	assert.Equal(t, "permission_denied", ErrorClass(newStatusError(403, assert.AnError)))
+	assert.Equal(t, "other", ErrorClass(assert.AnError))
+}
+
+type recordingRecorder struct {
+	lock       sync.Mutex
+	stores     map[string]bool
+	operations []string
+	bytes      map[string]int64
+}
+
+func (r *recordingRecorder) ObserveOperation(store, backend, operation string, duration time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.label(store, backend)
+	r.operations = append(r.operations, operation+":"+ErrorClass(err))
+}
+
+func (r *recordingRecorder) ObserveBytes(store, backend, operation string, n int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.label(store, backend)
+	r.bytes[operation] += n
+}
+
+func (r *recordingRecorder) label(store, backend string) {
+	if r.stores == nil {
+		r.stores = map[string]bool{}
+	}
+	r.stores[store+"/"+backend] = true
+}
+
+func (r *recordingRecorder) labels() (out []string) {
+	for label := range r.stores {
+		out = append(out, label)
+	}
+	return out
+}
@@ -0,0 +1,428 @@
+package dstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//
+// Mirror Store
+//
+
+const (
+	mirrorDefaultRepairQueueSize = 1000
+	mirrorDefaultRepairAttempts  = 3
+)
+
+// mirrorRepairDelay is the delay before the first retry of a failed repair,
+// doubled after every attempt.
+var mirrorRepairDelay = time.Second
+
+// MirrorPolicy configures how a `MirrorStore` handles the failure of some of
+// its stores.
+type MirrorPolicy struct {
+	// BestEffort makes operations succeed as soon as one store succeeded, the
+	// stores that failed being repaired asynchronously from one that
+	// succeeded. Otherwise, operations fail when any store failed, without
+	// rolling back the stores that succeeded.
+	BestEffort bool
+	// RepairQueueSize is the number of pending repairs above which new ones
+	// are dropped, 1000 by default.
+	RepairQueueSize int
+	// RepairAttempts is the number of times a repair is tried before giving
+	// up, 3 by default.
+	RepairAttempts int
+}
+
+// MirrorStore is a `Store` writing every object to all of its stores, for
+// example buckets in different regions. Writes, copies, renames and deletions
+// are performed on every store concurrently, reads, listings and attributes
+// come from the first store.
+type MirrorStore struct {
+	// Store is the first store, serving every operation not fanned out.
+	Store
+
+	stores  []Store
+	policy  MirrorPolicy
+	repairs *mirrorRepairs
+}
+
+type mirrorRepairKind int
+
+const (
+	// mirrorRepairSync copies the object from the source store
+	mirrorRepairSync mirrorRepairKind = iota
+	mirrorRepairDelete
+	mirrorRepairDeletePrefix
+)
+
+// mirrorRepair brings `name` on `store` back in line with the other stores.
+type mirrorRepair struct {
+	kind   mirrorRepairKind
+	store  Store
+	source Store
+	name   string
+}
+
+// mirrorRepairs is the queue of pending repairs, shared with sub stores.
+type mirrorRepairs struct {
+	lock     sync.Mutex
+	closed   bool
+	queue    chan *mirrorRepair
+	attempts int
+	done     chan struct{}
+}
+
+func NewMirrorStore(stores []Store, policy MirrorPolicy) (*MirrorStore, error) {
+	if len(stores) == 0 {
+		return nil, fmt.Errorf("mirror store requires at least one store")
+	}
+	if policy.RepairQueueSize == 0 {
+		policy.RepairQueueSize = mirrorDefaultRepairQueueSize
+	}
+	if policy.RepairAttempts == 0 {
+		policy.RepairAttempts = mirrorDefaultRepairAttempts
+	}
+
+	s := &MirrorStore{
+		Store:  stores[0],
+		stores: stores,
+		policy: policy,
+	}
+	if policy.BestEffort {
+		s.repairs = &mirrorRepairs{
+			queue:    make(chan *mirrorRepair, policy.RepairQueueSize),
+			attempts: policy.RepairAttempts,
+			done:     make(chan struct{}),
+		}
+		go s.repairs.run()
+	}
+
+	return s, nil
+}
+
+// SubStore returns a mirror store on the sub stores of every store, sharing
+// the repair queue of this one.
+func (s *MirrorStore) SubStore(subFolder string) (Store, error) {
+	stores := make([]Store, len(s.stores))
+	for i, store := range s.stores {
+		sub, err := store.SubStore(subFolder)
+		if err != nil {
+			return nil, fmt.Errorf("sub store of %s: %w", store.BaseURL(), err)
+		}
+		stores[i] = sub
+	}
+
+	return &MirrorStore{Store: stores[0], stores: stores, policy: s.policy, repairs: s.repairs}, nil
+}
+
+// Close waits for the pending repairs to complete, no repairs are queued
+// afterwards. It must be called on the store returned by `NewMirrorStore`
+// once its sub stores are not used anymore.
+func (s *MirrorStore) Close() error {
+	if s.repairs == nil {
+		return nil
+	}
+
+	s.repairs.lock.Lock()
+	if !s.repairs.closed {
+		s.repairs.closed = true
+		close(s.repairs.queue)
+	}
+	s.repairs.lock.Unlock()
+
+	<-s.repairs.done
+	return nil
+}
+
+func (r *mirrorRepairs) enqueue(repair *mirrorRepair) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		storeLogger(repair.store).Warn("mirror store closed, dropping repair", zap.Stringer("store", repair.store.BaseURL()), zap.String("name", repair.name))
+		return
+	}
+
+	select {
+	case r.queue <- repair:
+	default:
+		storeLogger(repair.store).Warn("mirror repair queue full, dropping repair", zap.Stringer("store", repair.store.BaseURL()), zap.String("name", repair.name))
+	}
+}
+
+func (r *mirrorRepairs) run() {
+	defer close(r.done)
+
+	for repair := range r.queue {
+		delay := mirrorRepairDelay
+		for attempt := 1; ; attempt++ {
+			err := repair.run(context.Background())
+			if err == nil {
+				break
+			}
+
+			if attempt == r.attempts {
+				storeLogger(repair.store).Warn("unable to repair mirror store, giving up", zap.Stringer("store", repair.store.BaseURL()), zap.String("name", repair.name), zap.Error(err))
+				break
+			}
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+func (r *mirrorRepair) run(ctx context.Context) error {
+	switch r.kind {
+	case mirrorRepairSync:
+		err := Copy(ctx, r.source, r.name, r.store, r.name)
+		if err == ErrNotFound {
+			// Deleted from the source since, so it must not be on the store either
+			err = r.store.DeleteObject(ctx, r.name)
+		}
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	case mirrorRepairDelete:
+		if err := r.store.DeleteObject(ctx, r.name); err != nil && err != ErrNotFound {
+			return err
+		}
+		return nil
+	case mirrorRepairDeletePrefix:
+		_, err := r.store.DeletePrefix(ctx, r.name)
+		return err
+	}
+	panic(fmt.Errorf("unknown mirror repair kind %d", r.kind))
+}
+
+// fanOut calls `f` on every store concurrently, returning the error of each
+// store.
+func (s *MirrorStore) fanOut(f func(i int, store Store) error) []error {
+	errs := make([]error, len(s.stores))
+
+	var wg sync.WaitGroup
+	for i, store := range s.stores {
+		wg.Add(1)
+		go func(i int, store Store) {
+			defer wg.Done()
+			errs[i] = f(i, store)
+		}(i, store)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// settle turns the errors of an operation on every store into the error of
+// the mirror store according to the policy, queuing the repairs returned by
+// `repair` for each failed store in best effort mode.
+func (s *MirrorStore) settle(op string, errs []error, repair func(store, source Store) []*mirrorRepair) error {
+	var source Store
+	var firstErr error
+	var failed []Store
+	for i, err := range errs {
+		if err == nil {
+			if source == nil {
+				source = s.stores[i]
+			}
+			continue
+		}
+
+		if firstErr == nil {
+			firstErr = fmt.Errorf("%s on %s: %w", op, s.stores[i].BaseURL(), err)
+		}
+		failed = append(failed, s.stores[i])
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+	if len(failed) == len(s.stores) && allNotFound(errs) {
+		return ErrNotFound
+	}
+	if !s.policy.BestEffort || source == nil {
+		return fmt.Errorf("%d of %d mirror stores failed, first %w", len(failed), len(s.stores), firstErr)
+	}
+
+	storeLogger(s.Store).Warn("mirror stores failed, repairing them asynchronously", zap.String("op", op), zap.Int("failed", len(failed)), zap.Error(firstErr))
+	for _, store := range failed {
+		for _, r := range repair(store, source) {
+			s.repairs.enqueue(r)
+		}
+	}
+	return nil
+}
+
+func allNotFound(errs []error) bool {
+	for _, err := range errs {
+		if err != ErrNotFound {
+			return false
+		}
+	}
+	return true
+}
+
+func syncRepair(name string) func(store, source Store) []*mirrorRepair {
+	return func(store, source Store) []*mirrorRepair {
+		return []*mirrorRepair{{kind: mirrorRepairSync, store: store, source: source, name: name}}
+	}
+}
+
+func deleteRepair(names ...string) func(store, source Store) []*mirrorRepair {
+	return func(store, source Store) []*mirrorRepair {
+		repairs := make([]*mirrorRepair, len(names))
+		for i, name := range names {
+			repairs[i] = &mirrorRepair{kind: mirrorRepairDelete, store: store, name: name}
+		}
+		return repairs
+	}
+}
+
+// ignoreNotFound treats objects already missing from some stores as deleted,
+// unless they're missing from all of them.
+func ignoreNotFound(errs []error) []error {
+	if allNotFound(errs) {
+		return errs
+	}
+	for i, err := range errs {
+		if err == ErrNotFound {
+			errs[i] = nil
+		}
+	}
+	return errs
+}
+
+func (s *MirrorStore) SetOverwrite(enabled bool) {
+	for _, store := range s.stores {
+		store.SetOverwrite(enabled)
+	}
+}
+
+// WriteObject streams the content to every store at once, so it's read only
+// once, a store failing doesn't interrupt the writes to the other ones.
+func (s *MirrorStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	pipeWriters := make([]*io.PipeWriter, len(s.stores))
+	pipeReaders := make([]*io.PipeReader, len(s.stores))
+	for i := range s.stores {
+		pipeReaders[i], pipeWriters[i] = io.Pipe()
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(s.stores))
+	for i, store := range s.stores {
+		wg.Add(1)
+		go func(i int, store Store) {
+			defer wg.Done()
+			errs[i] = store.WriteObject(ctx, base, pipeReaders[i], opts...)
+			// Stores that return without reading everything, like when the
+			// object exists and overwrite is disabled, must not block the others
+			pipeReaders[i].Close()
+		}(i, store)
+	}
+
+	copyErr := mirrorCopy(f, pipeWriters)
+	for _, pipeWrite := range pipeWriters {
+		pipeWrite.CloseWithError(copyErr)
+	}
+	wg.Wait()
+
+	if copyErr != nil {
+		return copyErr
+	}
+	return s.settle("write", errs, syncRepair(base))
+}
+
+// mirrorCopy copies `r` to all the writers, dropping the ones failing.
+func mirrorCopy(r io.Reader, writers []*io.PipeWriter) error {
+	active := make([]bool, len(writers))
+	for i := range active {
+		active[i] = true
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			for i, w := range writers {
+				if active[i] {
+					if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+						active[i] = false
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *MirrorStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+func (s *MirrorStore) CopyObject(ctx context.Context, src, dst string) error {
+	errs := s.fanOut(func(_ int, store Store) error {
+		return store.CopyObject(ctx, src, dst)
+	})
+	return s.settle("copy", errs, syncRepair(dst))
+}
+
+func (s *MirrorStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	errs := s.fanOut(func(_ int, store Store) error {
+		return store.RenameObject(ctx, oldName, newName)
+	})
+	return s.settle("rename", errs, func(store, source Store) []*mirrorRepair {
+		return append(syncRepair(newName)(store, source), deleteRepair(oldName)(store, source)...)
+	})
+}
+
+func (s *MirrorStore) DeleteObject(ctx context.Context, base string) error {
+	errs := s.fanOut(func(_ int, store Store) error {
+		return store.DeleteObject(ctx, base)
+	})
+	return s.settle("delete", ignoreNotFound(errs), deleteRepair(base))
+}
+
+func (s *MirrorStore) DeleteObjects(ctx context.Context, names []string) error {
+	errs := s.fanOut(func(_ int, store Store) error {
+		return store.DeleteObjects(ctx, names)
+	})
+	return s.settle("delete objects", errs, deleteRepair(names...))
+}
+
+// DeletePrefix returns the number of objects deleted from the first store
+// that succeeded.
+func (s *MirrorStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	counts := make([]int, len(s.stores))
+	errs := s.fanOut(func(i int, store Store) (err error) {
+		counts[i], err = store.DeletePrefix(ctx, prefix)
+		return err
+	})
+
+	err = s.settle("delete prefix", errs, func(store, source Store) []*mirrorRepair {
+		return []*mirrorRepair{{kind: mirrorRepairDeletePrefix, store: store, name: prefix}}
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, storeErr := range errs {
+		if storeErr == nil {
+			return counts[i], nil
+		}
+	}
+	return 0, nil
+}
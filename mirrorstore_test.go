@@ -0,0 +1,163 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingStore is a memory store failing the next `failures` writes and
+// deletions.
+type failingStore struct {
+	*MemoryStore
+
+	lock     sync.Mutex
+	failures int
+}
+
+func (s *failingStore) fail() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return fmt.Errorf("unavailable")
+	}
+	return nil
+}
+
+func (s *failingStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+	return s.MemoryStore.WriteObject(ctx, base, f, opts...)
+}
+
+func (s *failingStore) DeleteObject(ctx context.Context, base string) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+	return s.MemoryStore.DeleteObject(ctx, base)
+}
+
+func TestMirrorStore_WriteObject(t *testing.T) {
+	ctx := context.Background()
+	first, second := NewMemoryStore(), NewMemoryStore(Compression("zstd"))
+
+	store, err := NewMirrorStore([]Store{first, second}, MirrorPolicy{})
+	require.NoError(t, err)
+	defer store.Close()
+
+	written := strings.Repeat("0123456789", 10000)
+	require.NoError(t, store.WriteObject(ctx, "file", strings.NewReader(written)))
+	content, err := ReadObject(ctx, first, "file")
+	require.NoError(t, err)
+	assert.Equal(t, written, string(content))
+	content, err = ReadObject(ctx, second, "file")
+	require.NoError(t, err)
+	assert.Equal(t, written, string(content))
+
+	// A store skipping the write doesn't block the other ones
+	require.NoError(t, second.WriteObject(ctx, "existing", strings.NewReader("kept")))
+	require.NoError(t, store.WriteObject(ctx, "existing", bytes.NewReader(make([]byte, 1024*1024))))
+	content, err = ReadObject(ctx, first, "existing")
+	require.NoError(t, err)
+	assert.Len(t, content, 1024*1024)
+	content, err = ReadObject(ctx, second, "existing")
+	require.NoError(t, err)
+	assert.Equal(t, "kept", string(content))
+
+	require.NoError(t, store.RenameObject(ctx, "file", "renamed"))
+	for _, mirror := range []Store{first, second} {
+		exists, err := mirror.FileExists(ctx, "renamed")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	}
+
+	deleted, err := store.DeletePrefix(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, ErrNotFound, store.DeleteObject(ctx, "renamed"))
+}
+
+func TestMirrorStore_AllMustSucceed(t *testing.T) {
+	ctx := context.Background()
+	first, second := NewMemoryStore(), &failingStore{MemoryStore: NewMemoryStore(), failures: 1}
+
+	store, err := NewMirrorStore([]Store{first, second}, MirrorPolicy{})
+	require.NoError(t, err)
+	defer store.Close()
+
+	err = store.WriteObject(ctx, "file", strings.NewReader("content"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 2 mirror stores failed")
+
+	require.NoError(t, store.WriteObject(ctx, "file", strings.NewReader("content")))
+	content, err := ReadObject(ctx, second, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
+
+func TestMirrorStore_BestEffort(t *testing.T) {
+	previousDelay := mirrorRepairDelay
+	mirrorRepairDelay = time.Millisecond
+	defer func() { mirrorRepairDelay = previousDelay }()
+
+	ctx := context.Background()
+	first, second := NewMemoryStore(), &failingStore{MemoryStore: NewMemoryStore(), failures: 2}
+
+	store, err := NewMirrorStore([]Store{first, second}, MirrorPolicy{BestEffort: true})
+	require.NoError(t, err)
+
+	// The write and the first repair attempt fail, the second one succeeds
+	require.NoError(t, store.WriteObject(ctx, "file", strings.NewReader("content")))
+	require.NoError(t, first.WriteObject(ctx, "other", strings.NewReader("other")))
+	require.NoError(t, second.MemoryStore.WriteObject(ctx, "other", strings.NewReader("other")))
+
+	second.lock.Lock()
+	second.failures = 1
+	second.lock.Unlock()
+	require.NoError(t, store.DeleteObject(ctx, "other"))
+	require.NoError(t, store.Close())
+
+	content, err := ReadObject(ctx, second, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+	exists, err := second.FileExists(ctx, "other")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Failing on all stores fails the operation
+	all, err := NewMirrorStore([]Store{&failingStore{MemoryStore: NewMemoryStore(), failures: 1}}, MirrorPolicy{BestEffort: true})
+	require.NoError(t, err)
+	defer all.Close()
+	require.Error(t, all.WriteObject(ctx, "file", strings.NewReader("content")))
+}
+
+func TestMirrorStore_SubStore(t *testing.T) {
+	ctx := context.Background()
+	first, second := NewMemoryStore(), NewMemoryStore()
+
+	store, err := NewMirrorStore([]Store{first, second}, MirrorPolicy{})
+	require.NoError(t, err)
+	defer store.Close()
+
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+	require.NoError(t, sub.WriteObject(ctx, "file", strings.NewReader("content")))
+
+	content, err := ReadObject(ctx, first, "sub/file")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+	content, err = ReadObject(ctx, second, "sub/file")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
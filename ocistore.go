@@ -0,0 +1,781 @@
+//go:build !dstore_no_oci
+
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/common/auth"
+	"github.com/oracle/oci-go-sdk/v65/objectstorage"
+	"go.uber.org/zap"
+)
+
+// ociDefaultPartSize is the part size of multipart uploads when no
+// `MultipartThreshold` is configured, objects up to that size being written
+// with a single `PutObject`.
+const ociDefaultPartSize = 10 * 1024 * 1024
+
+// ociCopyPollInterval is the maximum delay between two checks of the work
+// request of a copy, OCI copies being asynchronous.
+var ociCopyPollInterval = 2 * time.Second
+
+//
+// Oracle Cloud Infrastructure Object Storage Store
+//
+
+func init() {
+	registerBackend(&backend{
+		schemes: []string{"oci"},
+		newStore: func(base *url.URL, opts ...Option) (Store, error) {
+			store, err := NewOCIStoreWithOptions(base, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		},
+		errorClass: ociErrorClass,
+	})
+}
+
+// ociErrorClass returns the class of the errors answered by OCI Object
+// Storage.
+func ociErrorClass(err error) error {
+	var ociErr common.ServiceError
+	if errors.As(err, &ociErr) {
+		return statusClass(ociErr.GetHTTPStatusCode())
+	}
+	return nil
+}
+
+type OCIStore struct {
+	baseURL *url.URL
+
+	namespace string
+	bucket    string
+	path      string
+	region    string
+	client    *objectstorage.ObjectStorageClient
+	partSize  int64
+
+	*commonStore
+}
+
+func NewOCIStore(baseURL *url.URL, extension, compressionType string, overwrite bool) (*OCIStore, error) {
+	return NewOCIStoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+// NewOCIStoreWithOptions creates a store on the `oci://namespace/bucket/path`
+// URL through OCI's native Object Storage API. It authenticates with the
+// compute instance's identity when the URL has the `auth=instance_principal`
+// query parameter, and with the OCI configuration file (`~/.oci/config` or
+// the one given through `CredentialsFile`) otherwise. The `region` query
+// parameter overrides the region of the configuration.
+func NewOCIStoreWithOptions(baseURL *url.URL, opts ...Option) (*OCIStore, error) {
+	config := newCloudConfig(opts)
+
+	provider, err := ociConfigurationProvider(baseURL, config.credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := objectstorage.NewObjectStorageClientWithConfigurationProvider(provider)
+	if err != nil {
+		return nil, fmt.Errorf("creating oci object storage client: %w", err)
+	}
+	if region := baseURL.Query().Get("region"); region != "" {
+		client.SetRegion(region)
+	}
+	// The SDK's default client times out after 60s including reading the
+	// body, which large downloads easily exceed
+	client.HTTPClient = &http.Client{}
+
+	return newOCIStore(baseURL, &client, config)
+}
+
+func ociConfigurationProvider(baseURL *url.URL, credentialsFile string) (common.ConfigurationProvider, error) {
+	switch baseURL.Query().Get("auth") {
+	case "instance_principal":
+		provider, err := auth.InstancePrincipalConfigurationProvider()
+		if err != nil {
+			return nil, fmt.Errorf("oci instance principal authentication: %w", err)
+		}
+		return provider, nil
+	case "":
+		if credentialsFile != "" {
+			return common.ConfigurationProviderFromFile(credentialsFile, "")
+		}
+		return common.DefaultConfigProvider(), nil
+	}
+	return nil, fmt.Errorf("unsupported oci auth %q, use instance_principal or leave empty to use the oci configuration file", baseURL.Query().Get("auth"))
+}
+
+func newOCIStore(baseURL *url.URL, client *objectstorage.ObjectStorageClient, config *config) (*OCIStore, error) {
+	parts := strings.SplitN(strings.Trim(baseURL.Path, "/"), "/", 2)
+	if baseURL.Host == "" || parts[0] == "" {
+		return nil, fmt.Errorf("specify oci bucket like: oci://namespace/bucket/path")
+	}
+
+	region := baseURL.Query().Get("region")
+	if region == "" {
+		var err error
+		if region, err = (*client.ConfigurationProvider()).Region(); err != nil {
+			return nil, fmt.Errorf("oci region: %w", err)
+		}
+	}
+
+	s := &OCIStore{
+		baseURL:     baseURL,
+		namespace:   baseURL.Host,
+		bucket:      parts[0],
+		region:      region,
+		client:      client,
+		partSize:    config.multipartThreshold,
+		commonStore: newCommonStore(baseURL, config),
+	}
+	if len(parts) > 1 {
+		s.path = strings.Trim(parts[1], "/")
+	}
+	if s.partSize == 0 {
+		s.partSize = ociDefaultPartSize
+	}
+
+	return s, nil
+}
+
+// SubStore shares the client of the parent store.
+func (s *OCIStore) SubStore(subFolder string) (Store, error) {
+	url, err := url.Parse(s.baseURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("oci store parsing base url: %w", err)
+	}
+	url.Path = path.Join(url.Path, subFolder)
+	return newOCIStore(url, s.client, newConfig(append(s.options(), MultipartThreshold(s.partSize))))
+}
+
+func (s *OCIStore) BaseURL() *url.URL {
+	return s.baseURL
+}
+
+func (s *OCIStore) ObjectPath(name string) string {
+	return path.Join(s.path, s.pathWithExt(name))
+}
+
+func (s *OCIStore) ObjectURL(name string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(s.baseURL.String(), "/"), strings.TrimLeft(s.pathWithExt(name), "/"))
+}
+
+func (s *OCIStore) toBaseName(filename string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(filename, s.pathWithExt("")), s.path+"/")
+}
+
+// walkPrefix returns the full object name prefix to list for the given store
+// relative prefix.
+func (s *OCIStore) walkPrefix(prefix string) string {
+	targetPrefix := s.path
+	if targetPrefix != "" {
+		targetPrefix += "/"
+	}
+	if prefix != "" {
+		targetPrefix = filepath.Join(targetPrefix, prefix)
+		if prefix[len(prefix)-1:] == "/" {
+			targetPrefix += "/"
+		}
+	}
+	return targetPrefix
+}
+
+func ociStatusCode(err error) int {
+	if serviceErr, ok := common.IsServiceError(err); ok {
+		return serviceErr.GetHTTPStatusCode()
+	}
+	return 0
+}
+
+// ociNotFound turns the service's 404 errors into `ErrNotFound`.
+func ociNotFound(err error) error {
+	if ociStatusCode(err) == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// isOCIPreconditionFailed reports whether the error is the service refusing a
+// write conditioned by `If-None-Match: *` because the object exists.
+func isOCIPreconditionFailed(err error) bool {
+	serviceErr, ok := common.IsServiceError(err)
+	if !ok {
+		return false
+	}
+	return serviceErr.GetHTTPStatusCode() == http.StatusPreconditionFailed || serviceErr.GetCode() == "IfNoneMatchFailed"
+}
+
+// ifNoneMatch returns the `If-None-Match` condition making writes fail when the
+// object exists, nil when overwrites are allowed.
+func (s *OCIStore) ifNoneMatch() *string {
+	if s.overwrite {
+		return nil
+	}
+	return common.String("*")
+}
+
+func (s *OCIStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return s.presign(ctx, base, objectstorage.CreatePreauthenticatedRequestDetailsAccessTypeObjectread, ttl)
+}
+
+func (s *OCIStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return s.presign(ctx, base, objectstorage.CreatePreauthenticatedRequestDetailsAccessTypeObjectwrite, ttl)
+}
+
+// presign creates a pre-authenticated request on the object, OCI's equivalent
+// of pre-signed URLs.
+func (s *OCIStore) presign(ctx context.Context, base string, accessType objectstorage.CreatePreauthenticatedRequestDetailsAccessTypeEnum, ttl time.Duration) (string, error) {
+	resp, err := s.client.CreatePreauthenticatedRequest(ctx, objectstorage.CreatePreauthenticatedRequestRequest{
+		NamespaceName: &s.namespace,
+		BucketName:    &s.bucket,
+		CreatePreauthenticatedRequestDetails: objectstorage.CreatePreauthenticatedRequestDetails{
+			Name:        common.String(fmt.Sprintf("dstore-%d", time.Now().UnixNano())),
+			ObjectName:  common.String(s.ObjectPath(base)),
+			AccessType:  accessType,
+			TimeExpires: &common.SDKTime{Time: time.Now().Add(ttl)},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating pre-authenticated request: %w", err)
+	}
+
+	return strings.TrimRight(s.client.Host, "/") + *resp.AccessUri, nil
+}
+
+func (s *OCIStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	defer classifyError(&err)
+	objectPath := s.ObjectPath(base)
+	config := newWriteConfig(opts)
+	f = config.trackProgress(f)
+	if config.acl != "" {
+		return fmt.Errorf("object ACL: %w", ErrNotSupported)
+	}
+
+	if !s.overwrite {
+		// Checked upfront to avoid uploading the whole object for nothing,
+		// the `If-None-Match` condition still guards against concurrent writers.
+		exists, err := s.FileExists(ctx, base)
+		if err != nil {
+			return err
+		}
+		if exists {
+			// We silently ignore when we ask not to overwrite
+			return nil
+		}
+	}
+
+	pipeRead, pipeWrite := io.Pipe()
+	writeDone := make(chan error, 1)
+	go func() {
+		err := s.compressedCopy(ctx, f, pipeWrite)
+		pipeWrite.CloseWithError(err)
+		writeDone <- err
+	}()
+
+	err = s.upload(ctx, objectPath, config, pipeRead)
+	pipeRead.Close()
+	if copyErr := <-writeDone; copyErr != nil && copyErr != io.ErrClosedPipe {
+		return copyErr
+	}
+	if isOCIPreconditionFailed(err) {
+		// Another writer created the object since our check
+		return nil
+	}
+	return err
+}
+
+// upload puts the content with a single request when it fits in one part, and
+// through a multipart upload otherwise, aborted on error.
+func (s *OCIStore) upload(ctx context.Context, objectPath string, config *writeConfig, content io.Reader) error {
+	contentType, cacheControl := s.contentHeaders(config, "", "")
+
+	head := &bytes.Buffer{}
+	if _, err := io.CopyN(head, content, s.partSize+1); err != nil && err != io.EOF {
+		return err
+	}
+
+	if int64(head.Len()) <= s.partSize {
+		request := objectstorage.PutObjectRequest{
+			NamespaceName: &s.namespace,
+			BucketName:    &s.bucket,
+			ObjectName:    &objectPath,
+			ContentLength: common.Int64(int64(head.Len())),
+			PutObjectBody: ioutil.NopCloser(head),
+			IfNoneMatch:   s.ifNoneMatch(),
+			OpcMeta:       config.metadata,
+		}
+		if contentType != "" {
+			request.ContentType = &contentType
+		}
+		if cacheControl != "" {
+			request.CacheControl = &cacheControl
+		}
+
+		_, err := s.client.PutObject(ctx, request)
+		return err
+	}
+
+	details := objectstorage.CreateMultipartUploadDetails{
+		Object:   &objectPath,
+		Metadata: config.metadata,
+	}
+	if contentType != "" {
+		details.ContentType = &contentType
+	}
+	if cacheControl != "" {
+		details.CacheControl = &cacheControl
+	}
+
+	upload, err := s.client.CreateMultipartUpload(ctx, objectstorage.CreateMultipartUploadRequest{
+		NamespaceName:                &s.namespace,
+		BucketName:                   &s.bucket,
+		CreateMultipartUploadDetails: details,
+		IfNoneMatch:                  s.ifNoneMatch(),
+	})
+	if err != nil {
+		return fmt.Errorf("creating multipart upload: %w", err)
+	}
+
+	if err := s.uploadParts(ctx, objectPath, upload.UploadId, io.MultiReader(head, content)); err != nil {
+		// Incomplete multipart uploads are billed until aborted
+		if _, abortErr := s.client.AbortMultipartUpload(context.Background(), objectstorage.AbortMultipartUploadRequest{
+			NamespaceName: &s.namespace,
+			BucketName:    &s.bucket,
+			ObjectName:    &objectPath,
+			UploadId:      upload.UploadId,
+		}); abortErr != nil {
+			s.logger.Warn("unable to abort multipart upload", zap.String("path", objectPath), zap.Error(abortErr))
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *OCIStore) uploadParts(ctx context.Context, objectPath string, uploadID *string, content io.Reader) error {
+	var parts []objectstorage.CommitMultipartUploadPartDetails
+	part := make([]byte, s.partSize)
+	for partNum := 1; ; partNum++ {
+		n, err := io.ReadFull(content, part)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		resp, err := s.client.UploadPart(ctx, objectstorage.UploadPartRequest{
+			NamespaceName:  &s.namespace,
+			BucketName:     &s.bucket,
+			ObjectName:     &objectPath,
+			UploadId:       uploadID,
+			UploadPartNum:  common.Int(partNum),
+			ContentLength:  common.Int64(int64(n)),
+			UploadPartBody: ioutil.NopCloser(bytes.NewReader(part[:n])),
+		})
+		if err != nil {
+			return fmt.Errorf("uploading part %d: %w", partNum, err)
+		}
+		parts = append(parts, objectstorage.CommitMultipartUploadPartDetails{PartNum: common.Int(partNum), Etag: resp.ETag})
+	}
+
+	_, err := s.client.CommitMultipartUpload(ctx, objectstorage.CommitMultipartUploadRequest{
+		NamespaceName:                &s.namespace,
+		BucketName:                   &s.bucket,
+		ObjectName:                   &objectPath,
+		UploadId:                     uploadID,
+		CommitMultipartUploadDetails: objectstorage.CommitMultipartUploadDetails{PartsToCommit: parts},
+		IfNoneMatch:                  s.ifNoneMatch(),
+	})
+	return err
+}
+
+func (s *OCIStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer classifyError(&err)
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+// CopyObject runs a server-side copy, which OCI performs asynchronously
+// through a work request polled until completion.
+func (s *OCIStore) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, s, dst); skip || err != nil {
+		return err
+	}
+
+	resp, err := s.client.CopyObject(ctx, objectstorage.CopyObjectRequest{
+		NamespaceName: &s.namespace,
+		BucketName:    &s.bucket,
+		CopyObjectDetails: objectstorage.CopyObjectDetails{
+			SourceObjectName:                 common.String(s.ObjectPath(src)),
+			DestinationRegion:                &s.region,
+			DestinationNamespace:             &s.namespace,
+			DestinationBucket:                &s.bucket,
+			DestinationObjectName:            common.String(s.ObjectPath(dst)),
+			DestinationObjectIfNoneMatchETag: s.ifNoneMatch(),
+		},
+	})
+	if err != nil {
+		return ociNotFound(err)
+	}
+
+	delay := 100 * time.Millisecond
+	for {
+		workRequest, err := s.client.GetWorkRequest(ctx, objectstorage.GetWorkRequestRequest{WorkRequestId: resp.OpcWorkRequestId})
+		if err != nil {
+			return fmt.Errorf("checking copy work request: %w", err)
+		}
+
+		switch workRequest.Status {
+		case objectstorage.WorkRequestStatusCompleted:
+			return nil
+		case objectstorage.WorkRequestStatusFailed, objectstorage.WorkRequestStatusCanceled:
+			if !s.overwrite {
+				// Another writer created the destination since our check
+				if exists, existsErr := s.FileExists(ctx, dst); existsErr == nil && exists {
+					return nil
+				}
+			}
+			return fmt.Errorf("copy work request %s: %s", *resp.OpcWorkRequestId, workRequest.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > ociCopyPollInterval {
+			delay = ociCopyPollInterval
+		}
+	}
+}
+
+// RenameObject uses the native rename, which is atomic.
+func (s *OCIStore) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer classifyError(&err)
+	_, err = s.client.RenameObject(ctx, objectstorage.RenameObjectRequest{
+		NamespaceName: &s.namespace,
+		BucketName:    &s.bucket,
+		RenameObjectDetails: objectstorage.RenameObjectDetails{
+			SourceName:            common.String(s.ObjectPath(oldName)),
+			NewName:               common.String(s.ObjectPath(newName)),
+			NewObjIfNoneMatchETag: s.ifNoneMatch(),
+		},
+	})
+	if isOCIPreconditionFailed(err) {
+		// Like other stores, the existing object is kept and the source removed
+		return s.DeleteObject(ctx, oldName)
+	}
+	return ociNotFound(err)
+}
+
+func (s *OCIStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	objectPath := s.ObjectPath(name)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file", zap.String("path", objectPath))
+	}
+
+	resp, err := s.client.GetObject(ctx, objectstorage.GetObjectRequest{
+		NamespaceName: &s.namespace,
+		BucketName:    &s.bucket,
+		ObjectName:    &objectPath,
+	})
+	if err != nil {
+		return nil, ociNotFound(err)
+	}
+
+	return s.uncompressedReader(ctx, s.resumeReads(ctx, name, resp.Content, s.openStoredRange))
+}
+
+func (s *OCIStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	if s.decompressesReads() {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+	reader, err := s.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.throttleReads(ctx, reader), nil
+}
+
+func (s *OCIStore) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+
+	resp, err := s.client.GetObject(ctx, objectstorage.GetObjectRequest{
+		NamespaceName: &s.namespace,
+		BucketName:    &s.bucket,
+		ObjectName:    common.String(s.ObjectPath(name)),
+		Range:         &byteRange,
+	})
+	if err != nil {
+		if ociStatusCode(err) == http.StatusRequestedRangeNotSatisfiable {
+			// The offset is past the end of the object
+			return ioutil.NopCloser(bytes.NewReader(nil)), nil
+		}
+		return nil, ociNotFound(err)
+	}
+
+	return resp.Content, nil
+}
+
+func (s *OCIStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer classifyError(&err)
+	if _, err := s.ObjectAttributes(ctx, base); err != nil {
+		if err == ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *OCIStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	defer classifyError(&err)
+	resp, err := s.client.HeadObject(ctx, objectstorage.HeadObjectRequest{
+		NamespaceName: &s.namespace,
+		BucketName:    &s.bucket,
+		ObjectName:    common.String(s.ObjectPath(base)),
+	})
+	if err != nil {
+		return nil, ociNotFound(err)
+	}
+
+	attrs = &ObjectAttrs{
+		Name: base,
+		Size: ociInt64(resp.ContentLength),
+		ETag: ociString(resp.ETag),
+	}
+	if resp.LastModified != nil {
+		attrs.LastModified = resp.LastModified.Time
+	}
+	if len(resp.OpcMeta) > 0 {
+		attrs.Metadata = make(map[string]string, len(resp.OpcMeta))
+		for key, value := range resp.OpcMeta {
+			attrs.Metadata[strings.ToLower(key)] = value
+		}
+	}
+	return attrs, nil
+}
+
+func newOCIObjectAttrs(name string, object objectstorage.ObjectSummary) *ObjectAttrs {
+	attrs := &ObjectAttrs{
+		Name: name,
+		Size: ociInt64(object.Size),
+		ETag: ociString(object.Etag),
+	}
+	if object.TimeModified != nil {
+		attrs.LastModified = object.TimeModified.Time
+	}
+	return attrs
+}
+
+func (s *OCIStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer classifyError(&err)
+	return listFiles(ctx, s, prefix, max)
+}
+
+func (s *OCIStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer classifyError(&err)
+	request := objectstorage.ListObjectsRequest{
+		NamespaceName: &s.namespace,
+		BucketName:    &s.bucket,
+		Prefix:        common.String(s.walkPrefix(prefix)),
+		Limit:         common.Int(pageSize),
+	}
+	if pageToken != "" {
+		request.Start = &pageToken
+	}
+
+	resp, err := s.client.ListObjects(ctx, request)
+	if err != nil {
+		return nil, "", fmt.Errorf("listing objects: %w", err)
+	}
+
+	for _, object := range resp.Objects {
+		if filename := s.toBaseName(*object.Name); filename != "" {
+			files = append(files, filename)
+		}
+	}
+	return files, ociString(resp.NextStartWith), nil
+}
+
+func (s *OCIStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer classifyError(&err)
+	request := objectstorage.ListObjectsRequest{
+		NamespaceName: &s.namespace,
+		BucketName:    &s.bucket,
+		Prefix:        common.String(directoryPrefix(s.path, prefix)),
+		Delimiter:     common.String("/"),
+	}
+
+	for {
+		resp, err := s.client.ListObjects(ctx, request)
+		if err != nil {
+			return nil, fmt.Errorf("listing directories: %w", err)
+		}
+
+		for _, prefix := range resp.Prefixes {
+			out = append(out, relativeDirectory(s.path, prefix))
+		}
+		if resp.NextStartWith == nil {
+			return out, nil
+		}
+		request.Start = resp.NextStartWith
+	}
+}
+
+func (s *OCIStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkBetween(ctx, prefix, startingPoint, "", f)
+}
+
+func (s *OCIStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkObjects(ctx, prefix, startingPoint, endPoint, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *OCIStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *OCIStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkObjects(ctx, prefix, "", "", f)
+}
+
+// walkObjects lists the objects under `prefix` between `startingPoint` and
+// `endPoint`, which map onto the inclusive `start` and exclusive `end` of the
+// listing, restarted at the end of prefixes skipped through `SkipPrefix`.
+func (s *OCIStore) walkObjects(ctx context.Context, prefix, startingPoint, endPoint string, f func(attrs *ObjectAttrs) (err error)) error {
+	basePrefix := s.walkPrefix("")
+
+	request := objectstorage.ListObjectsRequest{
+		NamespaceName: &s.namespace,
+		BucketName:    &s.bucket,
+		Prefix:        common.String(s.walkPrefix(prefix)),
+		Fields:        common.String("name,size,etag,timeModified"),
+	}
+	// Both bounds are store relative names, like the ones passed to `f`
+	if startingPoint != "" {
+		request.Start = common.String(basePrefix + startingPoint)
+	}
+	if endPoint != "" {
+		request.End = common.String(basePrefix + endPoint)
+	}
+
+	for {
+		if debugEnabled(s.logger) {
+			s.logger.Debug("walking files", zap.String("bucket", s.bucket), zap.String("prefix", *request.Prefix), zap.Stringp("start", request.Start))
+		}
+
+		resp, err := s.client.ListObjects(ctx, request)
+		if err != nil {
+			return fmt.Errorf("listing objects: %w", err)
+		}
+
+		request.Start = resp.NextStartWith
+		for _, object := range resp.Objects {
+			name := s.toBaseName(*object.Name)
+			if err := f(newOCIObjectAttrs(name, object)); err != nil {
+				if err == StopIteration {
+					return nil
+				}
+
+				skipped, ok := skippedPrefix(err)
+				if !ok {
+					return err
+				}
+
+				// Seek past the skipped prefix by restarting the listing after it
+				bound := prefixUpperBound(skipped)
+				if bound == "" || (endPoint != "" && bound >= endPoint) {
+					return nil
+				}
+				if bound > name {
+					request.Start = common.String(basePrefix + bound)
+					break
+				}
+			}
+		}
+
+		if request.Start == nil {
+			return nil
+		}
+	}
+}
+
+func (s *OCIStore) DeleteObject(ctx context.Context, base string) (err error) {
+	defer classifyError(&err)
+	_, err = s.client.DeleteObject(ctx, objectstorage.DeleteObjectRequest{
+		NamespaceName: &s.namespace,
+		BucketName:    &s.bucket,
+		ObjectName:    common.String(s.ObjectPath(base)),
+	})
+	return ociNotFound(err)
+}
+
+func (s *OCIStore) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer classifyError(&err)
+	return deleteObjectsConcurrently(ctx, names, deleteObjectsConcurrency, func(ctx context.Context, name string) error {
+		if err := s.DeleteObject(ctx, name); err != nil && err != ErrNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *OCIStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer classifyError(&err)
+	return deletePrefix(ctx, s, prefix, deletePrefixConcurrency)
+}
+
+func ociString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func ociInt64(value *int64) int64 {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
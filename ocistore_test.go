@@ -0,0 +1,483 @@
+//go:build !dstore_no_oci
+
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/objectstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOCI is an in-memory OCI Object Storage server implementing the subset of
+// the native API used by the store, for the `ns` namespace and `bucket` bucket.
+type fakeOCI struct {
+	*httptest.Server
+
+	lock          sync.Mutex
+	objects       map[string]*fakeOCIObject
+	uploads       map[string]map[int][]byte
+	aborted       int
+	failParts     bool
+	listRequests  []url.Values
+	nextUploadID  int
+	nextRequestID int
+}
+
+type fakeOCIObject struct {
+	content  []byte
+	metadata map[string]string
+	modified time.Time
+}
+
+func newFakeOCI(t *testing.T) *fakeOCI {
+	f := &fakeOCI{objects: map[string]*fakeOCIObject{}, uploads: map[string]map[int][]byte{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeOCI) store(t *testing.T, rawURL string, opts ...Option) *OCIStore {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privateKey := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+
+	provider := common.NewRawConfigurationProvider("tenancy", "user", "us-test-1", "fingerprint", privateKey, nil)
+	client, err := objectstorage.NewObjectStorageClientWithConfigurationProvider(provider)
+	require.NoError(t, err)
+	client.Host = f.URL
+
+	baseURL, err := url.Parse(rawURL)
+	require.NoError(t, err)
+
+	store, err := newOCIStore(baseURL, &client, newConfig(opts))
+	require.NoError(t, err)
+	return store
+}
+
+func (f *fakeOCI) fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"code": code, "message": code})
+}
+
+func (f *fakeOCI) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeOCI) serve(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// Object names keep their slashes, and form the rest of the path
+	segments := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 6)
+
+	if len(segments) == 2 && segments[0] == "workRequests" {
+		f.reply(w, map[string]string{"id": segments[1], "status": "COMPLETED"})
+		return
+	}
+	if len(segments) < 5 || segments[0] != "n" || segments[1] != "ns" || segments[2] != "b" || segments[3] != "bucket" {
+		f.fail(w, http.StatusNotFound, "BucketNotFound")
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	ifNoneMatch := r.Header.Get("If-None-Match") == "*"
+
+	switch {
+	case segments[4] == "o" && len(segments) == 5:
+		f.list(w, r.URL.Query())
+	case segments[4] == "o":
+		f.serveObject(w, r, segments[5], body, ifNoneMatch)
+	case segments[4] == "actions" && segments[5] == "renameObject":
+		var details objectstorage.RenameObjectDetails
+		json.Unmarshal(body, &details)
+		object, found := f.objects[*details.SourceName]
+		if !found {
+			f.fail(w, http.StatusNotFound, "ObjectNotFound")
+			return
+		}
+		if _, exists := f.objects[*details.NewName]; exists && details.NewObjIfNoneMatchETag != nil {
+			f.fail(w, http.StatusPreconditionFailed, "IfNoneMatchFailed")
+			return
+		}
+		delete(f.objects, *details.SourceName)
+		f.objects[*details.NewName] = object
+	case segments[4] == "actions" && segments[5] == "copyObject":
+		var details objectstorage.CopyObjectDetails
+		json.Unmarshal(body, &details)
+		object, found := f.objects[*details.SourceObjectName]
+		if !found {
+			f.fail(w, http.StatusNotFound, "ObjectNotFound")
+			return
+		}
+		f.objects[*details.DestinationObjectName] = object
+		f.nextRequestID++
+		w.Header().Set("opc-work-request-id", fmt.Sprintf("copy-%d", f.nextRequestID))
+		w.WriteHeader(http.StatusAccepted)
+	case segments[4] == "p":
+		var details objectstorage.CreatePreauthenticatedRequestDetails
+		json.Unmarshal(body, &details)
+		f.reply(w, map[string]string{"id": "par", "name": *details.Name, "accessUri": "/p/token/n/ns/b/bucket/o/" + *details.ObjectName, "accessType": string(details.AccessType)})
+	case segments[4] == "u":
+		f.serveUpload(w, r, segments, body, ifNoneMatch)
+	default:
+		f.fail(w, http.StatusNotFound, "NotFound")
+	}
+}
+
+func (f *fakeOCI) serveObject(w http.ResponseWriter, r *http.Request, name string, body []byte, ifNoneMatch bool) {
+	object, found := f.objects[name]
+
+	switch r.Method {
+	case http.MethodPut:
+		if found && ifNoneMatch {
+			f.fail(w, http.StatusPreconditionFailed, "IfNoneMatchFailed")
+			return
+		}
+		metadata := map[string]string{}
+		for key, values := range r.Header {
+			if strings.HasPrefix(strings.ToLower(key), "opc-meta-") {
+				metadata[strings.TrimPrefix(strings.ToLower(key), "opc-meta-")] = values[0]
+			}
+		}
+		f.objects[name] = &fakeOCIObject{content: body, metadata: metadata, modified: time.Now()}
+	case http.MethodHead, http.MethodGet:
+		if !found {
+			f.fail(w, http.StatusNotFound, "ObjectNotFound")
+			return
+		}
+		for key, value := range object.metadata {
+			w.Header().Set("opc-meta-"+key, value)
+		}
+		w.Header().Set("ETag", fmt.Sprintf("etag-%d", len(object.content)))
+		w.Header().Set("Last-Modified", object.modified.UTC().Format(http.TimeFormat))
+
+		content := object.content
+		if byteRange := r.Header.Get("Range"); byteRange != "" {
+			bounds := strings.SplitN(strings.TrimPrefix(byteRange, "bytes="), "-", 2)
+			start, _ := strconv.Atoi(bounds[0])
+			end := len(content) - 1
+			if bounds[1] != "" {
+				end, _ = strconv.Atoi(bounds[1])
+			}
+			if start >= len(content) {
+				f.fail(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+				return
+			}
+			if end >= len(content) {
+				end = len(content) - 1
+			}
+			content = content[start : end+1]
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method == http.MethodGet {
+			w.Write(content)
+		}
+	case http.MethodDelete:
+		if !found {
+			f.fail(w, http.StatusNotFound, "ObjectNotFound")
+			return
+		}
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeOCI) serveUpload(w http.ResponseWriter, r *http.Request, segments []string, body []byte, ifNoneMatch bool) {
+	if len(segments) == 5 {
+		var details objectstorage.CreateMultipartUploadDetails
+		json.Unmarshal(body, &details)
+		if _, exists := f.objects[*details.Object]; exists && ifNoneMatch {
+			f.fail(w, http.StatusPreconditionFailed, "IfNoneMatchFailed")
+			return
+		}
+		f.nextUploadID++
+		uploadID := fmt.Sprintf("upload-%d", f.nextUploadID)
+		f.uploads[uploadID] = map[int][]byte{}
+		f.reply(w, map[string]string{"namespace": "ns", "bucket": "bucket", "object": *details.Object, "uploadId": uploadID})
+		return
+	}
+
+	name, uploadID := segments[5], r.URL.Query().Get("uploadId")
+	parts, found := f.uploads[uploadID]
+	if !found {
+		f.fail(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if f.failParts {
+			f.fail(w, http.StatusBadRequest, "InvalidParameter")
+			return
+		}
+		partNum, _ := strconv.Atoi(r.URL.Query().Get("uploadPartNum"))
+		parts[partNum] = body
+		w.Header().Set("ETag", fmt.Sprintf("part-%d", partNum))
+	case http.MethodPost:
+		var details objectstorage.CommitMultipartUploadDetails
+		json.Unmarshal(body, &details)
+		content := &bytes.Buffer{}
+		for _, part := range details.PartsToCommit {
+			content.Write(parts[*part.PartNum])
+		}
+		f.objects[name] = &fakeOCIObject{content: content.Bytes(), modified: time.Now()}
+		delete(f.uploads, uploadID)
+	case http.MethodDelete:
+		f.aborted++
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeOCI) list(w http.ResponseWriter, query url.Values) {
+	f.listRequests = append(f.listRequests, query)
+
+	names := make([]string, 0, len(f.objects))
+	for name := range f.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	limit := 1000
+	if query.Get("limit") != "" {
+		limit, _ = strconv.Atoi(query.Get("limit"))
+	}
+
+	type summary struct {
+		Name string `json:"name"`
+		Size int    `json:"size"`
+	}
+	var objects []summary
+	var prefixes []string
+	var nextStartWith string
+	for _, name := range names {
+		if !strings.HasPrefix(name, query.Get("prefix")) || name < query.Get("start") || (query.Get("end") != "" && name >= query.Get("end")) {
+			continue
+		}
+		if delimiter := query.Get("delimiter"); delimiter != "" {
+			if i := strings.Index(name[len(query.Get("prefix")):], delimiter); i >= 0 {
+				prefix := name[:len(query.Get("prefix"))+i+1]
+				if len(prefixes) == 0 || prefixes[len(prefixes)-1] != prefix {
+					prefixes = append(prefixes, prefix)
+				}
+				continue
+			}
+		}
+		if len(objects) == limit {
+			nextStartWith = name
+			break
+		}
+		objects = append(objects, summary{Name: name, Size: len(f.objects[name].content)})
+	}
+
+	response := map[string]interface{}{"objects": objects, "prefixes": prefixes}
+	if nextStartWith != "" {
+		response["nextStartWith"] = nextStartWith
+	}
+	f.reply(w, response)
+}
+
+func TestNewOCIStore(t *testing.T) {
+	fake := newFakeOCI(t)
+
+	tests := []struct {
+		url            string
+		expectedBucket string
+		expectedPath   string
+		expectedRegion string
+	}{
+		{"oci://ns/bucket", "bucket", "", "us-test-1"},
+		{"oci://ns/bucket/path1/", "bucket", "path1", "us-test-1"},
+		{"oci://ns/bucket/path1/path2?region=eu-frankfurt-1", "bucket", "path1/path2", "eu-frankfurt-1"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			store := fake.store(t, test.url)
+			assert.Equal(t, "ns", store.namespace)
+			assert.Equal(t, test.expectedBucket, store.bucket)
+			assert.Equal(t, test.expectedPath, store.path)
+			assert.Equal(t, test.expectedRegion, store.region)
+
+			sub, err := store.SubStore("sub-folder")
+			require.NoError(t, err)
+			assert.True(t, strings.HasSuffix(sub.(*OCIStore).path, "sub-folder"))
+		})
+	}
+
+	baseURL, err := url.Parse("oci://ns")
+	require.NoError(t, err)
+	_, err = NewOCIStore(baseURL, "", "", false)
+	require.Error(t, err)
+
+	baseURL, err = url.Parse("oci://ns/bucket?auth=unknown")
+	require.NoError(t, err)
+	_, err = NewOCIStore(baseURL, "", "", false)
+	require.Error(t, err)
+}
+
+func TestOCIStore_ErrorClasses(t *testing.T) {
+	assertErrorClass(t, &ociServiceError{status: http.StatusTooManyRequests}, ErrRateLimited)
+	assertErrorClass(t, &ociServiceError{status: http.StatusUnauthorized}, ErrPermissionDenied)
+	assertErrorClass(t, &ociServiceError{status: http.StatusNotFound}, nil)
+}
+
+// ociServiceError is a `common.ServiceError` answered with `status`.
+type ociServiceError struct {
+	status int
+}
+
+func (e *ociServiceError) Error() string {
+	return fmt.Sprintf("oci error %d", e.status)
+}
+
+func (e *ociServiceError) GetHTTPStatusCode() int  { return e.status }
+func (e *ociServiceError) GetMessage() string      { return "" }
+func (e *ociServiceError) GetCode() string         { return "" }
+func (e *ociServiceError) GetOpcRequestID() string { return "" }
+
+func TestOCIStore_WriteObject(t *testing.T) {
+	fake := newFakeOCI(t)
+	store := fake.store(t, "oci://ns/bucket/path1")
+	ctx := context.Background()
+
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader([]byte("content")), WithMetadata(map[string]string{"Key": "value"})))
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader([]byte("ignored"))))
+	assert.Equal(t, []byte("content"), fake.objects["path1/file"].content)
+
+	attrs, err := store.ObjectAttributes(ctx, "file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), attrs.Size)
+	assert.Equal(t, map[string]string{"key": "value"}, attrs.Metadata)
+
+	// The object created by a concurrent writer is kept
+	fake.objects["path1/raced"] = &fakeOCIObject{content: []byte("first")}
+	err = store.upload(ctx, "path1/raced", newWriteConfig(nil), bytes.NewReader([]byte("second")))
+	assert.True(t, isOCIPreconditionFailed(err))
+	assert.Equal(t, []byte("first"), fake.objects["path1/raced"].content)
+
+	reader, err := store.OpenObjectRange(ctx, "file", 2, 3)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "nte", string(content))
+
+	_, err = store.OpenObject(ctx, "missing")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestOCIStore_WriteObject_Multipart(t *testing.T) {
+	fake := newFakeOCI(t)
+	store := fake.store(t, "oci://ns/bucket", MultipartThreshold(1024))
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("0123456789"), 250)
+	require.NoError(t, store.WriteObject(ctx, "large", bytes.NewReader(content)))
+	assert.Equal(t, content, fake.objects["large"].content)
+	assert.Empty(t, fake.uploads)
+
+	fake.failParts = true
+	require.Error(t, store.WriteObject(ctx, "failed", bytes.NewReader(content)))
+	assert.Equal(t, 1, fake.aborted)
+	assert.Empty(t, fake.uploads)
+}
+
+func TestOCIStore_Walk(t *testing.T) {
+	fake := newFakeOCI(t)
+	store := fake.store(t, "oci://ns/bucket/path1")
+	ctx := context.Background()
+
+	for _, name := range []string{"a/1", "a/2", "b/1", "b/2", "c/1"} {
+		fake.objects["path1/"+name] = &fakeOCIObject{content: []byte(name)}
+	}
+	fake.objects["other/file"] = &fakeOCIObject{}
+
+	var seen []string
+	err := store.Walk(ctx, "", func(filename string) error {
+		seen = append(seen, filename)
+		if filename == "a/1" {
+			return SkipPrefix("a/")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/1", "b/1", "b/2", "c/1"}, seen)
+	assert.Equal(t, "path1/a0", fake.listRequests[len(fake.listRequests)-1].Get("start"))
+
+	seen = nil
+	require.NoError(t, store.WalkBetween(ctx, "", "a/2", "c/", func(filename string) error {
+		seen = append(seen, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{"a/2", "b/1", "b/2"}, seen)
+
+	files, token, err := store.ListFilesPage(ctx, "", 2, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/1", "a/2"}, files)
+	files, token, err = store.ListFilesPage(ctx, "", 2, token)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/1", "b/2"}, files)
+	assert.NotEmpty(t, token)
+
+	directories, err := store.ListDirectories(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, directories)
+
+	deleted, err := store.DeletePrefix(ctx, "b/")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+}
+
+func TestOCIStore_CopyAndRename(t *testing.T) {
+	fake := newFakeOCI(t)
+	store := fake.store(t, "oci://ns/bucket")
+	ctx := context.Background()
+
+	fake.objects["src"] = &fakeOCIObject{content: []byte("content")}
+	fake.objects["existing"] = &fakeOCIObject{content: []byte("existing")}
+
+	require.NoError(t, store.CopyObject(ctx, "src", "dst"))
+	assert.Equal(t, []byte("content"), fake.objects["dst"].content)
+	assert.Equal(t, ErrNotFound, store.CopyObject(ctx, "missing", "other"))
+
+	require.NoError(t, store.RenameObject(ctx, "dst", "renamed"))
+	assert.Nil(t, fake.objects["dst"])
+	assert.Equal(t, []byte("content"), fake.objects["renamed"].content)
+
+	require.NoError(t, store.RenameObject(ctx, "renamed", "existing"))
+	assert.Nil(t, fake.objects["renamed"])
+	assert.Equal(t, []byte("existing"), fake.objects["existing"].content)
+
+	assert.Equal(t, ErrNotFound, store.RenameObject(ctx, "missing", "other"))
+}
+
+func TestOCIStore_Presign(t *testing.T) {
+	fake := newFakeOCI(t)
+	store := fake.store(t, "oci://ns/bucket/path1", Extension("dbin"))
+
+	signed, err := store.PresignGet(context.Background(), "0000000100", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, fake.URL+"/p/token/n/ns/b/bucket/o/path1/0000000100.dbin", signed)
+}
@@ -0,0 +1,156 @@
+package dstore
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress is the state of a transfer, reported to a `ProgressFunc`.
+type Progress struct {
+	// Transferred is the number of bytes transferred so far, of the content
+	// before compression for writes, or the number of objects deleted for
+	// deletions.
+	Transferred int64
+	// Total is the number of bytes of the whole transfer, -1 when unknown.
+	Total int64
+	// Elapsed is the time since the transfer started.
+	Elapsed time.Duration
+	// Rate is the average rate of the transfer, in bytes per second.
+	Rate float64
+}
+
+// ProgressFunc receives the progress of a transfer, at most every 200ms and
+// once it completed. It is never called concurrently for a transfer.
+type ProgressFunc func(progress Progress)
+
+// progressInterval is the minimum time between two reports of the progress
+// of a transfer.
+const progressInterval = 200 * time.Millisecond
+
+// progressTracker reports the progress of a transfer, safe for concurrent use.
+type progressTracker struct {
+	report ProgressFunc
+
+	lock        sync.Mutex
+	start       time.Time
+	last        time.Time
+	transferred int64
+	total       int64
+}
+
+func newProgressTracker(report ProgressFunc, total int64) *progressTracker {
+	return &progressTracker{
+		report: report,
+		start:  time.Now(),
+		total:  total,
+	}
+}
+
+// add counts `n` more bytes transferred, reporting the progress when the
+// interval elapsed or when `done`.
+func (t *progressTracker) add(n int64, done bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.transferred += n
+	t.reportLocked(done)
+}
+
+// set moves the bytes transferred to `transferred`, for transfers starting
+// over.
+func (t *progressTracker) set(transferred int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.transferred = transferred
+}
+
+func (t *progressTracker) reportLocked(done bool) {
+	now := time.Now()
+	if !done && now.Sub(t.last) < progressInterval {
+		return
+	}
+	t.last = now
+
+	elapsed := now.Sub(t.start)
+	progress := Progress{Transferred: t.transferred, Total: t.total, Elapsed: elapsed}
+	if elapsed > 0 {
+		progress.Rate = float64(t.transferred) / elapsed.Seconds()
+	}
+	t.report(progress)
+}
+
+type deleteProgressKey struct{}
+
+// WithDeleteProgress returns a context reporting the progress of the
+// `Store::DeletePrefix` calls performed with it to `progress`, counting the
+// objects deleted, their total being unknown.
+func WithDeleteProgress(ctx context.Context, progress ProgressFunc) context.Context {
+	return context.WithValue(ctx, deleteProgressKey{}, progress)
+}
+
+// deleteProgressTracker returns a tracker reporting the progress of a
+// deletion to the callback of `WithDeleteProgress`, nil without one.
+func deleteProgressTracker(ctx context.Context) *progressTracker {
+	progress, ok := ctx.Value(deleteProgressKey{}).(ProgressFunc)
+	if !ok || progress == nil {
+		return nil
+	}
+	return newProgressTracker(progress, -1)
+}
+
+// trackProgress returns `f` reporting the progress of its reads to the
+// callback of the `WithProgress` option, `f` itself without one. The total is
+// known for seekable content, which stays seekable.
+func (c *writeConfig) trackProgress(f io.Reader) io.Reader {
+	if c.progress == nil {
+		return f
+	}
+
+	seeker, ok := f.(io.ReadSeeker)
+	if !ok {
+		return &progressReader{Reader: f, tracker: newProgressTracker(c.progress, -1)}
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return &progressReader{Reader: f, tracker: newProgressTracker(c.progress, -1)}
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = seeker.Seek(start, io.SeekStart)
+	}
+	if err != nil {
+		return &progressReader{Reader: f, tracker: newProgressTracker(c.progress, -1)}
+	}
+
+	reader := &progressReader{Reader: f, tracker: newProgressTracker(c.progress, end-start)}
+	return &progressReadSeeker{progressReader: reader, seeker: seeker, start: start}
+}
+
+type progressReader struct {
+	io.Reader
+	tracker *progressTracker
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.tracker.add(int64(n), err == io.EOF)
+	return n, err
+}
+
+// progressReadSeeker tracks the position in the content, retried writes
+// seeking back to its start.
+type progressReadSeeker struct {
+	*progressReader
+	seeker io.Seeker
+	start  int64
+}
+
+func (r *progressReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	position, err := r.seeker.Seek(offset, whence)
+	if err == nil {
+		r.tracker.set(position - r.start)
+	}
+	return position, err
+}
@@ -0,0 +1,80 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithProgress(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+
+	var reports []Progress
+	record := func(progress Progress) { reports = append(reports, progress) }
+
+	require.NoError(t, store.WriteObject(ctx, "seekable", bytes.NewReader(content), WithProgress(record)))
+	require.NotEmpty(t, reports)
+	last := reports[len(reports)-1]
+	assert.Equal(t, int64(len(content)), last.Transferred)
+	assert.Equal(t, int64(len(content)), last.Total)
+	assert.True(t, last.Rate > 0)
+
+	reports = nil
+	require.NoError(t, store.WriteObject(ctx, "stream", io.MultiReader(strings.NewReader("content")), WithProgress(record)))
+	assert.Equal(t, int64(-1), reports[len(reports)-1].Total, "the total of non-seekable content is unknown")
+	assert.Equal(t, int64(7), reports[len(reports)-1].Transferred)
+
+	reports = nil
+	localFile := filepath.Join(t.TempDir(), "local")
+	require.NoError(t, ioutil.WriteFile(localFile, content, 0644))
+	_, err := UploadLocalFile(ctx, store, localFile, "uploaded", WithProgress(record))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), reports[len(reports)-1].Transferred)
+	assert.Equal(t, int64(len(content)), reports[len(reports)-1].Total)
+
+	reports = nil
+	buffer := &writerAtBuffer{}
+	n, err := DownloadObjectWithProgress(ctx, store, "seekable", buffer, 4096, 4, record)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), n)
+	assert.Equal(t, n, reports[len(reports)-1].Transferred)
+	assert.Equal(t, n, reports[len(reports)-1].Total)
+}
+
+func TestWithDeleteProgress(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStore(&url.URL{Scheme: "file", Path: t.TempDir()}, "", "", false)
+	require.NoError(t, err)
+	for i := 0; i < 2500; i++ {
+		require.NoError(t, store.WriteObject(ctx, fmt.Sprintf("prefix/%04d", i), strings.NewReader("content")))
+	}
+
+	var reports []Progress
+	record := func(progress Progress) { reports = append(reports, progress) }
+
+	deleted, err := store.DeletePrefix(WithDeleteProgress(ctx, record), "prefix/")
+	require.NoError(t, err)
+	assert.Equal(t, 2500, deleted)
+	require.NotEmpty(t, reports)
+	assert.Equal(t, int64(deleted), reports[len(reports)-1].Transferred)
+	assert.Equal(t, int64(-1), reports[len(reports)-1].Total, "the total of a deletion is unknown")
+
+	memory := NewMemoryStore()
+	require.NoError(t, memory.WriteObject(ctx, "prefix/a", strings.NewReader("content")))
+	reports = nil
+	deleted, err = memory.DeletePrefix(WithDeleteProgress(ctx, record), "prefix/")
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, int64(1), reports[len(reports)-1].Transferred)
+}
@@ -0,0 +1,276 @@
+package dstore
+
+import (
+	"context"
+	"io"
+	"math"
+
+	"golang.org/x/time/rate"
+)
+
+//
+// Rate Limited Store
+//
+
+// rateLimitMinBytesBurst is the smallest burst of the bytes limiters, so reads
+// and writes are not split in tiny chunks on low limits.
+const rateLimitMinBytesBurst = 32 * 1024
+
+// RateLimitPolicy configures the limits of a `RateLimitedStore`, zero values
+// meaning unlimited. Operations and bytes are limited separately, traffic
+// being allowed in bursts of up to one second of the limit.
+type RateLimitPolicy struct {
+	// ReadOpsPerSecond limits opening objects, checking their existence or
+	// attributes, and walking or listing, each walk counting as one operation.
+	ReadOpsPerSecond float64
+	// ReadBytesPerSecond limits the bytes read from opened objects.
+	ReadBytesPerSecond float64
+	// WriteOpsPerSecond limits writes, copies, renames and deletions, each
+	// object of `DeleteObjects` counting as one operation.
+	WriteOpsPerSecond float64
+	// WriteBytesPerSecond limits the bytes written to objects.
+	WriteBytesPerSecond float64
+}
+
+// RateLimitedStore is a `Store` throttling the operations performed on the
+// inner store with token buckets, for example so that backfills don't starve
+// latency sensitive readers sharing the same bucket quotas. Sub stores share
+// the limits of their parent.
+type RateLimitedStore struct {
+	// Store is the inner store, receiving the throttled operations.
+	Store
+
+	readOps, readBytes, writeOps, writeBytes *rate.Limiter
+}
+
+func NewRateLimitedStore(inner Store, policy RateLimitPolicy) *RateLimitedStore {
+	return &RateLimitedStore{
+		Store:      inner,
+		readOps:    newRateLimiter(policy.ReadOpsPerSecond, 1),
+		readBytes:  newRateLimiter(policy.ReadBytesPerSecond, rateLimitMinBytesBurst),
+		writeOps:   newRateLimiter(policy.WriteOpsPerSecond, 1),
+		writeBytes: newRateLimiter(policy.WriteBytesPerSecond, rateLimitMinBytesBurst),
+	}
+}
+
+// newRateLimiter returns a limiter allowing bursts of one second of traffic,
+// at least `minBurst`, nil when `perSecond` is zero.
+func newRateLimiter(perSecond float64, minBurst int) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	burst := int(math.Ceil(perSecond))
+	if burst < minBurst {
+		burst = minBurst
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+// waitRateLimit waits for `n` tokens of `limiter`, in bursts when `n` exceeds
+// the burst of the limiter.
+func waitRateLimit(ctx context.Context, limiter *rate.Limiter, n int) error {
+	if limiter == nil {
+		return nil
+	}
+
+	for n > 0 {
+		chunk := n
+		if chunk > limiter.Burst() {
+			chunk = limiter.Burst()
+		}
+		if err := limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// rateLimitedReader consumes tokens of `limiter` for every byte read.
+type rateLimitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+func newRateLimitedReader(ctx context.Context, reader io.Reader, limiter *rate.Limiter) io.Reader {
+	if limiter == nil {
+		return reader
+	}
+	return &rateLimitedReader{ctx: ctx, reader: reader, limiter: limiter}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+type rateLimitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (s *RateLimitedStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := *s
+	sub.Store = inner
+	return &sub, nil
+}
+
+func (s *RateLimitedStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	if err := waitRateLimit(ctx, s.readOps, 1); err != nil {
+		return nil, err
+	}
+
+	reader, err := s.Store.OpenObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.limitReader(ctx, reader), nil
+}
+
+func (s *RateLimitedStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	if err := waitRateLimit(ctx, s.readOps, 1); err != nil {
+		return nil, err
+	}
+
+	reader, err := s.Store.OpenObjectRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.limitReader(ctx, reader), nil
+}
+
+func (s *RateLimitedStore) limitReader(ctx context.Context, reader io.ReadCloser) io.ReadCloser {
+	if s.readBytes == nil {
+		return reader
+	}
+	return &rateLimitedReadCloser{Reader: newRateLimitedReader(ctx, reader, s.readBytes), Closer: reader}
+}
+
+func (s *RateLimitedStore) FileExists(ctx context.Context, base string) (bool, error) {
+	if err := waitRateLimit(ctx, s.readOps, 1); err != nil {
+		return false, err
+	}
+	return s.Store.FileExists(ctx, base)
+}
+
+func (s *RateLimitedStore) ObjectAttributes(ctx context.Context, base string) (*ObjectAttrs, error) {
+	if err := waitRateLimit(ctx, s.readOps, 1); err != nil {
+		return nil, err
+	}
+	return s.Store.ObjectAttributes(ctx, base)
+}
+
+func (s *RateLimitedStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	if err := waitRateLimit(ctx, s.writeOps, 1); err != nil {
+		return err
+	}
+	return s.Store.WriteObject(ctx, base, newRateLimitedReader(ctx, f, s.writeBytes), opts...)
+}
+
+func (s *RateLimitedStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+func (s *RateLimitedStore) CopyObject(ctx context.Context, src, dst string) error {
+	if err := waitRateLimit(ctx, s.writeOps, 1); err != nil {
+		return err
+	}
+	return s.Store.CopyObject(ctx, src, dst)
+}
+
+func (s *RateLimitedStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	if err := waitRateLimit(ctx, s.writeOps, 1); err != nil {
+		return err
+	}
+	return s.Store.RenameObject(ctx, oldName, newName)
+}
+
+func (s *RateLimitedStore) DeleteObject(ctx context.Context, base string) error {
+	if err := waitRateLimit(ctx, s.writeOps, 1); err != nil {
+		return err
+	}
+	return s.Store.DeleteObject(ctx, base)
+}
+
+func (s *RateLimitedStore) DeleteObjects(ctx context.Context, names []string) error {
+	if err := waitRateLimit(ctx, s.writeOps, len(names)); err != nil {
+		return err
+	}
+	return s.Store.DeleteObjects(ctx, names)
+}
+
+func (s *RateLimitedStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	if err := waitRateLimit(ctx, s.writeOps, 1); err != nil {
+		return 0, err
+	}
+	return s.Store.DeletePrefix(ctx, prefix)
+}
+
+func (s *RateLimitedStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
+	if err := waitRateLimit(ctx, s.readOps, 1); err != nil {
+		return err
+	}
+	return s.Store.WalkFrom(ctx, prefix, startingPoint, f)
+}
+
+func (s *RateLimitedStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error {
+	if err := waitRateLimit(ctx, s.readOps, 1); err != nil {
+		return err
+	}
+	return s.Store.WalkBetween(ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *RateLimitedStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error {
+	if err := waitRateLimit(ctx, s.readOps, 1); err != nil {
+		return err
+	}
+	return s.Store.Walk(ctx, prefix, f)
+}
+
+func (s *RateLimitedStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) error {
+	if err := waitRateLimit(ctx, s.readOps, 1); err != nil {
+		return err
+	}
+	return s.Store.WalkObjects(ctx, prefix, f)
+}
+
+func (s *RateLimitedStore) ListFiles(ctx context.Context, prefix string, max int) ([]string, error) {
+	if err := waitRateLimit(ctx, s.readOps, 1); err != nil {
+		return nil, err
+	}
+	return s.Store.ListFiles(ctx, prefix, max)
+}
+
+func (s *RateLimitedStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	if err := waitRateLimit(ctx, s.readOps, 1); err != nil {
+		return nil, "", err
+	}
+	return s.Store.ListFilesPage(ctx, prefix, pageSize, pageToken)
+}
+
+func (s *RateLimitedStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	if err := waitRateLimit(ctx, s.readOps, 1); err != nil {
+		return nil, err
+	}
+	return s.Store.ListDirectories(ctx, prefix)
+}
@@ -0,0 +1,56 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitedStore_Bytes(t *testing.T) {
+	ctx := context.Background()
+	store := NewRateLimitedStore(NewMemoryStore(), RateLimitPolicy{ReadBytesPerSecond: 100 * 1024, WriteBytesPerSecond: 100 * 1024})
+
+	// The first burst of 100KiB is free, the next 50KiB take half a second
+	content := make([]byte, 150*1024)
+	start := time.Now()
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader(content)))
+	assert.InDelta(t, 500*time.Millisecond, time.Since(start), float64(200*time.Millisecond))
+
+	start = time.Now()
+	reader, err := store.OpenObject(ctx, "file")
+	require.NoError(t, err)
+	read, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Len(t, read, len(content))
+	assert.InDelta(t, 500*time.Millisecond, time.Since(start), float64(200*time.Millisecond))
+}
+
+func TestRateLimitedStore_Ops(t *testing.T) {
+	ctx := context.Background()
+	store := NewRateLimitedStore(NewMemoryStore(), RateLimitPolicy{ReadOpsPerSecond: 20})
+	require.NoError(t, store.WriteObject(ctx, "file", strings.NewReader("content")))
+
+	// Sub stores share the limits of their parent
+	sub, err := store.SubStore("")
+	require.NoError(t, err)
+
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		exists, err := sub.FileExists(ctx, "file")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	}
+	assert.InDelta(t, 500*time.Millisecond, time.Since(start), float64(200*time.Millisecond))
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = store.FileExists(canceled, "file")
+	assert.Error(t, err)
+}
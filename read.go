@@ -0,0 +1,57 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ErrObjectTooLarge is returned by `ReadObjectMaxSize` when the object content
+// exceeds the allowed size.
+var ErrObjectTooLarge = errors.New("object too large")
+
+// ReadObject opens the object and reads its whole decompressed content.
+func ReadObject(ctx context.Context, store Store, name string) ([]byte, error) {
+	return ReadObjectMaxSize(ctx, store, name, -1)
+}
+
+// OpenObjectAt opens the object's decompressed content from the logical
+// `offset` to its end. On stores with `SeekableZstd`, only the frames from the
+// one holding `offset` are fetched and decompressed, other compressed stores
+// decompress and skip the content before `offset`.
+func OpenObjectAt(ctx context.Context, store Store, name string, offset int64) (io.ReadCloser, error) {
+	return store.OpenObjectRange(ctx, name, offset, -1)
+}
+
+// ReadObjectMaxSize is like `ReadObject` but fails with `ErrObjectTooLarge`
+// as soon as more than `maxSize` bytes are read, a negative `maxSize` meaning
+// no limit. The limit applies to the decompressed content.
+//
+// The error of closing the object is returned too, so that the checksum
+// mismatches of stores with `VerifyChecksums` are reported.
+func ReadObjectMaxSize(ctx context.Context, store Store, name string, maxSize int64) ([]byte, error) {
+	reader, err := store.OpenObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var source io.Reader = reader
+	if maxSize >= 0 {
+		source = io.LimitReader(reader, maxSize+1)
+	}
+
+	content, err := ioutil.ReadAll(source)
+	closeErr := reader.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read object %q: %w", name, err)
+	}
+	if maxSize >= 0 && int64(len(content)) > maxSize {
+		return nil, fmt.Errorf("object %q is over %d bytes: %w", name, maxSize, ErrObjectTooLarge)
+	}
+	if closeErr != nil {
+		return nil, fmt.Errorf("close object %q: %w", name, closeErr)
+	}
+	return content, nil
+}
@@ -0,0 +1,45 @@
+package dstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadObject(t *testing.T) {
+	store := NewMockStore(nil)
+	store.SetFile("file", []byte("content"))
+
+	content, err := ReadObject(context.Background(), store, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
+
+func TestReadObjectMaxSize(t *testing.T) {
+	store := NewMockStore(nil)
+	store.SetFile("file", []byte("content"))
+
+	tests := []struct {
+		maxSize     int64
+		expectedErr error
+	}{
+		{-1, nil},
+		{7, nil},
+		{100, nil},
+		{6, ErrObjectTooLarge},
+		{0, ErrObjectTooLarge},
+	}
+
+	for _, test := range tests {
+		content, err := ReadObjectMaxSize(context.Background(), store, "file", test.maxSize)
+		if test.expectedErr != nil {
+			assert.ErrorIs(t, err, test.expectedErr)
+			continue
+		}
+
+		require.NoError(t, err)
+		assert.Equal(t, "content", string(content))
+	}
+}
@@ -0,0 +1,117 @@
+package dstore
+
+import (
+	"io"
+	"sync"
+)
+
+// readAheadReader reads `source` in a goroutine, filling up to `chunks`
+// buffers ahead of the consumer so that fetching and decompressing the object
+// overlap with the consumer's own processing.
+type readAheadReader struct {
+	source io.ReadCloser
+
+	filled  chan readAheadChunk
+	free    chan []byte
+	current readAheadChunk
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type readAheadChunk struct {
+	buffer []byte
+	// data is the part of `buffer` not consumed yet.
+	data []byte
+	err  error
+}
+
+func newReadAheadReader(source io.ReadCloser, chunkSize, chunks int) *readAheadReader {
+	r := &readAheadReader{
+		source:  source,
+		filled:  make(chan readAheadChunk, chunks),
+		free:    make(chan []byte, chunks),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for i := 0; i < chunks; i++ {
+		r.free <- make([]byte, chunkSize)
+	}
+
+	go r.fill()
+	return r
+}
+
+// fill reads the source into the free buffers until it fails, reaches its
+// end or the reader is closed.
+func (r *readAheadReader) fill() {
+	defer close(r.stopped)
+	defer close(r.filled)
+
+	for {
+		var buffer []byte
+		select {
+		case buffer = <-r.free:
+		case <-r.done:
+			return
+		}
+
+		chunk := readAheadChunk{buffer: buffer}
+		var n int
+		for n < len(buffer) && chunk.err == nil {
+			var read int
+			read, chunk.err = r.source.Read(buffer[n:])
+			n += read
+
+			select {
+			case <-r.done:
+				return
+			default:
+			}
+		}
+		chunk.data = buffer[:n]
+
+		select {
+		case r.filled <- chunk:
+		case <-r.done:
+			return
+		}
+		if chunk.err != nil {
+			return
+		}
+	}
+}
+
+func (r *readAheadReader) Read(p []byte) (int, error) {
+	for len(r.current.data) == 0 {
+		if r.current.err != nil {
+			return 0, r.current.err
+		}
+		if r.current.buffer != nil {
+			r.free <- r.current.buffer
+		}
+
+		chunk, ok := <-r.filled
+		if !ok {
+			return 0, io.ErrClosedPipe
+		}
+		r.current = chunk
+	}
+
+	n := copy(p, r.current.data)
+	r.current.data = r.current.data[n:]
+	return n, nil
+}
+
+// Close stops reading ahead, waiting for the read in progress to complete
+// before closing the source which may not support concurrent calls.
+func (r *readAheadReader) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		<-r.stopped
+		r.closeErr = r.source.Close()
+	})
+	return r.closeErr
+}
@@ -0,0 +1,107 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type closeTrackingReader struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeTrackingReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestReadAheadReader(t *testing.T) {
+	content := make([]byte, 100*1024+17)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	for _, chunkSize := range []int{1, 1000, 100 * 1024, 1024 * 1024} {
+		source := &closeTrackingReader{Reader: bytes.NewReader(content)}
+		reader := newReadAheadReader(source, chunkSize, 2)
+
+		read, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, content, read, "chunk size %d", chunkSize)
+
+		require.NoError(t, reader.Close())
+		assert.True(t, source.closed)
+	}
+}
+
+func TestReadAheadReader_Error(t *testing.T) {
+	errBroken := errors.New("broken")
+	source := &closeTrackingReader{Reader: io.MultiReader(bytes.NewReader([]byte("partial")), readerFunc(func(p []byte) (int, error) { return 0, errBroken }))}
+	reader := newReadAheadReader(source, 4, 2)
+
+	read, err := ioutil.ReadAll(reader)
+	assert.Equal(t, errBroken, err)
+	assert.Equal(t, "partial", string(read))
+	require.NoError(t, reader.Close())
+}
+
+func TestReadAheadReader_CloseEarly(t *testing.T) {
+	// An endless source, the reader stopping as soon as it is closed
+	source := &closeTrackingReader{Reader: readerFunc(func(p []byte) (int, error) { return len(p), nil })}
+	reader := newReadAheadReader(source, 16, 2)
+
+	_, err := reader.Read(make([]byte, 4))
+	require.NoError(t, err)
+
+	closed := make(chan error)
+	go func() { closed <- reader.Close() }()
+	select {
+	case err := <-closed:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("close blocked")
+	}
+	assert.True(t, source.closed)
+}
+
+func TestReadAhead(t *testing.T) {
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("read ahead "), 10000)
+
+	store := NewMemoryStore(Compression("zstd"), ReadAhead(1024, 0))
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader(content)))
+
+	reader, err := store.OpenObject(ctx, "file")
+	require.NoError(t, err)
+	assert.IsType(t, &readAheadReader{}, reader)
+	read, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, content, read)
+
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+	require.NoError(t, sub.WriteObject(ctx, "file", bytes.NewReader(content)))
+	reader, err = sub.OpenObject(ctx, "file")
+	require.NoError(t, err)
+	assert.IsType(t, &readAheadReader{}, reader)
+	require.NoError(t, reader.Close())
+}
+
+func TestReadAheadSizeParam(t *testing.T) {
+	baseURL, err := url.Parse("memory:///?read_ahead=4096")
+	require.NoError(t, err)
+	assert.Equal(t, 4096, readAheadSizeParam(zlog, baseURL, 0))
+
+	baseURL, err = url.Parse("memory:///?read_ahead=invalid")
+	require.NoError(t, err)
+	assert.Equal(t, 1024, readAheadSizeParam(zlog, baseURL, 1024))
+}
@@ -0,0 +1,100 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+//
+// Read Only Store
+//
+
+// ErrReadOnly is returned by `ReadOnlyStore` for every operation that would
+// modify the store.
+var ErrReadOnly = errors.New("read-only store")
+
+// ReadableStore is the subset of `Store` that doesn't modify objects, every
+// `Store` being a `ReadableStore`. Handing a `ReadableStore` out instead of a
+// `Store` guarantees at compile time that callers don't write, wrapping it
+// with `NewReadOnlyStore` guarantees it at runtime too.
+type ReadableStore interface {
+	OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error)
+	OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error)
+	FileExists(ctx context.Context, base string) (bool, error)
+	ObjectAttributes(ctx context.Context, base string) (*ObjectAttrs, error)
+	ObjectPath(base string) string
+	ObjectURL(base string) string
+	PresignGet(ctx context.Context, base string, ttl time.Duration) (string, error)
+
+	WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error
+	WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error
+	Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error
+	WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) error
+	ListFiles(ctx context.Context, prefix string, max int) ([]string, error)
+	ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error)
+	ListDirectories(ctx context.Context, prefix string) ([]string, error)
+
+	BaseURL() *url.URL
+}
+
+var _ ReadableStore = Store(nil)
+
+// ReadOnlyStore is a `Store` delegating reads to the inner store and failing
+// every write, copy, rename and deletion with `ErrReadOnly`.
+type ReadOnlyStore struct {
+	// Store is the inner store, only receiving reads.
+	Store
+}
+
+func NewReadOnlyStore(inner Store) *ReadOnlyStore {
+	return &ReadOnlyStore{Store: inner}
+}
+
+func (s *ReadOnlyStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+	return NewReadOnlyStore(inner), nil
+}
+
+// SetOverwrite is ignored, the setting of the inner store possibly being
+// shared with writers.
+func (s *ReadOnlyStore) SetOverwrite(enabled bool) {}
+
+func (s *ReadOnlyStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return "", fmt.Errorf("presign put %q: %w", base, ErrReadOnly)
+}
+
+func (s *ReadOnlyStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	return fmt.Errorf("write %q: %w", base, ErrReadOnly)
+}
+
+// PushLocalFile fails and leaves `localFile` untouched.
+func (s *ReadOnlyStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	return fmt.Errorf("push %q: %w", toBaseName, ErrReadOnly)
+}
+
+func (s *ReadOnlyStore) CopyObject(ctx context.Context, src, dst string) error {
+	return fmt.Errorf("copy %q to %q: %w", src, dst, ErrReadOnly)
+}
+
+func (s *ReadOnlyStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	return fmt.Errorf("rename %q to %q: %w", oldName, newName, ErrReadOnly)
+}
+
+func (s *ReadOnlyStore) DeleteObject(ctx context.Context, base string) error {
+	return fmt.Errorf("delete %q: %w", base, ErrReadOnly)
+}
+
+func (s *ReadOnlyStore) DeleteObjects(ctx context.Context, names []string) error {
+	return fmt.Errorf("delete objects: %w", ErrReadOnly)
+}
+
+func (s *ReadOnlyStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	return 0, fmt.Errorf("delete prefix %q: %w", prefix, ErrReadOnly)
+}
@@ -0,0 +1,51 @@
+package dstore
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnlyStore(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+	require.NoError(t, inner.WriteObject(ctx, "sub/file", strings.NewReader("content")))
+
+	root := NewReadOnlyStore(inner)
+	store, err := root.SubStore("sub")
+	require.NoError(t, err)
+
+	content, err := ReadObject(ctx, store, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+	files, err := store.ListFiles(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"file"}, files)
+
+	localFile := filepath.Join(t.TempDir(), "local")
+	require.NoError(t, ioutil.WriteFile(localFile, []byte("local"), 0644))
+
+	assert.ErrorIs(t, store.WriteObject(ctx, "file", strings.NewReader("changed")), ErrReadOnly)
+	assert.ErrorIs(t, store.PushLocalFile(ctx, localFile, "local"), ErrReadOnly)
+	assert.FileExists(t, localFile)
+	assert.ErrorIs(t, store.CopyObject(ctx, "file", "copy"), ErrReadOnly)
+	assert.ErrorIs(t, store.RenameObject(ctx, "file", "renamed"), ErrReadOnly)
+	assert.ErrorIs(t, store.DeleteObject(ctx, "file"), ErrReadOnly)
+	assert.ErrorIs(t, store.DeleteObjects(ctx, []string{"file"}), ErrReadOnly)
+	_, err = store.DeletePrefix(ctx, "")
+	assert.ErrorIs(t, err, ErrReadOnly)
+	_, err = store.PresignPut(ctx, "file", time.Minute)
+	assert.ErrorIs(t, err, ErrReadOnly)
+
+	store.SetOverwrite(true)
+	assert.False(t, inner.Overwrite())
+	content, err = ReadObject(ctx, inner, "sub/file")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
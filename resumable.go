@@ -0,0 +1,141 @@
+package dstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// UploadCheckpoint is the state of a resumable write, to be persisted by the
+// caller so that a crashed process can finish the upload with `ResumeWrite`
+// instead of starting over. It marshals to JSON.
+type UploadCheckpoint struct {
+	// Name is the name of the object written.
+	Name string `json:"name"`
+	// Session identifies the upload on the backend, the session URI of Google
+	// Storage resumable uploads or the upload ID of S3 multipart uploads.
+	Session string `json:"session"`
+	// PartSize is the size of the chunks sent to the backend, kept for the
+	// whole upload.
+	PartSize int64 `json:"part_size"`
+	// Offset is the number of bytes of the content stored by the backend when
+	// the checkpoint was saved. Resuming asks the backend, so a checkpoint
+	// saved a bit late is fine.
+	Offset int64 `json:"offset"`
+	// CompressedCRC32C is the CRC32C of the content once compressed, on
+	// compressed stores, which compress the content again when resuming and
+	// check that they produced the same bytes.
+	CompressedCRC32C uint32 `json:"compressed_crc32c,omitempty"`
+}
+
+// resumableWriter is implemented by the stores supporting resumable writes.
+type resumableWriter interface {
+	compression() string
+	spoolChecksummed(ctx context.Context, f io.Reader) (*checksummedUpload, error)
+	startResumableWrite(ctx context.Context, name string, size int64, config *writeConfig) (*UploadCheckpoint, error)
+	resumeWrite(ctx context.Context, checkpoint *UploadCheckpoint, content io.ReadSeeker, size int64, save func(checkpoint *UploadCheckpoint) error) error
+}
+
+// WriteResumable writes `content` to the object `name` through an upload that
+// survives the process, calling `save` with the checkpoint of the upload once
+// it is created and after each chunk stored by the backend. When the write
+// fails, the last checkpoint saved is passed to `ResumeWrite` along with the
+// same content to finish it. An error returned by `save` aborts the write.
+//
+// It is supported by the Google Storage (resumable uploads) and S3 (multipart
+// uploads) stores, the content being sent from the offset reached. Compressed
+// stores compress the whole content to a temporary file first, and resume on
+// the compressed bytes. Other stores return `ErrNotSupported`. Like
+// `WriteObject`, existing objects are silently kept unless the store
+// overwrites them. The write options set the metadata, ACL and headers of the
+// object, applied when the upload is created.
+func WriteResumable(ctx context.Context, store Store, name string, content io.ReadSeeker, save func(checkpoint *UploadCheckpoint) error, opts ...WriteOption) error {
+	writer, ok := store.(resumableWriter)
+	if !ok {
+		return fmt.Errorf("resumable write to %T: %w", store, ErrNotSupported)
+	}
+
+	if !store.Overwrite() {
+		exists, err := store.FileExists(ctx, name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+	}
+
+	content, size, crc, cleanup, err := resumableContent(ctx, writer, content)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	checkpoint, err := writer.startResumableWrite(ctx, name, size, newWriteConfig(opts))
+	if err != nil {
+		return fmt.Errorf("starting resumable write of %q: %w", name, err)
+	}
+	checkpoint.CompressedCRC32C = crc
+	if err := save(checkpoint); err != nil {
+		return fmt.Errorf("saving checkpoint: %w", err)
+	}
+
+	return writer.resumeWrite(ctx, checkpoint, content, size, save)
+}
+
+// ResumeWrite finishes the resumable write of `checkpoint`, started by
+// `WriteResumable`, sending `content` from the offset stored by the backend.
+// `content` must be the whole content originally written, and `save` is
+// called with the updated checkpoint after each chunk.
+func ResumeWrite(ctx context.Context, store Store, checkpoint *UploadCheckpoint, content io.ReadSeeker, save func(checkpoint *UploadCheckpoint) error) error {
+	writer, ok := store.(resumableWriter)
+	if !ok {
+		return fmt.Errorf("resumable write to %T: %w", store, ErrNotSupported)
+	}
+
+	content, size, crc, cleanup, err := resumableContent(ctx, writer, content)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if crc != checkpoint.CompressedCRC32C {
+		return fmt.Errorf("resuming write of %q: compressed content differs from the one started, the write must start over", checkpoint.Name)
+	}
+	return writer.resumeWrite(ctx, checkpoint, content, size, save)
+}
+
+// resumableContent returns the bytes sent by the resumable write of
+// `content`, along with their size and, on compressed stores, their CRC32C.
+// Compressed stores spool the compressed content to a temporary file, removed
+// by `cleanup`.
+func resumableContent(ctx context.Context, writer resumableWriter, content io.ReadSeeker) (out io.ReadSeeker, size int64, crc uint32, cleanup func(), err error) {
+	if writer.compression() == "" {
+		size, err := content.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, 0, 0, nil, fmt.Errorf("content size: %w", err)
+		}
+		return content, size, 0, func() {}, nil
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, 0, nil, fmt.Errorf("seeking content: %w", err)
+	}
+	upload, err := writer.spoolChecksummed(ctx, content)
+	if err != nil {
+		return nil, 0, 0, nil, fmt.Errorf("compressing content: %w", err)
+	}
+	return upload.file, upload.size, upload.crc32c, upload.remove, nil
+}
+
+// readChunk reads the `length` bytes of `content` at `offset` into `buffer`.
+func readChunk(content io.ReadSeeker, buffer []byte, offset, length int64) ([]byte, error) {
+	if _, err := content.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seeking content: %w", err)
+	}
+	chunk := buffer[:length]
+	if _, err := io.ReadFull(content, chunk); err != nil {
+		return nil, fmt.Errorf("reading content: %w", err)
+	}
+	return chunk, nil
+}
@@ -0,0 +1,95 @@
+package dstore
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// resumingReader reads the stored content of an object, reopening it at the
+// offset reached when reading fails with a retryable error.
+type resumingReader struct {
+	ctx    context.Context
+	name   string
+	open   func(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error)
+	policy RetryPolicy
+	logger *zap.Logger
+
+	reader   io.ReadCloser
+	offset   int64
+	failures int
+	err      error
+}
+
+// resumeReads wraps `reader`, the stored content of the object `name`, so
+// that reads failing mid-stream resume through `open`, typically the
+// `openStoredRange` of the store, when the store resumes reads.
+func (c *commonStore) resumeReads(ctx context.Context, name string, reader io.ReadCloser, open func(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error)) io.ReadCloser {
+	if c.readResumes <= 0 {
+		return reader
+	}
+
+	return &resumingReader{
+		ctx:    ctx,
+		name:   name,
+		open:   open,
+		policy: RetryPolicy{MaxAttempts: c.readResumes + 1}.withDefaults(),
+		logger: c.logger,
+		reader: reader,
+	}
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	for {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		n, err := r.reader.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			r.failures = 0
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+
+		r.err = r.resume(err)
+		if n > 0 || r.err != nil {
+			return n, r.err
+		}
+	}
+}
+
+// resume reopens the object at the offset reached after the failure `err`,
+// returning the error to report when it can't be resumed.
+func (r *resumingReader) resume(err error) error {
+	for {
+		r.failures++
+		if r.failures >= r.policy.MaxAttempts || r.ctx.Err() != nil || !r.policy.Retryable(err) {
+			return err
+		}
+
+		delay := r.policy.backoff(r.failures)
+		r.logger.Warn("resuming interrupted read", zap.String("name", r.name), zap.Int64("offset", r.offset), zap.Int("attempt", r.failures), zap.Duration("delay", delay), zap.Error(err))
+		select {
+		case <-time.After(delay):
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		}
+
+		reader, openErr := r.open(r.ctx, r.name, r.offset, -1)
+		if openErr == nil {
+			r.reader.Close()
+			r.reader = reader
+			return nil
+		}
+		err = openErr
+	}
+}
+
+func (r *resumingReader) Close() error {
+	return r.reader.Close()
+}
@@ -0,0 +1,95 @@
+//go:build !dstore_no_http
+
+package dstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBreakingServer serves `content`, breaking the connection of the next
+// `breaks` responses after the first `breakAfter` bytes.
+func newBreakingServer(t *testing.T, content string, breaks, breakAfter int) (*httptest.Server, func() []string) {
+	var lock sync.Mutex
+	var ranges []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		broken := breaks > 0
+		breaks--
+		lock.Unlock()
+
+		var offset int
+		if byteRange := r.Header.Get("Range"); byteRange != "" {
+			fmt.Sscanf(byteRange, "bytes=%d-", &offset)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(content)-1, len(content)))
+		}
+		body := content[offset:]
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		if offset > 0 {
+			w.WriteHeader(http.StatusPartialContent)
+		}
+
+		if !broken {
+			io.WriteString(w, body)
+			return
+		}
+
+		io.WriteString(w, body[:breakAfter])
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return ranges
+	}
+}
+
+func TestResumeReads(t *testing.T) {
+	ctx := context.Background()
+	content := strings.Repeat("0123456789", 1000)
+	server, ranges := newBreakingServer(t, content, 2, 3000)
+
+	base, _ := url.Parse(server.URL + "/blocks?read_resumes=2")
+	store, err := NewHTTPStoreWithOptions(base)
+	require.NoError(t, err)
+	assert.Equal(t, 2, store.readResumes)
+
+	reader, err := store.OpenObject(ctx, "0001")
+	require.NoError(t, err)
+	read, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, content, string(read))
+	assert.Equal(t, []string{"", "bytes=3000-", "bytes=6000-"}, ranges())
+
+	server, _ = newBreakingServer(t, content, 1, 3000)
+	base, _ = url.Parse(server.URL + "/blocks")
+	store, err = NewHTTPStoreWithOptions(base)
+	require.NoError(t, err)
+
+	reader, err = store.OpenObject(ctx, "0001")
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(reader)
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "reads are not resumed by default, got %v", err)
+	reader.Close()
+}
@@ -0,0 +1,446 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//
+// Retrying Store
+//
+
+// RetryPolicy configures the retries of a `RetryingStore`, zero values picking
+// the defaults.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times an operation is attempted before its
+	// last error is returned, 5 by default.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, 500ms by default.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between two attempts, 30s by default. A longer
+	// wait requested by the `Retry-After` header of the backend is honored.
+	MaxBackoff time.Duration
+	// Multiplier grows the wait after each retry, 2 by default.
+	Multiplier float64
+	// Jitter randomly shortens each wait by up to this fraction of it, so that
+	// clients failing together don't retry together, 0.2 by default. A
+	// negative value disables it.
+	Jitter float64
+	// Retryable tells whether an error is worth retrying, `IsRetryableError`
+	// by default.
+	Retryable func(err error) bool
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 5
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 500 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 30 * time.Second
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.Jitter == 0 {
+		p.Jitter = 0.2
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+	if p.Retryable == nil {
+		p.Retryable = IsRetryableError
+	}
+	return p
+}
+
+// backoff returns the wait before the attempt following the failed `attempt`.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	return time.Duration(delay * (1 - p.Jitter*rand.Float64()))
+}
+
+// IsRetryableError tells whether `err` is likely transient, matching
+// `ErrTransient`: network failures, timeouts, throttling and server errors of
+// the backends. Missing objects, unsupported operations, canceled contexts and
+// open circuits are not.
+func IsRetryableError(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, ErrNotFound),
+		errors.Is(err, ErrNotSupported),
+		errors.Is(err, ErrReadOnly),
+		errors.Is(err, ErrChecksumMismatch),
+		errors.Is(err, ErrCircuitOpen):
+		return false
+	}
+
+	return errors.Is(withErrorClass(err), ErrTransient)
+}
+
+// RetryingStore is a `Store` retrying the operations of the inner store that
+// fail with retryable errors, waiting an exponential backoff between attempts.
+// Walks resume after the last file passed to the callback, reads of opened
+// objects resume at the offset reached, and writes replay their content, which
+// is spooled to a temporary file unless the reader is an `io.Seeker`. Errors
+// returned by walk callbacks are never retried.
+type RetryingStore struct {
+	// Store is the inner store, receiving the retried operations.
+	Store
+
+	policy RetryPolicy
+}
+
+func NewRetryingStore(inner Store, policy RetryPolicy) *RetryingStore {
+	return &RetryingStore{
+		Store:  inner,
+		policy: policy.withDefaults(),
+	}
+}
+
+// permanentError stops the retries of an operation, `retry` returning the
+// wrapped error.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// retry runs `f` until it succeeds, fails with a non-retryable error or runs
+// out of attempts.
+func (s *RetryingStore) retry(ctx context.Context, operation, name string, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if permanent, ok := err.(*permanentError); ok {
+			return permanent.err
+		}
+		if !s.shouldRetry(ctx, err, attempt) {
+			return err
+		}
+		if err := s.wait(ctx, operation, name, attempt, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *RetryingStore) shouldRetry(ctx context.Context, err error, attempt int) bool {
+	return err != nil && attempt < s.policy.MaxAttempts && ctx.Err() == nil && s.policy.Retryable(err)
+}
+
+// wait sleeps for the backoff of `attempt`, or longer when the backend asked
+// to through the `Retry-After` header of a throttled answer, so that retrying
+// does not make the throttling worse.
+func (s *RetryingStore) wait(ctx context.Context, operation, name string, attempt int, err error) error {
+	delay := s.policy.backoff(attempt)
+	if requested := retryAfter(err); requested > delay {
+		delay = requested
+	}
+	storeLogger(s.Store).Warn("retrying failed store operation", zap.String("operation", operation), zap.String("name", name), zap.Int("attempt", attempt), zap.Duration("delay", delay), zap.Error(err))
+
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *RetryingStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := *s
+	sub.Store = inner
+	return &sub, nil
+}
+
+func (s *RetryingStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	return s.OpenObjectRange(ctx, name, 0, -1)
+}
+
+func (s *RetryingStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	reader := &retryingReader{ctx: ctx, store: s, name: name, offset: offset, length: length}
+	err = s.retry(ctx, "open", name, func() (err error) {
+		reader.reader, err = reader.open()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// retryingReader reopens the object at the offset reached when reading fails
+// with a retryable error.
+type retryingReader struct {
+	ctx   context.Context
+	store *RetryingStore
+	name  string
+
+	reader io.ReadCloser
+	// offset is the position of the next byte to read and length the number
+	// of bytes left to read, negative when reading up to the end.
+	offset, length int64
+	failures       int
+	err            error
+}
+
+func (r *retryingReader) open() (io.ReadCloser, error) {
+	if r.offset == 0 && r.length < 0 {
+		return r.store.Store.OpenObject(r.ctx, r.name)
+	}
+	return r.store.Store.OpenObjectRange(r.ctx, r.name, r.offset, r.length)
+}
+
+func (r *retryingReader) Read(p []byte) (int, error) {
+	for {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		n, err := r.reader.Read(p)
+		r.offset += int64(n)
+		if r.length > 0 {
+			r.length -= int64(n)
+		}
+		if n > 0 {
+			r.failures = 0
+		}
+		if err == nil || err == io.EOF || r.length == 0 {
+			return n, err
+		}
+
+		r.err = r.resume(err)
+		if n > 0 || r.err != nil {
+			return n, r.err
+		}
+	}
+}
+
+// resume reopens the object after the failure `err`, returning the error to
+// report when it can't be retried.
+func (r *retryingReader) resume(err error) error {
+	r.reader.Close()
+	r.reader = nil
+
+	for {
+		r.failures++
+		if !r.store.shouldRetry(r.ctx, err, r.failures) {
+			return err
+		}
+		if err := r.store.wait(r.ctx, "read", r.name, r.failures, err); err != nil {
+			return err
+		}
+
+		r.reader, err = r.open()
+		if err == nil {
+			return nil
+		}
+	}
+}
+
+func (r *retryingReader) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	return r.reader.Close()
+}
+
+func (s *RetryingStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	err = s.retry(ctx, "exists", base, func() (err error) {
+		exists, err = s.Store.FileExists(ctx, base)
+		return err
+	})
+	return exists, err
+}
+
+func (s *RetryingStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	err = s.retry(ctx, "attributes", base, func() (err error) {
+		attrs, err = s.Store.ObjectAttributes(ctx, base)
+		return err
+	})
+	return attrs, err
+}
+
+func (s *RetryingStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	if s.policy.MaxAttempts == 1 {
+		return s.Store.WriteObject(ctx, base, f, opts...)
+	}
+
+	content, seekable := f.(io.ReadSeeker)
+	var start int64
+	if seekable {
+		start, err = content.Seek(0, io.SeekCurrent)
+		seekable = err == nil
+	}
+	if !seekable {
+		spooled, _, err := spoolContent(f)
+		if err != nil {
+			return err
+		}
+		defer removeSpooledContent(spooled)
+		content, start = spooled, 0
+	}
+
+	return s.retry(ctx, "write", base, func() error {
+		if _, err := content.Seek(start, io.SeekStart); err != nil {
+			return &permanentError{fmt.Errorf("rewind content: %w", err)}
+		}
+		return s.Store.WriteObject(ctx, base, content, opts...)
+	})
+}
+
+func (s *RetryingStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+func (s *RetryingStore) CopyObject(ctx context.Context, src, dst string) error {
+	return s.retry(ctx, "copy", src, func() error {
+		return s.Store.CopyObject(ctx, src, dst)
+	})
+}
+
+func (s *RetryingStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	return s.retry(ctx, "rename", oldName, func() error {
+		return s.Store.RenameObject(ctx, oldName, newName)
+	})
+}
+
+func (s *RetryingStore) DeleteObject(ctx context.Context, base string) error {
+	return s.retry(ctx, "delete", base, func() error {
+		return s.Store.DeleteObject(ctx, base)
+	})
+}
+
+func (s *RetryingStore) DeleteObjects(ctx context.Context, names []string) error {
+	return s.retry(ctx, "delete objects", "", func() error {
+		return s.Store.DeleteObjects(ctx, names)
+	})
+}
+
+// DeletePrefix retries the deletion of the objects left by the failed
+// attempts, returning the number of objects deleted by all the attempts.
+func (s *RetryingStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	err = s.retry(ctx, "delete prefix", prefix, func() error {
+		count, err := s.Store.DeletePrefix(ctx, prefix)
+		deleted += count
+		return err
+	})
+	return deleted, err
+}
+
+func (s *RetryingStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
+	return s.walk(ctx, prefix, startingPoint, "", f)
+}
+
+func (s *RetryingStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error {
+	return s.walk(ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *RetryingStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error {
+	return s.walk(ctx, prefix, "", "", f)
+}
+
+// walk resumes failed walks from the last file passed to `f`, relying on
+// listings being lexicographically ordered to skip it.
+func (s *RetryingStore) walk(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error {
+	var last string
+	var walked bool
+
+	return s.retry(ctx, "walk", prefix, func() error {
+		from := startingPoint
+		if walked {
+			from = last
+		}
+
+		var callbackErr error
+		err := s.Store.WalkBetween(ctx, prefix, from, endPoint, func(filename string) error {
+			if walked && filename <= last {
+				return nil
+			}
+			if err := f(filename); err != nil {
+				callbackErr = err
+				return err
+			}
+			last, walked = filename, true
+			return nil
+		})
+		if callbackErr != nil && err != nil {
+			return &permanentError{err}
+		}
+		return err
+	})
+}
+
+func (s *RetryingStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) error {
+	var last string
+	var walked bool
+
+	return s.retry(ctx, "walk", prefix, func() error {
+		var callbackErr error
+		err := s.Store.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+			if walked && attrs.Name <= last {
+				return nil
+			}
+			if err := f(attrs); err != nil {
+				callbackErr = err
+				return err
+			}
+			last, walked = attrs.Name, true
+			return nil
+		})
+		if callbackErr != nil && err != nil {
+			return &permanentError{err}
+		}
+		return err
+	})
+}
+
+func (s *RetryingStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	err = s.retry(ctx, "list", prefix, func() (err error) {
+		files, err = s.Store.ListFiles(ctx, prefix, max)
+		return err
+	})
+	return files, err
+}
+
+func (s *RetryingStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	err = s.retry(ctx, "list", prefix, func() (err error) {
+		files, nextToken, err = s.Store.ListFilesPage(ctx, prefix, pageSize, pageToken)
+		return err
+	})
+	return files, nextToken, err
+}
+
+func (s *RetryingStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	err = s.retry(ctx, "list", prefix, func() (err error) {
+		out, err = s.Store.ListDirectories(ctx, prefix)
+		return err
+	})
+	return out, err
+}
@@ -0,0 +1,271 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+)
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+func TestRetryingStore_Walk(t *testing.T) {
+	ctx := context.Background()
+	inner := &flakyStore{MemoryStore: NewMemoryStore()}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, WriteObjectBytes(ctx, inner, name, []byte(name)))
+	}
+	store := NewRetryingStore(inner, testRetryPolicy)
+	inner.calls = 0
+
+	var walked []string
+	inner.failures = 2
+	require.NoError(t, store.Walk(ctx, "", func(filename string) error {
+		walked = append(walked, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, walked)
+	assert.Equal(t, 3, inner.calls)
+
+	walked, inner.calls = nil, 0
+	inner.failures = 1
+	require.NoError(t, store.WalkBetween(ctx, "", "b", "e", func(filename string) error {
+		walked = append(walked, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{"b", "c", "d"}, walked)
+	assert.Equal(t, 2, inner.calls)
+
+	walked, inner.calls = nil, 0
+	inner.failures = 1
+	require.NoError(t, store.WalkObjects(ctx, "", func(attrs *ObjectAttrs) error {
+		walked = append(walked, attrs.Name)
+		return nil
+	}))
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, walked)
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestRetryingStore_WalkCallbackErrors(t *testing.T) {
+	ctx := context.Background()
+	inner := &flakyStore{MemoryStore: NewMemoryStore()}
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, WriteObjectBytes(ctx, inner, name, []byte(name)))
+	}
+	store := NewRetryingStore(inner, testRetryPolicy)
+	inner.calls = 0
+
+	// Callback errors are returned as-is, even when retryable
+	err := store.Walk(ctx, "", func(filename string) error {
+		return errFlaky
+	})
+	assert.Equal(t, errFlaky, err)
+	assert.Equal(t, 1, inner.calls)
+
+	var walked []string
+	require.NoError(t, store.Walk(ctx, "", func(filename string) error {
+		walked = append(walked, filename)
+		return StopIteration
+	}))
+	assert.Equal(t, []string{"a"}, walked)
+}
+
+func TestRetryingStore_OpenObject(t *testing.T) {
+	ctx := context.Background()
+	inner := &flakyStore{MemoryStore: NewMemoryStore()}
+	require.NoError(t, WriteObjectBytes(ctx, inner, "file", []byte("0123456789")))
+	store := NewRetryingStore(inner, testRetryPolicy)
+	inner.calls = 0
+
+	inner.failures = 2
+	read, err := ReadObject(ctx, store, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(read))
+	assert.Equal(t, 3, inner.calls)
+
+	inner.failures, inner.calls = 1, 0
+	reader, err := store.OpenObjectRange(ctx, "file", 2, 6)
+	require.NoError(t, err)
+	read, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, "234567", string(read))
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestRetryingStore_WriteObject(t *testing.T) {
+	ctx := context.Background()
+	inner := &flakyStore{MemoryStore: NewMemoryStore()}
+	store := NewRetryingStore(inner, testRetryPolicy)
+
+	// Readers that can't seek are spooled
+	inner.failures = 2
+	require.NoError(t, store.WriteObject(ctx, "file", io.MultiReader(strings.NewReader("01234"), strings.NewReader("56789"))))
+	assert.Equal(t, 3, inner.calls)
+	read, err := ReadObject(ctx, inner, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(read))
+
+	content := bytes.NewReader([]byte("xx0123"))
+	content.Seek(2, io.SeekStart)
+	inner.failures, inner.calls = 1, 0
+	require.NoError(t, store.WriteObject(ctx, "other", content))
+	assert.Equal(t, 2, inner.calls)
+	read, err = ReadObject(ctx, inner, "other")
+	require.NoError(t, err)
+	assert.Equal(t, "0123", string(read))
+
+	inner.failures, inner.calls = 5, 0
+	err = store.WriteObject(ctx, "failed", strings.NewReader("content"))
+	assert.Equal(t, errFlaky, err)
+	assert.Equal(t, 3, inner.calls)
+}
+
+func TestRetryingStore_NotRetryable(t *testing.T) {
+	ctx := context.Background()
+	inner := &flakyStore{MemoryStore: NewMemoryStore()}
+	store := NewRetryingStore(inner, testRetryPolicy)
+
+	_, err := store.ObjectAttributes(ctx, "missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	inner.failures = 5
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = store.WriteObject(canceled, "file", strings.NewReader("content"))
+	assert.Equal(t, errFlaky, err)
+	assert.Equal(t, 1, inner.calls)
+}
+
+func TestRetryingStore_RetryAfter(t *testing.T) {
+	ctx := context.Background()
+	inner := &throttledStore{MemoryStore: NewMemoryStore(), throttled: 1}
+	store := NewRetryingStore(inner, testRetryPolicy)
+
+	start := time.Now()
+	require.NoError(t, store.WriteObject(ctx, "file", strings.NewReader("content")))
+	assert.Equal(t, 0, inner.throttled)
+	assert.True(t, time.Since(start) >= time.Second, "waited %s", time.Since(start))
+}
+
+// throttledStore fails the next `throttled` writes with a rate limiting error
+// asking to retry after a second.
+type throttledStore struct {
+	*MemoryStore
+
+	throttled int
+}
+
+func (s *throttledStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) error {
+	if s.throttled > 0 {
+		s.throttled--
+		return &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"1"}}}
+	}
+	return s.MemoryStore.WriteObject(ctx, base, f, opts...)
+}
+
+func TestIsRetryableError(t *testing.T) {
+	for _, test := range []struct {
+		err       error
+		retryable bool
+	}{
+		{err: nil, retryable: false},
+		{err: errors.New("some error"), retryable: false},
+		{err: fmt.Errorf("open: %w", ErrNotFound), retryable: false},
+		{err: fmt.Errorf("read: %w", context.DeadlineExceeded), retryable: false},
+		{err: io.ErrUnexpectedEOF, retryable: true},
+		{err: fmt.Errorf("read: %w", syscall.ECONNRESET), retryable: true},
+		{err: &googleapi.Error{Code: http.StatusServiceUnavailable}, retryable: true},
+		{err: fmt.Errorf("walk: %w", &googleapi.Error{Code: http.StatusTooManyRequests}), retryable: true},
+		{err: &googleapi.Error{Code: http.StatusForbidden}, retryable: false},
+		{err: awserr.NewRequestFailure(awserr.New("InternalError", "internal error", nil), http.StatusInternalServerError, "id"), retryable: true},
+		{err: awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), http.StatusForbidden, "id"), retryable: false},
+		{err: awserr.New("RequestError", "send request failed", syscall.ECONNREFUSED), retryable: true},
+	} {
+		assert.Equal(t, test.retryable, IsRetryableError(test.err), "%v", test.err)
+	}
+}
+
+var errFlaky = &googleapi.Error{Code: http.StatusServiceUnavailable}
+
+// flakyStore fails the next `failures` walks, reads and writes with a
+// retryable error, walks after the first file, reads after the first byte and
+// writes after consuming part of the content.
+type flakyStore struct {
+	*MemoryStore
+
+	failures int
+	calls    int
+}
+
+func (s *flakyStore) fail() bool {
+	s.calls++
+	if s.failures > 0 {
+		s.failures--
+		return true
+	}
+	return false
+}
+
+func (s *flakyStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error {
+	fail := s.fail()
+	var walked int
+	return s.MemoryStore.WalkBetween(ctx, prefix, startingPoint, endPoint, func(filename string) error {
+		if fail && walked == 1 {
+			return errFlaky
+		}
+		walked++
+		return f(filename)
+	})
+}
+
+func (s *flakyStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) error {
+	fail := s.fail()
+	var walked int
+	return s.MemoryStore.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		if fail && walked == 1 {
+			return errFlaky
+		}
+		walked++
+		return f(attrs)
+	})
+}
+
+func (s *flakyStore) OpenObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.OpenObjectRange(ctx, name, 0, -1)
+}
+
+func (s *flakyStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	fail := s.fail()
+	reader, err := s.MemoryStore.OpenObjectRange(ctx, name, offset, length)
+	if err != nil || !fail {
+		return reader, err
+	}
+	return &readCloser{Reader: io.MultiReader(io.LimitReader(reader, 1), &failingReader{}), Closer: reader}, nil
+}
+
+func (s *flakyStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) error {
+	if s.fail() {
+		io.Copy(ioutil.Discard, io.LimitReader(f, 2))
+		return errFlaky
+	}
+	return s.MemoryStore.WriteObject(ctx, base, f, opts...)
+}
+
+type failingReader struct{}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	return 0, errFlaky
+}
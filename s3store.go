@@ -3,6 +3,8 @@ package dstore
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -17,6 +19,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -54,23 +57,41 @@ func init() {
 type S3Store struct {
 	baseURL *url.URL
 
-	bucket   string
-	path     string
-	service  *s3.S3
-	uploader *s3manager.Uploader
-	context  context.Context
+	bucket          string
+	path            string
+	service         *s3.S3
+	uploader        *s3manager.Uploader
+	context         context.Context
+	credentialsFile string
+
+	multipartThreshold int64
+	// objectLock sends the `Content-MD5` of every upload request, required
+	// by buckets with Object Lock.
+	objectLock bool
+
+	// listPageSize is the `MaxKeys` of listing requests, zero leaving the
+	// server's default of 1000 keys.
+	listPageSize int64
 
 	*commonStore
 }
 
 func NewS3Store(baseURL *url.URL, extension, compressionType string, overwrite bool) (*S3Store, error) {
+	return NewS3StoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+func NewS3StoreWithOptions(baseURL *url.URL, opts ...Option) (*S3Store, error) {
+	config := newCloudConfig(opts)
 	s := &S3Store{
-		baseURL: baseURL,
-		commonStore: &commonStore{
-			compressionType: compressionType,
-			extension:       extension,
-			overwrite:       overwrite,
-		},
+		baseURL:            baseURL,
+		credentialsFile:    config.credentialsFile,
+		multipartThreshold: config.multipartThreshold,
+		objectLock:         config.objectLock || baseURL.Query().Get("object_lock") == "true",
+		commonStore:        newCommonStore(baseURL, config),
+	}
+
+	if s.multipartThreshold != 0 && s.multipartThreshold < s3manager.MinUploadPartSize {
+		return nil, fmt.Errorf("multipart threshold must be at least %d bytes, got %d", s3manager.MinUploadPartSize, s.multipartThreshold)
 	}
 
 	awsConfig, bucket, path, err := ParseS3URL(baseURL)
@@ -78,13 +99,35 @@ func NewS3Store(baseURL *url.URL, extension, compressionType string, overwrite b
 		return nil, fmt.Errorf("invalid s3 url: %w", err)
 	}
 
+	compat, err := parseS3Compat(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 url: %w", err)
+	}
+	s.listPageSize = compat.listPageSize
+
+	if awsConfig.Credentials == nil && config.credentialsFile != "" {
+		awsConfig.Credentials = credentials.NewSharedCredentials(config.credentialsFile, "")
+	}
+	if s.httpClient != nil {
+		awsConfig.HTTPClient = s.httpClient
+	}
+
 	sess, err := session.NewSession(awsConfig)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching AWS session info from env: %w", err)
 	}
 
+	sess.Handlers.Complete.PushBack(s3RetryAfter)
+
 	s.service = s3.New(sess)
-	s.uploader = s3manager.NewUploader(sess)
+	s.uploader = s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+		if s.multipartThreshold != 0 {
+			u.PartSize = s.multipartThreshold
+		}
+		// Incomplete multipart uploads are billed until aborted, make sure
+		// they are on error
+		u.LeavePartsOnError = false
+	})
 	s.bucket = bucket
 	s.path = path
 
@@ -97,7 +140,11 @@ func (s *S3Store) SubStore(subFolder string) (Store, error) {
 		return nil, fmt.Errorf("s3 store parsing base url: %w", err)
 	}
 	url.Path = path.Join(url.Path, subFolder)
-	return NewS3Store(url, s.extension, s.compressionType, s.overwrite)
+	opts := append(s.options(), CredentialsFile(s.credentialsFile), MultipartThreshold(s.multipartThreshold))
+	if s.objectLock {
+		opts = append(opts, ObjectLock())
+	}
+	return NewS3StoreWithOptions(url, opts...)
 }
 
 func ParseS3URL(s3URL *url.URL) (config *aws.Config, bucket string, path string, err error) {
@@ -110,6 +157,11 @@ func ParseS3URL(s3URL *url.URL) (config *aws.Config, bucket string, path string,
 		Region: &region,
 	}
 
+	compat, err := parseS3Compat(s3URL)
+	if err != nil {
+		return nil, "", "", err
+	}
+
 	hasEndpoint := hasCustomEndpoint(s3URL)
 	if hasEndpoint {
 		awsConfig.Endpoint = aws.String(s3URL.Host)
@@ -129,6 +181,13 @@ func ParseS3URL(s3URL *url.URL) (config *aws.Config, bucket string, path string,
 		path = s3URL.Path
 	}
 
+	if compat.pathStyle != nil {
+		awsConfig.S3ForcePathStyle = compat.pathStyle
+	}
+	if compat.disableChecksums {
+		awsConfig.S3DisableContentMD5Validation = aws.Bool(true)
+	}
+
 	accessKeyID := s3URL.Query().Get("access_key_id")
 	secretAccessKey := s3URL.Query().Get("secret_access_key")
 	if accessKeyID != "" && secretAccessKey != "" {
@@ -138,6 +197,69 @@ func ParseS3URL(s3URL *url.URL) (config *aws.Config, bucket string, path string,
 	return awsConfig, bucket, strings.Trim(path, "/"), nil
 }
 
+// s3Compat holds the knobs tuning the S3 store for S3-compatible servers,
+// configured through the query parameters of the store URL:
+//
+//   - `compat`: a profile presetting the knobs below, `minio` (path-style
+//     addressing and disabled checksums) or `ceph` (path-style addressing and
+//     listings of 100 keys per page for Ceph RGW)
+//   - `path_style`: `true` or `false` to force or disable path-style addressing,
+//     defaulting to path-style only with custom endpoints
+//   - `disable_checksums`: `true` to skip the `Content-MD5` header of uploads
+//     which some servers reject
+//   - `list_page_size`: the number of keys per listing request, lower values
+//     easing the load of servers slow at listing large buckets
+//
+// Explicit knobs take precedence over the profile.
+type s3Compat struct {
+	pathStyle        *bool
+	disableChecksums bool
+	listPageSize     int64
+}
+
+var s3CompatProfiles = map[string]s3Compat{
+	"minio": {pathStyle: aws.Bool(true), disableChecksums: true},
+	"ceph":  {pathStyle: aws.Bool(true), listPageSize: 100},
+}
+
+func parseS3Compat(s3URL *url.URL) (*s3Compat, error) {
+	query := s3URL.Query()
+
+	compat := s3Compat{}
+	if profile := query.Get("compat"); profile != "" {
+		var found bool
+		if compat, found = s3CompatProfiles[profile]; !found {
+			return nil, fmt.Errorf("unknown s3 compat profile %q, use minio or ceph", profile)
+		}
+	}
+
+	if value := query.Get("path_style"); value != "" {
+		pathStyle, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path_style %q: %w", value, err)
+		}
+		compat.pathStyle = aws.Bool(pathStyle)
+	}
+
+	if value := query.Get("disable_checksums"); value != "" {
+		disableChecksums, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid disable_checksums %q: %w", value, err)
+		}
+		compat.disableChecksums = disableChecksums
+	}
+
+	if value := query.Get("list_page_size"); value != "" {
+		listPageSize, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || listPageSize <= 0 || listPageSize > 1000 {
+			return nil, fmt.Errorf("invalid list_page_size %q, expecting a number of keys between 1 and 1000", value)
+		}
+		compat.listPageSize = listPageSize
+	}
+
+	return &compat, nil
+}
+
 func hasCustomEndpoint(s3URL *url.URL) bool {
 	// As soon as there is a port in the url, we are sure that's it's the
 	// hostname that should be configured, so move along
@@ -177,8 +299,29 @@ func (s *S3Store) ObjectURL(name string) string {
 	return fmt.Sprintf("%s/%s", strings.TrimRight(s.baseURL.String(), "/"), strings.TrimLeft(s.pathWithExt(name), "/"))
 }
 
-func (s *S3Store) WriteObject(ctx context.Context, base string, f io.Reader) (err error) {
+func (s *S3Store) PresignGet(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	req, _ := s.service.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.ObjectPath(base)),
+	})
+	return req.Presign(ttl)
+}
+
+func (s *S3Store) PresignPut(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	req, _ := s.service.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.ObjectPath(base)),
+	})
+	return req.Presign(ttl)
+}
+
+func (s *S3Store) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	defer classifyError(&err)
 	path := s.ObjectPath(base)
+	config := newWriteConfig(opts)
+	f = config.trackProgress(f)
 
 	exists, err := s.FileExists(ctx, base)
 	if err != nil {
@@ -190,12 +333,70 @@ func (s *S3Store) WriteObject(ctx context.Context, base string, f io.Reader) (er
 		return nil
 	}
 
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    &path,
+	}
+	if len(config.metadata) > 0 {
+		input.Metadata = aws.StringMap(config.metadata)
+	}
+
+	// S3 had no explicit defaults, so we only set them when configured
+	contentType, cacheControl := s.contentHeaders(config, "", "")
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if cacheControl != "" {
+		input.CacheControl = aws.String(cacheControl)
+	}
+	if config.acl != "" {
+		input.ACL = aws.String(config.acl)
+	}
+	if contentEncoding := s.contentEncoding(); contentEncoding != "" {
+		input.ContentEncoding = aws.String(contentEncoding)
+	}
+
+	if s.uploadChecksums || s.objectLock {
+		upload, err := s.spoolChecksummed(ctx, f)
+		if err != nil {
+			return err
+		}
+		defer upload.remove()
+
+		// Only used by single request uploads, the uploader computing the
+		// MD5 of each part of multipart uploads. Backends configured with
+		// `disable_checksums` get none, unless the bucket requires them.
+		var uploadOpts []func(*s3manager.Uploader)
+		if s.objectLock {
+			input.ContentMD5 = aws.String(upload.base64MD5())
+			uploadOpts = append(uploadOpts, s3manager.WithUploaderRequestOptions(s3ContentMD5))
+		} else if !aws.BoolValue(s.service.Config.S3DisableContentMD5Validation) {
+			input.ContentMD5 = aws.String(upload.base64MD5())
+		}
+		input.Body = upload.file
+		if _, err := s.uploader.UploadWithContext(ctx, input, uploadOpts...); err != nil {
+			return fmt.Errorf("uploading to S3 through manager: %w", err)
+		}
+		return s.captureAttrs(ctx, base, config)
+	}
+
+	if seeker, ok := f.(io.ReadSeeker); ok && s.compressionType == "" && !s.limitsWrites() {
+		// In-memory payloads and files are handed as-is to the uploader, which
+		// reads them in place instead of buffering the pipe below. Throttled
+		// writes go through the pipe, which consumes the write bandwidth.
+		input.Body = seeker
+		if _, err := s.uploader.UploadWithContext(ctx, input); err != nil {
+			return fmt.Errorf("uploading to S3 through manager: %w", err)
+		}
+		return s.captureAttrs(ctx, base, config)
+	}
+
 	pipeRead, pipeWrite := io.Pipe()
 	writeDone := make(chan error, 1)
 	ctx, cancel := context.WithCancel(ctx)
 
 	go func() {
-		err := s.compressedCopy(f, pipeWrite)
+		err := s.compressedCopy(ctx, f, pipeWrite)
 		writeDone <- err
 		pipeWrite.Close() // required to allow the uploader to complete
 
@@ -204,11 +405,8 @@ func (s *S3Store) WriteObject(ctx context.Context, base string, f io.Reader) (er
 		}
 	}()
 
-	_, err = s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
-		Bucket: aws.String(s.bucket),
-		Key:    &path,
-		Body:   pipeRead,
-	})
+	input.Body = pipeRead
+	_, err = s.uploader.UploadWithContext(ctx, input)
 	if err != nil {
 		select {
 		case err2 := <-writeDone:
@@ -223,13 +421,375 @@ func (s *S3Store) WriteObject(ctx context.Context, base string, f io.Reader) (er
 		return fmt.Errorf("uploading to S3 through manager: %w", err)
 	}
 
+	return s.captureAttrs(ctx, base, config)
+}
+
+func (s *S3Store) startResumableWrite(ctx context.Context, name string, size int64, config *writeConfig) (checkpoint *UploadCheckpoint, err error) {
+	defer classifyError(&err)
+
+	output, err := s.service.CreateMultipartUploadWithContext(ctx, s.multipartUploadInput(s.ObjectPath(name), config))
+	if err != nil {
+		return nil, err
+	}
+
+	partSize := s.multipartThreshold
+	if partSize == 0 {
+		partSize = s3manager.DefaultUploadPartSize
+	}
+	// Multipart uploads have a maximum number of parts
+	if minPartSize := (size + s3manager.MaxUploadParts - 1) / s3manager.MaxUploadParts; partSize < minPartSize {
+		partSize = minPartSize
+	}
+	return &UploadCheckpoint{Name: name, Session: aws.StringValue(output.UploadId), PartSize: partSize}, nil
+}
+
+func (s *S3Store) resumeWrite(ctx context.Context, checkpoint *UploadCheckpoint, content io.ReadSeeker, size int64, save func(checkpoint *UploadCheckpoint) error) (err error) {
+	defer classifyError(&err)
+	key := aws.String(s.ObjectPath(checkpoint.Name))
+	uploadID := aws.String(checkpoint.Session)
+
+	// Parts are uploaded in order, the ones already stored are kept up to the
+	// first gap or short part, the following ones being uploaded again
+	var completed []*s3.CompletedPart
+	var offset int64
+	err = s.service.ListPartsPagesWithContext(ctx, &s3.ListPartsInput{Bucket: aws.String(s.bucket), Key: key, UploadId: uploadID}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, part := range page.Parts {
+			if aws.Int64Value(part.PartNumber) != int64(len(completed)+1) || aws.Int64Value(part.Size) != checkpoint.PartSize {
+				return false
+			}
+			completed = append(completed, &s3.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+			offset += checkpoint.PartSize
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("listing uploaded parts: %w", err)
+	}
+
+	bufferSize := checkpoint.PartSize
+	if size < bufferSize {
+		bufferSize = size
+	}
+	buffer := make([]byte, bufferSize)
+	// Empty content is uploaded as a single empty part
+	for offset < size || len(completed) == 0 {
+		length := checkpoint.PartSize
+		if size-offset < length {
+			length = size - offset
+		}
+		chunk, err := readChunk(content, buffer, offset, length)
+		if err != nil {
+			return err
+		}
+
+		partNumber := aws.Int64(int64(len(completed) + 1))
+		input := &s3.UploadPartInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           key,
+			UploadId:      uploadID,
+			PartNumber:    partNumber,
+			Body:          bytes.NewReader(chunk),
+			ContentLength: aws.Int64(length),
+		}
+		if s.objectLock {
+			sum := md5.Sum(chunk)
+			input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+		}
+		output, err := s.service.UploadPartWithContext(ctx, input)
+		if err != nil {
+			return fmt.Errorf("uploading part %d: %w", *partNumber, err)
+		}
+		completed = append(completed, &s3.CompletedPart{ETag: output.ETag, PartNumber: partNumber})
+
+		offset += length
+		checkpoint.Offset = offset
+		if offset < size {
+			if err := save(checkpoint); err != nil {
+				return fmt.Errorf("saving checkpoint: %w", err)
+			}
+		}
+	}
+
+	_, err = s.service.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             key,
+		UploadId:        uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("completing multipart upload: %w", err)
+	}
+	return nil
+}
+
+// s3RetryAfterError keeps the `Retry-After` header of an S3 error answer,
+// which the SDK errors don't expose.
+type s3RetryAfterError struct {
+	awserr.RequestFailure
+	delay time.Duration
+}
+
+func (e *s3RetryAfterError) retryAfter() time.Duration {
+	return e.delay
+}
+
+// s3RetryAfter tags the error of the request with the wait requested by the
+// `Retry-After` header of its last answer, typically a `503 Slow Down`.
+func s3RetryAfter(r *request.Request) {
+	failure, ok := r.Error.(awserr.RequestFailure)
+	if !ok || r.HTTPResponse == nil {
+		return
+	}
+	if delay := parseRetryAfter(r.HTTPResponse.Header.Get("Retry-After"), time.Now()); delay > 0 {
+		r.Error = &s3RetryAfterError{RequestFailure: failure, delay: delay}
+	}
+}
+
+// s3ContentMD5 sets the `Content-MD5` header of the requests sent without one,
+// which the SDK skips when checksums are disabled.
+func s3ContentMD5(r *request.Request) {
+	r.Handlers.Build.PushBack(func(r *request.Request) {
+		if r.Error != nil || r.Body == nil || r.HTTPRequest.Header.Get("Content-Md5") != "" {
+			return
+		}
+
+		start, err := r.Body.Seek(0, io.SeekCurrent)
+		if err != nil {
+			r.Error = fmt.Errorf("content md5: %w", err)
+			return
+		}
+		hash := md5.New()
+		if _, err := io.Copy(hash, r.Body); err != nil {
+			r.Error = fmt.Errorf("content md5: %w", err)
+			return
+		}
+		if _, err := r.Body.Seek(start, io.SeekStart); err != nil {
+			r.Error = fmt.Errorf("content md5: %w", err)
+			return
+		}
+		r.HTTPRequest.Header.Set("Content-Md5", base64.StdEncoding.EncodeToString(hash.Sum(nil)))
+	})
+}
+
+// captureAttrs fills the attributes requested through `CaptureAttrs`, which
+// S3 uploads don't return.
+func (s *S3Store) captureAttrs(ctx context.Context, base string, config *writeConfig) error {
+	if config.attrs == nil {
+		return nil
+	}
+
+	attrs, err := s.ObjectAttributes(ctx, base)
+	if err != nil {
+		return fmt.Errorf("retrieving written object attributes: %w", err)
+	}
+	config.capture(attrs)
+	return nil
+}
+
+// s3MaxCopySize is the maximum size of an object that can be copied in a single
+// `CopyObject` request, bigger objects must be copied through a multipart upload.
+const s3MaxCopySize = 5 * 1024 * 1024 * 1024
+
+// s3CopyPartSize is the part size used when copying objects bigger than
+// `s3MaxCopySize`.
+const s3CopyPartSize = 512 * 1024 * 1024
+
+func (s *S3Store) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, s, dst); skip || err != nil {
+		return err
+	}
+
+	return s.copyPath(ctx, s.bucket, s.ObjectPath(src), s.ObjectPath(dst))
+}
+
+func (s *S3Store) copyPath(ctx context.Context, srcBucket, srcPath, dstPath string) error {
+	head, err := s.service.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(srcBucket),
+		Key:    aws.String(srcPath),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+			return ErrNotFound
+		}
+		return fmt.Errorf("head source object: %w", err)
+	}
+
+	copySource := url.PathEscape(srcBucket + "/" + srcPath)
+	size := aws.Int64Value(head.ContentLength)
+	if size <= s3MaxCopySize {
+		_, err := s.service.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(s.bucket),
+			Key:        aws.String(dstPath),
+			CopySource: aws.String(copySource),
+		})
+		return err
+	}
+
+	return s.multipartCopy(ctx, copySource, dstPath, size, head)
+}
+
+// multipartCopy copies the object part by part, the metadata and headers of
+// the source `head` must be passed explicitly as they are not carried over like
+// they are with a plain `CopyObject`.
+func (s *S3Store) multipartCopy(ctx context.Context, copySource, dstPath string, size int64, head *s3.HeadObjectOutput) error {
+	return s.multipartCopyRanges(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(dstPath),
+		Metadata:        head.Metadata,
+		ContentType:     head.ContentType,
+		CacheControl:    head.CacheControl,
+		ContentEncoding: head.ContentEncoding,
+	}, s3CopyRanges(copySource, size))
+}
+
+// s3CopyRange is a range of an object copied as a part of a multipart upload,
+// `end` being inclusive.
+type s3CopyRange struct {
+	copySource string
+	start, end int64
+}
+
+// s3CopyRanges splits the `size` bytes of `copySource` in ranges of at most
+// `s3CopyPartSize` bytes.
+func s3CopyRanges(copySource string, size int64) (ranges []s3CopyRange) {
+	for offset := int64(0); offset < size; offset += s3CopyPartSize {
+		end := offset + s3CopyPartSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		ranges = append(ranges, s3CopyRange{copySource: copySource, start: offset, end: end})
+	}
+	return ranges
+}
+
+// multipartCopyRanges creates the object described by `input` out of the
+// ranges, each copied as a part.
+func (s *S3Store) multipartCopyRanges(ctx context.Context, input *s3.CreateMultipartUploadInput, ranges []s3CopyRange) error {
+	dstPath := aws.StringValue(input.Key)
+	upload, err := s.service.CreateMultipartUploadWithContext(ctx, input)
+	if err != nil {
+		return fmt.Errorf("create multipart upload: %w", err)
+	}
+
+	var parts []*s3.CompletedPart
+	for i, copyRange := range ranges {
+		partNumber := int64(i + 1)
+		part, err := s.service.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(s.bucket),
+			Key:             aws.String(dstPath),
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int64(partNumber),
+			CopySource:      aws.String(copyRange.copySource),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", copyRange.start, copyRange.end)),
+		})
+		if err != nil {
+			s.abortMultipartUpload(dstPath, upload.UploadId)
+			return fmt.Errorf("copy part %d: %w", partNumber, err)
+		}
+
+		parts = append(parts, &s3.CompletedPart{ETag: part.CopyPartResult.ETag, PartNumber: aws.Int64(partNumber)})
+	}
+
+	_, err = s.service.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(dstPath),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		s.abortMultipartUpload(dstPath, upload.UploadId)
+		return fmt.Errorf("complete multipart upload: %w", err)
+	}
+
 	return nil
 }
 
-func (s *S3Store) FileExists(ctx context.Context, base string) (bool, error) {
+// concatObjects concatenates the sources as stored through a multipart upload
+// copying them part by part, returning false when the sources cannot be
+// copied this way, S3 requiring every part but the last to be at least 5MiB.
+func (s *S3Store) concatObjects(ctx context.Context, dst string, sources []string, opts ...WriteOption) (concatenated bool, err error) {
+	if !s.concatenatesStored() {
+		return false, nil
+	}
+
+	var ranges []s3CopyRange
+	for _, source := range sources {
+		path := s.ObjectPath(source)
+		head, err := s.service.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(path),
+		})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+				return false, ErrNotFound
+			}
+			return false, fmt.Errorf("head source object %q: %w", source, err)
+		}
+
+		ranges = append(ranges, s3CopyRanges(url.PathEscape(s.bucket+"/"+path), aws.Int64Value(head.ContentLength))...)
+	}
+	if len(ranges) == 0 || len(ranges) > s3manager.MaxUploadParts {
+		return false, nil
+	}
+	for _, copyRange := range ranges[:len(ranges)-1] {
+		if copyRange.end-copyRange.start+1 < s3manager.MinUploadPartSize {
+			return false, nil
+		}
+	}
+
+	if skip, err := skipExistingCopy(ctx, s, dst); skip || err != nil {
+		return true, err
+	}
+
+	return true, s.multipartCopyRanges(ctx, s.multipartUploadInput(s.ObjectPath(dst), newWriteConfig(opts)), ranges)
+}
+
+// multipartUploadInput returns the input creating a multipart upload of
+// `key`, with the metadata, ACL and headers `WriteObject` sets.
+func (s *S3Store) multipartUploadInput(key string, config *writeConfig) *s3.CreateMultipartUploadInput {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if len(config.metadata) > 0 {
+		input.Metadata = aws.StringMap(config.metadata)
+	}
+	contentType, cacheControl := s.contentHeaders(config, "", "")
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if cacheControl != "" {
+		input.CacheControl = aws.String(cacheControl)
+	}
+	if config.acl != "" {
+		input.ACL = aws.String(config.acl)
+	}
+	if contentEncoding := s.contentEncoding(); contentEncoding != "" {
+		input.ContentEncoding = aws.String(contentEncoding)
+	}
+	return input
+}
+
+// abortMultipartUpload is called on failures so that we do not leave the
+// incomplete parts behind, which are billed until aborted. It uses a fresh
+// context as the operation's one is probably already canceled.
+func (s *S3Store) abortMultipartUpload(key string, uploadID *string) {
+	_, err := s.service.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		s.logger.Warn("unable to abort multipart upload", zap.String("key", key), zap.Error(err))
+	}
+}
+
+func (s *S3Store) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer classifyError(&err)
 	path := s.ObjectPath(base)
 
-	_, err := s.service.HeadObject(&s3.HeadObjectInput{
+	_, err = s.service.HeadObject(&s3.HeadObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    &path,
 	})
@@ -244,16 +804,47 @@ func (s *S3Store) FileExists(ctx context.Context, base string) (bool, error) {
 	return true, nil
 }
 
+func (s *S3Store) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+
+	head, err := s.service.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    &path,
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return &ObjectAttrs{
+		Name:         base,
+		Size:         aws.Int64Value(head.ContentLength),
+		LastModified: aws.TimeValue(head.LastModified),
+		ETag:         strings.Trim(aws.StringValue(head.ETag), `"`),
+		Metadata:     normalizeMetadata(aws.StringValueMap(head.Metadata)),
+	}, nil
+}
+
+func (s *S3Store) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer classifyError(&err)
+	return renameObject(ctx, s, oldName, newName)
+}
+
 func (s *S3Store) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
 	path := s.ObjectPath(name)
 
-	if tracer.Enabled() {
-		zlog.Debug("opening dstore file", zap.String("path", s.pathWithExt(name)))
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file", zap.String("path", s.pathWithExt(name)))
 	}
 
 	for i := 0; i < s3ReadAttempts; i++ {
 		if i > 0 { // small wait on retry
-			zlog.Warn("got an error on s3 OpenObject, retrying",
+			s.logger.Warn("got an error on s3 OpenObject, retrying",
 				zap.Error(err),
 				zap.Int("attempt", i),
 				zap.Int("max_attempts", s3ReadAttempts),
@@ -266,29 +857,39 @@ func (s *S3Store) OpenObject(ctx context.Context, name string) (out io.ReadClose
 		reader, err = s.service.GetObjectWithContext(ctx, &s3.GetObjectInput{
 			Bucket: aws.String(s.bucket),
 			Key:    &path,
-		})
+		}, s.readOptions()...)
 		if err != nil {
 			if err.Error() == "no such key" {
 				err = ErrNotFound
 			}
 			continue
 		}
+		// Resumed reads require the ETag read so far, failing instead of
+		// splicing the content of a replacing object
+		etag := reader.ETag
+		body := s.resumeReads(ctx, name, reader.Body, func(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+			return s.openMatchingRange(ctx, name, etag, offset, length)
+		})
 		if bufferedS3Read {
 			var data []byte
-			data, err = ioutil.ReadAll(reader.Body)
+			data, err = ioutil.ReadAll(body)
 			if err != nil {
 				continue
 			}
-			if err = reader.Body.Close(); err != nil {
+			if err = body.Close(); err != nil {
 				continue
 			}
-			out, err = s.uncompressedReader(ioutil.NopCloser(bytes.NewReader(data)))
-		} else {
-			out, err = s.uncompressedReader(reader.Body)
+			body = ioutil.NopCloser(bytes.NewReader(data))
 		}
-		if tracer.Enabled() {
+		if s.verifyChecksums {
+			if checksum, ok := s3ETagMD5(aws.StringValue(reader.ETag), aws.StringValue(reader.ServerSideEncryption)); ok {
+				body = newMD5VerifyingReader(name, body, checksum)
+			}
+		}
+		out, err = s.uncompressedReader(ctx, body)
+		if debugEnabled(s.logger) {
 			out = wrapReadCloser(out, func() {
-				zlog.Debug("closing dstore file", zap.String("path", s.pathWithExt(name)))
+				s.logger.Debug("closing dstore file", zap.String("path", s.pathWithExt(name)))
 			})
 		}
 		return out, err
@@ -296,83 +897,318 @@ func (s *S3Store) OpenObject(ctx context.Context, name string) (out io.ReadClose
 	return nil, fmt.Errorf("s3 open object (%d attempts, buffered_read: %v): %w", s3ReadAttempts, bufferedS3Read, err)
 }
 
-func (s *S3Store) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
-	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
+func (s *S3Store) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	if s.decompressesReads() {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+	reader, err := s.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.throttleReads(ctx, reader), nil
 }
 
-func (s *S3Store) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error {
-	targetPrefix := s.path
-	if targetPrefix != "" {
-		targetPrefix += "/"
+func (s *S3Store) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	return s.openMatchingRange(ctx, name, nil, offset, length)
+}
+
+// openMatchingRange opens the range of the object, only when its ETag is
+// `etag` if not nil.
+func (s *S3Store) openMatchingRange(ctx context.Context, name string, etag *string, offset, length int64) (out io.ReadCloser, err error) {
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
 	}
-	if prefix != "" {
-		targetPrefix = filepath.Join(targetPrefix, prefix)
-		if prefix[len(prefix)-1:] == "/" {
-			targetPrefix += "/"
-		}
+
+	path := s.ObjectPath(name)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file range", zap.String("path", s.pathWithExt(name)), zap.Int64("offset", offset), zap.Int64("length", length))
 	}
 
-	if tracer.Enabled() {
-		zlog.Debug("walking files", zap.String("bucket", s.bucket), zap.String("prefix", targetPrefix))
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
 	}
 
-	q := &s3.ListObjectsV2Input{
-		Bucket: aws.String(s.bucket),
-		Prefix: &targetPrefix,
+	reader, err := s.service.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(s.bucket),
+		Key:     &path,
+		Range:   aws.String(byteRange),
+		IfMatch: etag,
+	}, s.readOptions()...)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrNotFound
+		}
+		return nil, err
 	}
 
-	var innerErr error
-	err := s.service.ListObjectsV2PagesWithContext(ctx, q, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
-		for _, el := range page.Contents {
-			filename := s.toBaseName(*el.Key)
-			if filename == "" {
-				zlog.Warn("got an empty filename from s3 store, ignoring it", zap.String("key", *el.Key))
-				continue
-			}
-			if err := f(filename); err != nil {
-				if err == StopIteration {
+	return reader.Body, nil
+}
+
+// readOptions returns the options of `GetObject` requests. Objects stored with
+// `Content-Encoding: gzip` are requested with `Accept-Encoding: identity` when
+// the store handles their encoding, as Go's HTTP client would decompress them
+// otherwise.
+func (s *S3Store) readOptions() []request.Option {
+	if !s.readsStoredEncoding() {
+		return nil
+	}
+	return []request.Option{func(r *request.Request) {
+		r.HTTPRequest.Header.Set("Accept-Encoding", "identity")
+	}}
+}
+
+func (s *S3Store) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkBetween(ctx, prefix, startingPoint, "", f)
+}
+
+func (s *S3Store) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkObjects(ctx, prefix, startingPoint, func(attrs *ObjectAttrs) error {
+		if endPoint != "" && attrs.Name >= endPoint {
+			return StopIteration
+		}
+		return f(attrs.Name)
+	})
+}
+
+func (s *S3Store) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *S3Store) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkObjects(ctx, prefix, "", f)
+}
+
+// walkObjects lists the objects under `prefix`, seeking to `startingPoint`
+// when set. `StartAfter` being exclusive, the listing starts right before
+// `startingPoint` and the few objects sorting before it are skipped here.
+// The listing is restarted in the same way to seek past prefixes skipped
+// through `SkipPrefix`.
+func (s *S3Store) walkObjects(ctx context.Context, prefix, startingPoint string, f func(attrs *ObjectAttrs) (err error)) error {
+	targetPrefix := s.walkPrefix(prefix)
+
+	for {
+		if debugEnabled(s.logger) {
+			s.logger.Debug("walking files", zap.String("bucket", s.bucket), zap.String("prefix", targetPrefix), zap.String("starting_point", startingPoint))
+		}
+
+		q := &s3.ListObjectsV2Input{
+			Bucket:  aws.String(s.bucket),
+			Prefix:  &targetPrefix,
+			MaxKeys: s.maxKeys(),
+		}
+		if startingPoint != "" {
+			q.StartAfter = aws.String(s.walkPrefix("") + startingPoint[:len(startingPoint)-1])
+		}
+
+		var innerErr error
+		var seekTo string
+		err := s.service.ListObjectsV2PagesWithContext(ctx, q, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, el := range page.Contents {
+				filename := s.toBaseName(*el.Key)
+				if filename == "" {
+					s.logger.Warn("got an empty filename from s3 store, ignoring it", zap.String("key", *el.Key))
+					continue
+				}
+				if filename < startingPoint {
+					continue
+				}
+				if err := f(newS3ObjectAttrs(filename, el)); err != nil {
+					if err == StopIteration {
+						return false
+					}
+					if skipped, ok := skippedPrefix(err); ok {
+						bound := prefixUpperBound(skipped)
+						if bound == "" {
+							return false
+						}
+						if bound > filename {
+							seekTo = bound
+							return false
+						}
+						continue
+					}
+					innerErr = err
 					return false
 				}
-				innerErr = err
-				return false
 			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("listing objects: %w", err)
+		}
+		if innerErr != nil {
+			return fmt.Errorf("processing object list: %w", innerErr)
+		}
+		if seekTo == "" {
+			return nil
+		}
+		startingPoint = seekTo
+	}
+}
+
+// maxKeys returns the `MaxKeys` of listing requests, nil to leave the server's
+// default.
+func (s *S3Store) maxKeys() *int64 {
+	if s.listPageSize == 0 {
+		return nil
+	}
+	return aws.Int64(s.listPageSize)
+}
+
+// ListFilesPage caps `pageSize` to the configured `list_page_size`, pages
+// possibly holding fewer files than requested.
+func (s *S3Store) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer classifyError(&err)
+	if s.listPageSize != 0 && int64(pageSize) > s.listPageSize {
+		pageSize = int(s.listPageSize)
+	}
+
+	q := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucket),
+		Prefix:  aws.String(s.walkPrefix(prefix)),
+		MaxKeys: aws.Int64(int64(pageSize)),
+	}
+	if pageToken != "" {
+		q.ContinuationToken = aws.String(pageToken)
+	}
+
+	page, err := s.service.ListObjectsV2WithContext(ctx, q)
+	if err != nil {
+		return nil, "", fmt.Errorf("listing objects: %w", err)
+	}
+
+	for _, el := range page.Contents {
+		if filename := s.toBaseName(*el.Key); filename != "" {
+			files = append(files, filename)
+		}
+	}
+	if aws.BoolValue(page.IsTruncated) {
+		nextToken = aws.StringValue(page.NextContinuationToken)
+	}
+	return files, nextToken, nil
+}
+
+func (s *S3Store) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer classifyError(&err)
+	q := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(directoryPrefix(s.path, prefix)),
+		Delimiter: aws.String("/"),
+		MaxKeys:   s.maxKeys(),
+	}
+
+	err = s.service.ListObjectsV2PagesWithContext(ctx, q, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, commonPrefix := range page.CommonPrefixes {
+			out = append(out, relativeDirectory(s.path, aws.StringValue(commonPrefix.Prefix)))
 		}
 		return true
 	})
 	if err != nil {
-		return fmt.Errorf("listing objects: %w", err)
+		return nil, fmt.Errorf("listing directories: %w", err)
 	}
-	if innerErr != nil {
-		return fmt.Errorf("processing object list: %w", innerErr)
+
+	return out, nil
+}
+
+// walkPrefix returns the full key prefix to list for the given store relative
+// prefix.
+func (s *S3Store) walkPrefix(prefix string) string {
+	targetPrefix := s.path
+	if targetPrefix != "" {
+		targetPrefix += "/"
+	}
+	if prefix != "" {
+		targetPrefix = filepath.Join(targetPrefix, prefix)
+		if prefix[len(prefix)-1:] == "/" {
+			targetPrefix += "/"
+		}
 	}
+	return targetPrefix
+}
 
-	return nil
+func newS3ObjectAttrs(name string, object *s3.Object) *ObjectAttrs {
+	return &ObjectAttrs{
+		Name:         name,
+		Size:         aws.Int64Value(object.Size),
+		LastModified: aws.TimeValue(object.LastModified),
+		ETag:         strings.Trim(aws.StringValue(object.ETag), `"`),
+	}
 }
 
 func (s *S3Store) toBaseName(filename string) string {
 	return strings.TrimPrefix(strings.TrimSuffix(filename, s.pathWithExt("")), s.path+"/")
 }
 
-func (s *S3Store) DeleteObject(ctx context.Context, base string) error {
+func (s *S3Store) DeleteObject(ctx context.Context, base string) (err error) {
+	defer classifyError(&err)
 	path := s.ObjectPath(base)
-	_, err := s.service.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+	_, err = s.service.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    &path,
 	})
 	return err
 }
 
-func (s *S3Store) PushLocalFile(ctx context.Context, localFile, toBaseName string) error {
+// s3MaxDeleteObjects is the maximum number of keys accepted by a single
+// `DeleteObjects` request.
+const s3MaxDeleteObjects = 1000
+
+func (s *S3Store) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer classifyError(&err)
+	for start := 0; start < len(names); start += s3MaxDeleteObjects {
+		end := start + s3MaxDeleteObjects
+		if end > len(names) {
+			end = len(names)
+		}
+
+		objects := make([]*s3.ObjectIdentifier, end-start)
+		for i, name := range names[start:end] {
+			objects[i] = &s3.ObjectIdentifier{Key: aws.String(s.ObjectPath(name))}
+		}
+
+		out, err := s.service.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("deleting objects batch: %w", err)
+		}
+
+		if len(out.Errors) > 0 {
+			first := out.Errors[0]
+			return fmt.Errorf("deleting objects batch, %d failed, first failure %q: %s", len(out.Errors), aws.StringValue(first.Key), aws.StringValue(first.Message))
+		}
+	}
+
+	return nil
+}
+
+func (s *S3Store) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer classifyError(&err)
+	return deletePrefix(ctx, s, prefix, deletePrefixConcurrency)
+}
+
+func (s *S3Store) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer classifyError(&err)
 	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
 	if retryS3PushLocalFilesDelay != 0 {
 		time.Sleep(retryS3PushLocalFilesDelay)
 		exists, err := s.FileExists(ctx, toBaseName)
 		if err != nil {
-			zlog.Warn("just pushed file to dstore, but cannot check if it is still there after 500 milliseconds and retryS3PushLocalFiles is set", zap.Error(err))
+			s.logger.Warn("just pushed file to dstore, but cannot check if it is still there after 500 milliseconds and retryS3PushLocalFiles is set", zap.Error(err))
 			return err
 		}
 		if !exists {
-			zlog.Warn("just pushed file to dstore, but it disappeared. Pushing again because retryS3PushLocalFiles is set", zap.String("dest basename", toBaseName))
+			s.logger.Warn("just pushed file to dstore, but it disappeared. Pushing again because retryS3PushLocalFiles is set", zap.String("dest basename", toBaseName))
 			rem, err := pushLocalFile(ctx, s, localFile, toBaseName)
 			if err != nil {
 				return err
@@ -387,6 +1223,7 @@ func (s *S3Store) PushLocalFile(ctx context.Context, localFile, toBaseName strin
 	return remove()
 }
 
-func (s *S3Store) ListFiles(ctx context.Context, prefix string, max int) ([]string, error) {
+func (s *S3Store) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer classifyError(&err)
 	return listFiles(ctx, s, prefix, max)
 }
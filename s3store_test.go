@@ -1,11 +1,26 @@
 package dstore
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"path"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -58,3 +73,765 @@ func TestNewS3Store(t *testing.T) {
 		})
 	}
 }
+
+func TestS3Store_Presign(t *testing.T) {
+	baseURL, err := url.Parse("s3://bucket/path1?region=test&access_key_id=id&secret_access_key=secret")
+	require.NoError(t, err)
+
+	store, err := NewS3Store(baseURL, "dbin", "", false)
+	require.NoError(t, err)
+
+	signedGet, err := store.PresignGet(context.Background(), "0000000100", time.Minute)
+	require.NoError(t, err)
+
+	signedPut, err := store.PresignPut(context.Background(), "0000000100", time.Minute)
+	require.NoError(t, err)
+
+	for _, signed := range []string{signedGet, signedPut} {
+		parsed, err := url.Parse(signed)
+		require.NoError(t, err)
+		assert.Equal(t, "/path1/0000000100.dbin", parsed.Path)
+		assert.Equal(t, "60", parsed.Query().Get("X-Amz-Expires"))
+		assert.NotEmpty(t, parsed.Query().Get("X-Amz-Signature"))
+	}
+}
+
+func TestNewS3StoreWithOptions_MultipartThreshold(t *testing.T) {
+	baseURL, err := url.Parse("s3://bucket/path1?region=test")
+	require.NoError(t, err)
+
+	store, err := NewS3StoreWithOptions(baseURL)
+	require.NoError(t, err)
+	assert.Equal(t, int64(s3manager.DefaultUploadPartSize), store.uploader.PartSize)
+
+	store, err = NewS3StoreWithOptions(baseURL, MultipartThreshold(64*1024*1024))
+	require.NoError(t, err)
+	assert.Equal(t, int64(64*1024*1024), store.uploader.PartSize)
+	assert.False(t, store.uploader.LeavePartsOnError)
+
+	sub, err := store.SubStore("sub-folder")
+	require.NoError(t, err)
+	assert.Equal(t, int64(64*1024*1024), sub.(*S3Store).uploader.PartSize)
+
+	_, err = NewS3StoreWithOptions(baseURL, MultipartThreshold(1024))
+	require.Error(t, err)
+}
+
+func TestS3Store_WriteObject_AbortsMultipartUpload(t *testing.T) {
+	var lock sync.Mutex
+	var aborted bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, isUploads := r.URL.Query()["uploads"]
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost && isUploads:
+			fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>path1/file</Key><UploadId>upload-id</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && r.URL.Query().Get("uploadId") != "":
+			ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<Error><Code>InvalidPart</Code><Message>failed</Message></Error>`)
+		case r.Method == http.MethodDelete && r.URL.Query().Get("uploadId") == "upload-id":
+			lock.Lock()
+			aborted = true
+			lock.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path1?region=test&insecure=true&access_key_id=id&secret_access_key=secret", strings.TrimPrefix(server.URL, "http://")))
+	require.NoError(t, err)
+
+	store, err := NewS3StoreWithOptions(baseURL)
+	require.NoError(t, err)
+
+	err = store.WriteObject(context.Background(), "file", bytes.NewReader(make([]byte, 6*1024*1024)))
+	require.Error(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.True(t, aborted, "multipart upload was not aborted")
+}
+
+func TestS3Store_CopyObject_Multipart(t *testing.T) {
+	var lock sync.Mutex
+	var created http.Header
+	var parts int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		_, isUploads := r.URL.Query()["uploads"]
+		switch {
+		case r.Method == http.MethodHead && r.URL.Path == "/bucket/path1/large":
+			w.Header().Set("Content-Length", fmt.Sprint(s3MaxCopySize+1))
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("X-Amz-Meta-Owner", "test")
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost && isUploads:
+			created = r.Header.Clone()
+			fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>path1/copy</Key><UploadId>upload-id</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && r.URL.Query().Get("uploadId") != "":
+			parts++
+			fmt.Fprint(w, `<CopyPartResult><ETag>"etag"</ETag></CopyPartResult>`)
+		case r.Method == http.MethodPost && r.URL.Query().Get("uploadId") == "upload-id":
+			ioutil.ReadAll(r.Body)
+			fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>path1/copy</Key></CompleteMultipartUploadResult>`)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path1?region=test&insecure=true&access_key_id=id&secret_access_key=secret", strings.TrimPrefix(server.URL, "http://")))
+	require.NoError(t, err)
+
+	store, err := NewS3StoreWithOptions(baseURL)
+	require.NoError(t, err)
+
+	require.NoError(t, store.CopyObject(context.Background(), "large", "copy"))
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 11, parts)
+	assert.Equal(t, "application/json", created.Get("Content-Type"))
+	assert.Equal(t, "max-age=60", created.Get("Cache-Control"))
+	assert.Equal(t, "gzip", created.Get("Content-Encoding"))
+	assert.Equal(t, "test", created.Get("X-Amz-Meta-Owner"))
+}
+
+func TestS3Store_Copy_DeniedServerSide(t *testing.T) {
+	var lock sync.Mutex
+	objects := map[string][]byte{"/bucket/src/file": []byte("content")}
+	var denied int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		content, found := objects[r.URL.Path]
+		switch {
+		case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+			denied++
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+		case r.Method == http.MethodPut:
+			objects[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+		case !found:
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`)
+			}
+		case r.Method == http.MethodHead:
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		case r.Method == http.MethodGet:
+			w.Write(content)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	newStore := func(path string) *S3Store {
+		baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/%s?region=test&insecure=true&access_key_id=id&secret_access_key=secret", strings.TrimPrefix(server.URL, "http://"), path))
+		require.NoError(t, err)
+		store, err := NewS3StoreWithOptions(baseURL)
+		require.NoError(t, err)
+		return store
+	}
+
+	require.NoError(t, Copy(context.Background(), newStore("src"), "file", newStore("dst"), "file"))
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 1, denied)
+	assert.Equal(t, "content", string(objects["/bucket/dst/file"]))
+}
+
+func TestS3Store_WriteObject_WriteBandwidth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case http.MethodPut:
+			ioutil.ReadAll(r.Body)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path1?region=test&insecure=true&access_key_id=id&secret_access_key=secret&write_bandwidth=102400", strings.TrimPrefix(server.URL, "http://")))
+	require.NoError(t, err)
+	store, err := NewS3StoreWithOptions(baseURL)
+	require.NoError(t, err)
+
+	// Seekable content is throttled too, the first burst of 100KiB being free
+	start := time.Now()
+	require.NoError(t, store.WriteObject(context.Background(), "file", bytes.NewReader(make([]byte, 150*1024))))
+	assert.True(t, time.Since(start) >= 400*time.Millisecond, "write took %s", time.Since(start))
+}
+
+func TestS3Store_ResumeReads_IfMatch(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	for _, replaced := range []bool{false, true} {
+		t.Run(fmt.Sprintf("replaced %v", replaced), func(t *testing.T) {
+			var lock sync.Mutex
+			var ifMatch []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				ifMatch = append(ifMatch, r.Header.Get("If-Match"))
+				first := len(ifMatch) == 1
+				lock.Unlock()
+
+				etag := `"v1"`
+				if replaced && !first {
+					etag = `"v2"`
+				}
+				if match := r.Header.Get("If-Match"); match != "" && match != etag {
+					w.WriteHeader(http.StatusPreconditionFailed)
+					fmt.Fprint(w, `<Error><Code>PreconditionFailed</Code><Message>failed</Message></Error>`)
+					return
+				}
+
+				var offset int
+				fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset)
+				body := content[offset:]
+				w.Header().Set("ETag", etag)
+				w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+				if offset > 0 {
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(content)-1, len(content)))
+					w.WriteHeader(http.StatusPartialContent)
+				}
+				if !first {
+					io.WriteString(w, body)
+					return
+				}
+
+				io.WriteString(w, body[:3000])
+				w.(http.Flusher).Flush()
+				if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+					conn.Close()
+				}
+			}))
+			defer server.Close()
+
+			baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path1?region=test&insecure=true&access_key_id=id&secret_access_key=secret&read_resumes=2", strings.TrimPrefix(server.URL, "http://")))
+			require.NoError(t, err)
+			store, err := NewS3StoreWithOptions(baseURL)
+			require.NoError(t, err)
+
+			reader, err := store.OpenObject(context.Background(), "file")
+			require.NoError(t, err)
+			read, err := ioutil.ReadAll(reader)
+			reader.Close()
+			if replaced {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, content, string(read))
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			assert.Equal(t, []string{"", `"v1"`}, ifMatch)
+		})
+	}
+}
+
+func TestNewS3Store_Compat(t *testing.T) {
+	tests := []struct {
+		url                      string
+		expectedPathStyle        bool
+		expectedDisableChecksums bool
+		expectedListPageSize     int64
+		expectedErr              bool
+	}{
+		{url: "s3://bucket/path1?region=test"},
+		{url: "s3://test.com/bucket/path1?region=test", expectedPathStyle: true},
+		{url: "s3://test.com/bucket/path1?region=test&path_style=false"},
+		{url: "s3://bucket/path1?region=test&path_style=true", expectedPathStyle: true},
+		{url: "s3://localhost:9000/bucket?region=none&compat=minio", expectedPathStyle: true, expectedDisableChecksums: true},
+		{url: "s3://rgw.example.com/bucket?region=none&compat=ceph", expectedPathStyle: true, expectedListPageSize: 100},
+		{url: "s3://rgw.example.com/bucket?region=none&compat=ceph&list_page_size=250&disable_checksums=true", expectedPathStyle: true, expectedDisableChecksums: true, expectedListPageSize: 250},
+
+		{url: "s3://bucket/path1?region=test&compat=unknown", expectedErr: true},
+		{url: "s3://bucket/path1?region=test&path_style=maybe", expectedErr: true},
+		{url: "s3://bucket/path1?region=test&list_page_size=5000", expectedErr: true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			baseURL, err := url.Parse(test.url)
+			require.NoError(t, err)
+
+			store, err := NewS3Store(baseURL, "", "", false)
+			if test.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			config := store.service.Client.Config
+			assert.Equal(t, test.expectedPathStyle, aws.BoolValue(config.S3ForcePathStyle), "path style")
+			assert.Equal(t, test.expectedDisableChecksums, aws.BoolValue(config.S3DisableContentMD5Validation), "disable checksums")
+			assert.Equal(t, test.expectedListPageSize, store.listPageSize, "list page size")
+
+			sub, err := store.SubStore("sub-folder")
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedListPageSize, sub.(*S3Store).listPageSize)
+		})
+	}
+}
+
+func TestS3Store_Compat_Requests(t *testing.T) {
+	var lock sync.Mutex
+	var maxKeys []string
+	var contentMD5 []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch r.Method {
+		case http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case http.MethodGet:
+			maxKeys = append(maxKeys, r.URL.Query().Get("max-keys"))
+			fmt.Fprint(w, `<ListBucketResult><IsTruncated>false</IsTruncated></ListBucketResult>`)
+		case http.MethodPut:
+			ioutil.ReadAll(r.Body)
+			contentMD5 = append(contentMD5, r.Header.Get("Content-MD5"))
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path1?region=test&insecure=true&access_key_id=id&secret_access_key=secret&compat=ceph&disable_checksums=true", strings.TrimPrefix(server.URL, "http://")))
+	require.NoError(t, err)
+
+	store, err := NewS3StoreWithOptions(baseURL, AllowOverwrite())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, store.Walk(ctx, "", func(filename string) error { return nil }))
+	_, err = store.ListDirectories(ctx, "")
+	require.NoError(t, err)
+	_, _, err = store.ListFilesPage(ctx, "", 500, "")
+	require.NoError(t, err)
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader([]byte("content"))))
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"100", "100", "100"}, maxKeys)
+	assert.Equal(t, []string{""}, contentMD5)
+}
+
+func TestS3Store_GzipContentEncoding(t *testing.T) {
+	var lock sync.Mutex
+	var stored []byte
+	var headers, readHeaders http.Header
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch r.Method {
+		case http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case http.MethodPut:
+			stored, _ = ioutil.ReadAll(r.Body)
+			headers = r.Header.Clone()
+		case http.MethodGet:
+			readHeaders = r.Header.Clone()
+			w.Header().Set("Content-Encoding", headers.Get("Content-Encoding"))
+			w.Write(stored)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path1?region=test&insecure=true&access_key_id=id&secret_access_key=secret&content_encoding=gzip", strings.TrimPrefix(server.URL, "http://")))
+	require.NoError(t, err)
+
+	store, err := NewS3StoreWithOptions(baseURL, Compression("gzip"), DefaultContentType("application/json"))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, WriteObjectBytes(ctx, store, "file", []byte(`{"key":"value"}`)))
+	content, err := ReadObject(ctx, store, "file")
+	require.NoError(t, err)
+	assert.Equal(t, `{"key":"value"}`, string(content))
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, "gzip", headers.Get("Content-Encoding"))
+	assert.Equal(t, "application/json", headers.Get("Content-Type"))
+	assert.Equal(t, []byte{0x1f, 0x8b}, stored[:2])
+	assert.Equal(t, "identity", readHeaders.Get("Accept-Encoding"))
+}
+
+func TestS3Store_ConcatObjects(t *testing.T) {
+	sizes := map[string]int{"/bucket/path1/large": 6 * 1024 * 1024, "/bucket/path1/small": 10}
+
+	var lock sync.Mutex
+	var copied []string
+	var written []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		_, isUploads := r.URL.Query()["uploads"]
+		switch {
+		case r.Method == http.MethodHead:
+			size, found := sizes[r.URL.Path]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", fmt.Sprint(size))
+		case r.Method == http.MethodGet:
+			size, found := sizes[r.URL.Path]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`)
+				return
+			}
+			w.Write(bytes.Repeat([]byte(path.Base(r.URL.Path)[:1]), size))
+		case r.Method == http.MethodPost && isUploads:
+			fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>path1/bundle</Key><UploadId>upload-id</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && r.URL.Query().Get("uploadId") != "":
+			copied = append(copied, fmt.Sprintf("%s %s %s", r.URL.Query().Get("partNumber"), r.Header.Get("X-Amz-Copy-Source"), r.Header.Get("X-Amz-Copy-Source-Range")))
+			fmt.Fprint(w, `<CopyPartResult><ETag>"etag"</ETag></CopyPartResult>`)
+		case r.Method == http.MethodPost && r.URL.Query().Get("uploadId") == "upload-id":
+			ioutil.ReadAll(r.Body)
+			fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>path1/bundle</Key></CompleteMultipartUploadResult>`)
+		case r.Method == http.MethodPut:
+			written, _ = ioutil.ReadAll(r.Body)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path1?region=test&insecure=true&access_key_id=id&secret_access_key=secret", strings.TrimPrefix(server.URL, "http://")))
+	require.NoError(t, err)
+
+	store, err := NewS3StoreWithOptions(baseURL, AllowOverwrite())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, ConcatObjects(ctx, store, "bundle", []string{"large", "small"}))
+	assert.Equal(t, []string{
+		"1 bucket%2Fpath1%2Flarge bytes=0-6291455",
+		"2 bucket%2Fpath1%2Fsmall bytes=0-9",
+	}, copied)
+	assert.Nil(t, written)
+
+	// Parts before the last one must be at least 5MiB, so the objects are
+	// streamed instead
+	copied = nil
+	require.NoError(t, ConcatObjects(ctx, store, "bundle", []string{"small", "small"}))
+	assert.Nil(t, copied)
+	assert.Equal(t, strings.Repeat("s", 20), string(written))
+
+	assert.Equal(t, ErrNotFound, ConcatObjects(ctx, store, "bundle", []string{"large", "missing"}))
+}
+
+func TestS3Store_UploadChecksums(t *testing.T) {
+	var lock sync.Mutex
+	var contentMD5 string
+	var corrupt bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch r.Method {
+		case http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case http.MethodPut:
+			body, _ := ioutil.ReadAll(r.Body)
+			if corrupt {
+				body[len(body)/2] ^= 0x01
+			}
+
+			contentMD5 = r.Header.Get("Content-Md5")
+			sum := md5.Sum(body)
+			if contentMD5 != base64.StdEncoding.EncodeToString(sum[:]) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `<Error><Code>BadDigest</Code><Message>The Content-MD5 you specified did not match what we received.</Message></Error>`)
+			}
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path1?region=test&insecure=true&access_key_id=id&secret_access_key=secret&checksums=true", strings.TrimPrefix(server.URL, "http://")))
+	require.NoError(t, err)
+
+	store, err := NewS3StoreWithOptions(baseURL, Compression("zstd"))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, WriteObjectBytes(ctx, store, "file", bytes.Repeat([]byte("content"), 1000)))
+
+	lock.Lock()
+	assert.NotEmpty(t, contentMD5)
+	corrupt = true
+	lock.Unlock()
+
+	err = WriteObjectBytes(ctx, store, "file", bytes.Repeat([]byte("content"), 1000))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "BadDigest")
+}
+
+func TestS3Store_ObjectLock(t *testing.T) {
+	var lock sync.Mutex
+	var requests, missing int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost && r.URL.Query()["uploads"] != nil:
+			fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>path1/file</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPost:
+			fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>path1/file</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+		case r.Method == http.MethodPut:
+			body, _ := ioutil.ReadAll(r.Body)
+			sum := md5.Sum(body)
+
+			requests++
+			if r.Header.Get("Content-Md5") != base64.StdEncoding.EncodeToString(sum[:]) {
+				missing++
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `<Error><Code>InvalidRequest</Code><Message>Content-MD5 HTTP header is required for Put Object requests with Object Lock parameters</Message></Error>`)
+				return
+			}
+			w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum))
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path1?region=test&insecure=true&access_key_id=id&secret_access_key=secret&disable_checksums=true&object_lock=true", strings.TrimPrefix(server.URL, "http://")))
+	require.NoError(t, err)
+
+	store, err := NewS3StoreWithOptions(baseURL, MultipartThreshold(5*1024*1024))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, WriteObjectBytes(ctx, store, "file", []byte("content")))
+	require.NoError(t, WriteObjectBytes(ctx, store, "file", bytes.Repeat([]byte("a"), 6*1024*1024)))
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 3, requests)
+	assert.Zero(t, missing)
+}
+
+func TestS3Store_VerifyChecksums(t *testing.T) {
+	content := []byte("some content")
+	etag := md5.Sum(content)
+
+	var lock sync.Mutex
+	var served []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("ETag", fmt.Sprintf(`"%x"`, etag))
+			w.Write(served)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path1?region=test&insecure=true&access_key_id=id&secret_access_key=secret&verify_checksums=true", strings.TrimPrefix(server.URL, "http://")))
+	require.NoError(t, err)
+
+	store, err := NewS3StoreWithOptions(baseURL)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	served = content
+	read, err := ReadObject(ctx, store, "file")
+	require.NoError(t, err)
+	assert.Equal(t, content, read)
+
+	lock.Lock()
+	served = []byte("some c0ntent")
+	lock.Unlock()
+	_, err = ReadObject(ctx, store, "file")
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), "unexpected error %v", err)
+}
+
+func TestS3Store_CaptureAttrs(t *testing.T) {
+	var lock sync.Mutex
+	var stored []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch r.Method {
+		case http.MethodHead:
+			if stored == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(stored)))
+			w.Header().Set("Content-Length", fmt.Sprint(len(stored)))
+		case http.MethodPut:
+			stored, _ = ioutil.ReadAll(r.Body)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path1?region=test&insecure=true&access_key_id=id&secret_access_key=secret", strings.TrimPrefix(server.URL, "http://")))
+	require.NoError(t, err)
+
+	store, err := NewS3StoreWithOptions(baseURL)
+	require.NoError(t, err)
+
+	var attrs ObjectAttrs
+	require.NoError(t, WriteObjectBytes(context.Background(), store, "file", []byte("content"), CaptureAttrs(&attrs)))
+	assert.Equal(t, "file", attrs.Name)
+	assert.Equal(t, fmt.Sprintf("%x", md5.Sum([]byte("content"))), attrs.ETag)
+	assert.Equal(t, int64(7), attrs.Size)
+}
+
+func TestS3Store_HTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// The SDK only accepts `*http.Transport` when a custom CA bundle is
+	// configured, the dials tell that the client is used
+	var lock sync.Mutex
+	var dials int
+	dialer := &net.Dialer{}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			lock.Lock()
+			dials++
+			lock.Unlock()
+			return dialer.DialContext(ctx, network, address)
+		},
+	}}
+
+	baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path1?region=test&insecure=true&access_key_id=id&secret_access_key=secret", strings.TrimPrefix(server.URL, "http://")))
+	require.NoError(t, err)
+
+	store, err := NewS3StoreWithOptions(baseURL, HTTPClient(client))
+	require.NoError(t, err)
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+
+	exists, err := sub.FileExists(context.Background(), "file")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 1, dials)
+}
+
+func TestS3Store_WriteResumable(t *testing.T) {
+	content := make([]byte, 6*1024*1024+10)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	var lock sync.Mutex
+	parts := map[int][]byte{}
+	var uploads int
+	var written []byte
+	var created http.Header
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		_, isUploads := r.URL.Query()["uploads"]
+		uploadID := r.URL.Query().Get("uploadId")
+		switch {
+		case r.Method == http.MethodPost && isUploads:
+			created = r.Header.Clone()
+			fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>path/file</Key><UploadId>upload-id</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && uploadID == "upload-id":
+			var partNumber int
+			fmt.Sscan(r.URL.Query().Get("partNumber"), &partNumber)
+			parts[partNumber], _ = ioutil.ReadAll(r.Body)
+			uploads++
+			w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, partNumber))
+		case r.Method == http.MethodGet && uploadID == "upload-id":
+			fmt.Fprint(w, `<ListPartsResult><Bucket>bucket</Bucket><Key>path/file</Key><UploadId>upload-id</UploadId><IsTruncated>false</IsTruncated>`)
+			for partNumber := 1; parts[partNumber] != nil; partNumber++ {
+				fmt.Fprintf(w, `<Part><PartNumber>%d</PartNumber><ETag>"etag-%d"</ETag><Size>%d</Size></Part>`, partNumber, partNumber, len(parts[partNumber]))
+			}
+			fmt.Fprint(w, `</ListPartsResult>`)
+		case r.Method == http.MethodPost && uploadID == "upload-id":
+			ioutil.ReadAll(r.Body)
+			written = nil
+			for partNumber := 1; parts[partNumber] != nil; partNumber++ {
+				written = append(written, parts[partNumber]...)
+			}
+			fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>path/file</Key></CompleteMultipartUploadResult>`)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path?region=test&insecure=true&access_key_id=id&secret_access_key=secret", strings.TrimPrefix(server.URL, "http://")))
+	require.NoError(t, err)
+	store, err := NewS3StoreWithOptions(baseURL, AllowOverwrite())
+	require.NoError(t, err)
+
+	// The process "crashes" once the first part is stored
+	ctx := context.Background()
+	errCrash := errors.New("crash")
+	var saved UploadCheckpoint
+	err = WriteResumable(ctx, store, "file", bytes.NewReader(content), func(checkpoint *UploadCheckpoint) error {
+		saved = *checkpoint
+		if checkpoint.Offset > 0 {
+			return errCrash
+		}
+		return nil
+	}, WithACL("public-read"), WithMetadata(map[string]string{"owner": "test"}), WithContentType("application/octet-stream"))
+	require.True(t, errors.Is(err, errCrash), "expected crash, got %v", err)
+	assert.Equal(t, "public-read", created.Get("X-Amz-Acl"))
+	assert.Equal(t, "test", created.Get("X-Amz-Meta-Owner"))
+	assert.Equal(t, "application/octet-stream", created.Get("Content-Type"))
+	assert.Equal(t, UploadCheckpoint{Name: "file", Session: "upload-id", PartSize: s3manager.DefaultUploadPartSize, Offset: s3manager.DefaultUploadPartSize}, saved)
+	assert.Nil(t, written)
+
+	require.NoError(t, ResumeWrite(ctx, store, &saved, bytes.NewReader(content), func(*UploadCheckpoint) error { return nil }))
+	assert.Equal(t, content, written)
+	assert.Equal(t, 2, uploads, "stored parts are not sent again")
+}
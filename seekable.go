@@ -0,0 +1,235 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+//
+// Zstd Seekable Format
+//
+// Objects are made of independent zstd frames followed by a skippable frame
+// holding the compressed and decompressed size of each of them, as specified in
+// https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+//
+
+const (
+	seekableSkippableMagic = 0x184d2a5e
+	seekableMagic          = 0x8f92eab1
+
+	seekableFrameHeaderSize = 8
+	seekableFooterSize      = 9
+	// seekableChecksumFlag is the bit of the footer's descriptor telling that
+	// entries carry a checksum of their frame.
+	seekableChecksumFlag = 0x80
+)
+
+// errNotSeekable is returned by `openSeekableRange` for objects without seek
+// table, read from their start instead.
+var errNotSeekable = errors.New("object not in zstd seekable format")
+
+// seekableStore is implemented by the stores whose range reads can rely on the
+// seek table of objects in the zstd seekable format.
+type seekableStore interface {
+	Store
+
+	seekable() bool
+	openStoredRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error)
+}
+
+func seekableFrameSize(config *config) int {
+	if !config.seekable {
+		return 0
+	}
+	if config.seekableFrameSize <= 0 {
+		return defaultSeekableFrameSize
+	}
+	return config.seekableFrameSize
+}
+
+// seekable returns whether objects are written in the zstd seekable format.
+func (c *commonStore) seekable() bool {
+	return c.seekableFrameSize > 0 && c.compressionType == "zstd"
+}
+
+// writeSeekableZstd compresses `f` to `w` in frames of `frameSize`
+// decompressed bytes, followed by the seek table.
+func writeSeekableZstd(f io.Reader, w io.Writer, frameSize int, level zstd.EncoderLevel) error {
+	encoder, err := getZstdEncoder(nil, level)
+	if err != nil {
+		return err
+	}
+	// Frames are compressed with `EncodeAll`, which leaves the encoder ready
+	// for other calls
+	defer releaseZstdEncoder(encoder, level)
+
+	var table bytes.Buffer
+	var frames uint32
+	content := make([]byte, frameSize)
+	var frame []byte
+	for {
+		n, err := io.ReadFull(f, content)
+		if n > 0 {
+			frame = encoder.EncodeAll(content[:n], frame[:0])
+			if _, err := w.Write(frame); err != nil {
+				return err
+			}
+
+			binary.Write(&table, binary.LittleEndian, [2]uint32{uint32(len(frame)), uint32(n)})
+			frames++
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var seekTable bytes.Buffer
+	binary.Write(&seekTable, binary.LittleEndian, [2]uint32{seekableSkippableMagic, uint32(table.Len() + seekableFooterSize)})
+	seekTable.Write(table.Bytes())
+	binary.Write(&seekTable, binary.LittleEndian, frames)
+	seekTable.WriteByte(0)
+	binary.Write(&seekTable, binary.LittleEndian, uint32(seekableMagic))
+
+	_, err = w.Write(seekTable.Bytes())
+	return err
+}
+
+// seekableFrame locates a frame in the compressed and decompressed content.
+type seekableFrame struct {
+	offset, size                         int64
+	decompressedOffset, decompressedSize int64
+}
+
+// readSeekTable reads the frames of the object of `size` stored bytes,
+// failing with `errNotSeekable` when it has no seek table.
+func readSeekTable(ctx context.Context, store seekableStore, name string, size int64) ([]seekableFrame, error) {
+	if size < seekableFrameHeaderSize+seekableFooterSize {
+		return nil, errNotSeekable
+	}
+
+	footer, err := readStoredRange(ctx, store, name, size-seekableFooterSize, seekableFooterSize)
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekableMagic {
+		return nil, errNotSeekable
+	}
+
+	entrySize := int64(8)
+	if footer[4]&seekableChecksumFlag != 0 {
+		entrySize = 12
+	}
+	count := int64(binary.LittleEndian.Uint32(footer[:4]))
+	tableSize := count * entrySize
+	dataSize := size - seekableFrameHeaderSize - tableSize - seekableFooterSize
+	if dataSize < 0 {
+		return nil, fmt.Errorf("seek table of %d frames exceeds the object size", count)
+	}
+
+	table, err := readStoredRange(ctx, store, name, dataSize+seekableFrameHeaderSize, tableSize)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := make([]seekableFrame, count)
+	var offset, decompressedOffset int64
+	for i := range frames {
+		entry := table[int64(i)*entrySize:]
+		frames[i] = seekableFrame{
+			offset:             offset,
+			size:               int64(binary.LittleEndian.Uint32(entry)),
+			decompressedOffset: decompressedOffset,
+			decompressedSize:   int64(binary.LittleEndian.Uint32(entry[4:])),
+		}
+		offset += frames[i].size
+		decompressedOffset += frames[i].decompressedSize
+	}
+	if offset != dataSize {
+		return nil, fmt.Errorf("seek table frames add up to %d bytes, expected %d", offset, dataSize)
+	}
+	return frames, nil
+}
+
+func readStoredRange(ctx context.Context, store seekableStore, name string, offset, length int64) ([]byte, error) {
+	reader, err := store.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(reader, content); err != nil {
+		return nil, fmt.Errorf("read %d bytes at %d: %w", length, offset, err)
+	}
+	return content, nil
+}
+
+// openSeekableRange reads the decompressed range through the seek table of
+// the object, only fetching the frames overlapping the range.
+func openSeekableRange(ctx context.Context, store seekableStore, name string, offset, length int64) (io.ReadCloser, error) {
+	attrs, err := store.ObjectAttributes(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	frames, err := readSeekTable(ctx, store, name, attrs.Size)
+	if err != nil {
+		if err == errNotSeekable {
+			return nil, err
+		}
+		return nil, fmt.Errorf("seek table of %q: %w", name, err)
+	}
+
+	first := seekableFrameAt(frames, offset)
+	if first == len(frames) || length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	last := len(frames) - 1
+	if length > 0 {
+		last = seekableFrameAt(frames[first:], offset+length-1) + first
+		if last == len(frames) {
+			last = len(frames) - 1
+		}
+	}
+
+	start := frames[first].offset
+	end := frames[last].offset + frames[last].size
+	reader, err := store.openStoredRange(ctx, name, start, end-start)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder, err := zstd.NewReader(reader)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("unable to create zstd reader: %w", err)
+	}
+	out := &zstdReadCloser{src: reader, Decoder: decoder}
+
+	if skip := offset - frames[first].decompressedOffset; skip > 0 {
+		if _, err := io.CopyN(ioutil.Discard, out, skip); err != nil {
+			out.Close()
+			return nil, fmt.Errorf("skipping to offset %d: %w", offset, err)
+		}
+	}
+	return limitReadCloser(out, length), nil
+}
+
+// seekableFrameAt returns the index of the frame holding the decompressed
+// `offset`, `len(frames)` when it's past the end.
+func seekableFrameAt(frames []seekableFrame, offset int64) int {
+	return sort.Search(len(frames), func(i int) bool {
+		return offset < frames[i].decompressedOffset+frames[i].decompressedSize
+	})
+}
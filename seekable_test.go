@@ -0,0 +1,116 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeekableZstd(t *testing.T) {
+	ctx := context.Background()
+	content := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	baseURL := &url.URL{Scheme: "file", Path: t.TempDir()}
+	store, err := NewLocalStoreWithOptions(baseURL, Compression("zstd"), SeekableZstd(1024))
+	require.NoError(t, err)
+	require.NoError(t, WriteObjectBytes(ctx, store, "file", content))
+
+	attrs, err := store.ObjectAttributes(ctx, "file")
+	require.NoError(t, err)
+	frames, err := readSeekTable(ctx, store, "file", attrs.Size)
+	require.NoError(t, err)
+	require.Len(t, frames, 10)
+	assert.Equal(t, seekableFrame{offset: frames[9].offset, size: frames[9].size, decompressedOffset: 9216, decompressedSize: 784}, frames[9])
+
+	// Plain zstd decoders skip the seek table
+	plain, err := NewLocalStoreWithOptions(baseURL, Compression("zstd"))
+	require.NoError(t, err)
+	read, err := ReadObject(ctx, plain, "file")
+	require.NoError(t, err)
+	assert.Equal(t, content, read)
+
+	tests := []struct {
+		name           string
+		offset, length int64
+	}{
+		{"whole", 0, -1},
+		{"inside frame", 100, 200},
+		{"across frames", 1000, 3000},
+		{"frame boundary", 2048, 1024},
+		{"to end", 9500, -1},
+		{"past end", 9500, 1000},
+		{"empty", 5000, 0},
+		{"after end", 20000, -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, err := store.OpenObjectRange(ctx, "file", test.offset, test.length)
+			require.NoError(t, err)
+			defer reader.Close()
+
+			read, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+
+			expected := []byte{}
+			if test.offset < int64(len(content)) {
+				end := int64(len(content))
+				if test.length >= 0 && test.offset+test.length < end {
+					end = test.offset + test.length
+				}
+				expected = content[test.offset:end]
+			}
+			assert.Equal(t, expected, append([]byte{}, read...))
+		})
+	}
+
+	reader, err := OpenObjectAt(ctx, store, "file", 4321)
+	require.NoError(t, err)
+	defer reader.Close()
+	read, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content[4321:], read)
+}
+
+func TestSeekableZstd_notSeekable(t *testing.T) {
+	ctx := context.Background()
+
+	baseURL := &url.URL{Scheme: "file", Path: t.TempDir()}
+	store, err := NewLocalStoreWithOptions(baseURL, Compression("zstd"))
+	require.NoError(t, err)
+	require.NoError(t, WriteObjectBytes(ctx, store, "file", []byte("plain zstd content")))
+	require.NoError(t, WriteObjectBytes(ctx, store, "empty", nil))
+
+	seekable, err := NewLocalStoreWithOptions(baseURL, Compression("zstd"), SeekableZstd(0))
+	require.NoError(t, err)
+	reader, err := OpenObjectAt(ctx, seekable, "file", 6)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	read, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "zstd content", string(read))
+
+	require.NoError(t, WriteObjectBytes(ctx, seekable, "seekable-empty", nil))
+	for _, name := range []string{"empty", "seekable-empty"} {
+		content, err := ReadObject(ctx, seekable, name)
+		require.NoError(t, err)
+		assert.Empty(t, content, name)
+	}
+
+	sub, err := seekable.SubStore("sub")
+	require.NoError(t, err)
+	require.NoError(t, WriteObjectBytes(ctx, sub, "file", bytes.Repeat([]byte("a"), 3*defaultSeekableFrameSize)))
+	attrs, err := seekable.ObjectAttributes(ctx, "sub/file")
+	require.NoError(t, err)
+	frames, err := readSeekTable(ctx, seekable, "sub/file", attrs.Size)
+	require.NoError(t, err)
+	assert.Len(t, frames, 3)
+}
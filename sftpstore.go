@@ -0,0 +1,564 @@
+//go:build !dstore_no_sftp
+
+package dstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+//
+// SFTP Store
+//
+
+func init() {
+	registerBackend(&backend{
+		schemes: []string{"sftp"},
+		newStore: func(base *url.URL, opts ...Option) (Store, error) {
+			store, err := NewSFTPStoreWithOptions(base, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		},
+	})
+}
+
+type SFTPStore struct {
+	baseURL  *url.URL
+	basePath string
+	client   *sftp.Client
+	*commonStore
+}
+
+func NewSFTPStore(baseURL *url.URL, extension, compressionType string, overwrite bool) (*SFTPStore, error) {
+	return NewSFTPStoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+// NewSFTPStoreWithOptions connects to the `sftp://user@host:port/path` URL.
+// It authenticates with the URL's password or the `SFTP_PASSWORD` environment
+// variable, the private key file of the `key_file` query parameter or of the
+// `SFTP_PRIVATE_KEY_FILE` environment variable, and the keys of the running
+// SSH agent. The server's host key is verified against the `known_hosts`
+// query parameter file, `~/.ssh/known_hosts` by default, unless
+// `ignore_host_key=true` is set.
+func NewSFTPStoreWithOptions(baseURL *url.URL, opts ...Option) (*SFTPStore, error) {
+	sshConfig, err := newSFTPClientConfig(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := baseURL.Host
+	if baseURL.Port() == "" {
+		addr = net.JoinHostPort(baseURL.Hostname(), "22")
+	}
+
+	conn, err := ssh.Dial("tcp", addr, sshConfig)
+	if err != nil {
+		return nil, fmt.Errorf("ssh dial %q: %w", addr, err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sftp client: %w", err)
+	}
+
+	return NewSFTPStoreFromClient(baseURL, client, opts...)
+}
+
+// NewSFTPStoreFromClient creates a store on top of an already connected SFTP
+// client, for SSH setups that cannot be expressed through the store URL.
+func NewSFTPStoreFromClient(baseURL *url.URL, client *sftp.Client, opts ...Option) (*SFTPStore, error) {
+	config := newConfig(opts)
+
+	basePath := path.Clean("/" + baseURL.Path)
+	if err := client.MkdirAll(basePath); err != nil {
+		return nil, fmt.Errorf("unable to create base path %q: %w", basePath, err)
+	}
+
+	return &SFTPStore{
+		baseURL:     baseURL,
+		basePath:    basePath,
+		client:      client,
+		commonStore: newCommonStore(baseURL, config),
+	}, nil
+}
+
+func newSFTPClientConfig(baseURL *url.URL) (*ssh.ClientConfig, error) {
+	if baseURL.User == nil || baseURL.User.Username() == "" {
+		return nil, fmt.Errorf("specify sftp user like: sftp://user@host/path")
+	}
+
+	query := baseURL.Query()
+
+	var auths []ssh.AuthMethod
+	password, _ := baseURL.User.Password()
+	if password == "" {
+		password = os.Getenv("SFTP_PASSWORD")
+	}
+	if password != "" {
+		auths = append(auths, ssh.Password(password))
+	}
+
+	keyFile := query.Get("key_file")
+	if keyFile == "" {
+		keyFile = os.Getenv("SFTP_PRIVATE_KEY_FILE")
+	}
+	if keyFile != "" {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading private key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("parsing private key %q: %w", keyFile, err)
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			auths = append(auths, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	if len(auths) == 0 {
+		return nil, fmt.Errorf("specify sftp credentials with a password, a private key file or through an ssh agent")
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if query.Get("ignore_host_key") != "true" {
+		knownHostsFile := query.Get("known_hosts")
+		if knownHostsFile == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("locating known hosts: %w", err)
+			}
+			knownHostsFile = path.Join(home, ".ssh", "known_hosts")
+		}
+
+		var err error
+		hostKeyCallback, err = knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading known hosts %q: %w", knownHostsFile, err)
+		}
+	}
+
+	return &ssh.ClientConfig{
+		User:            baseURL.User.Username(),
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}, nil
+}
+
+// SubStore shares the SFTP connection of the parent store.
+func (s *SFTPStore) SubStore(subFolder string) (Store, error) {
+	url, err := url.Parse(s.baseURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("sftp store parsing base url: %w", err)
+	}
+	url.Path = path.Join(url.Path, subFolder)
+	return NewSFTPStoreFromClient(url, s.client, s.options()...)
+}
+
+func (s *SFTPStore) BaseURL() *url.URL {
+	return s.baseURL
+}
+
+func (s *SFTPStore) ObjectPath(name string) string {
+	return path.Join(s.basePath, s.pathWithExt(name))
+}
+
+func (s *SFTPStore) ObjectURL(name string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(s.baseURL.String(), "/"), strings.TrimLeft(s.pathWithExt(name), "/"))
+}
+
+func (s *SFTPStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return "", fmt.Errorf("sftp store presign: %w", ErrNotSupported)
+}
+
+func (s *SFTPStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return "", fmt.Errorf("sftp store presign: %w", ErrNotSupported)
+}
+
+func (s *SFTPStore) toBaseName(filePath string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(filePath, s.pathWithExt("")), strings.TrimSuffix(s.basePath, "/")+"/")
+}
+
+func (s *SFTPStore) WriteObject(ctx context.Context, base string, reader io.Reader, opts ...WriteOption) (err error) {
+	defer classifyError(&err)
+	destPath := s.ObjectPath(base)
+	config := newWriteConfig(opts)
+	reader = config.trackProgress(reader)
+	if config.acl != "" {
+		return fmt.Errorf("object ACL: %w", ErrNotSupported)
+	}
+
+	if !s.overwrite {
+		exists, err := s.FileExists(ctx, base)
+		if err != nil {
+			return err
+		}
+		if exists {
+			// We silently ignore when we ask not to overwrite
+			return nil
+		}
+	}
+
+	return s.writeFile(destPath, config.metadata, func(w io.Writer) error {
+		return s.compressedCopy(ctx, reader, w)
+	})
+}
+
+// writeFile writes the file through a temporary file renamed once complete,
+// so readers never see a partially written file.
+func (s *SFTPStore) writeFile(destPath string, metadata map[string]string, write func(w io.Writer) error) error {
+	tempPath := destPath + ".tmp"
+
+	targetDir := path.Dir(tempPath)
+	if err := s.client.MkdirAll(targetDir); err != nil {
+		return fmt.Errorf("ensuring directory exists (mkdir -p) %q: %w", targetDir, err)
+	}
+
+	file, err := s.client.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("unable to create file %q: %w", tempPath, err)
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+		s.client.Remove(tempPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := s.sidecarFiles().writeMetadata(destPath, metadata); err != nil {
+		return err
+	}
+
+	if err := s.rename(tempPath, destPath); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+// rename replaces `newPath` when it exists, which plain SFTP renames refuse to
+// do, through the `posix-rename@openssh.com` extension when available.
+func (s *SFTPStore) rename(oldPath, newPath string) error {
+	if _, ok := s.client.HasExtension("posix-rename@openssh.com"); ok {
+		return s.client.PosixRename(oldPath, newPath)
+	}
+
+	if err := s.client.Remove(newPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.client.Rename(oldPath, newPath)
+}
+
+// sidecarFiles returns the primitives of the metadata sidecar files.
+func (s *SFTPStore) sidecarFiles() sidecarFiles {
+	return sidecarFiles{
+		open: func(path string) (io.ReadCloser, error) {
+			file, err := s.client.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			return file, nil
+		},
+		write: func(path string, data []byte) error {
+			file, err := s.client.Create(path)
+			if err != nil {
+				return err
+			}
+			if _, err := file.Write(data); err != nil {
+				file.Close()
+				return err
+			}
+			return file.Close()
+		},
+		remove:   s.client.Remove,
+		notFound: os.IsNotExist,
+	}
+}
+
+func (s *SFTPStore) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, s, dst); skip || err != nil {
+		return err
+	}
+
+	srcPath := s.ObjectPath(src)
+	srcFile, err := s.client.Open(srcPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	defer srcFile.Close()
+
+	metadata, err := s.sidecarFiles().readMetadata(srcPath)
+	if err != nil {
+		return fmt.Errorf("reading metadata: %w", err)
+	}
+
+	return s.writeFile(s.ObjectPath(dst), metadata, func(w io.Writer) error {
+		_, err := io.Copy(w, srcFile)
+		return err
+	})
+}
+
+func (s *SFTPStore) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer classifyError(&err)
+	oldPath := s.ObjectPath(oldName)
+
+	if skip, err := skipExistingCopy(ctx, s, newName); skip || err != nil {
+		if err != nil {
+			return err
+		}
+		return s.DeleteObject(ctx, oldName)
+	}
+
+	newPath := s.ObjectPath(newName)
+
+	targetDir := path.Dir(newPath)
+	if err := s.client.MkdirAll(targetDir); err != nil {
+		return fmt.Errorf("ensuring directory exists (mkdir -p) %q: %w", targetDir, err)
+	}
+
+	metadata, err := s.sidecarFiles().readMetadata(oldPath)
+	if err != nil {
+		return fmt.Errorf("reading metadata: %w", err)
+	}
+
+	if err := s.rename(oldPath, newPath); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("rename: %w", err)
+	}
+
+	if err := s.sidecarFiles().writeMetadata(newPath, metadata); err != nil {
+		return err
+	}
+	if err := s.client.Remove(oldPath + localMetadataSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing metadata: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SFTPStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(name)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file", zap.String("path", path))
+	}
+
+	file, err := s.client.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return s.uncompressedReader(ctx, s.resumeReads(ctx, name, file, s.openStoredRange))
+}
+
+func (s *SFTPStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	if s.decompressesReads() {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+	reader, err := s.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.throttleReads(ctx, reader), nil
+}
+
+func (s *SFTPStore) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	file, err := s.client.Open(s.ObjectPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("seeking to offset %d: %w", offset, err)
+	}
+
+	return limitReadCloser(file, length), nil
+}
+
+func (s *SFTPStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer classifyError(&err)
+	if _, err := s.client.Stat(s.ObjectPath(base)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *SFTPStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+
+	info, err := s.client.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return s.newObjectAttrs(base, path, info)
+}
+
+func (s *SFTPStore) newObjectAttrs(name, path string, info os.FileInfo) (*ObjectAttrs, error) {
+	metadata, err := s.sidecarFiles().readMetadata(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+
+	return &ObjectAttrs{
+		Name:         name,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		ETag:         fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+		Metadata:     metadata,
+	}, nil
+}
+
+func (s *SFTPStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer classifyError(&err)
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+func (s *SFTPStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer classifyError(&err)
+	return listFiles(ctx, s, prefix, max)
+}
+
+func (s *SFTPStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer classifyError(&err)
+	return listFilesPage(ctx, s, prefix, pageSize, pageToken)
+}
+
+func (s *SFTPStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer classifyError(&err)
+	prefix = strings.Trim(prefix, "/")
+
+	entries, err := s.client.ReadDir(path.Join(s.basePath, prefix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			out = append(out, path.Join(prefix, entry.Name()))
+		}
+	}
+	return out, nil
+}
+
+func (s *SFTPStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
+}
+
+func (s *SFTPStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkBetween(s, ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *SFTPStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *SFTPStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkDirectories(ctx, s.basePath, prefix, s.listDir, f)
+}
+
+func (s *SFTPStore) listDir(dir string) ([]*dirEntry, error) {
+	infos, err := s.client.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	entries := make([]*dirEntry, len(infos))
+	for i, info := range infos {
+		info, entryPath := info, dir+"/"+info.Name()
+		entries[i] = &dirEntry{path: entryPath, dir: info.IsDir(), attrs: func(name string) (*ObjectAttrs, error) {
+			return s.newObjectAttrs(name, entryPath, info)
+		}}
+	}
+	return entries, nil
+}
+
+func (s *SFTPStore) DeleteObject(ctx context.Context, base string) (err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+	if err := s.client.Remove(path + localMetadataSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing metadata: %w", err)
+	}
+	return s.client.Remove(path)
+}
+
+func (s *SFTPStore) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer classifyError(&err)
+	return deleteObjectsConcurrently(ctx, names, deleteObjectsConcurrency, func(ctx context.Context, name string) error {
+		if err := s.DeleteObject(ctx, name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *SFTPStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer classifyError(&err)
+	return deletePrefix(ctx, s, prefix, deletePrefixConcurrency)
+}
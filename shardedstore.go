@@ -0,0 +1,349 @@
+package dstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//
+// Sharded Store
+//
+
+// ShardedStore is a `Store` spreading its objects across several stores, for
+// example buckets, to get past per-bucket rate limits. Each object lives on
+// the shard chosen by rendezvous hashing of its name, so appending a shard
+// only moves the objects that now hash to it. Walks and listings merge the
+// ones of every shard, in order.
+//
+// Objects are not moved when shards are appended, the ones not on their shard
+// anymore are only seen by walks until they are copied over.
+type ShardedStore struct {
+	// Store is the first shard, providing the base URL and overwrite setting.
+	Store
+
+	shards []Store
+	// prefix is the sub folder of sub stores, hashed along the names so that
+	// objects land on the same shard whatever store they're accessed from.
+	prefix string
+}
+
+// NewShardedStore returns a store spreading objects across `shards`. Shards are
+// identified by their position, so they must always be given in the same
+// order, new ones being appended.
+func NewShardedStore(shards []Store) (*ShardedStore, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("sharded store requires at least one shard")
+	}
+
+	return &ShardedStore{Store: shards[0], shards: shards}, nil
+}
+
+// SubStore returns a sharded store on the sub stores of every shard.
+func (s *ShardedStore) SubStore(subFolder string) (Store, error) {
+	shards := make([]Store, len(s.shards))
+	for i, shard := range s.shards {
+		sub, err := shard.SubStore(subFolder)
+		if err != nil {
+			return nil, fmt.Errorf("sub store of %s: %w", shard.BaseURL(), err)
+		}
+		shards[i] = sub
+	}
+
+	return &ShardedStore{Store: shards[0], shards: shards, prefix: directoryPrefix(s.prefix, subFolder)}, nil
+}
+
+// shard returns the shard holding the object `name`, the one with the highest
+// hash of its position and the name.
+func (s *ShardedStore) shard(name string) Store {
+	var best int
+	var bestScore uint64
+	for i := range s.shards {
+		sum := sha256.Sum256([]byte(strconv.Itoa(i) + "/" + s.prefix + name))
+		if score := binary.BigEndian.Uint64(sum[:8]); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return s.shards[best]
+}
+
+func (s *ShardedStore) SetOverwrite(enabled bool) {
+	for _, shard := range s.shards {
+		shard.SetOverwrite(enabled)
+	}
+}
+
+func (s *ShardedStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	return s.shard(name).OpenObject(ctx, name)
+}
+
+func (s *ShardedStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	return s.shard(name).OpenObjectRange(ctx, name, offset, length)
+}
+
+func (s *ShardedStore) FileExists(ctx context.Context, base string) (bool, error) {
+	return s.shard(base).FileExists(ctx, base)
+}
+
+func (s *ShardedStore) ObjectAttributes(ctx context.Context, base string) (*ObjectAttrs, error) {
+	return s.shard(base).ObjectAttributes(ctx, base)
+}
+
+func (s *ShardedStore) ObjectPath(base string) string {
+	return s.shard(base).ObjectPath(base)
+}
+
+func (s *ShardedStore) ObjectURL(base string) string {
+	return s.shard(base).ObjectURL(base)
+}
+
+func (s *ShardedStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return s.shard(base).PresignGet(ctx, base, ttl)
+}
+
+func (s *ShardedStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return s.shard(base).PresignPut(ctx, base, ttl)
+}
+
+func (s *ShardedStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	return s.shard(base).WriteObject(ctx, base, f, opts...)
+}
+
+func (s *ShardedStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+// CopyObject copies natively when both objects are on the same shard,
+// otherwise through `Copy`.
+func (s *ShardedStore) CopyObject(ctx context.Context, src, dst string) error {
+	srcShard, dstShard := s.shard(src), s.shard(dst)
+	if srcShard == dstShard {
+		return srcShard.CopyObject(ctx, src, dst)
+	}
+
+	if err := Copy(ctx, srcShard, src, dstShard, dst); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *ShardedStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	oldShard := s.shard(oldName)
+	if oldShard == s.shard(newName) {
+		return oldShard.RenameObject(ctx, oldName, newName)
+	}
+	return renameObject(ctx, s, oldName, newName)
+}
+
+func (s *ShardedStore) DeleteObject(ctx context.Context, base string) error {
+	return s.shard(base).DeleteObject(ctx, base)
+}
+
+// DeleteObjects deletes the objects of each shard concurrently, stopping at the
+// first error.
+func (s *ShardedStore) DeleteObjects(ctx context.Context, names []string) error {
+	byShard := map[Store][]string{}
+	for _, name := range names {
+		shard := s.shard(name)
+		byShard[shard] = append(byShard[shard], name)
+	}
+
+	errs := s.fanOut(func(_ int, shard Store) error {
+		if len(byShard[shard]) == 0 {
+			return nil
+		}
+		return shard.DeleteObjects(ctx, byShard[shard])
+	})
+	return firstShardError("delete objects", s.shards, errs)
+}
+
+func (s *ShardedStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	counts := make([]int, len(s.shards))
+	errs := s.fanOut(func(i int, shard Store) (err error) {
+		counts[i], err = shard.DeletePrefix(ctx, prefix)
+		return err
+	})
+
+	for _, count := range counts {
+		deleted += count
+	}
+	return deleted, firstShardError("delete prefix", s.shards, errs)
+}
+
+// fanOut calls `f` on every shard concurrently, returning the error of each
+// shard.
+func (s *ShardedStore) fanOut(f func(i int, shard Store) error) []error {
+	errs := make([]error, len(s.shards))
+
+	var wg sync.WaitGroup
+	for i, shard := range s.shards {
+		wg.Add(1)
+		go func(i int, shard Store) {
+			defer wg.Done()
+			errs[i] = f(i, shard)
+		}(i, shard)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+func firstShardError(op string, shards []Store, errs []error) error {
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("%s on shard %s: %w", op, shards[i].BaseURL(), err)
+		}
+	}
+	return nil
+}
+
+func (s *ShardedStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
+	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
+}
+
+func (s *ShardedStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error {
+	return commonWalkBetween(s, ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *ShardedStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error {
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+// shardWalk is the walk of one shard, feeding its objects one at a time to the
+// merge.
+type shardWalk struct {
+	shard   Store
+	objects chan *ObjectAttrs
+	// err is only read once objects is closed
+	err  error
+	head *ObjectAttrs
+}
+
+// next moves head to the next object of the shard, nil once the walk is over.
+func (w *shardWalk) next() error {
+	if w.head = <-w.objects; w.head == nil && w.err != nil {
+		return fmt.Errorf("walk shard %s: %w", w.shard.BaseURL(), w.err)
+	}
+	return nil
+}
+
+// WalkObjects walks every shard concurrently, merging their ordered listings.
+// An object present on several shards is only walked once.
+func (s *ShardedStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) error {
+	f = skipPrefixes(f)
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	walks := make([]*shardWalk, len(s.shards))
+	for i, shard := range s.shards {
+		walk := &shardWalk{shard: shard, objects: make(chan *ObjectAttrs, 64)}
+		walks[i] = walk
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(walk.objects)
+
+			walk.err = walk.shard.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+				select {
+				case walk.objects <- attrs:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+		}()
+	}
+
+	active := walks[:0]
+	for _, walk := range walks {
+		if err := walk.next(); err != nil {
+			return err
+		}
+		if walk.head != nil {
+			active = append(active, walk)
+		}
+	}
+
+	var last string
+	for len(active) > 0 {
+		next := 0
+		for i, walk := range active {
+			if walk.head.Name < active[next].head.Name {
+				next = i
+			}
+		}
+
+		walk := active[next]
+		if walk.head.Name != last {
+			last = walk.head.Name
+			if err := f(walk.head); err != nil {
+				if err == StopIteration {
+					return nil
+				}
+				return err
+			}
+		}
+
+		if err := walk.next(); err != nil {
+			return err
+		}
+		if walk.head == nil {
+			active = append(active[:next], active[next+1:]...)
+		}
+	}
+	return nil
+}
+
+func (s *ShardedStore) ListFiles(ctx context.Context, prefix string, max int) ([]string, error) {
+	return listFiles(ctx, s, prefix, max)
+}
+
+func (s *ShardedStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	return listFilesPage(ctx, s, prefix, pageSize, pageToken)
+}
+
+// ListDirectories returns the union of the directories of every shard.
+func (s *ShardedStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	dirs := make([][]string, len(s.shards))
+	errs := s.fanOut(func(i int, shard Store) (err error) {
+		dirs[i], err = shard.ListDirectories(ctx, prefix)
+		return err
+	})
+	if err := firstShardError("list directories", s.shards, errs); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, shardDirs := range dirs {
+		for _, dir := range shardDirs {
+			if !seen[dir] {
+				seen[dir] = true
+				out = append(out, dir)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
@@ -0,0 +1,85 @@
+package dstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedStore(t *testing.T) {
+	ctx := context.Background()
+	shards := []*MemoryStore{NewMemoryStore(), NewMemoryStore(), NewMemoryStore()}
+	store, err := NewShardedStore([]Store{shards[0], shards[1], shards[2]})
+	require.NoError(t, err)
+
+	var names []string
+	for i := 0; i < 30; i++ {
+		names = append(names, fmt.Sprintf("blocks/%04d", i))
+		require.NoError(t, store.WriteObject(ctx, names[i], strings.NewReader(names[i])))
+	}
+
+	total := 0
+	for _, shard := range shards {
+		files, err := shard.ListFiles(ctx, "", 100)
+		require.NoError(t, err)
+		assert.NotEmpty(t, files)
+		total += len(files)
+	}
+	assert.Equal(t, 30, total)
+
+	files, err := store.ListFiles(ctx, "blocks/", 100)
+	require.NoError(t, err)
+	assert.Equal(t, names, files)
+	content, err := ReadObject(ctx, store, "blocks/0007")
+	require.NoError(t, err)
+	assert.Equal(t, "blocks/0007", string(content))
+
+	// Sub stores place objects on the same shard as the root store
+	sub, err := store.SubStore("blocks")
+	require.NoError(t, err)
+	content, err = ReadObject(ctx, sub, "0012")
+	require.NoError(t, err)
+	assert.Equal(t, "blocks/0012", string(content))
+	require.NoError(t, sub.WriteObject(ctx, "0030", strings.NewReader("blocks/0030")))
+	content, err = ReadObject(ctx, store, "blocks/0030")
+	require.NoError(t, err)
+	assert.Equal(t, "blocks/0030", string(content))
+
+	// Renames across shards
+	for i := 0; i < 10; i++ {
+		require.NoError(t, store.RenameObject(ctx, names[i], fmt.Sprintf("renamed/%04d", i)))
+	}
+	renamed, err := store.ListFiles(ctx, "renamed/", 100)
+	require.NoError(t, err)
+	assert.Len(t, renamed, 10)
+	content, err = ReadObject(ctx, store, "renamed/0003")
+	require.NoError(t, err)
+	assert.Equal(t, "blocks/0003", string(content))
+	assert.Equal(t, ErrNotFound, store.RenameObject(ctx, "missing", "renamed/missing"))
+
+	deleted, err := store.DeletePrefix(ctx, "blocks/")
+	require.NoError(t, err)
+	assert.Equal(t, 21, deleted)
+}
+
+func TestShardedStore_AppendedShard(t *testing.T) {
+	shards := []Store{NewMemoryStore(), NewMemoryStore(), NewMemoryStore()}
+	store, err := NewShardedStore(shards[:2])
+	require.NoError(t, err)
+	grown, err := NewShardedStore(shards)
+	require.NoError(t, err)
+
+	moved := 0
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("%04d", i)
+		if before, after := store.shard(name), grown.shard(name); before != after {
+			assert.Equal(t, shards[2], after, name)
+			moved++
+		}
+	}
+	assert.InDelta(t, 33, moved, 15)
+}
@@ -0,0 +1,98 @@
+package dstore
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LogSlowOperations returns an interceptor, for `NewInterceptedStore`,
+// logging a warning for the operations taking longer than `threshold`, with
+// their name, duration and bytes transferred, to surface a degraded backend
+// before dashboards do.
+//
+// Reads are measured from the opening of the object to the closing of its
+// reader, counting only the time spent in its `Read` calls and not the time
+// the caller spends between them, and logged once the reader is closed.
+func LogSlowOperations(threshold time.Duration) Interceptor {
+	return func(op *Operation, next Handler) Handler {
+		return func(ctx context.Context) error {
+			var written int64
+			if op.Content != nil {
+				counted := &metricsReader{Reader: op.Content, observe: func(n int) { atomic.AddInt64(&written, int64(n)) }}
+				if seeker, ok := op.Content.(io.ReadSeeker); ok {
+					op.Content = &metricsReadSeeker{metricsReader: counted, seeker: seeker}
+				} else {
+					op.Content = counted
+				}
+			}
+
+			start := time.Now()
+			err := next(ctx)
+			elapsed := time.Since(start)
+
+			if err == nil && op.Reader != nil {
+				op.Reader = &slowLoggedReader{ReadCloser: op.Reader, op: op, threshold: threshold, elapsed: elapsed}
+				return nil
+			}
+			if elapsed >= threshold {
+				logSlowOperation(op, elapsed, atomic.LoadInt64(&written), err)
+			}
+			return err
+		}
+	}
+}
+
+func logSlowOperation(op *Operation, elapsed time.Duration, bytes int64, err error) {
+	fields := []zap.Field{
+		zap.String("method", op.Method),
+		zap.String("name", op.Name),
+		zap.Duration("duration", elapsed),
+		zap.Int64("bytes", bytes),
+	}
+	if op.Destination != "" {
+		fields = append(fields, zap.String("destination", op.Destination))
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	storeLogger(op.Store).Warn("slow store operation", fields...)
+}
+
+// slowLoggedReader measures the time spent in the reads of an opened object,
+// logging it once closed when the open and the reads took longer than the
+// threshold.
+type slowLoggedReader struct {
+	io.ReadCloser
+	op        *Operation
+	threshold time.Duration
+	elapsed   time.Duration
+	read      int64
+	readErr   error
+	closeOnce sync.Once
+}
+
+func (r *slowLoggedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.ReadCloser.Read(p)
+	r.elapsed += time.Since(start)
+	r.read += int64(n)
+	if err != nil && err != io.EOF && r.readErr == nil {
+		r.readErr = err
+	}
+	return n, err
+}
+
+func (r *slowLoggedReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.closeOnce.Do(func() {
+		if r.elapsed >= r.threshold {
+			logSlowOperation(r.op, r.elapsed, r.read, r.readErr)
+		}
+	})
+	return err
+}
@@ -0,0 +1,42 @@
+package dstore
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogSlowOperations(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zapcore.WarnLevel)
+	inner := NewMemoryStore(Logger(zap.New(core)))
+
+	fast := NewInterceptedStore(inner, LogSlowOperations(time.Hour))
+	require.NoError(t, fast.WriteObject(ctx, "file", strings.NewReader("content")))
+	assert.Equal(t, 0, logs.Len())
+
+	slow := NewInterceptedStore(inner, LogSlowOperations(0))
+	require.NoError(t, slow.WriteObject(ctx, "other", strings.NewReader("more")))
+	reader, err := slow.OpenObject(ctx, "file")
+	require.NoError(t, err)
+	assert.Equal(t, 1, logs.Len(), "reads are logged once closed")
+	_, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	entries := logs.FilterMessage("slow store operation").AllUntimed()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "WriteObject", entries[0].ContextMap()["method"])
+	assert.Equal(t, "other", entries[0].ContextMap()["name"])
+	assert.Equal(t, int64(4), entries[0].ContextMap()["bytes"])
+	assert.Equal(t, "OpenObject", entries[1].ContextMap()["method"])
+	assert.Equal(t, int64(7), entries[1].ContextMap()["bytes"])
+}
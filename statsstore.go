@@ -0,0 +1,140 @@
+package dstore
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+//
+// Stats Store
+//
+
+// StoreStats are the cumulative statistics of a `StatsStore`.
+type StoreStats struct {
+	// Operations is the number of operations started, by `Store` method.
+	Operations map[string]int64 `json:"operations"`
+	// Errors is the number of failed operations, by `ErrorClass`.
+	Errors map[string]int64 `json:"errors"`
+	// InFlight is the number of operations running. An opened object counts
+	// until its reader is closed.
+	InFlight int64 `json:"in_flight"`
+	// BytesRead and BytesWritten are the bytes of the content of the objects
+	// read and written, before compression.
+	BytesRead    int64 `json:"bytes_read"`
+	BytesWritten int64 `json:"bytes_written"`
+}
+
+// StatsStore is a `Store` keeping statistics of the operations on the inner
+// store in memory, returned by `Stats`, for admin endpoints and debugging
+// without a metrics stack. Sub stores count into the statistics of their
+// parent.
+type StatsStore struct {
+	// Store is the inner store, through the interceptor keeping the
+	// statistics.
+	Store
+
+	stats *storeStats
+}
+
+func NewStatsStore(inner Store) *StatsStore {
+	stats := &storeStats{operations: map[string]int64{}, errors: map[string]int64{}}
+	return &StatsStore{
+		Store: NewInterceptedStore(inner, stats.intercept),
+		stats: stats,
+	}
+}
+
+// Stats returns a snapshot of the statistics of the store.
+func (s *StatsStore) Stats() StoreStats {
+	return s.stats.snapshot()
+}
+
+func (s *StatsStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := *s
+	sub.Store = inner
+	return &sub, nil
+}
+
+type storeStats struct {
+	inFlight     int64
+	bytesRead    int64
+	bytesWritten int64
+
+	lock       sync.Mutex
+	operations map[string]int64
+	errors     map[string]int64
+}
+
+func (s *storeStats) intercept(op *Operation, next Handler) Handler {
+	return func(ctx context.Context) error {
+		s.lock.Lock()
+		s.operations[op.Method]++
+		s.lock.Unlock()
+
+		atomic.AddInt64(&s.inFlight, 1)
+		if op.Content != nil {
+			counted := &metricsReader{Reader: op.Content, observe: func(n int) { atomic.AddInt64(&s.bytesWritten, int64(n)) }}
+			if seeker, ok := op.Content.(io.ReadSeeker); ok {
+				op.Content = &metricsReadSeeker{metricsReader: counted, seeker: seeker}
+			} else {
+				op.Content = counted
+			}
+		}
+
+		err := next(ctx)
+		if err != nil {
+			s.lock.Lock()
+			s.errors[ErrorClass(err)]++
+			s.lock.Unlock()
+		}
+
+		if err == nil && op.Reader != nil {
+			op.Reader = &statsReader{
+				metricsReadCloser: metricsReadCloser{ReadCloser: op.Reader, observe: func(n int) { atomic.AddInt64(&s.bytesRead, int64(n)) }},
+				inFlight:          &s.inFlight,
+			}
+		} else {
+			atomic.AddInt64(&s.inFlight, -1)
+		}
+		return err
+	}
+}
+
+func (s *storeStats) snapshot() StoreStats {
+	stats := StoreStats{
+		Operations:   map[string]int64{},
+		Errors:       map[string]int64{},
+		InFlight:     atomic.LoadInt64(&s.inFlight),
+		BytesRead:    atomic.LoadInt64(&s.bytesRead),
+		BytesWritten: atomic.LoadInt64(&s.bytesWritten),
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for method, count := range s.operations {
+		stats.Operations[method] = count
+	}
+	for class, count := range s.errors {
+		stats.Errors[class] = count
+	}
+	return stats
+}
+
+// statsReader counts the bytes read, and ends the in-flight open once closed.
+type statsReader struct {
+	metricsReadCloser
+	inFlight  *int64
+	closeOnce sync.Once
+}
+
+func (r *statsReader) Close() error {
+	r.closeOnce.Do(func() { atomic.AddInt64(r.inFlight, -1) })
+	return r.metricsReadCloser.Close()
+}
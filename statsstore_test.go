@@ -0,0 +1,41 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewStatsStore(NewMemoryStore())
+
+	require.NoError(t, store.WriteObject(ctx, "file", bytes.NewReader([]byte("content"))))
+	reader, err := store.OpenObject(ctx, "file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), store.Stats().InFlight, "open objects are in flight until closed")
+
+	_, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.NoError(t, reader.Close())
+
+	_, err = store.OpenObject(ctx, "missing")
+	require.Error(t, err)
+
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+	_, err = sub.FileExists(ctx, "file")
+	require.NoError(t, err)
+
+	assert.Equal(t, StoreStats{
+		Operations:   map[string]int64{"WriteObject": 1, "OpenObject": 2, "FileExists": 1},
+		Errors:       map[string]int64{"not_found": 1},
+		BytesRead:    7,
+		BytesWritten: 7,
+	}, store.Stats())
+}
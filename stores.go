@@ -6,32 +6,89 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"go.uber.org/zap"
 )
 
 var ErrNotFound = errors.New("not found")
 
+// ErrNotSupported is returned by stores for operations that the backend
+// cannot perform.
+var ErrNotSupported = errors.New("not supported")
+
 type Store interface {
 	OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error)
+	// OpenObjectRange opens the object and reads `length` bytes starting at
+	// `offset`, a negative `length` reads up to the end of the object. Offsets
+	// are always expressed on the uncompressed content.
+	OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error)
 	FileExists(ctx context.Context, base string) (bool, error)
+	ObjectAttributes(ctx context.Context, base string) (*ObjectAttrs, error)
 	ObjectPath(base string) string
 	ObjectURL(base string) string
 
-	WriteObject(ctx context.Context, base string, f io.Reader) (err error)
+	// PresignGet returns a URL that can be used without credentials to
+	// download the object for the next `ttl`. The downloaded content is the
+	// object as stored, so compressed when the store uses compression.
+	PresignGet(ctx context.Context, base string, ttl time.Duration) (string, error)
+	// PresignPut returns a URL that can be used without credentials to upload
+	// the object for the next `ttl`. The uploaded content is stored as-is, it
+	// is up to the uploader to compress it according to the store's
+	// compression.
+	PresignPut(ctx context.Context, base string, ttl time.Duration) (string, error)
+
+	WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error)
 	PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error)
+	// CopyObject copies `src` to `dst` within the store, server-side when the
+	// backend supports it. The content is copied as-is, without being
+	// decompressed and re-compressed.
+	CopyObject(ctx context.Context, src, dst string) error
+	// RenameObject behaves like a `CopyObject` followed by a `DeleteObject` of
+	// the old name, so when overwrite is disabled and `newName` already exists,
+	// it is left untouched and `oldName` is still deleted.
+	RenameObject(ctx context.Context, oldName, newName string) error
 
 	Overwrite() bool
 	SetOverwrite(enabled bool)
 
 	WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error
+	// WalkBetween is like `WalkFrom` but stops before `endPoint`, which is
+	// exclusive, so that a key range can be walked without reading past it. An
+	// empty `endPoint` walks up to the end.
+	WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error
 
 	Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error
+	// WalkObjects is like `Walk` but yields the attributes of each object as
+	// returned by the listing, avoiding a round-trip per object. `Metadata` is
+	// only populated by the Google Storage and local stores, the other
+	// backends do not return it when listing.
+	WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) error
 	ListFiles(ctx context.Context, prefix string, max int) ([]string, error)
+	// ListFilesPage returns up to `pageSize` files along with the token to pass
+	// to retrieve the next page, empty once the listing is exhausted. Tokens are
+	// opaque and backend specific, an empty `pageToken` starts from the
+	// beginning.
+	ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error)
+	// ListDirectories returns the immediate sub-directories of the `prefix`
+	// directory, relative to the store and without a trailing `/`, using
+	// delimited listings so that the objects they contain are not listed.
+	ListDirectories(ctx context.Context, prefix string) ([]string, error)
 
 	DeleteObject(ctx context.Context, base string) error
+	// DeleteObjects deletes all the objects at once, using the backend's batch
+	// delete API when available or concurrent deletions otherwise. Objects that
+	// do not exist are ignored.
+	DeleteObjects(ctx context.Context, names []string) error
+	// DeletePrefix deletes every object under `prefix`, returning the number of
+	// objects deleted.
+	DeletePrefix(ctx context.Context, prefix string) (deleted int, err error)
 
 	// Used to retrieve original query parameters, allowing further
 	// configurability of the consumers of this store.
@@ -41,6 +98,59 @@ type Store interface {
 
 var StopIteration = errors.New("stop iteration")
 
+// SkipPrefix returns an error that, when returned by a walk callback, makes the
+// walk jump past every file starting with `prefix`, like `filepath.SkipDir`.
+// The Google Storage and S3 stores seek past the skipped files, other stores
+// still list them but without invoking the callback.
+func SkipPrefix(prefix string) error {
+	return &skipPrefixError{prefix: prefix}
+}
+
+type skipPrefixError struct {
+	prefix string
+}
+
+func (e *skipPrefixError) Error() string {
+	return fmt.Sprintf("skip prefix %q", e.prefix)
+}
+
+// skippedPrefix returns the prefix to skip when `err` was created by
+// `SkipPrefix`.
+func skippedPrefix(err error) (prefix string, ok bool) {
+	if e, ok := err.(*skipPrefixError); ok {
+		return e.prefix, true
+	}
+	return "", false
+}
+
+// ObjectAttrs represents the attributes of a stored object, as reported by the
+// backing store, without having to download its content.
+type ObjectAttrs struct {
+	// Name is the object's base name, relative to the store and without the
+	// store's extension, just like the names passed to `Walk` callbacks.
+	Name string
+
+	// Size is the size in bytes of the object as stored, so it's the compressed
+	// size when the store is configured with compression.
+	Size         int64
+	LastModified time.Time
+
+	// ETag is the backend's entity tag for the object. The local store derives
+	// one from the file's modification time and size.
+	ETag string
+
+	// Generation is the object's generation number, changing with its
+	// content, and Metageneration the version of its metadata within that
+	// generation. They are only populated by the Google Storage store, zero for
+	// the others.
+	Generation     int64
+	Metageneration int64
+
+	// Metadata is the user metadata attached with `WithMetadata` when the
+	// object was written, keys are lower-cased.
+	Metadata map[string]string
+}
+
 func NewDBinStore(baseURL string) (Store, error) {
 	return NewStore(baseURL, "dbin.zst", "zstd", false)
 }
@@ -57,6 +167,26 @@ func NewSimpleStore(baseURL string) (Store, error) {
 
 // NewStore creates a new Store instance. The baseURL is always a directory, and does not end with a `/`.
 func NewStore(baseURL, extension, compressionType string, overwrite bool) (Store, error) {
+	return NewStoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+// NewStoreWithOptions creates a new Store instance configured through the
+// given options. The baseURL is always a directory, and does not end with a `/`.
+func NewStoreWithOptions(baseURL string, opts ...Option) (Store, error) {
+	store, err := newBackendStore(baseURL, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout := newConfig(opts).operationTimeout; timeout > 0 {
+		return NewInterceptedStore(store, TimeoutOperations(timeout)), nil
+	}
+	return store, nil
+}
+
+// newBackendStore creates the store of the backend of the scheme of
+// `baseURL`.
+func newBackendStore(baseURL string, opts []Option) (Store, error) {
 	if strings.HasSuffix(baseURL, "/") {
 		return nil, fmt.Errorf("baseURL shouldn't end with a /")
 	}
@@ -71,24 +201,131 @@ func NewStore(baseURL, extension, compressionType string, overwrite bool) (Store
 	// file://superbob
 	switch base.Scheme {
 	case "gs":
-		return NewGSStore(base, extension, compressionType, overwrite)
+		return NewGSStoreWithOptions(base, opts...)
 	case "az":
-		return NewAzureStore(base, extension, compressionType, overwrite)
+		return NewAzureStoreWithOptions(base, opts...)
 	case "s3":
-		return NewS3Store(base, extension, compressionType, overwrite)
+		return NewS3StoreWithOptions(base, opts...)
 	case "file":
-		return NewLocalStore(base, extension, compressionType, overwrite)
+		return NewLocalStoreWithOptions(base, opts...)
 	case "":
 		// If scheme is empty, let's assume baseURL was a absolute/relative path without being an actual URL
-		return NewLocalStore(base, extension, compressionType, overwrite)
+		return NewLocalStoreWithOptions(base, opts...)
 	}
 
-	return nil, fmt.Errorf("archive store only supports, file://, gs:// or local path")
+	schemes := []string{"file://", "gs://", "s3://", "az://"}
+	for _, backend := range backends {
+		for _, scheme := range backend.schemes {
+			if scheme == base.Scheme {
+				return backend.newStore(base, opts...)
+			}
+			schemes = append(schemes, scheme+"://")
+		}
+	}
+
+	return nil, fmt.Errorf("archive store only supports, %s or local path", strings.Join(schemes, ", "))
+}
+
+// backend is an optional store backend, registered by its file so that it can
+// be left out of builds with its `dstore_no_<backend>` build tag along with
+// the dependencies of its client.
+type backend struct {
+	schemes  []string
+	newStore func(base *url.URL, opts ...Option) (Store, error)
+	// errorClass returns the class of the errors of the client of the backend,
+	// nil for the errors it doesn't know.
+	errorClass func(err error) error
+}
+
+// backends are the optional backends compiled in, in registration order.
+var backends []*backend
+
+func registerBackend(b *backend) {
+	backends = append(backends, b)
 }
 
 type config struct {
-	compression string
-	overwrite   bool
+	compression       string
+	compressionLevel  int
+	parallelGzip      bool
+	gzipBlockSize     int
+	gzipBlocks        int
+	detectCompression bool
+	rawReads          bool
+	contentEncoding   bool
+	seekable          bool
+	seekableFrameSize int
+	uploadChecksums   bool
+	verifyChecksums   bool
+	extension         string
+	overwrite         bool
+	contentType       string
+	cacheControl      string
+	credentialsFile   string
+
+	multipartThreshold int64
+	objectLock         bool
+
+	readAheadSize   int
+	readAheadChunks int
+
+	readResumes int
+
+	compositePartSize    int64
+	compositeConcurrency int
+
+	httpClient      *http.Client
+	httpVersion     int
+	idleConnTimeout time.Duration
+
+	gsClient *storage.Client
+
+	copyBufferSize int
+
+	syncWrites bool
+
+	passthroughCompressed bool
+
+	logger *zap.Logger
+
+	operationTimeout time.Duration
+
+	readBandwidth  int64
+	writeBandwidth int64
+	// bandwidth holds the limiters of a parent store, shared with its sub
+	// stores.
+	bandwidth *bandwidthLimits
+}
+
+func newConfig(opts []Option) *config {
+	config := &config{}
+	for _, opt := range opts {
+		opt.apply(config)
+	}
+	return config
+}
+
+// cloudCopyBufferSize is the default copy buffer size of the cloud stores,
+// whose uploads are faster with bigger writes.
+const cloudCopyBufferSize = 1024 * 1024
+
+// newCloudConfig is `newConfig` with the defaults of the cloud stores.
+func newCloudConfig(opts []Option) *config {
+	config := newConfig(opts)
+	if config.copyBufferSize == 0 {
+		config.copyBufferSize = cloudCopyBufferSize
+	}
+	return config
+}
+
+// legacyOptions converts the positional arguments of the historical
+// constructors into their equivalent options.
+func legacyOptions(extension, compressionType string, overwrite bool) []Option {
+	opts := []Option{Extension(extension), Compression(compressionType)}
+	if overwrite {
+		opts = append(opts, AllowOverwrite())
+	}
+	return opts
 }
 
 type Option interface {
@@ -108,12 +345,138 @@ func (f optionFunc) apply(config *config) {
 // - <empty>       No compression
 // - zstd          Use ZSTD compression
 // - gzip          Use GZIP compression
+// - lz4           Use LZ4 compression
+// - xz            Use XZ compression
+// - bzip2         Read BZIP2 compressed objects, writes are not supported
+//
+// Stores without compression whose extension ends with `bz2` or `xz` use the
+// corresponding compression.
 func Compression(compressionType string) Option {
 	return optionFunc(func(config *config) {
 		config.compression = compressionType
 	})
 }
 
+// CompressionLevel defines the level used to compress written objects, from 1
+// to 22 for zstd, mapped to the closest level it supports, and from
+// `gzip.BestSpeed` to `gzip.BestCompression` for gzip. Zero, the default, uses
+// the compressor's default level. The `compression_level` query parameter of
+// the store URL takes precedence over it.
+func CompressionLevel(level int) Option {
+	return optionFunc(func(config *config) {
+		config.compressionLevel = level
+	})
+}
+
+// DetectCompression decompresses read objects according to the magic bytes at
+// their start, recognizing gzip, zstd, lz4, xz and bzip2, and reading the other
+// objects as-is, whatever the store's compression. It's meant for buckets
+// holding objects of mixed compressions, writes still use the store's
+// compression.
+func DetectCompression() Option {
+	return optionFunc(func(config *config) {
+		config.detectCompression = true
+	})
+}
+
+// RawReads makes the store return read objects as stored, without
+// decompressing them, for example to relay compressed objects to clients that
+// decompress them on their own. Range reads then address the stored bytes.
+// Writes still compress objects according to the store's compression.
+func RawReads() Option {
+	return optionFunc(func(config *config) {
+		config.rawReads = true
+	})
+}
+
+// GzipContentEncoding stores the objects of gzip compressed stores on Google
+// Storage and S3 with the `Content-Encoding: gzip` header, along with their
+// logical `Content-Type`, so that browsers and CDNs serving them decompress them
+// transparently. The `content_encoding=gzip` query parameter of the store URL
+// enables it too. Reads of such stores fetch the objects as stored, the store
+// decompressing them itself.
+func GzipContentEncoding() Option {
+	return optionFunc(func(config *config) {
+		config.contentEncoding = true
+	})
+}
+
+// UploadChecksums makes the Google Storage and S3 stores send the CRC32C and
+// MD5 checksums of the written objects along with them, so that the backend
+// rejects the uploads corrupted on their way. The checksums are computed as the
+// content comes out of the compressor, which requires spooling the compressed
+// content to a temporary file before uploading it. The `checksums=true` query
+// parameter of the store URL enables it too.
+//
+// S3 verifies the MD5 of objects uploaded in a single request, multipart
+// uploads having the MD5 of each part computed from the spooled file instead,
+// and none being sent to S3 stores with `disable_checksums=true`.
+func UploadChecksums() Option {
+	return optionFunc(func(config *config) {
+		config.uploadChecksums = true
+	})
+}
+
+// VerifyChecksums makes the Google Storage and S3 stores compare the bytes of
+// the objects opened through `OpenObject`, as received and before being
+// decompressed, with the CRC32C stored by Google Storage or the MD5 found in
+// S3 ETags. Closing an object read up to its end whose bytes don't match
+// returns a `*ChecksumMismatchError`, matching `ErrChecksumMismatch`. The
+// `verify_checksums=true` query parameter of the store URL enables it too.
+//
+// It costs an extra request per object on Google Storage. Range reads, S3
+// objects uploaded in multiple parts or encrypted with KMS, and Google Storage
+// objects served decompressed are not verified.
+func VerifyChecksums() Option {
+	return optionFunc(func(config *config) {
+		config.verifyChecksums = true
+	})
+}
+
+// defaultSeekableFrameSize is the frame size of seekable zstd compression when
+// `SeekableZstd` is given zero.
+const defaultSeekableFrameSize = 1024 * 1024
+
+// SeekableZstd writes the objects of zstd compressed stores in the zstd
+// seekable format, compressing the content in independent frames of
+// `frameSize` decompressed bytes, 1MiB when zero, followed by a table of their
+// sizes. Range reads, like `OpenObjectAt`, then only fetch and decompress the
+// frames holding the range instead of the whole content before it. Objects
+// remain readable by any zstd decoder, and the ones without a seek table are
+// still read from their start. Smaller frames make range reads cheaper at the
+// expense of the compression ratio.
+func SeekableZstd(frameSize int) Option {
+	return optionFunc(func(config *config) {
+		config.seekable = true
+		config.seekableFrameSize = frameSize
+	})
+}
+
+// defaultGzipBlockSize is the block size of parallel gzip compression when
+// `ParallelGzip` is given zero.
+const defaultGzipBlockSize = 1024 * 1024
+
+// ParallelGzip compresses gzip objects on several cores, splitting the content
+// in blocks of `blockSize` bytes, 1MiB when zero, compressed `blocks` at a
+// time, the number of CPUs when zero. Blocks being compressed independently,
+// objects are slightly larger, and each write allocates its blocks, so it's
+// meant for stores of large objects.
+func ParallelGzip(blockSize, blocks int) Option {
+	return optionFunc(func(config *config) {
+		config.parallelGzip = true
+		config.gzipBlockSize = blockSize
+		config.gzipBlocks = blocks
+	})
+}
+
+// Extension defines the extension appended to every object name of the store,
+// without the leading `.` (e.g. `dbin.zst`).
+func Extension(extension string) Option {
+	return optionFunc(func(config *config) {
+		config.extension = extension
+	})
+}
+
 // AllowOverwrite allow files to be overwritten when already exist at a given
 // location.
 func AllowOverwrite() Option {
@@ -122,6 +485,212 @@ func AllowOverwrite() Option {
 	})
 }
 
+// DefaultContentType sets the `Content-Type` of objects written by the store,
+// the `content_type` query parameter of the store URL takes precedence over it
+// and `WithContentType` overrides it for a single write.
+func DefaultContentType(contentType string) Option {
+	return optionFunc(func(config *config) {
+		config.contentType = contentType
+	})
+}
+
+// DefaultCacheControl sets the `Cache-Control` of objects written by the store,
+// the `cache_control` query parameter of the store URL takes precedence over it
+// and `WithCacheControl` overrides it for a single write.
+func DefaultCacheControl(cacheControl string) Option {
+	return optionFunc(func(config *config) {
+		config.cacheControl = cacheControl
+	})
+}
+
+// CredentialsFile defines the credentials file to authenticate with instead
+// of the environment's default credentials. It's a service account JSON key
+// file for Google Storage, a shared credentials file for S3 and an OCI
+// configuration file for OCI, other stores ignore it.
+func CredentialsFile(path string) Option {
+	return optionFunc(func(config *config) {
+		config.credentialsFile = path
+	})
+}
+
+// MultipartThreshold defines the size in bytes above which objects are
+// uploaded through multipart uploads, in parts of that same size. Only the S3,
+// B2, Swift and OCI stores use it, S3 defaulting to 5MiB which is also the
+// minimum, B2 to the account's recommended part size, Swift to 100MiB and OCI
+// to 10MiB. As S3, B2 and OCI allow at most 10000 parts, streamed writes of
+// unknown size are limited to 10000 times that threshold there.
+func MultipartThreshold(size int64) Option {
+	return optionFunc(func(config *config) {
+		config.multipartThreshold = size
+	})
+}
+
+// HTTPClient makes the Google Storage, S3 and Azure stores send their requests
+// with `client`, whose transport tunes the connection pool, dial timeouts or
+// proxy, for example an `http.Transport` with a higher `MaxIdleConnsPerHost`
+// for processes running many concurrent operations. The Google Storage store
+// wraps its transport to authenticate the requests. Sub stores share it.
+func HTTPClient(client *http.Client) Option {
+	return optionFunc(func(config *config) {
+		config.httpClient = client
+	})
+}
+
+// HTTPVersion forces the HTTP protocol of the Google Storage, S3 and Azure
+// stores, 1 sending requests with HTTP/1.1 only, which works around HTTP/2
+// stream errors under heavy load, and 2 attempting HTTP/2 even with a custom
+// dialer or TLS configuration. It tunes a copy of the transport of the
+// `HTTPClient` option, or of the default transport, which must be an
+// `*http.Transport`.
+func HTTPVersion(version int) Option {
+	return optionFunc(func(config *config) {
+		config.httpVersion = version
+	})
+}
+
+// IdleConnTimeout closes the idle connections of the Google Storage, S3 and
+// Azure stores after `timeout`, tuning the transport like `HTTPVersion`.
+func IdleConnTimeout(timeout time.Duration) Option {
+	return optionFunc(func(config *config) {
+		config.idleConnTimeout = timeout
+	})
+}
+
+// GSClient makes the Google Storage store use `client` instead of creating
+// one, the `CredentialsFile`, `HTTPClient`, `HTTPVersion` and
+// `IdleConnTimeout` options being ignored. Without it, the stores share a
+// client per credentials file and HTTP client, and sub stores the client of
+// their parent, closed once all the stores using it are closed.
+func GSClient(client *storage.Client) Option {
+	return optionFunc(func(config *config) {
+		config.gsClient = client
+	})
+}
+
+// CopyBufferSize sets the size of the buffer through which the content of the
+// writes is copied to the compressor and the backend. It defaults to 1MiB for
+// the Google Storage, S3, Azure, B2, Swift and OCI stores, bigger writes
+// uploading big objects measurably faster, and to 32KiB for the others.
+func CopyBufferSize(size int) Option {
+	return optionFunc(func(config *config) {
+		config.copyBufferSize = size
+	})
+}
+
+// PassthroughCompressed makes the writes whose content is already compressed
+// in the compression of the store, like existing `.zst` files pushed to a zstd
+// store, store it as-is instead of compressing it again. The content is
+// recognized by its magic bytes, so content meant to be compressed twice must
+// not be written to stores with this option. Seekable zstd stores always
+// compress the content, a plain zstd stream not being seekable.
+func PassthroughCompressed() Option {
+	return optionFunc(func(config *config) {
+		config.passthroughCompressed = true
+	})
+}
+
+// SyncWrites makes the local store flush the written files and their directory
+// to disk before `WriteObject` and `AppendObject` return, so that the written
+// objects survive a power loss. Without it, the default fast mode leaves them
+// in the page cache of the operating system. The `sync=true` query parameter
+// of the store URL enables it too.
+func SyncWrites() Option {
+	return optionFunc(func(config *config) {
+		config.syncWrites = true
+	})
+}
+
+// ParallelCompositeUpload makes the Google Storage store split the written
+// objects in parts of `partSize` bytes, up to `concurrency` of them being
+// uploaded concurrently as temporary objects which are then composed into the
+// object and deleted, 4 when `concurrency` is zero. Each part in flight is
+// buffered in memory, and objects fitting in a single part are written
+// directly. As Google Storage composes at most 1024 components into an object,
+// writes are limited to 1024 times `partSize`. It is ignored with
+// `UploadChecksums`, the checksums being those of the whole content.
+func ParallelCompositeUpload(partSize int64, concurrency int) Option {
+	return optionFunc(func(config *config) {
+		config.compositePartSize = partSize
+		config.compositeConcurrency = concurrency
+	})
+}
+
+// ReadAhead makes the readers returned by `OpenObject` read the object ahead
+// of the consumer in a goroutine, filling up to `chunks` buffers of
+// `chunkSize` bytes of decompressed content, 2 when `chunks` is zero. It
+// improves the throughput of consumers processing the content slower than the
+// network delivers it, at the cost of the buffers' memory. The `read_ahead`
+// query parameter of the store URL sets the chunk size too, with 2 chunks.
+func ReadAhead(chunkSize, chunks int) Option {
+	return optionFunc(func(config *config) {
+		config.readAheadSize = chunkSize
+		config.readAheadChunks = chunks
+	})
+}
+
+// ResumeReads makes the readers returned by `OpenObject` reopen the object at
+// the offset reached when reading it fails mid-stream with an error accepted
+// by `IsRetryableError`, like a connection reset, up to `attempts` times in a
+// row with an exponential backoff, instead of failing the whole read. The
+// `read_resumes` query parameter of the store URL sets it too.
+func ResumeReads(attempts int) Option {
+	return optionFunc(func(config *config) {
+		config.readResumes = attempts
+	})
+}
+
+// ReadBandwidth limits the bytes read from objects to `bytesPerSecond` across
+// all the reads of the store and its sub stores, counting the bytes as stored,
+// so compressed when the store uses compression. The `read_bandwidth` query
+// parameter of the store URL sets it too.
+func ReadBandwidth(bytesPerSecond int64) Option {
+	return optionFunc(func(config *config) {
+		config.readBandwidth = bytesPerSecond
+	})
+}
+
+// WriteBandwidth limits the bytes written to objects to `bytesPerSecond`
+// across all the writes of the store and its sub stores, counting the bytes as
+// stored, so compressed when the store uses compression. The `write_bandwidth`
+// query parameter of the store URL sets it too.
+func WriteBandwidth(bytesPerSecond int64) Option {
+	return optionFunc(func(config *config) {
+		config.writeBandwidth = bytesPerSecond
+	})
+}
+
+// ObjectLock makes the S3 store send the `Content-MD5` header with every
+// upload request, as required by buckets with Object Lock enabled, even when
+// checksums are disabled through the `disable_checksums` query parameter. The
+// content is spooled to a temporary file to compute it before uploading. The
+// `object_lock=true` query parameter of the store URL enables it too.
+func ObjectLock() Option {
+	return optionFunc(func(config *config) {
+		config.objectLock = true
+	})
+}
+
+// Logger makes the store log to `logger` instead of the package logger, with
+// its level deciding of the debug messages logged. Sub stores and the wrapper
+// stores around the store log to it too.
+func Logger(logger *zap.Logger) Option {
+	return optionFunc(func(config *config) {
+		config.logger = logger
+	})
+}
+
+// OperationTimeout fails each operation of the stores created by
+// `NewStoreWithOptions`, `NewStoreFromURL` and `OpenObject` when it takes
+// longer than `timeout`, the returned store running them through
+// `TimeoutOperations`. Opened objects must be read and closed within it. The
+// constructors of each backend ignore it, their store being wrapped with
+// `NewInterceptedStore(store, TimeoutOperations(timeout))` instead.
+func OperationTimeout(timeout time.Duration) Option {
+	return optionFunc(func(config *config) {
+		config.operationTimeout = timeout
+	})
+}
+
 // NewStoreFromURL is similar from `NewStore` but infer the store URL path from the URL directly
 // extracting the filename along the way. The store's path is always the directory containing the file
 // itself.
@@ -145,12 +714,7 @@ func NewStoreFromURL(fileURL string, opts ...Option) (store Store, filename stri
 		storeURL = url.String()
 	}
 
-	config := config{}
-	for _, opt := range opts {
-		opt.apply(&config)
-	}
-
-	store, err = NewStore(storeURL, "", config.compression, config.overwrite)
+	store, err = NewStoreWithOptions(storeURL, opts...)
 	if err != nil {
 		return nil, filename, fmt.Errorf("open store: %w", err)
 	}
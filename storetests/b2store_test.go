@@ -0,0 +1,69 @@
+//go:build !dstore_no_b2
+
+package storetests
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// Requires the `B2_APPLICATION_KEY_ID` and `B2_APPLICATION_KEY` environment
+// variables to be set too, for example:
+//	STORETESTS_B2_STORE_URL="b2://streamingfast-store-tests/store-tests"
+var b2storeBaseURL = os.Getenv("STORETESTS_B2_STORE_URL")
+
+func TestB2Store(t *testing.T) {
+	if b2storeBaseURL == "" {
+		t.Skip("You must provide a valid B2 Bucket via STORETESTS_B2_STORE_URL environment variable to execute those tests")
+		return
+	}
+
+	TestAll(t, createB2StoreFactory(t, "", false))
+}
+
+func TestB2Store_Overwrite(t *testing.T) {
+	if b2storeBaseURL == "" {
+		t.Skip("You must provide a valid B2 Bucket via STORETESTS_B2_STORE_URL environment variable to execute those tests")
+		return
+	}
+
+	TestAll(t, createB2StoreFactory(t, "", true))
+}
+
+func createB2StoreFactory(t *testing.T, compression string, overwrite bool) StoreFactory {
+	random := rand.NewSource(time.Now().UnixNano())
+
+	return func() (dstore.Store, StoreCleanup) {
+		testPath := fmt.Sprintf("dstore-b2store-tests-%08x", random.Int63())
+		fullPath := b2storeBaseURL
+		if !strings.HasSuffix(fullPath, "/") {
+			fullPath += "/"
+		}
+
+		storeURL, err := url.Parse(fullPath + testPath)
+		require.NoError(t, err)
+
+		zlog.Debug("creating a new b2store for test", zap.Stringer("url", storeURL))
+		store, err := dstore.NewB2Store(storeURL, "", compression, overwrite)
+		require.NoError(t, err)
+
+		return store, func() {
+			if noCleanup {
+				return
+			}
+
+			_, err := store.DeletePrefix(context.Background(), "")
+			require.NoError(t, err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package storetests
+
+import (
+	"testing"
+
+	"github.com/streamingfast/dstore"
+)
+
+func TestCircuitBreakerStore(t *testing.T) {
+	TestAll(t, createCircuitBreakerStoreFactory())
+}
+
+func TestCircuitBreakerStoreOverwrite(t *testing.T) {
+	TestAll(t, createCircuitBreakerStoreFactory(dstore.AllowOverwrite()))
+}
+
+func createCircuitBreakerStoreFactory(opts ...dstore.Option) StoreFactory {
+	return func() (dstore.Store, StoreCleanup) {
+		return dstore.NewCircuitBreakerStore(dstore.NewMemoryStore(opts...), dstore.CircuitBreakerPolicy{}), func() {
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package storetests
+
+import (
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedStore(t *testing.T) {
+	TestAll(t, createChunkedStoreFactory(t))
+}
+
+func TestChunkedStoreOverwrite(t *testing.T) {
+	TestAll(t, createChunkedStoreFactory(t, dstore.AllowOverwrite()))
+}
+
+func TestChunkedStoreCompressedZst(t *testing.T) {
+	TestAll(t, createChunkedStoreFactory(t, dstore.Compression("zstd")))
+}
+
+func createChunkedStoreFactory(t *testing.T, opts ...dstore.Option) StoreFactory {
+	return func() (dstore.Store, StoreCleanup) {
+		store, err := dstore.NewChunkedStore(dstore.NewMemoryStore(opts...), dstore.ChunkPolicy{ChunkSize: 8})
+		require.NoError(t, err)
+
+		return store, func() {
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package storetests
+
+import (
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var copyObjectTests = []StoreTestFunc{
+	TestCopyObject,
+	TestCopyObject_ErrNotFound,
+	TestRenameObject,
+	TestRenameObject_ErrNotFound,
+}
+
+func TestCopyObject(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	addFileToStore(t, store, "0000/src", "c1")
+
+	require.NoError(t, store.CopyObject(ctx, "0000/src", "0001/dst"))
+
+	rd, err := store.OpenObject(ctx, "0001/dst")
+	require.NoError(t, err)
+	assert.Equal(t, "c1", readObjectAndClose(t, rd))
+
+	rd, err = store.OpenObject(ctx, "0000/src")
+	require.NoError(t, err)
+	assert.Equal(t, "c1", readObjectAndClose(t, rd))
+}
+
+func TestCopyObject_ErrNotFound(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	err := store.CopyObject(ctx, "anything_that_does_not_exist", "dst")
+	assert.Equal(t, dstore.ErrNotFound, err)
+}
+
+func TestRenameObject(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	addFileToStore(t, store, "0000/file.tmp", "c1")
+
+	require.NoError(t, store.RenameObject(ctx, "0000/file.tmp", "0000/file"))
+
+	exists, err := store.FileExists(ctx, "0000/file.tmp")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	rd, err := store.OpenObject(ctx, "0000/file")
+	require.NoError(t, err)
+	assert.Equal(t, "c1", readObjectAndClose(t, rd))
+}
+
+func TestRenameObject_ErrNotFound(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	err := store.RenameObject(ctx, "anything_that_does_not_exist", "dst")
+	assert.Equal(t, dstore.ErrNotFound, err)
+}
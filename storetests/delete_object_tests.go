@@ -0,0 +1,47 @@
+package storetests
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var deleteObjectTests = []StoreTestFunc{
+	TestDeleteObjects,
+	TestDeletePrefix,
+}
+
+func TestDeleteObjects(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	for _, f := range []string{"0000/0001", "0000/0002", "0000/0003"} {
+		addFileToStore(t, store, f, f)
+	}
+
+	err := store.DeleteObjects(ctx, []string{"0000/0001", "0000/0003", "anything_that_does_not_exist"})
+	require.NoError(t, err)
+
+	files, err := store.ListFiles(ctx, "", math.MaxInt64)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0000/0002"}, files)
+}
+
+func TestDeletePrefix(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	for _, f := range []string{"0000/0001", "0000/0002", "0001/0001"} {
+		addFileToStore(t, store, f, f)
+	}
+
+	deleted, err := store.DeletePrefix(ctx, "0000/")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	files, err := store.ListFiles(ctx, "", math.MaxInt64)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0001/0001"}, files)
+}
@@ -0,0 +1,25 @@
+package storetests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	TestAll(t, createEncryptedStoreFactory(t))
+}
+
+func TestEncryptedStoreOverwrite(t *testing.T) {
+	TestAll(t, createEncryptedStoreFactory(t, dstore.AllowOverwrite()))
+}
+
+func createEncryptedStoreFactory(t *testing.T, opts ...dstore.Option) StoreFactory {
+	keys := dstore.NewStaticKeyProvider("key", map[string][]byte{"key": bytes.Repeat([]byte{1}, 32)})
+
+	return func() (dstore.Store, StoreCleanup) {
+		return dstore.NewEncryptedStore(dstore.NewMemoryStore(opts...), keys), func() {
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package storetests
+
+import (
+	"testing"
+
+	"github.com/streamingfast/dstore"
+)
+
+func TestFallbackStore(t *testing.T) {
+	TestAll(t, createFallbackStoreFactory(t, ""))
+}
+
+func TestFallbackStoreCompressedZst(t *testing.T) {
+	TestAll(t, createFallbackStoreFactory(t, "zstd"))
+}
+
+func createFallbackStoreFactory(t *testing.T, compression string) StoreFactory {
+	return func() (dstore.Store, StoreCleanup) {
+		return dstore.NewFallbackStore(dstore.NewMemoryStore(dstore.Compression(compression)), dstore.NewMemoryStore()), func() {
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !dstore_no_ftp
+
+package storetests
+
+import (
+	"reflect"
+
+	"github.com/streamingfast/dstore"
+)
+
+func init() {
+	backendConcurrentWrites[reflect.TypeOf(&dstore.FTPStore{})] = false
+}
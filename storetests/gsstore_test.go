@@ -39,7 +39,16 @@ func TestGSStore_Overwrite(t *testing.T) {
 	TestAll(t, createGSStoreFactory(t, gsstoreBaseURL, "", true))
 }
 
-func createGSStoreFactory(t *testing.T, directory string, compression string, overwrite bool) StoreFactory {
+func TestGSStore_ParallelCompositeUpload(t *testing.T) {
+	if gsstoreBaseURL == "" {
+		t.Skip("You must provide a valid Google Storage Bucket via STORETESTS_GS_STORE_URL environment variable to execute those tests")
+		return
+	}
+
+	TestAll(t, createGSStoreFactory(t, gsstoreBaseURL, "", false, dstore.ParallelCompositeUpload(64, 4)))
+}
+
+func createGSStoreFactory(t *testing.T, directory string, compression string, overwrite bool, opts ...dstore.Option) StoreFactory {
 	random := rand.NewSource(time.Now().UnixNano())
 
 	return func() (dstore.Store, StoreCleanup) {
@@ -53,7 +62,11 @@ func createGSStoreFactory(t *testing.T, directory string, compression string, ov
 		require.NoError(t, err)
 
 		zlog.Debug("creating a new gsstore for test", zap.Stringer("url", storeURL), zap.String("host", storeURL.Host), zap.String("path", storeURL.Path))
-		store, err := dstore.NewGSStore(storeURL, "", compression, overwrite)
+		storeOpts := append([]dstore.Option{dstore.Compression(compression)}, opts...)
+		if overwrite {
+			storeOpts = append(storeOpts, dstore.AllowOverwrite())
+		}
+		store, err := dstore.NewGSStoreWithOptions(storeURL, storeOpts...)
 		require.NoError(t, err)
 
 		client, err := storage.NewClient(context.Background())
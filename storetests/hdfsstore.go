@@ -0,0 +1,13 @@
+//go:build !dstore_no_hdfs
+
+package storetests
+
+import (
+	"reflect"
+
+	"github.com/streamingfast/dstore"
+)
+
+func init() {
+	backendConcurrentWrites[reflect.TypeOf(&dstore.HDFSStore{})] = false
+}
@@ -0,0 +1,77 @@
+//go:build !dstore_no_hdfs
+
+package storetests
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// Requires a reachable namenode, for example:
+//	STORETESTS_HDFS_STORE_URL="hdfs://localhost:8020/store-tests"
+var hdfsstoreBaseURL = os.Getenv("STORETESTS_HDFS_STORE_URL")
+
+func TestHDFSStore(t *testing.T) {
+	if hdfsstoreBaseURL == "" {
+		t.Skip("You must provide a valid HDFS path via STORETESTS_HDFS_STORE_URL environment variable to execute those tests")
+		return
+	}
+
+	TestAll(t, createHDFSStoreFactory(t, "", false))
+}
+
+func TestHDFSStore_Overwrite(t *testing.T) {
+	if hdfsstoreBaseURL == "" {
+		t.Skip("You must provide a valid HDFS path via STORETESTS_HDFS_STORE_URL environment variable to execute those tests")
+		return
+	}
+
+	TestAll(t, createHDFSStoreFactory(t, "", true))
+}
+
+func TestHDFSStoreCompressedZst(t *testing.T) {
+	if hdfsstoreBaseURL == "" {
+		t.Skip("You must provide a valid HDFS path via STORETESTS_HDFS_STORE_URL environment variable to execute those tests")
+		return
+	}
+
+	TestAll(t, createHDFSStoreFactory(t, "zstd", false))
+}
+
+func createHDFSStoreFactory(t *testing.T, compression string, overwrite bool) StoreFactory {
+	random := rand.NewSource(time.Now().UnixNano())
+
+	return func() (dstore.Store, StoreCleanup) {
+		testPath := fmt.Sprintf("dstore-hdfsstore-tests-%08x", random.Int63())
+		fullPath := hdfsstoreBaseURL
+		if !strings.HasSuffix(fullPath, "/") {
+			fullPath += "/"
+		}
+
+		storeURL, err := url.Parse(fullPath + testPath)
+		require.NoError(t, err)
+
+		zlog.Debug("creating a new hdfsstore for test", zap.Stringer("url", storeURL))
+		store, err := dstore.NewHDFSStore(storeURL, "", compression, overwrite)
+		require.NoError(t, err)
+
+		return store, func() {
+			if noCleanup {
+				return
+			}
+
+			_, err := store.DeletePrefix(context.Background(), "")
+			require.NoError(t, err)
+		}
+	}
+}
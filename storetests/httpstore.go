@@ -0,0 +1,13 @@
+//go:build !dstore_no_http
+
+package storetests
+
+import (
+	"reflect"
+
+	"github.com/streamingfast/dstore"
+)
+
+func init() {
+	backendConcurrentWrites[reflect.TypeOf(&dstore.HTTPStore{})] = false
+}
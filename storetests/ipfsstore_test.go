@@ -0,0 +1,78 @@
+//go:build !dstore_no_ipfs
+
+package storetests
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// Requires a running IPFS node, reached through `IPFS_API_URL` or on its
+// default local address, for example:
+//	STORETESTS_IPFS_STORE_URL="ipfs:///store-tests"
+var ipfsstoreBaseURL = os.Getenv("STORETESTS_IPFS_STORE_URL")
+
+func TestIPFSStore(t *testing.T) {
+	if ipfsstoreBaseURL == "" {
+		t.Skip("You must provide a valid IPFS MFS path via STORETESTS_IPFS_STORE_URL environment variable to execute those tests")
+		return
+	}
+
+	TestAll(t, createIPFSStoreFactory(t, "", false))
+}
+
+func TestIPFSStore_Overwrite(t *testing.T) {
+	if ipfsstoreBaseURL == "" {
+		t.Skip("You must provide a valid IPFS MFS path via STORETESTS_IPFS_STORE_URL environment variable to execute those tests")
+		return
+	}
+
+	TestAll(t, createIPFSStoreFactory(t, "", true))
+}
+
+func TestIPFSStoreCompressedZst(t *testing.T) {
+	if ipfsstoreBaseURL == "" {
+		t.Skip("You must provide a valid IPFS MFS path via STORETESTS_IPFS_STORE_URL environment variable to execute those tests")
+		return
+	}
+
+	TestAll(t, createIPFSStoreFactory(t, "zstd", false))
+}
+
+func createIPFSStoreFactory(t *testing.T, compression string, overwrite bool) StoreFactory {
+	random := rand.NewSource(time.Now().UnixNano())
+
+	return func() (dstore.Store, StoreCleanup) {
+		testPath := fmt.Sprintf("dstore-ipfsstore-tests-%08x", random.Int63())
+		fullPath := ipfsstoreBaseURL
+		if !strings.HasSuffix(fullPath, "/") {
+			fullPath += "/"
+		}
+
+		storeURL, err := url.Parse(fullPath + testPath)
+		require.NoError(t, err)
+
+		zlog.Debug("creating a new ipfsstore for test", zap.Stringer("url", storeURL))
+		store, err := dstore.NewIPFSStore(storeURL, "", compression, overwrite)
+		require.NoError(t, err)
+
+		return store, func() {
+			if noCleanup {
+				return
+			}
+
+			_, err := store.DeletePrefix(context.Background(), "")
+			require.NoError(t, err)
+		}
+	}
+}
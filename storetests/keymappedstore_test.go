@@ -0,0 +1,29 @@
+package storetests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+)
+
+func TestKeyMappedStore(t *testing.T) {
+	TestAll(t, createKeyMappedStoreFactory(dstore.PrefixKeyMapping("mapped")))
+}
+
+func TestKeyMappedStoreHashFanOut(t *testing.T) {
+	TestAll(t, createKeyMappedStoreFactory(dstore.HashFanOutKeyMapping(2, 2)))
+}
+
+func TestKeyMappedStoreDate(t *testing.T) {
+	TestAll(t, createKeyMappedStoreFactory(dstore.DateKeyMapping("2006/01", func(name string) time.Time {
+		return time.Unix(int64(len(name))*86400*31, 0)
+	})))
+}
+
+func createKeyMappedStoreFactory(mapping dstore.KeyMapping, opts ...dstore.Option) StoreFactory {
+	return func() (dstore.Store, StoreCleanup) {
+		return dstore.NewKeyMappedStore(dstore.NewMemoryStore(opts...), mapping), func() {
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package storetests
+
+import (
+	"testing"
+
+	"github.com/streamingfast/dstore"
+)
+
+func TestMemoryStore(t *testing.T) {
+	TestAll(t, createMemoryStoreFactory(t, ""))
+}
+
+func TestMemoryStoreCompressedZst(t *testing.T) {
+	TestAll(t, createMemoryStoreFactory(t, "zstd"))
+}
+
+func TestMemoryStoreSeekableZst(t *testing.T) {
+	TestAll(t, createMemoryStoreFactory(t, "zstd", dstore.SeekableZstd(16)))
+}
+
+func TestMemoryStoreOverwrite(t *testing.T) {
+	TestAll(t, createMemoryStoreFactory(t, "", dstore.AllowOverwrite()))
+}
+
+func createMemoryStoreFactory(t *testing.T, compression string, opts ...dstore.Option) StoreFactory {
+	return func() (dstore.Store, StoreCleanup) {
+		return dstore.NewMemoryStore(append(opts, dstore.Compression(compression))...), func() {
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package storetests
+
+import (
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorStore(t *testing.T) {
+	TestAll(t, createMirrorStoreFactory(t, dstore.MirrorPolicy{}, ""))
+}
+
+func TestMirrorStoreBestEffort(t *testing.T) {
+	TestAll(t, createMirrorStoreFactory(t, dstore.MirrorPolicy{BestEffort: true}, ""))
+}
+
+func TestMirrorStoreCompressedZst(t *testing.T) {
+	TestAll(t, createMirrorStoreFactory(t, dstore.MirrorPolicy{}, "zstd"))
+}
+
+func TestMirrorStoreOverwrite(t *testing.T) {
+	TestAll(t, createMirrorStoreFactory(t, dstore.MirrorPolicy{}, "", dstore.AllowOverwrite()))
+}
+
+func createMirrorStoreFactory(t *testing.T, policy dstore.MirrorPolicy, compression string, opts ...dstore.Option) StoreFactory {
+	return func() (dstore.Store, StoreCleanup) {
+		opts := append(opts, dstore.Compression(compression))
+		store, err := dstore.NewMirrorStore([]dstore.Store{dstore.NewMemoryStore(opts...), dstore.NewMemoryStore(opts...)}, policy)
+		require.NoError(t, err)
+
+		return store, func() {
+			require.NoError(t, store.Close())
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package storetests
+
+import (
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var objectAttributesTests = []StoreTestFunc{
+	TestObjectAttributes,
+	TestObjectAttributes_ErrNotFound,
+}
+
+func TestObjectAttributes(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	addFileToStore(t, store, "0000/0001", "c1")
+
+	attrs, err := store.ObjectAttributes(ctx, "0000/0001")
+	require.NoError(t, err)
+	assert.Equal(t, "0000/0001", attrs.Name)
+	assert.True(t, attrs.Size > 0, "expecting a non-zero size, got %d", attrs.Size)
+}
+
+func TestObjectAttributes_ErrNotFound(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	attrs, err := store.ObjectAttributes(ctx, "anything_that_does_not_exist")
+	assert.Nil(t, attrs)
+	assert.Equal(t, dstore.ErrNotFound, err)
+}
@@ -0,0 +1,79 @@
+//go:build !dstore_no_oci
+
+package storetests
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// Requires a bucket and the OCI configuration file, for example:
+//
+//	STORETESTS_OCI_STORE_URL="oci://namespace/store-tests?region=us-ashburn-1"
+var ocistoreBaseURL = os.Getenv("STORETESTS_OCI_STORE_URL")
+
+func TestOCIStore(t *testing.T) {
+	if ocistoreBaseURL == "" {
+		t.Skip("You must provide a valid OCI bucket via STORETESTS_OCI_STORE_URL environment variable to execute those tests")
+		return
+	}
+
+	TestAll(t, createOCIStoreFactory(t, "", false))
+}
+
+func TestOCIStore_Overwrite(t *testing.T) {
+	if ocistoreBaseURL == "" {
+		t.Skip("You must provide a valid OCI bucket via STORETESTS_OCI_STORE_URL environment variable to execute those tests")
+		return
+	}
+
+	TestAll(t, createOCIStoreFactory(t, "", true))
+}
+
+func TestOCIStoreCompressedZst(t *testing.T) {
+	if ocistoreBaseURL == "" {
+		t.Skip("You must provide a valid OCI bucket via STORETESTS_OCI_STORE_URL environment variable to execute those tests")
+		return
+	}
+
+	TestAll(t, createOCIStoreFactory(t, "zstd", false))
+}
+
+func createOCIStoreFactory(t *testing.T, compression string, overwrite bool) StoreFactory {
+	random := rand.NewSource(time.Now().UnixNano())
+
+	return func() (dstore.Store, StoreCleanup) {
+		storeURL, err := url.Parse(ocistoreBaseURL)
+		require.NoError(t, err)
+
+		testPath := fmt.Sprintf("dstore-ocistore-tests-%08x", random.Int63())
+		fullPath := storeURL.Path
+		if !strings.HasSuffix(fullPath, "/") {
+			fullPath += "/"
+		}
+		storeURL.Path = fullPath + testPath
+
+		zlog.Debug("creating a new ocistore for test", zap.Stringer("url", storeURL))
+		store, err := dstore.NewOCIStore(storeURL, "", compression, overwrite)
+		require.NoError(t, err)
+
+		return store, func() {
+			if noCleanup {
+				return
+			}
+
+			_, err := store.DeletePrefix(context.Background(), "")
+			require.NoError(t, err)
+		}
+	}
+}
@@ -5,10 +5,12 @@ import (
 
 	"github.com/streamingfast/dstore"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var openObjectTests = []StoreTestFunc{
 	TestOpenObject_ReadSameFileMultipleTimes,
+	TestOpenObjectRange,
 }
 
 func TestOpenObject_ErrNotFound(t *testing.T, factory StoreFactory) {
@@ -34,3 +36,31 @@ func TestOpenObject_ReadSameFileMultipleTimes(t *testing.T, factory StoreFactory
 	assert.NoError(t, err)
 	assert.Equal(t, "c1", readObjectAndClose(t, rd))
 }
+
+func TestOpenObjectRange(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	addFileToStore(t, store, "file", "0123456789")
+
+	tests := []struct {
+		name     string
+		offset   int64
+		length   int64
+		expected string
+	}{
+		{"start", 0, 3, "012"},
+		{"middle", 2, 3, "234"},
+		{"up to end", 5, -1, "56789"},
+		{"length past end", 8, 10, "89"},
+		{"empty", 4, 0, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rd, err := store.OpenObjectRange(ctx, "file", test.offset, test.length)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, readObjectAndClose(t, rd))
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package storetests
+
+import (
+	"testing"
+
+	"github.com/streamingfast/dstore"
+)
+
+func TestRateLimitedStore(t *testing.T) {
+	TestAll(t, createRateLimitedStoreFactory())
+}
+
+func TestRateLimitedStoreOverwrite(t *testing.T) {
+	TestAll(t, createRateLimitedStoreFactory(dstore.AllowOverwrite()))
+}
+
+func createRateLimitedStoreFactory(opts ...dstore.Option) StoreFactory {
+	policy := dstore.RateLimitPolicy{
+		ReadOpsPerSecond:    10000,
+		ReadBytesPerSecond:  10 * 1024 * 1024,
+		WriteOpsPerSecond:   10000,
+		WriteBytesPerSecond: 10 * 1024 * 1024,
+	}
+
+	return func() (dstore.Store, StoreCleanup) {
+		return dstore.NewRateLimitedStore(dstore.NewMemoryStore(opts...), policy), func() {
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package storetests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+)
+
+func TestRetryingStore(t *testing.T) {
+	TestAll(t, createRetryingStoreFactory())
+}
+
+func TestRetryingStoreOverwrite(t *testing.T) {
+	TestAll(t, createRetryingStoreFactory(dstore.AllowOverwrite()))
+}
+
+func createRetryingStoreFactory(opts ...dstore.Option) StoreFactory {
+	policy := dstore.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	return func() (dstore.Store, StoreCleanup) {
+		return dstore.NewRetryingStore(dstore.NewMemoryStore(opts...), policy), func() {
+		}
+	}
+}
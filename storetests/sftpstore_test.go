@@ -0,0 +1,50 @@
+//go:build !dstore_no_sftp
+
+package storetests
+
+import (
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSFTPStore(t *testing.T) {
+	TestAll(t, createSFTPStoreFactory(t, ""))
+}
+
+func TestSFTPStoreCompressedZst(t *testing.T) {
+	TestAll(t, createSFTPStoreFactory(t, "zstd"))
+}
+
+// createSFTPStoreFactory serves a temporary directory through an in-process
+// SFTP server connected to the client with pipes.
+func createSFTPStoreFactory(t *testing.T, compression string) StoreFactory {
+	return func() (dstore.Store, StoreCleanup) {
+		dir := t.TempDir()
+
+		serverReader, clientWriter := io.Pipe()
+		clientReader, serverWriter := io.Pipe()
+
+		server, err := sftp.NewServer(struct {
+			io.Reader
+			io.WriteCloser
+		}{serverReader, serverWriter})
+		require.NoError(t, err)
+		go server.Serve()
+
+		client, err := sftp.NewClientPipe(clientReader, clientWriter)
+		require.NoError(t, err)
+
+		store, err := dstore.NewSFTPStoreFromClient(&url.URL{Scheme: "sftp", User: url.User("test"), Host: "localhost", Path: dir}, client, dstore.Compression(compression))
+		require.NoError(t, err)
+
+		return store, func() {
+			server.Close()
+			client.Close()
+		}
+	}
+}
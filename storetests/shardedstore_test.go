@@ -0,0 +1,31 @@
+package storetests
+
+import (
+	"testing"
+
+	"github.com/streamingfast/dstore"
+)
+
+func TestShardedStore(t *testing.T) {
+	TestAll(t, createShardedStoreFactory(t))
+}
+
+func TestShardedStoreOverwrite(t *testing.T) {
+	TestAll(t, createShardedStoreFactory(t, dstore.AllowOverwrite()))
+}
+
+func createShardedStoreFactory(t *testing.T, opts ...dstore.Option) StoreFactory {
+	return func() (dstore.Store, StoreCleanup) {
+		store, err := dstore.NewShardedStore([]dstore.Store{
+			dstore.NewMemoryStore(opts...),
+			dstore.NewMemoryStore(opts...),
+			dstore.NewMemoryStore(opts...),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return store, func() {
+		}
+	}
+}
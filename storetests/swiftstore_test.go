@@ -0,0 +1,43 @@
+//go:build !dstore_no_swift
+
+package storetests
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/ncw/swift/v2"
+	"github.com/ncw/swift/v2/swifttest"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwiftStore(t *testing.T) {
+	TestAll(t, createSwiftStoreFactory(t, ""))
+}
+
+func TestSwiftStoreCompressedZst(t *testing.T) {
+	TestAll(t, createSwiftStoreFactory(t, "zstd"))
+}
+
+// createSwiftStoreFactory runs the stores against the in-memory Swift server of
+// the Swift client library.
+func createSwiftStoreFactory(t *testing.T, compression string) StoreFactory {
+	return func() (dstore.Store, StoreCleanup) {
+		server, err := swifttest.NewSwiftServer("localhost")
+		require.NoError(t, err)
+
+		conn := &swift.Connection{
+			UserName: swifttest.TEST_ACCOUNT,
+			ApiKey:   swifttest.TEST_ACCOUNT,
+			AuthUrl:  server.AuthURL,
+		}
+		require.NoError(t, conn.ContainerCreate(context.Background(), "store-tests", nil))
+
+		store, err := dstore.NewSwiftStoreFromConnection(&url.URL{Scheme: "swift", Host: "store-tests", Path: "/path"}, conn, dstore.Compression(compression))
+		require.NoError(t, err)
+
+		return store, server.Close
+	}
+}
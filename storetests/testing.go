@@ -23,6 +23,9 @@ var noCleanup = os.Getenv("STORETESTS_NO_CLEANUP") == "true"
 func TestAll(t *testing.T, factory StoreFactory) {
 	all := [][]StoreTestFunc{
 		fileExistsTests,
+		objectAttributesTests,
+		copyObjectTests,
+		deleteObjectTests,
 		openObjectTests,
 		walkTests,
 		writeObjectTests,
@@ -77,12 +80,40 @@ func readObjectAndClose(t *testing.T, o io.ReadCloser) string {
 }
 
 func supportsConcurrentWrites(store dstore.Store) bool {
-	switch store.(type) {
-	case *dstore.GSStore, *dstore.S3Store, *dstore.AzureStore:
+	switch s := store.(type) {
+	case *dstore.GSStore, *dstore.S3Store, *dstore.AzureStore, *dstore.MemoryStore:
 		return true
+	case *dstore.TieredStore:
+		return supportsConcurrentWrites(s.Store)
+	case *dstore.MirrorStore:
+		return supportsConcurrentWrites(s.Store)
+	case *dstore.FallbackStore:
+		return supportsConcurrentWrites(s.Store)
+	case *dstore.EncryptedStore:
+		return supportsConcurrentWrites(s.Store)
+	case *dstore.KeyMappedStore:
+		return supportsConcurrentWrites(s.Store)
+	case *dstore.ShardedStore:
+		return supportsConcurrentWrites(s.Store)
+	case *dstore.RateLimitedStore:
+		return supportsConcurrentWrites(s.Store)
+	case *dstore.ChunkedStore:
+		return supportsConcurrentWrites(s.Store)
+	case *dstore.RetryingStore:
+		return supportsConcurrentWrites(s.Store)
+	case *dstore.CircuitBreakerStore:
+		return supportsConcurrentWrites(s.Store)
 	case *dstore.LocalStore, *dstore.MockStore:
 		return false
 	}
 
+	if concurrent, found := backendConcurrentWrites[reflect.TypeOf(store)]; found {
+		return concurrent
+	}
+
 	panic(fmt.Errorf("unknown store type %T regarding support for concurrent writes", store))
 }
+
+// backendConcurrentWrites tells whether the stores of the optional backends
+// support concurrent writes, each backend adding its store when compiled in.
+var backendConcurrentWrites = map[reflect.Type]bool{}
@@ -0,0 +1,31 @@
+package storetests
+
+import (
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieredStore(t *testing.T) {
+	TestAll(t, createTieredStoreFactory(t, ""))
+}
+
+func TestTieredStoreCompressedZst(t *testing.T) {
+	TestAll(t, createTieredStoreFactory(t, "zstd"))
+}
+
+func TestTieredStoreOverwrite(t *testing.T) {
+	TestAll(t, createTieredStoreFactory(t, "", dstore.AllowOverwrite()))
+}
+
+func createTieredStoreFactory(t *testing.T, compression string, opts ...dstore.Option) StoreFactory {
+	return func() (dstore.Store, StoreCleanup) {
+		// A small hot tier, so that evictions happen along the tests
+		store, err := dstore.NewTieredStore(dstore.NewMemoryStore(), dstore.NewMemoryStore(append(opts, dstore.Compression(compression))...), dstore.TieredPolicy{MaxSize: 64})
+		require.NoError(t, err)
+
+		return store, func() {
+		}
+	}
+}
@@ -5,24 +5,31 @@ import (
 	"math"
 	"testing"
 
+	"github.com/streamingfast/dstore"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 var walkTests = []StoreTestFunc{
 	TestListFiles,
+	TestListFilesPage,
 
 	TestWalk_IgnoreNotFound,
 	TestWalk_FilePrefix,
 	TestWalk_PathPrefix,
 	TestWalkFrom,
+	TestWalkBetween,
+	TestWalk_SkipPrefix,
+	TestWalkObjects,
+	TestListDirectories,
 }
 
 func TestWalk_IgnoreNotFound(t *testing.T, factory StoreFactory) {
 	store, cleanup := factory()
 	defer cleanup()
 
-	err := store.Walk(ctx, "bubblicious/0000", "", func(f string) error { return nil })
+	err := store.Walk(ctx, "bubblicious/0000", func(f string) error { return nil })
 	require.NoError(t, err)
 }
 
@@ -36,7 +43,7 @@ func TestWalk_FilePrefix(t *testing.T, factory StoreFactory) {
 	}
 
 	var seen []string
-	err := store.Walk(ctx, "0000", "", func(f string) error {
+	err := store.Walk(ctx, "0000", func(f string) error {
 		seen = append(seen, f)
 		exists, err := store.FileExists(ctx, f)
 		assert.NoError(t, err)
@@ -81,7 +88,7 @@ func TestWalk_PathPrefix(t *testing.T, factory StoreFactory) {
 	}
 
 	var seen []string
-	err := store.Walk(ctx, "0000", "", func(f string) error {
+	err := store.Walk(ctx, "0000", func(f string) error {
 		seen = append(seen, f)
 		exists, err := store.FileExists(ctx, f)
 		assert.NoError(t, err)
@@ -103,20 +110,20 @@ func TestListFiles(t *testing.T, factory StoreFactory) {
 	}{
 		{
 			name:           "empty",
-			withQuery:      listFilesQuery{prefix: "", ignoreSuffix: "", max: math.MaxInt64},
+			withQuery:      listFilesQuery{prefix: "", max: math.MaxInt64},
 			whenFiles:      []testFile{},
 			expectingNames: nil, expectedErr: nil,
 		},
 		{
 			name:           "multiple",
-			withQuery:      listFilesQuery{prefix: "", ignoreSuffix: "", max: math.MaxInt64},
+			withQuery:      listFilesQuery{prefix: "", max: math.MaxInt64},
 			whenFiles:      []testFile{{"1", "c1"}, {"2", "c2"}, {"3", "c3"}},
 			expectingNames: []string{"1", "2", "3"}, expectedErr: nil,
 		},
 
 		{
 			name:           "multiple with sub paths",
-			withQuery:      listFilesQuery{prefix: "", ignoreSuffix: "", max: math.MaxInt64},
+			withQuery:      listFilesQuery{prefix: "", max: math.MaxInt64},
 			whenFiles:      []testFile{{"a/1", "c1"}, {"b/2", "c2"}, {"b/3", "c3"}},
 			expectingNames: []string{"a/1", "b/2", "b/3"}, expectedErr: nil,
 		},
@@ -130,7 +137,7 @@ func TestListFiles(t *testing.T, factory StoreFactory) {
 				addFileToStore(t, store, file.id, file.content)
 			}
 
-			filenames, err := store.ListFiles(context.Background(), test.withQuery.prefix, test.withQuery.ignoreSuffix, test.withQuery.max)
+			filenames, err := store.ListFiles(context.Background(), test.withQuery.prefix, test.withQuery.max)
 			if test.expectedErr != nil {
 				require.Equal(t, test.expectedErr, err)
 			} else {
@@ -141,7 +148,134 @@ func TestListFiles(t *testing.T, factory StoreFactory) {
 }
 
 type listFilesQuery struct {
-	prefix       string
-	ignoreSuffix string
-	max          int
+	prefix string
+	max    int
+}
+
+func TestWalkObjects(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	expected := []string{"00000001", "00000002", "00000003"}
+	for _, f := range expected {
+		addFileToStore(t, store, f, f)
+	}
+
+	var seen []string
+	err := store.WalkObjects(ctx, "0000", func(attrs *dstore.ObjectAttrs) error {
+		seen = append(seen, attrs.Name)
+
+		expectedAttrs, err := store.ObjectAttributes(ctx, attrs.Name)
+		require.NoError(t, err)
+		assert.Equal(t, expectedAttrs.Size, attrs.Size)
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.EqualValues(t, expected, seen)
+}
+
+func TestListDirectories(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	for _, f := range []string{"root", "0000/0001", "0000/0002", "0001/0001", "0001/sub/0001"} {
+		addFileToStore(t, store, f, f)
+	}
+
+	directories, err := store.ListDirectories(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0000", "0001"}, directories)
+
+	directories, err = store.ListDirectories(ctx, "0001")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0001/sub"}, directories)
+
+	directories, err = store.ListDirectories(ctx, "0000/")
+	require.NoError(t, err)
+	assert.Empty(t, directories)
+
+	directories, err = store.ListDirectories(ctx, "unknown")
+	require.NoError(t, err)
+	assert.Empty(t, directories)
+}
+
+func TestWalkBetween(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	written := []string{"00000001", "00000002", "00000003", "00000004", "00000005"}
+	for _, f := range written {
+		addFileToStore(t, store, f, f)
+	}
+
+	var seen []string
+	err := store.WalkBetween(ctx, "", "00000002", "00000004", func(f string) error {
+		seen = append(seen, f)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, []string{"00000002", "00000003"}, seen)
+
+	seen = nil
+	err = store.WalkBetween(ctx, "0000", "00000004", "", func(f string) error {
+		seen = append(seen, f)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, []string{"00000004", "00000005"}, seen)
+}
+
+func TestListFilesPage(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	written := []string{"00000001", "00000002", "00000003", "00000004", "00000005"}
+	for _, f := range written {
+		addFileToStore(t, store, f, f)
+	}
+
+	var seen []string
+	var pages int
+	pageToken := ""
+	for {
+		files, nextToken, err := store.ListFilesPage(ctx, "", 2, pageToken)
+		require.NoError(t, err)
+		require.True(t, len(files) <= 2)
+
+		seen = append(seen, files...)
+		pages++
+		if nextToken == "" {
+			break
+		}
+		pageToken = nextToken
+	}
+
+	assert.EqualValues(t, written, seen)
+	assert.Equal(t, 3, pages)
+}
+
+func TestWalk_SkipPrefix(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	written := []string{"2022-01-01/0001", "2022-01-01/0002", "2022-01-02/0001", "2022-01-02/0002", "2022-01-03/0001"}
+	for _, f := range written {
+		addFileToStore(t, store, f, f)
+	}
+
+	var seen []string
+	err := store.Walk(ctx, "", func(f string) error {
+		seen = append(seen, f)
+		if f == "2022-01-01/0001" {
+			return dstore.SkipPrefix("2022-01-01/")
+		}
+		if f == "2022-01-02/0001" {
+			return dstore.SkipPrefix("2022-01-02/")
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.EqualValues(t, []string{"2022-01-01/0001", "2022-01-02/0001", "2022-01-03/0001"}, seen)
 }
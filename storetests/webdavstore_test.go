@@ -0,0 +1,40 @@
+//go:build !dstore_no_webdav
+
+package storetests
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVStore(t *testing.T) {
+	TestAll(t, createWebDAVStoreFactory(t, ""))
+}
+
+func TestWebDAVStoreCompressedZst(t *testing.T) {
+	TestAll(t, createWebDAVStoreFactory(t, "zstd"))
+}
+
+// createWebDAVStoreFactory serves a temporary directory through an in-process
+// WebDAV server.
+func createWebDAVStoreFactory(t *testing.T, compression string) StoreFactory {
+	return func() (dstore.Store, StoreCleanup) {
+		server := httptest.NewServer(&webdav.Handler{
+			FileSystem: webdav.Dir(t.TempDir()),
+			LockSystem: webdav.NewMemLS(),
+		})
+
+		storeURL, err := url.Parse("webdav://" + server.Listener.Addr().String() + "/store-tests")
+		require.NoError(t, err)
+
+		store, err := dstore.NewWebDAVStore(storeURL, "", compression, false)
+		require.NoError(t, err)
+
+		return store, server.Close
+	}
+}
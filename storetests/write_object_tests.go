@@ -2,15 +2,18 @@ package storetests
 
 import (
 	"bytes"
+	"math"
 	"strings"
 	"testing"
 
+	"github.com/streamingfast/dstore"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 var writeObjectTests = []StoreTestFunc{
 	TestWriteObject_Basic,
+	TestWriteObject_Metadata,
 	TestWriteObject_ConcurrentOverwrite,
 	TestWriteObject_ConcurrentNoOverwrite,
 }
@@ -29,6 +32,35 @@ func TestWriteObject_Basic(t *testing.T, factory StoreFactory) {
 	assert.Equal(t, content, readObjectAndClose(t, rd))
 }
 
+func TestWriteObject_Metadata(t *testing.T, factory StoreFactory) {
+	store, cleanup := factory()
+	defer cleanup()
+
+	metadata := dstore.WithMetadata(map[string]string{"Producer_Version": "v1.2.3", "block_range": "100-199"})
+	err := store.WriteObject(ctx, "0000/file", bytes.NewReader([]byte("c1")), metadata)
+	require.NoError(t, err)
+
+	expected := map[string]string{"producer_version": "v1.2.3", "block_range": "100-199"}
+
+	attrs, err := store.ObjectAttributes(ctx, "0000/file")
+	require.NoError(t, err)
+	assert.Equal(t, expected, attrs.Metadata)
+
+	require.NoError(t, store.CopyObject(ctx, "0000/file", "0001/file"))
+	attrs, err = store.ObjectAttributes(ctx, "0001/file")
+	require.NoError(t, err)
+	assert.Equal(t, expected, attrs.Metadata, "copy should carry metadata over")
+
+	require.NoError(t, store.RenameObject(ctx, "0001/file", "0002/file"))
+	attrs, err = store.ObjectAttributes(ctx, "0002/file")
+	require.NoError(t, err)
+	assert.Equal(t, expected, attrs.Metadata, "rename should carry metadata over")
+
+	files, err := store.ListFiles(ctx, "", math.MaxInt64)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0000/file", "0002/file"}, files)
+}
+
 func TestWriteObject_ConcurrentOverwrite(t *testing.T, factory StoreFactory) {
 	store, cleanup := factory()
 	defer cleanup()
@@ -0,0 +1,594 @@
+//go:build !dstore_no_swift
+
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ncw/swift/v2"
+	"go.uber.org/zap"
+)
+
+// swiftDefaultSegmentSize is the size above which objects are uploaded as
+// static large objects, in segments of that same size, when no
+// `MultipartThreshold` is configured.
+const swiftDefaultSegmentSize = 100 * 1024 * 1024
+
+// swiftMaxObjectSize is the largest object Swift accepts in a single request.
+const swiftMaxObjectSize = 5 * 1024 * 1024 * 1024
+
+//
+// OpenStack Swift Store
+//
+
+func init() {
+	registerBackend(&backend{
+		schemes: []string{"swift"},
+		newStore: func(base *url.URL, opts ...Option) (Store, error) {
+			store, err := NewSwiftStoreWithOptions(base, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		},
+		errorClass: swiftErrorClass,
+	})
+}
+
+// swiftErrorClass returns the class of the errors of the Swift client.
+func swiftErrorClass(err error) error {
+	var swiftErr *swift.Error
+	if errors.As(err, &swiftErr) {
+		return statusClass(swiftErr.StatusCode)
+	}
+	return nil
+}
+
+type SwiftStore struct {
+	baseURL *url.URL
+
+	container  string
+	path       string
+	conn       *swift.Connection
+	tempURLKey string
+
+	segmentSize int64
+
+	*commonStore
+}
+
+func NewSwiftStore(baseURL *url.URL, extension, compressionType string, overwrite bool) (*SwiftStore, error) {
+	return NewSwiftStoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+// NewSwiftStoreWithOptions creates a store on the `swift://container/path` URL,
+// authenticating against Keystone (v1, v2 or v3, as told by `OS_AUTH_URL`)
+// with the usual `OS_*` environment variables of the OpenStack clients, like
+// `OS_USERNAME`, `OS_PASSWORD`, `OS_PROJECT_NAME` and `OS_USER_DOMAIN_NAME`, or
+// `OS_APPLICATION_CREDENTIAL_ID` and `OS_APPLICATION_CREDENTIAL_SECRET`.
+// Pre-signed URLs are generated as temporary URLs signed with the key of the
+// `SWIFT_TEMP_URL_KEY` environment variable.
+func NewSwiftStoreWithOptions(baseURL *url.URL, opts ...Option) (*SwiftStore, error) {
+	conn := &swift.Connection{}
+	if err := conn.ApplyEnvironment(); err != nil {
+		return nil, fmt.Errorf("reading swift environment: %w", err)
+	}
+	if conn.AuthUrl == "" {
+		return nil, fmt.Errorf("specify swift credentials through the OS_AUTH_URL and other OS_* environment variables")
+	}
+
+	return NewSwiftStoreFromConnection(baseURL, conn, opts...)
+}
+
+// NewSwiftStoreFromConnection creates a store using an already configured
+// Swift connection, for authentication setups the environment can't express.
+func NewSwiftStoreFromConnection(baseURL *url.URL, conn *swift.Connection, opts ...Option) (*SwiftStore, error) {
+	config := newCloudConfig(opts)
+	if baseURL.Host == "" {
+		return nil, fmt.Errorf("specify swift container like: swift://container/path")
+	}
+	if config.multipartThreshold > swiftMaxObjectSize {
+		return nil, fmt.Errorf("multipart threshold must be at most %d bytes, got %d", int64(swiftMaxObjectSize), config.multipartThreshold)
+	}
+
+	segmentSize := config.multipartThreshold
+	if segmentSize == 0 {
+		segmentSize = swiftDefaultSegmentSize
+	}
+
+	return &SwiftStore{
+		baseURL:     baseURL,
+		container:   baseURL.Host,
+		path:        strings.Trim(baseURL.Path, "/"),
+		conn:        conn,
+		tempURLKey:  os.Getenv("SWIFT_TEMP_URL_KEY"),
+		segmentSize: segmentSize,
+		commonStore: newCommonStore(baseURL, config),
+	}, nil
+}
+
+// SubStore shares the Swift connection of the parent store.
+func (s *SwiftStore) SubStore(subFolder string) (Store, error) {
+	url, err := url.Parse(s.baseURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("swift store parsing base url: %w", err)
+	}
+	url.Path = path.Join(url.Path, subFolder)
+	return NewSwiftStoreFromConnection(url, s.conn, append(s.options(), MultipartThreshold(s.segmentSize))...)
+}
+
+func (s *SwiftStore) BaseURL() *url.URL {
+	return s.baseURL
+}
+
+func (s *SwiftStore) ObjectPath(name string) string {
+	return path.Join(s.path, s.pathWithExt(name))
+}
+
+func (s *SwiftStore) ObjectURL(name string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(s.baseURL.String(), "/"), strings.TrimLeft(s.pathWithExt(name), "/"))
+}
+
+func (s *SwiftStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return s.tempURL(ctx, http.MethodGet, base, ttl)
+}
+
+func (s *SwiftStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return s.tempURL(ctx, http.MethodPut, base, ttl)
+}
+
+func (s *SwiftStore) tempURL(ctx context.Context, method, base string, ttl time.Duration) (string, error) {
+	if s.tempURLKey == "" {
+		return "", fmt.Errorf("swift store presign without SWIFT_TEMP_URL_KEY: %w", ErrNotSupported)
+	}
+
+	// The storage URL signed in temporary URLs is only known once authenticated
+	if !s.conn.Authenticated() {
+		if err := s.conn.Authenticate(ctx); err != nil {
+			return "", fmt.Errorf("swift authentication: %w", err)
+		}
+	}
+
+	return s.conn.ObjectTempUrl(s.container, s.ObjectPath(base), s.tempURLKey, method, time.Now().Add(ttl)), nil
+}
+
+func (s *SwiftStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+	config := newWriteConfig(opts)
+	f = config.trackProgress(f)
+	if config.acl != "" {
+		return fmt.Errorf("object ACL: %w", ErrNotSupported)
+	}
+
+	if !s.overwrite {
+		exists, err := s.FileExists(ctx, base)
+		if err != nil {
+			return err
+		}
+		if exists {
+			// We silently ignore when we ask not to overwrite
+			return nil
+		}
+	}
+
+	contentType, cacheControl := s.contentHeaders(config, "", "")
+	headers := swift.Metadata(config.metadata).ObjectHeaders()
+	if cacheControl != "" {
+		headers["Cache-Control"] = cacheControl
+	}
+
+	pipeRead, pipeWrite := io.Pipe()
+	writeDone := make(chan error, 1)
+	go func() {
+		err := s.compressedCopy(ctx, f, pipeWrite)
+		pipeWrite.CloseWithError(err)
+		writeDone <- err
+	}()
+
+	err = s.upload(ctx, path, contentType, headers, pipeRead)
+	pipeRead.Close()
+	if copyErr := <-writeDone; copyErr != nil && copyErr != io.ErrClosedPipe {
+		return copyErr
+	}
+	return err
+}
+
+// upload puts the content as a regular object when it fits in one segment, and
+// as a static large object, or a dynamic one when the cluster doesn't support
+// them, otherwise. Segments go to the `<container>_segments` container.
+func (s *SwiftStore) upload(ctx context.Context, objectPath, contentType string, headers swift.Headers, content io.Reader) error {
+	head := &bytes.Buffer{}
+	if _, err := io.CopyN(head, content, s.segmentSize+1); err != nil && err != io.EOF {
+		return err
+	}
+
+	if int64(head.Len()) <= s.segmentSize {
+		if !s.overwrite {
+			// Another writer creating the object since our check fails the put
+			headers["If-None-Match"] = "*"
+		}
+
+		_, err := s.conn.ObjectPut(ctx, s.container, objectPath, head, false, "", contentType, headers)
+		var swiftErr *swift.Error
+		if errors.As(err, &swiftErr) && swiftErr.StatusCode == http.StatusPreconditionFailed {
+			return nil
+		}
+		return err
+	}
+
+	segmentContainer := s.container + "_segments"
+	if err := s.conn.ContainerCreate(ctx, segmentContainer, nil); err != nil {
+		return fmt.Errorf("creating segment container %q: %w", segmentContainer, err)
+	}
+
+	opts := &swift.LargeObjectOpts{
+		Container:        s.container,
+		ObjectName:       objectPath,
+		Flags:            os.O_TRUNC | os.O_CREATE,
+		ContentType:      contentType,
+		Headers:          headers,
+		ChunkSize:        s.segmentSize,
+		SegmentContainer: segmentContainer,
+	}
+
+	file, err := s.conn.StaticLargeObjectCreate(ctx, opts)
+	if err == swift.SLONotSupported {
+		file, err = s.conn.DynamicLargeObjectCreate(ctx, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("creating large object: %w", err)
+	}
+
+	if _, err := io.Copy(file, io.MultiReader(head, content)); err != nil {
+		file.Close()
+		return fmt.Errorf("writing large object segments: %w", err)
+	}
+	return file.Close()
+}
+
+func (s *SwiftStore) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, s, dst); skip || err != nil {
+		return err
+	}
+
+	srcPath, dstPath := s.ObjectPath(src), s.ObjectPath(dst)
+
+	info, headers, err := s.conn.Object(ctx, s.container, srcPath)
+	if err != nil {
+		if err == swift.ObjectNotFound {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if !headers.IsLargeObject() {
+		_, err := s.conn.ObjectCopy(ctx, s.container, srcPath, s.container, dstPath, nil)
+		return err
+	}
+
+	// Copying a large object server-side only duplicates its manifest, the
+	// two objects would then share their segments, so it's streamed instead
+	reader, _, err := s.conn.ObjectOpen(ctx, s.container, srcPath, false, nil)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	copyHeaders := headers.ObjectMetadata().ObjectHeaders()
+	if cacheControl := headers["Cache-Control"]; cacheControl != "" {
+		copyHeaders["Cache-Control"] = cacheControl
+	}
+	return s.upload(ctx, dstPath, info.ContentType, copyHeaders, reader)
+}
+
+func (s *SwiftStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer classifyError(&err)
+	if _, _, err := s.conn.Object(ctx, s.container, s.ObjectPath(base)); err != nil {
+		if err == swift.ObjectNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *SwiftStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	defer classifyError(&err)
+	info, headers, err := s.conn.Object(ctx, s.container, s.ObjectPath(base))
+	if err != nil {
+		if err == swift.ObjectNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	attrs = newSwiftObjectAttrs(base, info)
+	attrs.Metadata = normalizeMetadata(headers.ObjectMetadata())
+	return attrs, nil
+}
+
+func newSwiftObjectAttrs(name string, object swift.Object) *ObjectAttrs {
+	return &ObjectAttrs{
+		Name:         name,
+		Size:         object.Bytes,
+		LastModified: object.LastModified,
+		ETag:         strings.Trim(object.Hash, `"`),
+	}
+}
+
+func (s *SwiftStore) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer classifyError(&err)
+	return renameObject(ctx, s, oldName, newName)
+}
+
+func (s *SwiftStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(name)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file", zap.String("path", path))
+	}
+
+	file, _, err := s.conn.ObjectOpen(ctx, s.container, path, false, nil)
+	if err != nil {
+		if err == swift.ObjectNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return s.uncompressedReader(ctx, s.resumeReads(ctx, name, file, s.openStoredRange))
+}
+
+func (s *SwiftStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	if s.decompressesReads() {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+	reader, err := s.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.throttleReads(ctx, reader), nil
+}
+
+func (s *SwiftStore) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	path := s.ObjectPath(name)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file range", zap.String("path", path), zap.Int64("offset", offset), zap.Int64("length", length))
+	}
+
+	// The range is requested open-ended and cut client-side, some Swift
+	// implementations mishandling ranges ending past the end of the object
+	file, _, err := s.conn.ObjectOpen(ctx, s.container, path, false, swift.Headers{"Range": fmt.Sprintf("bytes=%d-", offset)})
+	if err != nil {
+		var swiftErr *swift.Error
+		switch {
+		case err == swift.ObjectNotFound:
+			return nil, ErrNotFound
+		case errors.As(err, &swiftErr) && swiftErr.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+			// The offset is past the end of the object
+			return ioutil.NopCloser(bytes.NewReader(nil)), nil
+		}
+		return nil, err
+	}
+
+	return limitReadCloser(file, length), nil
+}
+
+func (s *SwiftStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkBetween(ctx, prefix, startingPoint, "", f)
+}
+
+// WalkBetween seeks to `startingPoint` with the listing marker and stops at
+// `endPoint` natively through the end marker.
+func (s *SwiftStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkObjects(ctx, prefix, startingPoint, endPoint, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *SwiftStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *SwiftStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkObjects(ctx, prefix, "", "", f)
+}
+
+func (s *SwiftStore) walkObjects(ctx context.Context, prefix, startingPoint, endPoint string, f func(attrs *ObjectAttrs) (err error)) error {
+	opts := &swift.ObjectsOpts{Prefix: s.walkPrefix(prefix)}
+	if startingPoint != "" {
+		// The marker is exclusive, listing resumes right before the starting point
+		opts.Marker = s.walkPrefix("") + startingPoint[:len(startingPoint)-1]
+	}
+	if endPoint != "" {
+		opts.EndMarker = s.walkPrefix("") + endPoint
+	}
+
+	for {
+		if debugEnabled(s.logger) {
+			s.logger.Debug("walking files", zap.String("container", s.container), zap.String("prefix", opts.Prefix), zap.String("marker", opts.Marker))
+		}
+
+		objects, err := s.conn.Objects(ctx, s.container, opts)
+		if err != nil {
+			return fmt.Errorf("listing objects: %w", err)
+		}
+		if len(objects) == 0 {
+			return nil
+		}
+		opts.Marker = objects[len(objects)-1].Name
+
+		for _, object := range objects {
+			filename := s.toBaseName(object.Name)
+			if filename == "" {
+				s.logger.Warn("got an empty filename from swift store, ignoring it", zap.String("name", object.Name))
+				continue
+			}
+			if filename < startingPoint {
+				continue
+			}
+			if endPoint != "" && filename >= endPoint {
+				return nil
+			}
+
+			if err := f(newSwiftObjectAttrs(filename, object)); err != nil {
+				if err == StopIteration {
+					return nil
+				}
+				if skipped, ok := skippedPrefix(err); ok {
+					// Resumes after the last name of the skipped prefix
+					if marker := s.walkPrefix("") + skipped + string(utf8.MaxRune); marker > object.Name {
+						opts.Marker = marker
+						break
+					}
+					continue
+				}
+				return fmt.Errorf("processing object list: %w", err)
+			}
+		}
+	}
+}
+
+func (s *SwiftStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer classifyError(&err)
+	return listFiles(ctx, s, prefix, max)
+}
+
+// ListFilesPage uses the last object name of the page as page token, passed as
+// listing marker for the next page.
+func (s *SwiftStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer classifyError(&err)
+	objects, err := s.conn.Objects(ctx, s.container, &swift.ObjectsOpts{
+		Prefix: s.walkPrefix(prefix),
+		Marker: pageToken,
+		Limit:  pageSize,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("listing objects: %w", err)
+	}
+	if len(objects) > pageSize {
+		objects = objects[:pageSize]
+	}
+
+	for _, object := range objects {
+		if filename := s.toBaseName(object.Name); filename != "" {
+			files = append(files, filename)
+		}
+	}
+	if len(objects) == pageSize {
+		nextToken = objects[len(objects)-1].Name
+	}
+	return files, nextToken, nil
+}
+
+func (s *SwiftStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer classifyError(&err)
+	opts := &swift.ObjectsOpts{
+		Prefix:    directoryPrefix(s.path, prefix),
+		Delimiter: '/',
+	}
+
+	for {
+		objects, err := s.conn.Objects(ctx, s.container, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing directories: %w", err)
+		}
+		if len(objects) == 0 {
+			return out, nil
+		}
+		opts.Marker = objects[len(objects)-1].Name
+
+		for _, object := range objects {
+			if object.PseudoDirectory {
+				out = append(out, relativeDirectory(s.path, object.Name))
+			}
+		}
+	}
+}
+
+// walkPrefix returns the full object name prefix to list for the given store
+// relative prefix.
+func (s *SwiftStore) walkPrefix(prefix string) string {
+	targetPrefix := s.path
+	if targetPrefix != "" {
+		targetPrefix += "/"
+	}
+	if prefix != "" {
+		targetPrefix = filepath.Join(targetPrefix, prefix)
+		if prefix[len(prefix)-1:] == "/" {
+			targetPrefix += "/"
+		}
+	}
+	return targetPrefix
+}
+
+func (s *SwiftStore) toBaseName(filename string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(filename, s.pathWithExt("")), s.path+"/")
+}
+
+// DeleteObject deletes the object along with its segments when it's a large
+// object.
+func (s *SwiftStore) DeleteObject(ctx context.Context, base string) (err error) {
+	defer classifyError(&err)
+	if err := s.conn.LargeObjectDelete(ctx, s.container, s.ObjectPath(base)); err != nil {
+		if err == swift.ObjectNotFound {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *SwiftStore) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer classifyError(&err)
+	return deleteObjectsConcurrently(ctx, names, deleteObjectsConcurrency, func(ctx context.Context, name string) error {
+		if err := s.DeleteObject(ctx, name); err != nil && err != ErrNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *SwiftStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer classifyError(&err)
+	return deletePrefix(ctx, s, prefix, deletePrefixConcurrency)
+}
+
+func (s *SwiftStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer classifyError(&err)
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
@@ -0,0 +1,96 @@
+//go:build !dstore_no_swift
+
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ncw/swift/v2"
+	"github.com/ncw/swift/v2/swifttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSwiftStore(t *testing.T, opts ...Option) (*SwiftStore, *swift.Connection) {
+	server, err := swifttest.NewSwiftServer("localhost")
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	conn := &swift.Connection{UserName: swifttest.TEST_ACCOUNT, ApiKey: swifttest.TEST_ACCOUNT, AuthUrl: server.AuthURL}
+	require.NoError(t, conn.ContainerCreate(context.Background(), "container", nil))
+
+	store, err := NewSwiftStoreFromConnection(&url.URL{Scheme: "swift", Host: "container", Path: "/path"}, conn, opts...)
+	require.NoError(t, err)
+	return store, conn
+}
+
+func TestSwiftStore_ErrorClasses(t *testing.T) {
+	assertErrorClass(t, &swift.Error{StatusCode: http.StatusUnauthorized}, ErrPermissionDenied)
+	assertErrorClass(t, &swift.Error{StatusCode: http.StatusServiceUnavailable}, ErrTransient)
+	assertErrorClass(t, swift.ObjectNotFound, nil)
+}
+
+func TestSwiftStore_LargeObject(t *testing.T) {
+	store, conn := newTestSwiftStore(t, MultipartThreshold(1024))
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("0123456789"), 250)
+	require.NoError(t, store.WriteObject(ctx, "large", bytes.NewReader(content), WithMetadata(map[string]string{"Block_Range": "1-2"})))
+
+	segments, err := conn.ObjectNames(ctx, "container_segments", nil)
+	require.NoError(t, err)
+	segmentCount := len(segments)
+	assert.True(t, segmentCount >= 3, "should be written in segments of 1024 bytes")
+
+	reader, err := store.OpenObject(ctx, "large")
+	require.NoError(t, err)
+	read, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, content, read)
+
+	require.NoError(t, store.CopyObject(ctx, "large", "copy"))
+	segments, err = conn.ObjectNames(ctx, "container_segments", nil)
+	require.NoError(t, err)
+	assert.Len(t, segments, 2*segmentCount, "copy should have its own segments")
+
+	attrs, err := store.ObjectAttributes(ctx, "copy")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), attrs.Size)
+	assert.Equal(t, map[string]string{"block_range": "1-2"}, attrs.Metadata)
+
+	require.NoError(t, store.DeleteObject(ctx, "large"))
+	segments, err = conn.ObjectNames(ctx, "container_segments", nil)
+	require.NoError(t, err)
+	assert.Len(t, segments, segmentCount, "delete should remove the segments")
+
+	// Below the threshold, objects are regular ones
+	require.NoError(t, store.WriteObject(ctx, "small", bytes.NewReader(content[:1024])))
+	segments, err = conn.ObjectNames(ctx, "container_segments", nil)
+	require.NoError(t, err)
+	assert.Len(t, segments, segmentCount)
+}
+
+func TestSwiftStore_Presign(t *testing.T) {
+	os.Setenv("SWIFT_TEMP_URL_KEY", "")
+	store, _ := newTestSwiftStore(t)
+
+	_, err := store.PresignGet(context.Background(), "0001", time.Minute)
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	os.Setenv("SWIFT_TEMP_URL_KEY", "secret")
+	defer os.Unsetenv("SWIFT_TEMP_URL_KEY")
+	store, _ = newTestSwiftStore(t)
+
+	signed, err := store.PresignPut(context.Background(), "0001", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(signed, "/container/path/0001?temp_url_sig="), signed)
+}
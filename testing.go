@@ -11,24 +11,27 @@ import (
 	"path"
 	"sort"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 )
 
 type MockStore struct {
-	files             map[string][]byte
-	shouldOverwrite   bool
-	OpenObjectFunc    func(ctx context.Context, name string) (out io.ReadCloser, err error)
-	WriteObjectFunc   func(ctx context.Context, base string, f io.Reader) error
-	DeleteObjectFunc  func(ctx context.Context, base string) error
-	FileExistsFunc    func(ctx context.Context, base string) (bool, error)
-	ListFilesFunc     func(ctx context.Context, prefix string, max int) ([]string, error)
-	WalkFunc          func(ctx context.Context, prefix string, f func(filename string) error) error
-	PushLocalFileFunc func(ctx context.Context, localFile string, toBaseName string) (err error)
+	files                map[string][]byte
+	metadata             map[string]map[string]string
+	shouldOverwrite      bool
+	OpenObjectFunc       func(ctx context.Context, name string) (out io.ReadCloser, err error)
+	WriteObjectFunc      func(ctx context.Context, base string, f io.Reader) error
+	DeleteObjectFunc     func(ctx context.Context, base string) error
+	FileExistsFunc       func(ctx context.Context, base string) (bool, error)
+	ObjectAttributesFunc func(ctx context.Context, base string) (*ObjectAttrs, error)
+	ListFilesFunc        func(ctx context.Context, prefix string, max int) ([]string, error)
+	WalkFunc             func(ctx context.Context, prefix string, f func(filename string) error) error
+	PushLocalFileFunc    func(ctx context.Context, localFile string, toBaseName string) (err error)
 }
 
 func NewMockStore(writeFunc func(base string, f io.Reader) (err error)) *MockStore {
-	store := &MockStore{files: make(map[string][]byte)}
+	store := &MockStore{files: make(map[string][]byte), metadata: make(map[string]map[string]string)}
 	if writeFunc != nil {
 		store.WriteObjectFunc = func(ctx context.Context, base string, f io.Reader) error {
 			return writeFunc(base, f)
@@ -44,16 +47,23 @@ func (s *MockStore) SubStore(subFolder string) (Store, error) {
 		newFiles[subFolder+"/"+k] = v
 	}
 
+	newMetadata := map[string]map[string]string{}
+	for k, v := range s.metadata {
+		newMetadata[subFolder+"/"+k] = v
+	}
+
 	return &MockStore{
-		files:             newFiles,
-		shouldOverwrite:   s.shouldOverwrite,
-		OpenObjectFunc:    s.OpenObjectFunc,
-		WriteObjectFunc:   s.WriteObjectFunc,
-		DeleteObjectFunc:  s.DeleteObjectFunc,
-		FileExistsFunc:    s.FileExistsFunc,
-		ListFilesFunc:     s.ListFilesFunc,
-		WalkFunc:          s.WalkFunc,
-		PushLocalFileFunc: s.PushLocalFileFunc,
+		files:                newFiles,
+		metadata:             newMetadata,
+		shouldOverwrite:      s.shouldOverwrite,
+		OpenObjectFunc:       s.OpenObjectFunc,
+		WriteObjectFunc:      s.WriteObjectFunc,
+		DeleteObjectFunc:     s.DeleteObjectFunc,
+		FileExistsFunc:       s.FileExistsFunc,
+		ObjectAttributesFunc: s.ObjectAttributesFunc,
+		ListFilesFunc:        s.ListFilesFunc,
+		WalkFunc:             s.WalkFunc,
+		PushLocalFileFunc:    s.PushLocalFileFunc,
 	}, nil
 }
 
@@ -104,7 +114,29 @@ func (s *MockStore) OpenObject(ctx context.Context, name string) (out io.ReadClo
 
 }
 
-func (s *MockStore) WriteObject(ctx context.Context, base string, f io.Reader) (err error) {
+func (s *MockStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	reader, err := s.OpenObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	content = content[offset:]
+	if length >= 0 && length < int64(len(content)) {
+		content = content[:length]
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (s *MockStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
 	if s.WriteObjectFunc != nil {
 		return s.WriteObjectFunc(ctx, base, f)
 	}
@@ -129,11 +161,33 @@ func (s *MockStore) WriteObject(ctx context.Context, base string, f io.Reader) (
 	}
 
 	s.files[base] = buffer.Bytes()
+	s.metadata[base] = newWriteConfig(opts).metadata
 
 	zlog.Debug("wrote object", zap.String("name", base), zap.Int("content_length", len(s.files[base])))
 	return nil
 }
 
+func (s *MockStore) CopyObject(ctx context.Context, src, dst string) error {
+	zlog.Debug("copying object", zap.String("src", src), zap.String("dst", dst))
+
+	content, exists := s.files[src]
+	if !exists {
+		return ErrNotFound
+	}
+
+	if _, exists := s.files[dst]; exists && !s.shouldOverwrite {
+		return nil
+	}
+
+	s.files[dst] = content
+	s.metadata[dst] = s.metadata[src]
+	return nil
+}
+
+func (s *MockStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	return renameObject(ctx, s, oldName, newName)
+}
+
 func (s *MockStore) ObjectPath(base string) string {
 	return base
 }
@@ -142,6 +196,14 @@ func (s *MockStore) ObjectURL(base string) string {
 	return base
 }
 
+func (s *MockStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (s *MockStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
 func (s *MockStore) DeleteObject(ctx context.Context, base string) error {
 	if s.DeleteObjectFunc != nil {
 		return s.DeleteObjectFunc(ctx, base)
@@ -149,9 +211,25 @@ func (s *MockStore) DeleteObject(ctx context.Context, base string) error {
 
 	zlog.Debug("deleting object", zap.String("name", base))
 	delete(s.files, base)
+	delete(s.metadata, base)
 	return nil
 }
 
+func (s *MockStore) DeleteObjects(ctx context.Context, names []string) error {
+	for _, name := range names {
+		if err := s.DeleteObject(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeletePrefix deletes a single batch at a time since the mock store is not
+// safe for concurrent use.
+func (s *MockStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	return deletePrefix(ctx, s, prefix, 1)
+}
+
 func (s *MockStore) FileExists(ctx context.Context, base string) (bool, error) {
 	if s.FileExistsFunc != nil {
 		return s.FileExistsFunc(ctx, base)
@@ -171,6 +249,29 @@ func (s *MockStore) FileExists(ctx context.Context, base string) (bool, error) {
 	return scnt != "err", nil
 }
 
+func (s *MockStore) ObjectAttributes(ctx context.Context, base string) (*ObjectAttrs, error) {
+	if s.ObjectAttributesFunc != nil {
+		return s.ObjectAttributesFunc(ctx, base)
+	}
+
+	zlog.Debug("getting object attributes", zap.String("name", base))
+
+	content, exists := s.files[base]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	if string(content) == "err" {
+		return nil, fmt.Errorf("%q errored", base)
+	}
+
+	return &ObjectAttrs{
+		Name:     base,
+		Size:     int64(len(content)),
+		Metadata: s.metadata[base],
+	}, nil
+}
+
 func (s *MockStore) ListFiles(ctx context.Context, prefix string, max int) ([]string, error) {
 	if s.ListFilesFunc != nil {
 		return s.ListFilesFunc(ctx, prefix, max)
@@ -188,6 +289,10 @@ func (s *MockStore) WalkFrom(ctx context.Context, prefix, startingPoint string,
 	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
 }
 
+func (s *MockStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error {
+	return commonWalkBetween(s, ctx, prefix, startingPoint, endPoint, f)
+}
+
 func (s *MockStore) Walk(ctx context.Context, prefix string, f func(filename string) error) error {
 	if s.WalkFunc != nil {
 		return s.WalkFunc(ctx, prefix, f)
@@ -195,6 +300,9 @@ func (s *MockStore) Walk(ctx context.Context, prefix string, f func(filename str
 
 	zlog.Debug("walking files", zap.String("prefix", prefix))
 	sortedFiles := s.sortedFiles()
+	walkFunc := skipPrefixes(func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
 
 	for _, file := range sortedFiles {
 		zlog.Debug("walking file", zap.String("file", file), zap.Bool("has_prefix", strings.HasPrefix(file, prefix)))
@@ -202,7 +310,32 @@ func (s *MockStore) Walk(ctx context.Context, prefix string, f func(filename str
 			return fmt.Errorf("mock err, %s", file)
 		}
 		if strings.HasPrefix(file, prefix) {
-			if err := f(file); err != nil {
+			if err := walkFunc(&ObjectAttrs{Name: file}); err != nil {
+				if err == StopIteration {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MockStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) error) error {
+	zlog.Debug("walking objects", zap.String("prefix", prefix))
+	f = skipPrefixes(f)
+
+	for _, file := range s.sortedFiles() {
+		if strings.Contains(file, "err") {
+			return fmt.Errorf("mock err, %s", file)
+		}
+		if strings.HasPrefix(file, prefix) {
+			attrs := &ObjectAttrs{
+				Name:     file,
+				Size:     int64(len(s.files[file])),
+				Metadata: s.metadata[file],
+			}
+			if err := f(attrs); err != nil {
 				if err == StopIteration {
 					return nil
 				}
@@ -213,6 +346,31 @@ func (s *MockStore) Walk(ctx context.Context, prefix string, f func(filename str
 	return nil
 }
 
+func (s *MockStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	return listFilesPage(ctx, s, prefix, pageSize, pageToken)
+}
+
+func (s *MockStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+
+	seen := map[string]bool{}
+	for _, file := range s.sortedFiles() {
+		if !strings.HasPrefix(file, prefix) {
+			continue
+		}
+		if i := strings.Index(file[len(prefix):], "/"); i >= 0 {
+			directory := file[:len(prefix)+i]
+			if !seen[directory] {
+				seen[directory] = true
+				out = append(out, directory)
+			}
+		}
+	}
+	return out, nil
+}
+
 func (s *MockStore) sortedFiles() []string {
 	sortedFiles := make([]string, len(s.files))
 
@@ -0,0 +1,586 @@
+package dstore
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+//
+// Tiered Store
+//
+
+// TieredPolicy configures the hot tier of a `TieredStore`.
+type TieredPolicy struct {
+	// MaxSize is the total size in bytes of the objects kept on the hot store,
+	// as stored there, above which the least recently read ones are evicted.
+	MaxSize int64
+
+	// Validate checks on every read of a hot object that the cold object
+	// didn't change since it was copied, comparing their ETag, generation,
+	// size and last modification time, and copies it again otherwise. It
+	// costs an attributes request to the cold store per read.
+	Validate bool
+}
+
+// tieredVersionKey is the metadata key of hot objects holding the version of
+// the cold object they were copied from, when validating.
+const tieredVersionKey = "dstore-tiered-version"
+
+// TieredStore is a `Store` caching the objects of a cold store, typically
+// remote, on a hot store, typically local. Reads are served by the hot store,
+// which is filled from the cold store on a miss, and the least recently read
+// objects are evicted once the hot objects exceed the policy's `MaxSize`.
+// Writes go to the cold store and through to the hot store, copies, renames
+// and deletions go to the cold store and invalidate the hot copies, listings
+// and attributes always come from the cold store.
+//
+// The hot store is owned by the tiered store, objects already on it when
+// created are indexed, most recently modified first, and are trusted to be
+// up to date with the cold store unless the policy validates them.
+type TieredStore struct {
+	// Store is the cold store, serving every operation not involving the hot
+	// store.
+	Store
+
+	hot   Store
+	cache *tieredCache
+}
+
+// tieredCache indexes the objects of the hot store, shared with sub stores so
+// that they all count against the same size budget.
+type tieredCache struct {
+	lock     sync.Mutex
+	maxSize  int64
+	validate bool
+	size     int64
+	// entries are keyed by the hot object path, the most recently read at
+	// the front of the list
+	entries *list.List
+	byKey   map[string]*list.Element
+	// writes holds the hot objects being filled, written through or
+	// invalidated, closed once done, so that only one of them runs at a time
+	writes map[string]chan struct{}
+}
+
+type tieredEntry struct {
+	key     string
+	name    string
+	size    int64
+	version string
+	hot     Store
+}
+
+func NewTieredStore(hot, cold Store, policy TieredPolicy) (*TieredStore, error) {
+	if policy.MaxSize <= 0 {
+		return nil, fmt.Errorf("tiered store max size must be positive, got %d", policy.MaxSize)
+	}
+
+	hot.SetOverwrite(true)
+	s := &TieredStore{
+		Store: cold,
+		hot:   hot,
+		cache: &tieredCache{
+			maxSize:  policy.MaxSize,
+			validate: policy.Validate,
+			entries:  list.New(),
+			byKey:    map[string]*list.Element{},
+			writes:   map[string]chan struct{}{},
+		},
+	}
+
+	var existing []*ObjectAttrs
+	if err := hot.WalkObjects(context.Background(), "", func(attrs *ObjectAttrs) error {
+		existing = append(existing, attrs)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("indexing hot store: %w", err)
+	}
+
+	sort.SliceStable(existing, func(i, j int) bool { return existing[i].LastModified.Before(existing[j].LastModified) })
+	for _, attrs := range existing {
+		s.cache.add(&tieredEntry{key: hot.ObjectPath(attrs.Name), name: attrs.Name, size: attrs.Size, version: attrs.Metadata[tieredVersionKey], hot: hot})
+	}
+	s.evict(context.Background(), "")
+
+	return s, nil
+}
+
+// SubStore returns a tiered store on the sub stores of both tiers, sharing
+// the hot objects index and size budget of this one.
+func (s *TieredStore) SubStore(subFolder string) (Store, error) {
+	hot, err := s.hot.SubStore(subFolder)
+	if err != nil {
+		return nil, fmt.Errorf("hot sub store: %w", err)
+	}
+	cold, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, fmt.Errorf("cold sub store: %w", err)
+	}
+
+	return &TieredStore{Store: cold, hot: hot, cache: s.cache}, nil
+}
+
+func (c *tieredCache) add(entry *tieredEntry) {
+	if element, found := c.byKey[entry.key]; found {
+		c.size -= element.Value.(*tieredEntry).size
+		c.entries.Remove(element)
+	}
+	c.byKey[entry.key] = c.entries.PushFront(entry)
+	c.size += entry.size
+}
+
+func (c *tieredCache) remove(key string) {
+	if element, found := c.byKey[key]; found {
+		c.size -= element.Value.(*tieredEntry).size
+		c.entries.Remove(element)
+		delete(c.byKey, key)
+	}
+}
+
+// reserve reserves the hot object `key` for a write, returning the function
+// releasing it, or the channel closed once the write holding it is done.
+func (c *tieredCache) reserve(key string) (release func(), busy <-chan struct{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if done, found := c.writes[key]; found {
+		return nil, done
+	}
+
+	done := make(chan struct{})
+	c.writes[key] = done
+	return func() {
+		c.lock.Lock()
+		close(done)
+		delete(c.writes, key)
+		c.lock.Unlock()
+	}, nil
+}
+
+// NewCachingStore caches the objects read from `inner` as files in the local
+// `cacheDir` directory, up to `maxSize` bytes, checking on every read that
+// the cached objects are still up to date. Objects are cached decompressed
+// and the cache is kept across restarts, see `TieredStore` for the details.
+func NewCachingStore(inner Store, cacheDir string, maxSize int64) (*TieredStore, error) {
+	hot, err := NewLocalStore(&url.URL{Scheme: "file", Path: cacheDir}, "", "", true)
+	if err != nil {
+		return nil, fmt.Errorf("cache directory: %w", err)
+	}
+	return NewTieredStore(hot, inner, TieredPolicy{MaxSize: maxSize, Validate: true})
+}
+
+// tieredVersion identifies the content of the cold object, as far as its
+// attributes allow.
+func tieredVersion(attrs *ObjectAttrs) string {
+	return fmt.Sprintf("%s/%d/%d/%d", attrs.ETag, attrs.Generation, attrs.Size, attrs.LastModified.UnixNano())
+}
+
+// touch marks the object as the most recently read, reporting whether it is
+// on the hot store along with the version of the cold object it was copied
+// from.
+func (s *TieredStore) touch(name string) (version string, found bool) {
+	s.cache.lock.Lock()
+	defer s.cache.lock.Unlock()
+
+	element, found := s.cache.byKey[s.hot.ObjectPath(name)]
+	if !found {
+		return "", false
+	}
+	s.cache.entries.MoveToFront(element)
+	return element.Value.(*tieredEntry).version, true
+}
+
+// coldVersion returns the version of the cold object when validating.
+func (s *TieredStore) coldVersion(ctx context.Context, name string) (string, error) {
+	if !s.cache.validate {
+		return "", nil
+	}
+
+	attrs, err := s.Store.ObjectAttributes(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return tieredVersion(attrs), nil
+}
+
+// evict deletes the least recently read objects from the hot store until they
+// fit in the size budget, sparing the object at `keep` which was just read.
+func (s *TieredStore) evict(ctx context.Context, keep string) {
+	var victims []*tieredEntry
+
+	s.cache.lock.Lock()
+	for s.cache.size > s.cache.maxSize {
+		entry := s.cache.entries.Back().Value.(*tieredEntry)
+		if entry.key == keep {
+			break
+		}
+		s.cache.remove(entry.key)
+		victims = append(victims, entry)
+	}
+	s.cache.lock.Unlock()
+
+	for _, entry := range victims {
+		if err := entry.hot.DeleteObject(ctx, entry.name); err != nil && err != ErrNotFound {
+			storeLogger(s.Store).Warn("unable to evict object from hot store", zap.String("name", entry.name), zap.Error(err))
+		}
+	}
+}
+
+// reserve waits for the write of the hot object `key` in progress, if any,
+// and reserves it.
+func (s *TieredStore) reserve(ctx context.Context, key string) (release func(), err error) {
+	for {
+		release, busy := s.cache.reserve(key)
+		if release != nil {
+			return release, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-busy:
+		}
+	}
+}
+
+// invalidate removes the hot copy of the object, if any, once the write of
+// the hot object in progress is done, so that a fill started before the cold
+// object changed can't leave a stale copy behind.
+func (s *TieredStore) invalidate(ctx context.Context, name string) error {
+	release, err := s.reserve(ctx, s.hot.ObjectPath(name))
+	if err != nil {
+		return err
+	}
+	defer release()
+	return s.dropHot(ctx, name)
+}
+
+// dropHot removes the hot copy of the object, which must be reserved.
+func (s *TieredStore) dropHot(ctx context.Context, name string) error {
+	s.cache.lock.Lock()
+	s.cache.remove(s.hot.ObjectPath(name))
+	s.cache.lock.Unlock()
+
+	if err := s.hot.DeleteObject(ctx, name); err != nil && err != ErrNotFound {
+		return fmt.Errorf("invalidating hot object %q: %w", name, err)
+	}
+	return nil
+}
+
+// fill copies the object from the cold store to the hot store unless it's
+// already there and valid, waiting for the copy of a concurrent reader if
+// there's one.
+func (s *TieredStore) fill(ctx context.Context, name string) error {
+	key := s.hot.ObjectPath(name)
+	var release func()
+	for {
+		if version, found := s.touch(name); found {
+			current, err := s.coldVersion(ctx, name)
+			if err == ErrNotFound {
+				s.invalidate(ctx, name)
+				return ErrNotFound
+			}
+			if err != nil || current == version {
+				return err
+			}
+
+			if err := s.invalidate(ctx, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var busy <-chan struct{}
+		if release, busy = s.cache.reserve(key); release != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-busy:
+		}
+	}
+	defer release()
+
+	// Read before the content, so that a concurrent change is caught by the
+	// next validation rather than hidden
+	version, err := s.coldVersion(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	reader, err := s.Store.OpenObject(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return s.writeHot(ctx, name, reader, version)
+}
+
+// writeHot writes `content` to the hot object `name`, which must be reserved,
+// as the copy of the cold object of `version`, and indexes it.
+func (s *TieredStore) writeHot(ctx context.Context, name string, content io.Reader, version string) error {
+	var opts []WriteOption
+	if version != "" {
+		opts = append(opts, WithMetadata(map[string]string{tieredVersionKey: version}))
+	}
+	if err := s.hot.WriteObject(ctx, name, content, opts...); err != nil {
+		s.dropHot(ctx, name)
+		return fmt.Errorf("writing hot object: %w", err)
+	}
+
+	attrs, err := s.hot.ObjectAttributes(ctx, name)
+	if err != nil {
+		s.dropHot(ctx, name)
+		return fmt.Errorf("hot object attributes: %w", err)
+	}
+
+	key := s.hot.ObjectPath(name)
+	s.cache.lock.Lock()
+	s.cache.add(&tieredEntry{key: key, name: name, size: attrs.Size, version: version, hot: s.hot})
+	s.cache.lock.Unlock()
+
+	s.evict(ctx, key)
+	return nil
+}
+
+// openHot fills the hot store if needed and calls `open` on it, falling back
+// to the cold store when the hot store fails.
+func (s *TieredStore) openHot(ctx context.Context, name string, open func(store Store) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	if err := s.fill(ctx, name); err != nil {
+		if err == ErrNotFound || ctx.Err() != nil {
+			return nil, err
+		}
+
+		storeLogger(s.Store).Warn("unable to fill hot store, reading from cold store", zap.String("name", name), zap.Error(err))
+		return open(s.Store)
+	}
+
+	reader, err := open(s.hot)
+	if err == ErrNotFound {
+		// Evicted in the meantime, or removed behind our back
+		s.cache.lock.Lock()
+		s.cache.remove(s.hot.ObjectPath(name))
+		s.cache.lock.Unlock()
+
+		return open(s.Store)
+	}
+	return reader, err
+}
+
+func (s *TieredStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	return s.openHot(ctx, name, func(store Store) (io.ReadCloser, error) {
+		return store.OpenObject(ctx, name)
+	})
+}
+
+func (s *TieredStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	return s.openHot(ctx, name, func(store Store) (io.ReadCloser, error) {
+		return store.OpenObjectRange(ctx, name, offset, length)
+	})
+}
+
+// Prefetch copies the objects `names` to the hot store, with up to
+// `concurrency` copies in flight, so that reading them later is served
+// locally. Objects already hot are only marked as the most recently read, and
+// readers opening an object being prefetched wait for its copy instead of
+// fetching it again. It stops at the first error.
+//
+// It's meant to be run in a goroutine ahead of a sequential consumer, for
+// example on the next segments of a replay, prefetching past the size budget
+// evicting the objects prefetched first.
+func (s *TieredStore) Prefetch(ctx context.Context, names []string, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+
+	work := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				if err := s.fill(ctx, name); err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("prefetch %q: %w", name, err)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, name := range names {
+		select {
+		case work <- name:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func (s *TieredStore) FileExists(ctx context.Context, base string) (bool, error) {
+	if _, found := s.touch(base); found && !s.cache.validate {
+		return true, nil
+	}
+	return s.Store.FileExists(ctx, base)
+}
+
+// WriteObject writes through to the hot store, from `f` again when it's
+// seekable and from a temporary copy made along the cold write otherwise. A
+// cold store keeping existing objects only invalidates the hot copy, the
+// content possibly not being the one kept.
+func (s *TieredStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	release, err := s.reserve(ctx, s.hot.ObjectPath(base))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if !s.Store.Overwrite() {
+		if err := s.Store.WriteObject(ctx, base, f, opts...); err != nil {
+			return err
+		}
+		return s.dropHot(ctx, base)
+	}
+
+	seeker, seekable := f.(io.ReadSeeker)
+	var start int64
+	if seekable {
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+
+	var spooled *os.File
+	if !seekable {
+		if spooled, err = ioutil.TempFile("", "dstore-tiered-*"); err != nil {
+			return fmt.Errorf("create temporary file: %w", err)
+		}
+		defer removeSpooledContent(spooled)
+		f = io.TeeReader(f, spooled)
+	}
+
+	if err := s.Store.WriteObject(ctx, base, f, opts...); err != nil {
+		return err
+	}
+
+	source := seeker
+	if spooled != nil {
+		// The cold store may not have read the content to its end
+		_, err = io.Copy(ioutil.Discard, f)
+		source, start = spooled, 0
+	}
+	if err == nil {
+		err = s.writeThrough(ctx, base, source, start)
+	}
+	if err != nil {
+		storeLogger(s.Store).Warn("unable to write through to hot store", zap.String("name", base), zap.Error(err))
+		return s.dropHot(ctx, base)
+	}
+	return nil
+}
+
+// writeThrough writes the content just written to the cold object `name`,
+// read from `content` at `start`, to the hot store.
+func (s *TieredStore) writeThrough(ctx context.Context, name string, content io.ReadSeeker, start int64) error {
+	if _, err := content.Seek(start, io.SeekStart); err != nil {
+		return fmt.Errorf("seek content: %w", err)
+	}
+
+	version, err := s.coldVersion(ctx, name)
+	if err != nil {
+		return err
+	}
+	return s.writeHot(ctx, name, content, version)
+}
+
+func (s *TieredStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+func (s *TieredStore) CopyObject(ctx context.Context, src, dst string) error {
+	if err := s.Store.CopyObject(ctx, src, dst); err != nil {
+		return err
+	}
+	return s.invalidate(ctx, dst)
+}
+
+func (s *TieredStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	if err := s.Store.RenameObject(ctx, oldName, newName); err != nil {
+		return err
+	}
+	if err := s.invalidate(ctx, oldName); err != nil {
+		return err
+	}
+	return s.invalidate(ctx, newName)
+}
+
+func (s *TieredStore) DeleteObject(ctx context.Context, base string) error {
+	if err := s.Store.DeleteObject(ctx, base); err != nil {
+		return err
+	}
+	return s.invalidate(ctx, base)
+}
+
+func (s *TieredStore) DeleteObjects(ctx context.Context, names []string) error {
+	if err := s.Store.DeleteObjects(ctx, names); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := s.invalidate(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *TieredStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	deleted, err = s.Store.DeletePrefix(ctx, prefix)
+	if err != nil {
+		return deleted, err
+	}
+
+	var names []string
+	if err := s.hot.Walk(ctx, prefix, func(filename string) error {
+		names = append(names, filename)
+		return nil
+	}); err != nil {
+		return deleted, fmt.Errorf("walking hot store: %w", err)
+	}
+	for _, name := range names {
+		if err := s.invalidate(ctx, name); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
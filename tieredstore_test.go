@@ -0,0 +1,289 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hotObjects(t *testing.T, hot Store) []string {
+	t.Helper()
+
+	files, err := hot.ListFiles(context.Background(), "", 100)
+	require.NoError(t, err)
+	return files
+}
+
+func TestTieredStore_Eviction(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := NewMemoryStore(), NewMemoryStore(AllowOverwrite())
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, cold.WriteObject(ctx, name, strings.NewReader(strings.Repeat(name, 10))))
+	}
+
+	store, err := NewTieredStore(hot, cold, TieredPolicy{MaxSize: 25})
+	require.NoError(t, err)
+
+	content, err := ReadObject(ctx, store, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaaaaaaa", string(content))
+	content, err = ReadObject(ctx, store, "b")
+	require.NoError(t, err)
+	assert.Equal(t, "bbbbbbbbbb", string(content))
+	assert.Equal(t, []string{"a", "b"}, hotObjects(t, hot))
+
+	// Reading "a" again makes "b" the least recently read
+	_, err = ReadObject(ctx, store, "a")
+	require.NoError(t, err)
+	content, err = ReadObject(ctx, store, "c")
+	require.NoError(t, err)
+	assert.Equal(t, "cccccccccc", string(content))
+	assert.Equal(t, []string{"a", "c"}, hotObjects(t, hot))
+
+	// Served from the hot store, even when the cold store changed behind our back
+	require.NoError(t, cold.WriteObject(ctx, "a", strings.NewReader("changed")))
+	content, err = ReadObject(ctx, store, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaaaaaaa", string(content))
+
+	// Writes through the tiered store go through to the hot store
+	require.NoError(t, store.WriteObject(ctx, "a", strings.NewReader("written")))
+	assert.Equal(t, []string{"a", "c"}, hotObjects(t, hot))
+	written, err := ReadObject(ctx, hot, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "written", string(written))
+	content, err = ReadObject(ctx, store, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "written", string(content))
+
+	require.NoError(t, store.DeleteObject(ctx, "c"))
+	assert.Equal(t, []string{"a"}, hotObjects(t, hot))
+	_, err = store.OpenObject(ctx, "c")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestTieredStore_OversizedObject(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := NewMemoryStore(), NewMemoryStore()
+	require.NoError(t, cold.WriteObject(ctx, "large", strings.NewReader(strings.Repeat("l", 100))))
+	require.NoError(t, cold.WriteObject(ctx, "small", strings.NewReader("small")))
+
+	store, err := NewTieredStore(hot, cold, TieredPolicy{MaxSize: 50})
+	require.NoError(t, err)
+
+	content, err := ReadObject(ctx, store, "large")
+	require.NoError(t, err)
+	assert.Len(t, content, 100)
+	content, err = ReadObject(ctx, store, "small")
+	require.NoError(t, err)
+	assert.Equal(t, "small", string(content))
+	assert.Equal(t, []string{"small"}, hotObjects(t, hot))
+}
+
+func TestTieredStore_ExistingHotObjects(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := NewMemoryStore(), NewMemoryStore()
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, hot.WriteObject(ctx, name, strings.NewReader(strings.Repeat(name, 10))))
+	}
+
+	// The oldest hot objects are evicted to fit in the budget
+	store, err := NewTieredStore(hot, cold, TieredPolicy{MaxSize: 20})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, hotObjects(t, hot))
+
+	content, err := ReadObject(ctx, store, "c")
+	require.NoError(t, err)
+	assert.Equal(t, "cccccccccc", string(content))
+	exists, err := store.FileExists(ctx, "b")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	_, err = NewTieredStore(hot, cold, TieredPolicy{})
+	require.Error(t, err)
+}
+
+func TestTieredStore_SubStore(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := NewMemoryStore(), NewMemoryStore()
+	require.NoError(t, cold.WriteObject(ctx, "sub/a", strings.NewReader(strings.Repeat("a", 10))))
+	require.NoError(t, cold.WriteObject(ctx, "sub/b", strings.NewReader(strings.Repeat("b", 10))))
+
+	store, err := NewTieredStore(hot, cold, TieredPolicy{MaxSize: 15})
+	require.NoError(t, err)
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+
+	content, err := ReadObject(ctx, sub, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaaaaaaa", string(content))
+	content, err = ReadObject(ctx, store, "sub/a")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaaaaaaa", string(content))
+	content, err = ReadObject(ctx, store, "sub/b")
+	require.NoError(t, err)
+	assert.Equal(t, "bbbbbbbbbb", string(content))
+
+	// Both share the same budget
+	assert.Equal(t, []string{"sub/b"}, hotObjects(t, hot))
+}
+
+func TestTieredStore_ConcurrentReads(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := NewMemoryStore(), NewMemoryStore()
+	require.NoError(t, cold.WriteObject(ctx, "file", strings.NewReader("content")))
+
+	store, err := NewTieredStore(hot, cold, TieredPolicy{MaxSize: 1024})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			reader, err := store.OpenObject(ctx, "file")
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer reader.Close()
+
+			content, err := ioutil.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, "content", string(content))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(7), store.cache.size)
+}
+
+// gatedStore holds its first open until `proceed` is closed, once the content
+// was read and `opened` closed.
+type gatedStore struct {
+	Store
+	once    sync.Once
+	opened  chan struct{}
+	proceed chan struct{}
+}
+
+func (s *gatedStore) OpenObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	content, err := ReadObject(ctx, s.Store, name)
+	if err != nil {
+		return nil, err
+	}
+	s.once.Do(func() {
+		close(s.opened)
+		<-s.proceed
+	})
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func TestTieredStore_WriteDuringFill(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := NewMemoryStore(), NewMemoryStore(AllowOverwrite())
+	require.NoError(t, cold.WriteObject(ctx, "file", strings.NewReader("old")))
+
+	gated := &gatedStore{Store: cold, opened: make(chan struct{}), proceed: make(chan struct{})}
+	store, err := NewTieredStore(hot, gated, TieredPolicy{MaxSize: 1024})
+	require.NoError(t, err)
+
+	read := make(chan string)
+	go func() {
+		content, err := ReadObject(ctx, store, "file")
+		assert.NoError(t, err)
+		read <- string(content)
+	}()
+	<-gated.opened
+
+	written := make(chan error)
+	go func() { written <- store.WriteObject(ctx, "file", strings.NewReader("new")) }()
+	select {
+	case err := <-written:
+		t.Fatalf("write completed during the fill: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(gated.proceed)
+	assert.Equal(t, "old", <-read)
+	require.NoError(t, <-written)
+
+	// The fill of the old content doesn't survive the write
+	content, err := ReadObject(ctx, store, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+	content, err = ReadObject(ctx, hot, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+}
+
+func TestTieredStore_Prefetch(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := NewMemoryStore(), NewMemoryStore()
+	for _, name := range []string{"0001", "0002", "0003"} {
+		require.NoError(t, cold.WriteObject(ctx, name, strings.NewReader(name)))
+	}
+
+	store, err := NewTieredStore(hot, cold, TieredPolicy{MaxSize: 1024})
+	require.NoError(t, err)
+
+	require.NoError(t, store.Prefetch(ctx, []string{"0001", "0002"}, 2))
+	assert.Equal(t, []string{"0001", "0002"}, hotObjects(t, hot))
+
+	// Served from the hot store once prefetched
+	require.NoError(t, cold.DeleteObject(ctx, "0002"))
+	content, err := ReadObject(ctx, store, "0002")
+	require.NoError(t, err)
+	assert.Equal(t, "0002", string(content))
+
+	err = store.Prefetch(ctx, []string{"0003", "missing"}, 1)
+	assert.True(t, errors.Is(err, ErrNotFound), "expected ErrNotFound, got %v", err)
+	assert.Equal(t, []string{"0001", "0002", "0003"}, hotObjects(t, hot))
+}
+
+func TestCachingStore(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	inner := NewMemoryStore(AllowOverwrite(), Compression("zstd"))
+	require.NoError(t, inner.WriteObject(ctx, "blocks/0001", strings.NewReader("first")))
+
+	store, err := NewCachingStore(inner, cacheDir, 1024)
+	require.NoError(t, err)
+	content, err := ReadObject(ctx, store, "blocks/0001")
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(content))
+	assert.FileExists(t, filepath.Join(cacheDir, "blocks", "0001"))
+
+	// Changes made behind the cache's back are caught on the next read
+	require.NoError(t, inner.WriteObject(ctx, "blocks/0001", strings.NewReader("second")))
+	content, err = ReadObject(ctx, store, "blocks/0001")
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(content))
+
+	// The cache survives restarts
+	store, err = NewCachingStore(inner, cacheDir, 1024)
+	require.NoError(t, err)
+	_, found := store.touch("blocks/0001")
+	assert.True(t, found)
+	content, err = ReadObject(ctx, store, "blocks/0001")
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(content))
+
+	require.NoError(t, inner.DeleteObject(ctx, "blocks/0001"))
+	_, err = store.OpenObject(ctx, "blocks/0001")
+	assert.Equal(t, ErrNotFound, err)
+	exists, err := store.FileExists(ctx, "blocks/0001")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.NoFileExists(t, filepath.Join(cacheDir, "blocks", "0001"))
+}
@@ -0,0 +1,42 @@
+package dstore
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// TimeoutOperations returns an interceptor, for `NewInterceptedStore`,
+// running each operation under a context canceled after `timeout`, so that a
+// stalled backend fails the call instead of hanging it.
+//
+// The timeout of the opened objects runs until their reader is closed, and
+// the one of walks includes the time spent in their callback.
+func TimeoutOperations(timeout time.Duration) Interceptor {
+	return func(op *Operation, next Handler) Handler {
+		return func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			err := next(ctx)
+			if err == nil && op.Reader != nil {
+				op.Reader = &cancelingReader{ReadCloser: op.Reader, cancel: cancel}
+				return nil
+			}
+			cancel()
+			return err
+		}
+	}
+}
+
+// cancelingReader releases the context of an opened object once closed.
+type cancelingReader struct {
+	io.ReadCloser
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+func (r *cancelingReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.closeOnce.Do(r.cancel)
+	return err
+}
@@ -0,0 +1,66 @@
+package dstore
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeoutOperations(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+	require.NoError(t, inner.WriteObject(ctx, "file", strings.NewReader("content")))
+
+	// Stalls the writes until their context is done
+	stall := func(op *Operation, next Handler) Handler {
+		return func(ctx context.Context) error {
+			if op.Method == "WriteObject" {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return next(ctx)
+		}
+	}
+	store := NewInterceptedStore(inner, TimeoutOperations(10*time.Millisecond), stall)
+
+	err := store.WriteObject(ctx, "stalled", strings.NewReader("content"))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	var opened context.Context
+	capture := func(op *Operation, next Handler) Handler {
+		return func(ctx context.Context) error {
+			opened = ctx
+			return next(ctx)
+		}
+	}
+	store = NewInterceptedStore(inner, TimeoutOperations(time.Hour), capture)
+	reader, err := store.OpenObject(ctx, "file")
+	require.NoError(t, err)
+	assert.NoError(t, opened.Err(), "the context lasts until the reader is closed")
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+	require.NoError(t, reader.Close())
+	assert.Equal(t, context.Canceled, opened.Err())
+}
+
+func TestOperationTimeout(t *testing.T) {
+	store, err := NewStoreWithOptions(t.TempDir(), OperationTimeout(time.Minute))
+	require.NoError(t, err)
+	require.IsType(t, &InterceptedStore{}, store)
+
+	ctx := context.Background()
+	require.NoError(t, store.WriteObject(ctx, "file", strings.NewReader("content")))
+	content, err := ReadObject(ctx, store, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	store, err = NewStoreWithOptions(t.TempDir())
+	require.NoError(t, err)
+	assert.IsType(t, &LocalStore{}, store)
+}
@@ -0,0 +1,239 @@
+package dstore
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//
+// Tracing Store
+//
+
+const tracerName = "github.com/streamingfast/dstore"
+
+// TracingStore is a `Store` emitting OpenTelemetry spans for the reads,
+// writes, walks and deletions on the inner store, children of the span of the
+// context passed by the caller. Spans carry the backend, bucket and key of the
+// objects, the compression of the store and the size of the content
+// transferred.
+//
+// The span of an open object lasts until its reader is closed, so that it
+// covers the read of the content and not only the request opening it.
+type TracingStore struct {
+	// Store is the inner store, traced.
+	Store
+
+	tracer     trace.Tracer
+	attributes []attribute.KeyValue
+}
+
+// NewTracingStore returns a `TracingStore` tracing the operations on `inner`
+// with a tracer of `provider`, the global tracer provider when nil.
+func NewTracingStore(inner Store, provider trace.TracerProvider) *TracingStore {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+
+	s := &TracingStore{
+		Store:  inner,
+		tracer: provider.Tracer(tracerName),
+	}
+	s.attributes = s.storeAttributes()
+	return s
+}
+
+// storeAttributes returns the attributes of the spans identifying the inner
+// store.
+func (s *TracingStore) storeAttributes() []attribute.KeyValue {
+	backend, bucket := "file", ""
+	if baseURL := s.Store.BaseURL(); baseURL != nil {
+		if baseURL.Scheme != "" {
+			backend = baseURL.Scheme
+		}
+		bucket = baseURL.Host
+	}
+
+	attributes := []attribute.KeyValue{attribute.String("dstore.backend", backend)}
+	if bucket != "" {
+		attributes = append(attributes, attribute.String("dstore.bucket", bucket))
+	}
+	if compressed, ok := s.Store.(interface{ compression() string }); ok && compressed.compression() != "" {
+		attributes = append(attributes, attribute.String("dstore.compression", compressed.compression()))
+	}
+	return attributes
+}
+
+func (s *TracingStore) start(ctx context.Context, operation string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "dstore."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(s.attributes...),
+		trace.WithAttributes(attributes...),
+	)
+}
+
+// endSpan ends `span`, recording `err` when the operation failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *TracingStore) SubStore(subFolder string) (Store, error) {
+	inner, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := *s
+	sub.Store = inner
+	sub.attributes = sub.storeAttributes()
+	return &sub, nil
+}
+
+func (s *TracingStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	ctx, span := s.start(ctx, "OpenObject", attribute.String("dstore.key", s.ObjectPath(name)))
+
+	reader, err := s.Store.OpenObject(ctx, name)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedReader{ReadCloser: reader, span: span}, nil
+}
+
+func (s *TracingStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	ctx, span := s.start(ctx, "OpenObjectRange",
+		attribute.String("dstore.key", s.ObjectPath(name)),
+		attribute.Int64("dstore.offset", offset),
+		attribute.Int64("dstore.length", length),
+	)
+
+	reader, err := s.Store.OpenObjectRange(ctx, name, offset, length)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedReader{ReadCloser: reader, span: span}, nil
+}
+
+func (s *TracingStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	ctx, span := s.start(ctx, "WriteObject", attribute.String("dstore.key", s.ObjectPath(base)))
+
+	var size int64
+	counted := &metricsReader{Reader: f, observe: func(n int) { size += int64(n) }}
+	if seeker, ok := f.(io.ReadSeeker); ok {
+		err = s.Store.WriteObject(ctx, base, &metricsReadSeeker{metricsReader: counted, seeker: seeker}, opts...)
+	} else {
+		err = s.Store.WriteObject(ctx, base, counted, opts...)
+	}
+
+	span.SetAttributes(attribute.Int64("dstore.size", size))
+	endSpan(span, err)
+	return err
+}
+
+func (s *TracingStore) DeleteObject(ctx context.Context, base string) (err error) {
+	ctx, span := s.start(ctx, "DeleteObject", attribute.String("dstore.key", s.ObjectPath(base)))
+	err = s.Store.DeleteObject(ctx, base)
+	endSpan(span, err)
+	return err
+}
+
+func (s *TracingStore) DeleteObjects(ctx context.Context, names []string) (err error) {
+	ctx, span := s.start(ctx, "DeleteObjects", attribute.Int("dstore.count", len(names)))
+	err = s.Store.DeleteObjects(ctx, names)
+	endSpan(span, err)
+	return err
+}
+
+func (s *TracingStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	ctx, span := s.start(ctx, "DeletePrefix", attribute.String("dstore.prefix", prefix))
+	deleted, err = s.Store.DeletePrefix(ctx, prefix)
+	span.SetAttributes(attribute.Int("dstore.count", deleted))
+	endSpan(span, err)
+	return deleted, err
+}
+
+func (s *TracingStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	ctx, span := s.start(ctx, "Walk", attribute.String("dstore.prefix", prefix), attribute.String("dstore.start", startingPoint))
+	var count int
+	err = s.Store.WalkFrom(ctx, prefix, startingPoint, func(filename string) error {
+		count++
+		return f(filename)
+	})
+	span.SetAttributes(attribute.Int("dstore.count", count))
+	endSpan(span, err)
+	return err
+}
+
+func (s *TracingStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	ctx, span := s.start(ctx, "Walk", attribute.String("dstore.prefix", prefix), attribute.String("dstore.start", startingPoint), attribute.String("dstore.end", endPoint))
+	var count int
+	err = s.Store.WalkBetween(ctx, prefix, startingPoint, endPoint, func(filename string) error {
+		count++
+		return f(filename)
+	})
+	span.SetAttributes(attribute.Int("dstore.count", count))
+	endSpan(span, err)
+	return err
+}
+
+func (s *TracingStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	ctx, span := s.start(ctx, "Walk", attribute.String("dstore.prefix", prefix))
+	var count int
+	err = s.Store.Walk(ctx, prefix, func(filename string) error {
+		count++
+		return f(filename)
+	})
+	span.SetAttributes(attribute.Int("dstore.count", count))
+	endSpan(span, err)
+	return err
+}
+
+func (s *TracingStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	ctx, span := s.start(ctx, "WalkObjects", attribute.String("dstore.prefix", prefix))
+	var count int
+	err = s.Store.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		count++
+		return f(attrs)
+	})
+	span.SetAttributes(attribute.Int("dstore.count", count))
+	endSpan(span, err)
+	return err
+}
+
+// tracedReader ends the span of an open object once closed, with the number of
+// bytes read and the first read error other than `io.EOF`.
+type tracedReader struct {
+	io.ReadCloser
+	span    trace.Span
+	size    int64
+	readErr error
+}
+
+func (r *tracedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.size += int64(n)
+	if err != nil && err != io.EOF && r.readErr == nil {
+		r.readErr = err
+	}
+	return n, err
+}
+
+func (r *tracedReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.span.SetAttributes(attribute.Int64("dstore.size", r.size))
+	if r.readErr != nil {
+		endSpan(r.span, r.readErr)
+	} else {
+		endSpan(r.span, err)
+	}
+	return err
+}
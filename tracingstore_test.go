@@ -0,0 +1,126 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestTracingStore(t *testing.T) {
+	provider := &recordingTracerProvider{}
+	store := NewTracingStore(NewMemoryStore(), provider)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "caller")
+	require.NoError(t, store.WriteObject(ctx, "file", strings.NewReader("content")))
+
+	reader, err := store.OpenObject(ctx, "file")
+	require.NoError(t, err)
+	spans := provider.ended()
+	assert.Len(t, spans, 1, "the span of an open object ends once closed")
+	_, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	require.NoError(t, store.Walk(ctx, "", func(filename string) error { return nil }))
+	_, err = store.OpenObject(ctx, "missing")
+	require.Error(t, err)
+	require.NoError(t, store.DeleteObject(ctx, "file"))
+	parent.End()
+
+	spans = provider.ended()
+	require.Len(t, spans, 6)
+	for _, span := range spans[:5] {
+		assert.Equal(t, "caller", span.parent, span.name)
+		assert.Equal(t, "memory", span.attributes["dstore.backend"].AsString(), span.name)
+	}
+
+	assert.Equal(t, "dstore.WriteObject", spans[0].name)
+	assert.Equal(t, "file", spans[0].attributes["dstore.key"].AsString())
+	assert.Equal(t, int64(7), spans[0].attributes["dstore.size"].AsInt64())
+
+	assert.Equal(t, "dstore.OpenObject", spans[1].name)
+	assert.Equal(t, int64(7), spans[1].attributes["dstore.size"].AsInt64())
+	assert.Equal(t, codes.Unset, spans[1].status)
+
+	assert.Equal(t, "dstore.Walk", spans[2].name)
+	assert.Equal(t, int64(1), spans[2].attributes["dstore.count"].AsInt64())
+
+	assert.Equal(t, "dstore.OpenObject", spans[3].name)
+	assert.Equal(t, codes.Error, spans[3].status)
+	assert.True(t, errors.Is(spans[3].err, ErrNotFound))
+
+	assert.Equal(t, "dstore.DeleteObject", spans[4].name)
+}
+
+type recordingTracerProvider struct {
+	noop.TracerProvider
+
+	lock  sync.Mutex
+	spans []*recordingSpan
+}
+
+func (p *recordingTracerProvider) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return &recordingTracer{provider: p}
+}
+
+func (p *recordingTracerProvider) ended() []*recordingSpan {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]*recordingSpan(nil), p.spans...)
+}
+
+type recordingTracer struct {
+	noop.Tracer
+	provider *recordingTracerProvider
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	span := &recordingSpan{provider: t.provider, name: name, attributes: map[attribute.Key]attribute.Value{}}
+	if parent, ok := trace.SpanFromContext(ctx).(*recordingSpan); ok {
+		span.parent = parent.name
+	}
+	config := trace.NewSpanStartConfig(opts...)
+	span.SetAttributes(config.Attributes()...)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type recordingSpan struct {
+	noop.Span
+	provider *recordingTracerProvider
+
+	name       string
+	parent     string
+	attributes map[attribute.Key]attribute.Value
+	status     codes.Code
+	err        error
+}
+
+func (s *recordingSpan) SetAttributes(attributes ...attribute.KeyValue) {
+	for _, kv := range attributes {
+		s.attributes[kv.Key] = kv.Value
+	}
+}
+
+func (s *recordingSpan) RecordError(err error, _ ...trace.EventOption) {
+	s.err = err
+}
+
+func (s *recordingSpan) SetStatus(code codes.Code, _ string) {
+	s.status = code
+}
+
+func (s *recordingSpan) End(...trace.SpanEndOption) {
+	s.provider.lock.Lock()
+	defer s.provider.lock.Unlock()
+	s.provider.spans = append(s.provider.spans, s)
+}
@@ -0,0 +1,23 @@
+package dstore
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Validate checks that the store is reachable with its credentials by listing
+// at most one of its files, a single cheap request on most backends. Services
+// call it at startup to fail fast on wrong credentials or bucket names, the
+// error matching `ErrPermissionDenied` when the credentials are not allowed to
+// list the store. Local stores succeed when their directory is missing, as it
+// is created by the first write.
+func Validate(ctx context.Context, store Store) error {
+	if _, err := store.ListFiles(ctx, "", 1); err != nil {
+		// The query of the base URL can hold credentials
+		baseURL := store.BaseURL()
+		location := &url.URL{Scheme: baseURL.Scheme, Host: baseURL.Host, Path: baseURL.Path}
+		return fmt.Errorf("validate store %q: %w", location, err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	require.NoError(t, Validate(ctx, NewMemoryStore()))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	baseURL, err := url.Parse(fmt.Sprintf("s3://%s/bucket/path?region=test&insecure=true&access_key_id=id&secret_access_key=secret", host))
+	require.NoError(t, err)
+	store, err := NewS3StoreWithOptions(baseURL)
+	require.NoError(t, err)
+
+	err = Validate(ctx, store)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrPermissionDenied))
+	assert.Contains(t, err.Error(), fmt.Sprintf(`validate store "s3://%s/bucket/path"`, host))
+	assert.NotContains(t, err.Error(), "secret")
+}
@@ -0,0 +1,301 @@
+package dstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+)
+
+// WalkGlob walks the files matching the glob `pattern`, with the syntax of
+// `path.Match`, so `*` does not match across `/`. The literal part of the
+// pattern before its first special character is used as the listing prefix,
+// the rest being matched client-side.
+func WalkGlob(ctx context.Context, store Store, pattern string, f func(filename string) (err error)) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+	}
+
+	prefix := globPrefix(pattern)
+	if prefix == pattern {
+		// No special characters, it's either the single file or nothing
+		exists, err := store.FileExists(ctx, pattern)
+		if err != nil || !exists {
+			return err
+		}
+		if err := f(pattern); err != nil && err != StopIteration {
+			return err
+		}
+		return nil
+	}
+
+	return store.Walk(ctx, prefix, func(filename string) error {
+		if matched, _ := path.Match(pattern, filename); !matched {
+			return nil
+		}
+		return f(filename)
+	})
+}
+
+// globPrefix returns the literal part of `pattern` preceding its first special
+// character.
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// WalkFilter decides which files are passed to the callback of
+// `WalkFiltered`.
+type WalkFilter interface {
+	Match(filename string) bool
+}
+
+// WalkFilterFunc adapts a plain function into a `WalkFilter`.
+type WalkFilterFunc func(filename string) bool
+
+func (f WalkFilterFunc) Match(filename string) bool {
+	return f(filename)
+}
+
+// prefixedWalkFilter is implemented by filters only matching files starting
+// with a known prefix, which is then pushed down to the listing.
+type prefixedWalkFilter interface {
+	WalkFilter
+	listingPrefix() string
+}
+
+// RegexpFilter matches the files matching `re`. When `re` is anchored with
+// `^`, its literal prefix narrows the listing itself.
+func RegexpFilter(re *regexp.Regexp) WalkFilter {
+	return regexpWalkFilter{re}
+}
+
+type regexpWalkFilter struct {
+	re *regexp.Regexp
+}
+
+func (f regexpWalkFilter) Match(filename string) bool {
+	return f.re.MatchString(filename)
+}
+
+// listingPrefix extracts the literal following the `^` anchor, which the
+// standard `LiteralPrefix` does not report on anchored expressions.
+func (f regexpWalkFilter) listingPrefix() string {
+	re, err := syntax.Parse(f.re.String(), syntax.Perl)
+	if err != nil || re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+	if literal := re.Sub[1]; literal.Op == syntax.OpLiteral && literal.Flags&syntax.FoldCase == 0 {
+		return string(literal.Rune)
+	}
+	return ""
+}
+
+// WalkFiltered walks `prefix` like `Store.Walk`, only invoking `f` for the
+// files accepted by `filter`.
+func WalkFiltered(ctx context.Context, store Store, prefix string, filter WalkFilter, f func(filename string) (err error)) error {
+	if filter, ok := filter.(prefixedWalkFilter); ok {
+		// The filter prefix is only usable when more specific than ours, a
+		// disjoint one means that nothing can match
+		if filterPrefix := filter.listingPrefix(); strings.HasPrefix(filterPrefix, prefix) {
+			prefix = filterPrefix
+		} else if !strings.HasPrefix(prefix, filterPrefix) {
+			return nil
+		}
+	}
+
+	return store.Walk(ctx, prefix, func(filename string) error {
+		if !filter.Match(filename) {
+			return nil
+		}
+		return f(filename)
+	})
+}
+
+// ParallelWalk walks the `prefix` directory, empty for the whole store, like
+// `Store.Walk` but splits the keyspace on the immediate sub-directories of
+// `prefix` as returned by `Store.ListDirectories` and walks up to
+// `concurrency` of them in parallel. Objects directly under `prefix` are
+// walked through `Store.WalkBetween` ranges around the sub-directories, which
+// seek natively on Google Storage and S3.
+//
+// The callback is invoked concurrently, without any ordering guarantee across
+// sub-directories. Returning `StopIteration` from it stops the whole walk.
+func ParallelWalk(ctx context.Context, store Store, prefix string, concurrency int, f func(filename string) (err error)) error {
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+
+	directories, err := store.ListDirectories(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("listing directories: %w", err)
+	}
+	if len(directories) == 0 || concurrency <= 1 {
+		return store.Walk(ctx, prefix, f)
+	}
+
+	var shards []walkShard
+	startingPoint := ""
+	for _, directory := range directories {
+		shards = append(shards,
+			walkShard{prefix: prefix, startingPoint: startingPoint, endPoint: directory + "/"},
+			walkShard{prefix: directory + "/"},
+		)
+		// `0` is the character right after `/`, so it's the first name sorting
+		// after every object of the directory
+		startingPoint = directory + "0"
+	}
+	shards = append(shards, walkShard{prefix: prefix, startingPoint: startingPoint})
+
+	return walkShardsConcurrently(ctx, store, shards, concurrency, f)
+}
+
+type walkShard struct {
+	prefix        string
+	startingPoint string
+	endPoint      string
+}
+
+func walkShardsConcurrently(ctx context.Context, store Store, shards []walkShard, concurrency int, f func(filename string) (err error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	var stopped bool
+
+	fail := func(err error) {
+		errOnce.Do(func() {
+			if err == StopIteration {
+				stopped = true
+			} else {
+				firstErr = err
+			}
+			cancel()
+		})
+	}
+
+	work := make(chan walkShard)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range work {
+				err := store.WalkBetween(ctx, shard.prefix, shard.startingPoint, shard.endPoint, func(filename string) error {
+					if ctx.Err() != nil {
+						return StopIteration
+					}
+					if err := f(filename); err != nil {
+						if _, ok := skippedPrefix(err); ok {
+							return err
+						}
+						fail(err)
+						return StopIteration
+					}
+					return nil
+				})
+				if err != nil {
+					fail(fmt.Errorf("walking %q: %w", shard.prefix, err))
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, shard := range shards {
+		select {
+		case work <- shard:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if stopped {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// IteratorDone is returned by `ObjectIterator.Next` once every object has been
+// returned.
+var IteratorDone = errors.New("no more objects")
+
+// ObjectIterator streams the objects of a listing one at a time, see
+// `ListObjectsIterator`.
+type ObjectIterator struct {
+	objects chan *ObjectAttrs
+	done    chan struct{}
+
+	// err is set before `objects` is closed, so reading it once the channel
+	// is drained does not race with the walk.
+	err       error
+	closeOnce sync.Once
+}
+
+// ListObjectsIterator lists the objects under `prefix` like
+// `Store.WalkObjects`, returning them one at a time from
+// `ObjectIterator.Next` instead of through a callback. The walk runs in a
+// goroutine handing over a single object at a time, so the memory used does
+// not grow with the size of the listing.
+//
+// The iterator must be closed unless `Next` returned an error.
+func ListObjectsIterator(ctx context.Context, store Store, prefix string) *ObjectIterator {
+	it := &ObjectIterator{
+		objects: make(chan *ObjectAttrs),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(it.objects)
+		err := store.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			select {
+			case it.objects <- attrs:
+				return nil
+			case <-it.done:
+				return StopIteration
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err == nil || err == StopIteration {
+			err = IteratorDone
+		}
+		it.err = err
+	}()
+
+	return it
+}
+
+// Next returns the next object of the listing, `IteratorDone` once they have
+// all been returned or the error that interrupted the listing.
+func (it *ObjectIterator) Next() (*ObjectAttrs, error) {
+	if attrs, ok := <-it.objects; ok {
+		return attrs, nil
+	}
+	return nil, it.err
+}
+
+// Close stops the listing, waiting for its goroutine to complete.
+func (it *ObjectIterator) Close() error {
+	it.closeOnce.Do(func() {
+		close(it.done)
+		for range it.objects {
+		}
+	})
+	return nil
+}
@@ -0,0 +1,223 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelWalk(t *testing.T) {
+	files := []string{
+		"root-a",
+		"shards/0000",
+		"shards/0001/0001",
+		"shards/0001/0002",
+		"shards/0001.dat",
+		"shards/0002/0001",
+		"shards/0002/sub/0001",
+		"shards/0003",
+		"zzz",
+	}
+
+	tests := []struct {
+		name     string
+		prefix   string
+		expected []string
+	}{
+		{"whole store", "", files},
+		{"directory", "shards", files[1:8]},
+		{"directory with slash", "shards/", files[1:8]},
+		{"leaf directory", "shards/0001", files[2:4]},
+		{"unknown", "unknown", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := NewLocalStore(&url.URL{Scheme: "file", Path: t.TempDir()}, "dat", "", false)
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			for _, f := range files {
+				require.NoError(t, store.WriteObject(ctx, f, bytes.NewReader([]byte(f))))
+			}
+
+			var lock sync.Mutex
+			var seen []string
+			err = ParallelWalk(ctx, store, test.prefix, 4, func(filename string) error {
+				lock.Lock()
+				defer lock.Unlock()
+				seen = append(seen, filename)
+				return nil
+			})
+			require.NoError(t, err)
+
+			expected := append([]string(nil), test.expected...)
+			sort.Strings(expected)
+			sort.Strings(seen)
+			assert.Equal(t, expected, seen)
+		})
+	}
+}
+
+func TestParallelWalk_Errors(t *testing.T) {
+	store := NewMockStore(nil)
+	for i := 0; i < 10; i++ {
+		store.SetFile(fmt.Sprintf("%04d/file", i), []byte("content"))
+	}
+
+	ctx := context.Background()
+
+	var lock sync.Mutex
+	calls := 0
+	err := ParallelWalk(ctx, store, "", 2, func(filename string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		calls++
+		return StopIteration
+	})
+	require.NoError(t, err)
+	assert.True(t, calls <= 2, "expected at most one call per worker, got %d", calls)
+
+	failure := fmt.Errorf("failure")
+	err = ParallelWalk(ctx, store, "", 2, func(filename string) error {
+		return failure
+	})
+	assert.ErrorIs(t, err, failure)
+}
+
+func TestWalkGlob(t *testing.T) {
+	store := NewMockStore(nil)
+	for _, f := range []string{"shards/0000/0001.dat", "shards/0000/0002.dat", "shards/0001/0001.dat", "shards/0001/0001.json", "shards/0001/sub/0001.dat", "other/0001.dat"} {
+		store.SetFile(f, []byte(f))
+	}
+
+	tests := []struct {
+		pattern     string
+		expected    []string
+		expectedErr bool
+	}{
+		{"shards/*/0001*.dat", []string{"shards/0000/0001.dat", "shards/0001/0001.dat"}, false},
+		{"shards/0001/*", []string{"shards/0001/0001.dat", "shards/0001/0001.json"}, false},
+		{"*/0001.dat", []string{"other/0001.dat"}, false},
+		{"shards/000[1-9]/0001.???", []string{"shards/0001/0001.dat"}, false},
+		{"shards/0000/0002.dat", []string{"shards/0000/0002.dat"}, false},
+		{"shards/0000/0003.dat", nil, false},
+		{"shards/[", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			var seen []string
+			err := WalkGlob(context.Background(), store, test.pattern, func(filename string) error {
+				seen = append(seen, filename)
+				return nil
+			})
+			if test.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, seen)
+		})
+	}
+}
+
+func TestGlobPrefix(t *testing.T) {
+	assert.Equal(t, "shards/", globPrefix("shards/*/0001*.dat"))
+	assert.Equal(t, "shards/000", globPrefix("shards/000?/file"))
+	assert.Equal(t, "", globPrefix("*.dat"))
+	assert.Equal(t, "a/b", globPrefix("a/b"))
+}
+
+func TestWalkFiltered(t *testing.T) {
+	files := []string{"0000/0001.dat", "0000/0001.json", "0000/0002.dat", "0001/0001.dat", "0001/0002.dat"}
+
+	tests := []struct {
+		name           string
+		prefix         string
+		filter         WalkFilter
+		expected       []string
+		expectedPrefix string
+	}{
+		{"unanchored regexp", "", RegexpFilter(regexp.MustCompile(`0001\.dat$`)), []string{"0000/0001.dat", "0001/0001.dat"}, ""},
+		{"anchored regexp", "", RegexpFilter(regexp.MustCompile(`^0001/.*`)), []string{"0001/0001.dat", "0001/0002.dat"}, "0001/"},
+		{"anchored regexp within prefix", "0000", RegexpFilter(regexp.MustCompile(`^0000/0001`)), []string{"0000/0001.dat", "0000/0001.json"}, "0000/0001"},
+		{"anchored regexp disjoint from prefix", "0000", RegexpFilter(regexp.MustCompile(`^0001/`)), nil, ""},
+		{"func", "0000", WalkFilterFunc(func(filename string) bool { return path.Ext(filename) == ".json" }), []string{"0000/0001.json"}, "0000"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewMockStore(nil)
+			for _, f := range files {
+				store.SetFile(f, []byte(f))
+			}
+
+			var walkedPrefix string
+			store.WalkFunc = func(ctx context.Context, prefix string, f func(filename string) error) error {
+				walkedPrefix = prefix
+				store.WalkFunc = nil
+				return store.Walk(ctx, prefix, f)
+			}
+
+			var seen []string
+			err := WalkFiltered(context.Background(), store, test.prefix, test.filter, func(filename string) error {
+				seen = append(seen, filename)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, seen)
+			assert.Equal(t, test.expectedPrefix, walkedPrefix)
+		})
+	}
+}
+
+func TestListObjectsIterator(t *testing.T) {
+	ctx := context.Background()
+	store := NewMockStore(nil)
+	for _, f := range []string{"shards/0000", "shards/0001", "shards/0002", "other/0000"} {
+		store.SetFile(f, []byte(f))
+	}
+
+	it := ListObjectsIterator(ctx, store, "shards/")
+	var seen []string
+	for {
+		attrs, err := it.Next()
+		if err == IteratorDone {
+			break
+		}
+		require.NoError(t, err)
+		seen = append(seen, attrs.Name)
+	}
+	require.NoError(t, it.Close())
+	assert.Equal(t, []string{"shards/0000", "shards/0001", "shards/0002"}, seen)
+
+	_, err := it.Next()
+	assert.Equal(t, IteratorDone, err)
+
+	it = ListObjectsIterator(ctx, store, "shards/")
+	attrs, err := it.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "shards/0000", attrs.Name)
+	require.NoError(t, it.Close())
+	_, err = it.Next()
+	assert.Equal(t, IteratorDone, err)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	it = ListObjectsIterator(canceled, store, "shards/")
+	_, err = it.Next()
+	assert.True(t, errors.Is(err, context.Canceled), "expected context.Canceled, got %v", err)
+	require.NoError(t, it.Close())
+}
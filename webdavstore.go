@@ -0,0 +1,631 @@
+//go:build !dstore_no_webdav
+
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//
+// WebDAV Store
+//
+
+func init() {
+	registerBackend(&backend{
+		schemes: []string{"webdav", "davs"},
+		newStore: func(base *url.URL, opts ...Option) (Store, error) {
+			store, err := NewWebDAVStoreWithOptions(base, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		},
+	})
+}
+
+type WebDAVStore struct {
+	baseURL  *url.URL
+	endpoint *url.URL
+	basePath string
+	client   *http.Client
+	username string
+	password string
+	*commonStore
+}
+
+func NewWebDAVStore(baseURL *url.URL, extension, compressionType string, overwrite bool) (*WebDAVStore, error) {
+	return NewWebDAVStoreWithOptions(baseURL, legacyOptions(extension, compressionType, overwrite)...)
+}
+
+// NewWebDAVStoreWithOptions creates a store on the WebDAV server of the
+// `webdav://host/path` (plain HTTP) or `davs://host/path` (HTTPS) URL.
+// Credentials are read from the URL's user info, or from the
+// `WEBDAV_USERNAME` and `WEBDAV_PASSWORD` environment variables, and sent
+// through basic authentication.
+func NewWebDAVStoreWithOptions(baseURL *url.URL, opts ...Option) (*WebDAVStore, error) {
+	return newWebDAVStore(baseURL, http.DefaultClient, newConfig(opts))
+}
+
+func newWebDAVStore(baseURL *url.URL, client *http.Client, config *config) (*WebDAVStore, error) {
+	endpoint := &url.URL{Host: baseURL.Host}
+	switch baseURL.Scheme {
+	case "webdav":
+		endpoint.Scheme = "http"
+	case "davs":
+		endpoint.Scheme = "https"
+	default:
+		return nil, fmt.Errorf("unsupported webdav scheme %q, use webdav:// or davs://", baseURL.Scheme)
+	}
+
+	username, password := os.Getenv("WEBDAV_USERNAME"), os.Getenv("WEBDAV_PASSWORD")
+	if baseURL.User != nil {
+		username = baseURL.User.Username()
+		password, _ = baseURL.User.Password()
+	}
+
+	return &WebDAVStore{
+		baseURL:     baseURL,
+		endpoint:    endpoint,
+		basePath:    path.Clean("/" + baseURL.Path),
+		client:      client,
+		username:    username,
+		password:    password,
+		commonStore: newCommonStore(baseURL, config),
+	}, nil
+}
+
+// SubStore shares the HTTP client of the parent store.
+func (s *WebDAVStore) SubStore(subFolder string) (Store, error) {
+	url, err := url.Parse(s.baseURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("webdav store parsing base url: %w", err)
+	}
+	url.Path = path.Join(url.Path, subFolder)
+	return newWebDAVStore(url, s.client, newConfig(s.options()))
+}
+
+func (s *WebDAVStore) BaseURL() *url.URL {
+	return s.baseURL
+}
+
+func (s *WebDAVStore) ObjectPath(name string) string {
+	return path.Join(s.basePath, s.pathWithExt(name))
+}
+
+func (s *WebDAVStore) ObjectURL(name string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(s.baseURL.String(), "/"), strings.TrimLeft(s.pathWithExt(name), "/"))
+}
+
+func (s *WebDAVStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return "", fmt.Errorf("webdav store presign: %w", ErrNotSupported)
+}
+
+func (s *WebDAVStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (signed string, err error) {
+	defer classifyError(&err)
+	return "", fmt.Errorf("webdav store presign: %w", ErrNotSupported)
+}
+
+func (s *WebDAVStore) toBaseName(filePath string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(filePath, s.pathWithExt("")), strings.TrimSuffix(s.basePath, "/")+"/")
+}
+
+func (s *WebDAVStore) resourceURL(resourcePath string) string {
+	u := *s.endpoint
+	u.Path = resourcePath
+	return u.String()
+}
+
+func (s *WebDAVStore) do(ctx context.Context, method, resourcePath string, headers map[string]string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.resourceURL(resourcePath), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if s.username != "" || s.password != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	return s.client.Do(req)
+}
+
+// doExpect runs the request and turns any response whose status is not one of
+// `expected` into an error, `ErrNotFound` for 404s.
+func (s *WebDAVStore) doExpect(ctx context.Context, method, resourcePath string, headers map[string]string, body io.Reader, expected ...int) (*http.Response, error) {
+	resp, err := s.do(ctx, method, resourcePath, headers, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	return nil, newStatusError(resp.StatusCode, fmt.Errorf("webdav %s %q: %s", method, resourcePath, resp.Status))
+}
+
+func (s *WebDAVStore) discard(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// mkcol creates the collection `dir` along with its missing parents, the
+// server answering 409 Conflict when a parent is missing and 405 Method Not
+// Allowed when the collection already exists.
+func (s *WebDAVStore) mkcol(ctx context.Context, dir string) error {
+	if dir == "/" || dir == "." {
+		return nil
+	}
+
+	resp, err := s.do(ctx, "MKCOL", dir+"/", nil, nil)
+	if err != nil {
+		return err
+	}
+	s.discard(resp, nil)
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusMethodNotAllowed:
+		return nil
+	case http.StatusConflict:
+		if err := s.mkcol(ctx, path.Dir(dir)); err != nil {
+			return err
+		}
+		return s.discard(s.doExpect(ctx, "MKCOL", dir+"/", nil, nil, http.StatusCreated, http.StatusMethodNotAllowed))
+	}
+	return newStatusError(resp.StatusCode, fmt.Errorf("webdav MKCOL %q: %s", dir, resp.Status))
+}
+
+func (s *WebDAVStore) WriteObject(ctx context.Context, base string, reader io.Reader, opts ...WriteOption) (err error) {
+	defer classifyError(&err)
+	destPath := s.ObjectPath(base)
+	config := newWriteConfig(opts)
+	reader = config.trackProgress(reader)
+	if config.acl != "" {
+		return fmt.Errorf("object ACL: %w", ErrNotSupported)
+	}
+
+	headers := map[string]string{}
+	if contentType, _ := s.contentHeaders(config, "", ""); contentType != "" {
+		headers["Content-Type"] = contentType
+	}
+
+	if !s.overwrite {
+		// Checked upfront to avoid uploading the whole object for nothing,
+		// the conditional PUT below still guards against concurrent writers.
+		exists, err := s.FileExists(ctx, base)
+		if err != nil {
+			return err
+		}
+		if exists {
+			// We silently ignore when we ask not to overwrite
+			return nil
+		}
+		headers["If-None-Match"] = "*"
+	}
+
+	if err := s.mkcol(ctx, path.Dir(destPath)); err != nil {
+		return fmt.Errorf("creating parent collection: %w", err)
+	}
+
+	pipeRead, pipeWrite := io.Pipe()
+	writeDone := make(chan error, 1)
+	go func() {
+		err := s.compressedCopy(ctx, reader, pipeWrite)
+		pipeWrite.CloseWithError(err)
+		writeDone <- err
+	}()
+
+	resp, err := s.do(ctx, http.MethodPut, destPath, headers, pipeRead)
+	pipeRead.Close()
+	if copyErr := <-writeDone; copyErr != nil && copyErr != io.ErrClosedPipe {
+		return copyErr
+	}
+	if err != nil {
+		return err
+	}
+	s.discard(resp, nil)
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	case http.StatusPreconditionFailed:
+		// Another writer created the object since our check
+		return nil
+	default:
+		return newStatusError(resp.StatusCode, fmt.Errorf("webdav PUT %q: %s", destPath, resp.Status))
+	}
+
+	return s.sidecarFiles(ctx).writeMetadata(destPath, config.metadata)
+}
+
+// sidecarFiles returns the primitives of the metadata sidecar files.
+func (s *WebDAVStore) sidecarFiles(ctx context.Context) sidecarFiles {
+	return sidecarFiles{
+		open: func(path string) (io.ReadCloser, error) {
+			resp, err := s.doExpect(ctx, http.MethodGet, path, nil, nil, http.StatusOK)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Body, nil
+		},
+		write: func(path string, data []byte) error {
+			return s.discard(s.doExpect(ctx, http.MethodPut, path, nil, bytes.NewReader(data), http.StatusOK, http.StatusCreated, http.StatusNoContent))
+		},
+		remove: func(path string) error {
+			return s.discard(s.doExpect(ctx, http.MethodDelete, path, nil, nil, http.StatusOK, http.StatusNoContent))
+		},
+		notFound: func(err error) bool {
+			return err == ErrNotFound
+		},
+	}
+}
+
+// transfer runs a COPY or MOVE of the object and carries its metadata over.
+func (s *WebDAVStore) transfer(ctx context.Context, method, src, dst string) error {
+	srcPath, dstPath := s.ObjectPath(src), s.ObjectPath(dst)
+
+	if err := s.mkcol(ctx, path.Dir(dstPath)); err != nil {
+		return fmt.Errorf("creating parent collection: %w", err)
+	}
+
+	metadata, err := s.sidecarFiles(ctx).readMetadata(srcPath)
+	if err != nil {
+		return fmt.Errorf("reading metadata: %w", err)
+	}
+
+	headers := map[string]string{"Destination": s.resourceURL(dstPath), "Overwrite": "T"}
+	if err := s.discard(s.doExpect(ctx, method, srcPath, headers, nil, http.StatusCreated, http.StatusNoContent)); err != nil {
+		// Some servers answer 403 Forbidden instead of 404 for a missing source
+		if exists, existsErr := s.FileExists(ctx, src); existsErr == nil && !exists {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if err := s.sidecarFiles(ctx).writeMetadata(dstPath, metadata); err != nil {
+		return err
+	}
+	if method == "MOVE" && len(metadata) != 0 {
+		return s.discard(s.doExpect(ctx, http.MethodDelete, srcPath+localMetadataSuffix, nil, nil, http.StatusOK, http.StatusNoContent))
+	}
+	return nil
+}
+
+func (s *WebDAVStore) CopyObject(ctx context.Context, src, dst string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, s, dst); skip || err != nil {
+		return err
+	}
+	return s.transfer(ctx, "COPY", src, dst)
+}
+
+func (s *WebDAVStore) RenameObject(ctx context.Context, oldName, newName string) (err error) {
+	defer classifyError(&err)
+	if skip, err := skipExistingCopy(ctx, s, newName); skip || err != nil {
+		if err != nil {
+			return err
+		}
+		return s.DeleteObject(ctx, oldName)
+	}
+	return s.transfer(ctx, "MOVE", oldName, newName)
+}
+
+func (s *WebDAVStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(name)
+
+	if debugEnabled(s.logger) {
+		s.logger.Debug("opening dstore file", zap.String("path", path))
+	}
+
+	resp, err := s.doExpect(ctx, http.MethodGet, path, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.uncompressedReader(ctx, s.resumeReads(ctx, name, resp.Body, s.openStoredRange))
+}
+
+func (s *WebDAVStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	defer classifyError(&err)
+	if s.decompressesReads() {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+	reader, err := s.openStoredRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.throttleReads(ctx, reader), nil
+}
+
+func (s *WebDAVStore) openStoredRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+
+	resp, err := s.doExpect(ctx, http.MethodGet, s.ObjectPath(name), map[string]string{"Range": byteRange}, nil, http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The offset is past the end of the object
+		resp.Body.Close()
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	case http.StatusOK:
+		// The server ignored the range and sends the whole object
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil && err != io.EOF {
+			resp.Body.Close()
+			return nil, fmt.Errorf("skipping to offset %d: %w", offset, err)
+		}
+		return limitReadCloser(resp.Body, length), nil
+	}
+	return resp.Body, nil
+}
+
+func (s *WebDAVStore) FileExists(ctx context.Context, base string) (exists bool, err error) {
+	defer classifyError(&err)
+	if err := s.discard(s.doExpect(ctx, http.MethodHead, s.ObjectPath(base), nil, nil, http.StatusOK)); err != nil {
+		if err == ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *WebDAVStore) ObjectAttributes(ctx context.Context, base string) (attrs *ObjectAttrs, err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+
+	resources, err := s.propfind(ctx, path, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(resources) == 0 || resources[0].collection {
+		return nil, ErrNotFound
+	}
+
+	return s.newObjectAttrs(ctx, base, resources[0])
+}
+
+func (s *WebDAVStore) newObjectAttrs(ctx context.Context, name string, resource *webdavResource) (*ObjectAttrs, error) {
+	metadata, err := s.sidecarFiles(ctx).readMetadata(resource.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+
+	return &ObjectAttrs{
+		Name:         name,
+		Size:         resource.size,
+		LastModified: resource.lastModified,
+		ETag:         resource.etag,
+		Metadata:     metadata,
+	}, nil
+}
+
+type webdavResource struct {
+	path         string
+	collection   bool
+	size         int64
+	lastModified time.Time
+	etag         string
+}
+
+type webdavMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength string `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+				ETag          string `xml:"DAV: getetag"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+const webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/><d:getetag/></d:prop></d:propfind>`
+
+// propfind lists the properties of `resourcePath` and, with a depth of "1",
+// of its immediate members. Paths are returned without trailing slash.
+func (s *WebDAVStore) propfind(ctx context.Context, resourcePath, depth string) ([]*webdavResource, error) {
+	headers := map[string]string{"Depth": depth, "Content-Type": "application/xml; charset=utf-8"}
+	resp, err := s.doExpect(ctx, "PROPFIND", resourcePath, headers, strings.NewReader(webdavPropfindBody), http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var multistatus webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&multistatus); err != nil {
+		return nil, fmt.Errorf("decoding webdav PROPFIND response: %w", err)
+	}
+
+	var out []*webdavResource
+	for _, response := range multistatus.Responses {
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			return nil, fmt.Errorf("invalid href %q: %w", response.Href, err)
+		}
+
+		resource := &webdavResource{path: strings.TrimSuffix(href.Path, "/")}
+		for _, propstat := range response.Propstats {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+
+			prop := propstat.Prop
+			resource.collection = prop.ResourceType.Collection != nil
+			resource.etag = prop.ETag
+			if prop.ContentLength != "" {
+				if resource.size, err = strconv.ParseInt(prop.ContentLength, 10, 64); err != nil {
+					return nil, fmt.Errorf("invalid content length %q: %w", prop.ContentLength, err)
+				}
+			}
+			if prop.LastModified != "" {
+				if resource.lastModified, err = http.ParseTime(prop.LastModified); err != nil {
+					return nil, fmt.Errorf("invalid last modified %q: %w", prop.LastModified, err)
+				}
+			}
+		}
+		out = append(out, resource)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].path < out[j].path })
+	return out, nil
+}
+
+func (s *WebDAVStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	defer classifyError(&err)
+	remove, err := pushLocalFile(ctx, s, localFile, toBaseName)
+	if err != nil {
+		return err
+	}
+	return remove()
+}
+
+func (s *WebDAVStore) ListFiles(ctx context.Context, prefix string, max int) (files []string, err error) {
+	defer classifyError(&err)
+	return listFiles(ctx, s, prefix, max)
+}
+
+func (s *WebDAVStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	defer classifyError(&err)
+	return listFilesPage(ctx, s, prefix, pageSize, pageToken)
+}
+
+func (s *WebDAVStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	defer classifyError(&err)
+	prefix = strings.Trim(prefix, "/")
+	dir := path.Join(s.basePath, prefix)
+
+	resources, err := s.propfind(ctx, dir+"/", "1")
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, resource := range resources {
+		if resource.collection && resource.path != strings.TrimSuffix(dir, "/") {
+			out = append(out, path.Join(prefix, path.Base(resource.path)))
+		}
+	}
+	return out, nil
+}
+
+func (s *WebDAVStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
+}
+
+func (s *WebDAVStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return commonWalkBetween(s, ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *WebDAVStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+func (s *WebDAVStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) (err error) {
+	defer classifyError(&err)
+	return s.walkDirectories(ctx, s.basePath, prefix, func(dir string) ([]*dirEntry, error) {
+		return s.listCollection(ctx, dir)
+	}, f)
+}
+
+// listCollection lists `dir` through a `Depth: 1` PROPFIND request, servers
+// commonly refusing `Depth: infinity`.
+func (s *WebDAVStore) listCollection(ctx context.Context, dir string) ([]*dirEntry, error) {
+	resources, err := s.propfind(ctx, dir+"/", "1")
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	entries := make([]*dirEntry, 0, len(resources))
+	for _, resource := range resources {
+		if resource.path == dir {
+			continue
+		}
+
+		resource := resource
+		entries = append(entries, &dirEntry{path: resource.path, dir: resource.collection, attrs: func(name string) (*ObjectAttrs, error) {
+			return s.newObjectAttrs(ctx, name, resource)
+		}})
+	}
+	return entries, nil
+}
+
+func (s *WebDAVStore) DeleteObject(ctx context.Context, base string) (err error) {
+	defer classifyError(&err)
+	path := s.ObjectPath(base)
+	if err := s.discard(s.doExpect(ctx, http.MethodDelete, path+localMetadataSuffix, nil, nil, http.StatusOK, http.StatusNoContent)); err != nil && err != ErrNotFound {
+		return fmt.Errorf("removing metadata: %w", err)
+	}
+	return s.discard(s.doExpect(ctx, http.MethodDelete, path, nil, nil, http.StatusOK, http.StatusNoContent))
+}
+
+func (s *WebDAVStore) DeleteObjects(ctx context.Context, names []string) (err error) {
+	defer classifyError(&err)
+	return deleteObjectsConcurrently(ctx, names, deleteObjectsConcurrency, func(ctx context.Context, name string) error {
+		if err := s.DeleteObject(ctx, name); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *WebDAVStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	defer classifyError(&err)
+	return deletePrefix(ctx, s, prefix, deletePrefixConcurrency)
+}
@@ -0,0 +1,75 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// WriteObjectBytes writes `data` as the content of the object, compressed
+// according to the store's compression.
+func WriteObjectBytes(ctx context.Context, store Store, name string, data []byte, opts ...WriteOption) error {
+	return store.WriteObject(ctx, name, bytes.NewReader(data), opts...)
+}
+
+// Digest holds the size and checksums of the uncompressed content of a written
+// object.
+type Digest struct {
+	Size int64
+	// SHA256 is hex encoded.
+	SHA256 string
+	// CRC32C uses the Castagnoli table, like Google Storage.
+	CRC32C uint32
+}
+
+// WriteObjectDigest writes the object like `WriteObject`, returning the digest
+// of the content read from `f`, computed as it's streamed to the store so that
+// producers can record it without reading the object back. When the store
+// skips the write, overwrite being disabled, the rest of `f` is read to
+// complete the digest.
+func WriteObjectDigest(ctx context.Context, store Store, name string, f io.Reader, opts ...WriteOption) (*Digest, error) {
+	sha := sha256.New()
+	crc := crc32.New(crc32cTable)
+	counter := &countingWriter{writer: io.MultiWriter(sha, crc)}
+	content := io.TeeReader(f, counter)
+
+	if err := store.WriteObject(ctx, name, content, opts...); err != nil {
+		return nil, err
+	}
+	if _, err := pooledCopy(ioutil.Discard, content); err != nil {
+		return nil, fmt.Errorf("read content: %w", err)
+	}
+
+	return &Digest{
+		Size:   counter.written,
+		SHA256: hex.EncodeToString(sha.Sum(nil)),
+		CRC32C: crc.Sum32(),
+	}, nil
+}
+
+// appendableStore is implemented by the stores able to append to objects
+// without rewriting them.
+type appendableStore interface {
+	AppendObject(ctx context.Context, name string, f io.Reader) error
+}
+
+// AppendObject appends the content read from `f` to the object `name`,
+// creating it when missing, through Google Storage compose or local appends.
+// The appended content is compressed as a stream of its own, gzip members and
+// zstd frames being read back one after the other, so lz4 stores and zstd
+// stores with `SeekableZstd` cannot append. Other stores return
+// `ErrNotSupported`.
+//
+// Appends are performed whatever the store's overwrite setting.
+func AppendObject(ctx context.Context, store Store, name string, f io.Reader) error {
+	appendable, ok := store.(appendableStore)
+	if !ok {
+		return fmt.Errorf("append to %q: %w", store.ObjectURL(name), ErrNotSupported)
+	}
+	return appendable.AppendObject(ctx, name, f)
+}
@@ -0,0 +1,132 @@
+package dstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteObjectBytes(t *testing.T) {
+	ctx := context.Background()
+	for _, compression := range []string{"", "gzip", "zstd"} {
+		t.Run(compression, func(t *testing.T) {
+			store, err := NewLocalStore(&url.URL{Scheme: "file", Path: t.TempDir()}, "", compression, false)
+			require.NoError(t, err)
+
+			require.NoError(t, WriteObjectBytes(ctx, store, "file", []byte("content")))
+
+			content, err := ReadObject(ctx, store, "file")
+			require.NoError(t, err)
+			assert.Equal(t, "content", string(content))
+		})
+	}
+}
+
+func TestAppendObject(t *testing.T) {
+	ctx := context.Background()
+	for _, compression := range []string{"", "gzip", "zstd", "xz"} {
+		t.Run(compression, func(t *testing.T) {
+			store, err := NewLocalStore(&url.URL{Scheme: "file", Path: t.TempDir()}, "", compression, false)
+			require.NoError(t, err)
+
+			require.NoError(t, AppendObject(ctx, store, "dir/file", strings.NewReader("first,")))
+			require.NoError(t, AppendObject(ctx, store, "dir/file", strings.NewReader("second,")))
+			require.NoError(t, AppendObject(ctx, store, "dir/file", strings.NewReader("third")))
+
+			content, err := ReadObject(ctx, store, "dir/file")
+			require.NoError(t, err)
+			assert.Equal(t, "first,second,third", string(content))
+
+			reader, err := store.OpenObjectRange(ctx, "dir/file", 3, 10)
+			require.NoError(t, err)
+			defer reader.Close()
+			content, err = ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, "st,second,", string(content))
+		})
+	}
+}
+
+func TestAppendObject_failure(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStore(&url.URL{Scheme: "file", Path: t.TempDir()}, "", "gzip", false)
+	require.NoError(t, err)
+
+	require.NoError(t, AppendObject(ctx, store, "file", strings.NewReader("first")))
+	err = AppendObject(ctx, store, "file", iotest.TimeoutReader(strings.NewReader(strings.Repeat("x", 100000))))
+	assert.Equal(t, iotest.ErrTimeout, err)
+
+	content, err := ReadObject(ctx, store, "file")
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(content))
+}
+
+func TestAppendObject_notSupported(t *testing.T) {
+	ctx := context.Background()
+
+	lz4Store, err := NewLocalStore(&url.URL{Scheme: "file", Path: t.TempDir()}, "", "lz4", false)
+	require.NoError(t, err)
+	seekableStore, err := NewLocalStoreWithOptions(&url.URL{Scheme: "file", Path: t.TempDir()}, Compression("zstd"), SeekableZstd(0))
+	require.NoError(t, err)
+
+	for _, store := range []Store{lz4Store, seekableStore, NewMemoryStore()} {
+		err := AppendObject(ctx, store, "file", strings.NewReader("content"))
+		assert.True(t, errors.Is(err, ErrNotSupported), store.ObjectURL("file"))
+	}
+}
+
+func TestWriteObjectDigest(t *testing.T) {
+	ctx := context.Background()
+	content := []byte(strings.Repeat("content", 1000))
+
+	chunked, err := NewChunkedStore(NewMemoryStore(), ChunkPolicy{ChunkSize: 1024})
+	require.NoError(t, err)
+
+	// The chunked store skips existing objects without reading their content
+	for _, store := range []Store{NewMemoryStore(), NewMemoryStore(Compression("zstd")), chunked} {
+		digest, err := WriteObjectDigest(ctx, store, "file", bytes.NewReader(content))
+		require.NoError(t, err)
+
+		sum := sha256.Sum256(content)
+		assert.Equal(t, &Digest{
+			Size:   int64(len(content)),
+			SHA256: hex.EncodeToString(sum[:]),
+			CRC32C: crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli)),
+		}, digest)
+
+		// The write is skipped, overwrite being disabled, the digest still
+		// covers the content
+		skipped, err := WriteObjectDigest(ctx, store, "file", bytes.NewReader(content))
+		require.NoError(t, err)
+		assert.Equal(t, digest, skipped)
+	}
+}
+
+func TestCaptureAttrs(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocalStore(&url.URL{Scheme: "file", Path: t.TempDir()}, "", "gzip", false)
+	require.NoError(t, err)
+
+	for _, store := range []Store{NewMemoryStore(Compression("gzip")), local} {
+		var attrs ObjectAttrs
+		require.NoError(t, WriteObjectBytes(ctx, store, "dir/file", []byte("content"), WithMetadata(map[string]string{"key": "value"}), CaptureAttrs(&attrs)))
+
+		stored, err := store.ObjectAttributes(ctx, "dir/file")
+		require.NoError(t, err)
+		assert.Equal(t, stored, &attrs)
+		assert.Equal(t, "dir/file", attrs.Name)
+		assert.NotEmpty(t, attrs.ETag)
+		assert.Equal(t, map[string]string{"key": "value"}, attrs.Metadata)
+	}
+}
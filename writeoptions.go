@@ -0,0 +1,133 @@
+package dstore
+
+import (
+	"strings"
+)
+
+type writeConfig struct {
+	metadata     map[string]string
+	contentType  string
+	cacheControl string
+	acl          string
+	attrs        *ObjectAttrs
+	progress     ProgressFunc
+}
+
+// WriteOption configures a single `WriteObject` call.
+type WriteOption interface {
+	applyWrite(config *writeConfig)
+}
+
+type writeOptionFunc func(config *writeConfig)
+
+func (f writeOptionFunc) applyWrite(config *writeConfig) {
+	f(config)
+}
+
+func newWriteConfig(opts []WriteOption) *writeConfig {
+	config := &writeConfig{}
+	for _, opt := range opts {
+		opt.applyWrite(config)
+	}
+	return config
+}
+
+// WithMetadata attaches user metadata to the written object, stored as object
+// metadata on cloud stores and as a sidecar file on the local store. It can be
+// read back through `Store::ObjectAttributes()`.
+//
+// Keys are case-insensitive, they are always returned lower-cased. Azure only
+// accepts keys that are valid C# identifiers, so use `_` instead of `-` if the
+// metadata is meant to be portable across stores.
+func WithMetadata(metadata map[string]string) WriteOption {
+	return writeOptionFunc(func(config *writeConfig) {
+		if config.metadata == nil {
+			config.metadata = make(map[string]string, len(metadata))
+		}
+
+		for k, v := range metadata {
+			config.metadata[strings.ToLower(k)] = v
+		}
+	})
+}
+
+// WithContentType sets the `Content-Type` of the written object, overriding the
+// store's `content_type` URL query parameter. It has no effect on the local
+// store.
+func WithContentType(contentType string) WriteOption {
+	return writeOptionFunc(func(config *writeConfig) {
+		config.contentType = contentType
+	})
+}
+
+// WithCacheControl sets the `Cache-Control` of the written object, overriding
+// the store's `cache_control` URL query parameter. It has no effect on the local
+// store.
+func WithCacheControl(cacheControl string) WriteOption {
+	return writeOptionFunc(func(config *writeConfig) {
+		config.cacheControl = cacheControl
+	})
+}
+
+// Canned ACLs that can be passed to `WithACL`, they are translated to the
+// equivalent predefined ACL on Google Storage.
+const (
+	ACLPrivate                = "private"
+	ACLPublicRead             = "public-read"
+	ACLPublicReadWrite        = "public-read-write"
+	ACLAuthenticatedRead      = "authenticated-read"
+	ACLBucketOwnerRead        = "bucket-owner-read"
+	ACLBucketOwnerFullControl = "bucket-owner-full-control"
+)
+
+// WithACL applies the canned ACL to the written object, see the `ACL...`
+// constants. Stores without object-level ACLs return `ErrNotSupported` when
+// it's used.
+func WithACL(acl string) WriteOption {
+	return writeOptionFunc(func(config *writeConfig) {
+		config.acl = acl
+	})
+}
+
+// CaptureAttrs fills `attrs` with the attributes of the written object once
+// the write succeeds, its ETag and generation included, so that callers can
+// validate caches or detect concurrent writes. The Google Storage, S3, local
+// and memory stores fill them, S3 through an extra request, `attrs` being left
+// untouched by the other stores and by writes skipped because overwrite is
+// disabled.
+func CaptureAttrs(attrs *ObjectAttrs) WriteOption {
+	return writeOptionFunc(func(config *writeConfig) {
+		config.attrs = attrs
+	})
+}
+
+// WithProgress reports the progress of the write to `progress`, as the
+// content is consumed by the store, its total being known for seekable
+// content. Wrapper stores spooling non-seekable content, like
+// `RetryingStore`, report the progress of sending the spooled copy.
+func WithProgress(progress ProgressFunc) WriteOption {
+	return writeOptionFunc(func(config *writeConfig) {
+		config.progress = progress
+	})
+}
+
+// capture fills the attributes requested through `CaptureAttrs`, if any.
+func (c *writeConfig) capture(attrs *ObjectAttrs) {
+	if c.attrs != nil {
+		*c.attrs = *attrs
+	}
+}
+
+// normalizeMetadata lower-cases the metadata keys as returned by the backend,
+// returning nil when there is no metadata at all.
+func normalizeMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	out := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		out[strings.ToLower(k)] = v
+	}
+	return out
+}
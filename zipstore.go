@@ -0,0 +1,439 @@
+package dstore
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Zip Store
+//
+
+// zipReadBlockSize is the minimum number of bytes fetched by each range read
+// of the zip object, the zip reader reading its central directory in small
+// chunks.
+const zipReadBlockSize = 64 * 1024
+
+// ZipStore is a read-only `Store` over the files of a zip object held in
+// another store, fetching the zip's central directory and then each opened
+// file through range reads, without downloading or extracting the whole
+// archive. Every modification fails with `ErrReadOnly`.
+type ZipStore struct {
+	baseURL *url.URL
+
+	// prefix is the path of the sub store in the archive, empty or ending
+	// with `/`
+	prefix  string
+	archive *zipArchive
+}
+
+// zipArchive holds the central directory of a zip object, shared by a zip
+// store and its sub stores.
+type zipArchive struct {
+	store Store
+	name  string
+
+	files map[string]*zip.File
+	// names are the names of the files of the archive, sorted
+	names []string
+
+	dataOffsetsLock sync.Mutex
+	dataOffsets     map[*zip.File]int64
+}
+
+// NewZipStore reads the central directory of the zip object `name` of
+// `store`, which must not decompress its objects as offsets are those of the
+// stored zip. Directories of the archive are not listed, only their files.
+func NewZipStore(ctx context.Context, store Store, name string) (*ZipStore, error) {
+	if compressed, ok := store.(interface{ decompressesReads() bool }); ok && compressed.decompressesReads() {
+		return nil, fmt.Errorf("zip object %q must be read from a store without compression", name)
+	}
+
+	attrs, err := store.ObjectAttributes(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL, err := url.Parse(store.ObjectURL(name))
+	if err != nil {
+		return nil, fmt.Errorf("parsing zip object url: %w", err)
+	}
+
+	reader := &zipReaderAt{ctx: ctx, store: store, name: name, size: attrs.Size}
+	zipReader, err := zip.NewReader(reader, attrs.Size)
+	if err != nil {
+		return nil, fmt.Errorf("reading zip %q central directory: %w", name, err)
+	}
+	// The local headers later read to locate the files are tiny reads that
+	// outlive the constructor's context
+	reader.ctx = context.Background()
+
+	archive := &zipArchive{
+		store:       store,
+		name:        name,
+		files:       map[string]*zip.File{},
+		dataOffsets: map[*zip.File]int64{},
+	}
+	for _, file := range zipReader.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		if _, exists := archive.files[file.Name]; !exists {
+			archive.names = append(archive.names, file.Name)
+		}
+		archive.files[file.Name] = file
+	}
+	sort.Strings(archive.names)
+
+	return &ZipStore{baseURL: baseURL, archive: archive}, nil
+}
+
+// dataOffset returns the offset of the content of `file` in the zip object,
+// read from its local header on first use.
+func (a *zipArchive) dataOffset(file *zip.File) (int64, error) {
+	a.dataOffsetsLock.Lock()
+	defer a.dataOffsetsLock.Unlock()
+
+	if offset, found := a.dataOffsets[file]; found {
+		return offset, nil
+	}
+
+	offset, err := file.DataOffset()
+	if err != nil {
+		return 0, fmt.Errorf("reading %q local header: %w", file.Name, err)
+	}
+	a.dataOffsets[file] = offset
+	return offset, nil
+}
+
+// zipReaderAt reads the zip object through range reads of at least
+// `zipReadBlockSize` bytes, keeping the last block read to serve the
+// following small reads.
+type zipReaderAt struct {
+	ctx   context.Context
+	store Store
+	name  string
+	size  int64
+
+	lock        sync.Mutex
+	blockOffset int64
+	block       []byte
+}
+
+func (r *zipReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if offset >= r.size {
+		return 0, io.EOF
+	}
+
+	end := offset + int64(len(p))
+	if offset < r.blockOffset || end > r.blockOffset+int64(len(r.block)) {
+		length := int64(len(p))
+		if length < zipReadBlockSize {
+			length = zipReadBlockSize
+		}
+		if offset+length > r.size {
+			length = r.size - offset
+		}
+
+		block, err := readObjectRange(r.ctx, r.store, r.name, offset, length)
+		if err != nil {
+			return 0, err
+		}
+		r.blockOffset, r.block = offset, block
+	}
+
+	n := copy(p, r.block[offset-r.blockOffset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func readObjectRange(ctx context.Context, store Store, name string, offset, length int64) ([]byte, error) {
+	reader, err := store.OpenObjectRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(reader, content); err != nil {
+		return nil, fmt.Errorf("read %d bytes at %d: %w", length, offset, err)
+	}
+	return content, nil
+}
+
+func (s *ZipStore) SubStore(subFolder string) (Store, error) {
+	prefix := strings.Trim(path.Join(s.prefix, subFolder), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	baseURL := *s.baseURL
+	baseURL.Path = path.Join(baseURL.Path, subFolder)
+	return &ZipStore{baseURL: &baseURL, prefix: prefix, archive: s.archive}, nil
+}
+
+func (s *ZipStore) BaseURL() *url.URL {
+	return s.baseURL
+}
+
+// ObjectPath returns the path of the file in the archive.
+func (s *ZipStore) ObjectPath(name string) string {
+	return s.prefix + name
+}
+
+func (s *ZipStore) ObjectURL(name string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(s.baseURL.String(), "/"), strings.TrimLeft(name, "/"))
+}
+
+func (s *ZipStore) file(name string) (*zip.File, error) {
+	file, found := s.archive.files[s.ObjectPath(name)]
+	if !found {
+		return nil, ErrNotFound
+	}
+	return file, nil
+}
+
+func (s *ZipStore) OpenObject(ctx context.Context, name string) (out io.ReadCloser, err error) {
+	file, err := s.file(name)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := s.archive.dataOffset(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var decompress func(io.Reader) io.ReadCloser
+	switch file.Method {
+	case zip.Store:
+		decompress = ioutil.NopCloser
+	case zip.Deflate:
+		decompress = flate.NewReader
+	default:
+		return nil, fmt.Errorf("zip compression method %d of %q: %w", file.Method, file.Name, ErrNotSupported)
+	}
+
+	reader, err := s.archive.store.OpenObjectRange(ctx, s.archive.name, offset, int64(file.CompressedSize64))
+	if err != nil {
+		return nil, err
+	}
+
+	decompressor := decompress(reader)
+	return &readCloser{
+		Reader: &zipChecksumReader{reader: decompressor, hash: crc32.NewIEEE(), file: file},
+		Closer: closerFunc(func() error {
+			decompressor.Close()
+			return reader.Close()
+		}),
+	}, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// zipChecksumReader verifies the size and CRC-32 of the decompressed content
+// of a file once read entirely.
+type zipChecksumReader struct {
+	reader io.Reader
+	hash   hash.Hash32
+	file   *zip.File
+	read   uint64
+}
+
+func (r *zipChecksumReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hash.Write(p[:n])
+	r.read += uint64(n)
+
+	if err == io.EOF {
+		if r.read != r.file.UncompressedSize64 {
+			return n, fmt.Errorf("zip file %q is %d bytes, expected %d", r.file.Name, r.read, r.file.UncompressedSize64)
+		}
+		if r.hash.Sum32() != r.file.CRC32 {
+			return n, fmt.Errorf("zip file %q checksum mismatch", r.file.Name)
+		}
+	}
+	return n, err
+}
+
+// OpenObjectRange reads the range directly from the zip object for files
+// stored without compression, and decompresses deflated files from their
+// start.
+func (s *ZipStore) OpenObjectRange(ctx context.Context, name string, offset, length int64) (out io.ReadCloser, err error) {
+	file, err := s.file(name)
+	if err != nil {
+		return nil, err
+	}
+	if file.Method != zip.Store {
+		return openDecompressedRange(ctx, s, name, offset, length)
+	}
+
+	size := int64(file.UncompressedSize64)
+	if offset >= size || length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+
+	dataOffset, err := s.archive.dataOffset(file)
+	if err != nil {
+		return nil, err
+	}
+	return s.archive.store.OpenObjectRange(ctx, s.archive.name, dataOffset+offset, length)
+}
+
+func (s *ZipStore) FileExists(ctx context.Context, base string) (bool, error) {
+	_, err := s.file(base)
+	return err == nil, nil
+}
+
+func (s *ZipStore) ObjectAttributes(ctx context.Context, base string) (*ObjectAttrs, error) {
+	file, err := s.file(base)
+	if err != nil {
+		return nil, err
+	}
+	return s.objectAttrs(base, file), nil
+}
+
+// objectAttrs uses the CRC-32 of the file as its ETag.
+func (s *ZipStore) objectAttrs(name string, file *zip.File) *ObjectAttrs {
+	return &ObjectAttrs{
+		Name:         name,
+		Size:         int64(file.UncompressedSize64),
+		LastModified: file.Modified,
+		ETag:         fmt.Sprintf("%08x", file.CRC32),
+	}
+}
+
+func (s *ZipStore) PresignGet(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (s *ZipStore) PresignPut(ctx context.Context, base string, ttl time.Duration) (string, error) {
+	return "", fmt.Errorf("presign put %q: %w", base, ErrReadOnly)
+}
+
+func (s *ZipStore) WriteObject(ctx context.Context, base string, f io.Reader, opts ...WriteOption) (err error) {
+	return fmt.Errorf("write %q: %w", base, ErrReadOnly)
+}
+
+func (s *ZipStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) (err error) {
+	return fmt.Errorf("push %q: %w", toBaseName, ErrReadOnly)
+}
+
+func (s *ZipStore) CopyObject(ctx context.Context, src, dst string) error {
+	return fmt.Errorf("copy %q to %q: %w", src, dst, ErrReadOnly)
+}
+
+func (s *ZipStore) RenameObject(ctx context.Context, oldName, newName string) error {
+	return fmt.Errorf("rename %q to %q: %w", oldName, newName, ErrReadOnly)
+}
+
+func (s *ZipStore) DeleteObject(ctx context.Context, base string) error {
+	return fmt.Errorf("delete %q: %w", base, ErrReadOnly)
+}
+
+func (s *ZipStore) DeleteObjects(ctx context.Context, names []string) error {
+	return fmt.Errorf("delete objects: %w", ErrReadOnly)
+}
+
+func (s *ZipStore) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	return 0, fmt.Errorf("delete prefix %q: %w", prefix, ErrReadOnly)
+}
+
+func (s *ZipStore) Overwrite() bool { return false }
+
+func (s *ZipStore) SetOverwrite(enabled bool) {}
+
+func (s *ZipStore) ListFiles(ctx context.Context, prefix string, max int) ([]string, error) {
+	return listFiles(ctx, s, prefix, max)
+}
+
+func (s *ZipStore) ListFilesPage(ctx context.Context, prefix string, pageSize int, pageToken string) (files []string, nextToken string, err error) {
+	return listFilesPage(ctx, s, prefix, pageSize, pageToken)
+}
+
+func (s *ZipStore) ListDirectories(ctx context.Context, prefix string) (out []string, err error) {
+	prefix = strings.Trim(prefix, "/")
+
+	keyPrefix := s.prefix
+	if prefix != "" {
+		keyPrefix += prefix + "/"
+	}
+
+	seen := map[string]bool{}
+	for _, key := range s.archive.names {
+		if !strings.HasPrefix(key, keyPrefix) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(key, keyPrefix)
+		if index := strings.Index(rest, "/"); index > 0 {
+			dir := path.Join(prefix, rest[:index])
+			if !seen[dir] {
+				seen[dir] = true
+				out = append(out, dir)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (s *ZipStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) (err error)) error {
+	return commonWalkFrom(s, ctx, prefix, startingPoint, f)
+}
+
+func (s *ZipStore) WalkBetween(ctx context.Context, prefix, startingPoint, endPoint string, f func(filename string) (err error)) error {
+	return commonWalkBetween(s, ctx, prefix, startingPoint, endPoint, f)
+}
+
+func (s *ZipStore) Walk(ctx context.Context, prefix string, f func(filename string) (err error)) error {
+	return s.WalkObjects(ctx, prefix, func(attrs *ObjectAttrs) error {
+		return f(attrs.Name)
+	})
+}
+
+// WalkObjects walks the files of the archive in lexicographical order.
+func (s *ZipStore) WalkObjects(ctx context.Context, prefix string, f func(attrs *ObjectAttrs) (err error)) error {
+	f = skipPrefixes(f)
+	keyPrefix := s.prefix + prefix
+
+	start := sort.SearchStrings(s.archive.names, keyPrefix)
+	for _, key := range s.archive.names[start:] {
+		if !strings.HasPrefix(key, keyPrefix) {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := f(s.objectAttrs(strings.TrimPrefix(key, s.prefix), s.archive.files[key])); err != nil {
+			if err == StopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
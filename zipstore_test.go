@@ -0,0 +1,135 @@
+package dstore
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestZipStore(t *testing.T, files map[string]string) (*ZipStore, *MemoryStore) {
+	t.Helper()
+
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	_, err := writer.Create("dir/")
+	require.NoError(t, err)
+	for name, content := range files {
+		method := zip.Deflate
+		if strings.HasPrefix(name, "stored/") {
+			method = zip.Store
+		}
+
+		file, err := writer.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)})
+		require.NoError(t, err)
+		_, err = file.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	ctx := context.Background()
+	outer := NewMemoryStore()
+	require.NoError(t, WriteObjectBytes(ctx, outer, "bundles/archive.zip", archive.Bytes()))
+
+	store, err := NewZipStore(ctx, outer, "bundles/archive.zip")
+	require.NoError(t, err)
+	return store, outer
+}
+
+func TestZipStore(t *testing.T) {
+	ctx := context.Background()
+	files := map[string]string{
+		"dir/a.txt":         strings.Repeat("deflated content ", 100),
+		"dir/sub/b.txt":     "b",
+		"stored/c.bin":      "0123456789",
+		"top.txt":           "top",
+		"dir/sub/deep/d.md": "d",
+	}
+	store, outer := newTestZipStore(t, files)
+
+	var walked []string
+	require.NoError(t, store.Walk(ctx, "", func(filename string) error {
+		walked = append(walked, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{"dir/a.txt", "dir/sub/b.txt", "dir/sub/deep/d.md", "stored/c.bin", "top.txt"}, walked)
+
+	for name, expected := range files {
+		content, err := ReadObject(ctx, store, name)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content), name)
+	}
+
+	for _, name := range []string{"dir/a.txt", "stored/c.bin"} {
+		reader, err := store.OpenObjectRange(ctx, name, 3, 4)
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, files[name][3:7], string(content), name)
+	}
+	reader, err := store.OpenObjectRange(ctx, "stored/c.bin", 8, -1)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "89", string(content))
+
+	attrs, err := store.ObjectAttributes(ctx, "stored/c.bin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), attrs.Size)
+	assert.Equal(t, time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC), attrs.LastModified.UTC())
+
+	exists, err := store.FileExists(ctx, "dir/a.txt")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = store.FileExists(ctx, "dir")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	_, err = store.OpenObject(ctx, "missing")
+	assert.Equal(t, ErrNotFound, err)
+
+	dirs, err := store.ListDirectories(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dir", "stored"}, dirs)
+	dirs, err = store.ListDirectories(ctx, "dir")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dir/sub"}, dirs)
+
+	sub, err := store.SubStore("dir/sub")
+	require.NoError(t, err)
+	content, err = ReadObject(ctx, sub, "b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(content))
+	files2, err := sub.ListFiles(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b.txt", "deep/d.md"}, files2)
+	assert.Equal(t, outer.ObjectURL("bundles/archive.zip")+"/dir/sub/b.txt", sub.ObjectURL("b.txt"))
+
+	assert.True(t, errors.Is(store.WriteObject(ctx, "new", strings.NewReader("")), ErrReadOnly))
+	assert.True(t, errors.Is(store.DeleteObject(ctx, "top.txt"), ErrReadOnly))
+	_, err = store.DeletePrefix(ctx, "")
+	assert.True(t, errors.Is(err, ErrReadOnly))
+}
+
+func TestZipStore_corrupted(t *testing.T) {
+	ctx := context.Background()
+	store, outer := newTestZipStore(t, map[string]string{"stored/file": "original"})
+
+	content, err := ReadObject(ctx, outer, "bundles/archive.zip")
+	require.NoError(t, err)
+	require.NoError(t, outer.DeleteObject(ctx, "bundles/archive.zip"))
+	require.NoError(t, WriteObjectBytes(ctx, outer, "bundles/archive.zip", bytes.Replace(content, []byte("original"), []byte("tampered"), 1)))
+
+	_, err = ReadObject(ctx, store, "stored/file")
+	assert.Error(t, err)
+
+	_, err = NewZipStore(ctx, NewMemoryStore(Compression("zstd")), "bundles/archive.zip")
+	assert.Error(t, err)
+}